@@ -0,0 +1,284 @@
+package channel
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// the extra delay of a lost segment on a stream, which is recovered by TCP retransmission (RFC 6298 minimum RTO is 1s, Linux uses 200ms)
+const STREAM_RETRANSMISSION_DELAY = 200 * time.Millisecond
+
+// the datagrams in flight on a link, a datagram sent while the queue is full is dropped as by a full radio buffer
+const DATAGRAM_QUEUE_SIZE = 1024
+
+// Outage is a window in which the link carries nothing, counted from the creation of the link or from the last time
+// a profile with outages is set, it repeats every Period if Period is not zero
+type Outage struct {
+	Start    time.Duration
+	Duration time.Duration
+	Period   time.Duration
+}
+
+// Profile describes the radio conditions of one direction of a link, the zero value is a perfect link
+type Profile struct {
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// probabilities in [0, 1]
+	Loss    float64
+	Reorder float64
+
+	// kbit/s, zero is unlimited
+	Bandwidth int
+
+	Outages []Outage
+}
+
+func (p Profile) Validate() error {
+	if p.Latency < 0 || p.Jitter < 0 {
+		return fmt.Errorf("latency and jitter must not be negative")
+	}
+	if p.Loss < 0 || p.Loss > 1 {
+		return fmt.Errorf("loss %v out of range [0, 1]", p.Loss)
+	}
+	if p.Reorder < 0 || p.Reorder > 1 {
+		return fmt.Errorf("reorder %v out of range [0, 1]", p.Reorder)
+	}
+	if p.Bandwidth < 0 {
+		return fmt.Errorf("bandwidth must not be negative")
+	}
+	for _, outage := range p.Outages {
+		if outage.Start < 0 || outage.Duration <= 0 || outage.Period < 0 {
+			return fmt.Errorf("invalid outage %+v", outage)
+		}
+		if outage.Period != 0 && outage.Period < outage.Duration {
+			return fmt.Errorf("outage period %v shorter than its duration %v", outage.Period, outage.Duration)
+		}
+	}
+	return nil
+}
+
+func (p Profile) IsPerfect() bool {
+	return p.Latency == 0 && p.Jitter == 0 && p.Loss == 0 && p.Reorder == 0 && p.Bandwidth == 0 && len(p.Outages) == 0
+}
+
+// outageEnd returns the end of the outage covering the elapsed time since the link creation, or false if the link is up
+func (p Profile) outageEnd(elapsed time.Duration) (time.Duration, bool) {
+	for _, outage := range p.Outages {
+		if elapsed < outage.Start {
+			continue
+		}
+		offset := elapsed - outage.Start
+		if outage.Period != 0 {
+			offset %= outage.Period
+		}
+		if offset < outage.Duration {
+			return elapsed + outage.Duration - offset, true
+		}
+	}
+	return 0, false
+}
+
+// Link emulates one direction of the radio channel of a UE
+type Link struct {
+	profile Profile
+
+	epoch time.Time
+
+	// when the transmitter is free again and when the last in-order unit is delivered
+	transmitterFree time.Time
+	lastDelivery    time.Time
+
+	// the datagrams in flight, and the stream units waiting for their delivery in order
+	pendingDatagrams int
+	streamQueue      []streamUnit
+	streamDelivering bool
+
+	random *rand.Rand
+	now    func() time.Time
+
+	mtx sync.Mutex
+}
+
+func NewLink(profile Profile) *Link {
+	now := time.Now()
+	return &Link{
+		profile: profile,
+
+		epoch: now,
+
+		transmitterFree: now,
+		lastDelivery:    now,
+
+		random: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		now:    time.Now,
+
+		mtx: sync.Mutex{},
+	}
+}
+
+func (l *Link) GetProfile() Profile {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.profile
+}
+
+// SetProfile applies the profile to the link, the outages of the profile start counting from now
+func (l *Link) SetProfile(profile Profile) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.profile = profile
+	if len(profile.Outages) > 0 {
+		l.epoch = l.now()
+	}
+}
+
+// schedule returns the delay until the unit of size bytes is delivered, or false if it is dropped,
+// a stream keeps the order of its units and recovers lost ones with a retransmission delay
+func (l *Link) schedule(size int, stream bool) (time.Duration, bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	if l.profile.IsPerfect() {
+		return 0, true
+	}
+
+	// wait for the link to come back, datagrams sent into an outage are lost
+	start := now
+	if outageEnd, down := l.profile.outageEnd(now.Sub(l.epoch)); down {
+		if !stream {
+			return 0, false
+		}
+		start = l.epoch.Add(outageEnd)
+	}
+
+	// serialization on the air interface
+	if l.profile.Bandwidth > 0 {
+		if l.transmitterFree.After(start) {
+			start = l.transmitterFree
+		}
+		start = start.Add(time.Duration(int64(size) * 8 * int64(time.Millisecond) / int64(l.profile.Bandwidth)))
+		l.transmitterFree = start
+	}
+
+	delay := l.profile.Latency
+	if l.profile.Jitter > 0 {
+		delay += time.Duration(l.random.Int64N(int64(2*l.profile.Jitter)+1)) - l.profile.Jitter
+	}
+
+	if l.profile.Loss > 0 && l.random.Float64() < l.profile.Loss {
+		if !stream {
+			return 0, false
+		}
+		delay += STREAM_RETRANSMISSION_DELAY
+	}
+
+	if !stream && l.profile.Reorder > 0 && l.random.Float64() < l.profile.Reorder {
+		delay = 0
+	}
+
+	delivery := start.Add(max(delay, 0))
+	if stream {
+		if delivery.Before(l.lastDelivery) {
+			delivery = l.lastDelivery
+		}
+		l.lastDelivery = delivery
+	}
+
+	return delivery.Sub(now), true
+}
+
+// SendDatagram delivers the packet after the emulated delay and returns false if the packet is dropped, either by the channel
+// or because DATAGRAM_QUEUE_SIZE datagrams are already in flight, the error of deliver is only returned when the packet is delivered at once
+func (l *Link) SendDatagram(packet []byte, deliver func([]byte) error) (bool, error) {
+	delay, delivered := l.schedule(len(packet), false)
+	if !delivered {
+		return false, nil
+	}
+
+	if delay <= 0 {
+		return true, deliver(packet)
+	}
+
+	l.mtx.Lock()
+	if l.pendingDatagrams >= DATAGRAM_QUEUE_SIZE {
+		l.mtx.Unlock()
+		return false, nil
+	}
+	l.pendingDatagrams++
+	l.mtx.Unlock()
+
+	time.AfterFunc(delay, func() {
+		_ = deliver(packet)
+
+		l.mtx.Lock()
+		l.pendingDatagrams--
+		l.mtx.Unlock()
+	})
+	return true, nil
+}
+
+// streamUnit is a unit of a stream waiting for its delivery time
+type streamUnit struct {
+	delivery  time.Time
+	unit      []byte
+	deliver   func([]byte) error
+	delivered chan error
+}
+
+// SendStream schedules the unit of a stream and returns at once with a channel receiving the error of deliver once the unit is delivered,
+// the units are delivered in the order of SendStream by one routine of the link, so the caller serializing the units of a stream
+// only needs to hold its lock for SendStream and waits for the delivery outside of it
+func (l *Link) SendStream(unit []byte, deliver func([]byte) error) <-chan error {
+	delay, _ := l.schedule(len(unit), true)
+	delivered := make(chan error, 1)
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.streamQueue = append(l.streamQueue, streamUnit{
+		delivery:  l.now().Add(delay),
+		unit:      unit,
+		deliver:   deliver,
+		delivered: delivered,
+	})
+	if !l.streamDelivering {
+		l.streamDelivering = true
+		go l.deliverStream()
+	}
+	return delivered
+}
+
+// deliverStream delivers the queued stream units in order at their delivery time, and stops once the queue is empty
+func (l *Link) deliverStream() {
+	for {
+		l.mtx.Lock()
+		if len(l.streamQueue) == 0 {
+			l.streamDelivering = false
+			l.mtx.Unlock()
+			return
+		}
+		unit := l.streamQueue[0]
+		l.streamQueue = l.streamQueue[1:]
+		now := l.now()
+		l.mtx.Unlock()
+
+		if wait := unit.delivery.Sub(now); wait > 0 {
+			time.Sleep(wait)
+		}
+		unit.delivered <- unit.deliver(unit.unit)
+	}
+}
+
+// WaitStream blocks for the emulated delay of a stream unit of size bytes, the caller sends or
+// handles the unit afterwards, so the units of a stream stay in order
+func (l *Link) WaitStream(size int) {
+	if delay, _ := l.schedule(size, true); delay > 0 {
+		time.Sleep(delay)
+	}
+}
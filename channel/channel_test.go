@@ -0,0 +1,222 @@
+package channel
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLink(profile Profile, now time.Time) *Link {
+	link := NewLink(profile)
+	link.epoch, link.transmitterFree, link.lastDelivery = now, now, now
+	link.now = func() time.Time { return now }
+	return link
+}
+
+var testLinkScheduleCases = []struct {
+	name          string
+	profile       Profile
+	elapsed       time.Duration
+	size          int
+	stream        bool
+	expectedDelay time.Duration
+	expectedSent  bool
+}{
+	{
+		name:          "testPerfectLink",
+		profile:       Profile{},
+		size:          1000,
+		expectedDelay: 0,
+		expectedSent:  true,
+	},
+	{
+		name:          "testLatency",
+		profile:       Profile{Latency: 20 * time.Millisecond},
+		size:          1000,
+		expectedDelay: 20 * time.Millisecond,
+		expectedSent:  true,
+	},
+	{
+		name:          "testBandwidth",
+		profile:       Profile{Bandwidth: 8},
+		size:          1,
+		expectedDelay: time.Millisecond,
+		expectedSent:  true,
+	},
+	{
+		name:          "testDatagramLost",
+		profile:       Profile{Loss: 1},
+		size:          1000,
+		expectedDelay: 0,
+		expectedSent:  false,
+	},
+	{
+		name:          "testStreamRetransmitted",
+		profile:       Profile{Loss: 1},
+		size:          1000,
+		stream:        true,
+		expectedDelay: STREAM_RETRANSMISSION_DELAY,
+		expectedSent:  true,
+	},
+	{
+		name:          "testDatagramInOutage",
+		profile:       Profile{Outages: []Outage{{Start: time.Second, Duration: time.Second}}},
+		elapsed:       1500 * time.Millisecond,
+		size:          1000,
+		expectedDelay: 0,
+		expectedSent:  false,
+	},
+	{
+		name:          "testStreamHeldByOutage",
+		profile:       Profile{Outages: []Outage{{Start: time.Second, Duration: time.Second}}},
+		elapsed:       1500 * time.Millisecond,
+		size:          1000,
+		stream:        true,
+		expectedDelay: 500 * time.Millisecond,
+		expectedSent:  true,
+	},
+	{
+		name:          "testPeriodicOutage",
+		profile:       Profile{Outages: []Outage{{Start: 0, Duration: time.Second, Period: 10 * time.Second}}},
+		elapsed:       20500 * time.Millisecond,
+		size:          1000,
+		stream:        true,
+		expectedDelay: 500 * time.Millisecond,
+		expectedSent:  true,
+	},
+	{
+		name:          "testAfterOutage",
+		profile:       Profile{Outages: []Outage{{Start: time.Second, Duration: time.Second}}},
+		elapsed:       2500 * time.Millisecond,
+		size:          1000,
+		expectedDelay: 0,
+		expectedSent:  true,
+	},
+	{
+		name:          "testReorderedDatagramSkipsLatency",
+		profile:       Profile{Latency: 20 * time.Millisecond, Reorder: 1},
+		size:          1000,
+		expectedDelay: 0,
+		expectedSent:  true,
+	},
+}
+
+func TestLinkSchedule(t *testing.T) {
+	for _, testCase := range testLinkScheduleCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			now := time.Now()
+			link := newTestLink(testCase.profile, now)
+			link.epoch = now.Add(-testCase.elapsed)
+
+			delay, sent := link.schedule(testCase.size, testCase.stream)
+			if sent != testCase.expectedSent {
+				t.Fatalf("expected sent %t, got %t", testCase.expectedSent, sent)
+			}
+			if delay != testCase.expectedDelay {
+				t.Errorf("expected delay %v, got %v", testCase.expectedDelay, delay)
+			}
+		})
+	}
+}
+
+func TestLinkScheduleKeepsStreamOrder(t *testing.T) {
+	now := time.Now()
+	link := newTestLink(Profile{Latency: 50 * time.Millisecond, Jitter: 50 * time.Millisecond}, now)
+
+	var last time.Duration
+	for range 1000 {
+		delay, _ := link.schedule(100, true)
+		if delay < last {
+			t.Fatalf("stream reordered: delay %v after %v", delay, last)
+		}
+		last = delay
+	}
+}
+
+func TestLinkScheduleQueuesBehindBandwidth(t *testing.T) {
+	now := time.Now()
+	link := newTestLink(Profile{Bandwidth: 8}, now)
+
+	for i := range 10 {
+		delay, _ := link.schedule(1, false)
+		if expected := time.Duration(i+1) * time.Millisecond; delay != expected {
+			t.Fatalf("packet %d: expected delay %v, got %v", i, expected, delay)
+		}
+	}
+}
+
+func TestLinkSetProfileStartsOutagesNow(t *testing.T) {
+	now := time.Now()
+	link := newTestLink(Profile{}, now.Add(-time.Hour))
+	link.now = func() time.Time { return now }
+
+	link.SetProfile(Profile{Outages: []Outage{{Start: time.Second, Duration: time.Second}}})
+	if _, sent := link.schedule(100, false); !sent {
+		t.Fatalf("expected datagram sent before the outage of the new profile")
+	}
+
+	link.now = func() time.Time { return now.Add(1500 * time.Millisecond) }
+	if _, sent := link.schedule(100, false); sent {
+		t.Errorf("expected datagram lost in the outage of the new profile")
+	}
+}
+
+func TestLinkDropsDatagramsOnFullQueue(t *testing.T) {
+	link := NewLink(Profile{Latency: time.Hour})
+
+	for i := range DATAGRAM_QUEUE_SIZE {
+		if sent, _ := link.SendDatagram([]byte{0x00}, func([]byte) error { return nil }); !sent {
+			t.Fatalf("datagram %d: expected queued", i)
+		}
+	}
+	if sent, _ := link.SendDatagram([]byte{0x00}, func([]byte) error { return nil }); sent {
+		t.Errorf("expected datagram dropped on full queue")
+	}
+}
+
+func TestLinkSendStreamKeepsOrder(t *testing.T) {
+	link := NewLink(Profile{Latency: 20 * time.Millisecond, Jitter: 20 * time.Millisecond})
+
+	received := make(chan byte, 100)
+	deliveries := make([]<-chan error, 0, 100)
+	start := time.Now()
+	for i := range 100 {
+		deliveries = append(deliveries, link.SendStream([]byte{byte(i)}, func(unit []byte) error {
+			received <- unit[0]
+			return nil
+		}))
+	}
+	if elapsed := time.Since(start); elapsed >= 20*time.Millisecond {
+		t.Errorf("expected SendStream not to wait for the delay, took %v", elapsed)
+	}
+
+	for i, delivered := range deliveries {
+		if err := <-delivered; err != nil {
+			t.Fatalf("unit %d: %v", i, err)
+		}
+		if unit := <-received; unit != byte(i) {
+			t.Fatalf("stream reordered: unit %d delivered as %d", unit, i)
+		}
+	}
+}
+
+var testProfileValidateCases = []struct {
+	name    string
+	profile Profile
+	valid   bool
+}{
+	{name: "testZeroProfile", profile: Profile{}, valid: true},
+	{name: "testLossOutOfRange", profile: Profile{Loss: 1.5}, valid: false},
+	{name: "testNegativeLatency", profile: Profile{Latency: -time.Millisecond}, valid: false},
+	{name: "testOutagePeriodTooShort", profile: Profile{Outages: []Outage{{Duration: time.Second, Period: time.Millisecond}}}, valid: false},
+	{name: "testOutageWithoutDuration", profile: Profile{Outages: []Outage{{Start: time.Second}}}, valid: false},
+}
+
+func TestProfileValidate(t *testing.T) {
+	for _, testCase := range testProfileValidateCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := testCase.profile.Validate(); (err == nil) != testCase.valid {
+				t.Errorf("expected valid %t, got %v", testCase.valid, err)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"syscall"
 
+	"github.com/Alonza0314/free-ran-ue/constant"
 	"github.com/Alonza0314/free-ran-ue/logger"
 	"github.com/Alonza0314/free-ran-ue/model"
 	"github.com/Alonza0314/free-ran-ue/ue"
@@ -33,11 +34,6 @@ func init() {
 }
 
 func ueFunc(cmd *cobra.Command, args []string) {
-	if os.Geteuid() != 0 {
-		loggergo.Error("UE", "This program requires root privileges to bring up tunnel device.")
-		return
-	}
-
 	ueConfigFilePath, err := cmd.Flags().GetString("config")
 	if err != nil {
 		panic(err)
@@ -52,8 +48,19 @@ func ueFunc(cmd *cobra.Command, args []string) {
 		panic(err)
 	}
 
+	// a user space data plane brings up no tunnel device
+	if os.Geteuid() != 0 && !(ueConfig.MultiUe.Enable && ueConfig.MultiUe.DataPlane == constant.MULTI_UE_DATA_PLANE_USERSPACE) {
+		loggergo.Error("UE", "This program requires root privileges to bring up tunnel device.")
+		return
+	}
+
 	logger := logger.NewUeLogger(loggergoUtil.LogLevelString(ueConfig.Logger.Level), "", true)
 
+	if ueConfig.MultiUe.Enable {
+		multiUeFunc(&ueConfig, &logger)
+		return
+	}
+
 	ue := ue.NewUe(&ueConfig, &logger)
 	if ue == nil {
 		return
@@ -76,3 +83,33 @@ func ueFunc(cmd *cobra.Command, args []string) {
 	cancel()
 	wg.Wait()
 }
+
+func multiUeFunc(ueConfig *model.UeConfig, logger *logger.UeLogger) {
+	multiUe := ue.NewMultiUe(ueConfig, logger)
+	if multiUe == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wg := sync.WaitGroup{}
+
+	// an interrupt during the attach stops the attach of the remaining UEs
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	err := multiUe.Start(ctx, &wg)
+	multiUe.PrintSummary()
+	if err != nil {
+		return
+	}
+	defer multiUe.Stop()
+
+	<-ctx.Done()
+	wg.Wait()
+}
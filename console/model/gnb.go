@@ -30,10 +30,20 @@ type SnssaiIE struct {
 type RanUeInfo struct {
 	Imsi          string `json:"imsi"`
 	NrdcIndicator bool   `json:"nrdcIndicator"`
+	RrcState      string `json:"rrcState"`
+	NrCellId      string `json:"nrCellId"`
+
+	PduSessionIdList []int `json:"pduSessionIdList"`
+
+	DlBuffered      int    `json:"dlBuffered"`
+	DlBufferDropped uint64 `json:"dlBufferDropped"`
 }
 
 type XnUeInfo struct {
 	Imsi string `json:"imsi"`
+
+	DlBuffered      int    `json:"dlBuffered"`
+	DlBufferDropped uint64 `json:"dlBufferDropped"`
 }
 
 type ConsoleGnbUeNrdcModifyRequest struct {
@@ -45,3 +55,80 @@ type ConsoleGnbUeNrdcModifyRequest struct {
 type ConsoleGnbUeNrdcModifyResponse struct {
 	Message string `json:"message"`
 }
+
+type GnbRadioChannelProfile struct {
+	LatencyMs int64 `json:"latencyMs"`
+	JitterMs  int64 `json:"jitterMs"`
+
+	Loss    float64 `json:"loss"`
+	Reorder float64 `json:"reorder"`
+
+	BandwidthKbps int `json:"bandwidthKbps"`
+
+	Outages []GnbRadioChannelOutage `json:"outages"`
+}
+
+type GnbRadioChannelOutage struct {
+	StartMs    int64 `json:"startMs"`
+	DurationMs int64 `json:"durationMs"`
+	PeriodMs   int64 `json:"periodMs"`
+}
+
+type GnbRadioChannelResponse struct {
+	Message string                            `json:"message"`
+	Cell    GnbRadioChannelProfile            `json:"cell"`
+	Ue      map[string]GnbRadioChannelProfile `json:"ue"`
+}
+
+// an empty imsi modifies the cell profile, reset removes the override of the UE
+type GnbRadioChannelModifyRequest struct {
+	Imsi    string                 `json:"imsi"`
+	Reset   bool                   `json:"reset"`
+	Profile GnbRadioChannelProfile `json:"profile"`
+}
+
+type GnbRadioChannelModifyResponse struct {
+	Message string `json:"message"`
+}
+
+type GnbCell struct {
+	NrCellId string     `json:"nrCellId"`
+	Pci      int        `json:"pci"`
+	Tac      string     `json:"tac"`
+	Snssai   []SnssaiIE `json:"snssai"`
+
+	RanUeCount int `json:"ranUeCount"`
+}
+
+type GnbCellResponse struct {
+	Message string    `json:"message"`
+	Cells   []GnbCell `json:"cells"`
+}
+
+// moves a connected UE to another cell of the gNB with RRC reconfiguration with sync
+type GnbUeCellModifyRequest struct {
+	Imsi     string `json:"imsi"`
+	NrCellId string `json:"nrCellId"`
+}
+
+type GnbUeCellModifyResponse struct {
+	Message string `json:"message"`
+}
+
+// suspends a connected UE to RRC_INACTIVE with RRC release, the UE resumes on paging or on its own uplink
+type GnbUeRrcSuspendRequest struct {
+	Imsi string `json:"imsi"`
+}
+
+type GnbUeRrcSuspendResponse struct {
+	Message string `json:"message"`
+}
+
+// asks AMF to release the context of a connected UE, which leaves the UE registered in RRC_IDLE
+type GnbUeReleaseRequest struct {
+	Imsi string `json:"imsi"`
+}
+
+type GnbUeReleaseResponse struct {
+	Message string `json:"message"`
+}
@@ -0,0 +1,111 @@
+package model
+
+// the rejected S-NSSAI of the last registration accept with its cause of TS 24.501 9.11.3.46
+type UeRejectedSnssai struct {
+	Sst   string `json:"sst"`
+	Sd    string `json:"sd"`
+	Cause string `json:"cause"`
+}
+
+type UeRegistration struct {
+	Imsi       string `json:"imsi"`
+	Registered bool   `json:"registered"`
+	RrcState   string `json:"rrcState"`
+	NrCellId   string `json:"nrCellId"`
+	Tac        string `json:"tac"`
+	Guti5G     string `json:"guti5G"`
+
+	AllowedNssai    []SnssaiIE         `json:"allowedNssai"`
+	ConfiguredNssai []SnssaiIE         `json:"configuredNssai"`
+	RejectedNssai   []UeRejectedSnssai `json:"rejectedNssai"`
+
+	RegistrationAttempts int    `json:"registrationAttempts"`
+	NasStatus            string `json:"nasStatus"`
+}
+
+type UeRegistrationResponse struct {
+	Message      string         `json:"message"`
+	Registration UeRegistration `json:"registration"`
+}
+
+type UeDeregistrationResponse struct {
+	Message string `json:"message"`
+}
+
+type UeServiceRequestResponse struct {
+	Message      string         `json:"message"`
+	Registration UeRegistration `json:"registration"`
+}
+
+// the qos rules of an IP PDU session are the packet filters of its flows, the UE IPs of an IPv6 session take the
+// link local address until the prefix is advertised
+type UePduSession struct {
+	PduSessionId int    `json:"pduSessionId"`
+	Type         string `json:"type"`
+	Dnn          string `json:"dnn"`
+	OnDemand     bool   `json:"onDemand"`
+
+	Established  bool      `json:"established"`
+	Snssai       *SnssaiIE `json:"snssai"`
+	UeIps        []string  `json:"ueIps"`
+	QosRules     []string  `json:"qosRules"`
+	TunnelDevice string    `json:"tunnelDevice"`
+	NrdcSplit    bool      `json:"nrdcSplit"`
+}
+
+type UePduSessionResponse struct {
+	Message     string         `json:"message"`
+	PduSessions []UePduSession `json:"pduSessions"`
+}
+
+type UePduSessionModifyRequest struct {
+	PduSessionId int `json:"pduSessionId"`
+}
+
+type UePduSessionModifyResponse struct {
+	Message string `json:"message"`
+}
+
+// scgActive tells the secondary cell group is added, and the uplink leg is auto, mcg or scg
+type UeNrdc struct {
+	Enable              bool     `json:"enable"`
+	ScgActive           bool     `json:"scgActive"`
+	PrimaryPduSessionId int      `json:"primaryPduSessionId"`
+	SpecifiedFlows      []string `json:"specifiedFlows"`
+	UplinkLeg           string   `json:"uplinkLeg"`
+}
+
+type UeNrdcResponse struct {
+	Message string `json:"message"`
+	Nrdc    UeNrdc `json:"nrdc"`
+}
+
+type UeNrdcUplinkLegModifyRequest struct {
+	UplinkLeg string `json:"uplinkLeg"`
+}
+
+type UeNrdcUplinkLegModifyResponse struct {
+	Message string `json:"message"`
+}
+
+// the data plane packets of the UE since start, the uplink packets of a session kept in user space are the echo requests
+// of its traffic and its downlink packets are dropped
+type UeCounters struct {
+	UlPackets    uint64 `json:"ulPackets"`
+	UlBytes      uint64 `json:"ulBytes"`
+	ScgUlPackets uint64 `json:"scgUlPackets"`
+	DlPackets    uint64 `json:"dlPackets"`
+	DlBytes      uint64 `json:"dlBytes"`
+
+	UserspaceUlPackets uint64 `json:"userspaceUlPackets"`
+	UserspaceDlPackets uint64 `json:"userspaceDlPackets"`
+}
+
+type UeCountersResponse struct {
+	Message  string     `json:"message"`
+	Counters UeCounters `json:"counters"`
+}
+
+type MultiUeNotFoundResponse struct {
+	Message string `json:"message"`
+}
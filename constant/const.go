@@ -1,26 +1,111 @@
 package constant
 
-import "net/http"
-
-type UeType string
+import (
+	"net/http"
+	"time"
+)
 
 // for RAN
 const (
 	NGAP_PPID uint32 = 0x3c000000
 
-	UE_TYPE_RAN UeType = "ran"
-	UE_TYPE_XN  UeType = "xn"
+	// NGAP PDUs from AMF waiting for the procedure of their UE, and the time an NGAP procedure of the gNB waits for
+	// the answer of AMF
+	N2_MESSAGE_QUEUE_SIZE  = 16
+	NGAP_PROCEDURE_TIMEOUT = 5 * time.Second
 )
 
 // for UE
 const (
-	PDU_SESSION_ID = 4
+	// PDU session identity values 1 to 15 as in TS 24.007 11.2.3.1b
+	PDU_SESSION_ID_MIN = 1
+	PDU_SESSION_ID_MAX = 15
+
+	// PDU session types of the ue config, a PDU session without type is IPv4
+	PDU_SESSION_TYPE_IPV4         = "IPv4"
+	PDU_SESSION_TYPE_IPV6         = "IPv6"
+	PDU_SESSION_TYPE_IPV4V6       = "IPv4v6"
+	PDU_SESSION_TYPE_ETHERNET     = "Ethernet"
+	PDU_SESSION_TYPE_UNSTRUCTURED = "Unstructured"
+
+	// SUCI protection schemes of the ue config as in TS 33.501 Annex C, a UE without protection scheme sends a null scheme SUCI
+	SUCI_PROTECTION_SCHEME_NULL      = "null"
+	SUCI_PROTECTION_SCHEME_PROFILE_A = "profileA"
+	SUCI_PROTECTION_SCHEME_PROFILE_B = "profileB"
+
+	// the SQN of an authentication challenge may run this far ahead of the UE as the limit Δ of TS 33.102 C.2.2,
+	// and the UE gives up the registration after this many consecutive authentication failures as in TS 24.501 5.4.1.3.7
+	UE_SQN_FRESHNESS_WINDOW        = 1 << 28
+	UE_MAX_AUTHENTICATION_FAILURES = 3
+
+	// an IPv6 PDU session solicits the router advertisement of its prefix as a host does in RFC 4861 6.3.7
+	UE_IPV6_ROUTER_SOLICITATION_INTERVAL = 4 * time.Second
+	UE_IPV6_MAX_ROUTER_SOLICITATIONS     = 3
+
+	// a registration waits this long for the accept as T3510, and a registration accept without T3512
+	// takes its default value of TS 24.501 10.2
+	UE_T3510         = 15 * time.Second
+	UE_DEFAULT_T3512 = 54 * time.Minute
+
+	// the default NAS timers of TS 24.501 10.2, a failed registration is attempted again after T3511 up to the attempt counter
+	// of TS 24.501 5.5.1.2.7, a request of the deregistration or a pdu session procedure is retransmitted on the expiry
+	// of its timer up to the retransmissions, and a service request is aborted on the expiry of T3517
+	UE_T3511                        = 10 * time.Second
+	UE_T3517                        = 15 * time.Second
+	UE_T3521                        = 15 * time.Second
+	UE_T3580                        = 16 * time.Second
+	UE_T3581                        = 16 * time.Second
+	UE_T3582                        = 16 * time.Second
+	UE_MAX_REGISTRATION_ATTEMPTS    = 5
+	UE_MAX_NAS_RETRANSMISSIONS      = 4
+	UE_DEFAULT_MAX_NAS_BACKOFF_TIME = 5 * time.Minute
+
+	// every UE of multi UE mode uses the key of the ue section, or the key incremented by the index of the UE
+	MULTI_UE_KEY_DERIVATION_SHARED    = "shared"
+	MULTI_UE_KEY_DERIVATION_INCREMENT = "increment"
+
+	// every UE of multi UE mode brings up its own TUN device, or keeps its data plane in user space without one
+	MULTI_UE_DATA_PLANE_TUN       = "tun"
+	MULTI_UE_DATA_PLANE_USERSPACE = "userspace"
+
+	MULTI_UE_DEFAULT_CONCURRENCY = 16
+
+	// the uplink traffic of the userspace data plane sends an echo request per PDU session each interval
+	MULTI_UE_DEFAULT_TRAFFIC_INTERVAL = time.Second
+
+	// the NR-DC uplink of the primary PDU session sends the specified flows to the secondary cell group,
+	// or is forced to the master or secondary cell group by the UE API
+	UE_NRDC_UPLINK_LEG_AUTO = "auto"
+	UE_NRDC_UPLINK_LEG_MCG  = "mcg"
+	UE_NRDC_UPLINK_LEG_SCG  = "scg"
 )
 
 // between RAN and UE
 const (
-	UE_DATA_PLANE_INITIAL_PACKET = "initial packet"
-	UE_TUNNEL_UPDATE             = "tunnel update"
+	// the first octet of data plane registration is not a valid IP version
+	UE_DATA_PLANE_REGISTRATION uint8 = 0x01
+	UE_DATA_PLANE_TOKEN        uint8 = 0x02
+	// a user plane packet of a PDU session protected by AS security on the DRB
+	UE_DATA_PLANE_PDU uint8 = 0x03
+
+	UE_DATA_PLANE_TOKEN_LENGTH          = 16
+	UE_DATA_PLANE_REGISTRATION_INTERVAL = 5 * time.Second
+)
+
+// for RRC
+const (
+	RRC_PROCEDURE_TIMEOUT           = 5 * time.Second
+	RRC_MEASUREMENT_REPORT_INTERVAL = 2 * time.Second
+
+	// simulated radio, the UE reports these for every cell it is configured with
+	RRC_NOMINAL_RSRP int16 = -80
+	RRC_NOMINAL_RSRQ int16 = -10
+
+	// the secondary cell must be reported at least this strong before SCG addition
+	RRC_SCG_ADDITION_RSRP_THRESHOLD int16 = -110
+
+	// physical cell id range as in TS 38.331
+	RRC_PCI_MAX = 1007
 )
 
 // for logger
@@ -61,6 +146,12 @@ const (
 
 	NEXT_EXTENSION_HEADER_TYPE_PDU_SESSION_CONTAINER        = 0x85
 	NEXT_EXTENSION_HEADER_TYPE_PDU_SESSION_CONTAINER_LENGTH = 2
+
+	TEID_ALLOCATION_SEQUENTIAL = "sequential"
+	TEID_ALLOCATION_RANDOM     = "random"
+
+	DL_BUFFER_DEFAULT_SIZE    = 256
+	DL_BUFFER_DEFAULT_MAX_AGE = 2 * time.Second
 )
 
 // API_PREFIX defines API path prefixes for gin
@@ -80,6 +171,56 @@ const (
 
 	API_GNB_UE_NRDC        = "/ue/nrdc"
 	API_GNB_UE_NRDC_METHOD = http.MethodPost
+
+	API_GNB_RADIO_CHANNEL               = "/radio-channel"
+	API_GNB_RADIO_CHANNEL_GET_METHOD    = http.MethodGet
+	API_GNB_RADIO_CHANNEL_MODIFY_METHOD = http.MethodPost
+
+	API_GNB_CELL            = "/cell"
+	API_GNB_CELL_GET_METHOD = http.MethodGet
+
+	API_GNB_UE_CELL        = "/ue/cell"
+	API_GNB_UE_CELL_METHOD = http.MethodPost
+
+	API_GNB_UE_RRC_SUSPEND        = "/ue/rrc-suspend"
+	API_GNB_UE_RRC_SUSPEND_METHOD = http.MethodPost
+
+	API_GNB_UE_RELEASE        = "/ue/release"
+	API_GNB_UE_RELEASE_METHOD = http.MethodPost
+)
+
+// for UE
+const (
+	// the path parameter of the UE in multi UE mode, e.g. /api/ue/imsi-208930000000001/registration
+	API_UE_IMSI_PARAM = "imsi"
+
+	API_UE_REGISTRATION                 = "/registration"
+	API_UE_REGISTRATION_GET_METHOD      = http.MethodGet
+	API_UE_REGISTRATION_REGISTER_METHOD = http.MethodPost
+
+	API_UE_DEREGISTRATION        = "/deregistration"
+	API_UE_DEREGISTRATION_METHOD = http.MethodPost
+
+	API_UE_SERVICE_REQUEST        = "/service-request"
+	API_UE_SERVICE_REQUEST_METHOD = http.MethodPost
+
+	API_UE_PDU_SESSION            = "/pdu-session"
+	API_UE_PDU_SESSION_GET_METHOD = http.MethodGet
+
+	API_UE_PDU_SESSION_ESTABLISH        = "/pdu-session/establish"
+	API_UE_PDU_SESSION_ESTABLISH_METHOD = http.MethodPost
+
+	API_UE_PDU_SESSION_RELEASE        = "/pdu-session/release"
+	API_UE_PDU_SESSION_RELEASE_METHOD = http.MethodPost
+
+	API_UE_NRDC            = "/nrdc"
+	API_UE_NRDC_GET_METHOD = http.MethodGet
+
+	API_UE_NRDC_UPLINK_LEG        = "/nrdc/uplink-leg"
+	API_UE_NRDC_UPLINK_LEG_METHOD = http.MethodPost
+
+	API_UE_COUNTERS            = "/counters"
+	API_UE_COUNTERS_GET_METHOD = http.MethodGet
 )
 
 // for console
@@ -91,4 +232,20 @@ const (
 
 	API_REQUEST_GNB_UE_NRDC        = API_PREFIX_GNB + API_GNB_UE_NRDC
 	API_REQUEST_GNB_UE_NRDC_METHOD = API_GNB_UE_NRDC_METHOD
+
+	API_REQUEST_GNB_RADIO_CHANNEL               = API_PREFIX_GNB + API_GNB_RADIO_CHANNEL
+	API_REQUEST_GNB_RADIO_CHANNEL_GET_METHOD    = API_GNB_RADIO_CHANNEL_GET_METHOD
+	API_REQUEST_GNB_RADIO_CHANNEL_MODIFY_METHOD = API_GNB_RADIO_CHANNEL_MODIFY_METHOD
+
+	API_REQUEST_GNB_CELL            = API_PREFIX_GNB + API_GNB_CELL
+	API_REQUEST_GNB_CELL_GET_METHOD = API_GNB_CELL_GET_METHOD
+
+	API_REQUEST_GNB_UE_CELL        = API_PREFIX_GNB + API_GNB_UE_CELL
+	API_REQUEST_GNB_UE_CELL_METHOD = API_GNB_UE_CELL_METHOD
+
+	API_REQUEST_GNB_UE_RRC_SUSPEND        = API_PREFIX_GNB + API_GNB_UE_RRC_SUSPEND
+	API_REQUEST_GNB_UE_RRC_SUSPEND_METHOD = API_GNB_UE_RRC_SUSPEND_METHOD
+
+	API_REQUEST_GNB_UE_RELEASE        = API_PREFIX_GNB + API_GNB_UE_RELEASE
+	API_REQUEST_GNB_UE_RELEASE_METHOD = API_GNB_UE_RELEASE_METHOD
 )
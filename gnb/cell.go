@@ -0,0 +1,183 @@
+package gnb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/Alonza0314/free-ran-ue/model"
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/aper"
+	"github.com/free5gc/ngap/ngapType"
+	"github.com/free5gc/openapi/models"
+)
+
+// the cell of a gNB configured without cells, it is the nr cell identity reported before cells were configurable
+const (
+	defaultNrCellIdentity uint64 = 0x000000001
+	defaultPci                   = 1
+)
+
+// cell is a cell served by the gNB, a UE camps on the cell of the listener it connects to,
+// or on the cell it selects in RRCSetupRequest
+type cell struct {
+	nrCellIdentity uint64
+	pci            int
+
+	nrCgi  ngapType.NRCGI
+	tai    ngapType.TAI
+	snssai []ngapType.SNSSAI
+
+	// empty if the cell shares the listeners of the gNB
+	ranControlPlaneIp   string
+	ranControlPlanePort int
+	ranDataPlaneIp      string
+	ranDataPlanePort    int
+
+	ranControlPlaneListener *net.Listener
+	ranDataPlaneServer      *net.UDPConn
+}
+
+// newCells builds the cells of the gNB, a gNB without cells serves a single cell with its tai and snssai
+func newCells(cellsIe []model.CellIE, plmnId ngapType.PLMNIdentity, tai ngapType.TAI, snssai ngapType.SNSSAI) ([]*cell, error) {
+	if len(cellsIe) == 0 {
+		return []*cell{newCell(defaultNrCellIdentity, defaultPci, plmnId, tai, []ngapType.SNSSAI{snssai})}, nil
+	}
+
+	cells := make([]*cell, 0, len(cellsIe))
+	for _, cellIe := range cellsIe {
+		nrCellIdentity, err := util.NrCellIdToUint64(cellIe.NrCellId)
+		if err != nil {
+			return nil, err
+		}
+
+		cellTai := tai
+		if cellIe.Tac != "" {
+			tac, err := hex.DecodeString(cellIe.Tac)
+			if err != nil {
+				return nil, fmt.Errorf("error decode tac of cell %s: %v", cellIe.NrCellId, err)
+			}
+			cellTai = ngapType.TAI{PLMNIdentity: tai.PLMNIdentity, TAC: ngapType.TAC{Value: tac}}
+		}
+
+		cellSnssai := []ngapType.SNSSAI{snssai}
+		if len(cellIe.Snssai) > 0 {
+			cellSnssai = make([]ngapType.SNSSAI, 0, len(cellIe.Snssai))
+			for _, snssaiIe := range cellIe.Snssai {
+				sst, err := strconv.Atoi(snssaiIe.Sst)
+				if err != nil {
+					return nil, fmt.Errorf("error convert sst of cell %s: %v", cellIe.NrCellId, err)
+				}
+				ngapSnssai, err := util.SNssaiToNgap(models.Snssai{Sst: int32(sst), Sd: snssaiIe.Sd})
+				if err != nil {
+					return nil, fmt.Errorf("error convert snssai of cell %s: %v", cellIe.NrCellId, err)
+				}
+				cellSnssai = append(cellSnssai, ngapSnssai)
+			}
+		}
+
+		c := newCell(nrCellIdentity, cellIe.Pci, plmnId, cellTai, cellSnssai)
+		c.ranControlPlaneIp, c.ranControlPlanePort = cellIe.RanControlPlaneIp, cellIe.RanControlPlanePort
+		c.ranDataPlaneIp, c.ranDataPlanePort = cellIe.RanDataPlaneIp, cellIe.RanDataPlanePort
+		cells = append(cells, c)
+	}
+	return cells, nil
+}
+
+func newCell(nrCellIdentity uint64, pci int, plmnId ngapType.PLMNIdentity, tai ngapType.TAI, snssai []ngapType.SNSSAI) *cell {
+	return &cell{
+		nrCellIdentity: nrCellIdentity,
+		pci:            pci,
+
+		nrCgi: ngapType.NRCGI{
+			PLMNIdentity:   plmnId,
+			NRCellIdentity: util.NrCellIdentityToNgap(nrCellIdentity),
+		},
+		tai:    tai,
+		snssai: snssai,
+	}
+}
+
+func (c *cell) String() string {
+	return fmt.Sprintf("%09x", c.nrCellIdentity)
+}
+
+// getTac returns the 3 bytes tac as carried in RRC
+func (c *cell) getTac() uint32 {
+	tac := uint32(0)
+	for _, b := range c.tai.TAC.Value {
+		tac = tac<<8 | uint32(b)
+	}
+	return tac
+}
+
+// getSupportedTaList groups the cells by tac for NG setup, the slices of a tracking area are the union of its cells
+func getSupportedTaList(cells []*cell) ngapType.SupportedTAList {
+	supportedTaList := ngapType.SupportedTAList{}
+
+	for _, c := range cells {
+		var supportedTaItem *ngapType.SupportedTAItem
+		for i := range supportedTaList.List {
+			if bytes.Equal(supportedTaList.List[i].TAC.Value, c.tai.TAC.Value) {
+				supportedTaItem = &supportedTaList.List[i]
+				break
+			}
+		}
+		if supportedTaItem == nil {
+			supportedTaList.List = append(supportedTaList.List, ngapType.SupportedTAItem{
+				TAC: ngapType.TAC{Value: aper.OctetString(c.tai.TAC.Value)},
+				BroadcastPLMNList: ngapType.BroadcastPLMNList{
+					List: []ngapType.BroadcastPLMNItem{
+						{PLMNIdentity: ngapType.PLMNIdentity{Value: c.tai.PLMNIdentity.Value}},
+					},
+				},
+			})
+			supportedTaItem = &supportedTaList.List[len(supportedTaList.List)-1]
+		}
+
+		sliceSupportList := &supportedTaItem.BroadcastPLMNList.List[0].TAISliceSupportList
+		for _, snssai := range c.snssai {
+			if !containsSnssai(sliceSupportList, snssai) {
+				sliceSupportList.List = append(sliceSupportList.List, newSliceSupportItem(snssai))
+			}
+		}
+	}
+
+	return supportedTaList
+}
+
+func newSliceSupportItem(snssai ngapType.SNSSAI) ngapType.SliceSupportItem {
+	sliceSupportItem := ngapType.SliceSupportItem{}
+	sliceSupportItem.SNSSAI.SST.Value = aper.OctetString(snssai.SST.Value)
+	if snssai.SD != nil {
+		sliceSupportItem.SNSSAI.SD = &ngapType.SD{Value: aper.OctetString(snssai.SD.Value)}
+	}
+	return sliceSupportItem
+}
+
+func containsSnssai(sliceSupportList *ngapType.SliceSupportList, snssai ngapType.SNSSAI) bool {
+	for _, sliceSupportItem := range sliceSupportList.List {
+		if !bytes.Equal(sliceSupportItem.SNSSAI.SST.Value, snssai.SST.Value) {
+			continue
+		}
+		if (sliceSupportItem.SNSSAI.SD == nil) != (snssai.SD == nil) {
+			continue
+		}
+		if snssai.SD == nil || bytes.Equal(sliceSupportItem.SNSSAI.SD.Value, snssai.SD.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// getCell returns the cell of the nr cell identity
+func (g *Gnb) getCell(nrCellIdentity uint64) (*cell, bool) {
+	for _, c := range g.cells {
+		if c.nrCellIdentity == nrCellIdentity {
+			return c, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,83 @@
+package gnb
+
+import (
+	"testing"
+
+	"github.com/free5gc/aper"
+	"github.com/free5gc/ngap/ngapType"
+)
+
+var (
+	testCellPlmnId = ngapType.PLMNIdentity{Value: aper.OctetString("\x02\xF8\x39")}
+
+	testCellSnssai1 = ngapType.SNSSAI{
+		SST: ngapType.SST{Value: aper.OctetString("\x01")},
+		SD:  &ngapType.SD{Value: aper.OctetString("\x01\x02\x03")},
+	}
+	testCellSnssai2 = ngapType.SNSSAI{
+		SST: ngapType.SST{Value: aper.OctetString("\x01")},
+	}
+)
+
+func testCellTai(tac string) ngapType.TAI {
+	return ngapType.TAI{
+		PLMNIdentity: testCellPlmnId,
+		TAC:          ngapType.TAC{Value: aper.OctetString(tac)},
+	}
+}
+
+var testGetSupportedTaListCases = []struct {
+	name                string
+	cells               []*cell
+	expectedTacs        []string
+	expectedSliceCounts []int
+}{
+	{
+		name: "testSingleCell",
+		cells: []*cell{
+			newCell(0x000000001, 1, testCellPlmnId, testCellTai("\x00\x00\x01"), []ngapType.SNSSAI{testCellSnssai1}),
+		},
+		expectedTacs:        []string{"\x00\x00\x01"},
+		expectedSliceCounts: []int{1},
+	},
+	{
+		name: "testCellsInSameTrackingArea",
+		cells: []*cell{
+			newCell(0x000000001, 1, testCellPlmnId, testCellTai("\x00\x00\x01"), []ngapType.SNSSAI{testCellSnssai1}),
+			newCell(0x000000002, 2, testCellPlmnId, testCellTai("\x00\x00\x01"), []ngapType.SNSSAI{testCellSnssai1, testCellSnssai2}),
+		},
+		expectedTacs:        []string{"\x00\x00\x01"},
+		expectedSliceCounts: []int{2},
+	},
+	{
+		name: "testCellsInDifferentTrackingAreas",
+		cells: []*cell{
+			newCell(0x000000001, 1, testCellPlmnId, testCellTai("\x00\x00\x01"), []ngapType.SNSSAI{testCellSnssai1}),
+			newCell(0x000000002, 2, testCellPlmnId, testCellTai("\x00\x00\x02"), []ngapType.SNSSAI{testCellSnssai2}),
+			newCell(0x000000003, 3, testCellPlmnId, testCellTai("\x00\x00\x01"), []ngapType.SNSSAI{testCellSnssai1}),
+		},
+		expectedTacs:        []string{"\x00\x00\x01", "\x00\x00\x02"},
+		expectedSliceCounts: []int{1, 1},
+	},
+}
+
+func TestGetSupportedTaList(t *testing.T) {
+	for _, testCase := range testGetSupportedTaListCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			supportedTaList := getSupportedTaList(testCase.cells)
+			if len(supportedTaList.List) != len(testCase.expectedTacs) {
+				t.Fatalf("expected %d tracking areas, got %d", len(testCase.expectedTacs), len(supportedTaList.List))
+			}
+
+			for i, supportedTaItem := range supportedTaList.List {
+				if string(supportedTaItem.TAC.Value) != testCase.expectedTacs[i] {
+					t.Fatalf("tracking area %d: expected tac %x, got %x", i, testCase.expectedTacs[i], supportedTaItem.TAC.Value)
+				}
+				sliceSupportList := supportedTaItem.BroadcastPLMNList.List[0].TAISliceSupportList.List
+				if len(sliceSupportList) != testCase.expectedSliceCounts[i] {
+					t.Fatalf("tracking area %d: expected %d slices, got %d", i, testCase.expectedSliceCounts[i], len(sliceSupportList))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package gnb
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/channel"
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/Alonza0314/free-ran-ue/util"
+)
+
+var errDlBufferFull = errors.New("downlink buffer full")
+
+type dlBufferedPacket struct {
+	pduSessionId uint8
+	payload      []byte
+	bufferedAt   time.Time
+}
+
+// dlBuffer holds the downlink packets of a UE whose data plane address is not known yet,
+// packets beyond maxSize or older than maxAge are dropped and counted
+type dlBuffer struct {
+	packets []dlBufferedPacket
+
+	maxSize int
+	maxAge  time.Duration
+
+	dropped uint64
+}
+
+func newDlBuffer(maxSize int, maxAge time.Duration) *dlBuffer {
+	return &dlBuffer{
+		packets: make([]dlBufferedPacket, 0),
+
+		maxSize: maxSize,
+		maxAge:  maxAge,
+
+		dropped: 0,
+	}
+}
+
+// expire drops the packets which have been buffered longer than maxAge
+func (b *dlBuffer) expire(now time.Time) {
+	expired := 0
+	for expired < len(b.packets) && now.Sub(b.packets[expired].bufferedAt) > b.maxAge {
+		expired++
+	}
+	if expired == 0 {
+		return
+	}
+
+	b.dropped += uint64(expired)
+	b.packets = b.packets[expired:]
+}
+
+// push buffers the packet, returns false if it is dropped because the buffer is full
+func (b *dlBuffer) push(pduSessionId uint8, payload []byte, now time.Time) bool {
+	b.expire(now)
+
+	if len(b.packets) >= b.maxSize {
+		b.dropped++
+		return false
+	}
+
+	b.packets = append(b.packets, dlBufferedPacket{
+		pduSessionId: pduSessionId,
+		payload:      payload,
+		bufferedAt:   now,
+	})
+	return true
+}
+
+// drain returns the buffered packets in arrival order and empties the buffer
+func (b *dlBuffer) drain(now time.Time) []dlBufferedPacket {
+	b.expire(now)
+
+	packets := b.packets
+	b.packets = b.packets[:0:0]
+
+	return packets
+}
+
+func (b *dlBuffer) len() int {
+	return len(b.packets)
+}
+
+// ueDataPlane is the data plane address of a UE at the RAN together with its downlink buffer,
+// the address is bound by the UE's data plane registration request authenticated with the session token on the data plane server of a cell,
+// and the packets are protected by the AS security of the DRB, the downlink is also buffered while the UE is suspended
+type ueDataPlane struct {
+	dataPlaneToken     []byte
+	dataPlaneAddress   *net.UDPAddr
+	ranDataPlaneServer *net.UDPConn
+	dlBuffer           *dlBuffer
+	drb                *protocol.PdcpEntity
+	suspended          bool
+
+	// the counter of the last accepted data plane registration request
+	dataPlaneRegistrationCounter uint64
+
+	ueRadioChannel
+
+	mtx sync.Mutex
+}
+
+func newUeDataPlane(dlBufferSize int, dlBufferMaxAge time.Duration, radioChannelProfile channel.Profile) ueDataPlane {
+	return ueDataPlane{
+		dataPlaneAddress: nil,
+		dlBuffer:         newDlBuffer(dlBufferSize, dlBufferMaxAge),
+
+		ueRadioChannel: newUeRadioChannel(radioChannelProfile),
+
+		mtx: sync.Mutex{},
+	}
+}
+
+// writeToUe protects the packet of the PDU session and sends it over the downlink radio channel to the data plane address
+func (d *ueDataPlane) writeToUe(pduSessionId uint8, payload []byte, dataPlaneAddress *net.UDPAddr, ranDataPlaneServer *net.UDPConn) (int, error) {
+	pdu, err := util.ProtectDataPlanePacket(d.drb, pduSessionId, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	sent, err := d.dlRadioLink.SendDatagram(pdu, func(packet []byte) error {
+		_, err := ranDataPlaneServer.WriteToUDP(packet, dataPlaneAddress)
+		return err
+	})
+	if !sent {
+		return 0, errRadioChannelLoss
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(payload), nil
+}
+
+// readFromUe verifies and deciphers the uplink packet received on the data plane, returns its PDU session ID and the packet
+func (d *ueDataPlane) readFromUe(packet []byte) (uint8, []byte, error) {
+	d.mtx.Lock()
+	drb := d.drb
+	d.mtx.Unlock()
+
+	return util.UnprotectDataPlanePacket(drb, packet)
+}
+
+func (d *ueDataPlane) SetDrbSecurity(drb *protocol.PdcpEntity) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.drb = drb
+}
+
+func (d *ueDataPlane) GetDataPlaneToken() []byte {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.dataPlaneToken
+}
+
+func (d *ueDataPlane) SetDataPlaneToken(dataPlaneToken []byte) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.dataPlaneToken = dataPlaneToken
+}
+
+// AcceptDataPlaneRegistrationCounter accepts the counter of a verified data plane registration request if it is above
+// the last accepted one, a replayed request is rejected
+func (d *ueDataPlane) AcceptDataPlaneRegistrationCounter(counter uint64) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if counter <= d.dataPlaneRegistrationCounter {
+		return false
+	}
+	d.dataPlaneRegistrationCounter = counter
+	return true
+}
+
+func (d *ueDataPlane) GetDataPlaneAddress() *net.UDPAddr {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.dataPlaneAddress
+}
+
+// SetDataPlaneAddress sets the data plane address of the UE and the server it registered on,
+// and flushes the buffered downlink packets to it in order, returns the number of flushed packets
+func (d *ueDataPlane) SetDataPlaneAddress(dataPlaneAddress *net.UDPAddr, ranDataPlaneServer *net.UDPConn) (int, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.dataPlaneAddress = dataPlaneAddress
+	d.ranDataPlaneServer = ranDataPlaneServer
+	return d.flushDlBuffer()
+}
+
+// SuspendDataPlane buffers the downlink packets while the UE is in RRC_INACTIVE
+func (d *ueDataPlane) SuspendDataPlane() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.suspended = true
+}
+
+// ResumeDataPlane flushes the downlink packets buffered while the UE was suspended, returns the number of flushed packets
+func (d *ueDataPlane) ResumeDataPlane() (int, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.suspended = false
+	return d.flushDlBuffer()
+}
+
+// flushDlBuffer writes the buffered downlink packets to the data plane address in order, the caller holds mtx
+func (d *ueDataPlane) flushDlBuffer() (int, error) {
+	if d.dataPlaneAddress == nil || d.suspended {
+		return 0, nil
+	}
+
+	packets := d.dlBuffer.drain(time.Now())
+	for i, packet := range packets {
+		if _, err := d.writeToUe(packet.pduSessionId, packet.payload, d.dataPlaneAddress, d.ranDataPlaneServer); err != nil && !errors.Is(err, errRadioChannelLoss) {
+			d.dlBuffer.dropped += uint64(len(packets) - i)
+			return i, err
+		}
+	}
+	return len(packets), nil
+}
+
+// forwardDlPacket writes the packet of the PDU session to the UE, or buffers it if the data plane address is not set yet
+// or the UE is suspended, returns whether the packet is buffered
+func (d *ueDataPlane) forwardDlPacket(pduSessionId uint8, payload []byte) (int, bool, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dataPlaneAddress == nil || d.suspended {
+		if !d.dlBuffer.push(pduSessionId, payload, time.Now()) {
+			return 0, false, errDlBufferFull
+		}
+		return 0, true, nil
+	}
+
+	n, err := d.writeToUe(pduSessionId, payload, d.dataPlaneAddress, d.ranDataPlaneServer)
+	return n, false, err
+}
+
+func (d *ueDataPlane) GetDlBufferedCount() int {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.dlBuffer.len()
+}
+
+func (d *ueDataPlane) GetDlDroppedCount() uint64 {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.dlBuffer.dropped
+}
@@ -0,0 +1,135 @@
+package gnb
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/channel"
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/nas/security"
+)
+
+func TestDlBuffer(t *testing.T) {
+	now := time.Now()
+	buffer := newDlBuffer(3, time.Second)
+
+	for i := range 4 {
+		accepted := buffer.push(1, []byte{byte(i)}, now)
+		if accepted != (i < 3) {
+			t.Fatalf("packet %d: expected accepted %v, got %v", i, i < 3, accepted)
+		}
+	}
+	if buffer.dropped != 1 {
+		t.Errorf("expected 1 dropped packet, got %d", buffer.dropped)
+	}
+
+	packets := buffer.drain(now)
+	if len(packets) != 3 {
+		t.Fatalf("expected 3 drained packets, got %d", len(packets))
+	}
+	for i, packet := range packets {
+		if packet.payload[0] != byte(i) {
+			t.Errorf("expected packet %d in order, got %d", i, packet.payload[0])
+		}
+	}
+	if buffer.len() != 0 {
+		t.Errorf("expected empty buffer after drain, got %d", buffer.len())
+	}
+}
+
+func TestDlBufferExpire(t *testing.T) {
+	now := time.Now()
+	buffer := newDlBuffer(8, time.Second)
+
+	buffer.push(1, []byte{0}, now)
+	buffer.push(1, []byte{1}, now.Add(1500*time.Millisecond))
+
+	packets := buffer.drain(now.Add(2 * time.Second))
+	if len(packets) != 1 || packets[0].payload[0] != 1 {
+		t.Fatalf("expected only the fresh packet to be drained, got %v", packets)
+	}
+	if buffer.dropped != 1 {
+		t.Errorf("expected 1 expired packet counted as dropped, got %d", buffer.dropped)
+	}
+}
+
+func TestUeDataPlaneFlushOnSetDataPlaneAddress(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen server: %v", err)
+	}
+	defer server.Close()
+
+	ue, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen ue: %v", err)
+	}
+	defer ue.Close()
+
+	kGnb := make([]byte, 32)
+	ranContext, err := protocol.NewAsSecurityContext(kGnb, security.AlgCiphering128NEA2, security.AlgIntegrity128NIA2, security.DirectionDownlink)
+	if err != nil {
+		t.Fatalf("new ran as security context: %v", err)
+	}
+	ueContext, err := protocol.NewAsSecurityContext(kGnb, security.AlgCiphering128NEA2, security.AlgIntegrity128NIA2, security.DirectionUplink)
+	if err != nil {
+		t.Fatalf("new ue as security context: %v", err)
+	}
+
+	dataPlane := newUeDataPlane(8, time.Second, channel.Profile{})
+	dataPlane.SetDrbSecurity(ranContext.Drb)
+	for i := range 3 {
+		if _, buffered, err := dataPlane.forwardDlPacket(uint8(i+1), []byte{byte(i)}); err != nil || !buffered {
+			t.Fatalf("packet %d: expected buffered, got buffered %v, err %v", i, buffered, err)
+		}
+	}
+
+	flushed, err := dataPlane.SetDataPlaneAddress(ue.LocalAddr().(*net.UDPAddr), server)
+	if err != nil || flushed != 3 {
+		t.Fatalf("expected 3 flushed packets, got %d, err %v", flushed, err)
+	}
+
+	if _, buffered, err := dataPlane.forwardDlPacket(4, []byte{3}); err != nil || buffered {
+		t.Fatalf("expected packet to be forwarded directly, got buffered %v, err %v", buffered, err)
+	}
+
+	if err := ue.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("set read deadline: %v", err)
+	}
+	buffer := make([]byte, 64)
+	for i := range 4 {
+		n, err := ue.Read(buffer)
+		if err != nil {
+			t.Fatalf("read packet %d: %v", i, err)
+		}
+		pduSessionId, packet, err := util.UnprotectDataPlanePacket(ueContext.Drb, buffer[:n])
+		if err != nil {
+			t.Fatalf("unprotect packet %d: %v", i, err)
+		}
+		if len(packet) != 1 || packet[0] != byte(i) {
+			t.Errorf("expected packet %d in order, got %v", i, packet)
+		}
+		if pduSessionId != uint8(i+1) {
+			t.Errorf("expected packet %d of pdu session %d, got %d", i, i+1, pduSessionId)
+		}
+	}
+}
+
+func TestUeDataPlaneAcceptDataPlaneRegistrationCounter(t *testing.T) {
+	dataPlane := newUeDataPlane(8, time.Second, channel.Profile{})
+
+	if !dataPlane.AcceptDataPlaneRegistrationCounter(1) {
+		t.Fatalf("expected counter 1 to be accepted")
+	}
+	if dataPlane.AcceptDataPlaneRegistrationCounter(1) {
+		t.Errorf("expected replayed counter 1 to be rejected")
+	}
+	if !dataPlane.AcceptDataPlaneRegistrationCounter(3) {
+		t.Errorf("expected counter 3 to be accepted")
+	}
+	if dataPlane.AcceptDataPlaneRegistrationCounter(2) {
+		t.Errorf("expected older counter 2 to be rejected")
+	}
+}
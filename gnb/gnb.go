@@ -15,9 +15,11 @@ import (
 	"github.com/Alonza0314/free-ran-ue/constant"
 	"github.com/Alonza0314/free-ran-ue/logger"
 	"github.com/Alonza0314/free-ran-ue/model"
+	"github.com/Alonza0314/free-ran-ue/protocol"
 	"github.com/Alonza0314/free-ran-ue/util"
 	"github.com/free5gc/aper"
 	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasType"
 	"github.com/free5gc/ngap"
 	"github.com/free5gc/ngap/ngapConvert"
 	"github.com/free5gc/ngap/ngapType"
@@ -26,11 +28,6 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-type dlTeidAndUeType struct {
-	dlTeid aper.OctetString
-	ueType constant.UeType
-}
-
 type xnInterface struct {
 	enable       bool
 	xnListenIp   string
@@ -67,6 +64,12 @@ type Gnb struct {
 	n2Conn *sctp.SCTPConn
 	n3Conn *net.UDPConn
 
+	// closed when the N2 receiver stops
+	n2Closed chan struct{}
+
+	upfN3Addr *net.UDPAddr
+	knownUpfs knownUpfs
+
 	gnbId   []byte
 	gnbName string
 
@@ -74,21 +77,30 @@ type Gnb struct {
 	tai    ngapType.TAI
 	snssai ngapType.SNSSAI
 
+	// the first cell is served on the listeners of the gNB
+	cells []*cell
+
 	staticNrdc bool
 
+	dlBufferSize   int
+	dlBufferMaxAge time.Duration
+
+	radioChannel *radioChannel
+
 	xnInterface
 
 	ranControlPlaneListener *net.Listener
 	ranDataPlaneServer      *net.UDPConn
 	xnListener              *net.Listener
 
-	ranUeConns  sync.Map
-	xnUeConns   sync.Map
-	dlTeidToUe  sync.Map
-	addressToUe sync.Map
+	ranUeConns            sync.Map
+	ranUeIdToUe           sync.Map
+	xnUeConns             sync.Map
+	dlTeidToUe            sync.Map
+	addressToUe           sync.Map
+	dataPlaneIdentityToUe sync.Map
 
-	gtpChannel             chan []byte
-	dlTeidAndUeTypeChannel chan dlTeidAndUeType
+	gtpChannel chan ulGtpPacket
 
 	ranUeNgapIdGenerator *RanUeNgapIdGenerator
 	teidGenerator        *TeidGenerator
@@ -140,6 +152,20 @@ func NewGnb(config *model.GnbConfig, gnbLogger *logger.GnbLogger) *Gnb {
 		return nil
 	}
 
+	cells, err := newCells(config.Gnb.Cells, plmnId, tai, snssai)
+	if err != nil {
+		gnbLogger.CfgLog.Errorf("Error building cells: %v", err)
+		return nil
+	}
+
+	dlBufferSize, dlBufferMaxAge := config.Gnb.DlBuffer.Size, config.Gnb.DlBuffer.MaxAge
+	if dlBufferSize == 0 {
+		dlBufferSize = constant.DL_BUFFER_DEFAULT_SIZE
+	}
+	if dlBufferMaxAge == 0 {
+		dlBufferMaxAge = constant.DL_BUFFER_DEFAULT_MAX_AGE
+	}
+
 	return &Gnb{
 		amfN2Ip:           config.Gnb.AmfN2Ip,
 		ranN2Ip:           config.Gnb.RanN2Ip,
@@ -162,7 +188,15 @@ func NewGnb(config *model.GnbConfig, gnbLogger *logger.GnbLogger) *Gnb {
 		tai:    tai,
 		snssai: snssai,
 
+		cells: cells,
+
 		staticNrdc: config.Gnb.StaticNrdc,
+
+		dlBufferSize:   dlBufferSize,
+		dlBufferMaxAge: dlBufferMaxAge,
+
+		radioChannel: newRadioChannel(config.Gnb.RadioChannel),
+
 		xnInterface: xnInterface{
 			enable:       config.Gnb.XnInterface.Enable,
 			xnListenIp:   config.Gnb.XnInterface.XnListenIp,
@@ -171,15 +205,15 @@ func NewGnb(config *model.GnbConfig, gnbLogger *logger.GnbLogger) *Gnb {
 			xnDialPort:   config.Gnb.XnInterface.XnDialPort,
 		},
 
-		ranUeConns:  sync.Map{},
-		xnUeConns:   sync.Map{},
-		dlTeidToUe:  sync.Map{},
-		addressToUe: sync.Map{},
-
-		dlTeidAndUeTypeChannel: make(chan dlTeidAndUeType),
+		ranUeConns:            sync.Map{},
+		ranUeIdToUe:           sync.Map{},
+		xnUeConns:             sync.Map{},
+		dlTeidToUe:            sync.Map{},
+		addressToUe:           sync.Map{},
+		dataPlaneIdentityToUe: sync.Map{},
 
 		ranUeNgapIdGenerator: NewRanUeNgapIdGenerator(),
-		teidGenerator:        NewTeidGenerator(),
+		teidGenerator:        NewTeidGenerator(config.Gnb.TeidAllocation == constant.TEID_ALLOCATION_RANDOM),
 
 		api: api{
 			ip:   config.Gnb.Api.Ip,
@@ -208,9 +242,10 @@ func (g *Gnb) Start(ctx context.Context) error {
 		}
 		return err
 	}
+	g.startN2Receiver()
 
-	if err := g.connectToUpf(); err != nil {
-		g.GtpLog.Errorf("Error connecting to UPF: %v", err)
+	if err := g.startN3Conn(); err != nil {
+		g.GtpLog.Errorf("Error starting N3 connection: %v", err)
 		if err := g.n2Conn.Close(); err != nil {
 			g.SctpLog.Errorf("Error closing N2 connection: %v", err)
 		}
@@ -266,9 +301,38 @@ func (g *Gnb) Start(ctx context.Context) error {
 		return err
 	}
 
+	if err := g.startCellListeners(); err != nil {
+		g.RanLog.Errorf("Error starting cell listeners: %v", err)
+		g.stopCellListeners()
+		if err := g.ranDataPlaneServer.Close(); err != nil {
+			g.RanLog.Errorf("Error closing ran data plane server: %v", err)
+		}
+		if err := (*g.ranControlPlaneListener).Close(); err != nil {
+			g.RanLog.Errorf("Error closing ran control plane listener: %v", err)
+		}
+		if g.xnInterface.enable {
+			if err := (*g.xnListener).Close(); err != nil {
+				g.XnLog.Errorf("Error closing XN listener: %v", err)
+			}
+		}
+		close(g.gtpChannel)
+		if err := g.n3Conn.Close(); err != nil {
+			g.GtpLog.Errorf("Error closing N3 connection: %v", err)
+		}
+		if err := g.n2Conn.Close(); err != nil {
+			g.SctpLog.Errorf("Error closing N2 connection: %v", err)
+		}
+		return err
+	}
+
 	g.startGtpProcessor(ctx)
 
-	go g.startDataPlaneProcessor()
+	go g.startDataPlaneProcessor(g.ranDataPlaneServer)
+	for _, c := range g.cells {
+		if c.ranDataPlaneServer != nil {
+			go g.startDataPlaneProcessor(c.ranDataPlaneServer)
+		}
+	}
 
 	go func() {
 		if !g.xnInterface.enable {
@@ -289,26 +353,12 @@ func (g *Gnb) Start(ctx context.Context) error {
 		}
 	}()
 
-	go func() {
-		for {
-			conn, err := (*g.ranControlPlaneListener).Accept()
-			if err != nil {
-				if errors.Is(err, net.ErrClosed) {
-					return
-				}
-				g.RanLog.Errorf("Error accepting UE connection: %v", err)
-				continue
-			}
-			g.RanLog.Infof("New UE connection accepted from: %v", conn.RemoteAddr())
-			ranUe := NewRanUe(conn, g.ranUeNgapIdGenerator)
-			if g.staticNrdc {
-				ranUe.ActivateNrdc()
-			}
-
-			g.ranUeConns.Store(ranUe, struct{}{})
-			go g.handleRanConnection(ctx, ranUe)
+	go g.acceptRanConnections(ctx, g.ranControlPlaneListener, g.cells[0])
+	for _, c := range g.cells {
+		if c.ranControlPlaneListener != nil {
+			go g.acceptRanConnections(ctx, c.ranControlPlaneListener, c)
 		}
-	}()
+	}
 
 	g.startApiServer()
 
@@ -321,6 +371,8 @@ func (g *Gnb) Stop() {
 
 	g.stopApiServer()
 
+	g.stopCellListeners()
+
 	if err := g.ranDataPlaneServer.Close(); err != nil {
 		g.RanLog.Errorf("Error stopping ran data plane listener: %v", err)
 		return
@@ -350,6 +402,9 @@ func (g *Gnb) Stop() {
 			defer wg.Done()
 			if ranUe, ok := key.(*RanUe); ok {
 				g.RanLog.Tracef("UE %v still in connection", ranUe.GetN1Conn().RemoteAddr())
+				if _, err := ranUe.SendToUe(protocol.MESSAGE_TYPE_RELEASE, []byte{byte(protocol.CAUSE_NORMAL)}); err != nil {
+					g.RanLog.Warnf("Error send release to UE: %v", err)
+				}
 				if err := ranUe.GetN1Conn().Close(); err != nil {
 					g.RanLog.Errorf("Error closing UE connection: %v", err)
 				}
@@ -413,33 +468,95 @@ func (g *Gnb) connectToAmf() error {
 	return nil
 }
 
-func (g *Gnb) connectToUpf() error {
-	g.RanLog.Infoln("Connecting to UPF")
-	upfAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", g.upfN3Ip, g.upfN3Port))
+func (g *Gnb) startN3Conn() error {
+	g.RanLog.Infoln("Starting N3 connection")
+	upfAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(g.upfN3Ip, strconv.Itoa(g.upfN3Port)))
 	if err != nil {
 		return fmt.Errorf("error resolving UPF N3 IP address: %v", err)
 	}
 
-	ranAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", g.ranN3Ip, g.ranN3Port))
+	ranAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(g.ranN3Ip, strconv.Itoa(g.ranN3Port)))
 	if err != nil {
 		return fmt.Errorf("error resolving RAN N3 IP address: %v", err)
 	}
 
-	conn, err := net.DialUDP("udp", ranAddr, upfAddr)
+	// the N3 connection is left unconnected, each session sends to its own UPF
+	conn, err := net.ListenUDP("udp", ranAddr)
 	if err != nil {
-		return fmt.Errorf("error connecting to UPF: %v", err)
+		return fmt.Errorf("error listening on RAN N3 address: %v", err)
 	}
-	g.GtpLog.Debugln("Dial UDP to UPF success")
+	g.GtpLog.Debugln("Listen UDP on RAN N3 address success")
 
 	g.n3Conn = conn
-	g.RanLog.Infof("Connected to UPF: %v, local: %v", upfAddr.String(), conn.LocalAddr().String())
+	g.upfN3Addr = upfAddr
+	g.addKnownUpf(upfAddr)
+	g.RanLog.Infof("N3 connection started, default UPF: %v, local: %v", upfAddr.String(), conn.LocalAddr().String())
+	return nil
+}
+
+// add a reference of the UPF to the known UPFs, downlink GTP packets are only accepted from the known UPFs
+func (g *Gnb) addKnownUpf(upfN3Addr *net.UDPAddr) {
+	if g.knownUpfs.acquire(upfN3Addr.AddrPort().Addr().Unmap()) {
+		g.GtpLog.Infof("Added known UPF: %s", upfN3Addr.IP.String())
+	}
+}
+
+// release a reference of the UPF, the UPF is removed from the known UPFs with its last PDU session
+func (g *Gnb) releaseKnownUpf(upfN3Addr *net.UDPAddr) {
+	if g.knownUpfs.release(upfN3Addr.AddrPort().Addr().Unmap()) {
+		g.GtpLog.Infof("Removed known UPF: %s", upfN3Addr.IP.String())
+	}
+}
+
+// anchor the PDU session at the UPF of the UP transport layer address, the UPF it was anchored at before is released
+func (g *Gnb) anchorPduSession(session *pduSession, transportLayerAddress ngapType.TransportLayerAddress) {
+	upfN3Addr := g.resolveUpfN3Addr(transportLayerAddress)
+	g.addKnownUpf(upfN3Addr)
+	if previousUpfN3Addr := session.GetUpfN3Addr(); previousUpfN3Addr != nil {
+		g.releaseKnownUpf(previousUpfN3Addr)
+	}
+	session.SetUpfN3Addr(upfN3Addr)
+}
+
+// release the DL TEID of the PDU session and the UPF it is anchored at
+func (g *Gnb) releasePduSessionTunnel(session *pduSession) {
+	g.teidGenerator.ReleaseTeid(session.GetDlTeid())
+	if upfN3Addr := session.GetUpfN3Addr(); upfN3Addr != nil {
+		g.releaseKnownUpf(upfN3Addr)
+	}
+}
+
+// resolve the N3 address of the UPF from the UP transport layer address sent by the SMF,
+// the configured UPF is used if the address is absent
+func (g *Gnb) resolveUpfN3Addr(transportLayerAddress ngapType.TransportLayerAddress) *net.UDPAddr {
+	upfIp := util.TransportLayerAddressToIp(transportLayerAddress)
+	if upfIp == nil {
+		g.GtpLog.Warnf("No UPF address in UP transport layer information, using default UPF %s", g.upfN3Addr.String())
+		return g.upfN3Addr
+	}
+
+	return &net.UDPAddr{IP: upfIp, Port: g.upfN3Port}
+}
+
+// checkUlNgUUpTnlInformation rejects the UL NG-U UP TNL information of a UPF with an IPv6 address only,
+// the gNB has an IPv4 N3 address only and could not reach it
+func checkUlNgUUpTnlInformation(pduSessionResourceSetupRequestTransfer *ngapType.PDUSessionResourceSetupRequestTransfer) error {
+	for _, item := range pduSessionResourceSetupRequestTransfer.ProtocolIEs.List {
+		if item.Id.Value != ngapType.ProtocolIEIDULNGUUPTNLInformation || item.Value.ULNGUUPTNLInformation.GTPTunnel == nil {
+			continue
+		}
+		upfIp := util.TransportLayerAddressToIp(item.Value.ULNGUUPTNLInformation.GTPTunnel.TransportLayerAddress)
+		if upfIp != nil && upfIp.To4() == nil {
+			return fmt.Errorf("unsupported UL NG-U UP TNL information: UPF address %s is IPv6 only, N3 of the gNB is IPv4", upfIp.String())
+		}
+	}
 	return nil
 }
 
 func (g *Gnb) setupN2() error {
 	g.RanLog.Infoln("Setting up N2")
 
-	request, err := getNgapSetupRequest(g.gnbId, g.gnbName, g.plmnId, g.tai, g.snssai)
+	request, err := getNgapSetupRequest(g.gnbId, g.gnbName, g.plmnId, getSupportedTaList(g.cells))
 	if err != nil {
 		return fmt.Errorf("error getting NGAP setup request: %v", err)
 	}
@@ -477,11 +594,14 @@ func (g *Gnb) setupN2() error {
 	plmnId := ngapConvert.PlmnIdToModels(g.plmnId)
 	g.NgapLog.Infof("PLMN ID: %v", plmnId)
 
-	tai := ngapConvert.TaiToModels(g.tai)
-	g.NgapLog.Infof("TAC: %v, broadcast PLMN ID: %v", tai.Tac, tai.PlmnId)
-
-	snssai := ngapConvert.SNssaiToModels(g.snssai)
-	g.NgapLog.Infof("SST: %v, SD: %v", snssai.Sst, snssai.Sd)
+	for _, c := range g.cells {
+		tai := ngapConvert.TaiToModels(c.tai)
+		g.NgapLog.Infof("Cell NR cell ID: %s, PCI: %d, TAC: %v, broadcast PLMN ID: %v", c, c.pci, tai.Tac, tai.PlmnId)
+		for _, cellSnssai := range c.snssai {
+			snssai := ngapConvert.SNssaiToModels(cellSnssai)
+			g.NgapLog.Infof("Cell NR cell ID: %s, SST: %v, SD: %v", c, snssai.Sst, snssai.Sd)
+		}
+	}
 
 	g.NgapLog.Infoln("====================================")
 
@@ -498,59 +618,188 @@ func (g *Gnb) setupN1(ranUe *RanUe) error {
 	}
 	time.Sleep(1 * time.Second)
 
-	// pdu session establishment
-	ranUe.SetDlTeid(g.teidGenerator.AllocateTeid())
-	pduSessionResourceSetupRequestTransfer := ngapType.PDUSessionResourceSetupRequestTransfer{}
-	if err := g.processUePduSessionEstablishment(ranUe, &pduSessionResourceSetupRequestTransfer); err != nil {
+	// issue the data plane registration to UE, which the data plane of every pdu session is bound by
+	dataPlaneToken, err := util.NewDataPlaneToken()
+	if err != nil {
 		return err
 	}
-	time.Sleep(1 * time.Second)
+	ranUe.SetDataPlaneToken(dataPlaneToken)
+	g.dataPlaneIdentityToUe.Store(ranUe.GetMobileIdentityIMSI(), ranUe)
 
-	// configure UE mapping
-	for _, item := range pduSessionResourceSetupRequestTransfer.ProtocolIEs.List {
-		switch item.Id.Value {
-		case ngapType.ProtocolIEIDPDUSessionAggregateMaximumBitRate:
-		case ngapType.ProtocolIEIDULNGUUPTNLInformation:
-			ranUe.SetUlTeid(item.Value.ULNGUUPTNLInformation.GTPTunnel.GTPTEID.Value)
-		case ngapType.ProtocolIEIDAdditionalULNGUUPTNLInformation:
-		case ngapType.ProtocolIEIDPDUSessionType:
-		case ngapType.ProtocolIEIDQosFlowSetupRequestList:
+	dataPlaneRegistration := util.DataPlaneRegistration{
+		Identity: ranUe.GetMobileIdentityIMSI(),
+		Token:    ranUe.GetDataPlaneToken(),
+	}
+	dataPlaneTokenMessage, err := dataPlaneRegistration.Marshal(constant.UE_DATA_PLANE_TOKEN)
+	if err != nil {
+		return fmt.Errorf("error marshal data plane token message: %v", err)
+	}
+	n, err := ranUe.SendToUe(protocol.MESSAGE_TYPE_DATA_PLANE_TOKEN, dataPlaneTokenMessage)
+	if err != nil {
+		return fmt.Errorf("error send data plane token message to UE: %v", err)
+	}
+	g.RanLog.Tracef("Sent %d bytes of data plane token message to UE", n)
+	g.RanLog.Debugf("Sent data plane token to UE %s", ranUe.GetMobileIdentityIMSI())
+
+	g.RanLog.Infof("UE %s N1 setup complete", ranUe.GetMobileIdentityIMSI())
+	return nil
+}
+
+// serveN1 relays the messages of the UE and AMF until the UE is released, an uplink nas message is sent to AMF
+// and the NGAP PDUs from AMF are handled whenever they arrive, either answering the UE or initiated by the network,
+// a pdu session resource setup request sets up a pdu session, a downlink nas transport is forwarded to UE,
+// e.g. the registration accept of a registration update, and the UE context release command releases the UE,
+// e.g. after the deregistration accept
+func (g *Gnb) serveN1(ranUe *RanUe) error {
+	g.RanLog.Infoln("Serving N1")
+
+	for {
+		select {
+		case message, received := <-ranUe.n1Messages:
+			message, err := ranUe.openUeMessage(message, received)
+			if err != nil {
+				return fmt.Errorf("error receive message from UE: %v", err)
+			}
+			switch message.Type {
+			case protocol.MESSAGE_TYPE_NAS:
+				g.NasLog.Tracef("Received %d bytes of uplink NAS from UE", len(message.Payload))
+				if err := g.sendUplinkNasTransport(ranUe, message.Payload); err != nil {
+					return fmt.Errorf("error relay uplink nas to AMF: %v", err)
+				}
+			case protocol.MESSAGE_TYPE_RRC:
+				g.handleUeRrcMessage(ranUe, message.Payload)
+			default:
+				g.RanLog.Warnf("Unexpected %s message from UE %s", message.Type, ranUe.GetMobileIdentityIMSI())
+			}
+		case n2Message := <-ranUe.n2Messages:
+			released, err := g.handleN2Message(ranUe, n2Message)
+			if err != nil {
+				return err
+			}
+			if released {
+				g.RanLog.Infoln("N1 released")
+				return nil
+			}
+		case <-g.n2Closed:
+			return fmt.Errorf("error receive ngap pdu from AMF: N2 connection closed")
 		}
 	}
+}
+
+// handleN2Message handles an NGAP PDU from AMF for the served UE, and returns whether the UE is released
+func (g *Gnb) handleN2Message(ranUe *RanUe, n2Message *n2Message) (bool, error) {
+	ngapPdu := n2Message.pdu
+	if ngapPdu.Present != ngapType.NGAPPDUPresentInitiatingMessage {
+		g.NgapLog.Warnf("Unexpected NGAP PDU from AMF for UE %s: %+v", ranUe.GetMobileIdentityIMSI(), ngapPdu)
+		return false, nil
+	}
+	switch ngapPdu.InitiatingMessage.ProcedureCode.Value {
+	case ngapType.ProcedureCodePDUSessionResourceSetup:
+		if err := g.processUePduSessionEstablishment(ranUe, n2Message.raw, ngapPdu); err != nil {
+			return false, fmt.Errorf("error process pdu session establishment: %v", err)
+		}
+	case ngapType.ProcedureCodePDUSessionResourceRelease:
+		if err := g.processUePduSessionRelease(ranUe, ngapPdu); err != nil {
+			return false, fmt.Errorf("error process pdu session release: %v", err)
+		}
+	case ngapType.ProcedureCodePDUSessionResourceModify:
+		if err := g.processUePduSessionModification(ranUe, ngapPdu); err != nil {
+			return false, fmt.Errorf("error process pdu session modification: %v", err)
+		}
+	case ngapType.ProcedureCodeDownlinkNASTransport:
+		if err := g.forwardDownlinkNas(ranUe, ngapPdu); err != nil {
+			return false, fmt.Errorf("error forward downlink nas to UE: %v", err)
+		}
+	case ngapType.ProcedureCodeUEContextRelease:
+		if err := g.processUeRelease(ranUe, ngapPdu); err != nil {
+			return false, fmt.Errorf("error process ue release: %v", err)
+		}
+		return true, nil
+	case ngapType.ProcedureCodeErrorIndication:
+		cause, _ := getErrorIndicationCause(ngapPdu)
+		g.NgapLog.Warnf("Receive Error Indication from AMF for UE %s, cause: %s", ranUe.GetMobileIdentityIMSI(), ngapCauseToString(cause))
+	default:
+		g.NgapLog.Warnf("Unexpected NGAP procedure %d from AMF for UE %s", ngapPdu.InitiatingMessage.ProcedureCode.Value, ranUe.GetMobileIdentityIMSI())
+	}
+	return false, nil
+}
 
-	g.dlTeidToUe.Store(hex.EncodeToString(ranUe.GetDlTeid()), ranUe)
-	g.GtpLog.Debugf("Stored RAN UE %s with DL TEID %s to dlTeidToUe", ranUe.GetMobileIdentityIMSI(), hex.EncodeToString(ranUe.GetDlTeid()))
+// forwardDownlinkNas sends the nas pdu of the downlink nas transport from AMF to UE
+func (g *Gnb) forwardDownlinkNas(ranUe *RanUe, ngapDownlinkNasTransport *ngapType.NGAPPDU) error {
+	g.NgapLog.Tracef("NGAP downlink nas transport: %+v", ngapDownlinkNasTransport)
+	g.NgapLog.Debugln("Receive downlink NAS transport from AMF")
 
-	g.dlTeidAndUeTypeChannel <- dlTeidAndUeType{
-		dlTeid: ranUe.GetDlTeid(),
-		ueType: constant.UE_TYPE_RAN,
+	nasPdu, err := getNasPduFromDownlinkNasTransport(ngapDownlinkNasTransport.InitiatingMessage.Value.DownlinkNASTransport)
+	if err != nil {
+		return fmt.Errorf("error NGAP downlink nas transport: %v", err)
 	}
-	g.GtpLog.Debugf("Sent DL TEID %s to teidChannel", hex.EncodeToString(ranUe.GetDlTeid()))
 
-	g.RanLog.Infof("UE %s N1 setup complete", ranUe.GetMobileIdentityIMSI())
+	n, err := ranUe.SendToUe(protocol.MESSAGE_TYPE_NAS, nasPdu)
+	if err != nil {
+		return fmt.Errorf("error send downlink nas to UE: %v", err)
+	}
+	g.NasLog.Tracef("Sent %d bytes of downlink NAS to UE", n)
+	g.NasLog.Debugf("Send downlink NAS to UE %s", ranUe.GetMobileIdentityIMSI())
 	return nil
 }
 
-func (g *Gnb) releaseN1(ranUe *RanUe) error {
-	g.RanLog.Infoln("Waiting for UE to release N1")
+// forwardUeUplinkNas sends the next uplink nas message of the UE to AMF without waiting for a reply,
+// e.g. the 5GSM complete message ending a pdu session procedure
+func (g *Gnb) forwardUeUplinkNas(ranUe *RanUe) error {
+	uplinkNas, err := g.receiveUeUplinkNas(ranUe)
+	if err != nil {
+		return fmt.Errorf("error receive uplink nas from UE: %v", err)
+	}
+	g.NasLog.Tracef("Received %d bytes of uplink NAS from UE", len(uplinkNas))
+
+	return g.sendUplinkNasTransport(ranUe, uplinkNas)
+}
 
-	if err := g.processUeDeRegistration(ranUe); err != nil {
-		return fmt.Errorf("error processing UE deregistration: %v", err)
+func (g *Gnb) sendUplinkNasTransport(ranUe *RanUe, uplinkNas []byte) error {
+	servingCell := ranUe.GetServingCell()
+	uplinkNasTransport, err := getUplinkNasTransport(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), servingCell.nrCgi, servingCell.tai, uplinkNas)
+	if err != nil {
+		return fmt.Errorf("error get uplink nas transport: %v", err)
 	}
+	g.NgapLog.Tracef("Get uplink NAS transport: %+v", uplinkNasTransport)
 
-	g.RanLog.Infoln("N1 released")
+	n, err := g.n2Conn.Write(uplinkNasTransport)
+	if err != nil {
+		return fmt.Errorf("error send uplink nas transport to AMF: %v", err)
+	}
+	g.NgapLog.Tracef("Sent %d bytes of uplink NAS transport to AMF", n)
+	g.NgapLog.Debugln("Send uplink NAS transport to AMF")
 	return nil
 }
 
+// receiveUeUplinkNas handles the rrc messages from the connected UE until an uplink nas message arrives
+func (g *Gnb) receiveUeUplinkNas(ranUe *RanUe) ([]byte, error) {
+	for {
+		message, err := ranUe.ReceiveMessageFromUe()
+		if err != nil {
+			return nil, err
+		}
+
+		switch message.Type {
+		case protocol.MESSAGE_TYPE_NAS:
+			return message.Payload, nil
+		case protocol.MESSAGE_TYPE_RRC:
+			g.handleUeRrcMessage(ranUe, message.Payload)
+		default:
+			g.RanLog.Warnf("Unexpected %s message from UE %s", message.Type, ranUe.GetMobileIdentityIMSI())
+		}
+	}
+}
+
 func (g *Gnb) startGtpProcessor(ctx context.Context) {
 	g.GtpLog.Infoln("Starting GTP processor")
 
-	g.gtpChannel = make(chan []byte)
+	g.gtpChannel = make(chan ulGtpPacket)
 
 	go forwardGtpPacketToN3Conn(ctx, g.n3Conn, g.gtpChannel, g.GnbLogger)
 	g.GtpLog.Debugln("Forward GTP packet to N3 connection started")
 
-	go receiveGtpPacketFromN3Conn(ctx, g.n3Conn, g.ranDataPlaneServer, g.GnbLogger, &g.dlTeidToUe)
+	go receiveGtpPacketFromN3Conn(ctx, g.n3Conn, g.GnbLogger, &g.knownUpfs, &g.dlTeidToUe)
 	g.GtpLog.Debugln("Receive GTP packet from N3 connection started")
 
 	g.GtpLog.Infoln("GTP processor started")
@@ -607,33 +856,120 @@ func (g *Gnb) startRanDataPlaneServer() error {
 	return nil
 }
 
+// startCellListeners starts the listeners of the cells which do not share the listeners of the gNB
+func (g *Gnb) startCellListeners() error {
+	for _, c := range g.cells {
+		if c.ranControlPlaneIp == "" {
+			continue
+		}
+
+		listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.ranControlPlaneIp, c.ranControlPlanePort))
+		if err != nil {
+			return fmt.Errorf("error listen control plane of cell %s: %v", c, err)
+		}
+		c.ranControlPlaneListener = &listener
+		g.RanLog.Infof("Cell %s RAN Control Plane access address: %s:%d", c, c.ranControlPlaneIp, c.ranControlPlanePort)
+
+		if c.ranDataPlaneIp == "" {
+			continue
+		}
+
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(c.ranDataPlaneIp), Port: c.ranDataPlanePort})
+		if err != nil {
+			return fmt.Errorf("error listen data plane of cell %s: %v", c, err)
+		}
+		c.ranDataPlaneServer = conn
+		g.RanLog.Infof("Cell %s RAN Data Plane access address: %s:%d", c, c.ranDataPlaneIp, c.ranDataPlanePort)
+	}
+	return nil
+}
+
+func (g *Gnb) stopCellListeners() {
+	for _, c := range g.cells {
+		if c.ranDataPlaneServer != nil {
+			if err := c.ranDataPlaneServer.Close(); err != nil {
+				g.RanLog.Errorf("Error closing data plane server of cell %s: %v", c, err)
+			}
+			c.ranDataPlaneServer = nil
+		}
+		if c.ranControlPlaneListener != nil {
+			if err := (*c.ranControlPlaneListener).Close(); err != nil {
+				g.RanLog.Errorf("Error closing control plane listener of cell %s: %v", c, err)
+			}
+			c.ranControlPlaneListener = nil
+			g.RanLog.Tracef("Cell %s listener stopped at %s:%d", c, c.ranControlPlaneIp, c.ranControlPlanePort)
+		}
+	}
+}
+
+// acceptRanConnections accepts the UEs of a control plane listener, which camp on the cell of the listener
+func (g *Gnb) acceptRanConnections(ctx context.Context, listener *net.Listener, c *cell) {
+	for {
+		conn, err := (*listener).Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			g.RanLog.Errorf("Error accepting UE connection: %v", err)
+			continue
+		}
+		g.RanLog.Infof("New UE connection accepted from: %v on cell %s", conn.RemoteAddr(), c)
+		ranUe := NewRanUe(conn, c, g.ranUeNgapIdGenerator, g.dlBufferSize, g.dlBufferMaxAge, g.radioChannel.getCellProfile())
+		if g.staticNrdc {
+			ranUe.ActivateNrdc()
+		}
+
+		g.ranUeConns.Store(ranUe, struct{}{})
+		g.ranUeIdToUe.Store(ranUe.GetRanUeId(), ranUe)
+		go g.handleRanConnection(ctx, ranUe)
+	}
+}
+
 func (g *Gnb) handleRanConnection(ctx context.Context, ranUe *RanUe) {
 	defer func() {
 		if err := ranUe.GetN1Conn().Close(); err != nil {
 			g.RanLog.Errorf("Error closing UE connection: %v", err)
 		}
 		g.RanLog.Infof("Closed UE connection from: %v", ranUe.GetN1Conn().RemoteAddr())
-		ranUe.Release(g.ranUeNgapIdGenerator, g.teidGenerator)
+		if primaryPduSession, exists := ranUe.GetPrimaryPduSession(); exists && ranUe.IsNrdcActivated() {
+			g.releaseSecondaryNode(ranUe, primaryPduSession)
+		}
+		for _, session := range ranUe.GetPduSessionList() {
+			g.dlTeidToUe.Delete(teidToUint32(session.GetDlTeid()))
+			if upfN3Addr := session.GetUpfN3Addr(); upfN3Addr != nil {
+				g.releaseKnownUpf(upfN3Addr)
+			}
+		}
+		if dataPlaneAddress := ranUe.GetDataPlaneAddress(); dataPlaneAddress != nil {
+			g.addressToUe.Delete(dataPlaneAddress.String())
+		}
+		g.dataPlaneIdentityToUe.CompareAndDelete(ranUe.GetMobileIdentityIMSI(), ranUe)
+		g.ranUeIdToUe.Delete(ranUe.GetRanUeId())
+		if err := ranUe.Release(g.ranUeNgapIdGenerator, g.teidGenerator); err != nil {
+			g.RanLog.Warnf("Error releasing UE: %v", err)
+		}
 		g.ranUeConns.Delete(ranUe)
 	}()
 
 	if err := g.setupN1(ranUe); err != nil {
 		g.RanLog.Errorf("Error setting up N1: %v", err)
+		if _, err := ranUe.SendToUe(protocol.MESSAGE_TYPE_REJECT, []byte{byte(protocol.CAUSE_NETWORK_FAILURE)}); err != nil {
+			g.RanLog.Warnf("Error send reject to UE: %v", err)
+		}
 		return
 	}
-	g.GtpLog.Debugf("DL TEID: %s, UL TEID: %s", hex.EncodeToString(ranUe.GetDlTeid()), hex.EncodeToString(ranUe.GetUlTeid()))
 
-	if err := g.releaseN1(ranUe); err != nil {
-		g.RanLog.Errorf("Error releasing N1: %v", err)
+	if err := g.serveN1(ranUe); err != nil {
+		g.RanLog.Errorf("Error serving N1: %v", err)
 		return
 	}
 	g.RanLog.Infof("UE %s N1 released", ranUe.GetMobileIdentityIMSI())
 }
 
-func (g *Gnb) startDataPlaneProcessor() {
+func (g *Gnb) startDataPlaneProcessor(ranDataPlaneServer *net.UDPConn) {
 	buffer := make([]byte, 4096)
 	for {
-		n, ueAddress, err := g.ranDataPlaneServer.ReadFromUDP(buffer)
+		n, ueAddress, err := ranDataPlaneServer.ReadFromUDP(buffer)
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				g.RanLog.Infoln("RAN data plane server closed")
@@ -645,34 +981,83 @@ func (g *Gnb) startDataPlaneProcessor() {
 		g.RanLog.Tracef("Received %d bytes of data from UE: %+v", n, buffer[:n])
 		g.RanLog.Tracef("Received %d bytes of data from UE", n)
 
-		if string(buffer[:n]) == constant.UE_DATA_PLANE_INITIAL_PACKET {
-			go g.handleUeDataPlaneInitialPacket(ueAddress)
+		tmp := make([]byte, n)
+		copy(tmp, buffer[:n])
+		if util.IsDataPlaneRegistration(tmp) {
+			go g.handleUeDataPlaneRegistration(ranDataPlaneServer, ueAddress, tmp)
 		} else {
-			tmp := make([]byte, n)
-			copy(tmp, buffer[:n])
 			go g.handleUeDataPlanePacket(ueAddress, tmp)
 		}
 	}
 }
 
-func (g *Gnb) handleUeDataPlaneInitialPacket(ueAddress *net.UDPAddr) {
-	dlTeidAndUeType := <-g.dlTeidAndUeTypeChannel
-	ue, exists := g.dlTeidToUe.Load(hex.EncodeToString(dlTeidAndUeType.dlTeid))
+// bind the data plane address of the UE whose token authenticates the request, a registration from a new address rebinds the UE,
+// a request whose counter is not above the last accepted one is a replay and rejected, the downlink is sent from the server
+// the registration arrived on
+func (g *Gnb) handleUeDataPlaneRegistration(ranDataPlaneServer *net.UDPConn, ueAddress *net.UDPAddr, packet []byte) {
+	dataPlaneRegistrationRequest := util.DataPlaneRegistrationRequest{}
+	if err := dataPlaneRegistrationRequest.Unmarshal(packet); err != nil {
+		g.RanLog.Warnf("Error unmarshal data plane registration from %s: %v", ueAddress.String(), err)
+		return
+	}
+
+	ue, exists := g.dataPlaneIdentityToUe.Load(dataPlaneRegistrationRequest.Identity)
 	if !exists {
-		g.RanLog.Warnf("No UE found for DL TEID: %s", hex.EncodeToString(dlTeidAndUeType.dlTeid))
+		g.RanLog.Warnf("Rejected data plane registration of %s from %s: unknown identity", dataPlaneRegistrationRequest.Identity, ueAddress.String())
 		return
 	}
 
-	switch dlTeidAndUeType.ueType {
-	case constant.UE_TYPE_RAN:
-		ue.(*RanUe).SetDataPlaneAddress(ueAddress)
-		g.addressToUe.Store(ueAddress.String(), ue)
-		g.RanLog.Infof("Set data plane address %s for UE: %s", ueAddress.String(), ue.(*RanUe).GetMobileIdentityIMSI())
-	case constant.UE_TYPE_XN:
-		ue.(*XnUe).SetDataPlaneAddress(ueAddress)
-		g.addressToUe.Store(ueAddress.String(), ue)
-		g.XnLog.Infof("Set data plane address %s for UE: %s", ueAddress.String(), ue.(*XnUe).GetIMSI())
+	switch u := ue.(type) {
+	case *RanUe:
+		if !dataPlaneRegistrationRequest.Verify(u.GetMobileIdentityIMSI(), u.GetDataPlaneToken()) {
+			g.RanLog.Warnf("Rejected data plane registration of %s from %s: authentication failure", dataPlaneRegistrationRequest.Identity, ueAddress.String())
+			return
+		}
+		if !u.AcceptDataPlaneRegistrationCounter(dataPlaneRegistrationRequest.Counter) {
+			g.RanLog.Warnf("Rejected data plane registration of %s from %s: replayed counter %d", dataPlaneRegistrationRequest.Identity, ueAddress.String(), dataPlaneRegistrationRequest.Counter)
+			return
+		}
+		if !g.rebindUeDataPlaneAddress(u, u.GetDataPlaneAddress(), ueAddress) {
+			return
+		}
+		flushed, err := u.SetDataPlaneAddress(ueAddress, ranDataPlaneServer)
+		if err != nil {
+			g.RanLog.Warnf("Error flushing buffered downlink packets to UE %s: %v", u.GetMobileIdentityIMSI(), err)
+		}
+		g.RanLog.Infof("Set data plane address %s for UE: %s, flushed %d buffered downlink packets", ueAddress.String(), u.GetMobileIdentityIMSI(), flushed)
+	case *XnUe:
+		if !dataPlaneRegistrationRequest.Verify(u.GetIMSI(), u.GetDataPlaneToken()) {
+			g.XnLog.Warnf("Rejected data plane registration of %s from %s: authentication failure", dataPlaneRegistrationRequest.Identity, ueAddress.String())
+			return
+		}
+		if !u.AcceptDataPlaneRegistrationCounter(dataPlaneRegistrationRequest.Counter) {
+			g.XnLog.Warnf("Rejected data plane registration of %s from %s: replayed counter %d", dataPlaneRegistrationRequest.Identity, ueAddress.String(), dataPlaneRegistrationRequest.Counter)
+			return
+		}
+		if !g.rebindUeDataPlaneAddress(u, u.GetDataPlaneAddress(), ueAddress) {
+			return
+		}
+		flushed, err := u.SetDataPlaneAddress(ueAddress, ranDataPlaneServer)
+		if err != nil {
+			g.XnLog.Warnf("Error flushing buffered downlink packets to UE %s: %v", u.GetIMSI(), err)
+		}
+		g.XnLog.Infof("Set data plane address %s for UE: %s, flushed %d buffered downlink packets", ueAddress.String(), u.GetIMSI(), flushed)
+	}
+}
+
+// move the UE in addressToUe from the old data plane address to the new one,
+// returns false if the address is unchanged, i.e. the registration is a refresh
+func (g *Gnb) rebindUeDataPlaneAddress(ue any, oldAddress, newAddress *net.UDPAddr) bool {
+	if oldAddress != nil {
+		if oldAddress.String() == newAddress.String() {
+			g.RanLog.Tracef("Refreshed data plane registration from %s", newAddress.String())
+			return false
+		}
+		g.addressToUe.Delete(oldAddress.String())
+		g.RanLog.Infof("Rebinding data plane address from %s to %s", oldAddress.String(), newAddress.String())
 	}
+	g.addressToUe.Store(newAddress.String(), ue)
+	return true
 }
 
 func (g *Gnb) handleUeDataPlanePacket(ueAddress *net.UDPAddr, buffer []byte) {
@@ -682,213 +1067,299 @@ func (g *Gnb) handleUeDataPlanePacket(ueAddress *net.UDPAddr, buffer []byte) {
 		return
 	}
 
+	var sent bool
 	switch u := ue.(type) {
 	case *RanUe:
-		go formatGtpPacketAndWriteToGtpChannel(u.GetUlTeid(), buffer, g.gtpChannel, g.GnbLogger)
+		sent, _ = u.ulRadioLink.SendDatagram(buffer, func(pdu []byte) error {
+			pduSessionId, packet, err := u.readFromUe(pdu)
+			if err != nil {
+				g.RanLog.Warnf("Dropped uplink packet of UE %s: %v", u.GetMobileIdentityIMSI(), err)
+				return nil
+			}
+			session, exists := u.GetPduSession(pduSessionId)
+			if !exists {
+				g.RanLog.Warnf("Dropped uplink packet of UE %s: no PDU session %d", u.GetMobileIdentityIMSI(), pduSessionId)
+				return nil
+			}
+			formatGtpPacketAndWriteToGtpChannel(session.GetUlTeid(), g.upfN3AddrOrDefault(session.GetUpfN3Addr()), packet, g.gtpChannel, g.GnbLogger)
+			return nil
+		})
 	case *XnUe:
-		go formatGtpPacketAndWriteToGtpChannel(u.GetUlTeid(), buffer, g.gtpChannel, g.GnbLogger)
+		sent, _ = u.ulRadioLink.SendDatagram(buffer, func(pdu []byte) error {
+			pduSessionId, packet, err := u.readFromUe(pdu)
+			if err != nil {
+				g.XnLog.Warnf("Dropped uplink packet of UE %s: %v", u.GetIMSI(), err)
+				return nil
+			}
+			if pduSessionId != u.GetPduSessionId() {
+				g.XnLog.Warnf("Dropped uplink packet of UE %s: PDU session %d is not split by NR-DC", u.GetIMSI(), pduSessionId)
+				return nil
+			}
+			formatGtpPacketAndWriteToGtpChannel(u.GetUlTeid(), g.upfN3AddrOrDefault(u.GetUpfN3Addr()), packet, g.gtpChannel, g.GnbLogger)
+			return nil
+		})
+	}
+	if !sent {
+		g.RanLog.Tracef("Uplink packet from %s %v", ueAddress.String(), errRadioChannelLoss)
+	}
+}
+
+func (g *Gnb) upfN3AddrOrDefault(upfN3Addr *net.UDPAddr) *net.UDPAddr {
+	if upfN3Addr == nil {
+		return g.upfN3Addr
 	}
+	return upfN3Addr
 }
 
 func (g *Gnb) processUeInitialization(ranUe *RanUe) error {
 	g.RanLog.Infoln("Processing UE initialization")
 
-	// receive ue registration request from UE and send to AMF
-	ueRegistrationRequest := make([]byte, 1024)
-	n, err := ranUe.GetN1Conn().Read(ueRegistrationRequest)
+	// receive the initial nas of UE in rrc setup complete and send to AMF, either the registration request,
+	// or the service request of a registered UE in RRC_IDLE, which is identified by its 5G-S-TMSI
+	ueInitialNas, err := g.processRrcConnectionSetup(ranUe)
 	if err != nil {
-		return fmt.Errorf("error receive ue registration request from UE: %v", err)
+		return fmt.Errorf("error process rrc connection setup: %v", err)
 	}
-	g.NasLog.Tracef("Received %d bytes of UE registration request from UE", n)
+	g.NasLog.Tracef("Received %d bytes of UE initial NAS from UE", len(ueInitialNas))
 
+	// a UE resuming its native security context sends the initial nas integrity protected only
+	plainUeInitialNas, readable := getPlainNasPdu(ueInitialNas)
+	if !readable {
+		return fmt.Errorf("error decode ue initial nas from UE: ciphered initial nas")
+	}
 	nasMessage := nas.NewMessage()
-	if err := nasMessage.GmmMessageDecode(&ueRegistrationRequest); err != nil {
-		return fmt.Errorf("error decode ue registration request from UE: %v", err)
+	if err := nasMessage.GmmMessageDecode(&plainUeInitialNas); err != nil {
+		return fmt.Errorf("error decode ue initial nas from UE: %v", err)
+	}
+	var fiveGSTmsi *ngapType.FiveGSTMSI
+	serviceRequest := nasMessage.GmmMessage.GetMessageType() == nas.MsgTypeServiceRequest
+	switch nasMessage.GmmMessage.GetMessageType() {
+	case nas.MsgTypeRegistrationRequest:
+		ranUe.SetMobileIdentity5GS(nasMessage.GmmMessage.RegistrationRequest.MobileIdentity5GS)
+		g.NasLog.Debugf("Receive UE %s registration request from UE", ranUe.GetMobileIdentityIMSI())
+	case nas.MsgTypeServiceRequest:
+		tmsi5GS := nasMessage.GmmMessage.ServiceRequest.TMSI5GS
+		ranUe.SetMobileIdentity5GS(nasType.MobileIdentity5GS{Len: tmsi5GS.Len, Buffer: append([]byte{}, tmsi5GS.Octet[:tmsi5GS.Len]...)})
+		fiveGSTmsi = getFiveGSTmsi(tmsi5GS)
+		g.NasLog.Debugf("Receive UE %s service request from UE", ranUe.GetMobileIdentityIMSI())
+	default:
+		return fmt.Errorf("error decode ue initial nas from UE: message type %d", nasMessage.GmmMessage.GetMessageType())
+	}
+
+	if radioChannelProfile, exists := g.radioChannel.getUeProfile(ranUe.GetMobileIdentityIMSI()); exists {
+		ranUe.SetRadioChannelProfile(radioChannelProfile)
+		g.RanLog.Debugf("Applied radio channel profile of UE %s: %+v", ranUe.GetMobileIdentityIMSI(), radioChannelProfile)
 	}
-	ranUe.SetMobileIdentity5GS(nasMessage.GmmMessage.RegistrationRequest.MobileIdentity5GS)
-	g.NasLog.Debugf("Receive UE %s registration request from UE", ranUe.GetMobileIdentityIMSI())
 
-	ueInitialMessage, err := getInitialUeMessage(ranUe.GetRanUeId(), ueRegistrationRequest, g.plmnId, g.tai)
+	servingCell := ranUe.GetServingCell()
+	ueInitialMessage, err := getInitialUeMessage(ranUe.GetRanUeId(), ueInitialNas, fiveGSTmsi, servingCell.nrCgi, servingCell.tai)
 	if err != nil {
 		return fmt.Errorf("error get initial ue message: %v", err)
 	}
 	g.NgapLog.Tracef("Get initial UE message: %+v", ueInitialMessage)
 
-	if n, err = g.n2Conn.Write(ueInitialMessage); err != nil {
+	n, err := g.n2Conn.Write(ueInitialMessage)
+	if err != nil {
 		return fmt.Errorf("error send initial ue message to AMF: %v", err)
 	}
 	g.NgapLog.Tracef("Sent %d bytes of initial UE message to AMF", n)
 	g.NgapLog.Debugln("Sent initial UE message to AMF")
 
-	// receive nas authentication request from AMF and send to UE
-	ngapNasAuthenticationRequestRaw := make([]byte, 1024)
-	n, err = g.n2Conn.Read(ngapNasAuthenticationRequestRaw)
-	if err != nil {
-		return fmt.Errorf("error receive initial ue response from AMF: %v", err)
-	}
-	g.NgapLog.Tracef("Received %d bytes of NAS Authentication Request from AMF", n)
-	g.NgapLog.Debugln("Receive NAS Authentication Request from AMF")
-
-	ngapNasAuthenticationRequest, err := ngap.Decoder(ngapNasAuthenticationRequestRaw[:n])
-	if err != nil {
-		return fmt.Errorf("error decode nas authentication request from AMF: %v", err)
-	}
-	if ngapNasAuthenticationRequest.Present != ngapType.NGAPPDUPresentInitiatingMessage || ngapNasAuthenticationRequest.InitiatingMessage.ProcedureCode.Value != ngapType.ProcedureCodeDownlinkNASTransport {
-		return fmt.Errorf("error NGAP nas authentication request: %+v", ngapNasAuthenticationRequest)
-	}
-	g.NgapLog.Tracef("NGAP nas authentication request: %+v", ngapNasAuthenticationRequest)
+	// relay the authentication from AMF until the security mode command, either 5G AKA or EAP-AKA', the AMF authenticates
+	// the UE again after an authentication failure, e.g. with the AUTS of a synch failure, and may identify the UE in between,
+	// or sets up the initial context right away if it takes the native security context the UE registers with
+	var ngapInitialContextSetupRequest *ngapType.NGAPPDU
+	for {
+		nasPdu, initialContextSetupRequest, err := g.receiveInitialDownlinkNas(ranUe)
+		if err != nil {
+			return err
+		}
+		if initialContextSetupRequest != nil {
+			g.NasLog.Debugf("UE %s is accepted with its native NAS security context", ranUe.GetMobileIdentityIMSI())
+			ngapInitialContextSetupRequest = initialContextSetupRequest
+			break
+		}
 
-	var nasAuthenticationRequest []byte
-	for _, ie := range ngapNasAuthenticationRequest.InitiatingMessage.Value.DownlinkNASTransport.ProtocolIEs.List {
-		switch ie.Id.Value {
-		case ngapType.ProtocolIEIDAMFUENGAPID:
-			ranUe.SetAmfUeId(ie.Value.AMFUENGAPID.Value)
-			g.NgapLog.Tracef("Set AMF UE ID: %d", ranUe.GetAmfUeId())
-		case ngapType.ProtocolIEIDRANUENGAPID:
-			ranUe.SetRanUeId(ie.Value.RANUENGAPID.Value)
-			g.NgapLog.Tracef("Set RAN UE ID: %d", ranUe.GetRanUeId())
-		case ngapType.ProtocolIEIDNASPDU:
-			if ie.Value.NASPDU == nil {
-				return fmt.Errorf("error NGAP nas authentication request: NASPDU is nil")
+		n, err = ranUe.SendToUe(protocol.MESSAGE_TYPE_NAS, nasPdu)
+		if err != nil {
+			return fmt.Errorf("error send downlink nas to UE: %v", err)
+		}
+		g.NasLog.Tracef("Sent %d bytes of downlink NAS to UE", n)
+
+		// the security mode command is the first nas message integrity protected by AMF with a new security context,
+		// another protected one is the reject of a UE resuming its native security context, e.g. the service reject
+		securityHeaderType := nas.GetSecurityHeaderType(nasPdu)
+		if securityHeaderType == nas.SecurityHeaderTypeIntegrityProtectedWithNew5gNasSecurityContext {
+			g.NasLog.Debugln("Send NAS Security Mode Command to UE")
+			break
+		}
+		if securityHeaderType == nas.SecurityHeaderTypePlainNas && nasPdu[2] == nas.MsgTypeAuthenticationReject {
+			return fmt.Errorf("UE %s authentication rejected by AMF", ranUe.GetMobileIdentityIMSI())
+		}
+		if securityHeaderType != nas.SecurityHeaderTypePlainNas || nasPdu[2] == nas.MsgTypeRegistrationReject || nasPdu[2] == nas.MsgTypeServiceReject {
+			if err := g.processUeContextRelease(ranUe); err != nil {
+				return err
 			}
-			nasAuthenticationRequest = make([]byte, len(ie.Value.NASPDU.Value))
-			copy(nasAuthenticationRequest, ie.Value.NASPDU.Value)
-			g.NgapLog.Tracef("Get NASPDU: %+v", nasAuthenticationRequest)
+			return fmt.Errorf("UE %s rejected by AMF", ranUe.GetMobileIdentityIMSI())
 		}
-	}
+		g.NasLog.Debugf("Send NAS message type %d to UE", nasPdu[2])
 
-	n, err = ranUe.GetN1Conn().Write(nasAuthenticationRequest)
-	if err != nil {
-		return fmt.Errorf("error send nas authentication request to UE: %v", err)
-	}
-	g.NasLog.Tracef("Sent %d bytes of NAS Authentication Request to UE", n)
-	g.NasLog.Debugln("Send NAS Authentication Request to UE")
+		// the authentication result of EAP-AKA' is not answered by UE, the security mode command follows
+		if nasPdu[2] == nas.MsgTypeAuthenticationResult {
+			continue
+		}
 
-	// receive nas authentication response from UE and send to AMF
-	nasAuthenticationResponse := make([]byte, 1024)
-	n, err = ranUe.GetN1Conn().Read(nasAuthenticationResponse)
-	if err != nil {
-		return fmt.Errorf("error receive nas authentication response from UE: %v", err)
-	}
-	g.NasLog.Tracef("Received %d bytes of NAS Authentication Response from UE", n)
-	g.NasLog.Debugln("Receive NAS Authentication Response from UE")
+		uplinkNas, err := ranUe.ReceiveFromUe(protocol.MESSAGE_TYPE_NAS)
+		if err != nil {
+			return fmt.Errorf("error receive uplink nas from UE: %v", err)
+		}
+		g.NasLog.Tracef("Received %d bytes of uplink NAS from UE", len(uplinkNas))
 
-	uplinkNasTransport, err := getUplinkNasTransport(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), g.plmnId, g.tai, nasAuthenticationResponse[:n])
-	if err != nil {
-		return fmt.Errorf("error get uplink nas transport: %v", err)
-	}
-	g.NgapLog.Tracef("Get uplink NAS transport: %+v", uplinkNasTransport)
+		uplinkNasTransport, err := getUplinkNasTransport(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), servingCell.nrCgi, servingCell.tai, uplinkNas)
+		if err != nil {
+			return fmt.Errorf("error get uplink nas transport: %v", err)
+		}
+		g.NgapLog.Tracef("Get uplink NAS transport: %+v", uplinkNasTransport)
 
-	n, err = g.n2Conn.Write(uplinkNasTransport)
-	if err != nil {
-		return fmt.Errorf("error send uplink nas transport to AMF: %v", err)
+		n, err = g.n2Conn.Write(uplinkNasTransport)
+		if err != nil {
+			return fmt.Errorf("error send uplink nas transport to AMF: %v", err)
+		}
+		g.NgapLog.Tracef("Sent %d bytes of uplink NAS transport to AMF", n)
+		g.NgapLog.Debugln("Sent uplink NAS transport to AMF")
 	}
-	g.NgapLog.Tracef("Sent %d bytes of uplink NAS transport to AMF", n)
-	g.NgapLog.Debugln("Sent uplink NAS transport to AMF")
 
-	// receive nas security mode command message from AMF and send to UE
-	ngapNasSecurityModeCommandRaw := make([]byte, 1024)
-	n, err = g.n2Conn.Read(ngapNasSecurityModeCommandRaw)
-	if err != nil {
-		return fmt.Errorf("error receive nas security mode command from AMF: %v", err)
-	}
-	g.NgapLog.Tracef("Received %d bytes of NAS Security Mode Command from AMF", n)
-	g.NgapLog.Debugf("Receive NAS Security Mode Command from AMF")
+	if ngapInitialContextSetupRequest == nil {
+		// receive nas security mode complete message from UE and send to AMF
+		nasSecurityModeComplete, err := ranUe.ReceiveFromUe(protocol.MESSAGE_TYPE_NAS)
+		if err != nil {
+			return fmt.Errorf("error receive nas security mode complete from UE: %v", err)
+		}
+		g.NasLog.Tracef("Received %d bytes of NAS Security Mode Complete from UE", len(nasSecurityModeComplete))
+		g.NasLog.Debugln("Receive NAS Security Mode Complete from UE")
 
-	ngapNasSecurityModeCommand, err := ngap.Decoder(ngapNasSecurityModeCommandRaw[:n])
-	if err != nil {
-		return fmt.Errorf("error decode nas security mode command from AMF: %v", err)
-	}
-	if ngapNasSecurityModeCommand.Present != ngapType.NGAPPDUPresentInitiatingMessage || ngapNasSecurityModeCommand.InitiatingMessage.ProcedureCode.Value != ngapType.ProcedureCodeDownlinkNASTransport {
-		return fmt.Errorf("error NGAP nas security mode command: %+v", ngapNasSecurityModeCommand)
-	}
-	g.NgapLog.Tracef("NGAP nas security mode command: %+v", ngapNasSecurityModeCommand)
+		uplinkNasTransport, err := getUplinkNasTransport(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), servingCell.nrCgi, servingCell.tai, nasSecurityModeComplete)
+		if err != nil {
+			return fmt.Errorf("error get uplink nas transport: %v", err)
+		}
+		g.NgapLog.Tracef("Get uplink NAS transport: %+v", uplinkNasTransport)
 
-	var nasSecurityModeCommand []byte
-	for _, ie := range ngapNasSecurityModeCommand.InitiatingMessage.Value.DownlinkNASTransport.ProtocolIEs.List {
-		switch ie.Id.Value {
-		case ngapType.ProtocolIEIDAMFUENGAPID:
-		case ngapType.ProtocolIEIDRANUENGAPID:
-		case ngapType.ProtocolIEIDNASPDU:
-			if ie.Value.NASPDU == nil {
-				return fmt.Errorf("error NGAP nas security mode command: NASPDU is nil")
+		n, err = g.n2Conn.Write(uplinkNasTransport)
+		if err != nil {
+			return fmt.Errorf("error send uplink nas transport to AMF: %v", err)
+		}
+		g.NgapLog.Tracef("Sent %d bytes of uplink NAS transport to AMF", n)
+		g.NgapLog.Debugln("Sent uplink NAS transport to AMF")
+
+		// the UE rejects a security mode command of algorithms it does not enable, and AMF releases the UE context
+		if len(nasSecurityModeComplete) > 2 && nas.GetSecurityHeaderType(nasSecurityModeComplete) == nas.SecurityHeaderTypePlainNas && nasSecurityModeComplete[2] == nas.MsgTypeSecurityModeReject {
+			if err := g.processUeContextRelease(ranUe); err != nil {
+				return err
 			}
-			nasSecurityModeCommand = make([]byte, len(ie.Value.NASPDU.Value))
-			copy(nasSecurityModeCommand, ie.Value.NASPDU.Value)
-			g.NgapLog.Tracef("Get NASPDU: %+v", nasSecurityModeCommand)
+			return fmt.Errorf("UE %s rejected the security mode command", ranUe.GetMobileIdentityIMSI())
 		}
-	}
 
-	if n, err = ranUe.GetN1Conn().Write(nasSecurityModeCommand); err != nil {
-		return fmt.Errorf("error send nas security mode command to UE: %v", err)
-	}
-	g.NasLog.Tracef("Sent %d bytes of NAS Security Mode Command to UE", n)
-	g.NasLog.Debugln("Send NAS Security Mode Command to UE")
+		// receive ngap initial context setup request from AMF, or the registration reject AMF sends instead
+		nasPdu, initialContextSetupRequest, err := g.receiveInitialDownlinkNas(ranUe)
+		if err != nil {
+			return fmt.Errorf("error receive ngap initial context setup request from AMF: %v", err)
+		}
+		if initialContextSetupRequest == nil {
+			n, err = ranUe.SendToUe(protocol.MESSAGE_TYPE_NAS, nasPdu)
+			if err != nil {
+				return fmt.Errorf("error send downlink nas to UE: %v", err)
+			}
+			g.NasLog.Tracef("Sent %d bytes of downlink NAS to UE", n)
 
-	// receive nas security mode complete message from UE and send to AMF
-	nasSecurityModeComplete := make([]byte, 1024)
-	n, err = ranUe.GetN1Conn().Read(nasSecurityModeComplete)
-	if err != nil {
-		return fmt.Errorf("error receive nas security mode complete from UE: %v", err)
+			if err := g.processUeContextRelease(ranUe); err != nil {
+				return err
+			}
+			return fmt.Errorf("UE %s rejected by AMF after security mode", ranUe.GetMobileIdentityIMSI())
+		}
+		ngapInitialContextSetupRequest = initialContextSetupRequest
 	}
-	g.NasLog.Tracef("Received %d bytes of NAS Security Mode Complete from UE", n)
-	g.NasLog.Debugln("Receive NAS Security Mode Complete from UE")
+	g.NgapLog.Tracef("NGAP Initial Context Setup Request: %+v", ngapInitialContextSetupRequest)
+	g.NgapLog.Debugln("Receive NGAP Initial Context Setup Request from AMF")
 
-	uplinkNasTransport, err = getUplinkNasTransport(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), g.plmnId, g.tai, nasSecurityModeComplete[:n])
+	// activate AS security with the security key in initial context setup request
+	kGnb, ueSecurityCapabilities, err := getAsSecurityFromInitialContextSetupRequest(ngapInitialContextSetupRequest.InitiatingMessage.Value.InitialContextSetupRequest)
 	if err != nil {
-		return fmt.Errorf("error get uplink nas transport: %v", err)
+		return fmt.Errorf("error get as security from ngap initial context setup request: %v", err)
 	}
-	g.NgapLog.Tracef("Get uplink NAS transport: %+v", uplinkNasTransport)
-
-	n, err = g.n2Conn.Write(uplinkNasTransport)
-	if err != nil {
-		return fmt.Errorf("error send uplink nas transport to AMF: %v", err)
+	if err := g.processRrcSecurityMode(ranUe, kGnb, ueSecurityCapabilities); err != nil {
+		return fmt.Errorf("error process rrc security mode: %v", err)
 	}
-	g.NgapLog.Tracef("Sent %d bytes of uplink NAS transport to AMF", n)
-	g.NgapLog.Debugln("Sent uplink NAS transport to AMF")
 
-	// receive ngap initial context setup request from AMF
-	ngapInitialContextSetupRequestRaw := make([]byte, 1024)
-	n, err = g.n2Conn.Read(ngapInitialContextSetupRequestRaw)
-	if err != nil {
-		return fmt.Errorf("error receive ngap initial context setup request from AMF: %v", err)
+	// send the registration accept or the service accept in initial context setup request to UE once AS security is activated
+	if nasAccept := getNasPduFromInitialContextSetupRequest(ngapInitialContextSetupRequest.InitiatingMessage.Value.InitialContextSetupRequest); nasAccept != nil {
+		n, err = ranUe.SendToUe(protocol.MESSAGE_TYPE_NAS, nasAccept)
+		if err != nil {
+			return fmt.Errorf("error send nas accept to UE: %v", err)
+		}
+		g.NasLog.Tracef("Sent %d bytes of NAS Accept to UE", n)
+		g.NasLog.Debugln("Send NAS Accept to UE")
 	}
-	g.NgapLog.Tracef("Received %d bytes of NGAP Initial Context Setup Request from AMF", n)
 
-	ngapInitialContextSetupRequest, err := ngap.Decoder(ngapInitialContextSetupRequestRaw[:n])
-	if err != nil {
-		return fmt.Errorf("error decode ngap initial context setup request from AMF: %v", err)
+	// set up the pdu sessions of the initial context setup request, which a service request reactivates the user plane of
+	sessions := make([]*pduSession, 0)
+	pduSessionResourceSetupItems := make([]ngapType.PDUSessionResourceSetupItemCxtRes, 0)
+	releaseSessions := func() {
+		for _, session := range sessions {
+			g.releasePduSessionTunnel(session)
+		}
 	}
-	if ngapInitialContextSetupRequest.Present != ngapType.NGAPPDUPresentInitiatingMessage || ngapInitialContextSetupRequest.InitiatingMessage.ProcedureCode.Value != ngapType.ProcedureCodeInitialContextSetup {
-		return fmt.Errorf("error ngap initial context setup request: no initial context setup request")
+	for _, item := range getPduSessionResourceSetupListFromInitialContextSetupRequest(ngapInitialContextSetupRequest.InitiatingMessage.Value.InitialContextSetupRequest) {
+		var nasPdu []byte
+		if item.NASPDU != nil {
+			nasPdu = item.NASPDU.Value
+		}
+		session, ngapPduSessionResourceSetupResponseTransfer, err := g.setupPduSessionBearer(ranUe, uint8(item.PDUSessionID.Value), nasPdu, item.PDUSessionResourceSetupRequestTransfer, nil)
+		if err != nil {
+			releaseSessions()
+			return fmt.Errorf("error setup pdu session %d: %v", item.PDUSessionID.Value, err)
+		}
+		sessions = append(sessions, session)
+		pduSessionResourceSetupItems = append(pduSessionResourceSetupItems, ngapType.PDUSessionResourceSetupItemCxtRes{
+			PDUSessionID:                            item.PDUSessionID,
+			PDUSessionResourceSetupResponseTransfer: ngapPduSessionResourceSetupResponseTransfer,
+		})
 	}
-	g.NgapLog.Tracef("NGAP Initial Context Setup Request: %+v", ngapInitialContextSetupRequest)
-	g.NgapLog.Debugln("Receive NGAP Initial Context Setup Request from AMF")
 
 	// send ngap initial context setup response to AMF
-	ngapInitialContextSetupResponse, err := getNgapInitialContextSetupResponse(ranUe.GetAmfUeId(), ranUe.GetRanUeId())
+	ngapInitialContextSetupResponse, err := getNgapInitialContextSetupResponse(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), pduSessionResourceSetupItems)
 	if err != nil {
+		releaseSessions()
 		return fmt.Errorf("error get ngap initial context setup response: %v", err)
 	}
 	g.NgapLog.Tracef("Get NGAP Initial Context Setup Response: %+v", ngapInitialContextSetupResponse)
 
 	n, err = g.n2Conn.Write(ngapInitialContextSetupResponse)
 	if err != nil {
+		releaseSessions()
 		return fmt.Errorf("error send ngap initial context setup response to AMF: %v", err)
 	}
 	g.NgapLog.Tracef("Sent %d bytes of NGAP Initial Context Setup Response to AMF", n)
 	g.NgapLog.Debugln("Send NGAP Initial Context Setup Response to AMF")
 
+	for _, session := range sessions {
+		g.addPduSession(ranUe, session)
+	}
+
+	// the service accept is not answered by UE
+	if serviceRequest {
+		g.RanLog.Infof("UE %s initialized by service request", ranUe.GetMobileIdentityIMSI())
+		return nil
+	}
+
 	// receive nas registration complete message from UE and send to AMF
-	nasRegistrationComplete := make([]byte, 1024)
-	n, err = ranUe.GetN1Conn().Read(nasRegistrationComplete)
+	nasRegistrationComplete, err := ranUe.ReceiveFromUe(protocol.MESSAGE_TYPE_NAS)
 	if err != nil {
 		return fmt.Errorf("error receive nas registration complete from UE: %v", err)
 	}
-	g.NasLog.Tracef("Received %d bytes of NAS Registration Complete from UE", n)
+	g.NasLog.Tracef("Received %d bytes of NAS Registration Complete from UE", len(nasRegistrationComplete))
 	g.NasLog.Debugln("Receive NAS Registration Complete from UE")
 
-	uplinkNasTransport, err = getUplinkNasTransport(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), g.plmnId, g.tai, nasRegistrationComplete[:n])
+	uplinkNasTransport, err := getUplinkNasTransport(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), servingCell.nrCgi, servingCell.tai, nasRegistrationComplete)
 	if err != nil {
 		return fmt.Errorf("error get uplink nas transport: %v", err)
 	}
@@ -901,151 +1372,293 @@ func (g *Gnb) processUeInitialization(ranUe *RanUe) error {
 	g.NgapLog.Tracef("Sent %d bytes of uplink NAS transport to AMF", n)
 	g.NgapLog.Debugln("Send NAS Registration Complete to AMF")
 
-	// receive ue configuration update command message from AMF
-	ueConfigurationUpdateCommandRaw := make([]byte, 1024)
-	n, err = g.n2Conn.Read(ueConfigurationUpdateCommandRaw)
+	g.RanLog.Infof("UE %s initialized", ranUe.GetMobileIdentityIMSI())
+	return nil
+}
+
+// receiveInitialDownlinkNas reads the downlink nas transport of the UE during initialization from AMF,
+// the first one gives the AMF UE NGAP ID of the UE, and an initial context setup request in its place is returned as it is
+func (g *Gnb) receiveInitialDownlinkNas(ranUe *RanUe) ([]byte, *ngapType.NGAPPDU, error) {
+	n2Message, err := g.receiveN2Message(ranUe)
 	if err != nil {
-		return fmt.Errorf("error receive ue configuration update command from AMF: %v", err)
+		return nil, nil, fmt.Errorf("error receive downlink nas transport from AMF: %v", err)
+	}
+	ngapDownlinkNasTransport := n2Message.pdu
+	if ngapDownlinkNasTransport.Present == ngapType.NGAPPDUPresentInitiatingMessage && ngapDownlinkNasTransport.InitiatingMessage.ProcedureCode.Value == ngapType.ProcedureCodeInitialContextSetup {
+		for _, ie := range ngapDownlinkNasTransport.InitiatingMessage.Value.InitialContextSetupRequest.ProtocolIEs.List {
+			if ie.Id.Value == ngapType.ProtocolIEIDAMFUENGAPID {
+				ranUe.SetAmfUeId(ie.Value.AMFUENGAPID.Value)
+				g.NgapLog.Tracef("Set AMF UE ID: %d", ranUe.GetAmfUeId())
+			}
+		}
+		return nil, ngapDownlinkNasTransport, nil
+	}
+	if ngapDownlinkNasTransport.Present != ngapType.NGAPPDUPresentInitiatingMessage || ngapDownlinkNasTransport.InitiatingMessage.ProcedureCode.Value != ngapType.ProcedureCodeDownlinkNASTransport {
+		return nil, nil, fmt.Errorf("error NGAP downlink nas transport: %+v", ngapDownlinkNasTransport)
+	}
+	g.NgapLog.Tracef("NGAP downlink nas transport: %+v", ngapDownlinkNasTransport)
+
+	var nasPdu []byte
+	for _, ie := range ngapDownlinkNasTransport.InitiatingMessage.Value.DownlinkNASTransport.ProtocolIEs.List {
+		switch ie.Id.Value {
+		case ngapType.ProtocolIEIDAMFUENGAPID:
+			ranUe.SetAmfUeId(ie.Value.AMFUENGAPID.Value)
+			g.NgapLog.Tracef("Set AMF UE ID: %d", ranUe.GetAmfUeId())
+		case ngapType.ProtocolIEIDRANUENGAPID:
+			ranUe.SetRanUeId(ie.Value.RANUENGAPID.Value)
+			g.NgapLog.Tracef("Set RAN UE ID: %d", ranUe.GetRanUeId())
+		case ngapType.ProtocolIEIDNASPDU:
+			if ie.Value.NASPDU == nil {
+				return nil, nil, fmt.Errorf("error NGAP downlink nas transport: NASPDU is nil")
+			}
+			nasPdu = make([]byte, len(ie.Value.NASPDU.Value))
+			copy(nasPdu, ie.Value.NASPDU.Value)
+			g.NgapLog.Tracef("Get NASPDU: %+v", nasPdu)
+		}
+	}
+	if len(nasPdu) < 3 {
+		return nil, nil, fmt.Errorf("error NGAP downlink nas transport: NASPDU of %d bytes", len(nasPdu))
 	}
-	g.NgapLog.Tracef("Received %d bytes of UE Configuration Update Command from AMF", n)
+	g.NgapLog.Debugln("Receive downlink NAS transport from AMF")
+	return nasPdu, nil, nil
+}
 
-	ueConfigurationUpdateCommand, err := ngap.Decoder(ueConfigurationUpdateCommandRaw[:n])
+// processUeContextRelease answers the UE context release command of AMF with the release complete
+func (g *Gnb) processUeContextRelease(ranUe *RanUe) error {
+	// receive ngap ue context release command from AMF
+	n2Message, err := g.receiveN2Message(ranUe)
 	if err != nil {
-		return fmt.Errorf("error decode ue configuration update command from AMF: %v", err)
+		return fmt.Errorf("error receive ngap ue context release command from AMF: %v", err)
 	}
-	if ueConfigurationUpdateCommand.Present != ngapType.NGAPPDUPresentInitiatingMessage || ueConfigurationUpdateCommand.InitiatingMessage.ProcedureCode.Value != ngapType.ProcedureCodeDownlinkNASTransport {
-		return fmt.Errorf("error ue configuration update command: no ue configuration update command")
+	ngapUeContextReleaseCommand := n2Message.pdu
+	if ngapUeContextReleaseCommand.Present != ngapType.NGAPPDUPresentInitiatingMessage || ngapUeContextReleaseCommand.InitiatingMessage.ProcedureCode.Value != ngapType.ProcedureCodeUEContextRelease {
+		return fmt.Errorf("error ngap ue context release command: %+v", ngapUeContextReleaseCommand)
 	}
-	g.NgapLog.Tracef("UE Configuration Update Command: %+v", ueConfigurationUpdateCommand)
-	g.NgapLog.Debugln("Receive UE Configuration Update Command from AMF")
 
-	g.RanLog.Infof("UE %s initialized", ranUe.GetMobileIdentityIMSI())
-	return nil
+	return g.completeUeContextRelease(ranUe, ngapUeContextReleaseCommand)
 }
 
-func (g *Gnb) processUePduSessionEstablishment(ranUe *RanUe, pduSessionResourceSetupRequestTransfer *ngapType.PDUSessionResourceSetupRequestTransfer) error {
-	g.NgapLog.Infof("Processing UE %s PDU session establishment", ranUe.GetMobileIdentityIMSI())
+// completeUeContextRelease sends the release complete of the UE context release command to AMF
+func (g *Gnb) completeUeContextRelease(ranUe *RanUe, ngapUeContextReleaseCommand *ngapType.NGAPPDU) error {
+	g.NgapLog.Tracef("NGAP UE Context Release Command: %+v", ngapUeContextReleaseCommand)
+	g.NgapLog.Debugln("Receive NGAP UE Context Release Command from AMF")
 
-	// receive pdu session establishment request from UE and send to AMF
-	pduSessionEstablishmentRequest := make([]byte, 1024)
-	n, err := ranUe.GetN1Conn().Read(pduSessionEstablishmentRequest)
-	if err != nil {
-		return fmt.Errorf("error receive pdu session establishment request from UE: %v", err)
+	// send ngap ue context release complete to AMF
+	pduSessionIds := make([]int64, 0)
+	for _, session := range ranUe.GetPduSessionList() {
+		pduSessionIds = append(pduSessionIds, int64(session.GetPduSessionId()))
 	}
-	g.NasLog.Tracef("Received %d bytes of PDU Session Establishment Request from UE", n)
-	g.NasLog.Debugln("Receive PDU Session Establishment Request from UE")
-
-	uplinkNasTransport, err := getUplinkNasTransport(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), g.plmnId, g.tai, pduSessionEstablishmentRequest[:n])
+	servingCell := ranUe.GetServingCell()
+	ngapUeContextReleaseCompleteMessage, err := getNgapUeContextReleaseCompleteMessage(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), pduSessionIds, servingCell.nrCgi, servingCell.tai)
 	if err != nil {
-		return fmt.Errorf("error get uplink nas transport: %v", err)
+		return fmt.Errorf("error get ngap ue context release complete message: %v", err)
 	}
-	g.NgapLog.Tracef("Get uplink NAS transport: %+v", uplinkNasTransport)
+	g.NgapLog.Tracef("Get NGAP UE Context Release Complete Message: %+v", ngapUeContextReleaseCompleteMessage)
 
-	n, err = g.n2Conn.Write(uplinkNasTransport)
+	n, err := g.n2Conn.Write(ngapUeContextReleaseCompleteMessage)
 	if err != nil {
-		return fmt.Errorf("error send uplink nas transport to AMF: %v", err)
+		return fmt.Errorf("error send ngap ue context release complete message to AMF: %v", err)
 	}
-	g.NgapLog.Tracef("Sent %d bytes of uplink NAS transport to AMF", n)
-	g.NgapLog.Debugln("Send PDU Session Establishment Request to AMF")
+	g.NgapLog.Tracef("Sent %d bytes of NGAP UE Context Release Complete Message to AMF", n)
+	g.NgapLog.Debugln("Send NGAP UE Context Release Complete Message to AMF")
+	return nil
+}
 
-	// receive ngap pdu session resource setup request from AMF
-	ngapPduSessionResourceSetupRequestRaw := make([]byte, 1024)
-	n, err = g.n2Conn.Read(ngapPduSessionResourceSetupRequestRaw)
-	if err != nil {
-		return fmt.Errorf("error receive ngap pdu session resource setup request from AMF: %v", err)
-	}
-	g.NgapLog.Tracef("Received %d bytes of NGAP PDU Session Resource Setup Request from AMF", n)
+// processUePduSessionEstablishment sets up the pdu sessions of the pdu session resource setup request from AMF,
+// each with its own N3 tunnel and data radio bearer, and the first pdu session of the UE is split by NR-DC if activated
+func (g *Gnb) processUePduSessionEstablishment(ranUe *RanUe, ngapPduSessionResourceSetupRequestRaw []byte, ngapPduSessionResourceSetupRequest *ngapType.NGAPPDU) error {
+	g.NgapLog.Infof("Processing UE %s PDU session establishment", ranUe.GetMobileIdentityIMSI())
 	g.NgapLog.Debugln("Receive NGAP PDU Session Resource Setup Request from AMF")
 
-	ngapPduSessionResourceSetupRequest, err := ngap.Decoder(ngapPduSessionResourceSetupRequestRaw[:n])
-	if err != nil {
-		return fmt.Errorf("error decode ngap pdu session resource setup request from AMF: %v", err)
-	}
-	if ngapPduSessionResourceSetupRequest.Present != ngapType.NGAPPDUPresentInitiatingMessage || ngapPduSessionResourceSetupRequest.InitiatingMessage.ProcedureCode.Value != ngapType.ProcedureCodePDUSessionResourceSetup {
-		return fmt.Errorf("error ngap pdu session resource setup request: no pdu session resource setup request")
-	}
-	g.NgapLog.Tracef("NGAP PDU Session Resource Setup Request: %+v", ngapPduSessionResourceSetupRequest)
-
-	var nasPduSessionEstablishmentAccept []byte
 	for _, ie := range ngapPduSessionResourceSetupRequest.InitiatingMessage.Value.PDUSessionResourceSetupRequest.ProtocolIEs.List {
 		switch ie.Id.Value {
 		case ngapType.ProtocolIEIDAMFUENGAPID:
 		case ngapType.ProtocolIEIDRANUENGAPID:
 		case ngapType.ProtocolIEIDPDUSessionResourceSetupListSUReq:
 			for _, pduSessionResourceSetupItem := range ie.Value.PDUSessionResourceSetupListSUReq.List {
-				nasPduSessionEstablishmentAccept = make([]byte, len(pduSessionResourceSetupItem.PDUSessionNASPDU.Value))
-				copy(nasPduSessionEstablishmentAccept, pduSessionResourceSetupItem.PDUSessionNASPDU.Value)
-				g.NgapLog.Tracef("Get NASPDU: %+v", nasPduSessionEstablishmentAccept)
-
-				if err := aper.UnmarshalWithParams(pduSessionResourceSetupItem.PDUSessionResourceSetupRequestTransfer, pduSessionResourceSetupRequestTransfer, "valueExt"); err != nil {
-					return fmt.Errorf("error unmarshal pdu session resource setup request transfer: %v", err)
+				if err := g.setupPduSessionResource(ranUe, &pduSessionResourceSetupItem, ngapPduSessionResourceSetupRequestRaw); err != nil {
+					return fmt.Errorf("error setup pdu session %d: %v", pduSessionResourceSetupItem.PDUSessionID.Value, err)
 				}
-				g.NgapLog.Tracef("Get PDUSessionResourceSetupRequestTransfer: %+v", pduSessionResourceSetupRequestTransfer)
 			}
 		case ngapType.ProtocolIEIDUEAggregateMaximumBitRate:
 		}
 	}
 
-	var qosFlowPerTNLInformationItem ngapType.QosFlowPerTNLInformationItem
-	if ranUe.IsNrdcActivated() {
-		if qosFlowPerTNLInformationItem, err = g.xnPduSessionResourceSetupRequestTransfer(ranUe.GetMobileIdentityIMSI(), ngapPduSessionResourceSetupRequestRaw[:n]); err != nil {
-			g.XnLog.Warnf("Error xn pdu session resource setup request transfer: %v", err)
-		}
-	}
+	g.NgapLog.Infof("UE %s PDU session establishment completed", ranUe.GetMobileIdentityIMSI())
+	return nil
+}
 
-	n, err = ranUe.GetN1Conn().Write(nasPduSessionEstablishmentAccept)
+func (g *Gnb) setupPduSessionResource(ranUe *RanUe, pduSessionResourceSetupItem *ngapType.PDUSessionResourceSetupItemSUReq, ngapPduSessionResourceSetupRequestRaw []byte) error {
+	pduSessionId := uint8(pduSessionResourceSetupItem.PDUSessionID.Value)
+	session, ngapPduSessionResourceSetupResponseTransfer, err := g.setupPduSessionBearer(ranUe, pduSessionId, pduSessionResourceSetupItem.PDUSessionNASPDU.Value, pduSessionResourceSetupItem.PDUSessionResourceSetupRequestTransfer, ngapPduSessionResourceSetupRequestRaw)
 	if err != nil {
-		return fmt.Errorf("error send nas pdu session establishment accept to UE: %v", err)
+		return err
 	}
-	g.NasLog.Tracef("Sent %d bytes of NAS PDU Session Establishment Accept to UE", n)
-	g.NasLog.Debugln("Send NAS PDU Session Establishment Accept to UE")
 
 	// send ngap pdu session resource setup response to AMF
-	ngapPduSessionResourceSetupResponseTransfer, err := getPduSessionResourceSetupResponseTransfer(ranUe.GetDlTeid(), g.ranN3Ip, 1, g.staticNrdc, qosFlowPerTNLInformationItem)
-	if err != nil {
-		return fmt.Errorf("error get pdu session resource setup response transfer: %v", err)
-	}
-	g.NgapLog.Tracef("Get pdu session resource setup response transfer: %+v", ngapPduSessionResourceSetupResponseTransfer)
-
-	ngapPduSessionResourceSetupResponse, err := getPduSessionResourceSetupResponse(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), constant.PDU_SESSION_ID, ngapPduSessionResourceSetupResponseTransfer)
+	ngapPduSessionResourceSetupResponse, err := getPduSessionResourceSetupResponse(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), int64(pduSessionId), ngapPduSessionResourceSetupResponseTransfer)
 	if err != nil {
+		g.releasePduSessionTunnel(session)
 		return fmt.Errorf("error get pdu session resource setup response: %v", err)
 	}
 	g.NgapLog.Tracef("Get pdu session resource setup response: %+v", ngapPduSessionResourceSetupResponse)
 
-	n, err = g.n2Conn.Write(ngapPduSessionResourceSetupResponse)
+	n, err := g.n2Conn.Write(ngapPduSessionResourceSetupResponse)
 	if err != nil {
+		g.releasePduSessionTunnel(session)
 		return fmt.Errorf("error send pdu session resource setup response to AMF: %v", err)
 	}
 	g.NgapLog.Tracef("Sent %d bytes of pdu session resource setup response to AMF", n)
 	g.NgapLog.Debugln("Send PDU Session Resource Setup Response to AMF")
 
-	g.NgapLog.Infof("UE %s PDU session establishment completed", ranUe.GetMobileIdentityIMSI())
+	g.addPduSession(ranUe, session)
 	return nil
 }
 
+// setupPduSessionBearer sets up the N3 tunnel and the data radio bearer of a pdu session and returns its setup response transfer,
+// the raw pdu session resource setup request is relayed to the secondary gNB, so a pdu session set up without it is not split by NR-DC
+func (g *Gnb) setupPduSessionBearer(ranUe *RanUe, pduSessionId uint8, nasPdu []byte, transfer aper.OctetString, ngapPduSessionResourceSetupRequestRaw []byte) (*pduSession, []byte, error) {
+	if _, exists := ranUe.GetPduSession(pduSessionId); exists {
+		return nil, nil, fmt.Errorf("pdu session %d already exists", pduSessionId)
+	}
+
+	nasPduSessionEstablishmentAccept := make([]byte, len(nasPdu))
+	copy(nasPduSessionEstablishmentAccept, nasPdu)
+	g.NgapLog.Tracef("Get NASPDU: %+v", nasPduSessionEstablishmentAccept)
+
+	pduSessionResourceSetupRequestTransfer := ngapType.PDUSessionResourceSetupRequestTransfer{}
+	if err := aper.UnmarshalWithParams(transfer, &pduSessionResourceSetupRequestTransfer, "valueExt"); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshal pdu session resource setup request transfer: %v", err)
+	}
+	g.NgapLog.Tracef("Get PDUSessionResourceSetupRequestTransfer: %+v", pduSessionResourceSetupRequestTransfer)
+
+	if err := checkUlNgUUpTnlInformation(&pduSessionResourceSetupRequestTransfer); err != nil {
+		return nil, nil, fmt.Errorf("error pdu session resource setup request transfer: %v", err)
+	}
+
+	session := newPduSession(pduSessionId, g.teidGenerator.AllocateTeid())
+	for _, item := range pduSessionResourceSetupRequestTransfer.ProtocolIEs.List {
+		switch item.Id.Value {
+		case ngapType.ProtocolIEIDPDUSessionAggregateMaximumBitRate:
+		case ngapType.ProtocolIEIDULNGUUPTNLInformation:
+			session.SetUlTeid(item.Value.ULNGUUPTNLInformation.GTPTunnel.GTPTEID.Value)
+			g.anchorPduSession(session, item.Value.ULNGUUPTNLInformation.GTPTunnel.TransportLayerAddress)
+			g.GtpLog.Debugf("UE %s PDU session %d anchored at UPF %s", ranUe.GetMobileIdentityIMSI(), pduSessionId, session.GetUpfN3Addr().String())
+		case ngapType.ProtocolIEIDAdditionalULNGUUPTNLInformation:
+		case ngapType.ProtocolIEIDPDUSessionType:
+		case ngapType.ProtocolIEIDQosFlowSetupRequestList:
+		}
+	}
+
+	// only the first pdu session of the UE is split by NR-DC
+	_, hasPrimaryPduSession := ranUe.GetPrimaryPduSession()
+	nrdc := ranUe.IsNrdcActivated() && !hasPrimaryPduSession && ngapPduSessionResourceSetupRequestRaw != nil
+
+	var qosFlowPerTNLInformationItem ngapType.QosFlowPerTNLInformationItem
+	var skCounter uint16
+	if nrdc {
+		var xnSecurity []byte
+		var err error
+		if skCounter, xnSecurity, err = getSecondaryNodeSecurity(ranUe); err != nil {
+			g.releasePduSessionTunnel(session)
+			return nil, nil, fmt.Errorf("error get secondary node security: %v", err)
+		}
+		if qosFlowPerTNLInformationItem, err = g.xnPduSessionResourceSetupRequestTransfer(ranUe.GetMobileIdentityIMSI(), ranUe.GetDataPlaneToken(), xnSecurity, ngapPduSessionResourceSetupRequestRaw); err != nil {
+			g.XnLog.Warnf("Error xn pdu session resource setup request transfer: %v", err)
+		}
+	}
+
+	// set up the data radio bearer of the pdu session with the nas pdu session establishment accept,
+	// the data radio bearer is identified by the pdu session id
+	scg := protocol.RRC_SCG_NONE
+	if nrdc {
+		scg = protocol.RRC_SCG_ADD
+	}
+	transactionId, err := g.sendRrcReconfiguration(ranUe, []uint8{pduSessionId}, nil, scg, skCounter, nasPduSessionEstablishmentAccept)
+	if err != nil {
+		g.releasePduSessionTunnel(session)
+		return nil, nil, fmt.Errorf("error send rrc reconfiguration to UE: %v", err)
+	}
+	if len(nasPduSessionEstablishmentAccept) > 0 {
+		g.NasLog.Debugln("Send NAS PDU Session Establishment Accept to UE")
+	}
+
+	if err := g.receiveRrcReconfigurationComplete(ranUe, transactionId); err != nil {
+		g.releasePduSessionTunnel(session)
+		return nil, nil, err
+	}
+
+	ngapPduSessionResourceSetupResponseTransfer, err := getPduSessionResourceSetupResponseTransfer(session.GetDlTeid(), g.ranN3Ip, 1, g.staticNrdc && nrdc, qosFlowPerTNLInformationItem)
+	if err != nil {
+		g.releasePduSessionTunnel(session)
+		return nil, nil, fmt.Errorf("error get pdu session resource setup response transfer: %v", err)
+	}
+	g.NgapLog.Tracef("Get pdu session resource setup response transfer: %+v", ngapPduSessionResourceSetupResponseTransfer)
+
+	return session, ngapPduSessionResourceSetupResponseTransfer, nil
+}
+
+// addPduSession adds the pdu session set up to the UE and routes its downlink tunnel to the UE
+func (g *Gnb) addPduSession(ranUe *RanUe, session *pduSession) {
+	pduSessionId := session.GetPduSessionId()
+	ranUe.AddPduSession(session)
+	g.dlTeidToUe.Store(teidToUint32(session.GetDlTeid()), &dlTunnel{ue: ranUe, pduSessionId: pduSessionId})
+	g.GtpLog.Debugf("Stored RAN UE %s PDU session %d with DL TEID %s to dlTeidToUe", ranUe.GetMobileIdentityIMSI(), pduSessionId, hex.EncodeToString(session.GetDlTeid()))
+	g.GtpLog.Debugf("PDU session %d DL TEID: %s, UL TEID: %s", pduSessionId, hex.EncodeToString(session.GetDlTeid()), hex.EncodeToString(session.GetUlTeid()))
+}
+
 func (g *Gnb) processUePduSessionModifyIndication(ranUe *RanUe) error {
 	g.NgapLog.Infoln("Processing UE PDU Session Modify Indication")
 
-	pduSessionModifyIndicationTransfer, err := getPDUSessionResourceModifyIndicationTransfer(ranUe.GetDlTeid(), g.ranN3Ip, 1)
+	if ranUe.GetRrcState() != protocol.RRC_STATE_CONNECTED {
+		return fmt.Errorf("UE %s is in %s", ranUe.GetMobileIdentityIMSI(), ranUe.GetRrcState())
+	}
+	if !ranUe.IsNrdcActivated() {
+		if err := g.checkScgAddition(ranUe); err != nil {
+			return fmt.Errorf("error check scg addition: %v", err)
+		}
+	}
+
+	// NR-DC splits the primary pdu session of the UE
+	primaryPduSession, exists := ranUe.GetPrimaryPduSession()
+	if !exists {
+		return fmt.Errorf("UE %s has no pdu session", ranUe.GetMobileIdentityIMSI())
+	}
+
+	pduSessionModifyIndicationTransfer, err := getPDUSessionResourceModifyIndicationTransfer(primaryPduSession.GetDlTeid(), g.ranN3Ip, 1)
 	if err != nil {
 		return fmt.Errorf("error get pdu session modify indication transfer: %v", err)
 	}
 	g.NgapLog.Tracef("Get pdu session modify indication transfer: %+v", pduSessionModifyIndicationTransfer)
 
 	// send ngap pdu session resource modify indication to AMF
-	pduSessionModifyIndication, err := getPDUSessionResourceModifyIndication(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), constant.PDU_SESSION_ID, pduSessionModifyIndicationTransfer)
+	pduSessionModifyIndication, err := getPDUSessionResourceModifyIndication(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), int64(primaryPduSession.GetPduSessionId()), pduSessionModifyIndicationTransfer)
 	if err != nil {
 		return fmt.Errorf("error get pdu session modify indication: %v", err)
 	}
 	g.NgapLog.Tracef("Get pdu session modify indication: %+v", pduSessionModifyIndication)
 
-	if pduSessionModifyIndication, err = g.xnPduSessionResourceModifyIndication(ranUe.GetMobileIdentityIMSI(), pduSessionModifyIndication); err != nil {
+	// the secondary gNB takes K_SN when the secondary cell group is added
+	var skCounter uint16
+	var xnSecurity []byte
+	if !ranUe.IsNrdcActivated() {
+		if skCounter, xnSecurity, err = getSecondaryNodeSecurity(ranUe); err != nil {
+			return fmt.Errorf("error get secondary node security: %v", err)
+		}
+	}
+
+	if pduSessionModifyIndication, err = g.xnPduSessionResourceModifyIndication(ranUe.GetMobileIdentityIMSI(), ranUe.GetDataPlaneToken(), xnSecurity, pduSessionModifyIndication); err != nil {
 		g.XnLog.Errorf("Error xn pdu session resource modify indication: %v", err)
 		return fmt.Errorf("error xn pdu session resource modify indication: %v", err)
 	}
 	g.XnLog.Tracef("Get pdu session modify indication: %+v", pduSessionModifyIndication)
 
+	// drop a stale confirm of an indication given up before
+	select {
+	case <-ranUe.pduSessionModifyConfirm:
+	default:
+	}
+
 	n, err := g.n2Conn.Write(pduSessionModifyIndication)
 	if err != nil {
 		return fmt.Errorf("error send pdu session modify indication to AMF: %v", err)
@@ -1053,19 +1666,14 @@ func (g *Gnb) processUePduSessionModifyIndication(ranUe *RanUe) error {
 	g.NgapLog.Tracef("Sent %d bytes of pdu session modify indication to AMF", n)
 	g.NgapLog.Debugln("Send PDU Session Modify Indication to AMF")
 
-	// receive ngap pdu session resource setup request from AMF
-	ngapPduSessionResourceModifyConfirmRaw := make([]byte, 1024)
-	n, err = g.n2Conn.Read(ngapPduSessionResourceModifyConfirmRaw)
+	// receive ngap pdu session resource modify confirm from AMF
+	n2Message, err := g.receivePduSessionModifyConfirm(ranUe)
 	if err != nil {
 		return fmt.Errorf("error receive ngap pdu session resource modify confirm from AMF: %v", err)
 	}
-	g.NgapLog.Tracef("Received %d bytes of NGAP PDU Session Resource Modify Confirm from AMF", n)
 	g.NgapLog.Debugln("Receive NGAP PDU Session Resource Modify Confirm from AMF")
 
-	ngapPduSessionResourceModifyConfirm, err := ngap.Decoder(ngapPduSessionResourceModifyConfirmRaw[:n])
-	if err != nil {
-		return fmt.Errorf("error decode ngap pdu session resource modify confirm from AMF: %v", err)
-	}
+	ngapPduSessionResourceModifyConfirm := n2Message.pdu
 	if ngapPduSessionResourceModifyConfirm.Present != ngapType.NGAPPDUPresentSuccessfulOutcome || ngapPduSessionResourceModifyConfirm.SuccessfulOutcome.ProcedureCode.Value != ngapType.ProcedureCodePDUSessionResourceModifyIndication {
 		return fmt.Errorf("error ngap pdu session resource modify confirm: no pdu session resource modify confirm")
 	}
@@ -1085,22 +1693,25 @@ func (g *Gnb) processUePduSessionModifyIndication(ranUe *RanUe) error {
 
 	// send confirm to Xm for update xnUE ULTEID
 	if !ranUe.IsNrdcActivated() {
-		if _, err = g.xnPduSessionResourceModifyConfirm(ranUe.GetMobileIdentityIMSI(), ngapPduSessionResourceModifyConfirmRaw[:n]); err != nil {
+		if _, err = g.xnPduSessionResourceModifyConfirm(ranUe.GetMobileIdentityIMSI(), n2Message.raw); err != nil {
 			g.XnLog.Errorf("Error xn pdu session resource modify confirm: %v", err)
 			return fmt.Errorf("error xn pdu session resource modify confirm: %v", err)
 		}
 		g.XnLog.Debugln("XN PDU Session Resource Modify Confirm sent")
 	}
 
-	// send modify message to UE
-	modifyMessage := []byte(constant.UE_TUNNEL_UPDATE)
-
-	n, err = ranUe.GetN1Conn().Write(modifyMessage)
+	// add or release the secondary cell group at UE
+	scg := protocol.RRC_SCG_ADD
+	if ranUe.IsNrdcActivated() {
+		scg = protocol.RRC_SCG_RELEASE
+	}
+	transactionId, err := g.sendRrcReconfiguration(ranUe, nil, nil, scg, skCounter, nil)
 	if err != nil {
-		return fmt.Errorf("error send modify message to UE: %v", err)
+		return fmt.Errorf("error send rrc reconfiguration to UE: %v", err)
+	}
+	if err := g.waitRrcReconfigurationComplete(ranUe, transactionId); err != nil {
+		return fmt.Errorf("error wait rrc reconfiguration complete: %v", err)
 	}
-	g.NasLog.Tracef("Sent %d bytes of modify message to UE", n)
-	g.NasLog.Debugln("Send Modify Message to UE")
 
 	// update ranUe NRDC status
 	if ranUe.IsNrdcActivated() {
@@ -1115,108 +1726,282 @@ func (g *Gnb) processUePduSessionModifyIndication(ranUe *RanUe) error {
 	return nil
 }
 
-func (g *Gnb) processUeDeRegistration(ranUe *RanUe) error {
-	g.RanLog.Infoln("Waiting for UE to deregister")
+// processUePduSessionRelease releases the N3 tunnels and data radio bearers of the pdu sessions in the release command,
+// relays the nas pdu session release command to the UE and forwards its release complete after the response to AMF
+func (g *Gnb) processUePduSessionRelease(ranUe *RanUe, ngapPduSessionResourceReleaseCommand *ngapType.NGAPPDU) error {
+	g.NgapLog.Infof("Processing UE %s PDU session release", ranUe.GetMobileIdentityIMSI())
+	g.NgapLog.Debugln("Receive NGAP PDU Session Resource Release Command from AMF")
 
-	// receive ue deregistration request from UE and send to AMF
-	ueDeRegistrationRequest := make([]byte, 1024)
-	n, err := ranUe.GetN1Conn().Read(ueDeRegistrationRequest)
+	var nasPduSessionReleaseCommand []byte
+	pduSessionIds := make([]uint8, 0)
+	for _, ie := range ngapPduSessionResourceReleaseCommand.InitiatingMessage.Value.PDUSessionResourceReleaseCommand.ProtocolIEs.List {
+		switch ie.Id.Value {
+		case ngapType.ProtocolIEIDAMFUENGAPID:
+		case ngapType.ProtocolIEIDRANUENGAPID:
+		case ngapType.ProtocolIEIDRANPagingPriority:
+		case ngapType.ProtocolIEIDNASPDU:
+			if ie.Value.NASPDU == nil {
+				return fmt.Errorf("error NGAP pdu session resource release command: NASPDU is nil")
+			}
+			nasPduSessionReleaseCommand = make([]byte, len(ie.Value.NASPDU.Value))
+			copy(nasPduSessionReleaseCommand, ie.Value.NASPDU.Value)
+			g.NgapLog.Tracef("Get NASPDU: %+v", nasPduSessionReleaseCommand)
+		case ngapType.ProtocolIEIDPDUSessionResourceToReleaseListRelCmd:
+			for _, pduSessionResourceToReleaseItem := range ie.Value.PDUSessionResourceToReleaseListRelCmd.List {
+				pduSessionIds = append(pduSessionIds, uint8(pduSessionResourceToReleaseItem.PDUSessionID.Value))
+			}
+		}
+	}
+
+	// the secondary cell group goes with the primary pdu session which NR-DC splits
+	scg := protocol.RRC_SCG_NONE
+	var splitPduSession *pduSession
+	releasedPduSessionIds := make([]int64, 0, len(pduSessionIds))
+	for _, pduSessionId := range pduSessionIds {
+		primaryPduSession, hasPrimaryPduSession := ranUe.GetPrimaryPduSession()
+		session, exists := ranUe.RemovePduSession(pduSessionId)
+		if !exists {
+			g.NgapLog.Warnf("UE %s has no PDU session %d to release", ranUe.GetMobileIdentityIMSI(), pduSessionId)
+			continue
+		}
+		if ranUe.IsNrdcActivated() && hasPrimaryPduSession && primaryPduSession == session {
+			scg, splitPduSession = protocol.RRC_SCG_RELEASE, session
+		}
+
+		g.dlTeidToUe.Delete(teidToUint32(session.GetDlTeid()))
+		g.releasePduSessionTunnel(session)
+		g.GtpLog.Debugf("Released UE %s PDU session %d with DL TEID %s", ranUe.GetMobileIdentityIMSI(), pduSessionId, hex.EncodeToString(session.GetDlTeid()))
+
+		releasedPduSessionIds = append(releasedPduSessionIds, int64(pduSessionId))
+	}
+
+	// release the data radio bearers of the pdu sessions with the nas pdu session release command
+	transactionId, err := g.sendRrcReconfiguration(ranUe, nil, pduSessionIds, scg, 0, nasPduSessionReleaseCommand)
 	if err != nil {
-		return fmt.Errorf("error reading from UE connection: %v", err)
+		return fmt.Errorf("error send rrc reconfiguration to UE: %v", err)
 	}
-	g.RanLog.Tracef("Received %d bytes of UE deregistration request from UE: %+v", n, ueDeRegistrationRequest[:n])
-	g.RanLog.Tracef("Received %d bytes of UE deregistration request from UE", n)
+	g.NasLog.Debugln("Send NAS PDU Session Release Command to UE")
 
-	uplinkNasTransport, err := getUplinkNasTransport(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), g.plmnId, g.tai, ueDeRegistrationRequest[:n])
+	if err := g.receiveRrcReconfigurationComplete(ranUe, transactionId); err != nil {
+		return err
+	}
+
+	if scg == protocol.RRC_SCG_RELEASE {
+		ranUe.DeactivateNrdc()
+		g.releaseSecondaryNode(ranUe, splitPduSession)
+		g.NgapLog.Infof("UE %s NRDC deactivated with its primary PDU session", ranUe.GetMobileIdentityIMSI())
+	}
+
+	// send ngap pdu session resource release response to AMF
+	ngapPduSessionResourceReleaseResponseTransfer, err := getPduSessionResourceReleaseResponseTransfer()
 	if err != nil {
-		return fmt.Errorf("error get uplink nas transport: %v", err)
+		return fmt.Errorf("error get pdu session resource release response transfer: %v", err)
 	}
-	g.NgapLog.Tracef("Get uplink NAS transport: %+v", uplinkNasTransport)
+	g.NgapLog.Tracef("Get pdu session resource release response transfer: %+v", ngapPduSessionResourceReleaseResponseTransfer)
 
-	n, err = g.n2Conn.Write(uplinkNasTransport)
+	ngapPduSessionResourceReleaseResponse, err := getPduSessionResourceReleaseResponse(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), releasedPduSessionIds, ngapPduSessionResourceReleaseResponseTransfer)
 	if err != nil {
-		return fmt.Errorf("error send uplink nas transport to AMF: %v", err)
+		return fmt.Errorf("error get pdu session resource release response: %v", err)
 	}
-	g.NgapLog.Tracef("Sent %d bytes of uplink NAS transport to AMF", n)
-	g.NgapLog.Debugln("Send UE deregistration request to AMF")
+	g.NgapLog.Tracef("Get pdu session resource release response: %+v", ngapPduSessionResourceReleaseResponse)
 
-	// receive ue deregistration accept from AMF
-	ngapUeDeRegistrationAcceptRaw := make([]byte, 1024)
-	n, err = g.n2Conn.Read(ngapUeDeRegistrationAcceptRaw)
+	n, err := g.n2Conn.Write(ngapPduSessionResourceReleaseResponse)
 	if err != nil {
-		return fmt.Errorf("error receive ue deregistration accept from AMF: %v", err)
+		return fmt.Errorf("error send pdu session resource release response to AMF: %v", err)
+	}
+	g.NgapLog.Tracef("Sent %d bytes of pdu session resource release response to AMF", n)
+	g.NgapLog.Debugln("Send PDU Session Resource Release Response to AMF")
+
+	// forward nas pdu session release complete to AMF
+	if len(nasPduSessionReleaseCommand) > 0 {
+		if err := g.forwardUeUplinkNas(ranUe); err != nil {
+			return fmt.Errorf("error forward nas pdu session release complete: %v", err)
+		}
+		g.NasLog.Debugln("Forward NAS PDU Session Release Complete to AMF")
 	}
-	g.NgapLog.Tracef("Received %d bytes of UE deregistration accept from AMF", n)
-	g.NgapLog.Debugln("Receive UE deregistration accept from AMF")
 
-	ngapUeDeRegistrationAccept, err := ngap.Decoder(ngapUeDeRegistrationAcceptRaw[:n])
+	g.NgapLog.Infof("UE %s PDU session release completed: %v", ranUe.GetMobileIdentityIMSI(), releasedPduSessionIds)
+	return nil
+}
+
+// releaseSecondaryNode has the secondary gNB release the XnUe of the split pdu session, with the modify indication
+// of the master tunnel only as NR-DC deactivation sends it, which is not sent to AMF as the session is gone
+func (g *Gnb) releaseSecondaryNode(ranUe *RanUe, session *pduSession) {
+	pduSessionModifyIndicationTransfer, err := getPDUSessionResourceModifyIndicationTransfer(session.GetDlTeid(), g.ranN3Ip, 1)
 	if err != nil {
-		return fmt.Errorf("error decode ue deregistration accept from AMF: %v", err)
+		g.XnLog.Warnf("Error get pdu session modify indication transfer: %v", err)
+		return
 	}
-	if ngapUeDeRegistrationAccept.Present != ngapType.NGAPPDUPresentInitiatingMessage || ngapUeDeRegistrationAccept.InitiatingMessage.ProcedureCode.Value != ngapType.ProcedureCodeDownlinkNASTransport {
-		return fmt.Errorf("error NGAP ue deregistration accept: %+v", ngapUeDeRegistrationAccept)
+	pduSessionModifyIndication, err := getPDUSessionResourceModifyIndication(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), int64(session.GetPduSessionId()), pduSessionModifyIndicationTransfer)
+	if err != nil {
+		g.XnLog.Warnf("Error get pdu session modify indication: %v", err)
+		return
+	}
+
+	if _, err := g.xnPduSessionResourceModifyIndication(ranUe.GetMobileIdentityIMSI(), nil, nil, pduSessionModifyIndication); err != nil {
+		g.XnLog.Warnf("Error release UE %s at the secondary gNB: %v", ranUe.GetMobileIdentityIMSI(), err)
+		return
 	}
-	g.NgapLog.Tracef("NGAP UE deregistration accept: %+v", ngapUeDeRegistrationAccept)
+	g.XnLog.Infof("UE %s released at the secondary gNB", ranUe.GetMobileIdentityIMSI())
+}
 
-	var nasUeDeRegistrationAccept []byte
-	for _, ie := range ngapUeDeRegistrationAccept.InitiatingMessage.Value.DownlinkNASTransport.ProtocolIEs.List {
+// processUePduSessionModification applies the UL tunnel changes of the pdu sessions in the modify request,
+// relays the nas pdu session modification command of each session to the UE and forwards its modification complete
+// after the response to AMF
+func (g *Gnb) processUePduSessionModification(ranUe *RanUe, ngapPduSessionResourceModifyRequest *ngapType.NGAPPDU) error {
+	g.NgapLog.Infof("Processing UE %s PDU session modification", ranUe.GetMobileIdentityIMSI())
+	g.NgapLog.Debugln("Receive NGAP PDU Session Resource Modify Request from AMF")
+
+	modifiedPduSessionIds, nasPduSessionModificationCommands := make([]int64, 0), 0
+	for _, ie := range ngapPduSessionResourceModifyRequest.InitiatingMessage.Value.PDUSessionResourceModifyRequest.ProtocolIEs.List {
 		switch ie.Id.Value {
 		case ngapType.ProtocolIEIDAMFUENGAPID:
 		case ngapType.ProtocolIEIDRANUENGAPID:
-		case ngapType.ProtocolIEIDNASPDU:
-			if ie.Value.NASPDU == nil {
-				return fmt.Errorf("error NGAP ue deregistration accept: NASPDU is nil")
+		case ngapType.ProtocolIEIDRANPagingPriority:
+		case ngapType.ProtocolIEIDPDUSessionResourceModifyListModReq:
+			for _, pduSessionResourceModifyItem := range ie.Value.PDUSessionResourceModifyListModReq.List {
+				hasNas, err := g.modifyPduSessionResource(ranUe, &pduSessionResourceModifyItem)
+				if err != nil {
+					return fmt.Errorf("error modify pdu session %d: %v", pduSessionResourceModifyItem.PDUSessionID.Value, err)
+				}
+				if hasNas {
+					nasPduSessionModificationCommands++
+				}
+				modifiedPduSessionIds = append(modifiedPduSessionIds, pduSessionResourceModifyItem.PDUSessionID.Value)
 			}
-			nasUeDeRegistrationAccept = make([]byte, len(ie.Value.NASPDU.Value))
-			copy(nasUeDeRegistrationAccept, ie.Value.NASPDU.Value)
-			g.NgapLog.Tracef("Get NASPDU: %+v", nasUeDeRegistrationAccept)
 		}
 	}
 
-	n, err = ranUe.GetN1Conn().Write(nasUeDeRegistrationAccept)
+	// send ngap pdu session resource modify response to AMF
+	ngapPduSessionResourceModifyResponseTransfer, err := getPduSessionResourceModifyResponseTransfer()
 	if err != nil {
-		return fmt.Errorf("error send nas ue deregistration accept to UE: %v", err)
+		return fmt.Errorf("error get pdu session resource modify response transfer: %v", err)
 	}
-	g.NasLog.Tracef("Sent %d bytes of NAS UE deregistration Accept to UE", n)
-	g.NasLog.Debugln("Send NAS UE deregistration Accept to UE")
+	g.NgapLog.Tracef("Get pdu session resource modify response transfer: %+v", ngapPduSessionResourceModifyResponseTransfer)
 
-	// receive ngap ue context release command from AMF
-	ngapUeContextReleaseCommandRaw := make([]byte, 1024)
-	n, err = g.n2Conn.Read(ngapUeContextReleaseCommandRaw)
+	ngapPduSessionResourceModifyResponse, err := getPduSessionResourceModifyResponse(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), modifiedPduSessionIds, ngapPduSessionResourceModifyResponseTransfer)
 	if err != nil {
-		return fmt.Errorf("error receive ngap ue context release command from AMF: %v", err)
+		return fmt.Errorf("error get pdu session resource modify response: %v", err)
 	}
-	g.NgapLog.Tracef("Received %d bytes of NGAP UE Context Release Command from AMF", n)
+	g.NgapLog.Tracef("Get pdu session resource modify response: %+v", ngapPduSessionResourceModifyResponse)
 
-	ngapUeContextReleaseCommand, err := ngap.Decoder(ngapUeContextReleaseCommandRaw[:n])
+	n, err := g.n2Conn.Write(ngapPduSessionResourceModifyResponse)
 	if err != nil {
-		return fmt.Errorf("error decode ngap ue context release command from AMF: %v", err)
+		return fmt.Errorf("error send pdu session resource modify response to AMF: %v", err)
 	}
-	if ngapUeContextReleaseCommand.Present != ngapType.NGAPPDUPresentInitiatingMessage || ngapUeContextReleaseCommand.InitiatingMessage.ProcedureCode.Value != ngapType.ProcedureCodeUEContextRelease {
-		return fmt.Errorf("error ngap ue context release command: %+v", ngapUeContextReleaseCommand)
+	g.NgapLog.Tracef("Sent %d bytes of pdu session resource modify response to AMF", n)
+	g.NgapLog.Debugln("Send PDU Session Resource Modify Response to AMF")
+
+	// forward nas pdu session modification complete of each command to AMF
+	for range nasPduSessionModificationCommands {
+		if err := g.forwardUeUplinkNas(ranUe); err != nil {
+			return fmt.Errorf("error forward nas pdu session modification complete: %v", err)
+		}
+		g.NasLog.Debugln("Forward NAS PDU Session Modification Complete to AMF")
 	}
-	g.NgapLog.Tracef("NGAP UE Context Release Command: %+v", ngapUeContextReleaseCommand)
-	g.NgapLog.Debugln("Receive NGAP UE Context Release Command from AMF")
 
-	// send ngap ue context release complete to AMF
-	ngapUeContextReleaseCompleteMessage, err := getNgapUeContextReleaseCompleteMessage(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), []int64{constant.PDU_SESSION_ID}, g.plmnId, g.tai)
+	g.NgapLog.Infof("UE %s PDU session modification completed: %v", ranUe.GetMobileIdentityIMSI(), modifiedPduSessionIds)
+	return nil
+}
+
+// modifyPduSessionResource moves the UL tunnel of the pdu session if the modify request transfer asks to,
+// and reconfigures the UE with the nas pdu session modification command, returns whether a command was sent
+func (g *Gnb) modifyPduSessionResource(ranUe *RanUe, pduSessionResourceModifyItem *ngapType.PDUSessionResourceModifyItemModReq) (bool, error) {
+	pduSessionId := uint8(pduSessionResourceModifyItem.PDUSessionID.Value)
+	session, exists := ranUe.GetPduSession(pduSessionId)
+	if !exists {
+		return false, fmt.Errorf("pdu session %d does not exist", pduSessionId)
+	}
+
+	pduSessionResourceModifyRequestTransfer := ngapType.PDUSessionResourceModifyRequestTransfer{}
+	if err := aper.UnmarshalWithParams(pduSessionResourceModifyItem.PDUSessionResourceModifyRequestTransfer, &pduSessionResourceModifyRequestTransfer, "valueExt"); err != nil {
+		return false, fmt.Errorf("error unmarshal pdu session resource modify request transfer: %v", err)
+	}
+	g.NgapLog.Tracef("Get PDUSessionResourceModifyRequestTransfer: %+v", pduSessionResourceModifyRequestTransfer)
+
+	for _, item := range pduSessionResourceModifyRequestTransfer.ProtocolIEs.List {
+		switch item.Id.Value {
+		case ngapType.ProtocolIEIDPDUSessionAggregateMaximumBitRate:
+		case ngapType.ProtocolIEIDULNGUUPTNLModifyList:
+			for _, ulNgUUpTnlModifyItem := range item.Value.ULNGUUPTNLModifyList.List {
+				if ulNgUUpTnlModifyItem.ULNGUUPTNLInformation.GTPTunnel == nil {
+					continue
+				}
+				session.SetUlTeid(ulNgUUpTnlModifyItem.ULNGUUPTNLInformation.GTPTunnel.GTPTEID.Value)
+				g.anchorPduSession(session, ulNgUUpTnlModifyItem.ULNGUUPTNLInformation.GTPTunnel.TransportLayerAddress)
+				g.GtpLog.Debugf("UE %s PDU session %d moved to UPF %s, UL TEID: %s", ranUe.GetMobileIdentityIMSI(), pduSessionId, session.GetUpfN3Addr().String(), hex.EncodeToString(session.GetUlTeid()))
+			}
+		case ngapType.ProtocolIEIDNetworkInstance:
+		case ngapType.ProtocolIEIDQosFlowAddOrModifyRequestList:
+		case ngapType.ProtocolIEIDQosFlowToReleaseList:
+		}
+	}
+
+	if pduSessionResourceModifyItem.NASPDU == nil {
+		return false, nil
+	}
+
+	nasPduSessionModificationCommand := make([]byte, len(pduSessionResourceModifyItem.NASPDU.Value))
+	copy(nasPduSessionModificationCommand, pduSessionResourceModifyItem.NASPDU.Value)
+	g.NgapLog.Tracef("Get NASPDU: %+v", nasPduSessionModificationCommand)
+
+	transactionId, err := g.sendRrcReconfiguration(ranUe, nil, nil, protocol.RRC_SCG_NONE, 0, nasPduSessionModificationCommand)
 	if err != nil {
-		return fmt.Errorf("error get ngap ue context release complete message: %v", err)
+		return false, fmt.Errorf("error send rrc reconfiguration to UE: %v", err)
+	}
+	g.NasLog.Debugln("Send NAS PDU Session Modification Command to UE")
+
+	if err := g.receiveRrcReconfigurationComplete(ranUe, transactionId); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// requestUeContextRelease asks AMF to release the context of a connected UE for user inactivity, AMF keeps the UE registered
+// and its pdu sessions with the user plane deactivated, and answers with the UE context release command that releases the UE
+func (g *Gnb) requestUeContextRelease(ranUe *RanUe) error {
+	if ranUe.GetRrcState() != protocol.RRC_STATE_CONNECTED {
+		return fmt.Errorf("UE %s is in %s", ranUe.GetMobileIdentityIMSI(), ranUe.GetRrcState())
 	}
-	g.NgapLog.Tracef("Get NGAP UE Context Release Complete Message: %+v", ngapUeContextReleaseCompleteMessage)
 
-	n, err = g.n2Conn.Write(ngapUeContextReleaseCompleteMessage)
+	pduSessionIds := make([]int64, 0)
+	for _, session := range ranUe.GetPduSessionList() {
+		pduSessionIds = append(pduSessionIds, int64(session.GetPduSessionId()))
+	}
+	ngapUeContextReleaseRequest, err := getNgapUeContextReleaseRequest(ranUe.GetAmfUeId(), ranUe.GetRanUeId(), pduSessionIds, ngapType.CauseRadioNetworkPresentUserInactivity)
 	if err != nil {
-		return fmt.Errorf("error send ngap ue context release complete message to AMF: %v", err)
+		return fmt.Errorf("error get ngap ue context release request: %v", err)
 	}
-	g.NgapLog.Tracef("Sent %d bytes of NGAP UE Context Release Complete Message to AMF", n)
-	g.NgapLog.Debugln("Send NGAP UE Context Release Complete Message to AMF")
+	g.NgapLog.Tracef("Get NGAP UE Context Release Request: %+v", ngapUeContextReleaseRequest)
 
-	g.RanLog.Infoln("UE deregistration complete")
+	n, err := g.n2Conn.Write(ngapUeContextReleaseRequest)
+	if err != nil {
+		return fmt.Errorf("error send ngap ue context release request to AMF: %v", err)
+	}
+	g.NgapLog.Tracef("Sent %d bytes of NGAP UE Context Release Request to AMF", n)
+	g.NgapLog.Debugln("Send NGAP UE Context Release Request to AMF")
 	return nil
 }
 
-func (g *Gnb) xnPduSessionResourceSetupRequestTransfer(imsi string, ngapPduSessionResourceSetupRequestRaw []byte) (ngapType.QosFlowPerTNLInformationItem, error) {
+// processUeRelease releases the served UE on the UE context release command of AMF, e.g. after the deregistration accept
+// forwarded to the UE, with the release complete to AMF and the RRC release of the UE to RRC_IDLE
+func (g *Gnb) processUeRelease(ranUe *RanUe, ngapUeContextReleaseCommand *ngapType.NGAPPDU) error {
+	g.RanLog.Infof("Processing UE %s release", ranUe.GetMobileIdentityIMSI())
+
+	if err := g.completeUeContextRelease(ranUe, ngapUeContextReleaseCommand); err != nil {
+		return err
+	}
+
+	if err := g.processRrcRelease(ranUe, false); err != nil {
+		return err
+	}
+
+	g.RanLog.Infof("UE %s released", ranUe.GetMobileIdentityIMSI())
+	return nil
+}
+
+func (g *Gnb) xnPduSessionResourceSetupRequestTransfer(imsi string, dataPlaneToken []byte, xnSecurity []byte, ngapPduSessionResourceSetupRequestRaw []byte) (ngapType.QosFlowPerTNLInformationItem, error) {
 	g.XnLog.Infoln("Processing XN PDU Session Resource Setup Request Transfer")
 
 	var qosFlowPerTNLInformationItem ngapType.QosFlowPerTNLInformationItem
@@ -1228,6 +2013,8 @@ func (g *Gnb) xnPduSessionResourceSetupRequestTransfer(imsi string, ngapPduSessi
 	g.XnLog.Debugf("Dial XN at %s:%d", g.xnInterface.xnDialIp, g.xnInterface.xnDialPort)
 
 	xnPdu := NewXnPdu(imsi, ngapPduSessionResourceSetupRequestRaw)
+	xnPdu.Token = dataPlaneToken
+	xnPdu.Security = xnSecurity
 	xnPduBytes, err := xnPdu.Marshal()
 	if err != nil {
 		return qosFlowPerTNLInformationItem, fmt.Errorf("error marshal xn pdu: %v", err)
@@ -1271,7 +2058,7 @@ func (g *Gnb) xnPduSessionResourceSetupRequestTransfer(imsi string, ngapPduSessi
 	return qosFlowPerTNLInformationItem, nil
 }
 
-func (g *Gnb) xnPduSessionResourceModifyIndication(imsi string, ngapPduSessionResourceModifyIndicationRaw []byte) ([]byte, error) {
+func (g *Gnb) xnPduSessionResourceModifyIndication(imsi string, dataPlaneToken []byte, xnSecurity []byte, ngapPduSessionResourceModifyIndicationRaw []byte) ([]byte, error) {
 	g.XnLog.Infoln("Processing XN PDU Session Resource Modify Indication Transfer")
 
 	xnConn, err := util.TcpDialWithOptionalLocalAddress(g.xnInterface.xnDialIp, g.xnInterface.xnDialPort, "")
@@ -1281,6 +2068,8 @@ func (g *Gnb) xnPduSessionResourceModifyIndication(imsi string, ngapPduSessionRe
 	g.XnLog.Debugf("Dial XN at %s:%d", g.xnInterface.xnDialIp, g.xnInterface.xnDialPort)
 
 	xnPdu := NewXnPdu(imsi, ngapPduSessionResourceModifyIndicationRaw)
+	xnPdu.Token = dataPlaneToken
+	xnPdu.Security = xnSecurity
 	xnPduBytes, err := xnPdu.Marshal()
 	if err != nil {
 		return nil, fmt.Errorf("error marshal xn pdu: %v", err)
@@ -1414,6 +2203,42 @@ func (g *Gnb) initApiRoutes() util.Routes {
 			Pattern:     constant.API_GNB_UE_NRDC,
 			HandlerFunc: g.handleConsoleGnbUeNrdcModify,
 		},
+		{
+			Name:        "GNB Radio Channel",
+			Method:      constant.API_GNB_RADIO_CHANNEL_GET_METHOD,
+			Pattern:     constant.API_GNB_RADIO_CHANNEL,
+			HandlerFunc: g.handleGnbRadioChannel,
+		},
+		{
+			Name:        "GNB Radio Channel Modify",
+			Method:      constant.API_GNB_RADIO_CHANNEL_MODIFY_METHOD,
+			Pattern:     constant.API_GNB_RADIO_CHANNEL,
+			HandlerFunc: g.handleGnbRadioChannelModify,
+		},
+		{
+			Name:        "GNB Cell",
+			Method:      constant.API_GNB_CELL_GET_METHOD,
+			Pattern:     constant.API_GNB_CELL,
+			HandlerFunc: g.handleGnbCell,
+		},
+		{
+			Name:        "GNB UE Cell Modify",
+			Method:      constant.API_GNB_UE_CELL_METHOD,
+			Pattern:     constant.API_GNB_UE_CELL,
+			HandlerFunc: g.handleGnbUeCellModify,
+		},
+		{
+			Name:        "GNB UE RRC Suspend",
+			Method:      constant.API_GNB_UE_RRC_SUSPEND_METHOD,
+			Pattern:     constant.API_GNB_UE_RRC_SUSPEND,
+			HandlerFunc: g.handleGnbUeRrcSuspend,
+		},
+		{
+			Name:        "GNB UE Release",
+			Method:      constant.API_GNB_UE_RELEASE_METHOD,
+			Pattern:     constant.API_GNB_UE_RELEASE,
+			HandlerFunc: g.handleGnbUeRelease,
+		},
 	}
 }
 
@@ -1426,9 +2251,18 @@ func (g *Gnb) handleConsoleGnbInfo(c *gin.Context) {
 	ranUeList := []consoleModel.RanUeInfo{}
 	g.ranUeConns.Range(func(key, value any) bool {
 		ranUe := key.(*RanUe)
+		pduSessionIdList := []int{}
+		for _, session := range ranUe.GetPduSessionList() {
+			pduSessionIdList = append(pduSessionIdList, int(session.GetPduSessionId()))
+		}
 		ranUeList = append(ranUeList, consoleModel.RanUeInfo{
-			Imsi:          ranUe.GetMobileIdentityIMSI(),
-			NrdcIndicator: ranUe.IsNrdcActivated(),
+			Imsi:             ranUe.GetMobileIdentityIMSI(),
+			NrdcIndicator:    ranUe.IsNrdcActivated(),
+			RrcState:         ranUe.GetRrcState().String(),
+			NrCellId:         ranUe.GetServingCell().String(),
+			PduSessionIdList: pduSessionIdList,
+			DlBuffered:       ranUe.GetDlBufferedCount(),
+			DlBufferDropped:  ranUe.GetDlDroppedCount(),
 		})
 		return true
 	})
@@ -1437,7 +2271,9 @@ func (g *Gnb) handleConsoleGnbInfo(c *gin.Context) {
 	g.xnUeConns.Range(func(key, value any) bool {
 		xnUe := key.(*XnUe)
 		xnUeList = append(xnUeList, consoleModel.XnUeInfo{
-			Imsi: xnUe.GetIMSI(),
+			Imsi:            xnUe.GetIMSI(),
+			DlBuffered:      xnUe.GetDlBufferedCount(),
+			DlBufferDropped: xnUe.GetDlDroppedCount(),
 		})
 		return true
 	})
@@ -1504,3 +2340,258 @@ func (g *Gnb) handleConsoleGnbUeNrdcModify(c *gin.Context) {
 
 	g.ApiLog.Infof("Console gnb ue %s nrdc control completed", request.Imsi)
 }
+
+func (g *Gnb) handleGnbRadioChannel(c *gin.Context) {
+	g.ApiLog.Infoln("Handling get gnb radio channel")
+
+	ueProfiles := make(map[string]consoleModel.GnbRadioChannelProfile)
+	for imsi, profile := range g.radioChannel.getUeProfiles() {
+		ueProfiles[imsi] = radioChannelProfileToApi(profile)
+	}
+
+	c.JSON(http.StatusOK, consoleModel.GnbRadioChannelResponse{
+		Message: "Get gNB radio channel successful",
+		Cell:    radioChannelProfileToApi(g.radioChannel.getCellProfile()),
+		Ue:      ueProfiles,
+	})
+
+	g.ApiLog.Infoln("Get gnb radio channel successful")
+}
+
+func (g *Gnb) handleGnbRadioChannelModify(c *gin.Context) {
+	g.ApiLog.Infoln("Handling gnb radio channel modify")
+
+	var request consoleModel.GnbRadioChannelModifyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		g.ApiLog.Warnf("Error bind gnb radio channel modify request: %v", err)
+		c.JSON(http.StatusBadRequest, consoleModel.GnbRadioChannelModifyResponse{
+			Message: fmt.Sprintf("Error bind gnb radio channel modify request: %v", err),
+		})
+		return
+	}
+
+	profile := radioChannelProfileFromApi(request.Profile)
+	if err := profile.Validate(); err != nil {
+		g.ApiLog.Warnf("Invalid radio channel profile: %v", err)
+		c.JSON(http.StatusBadRequest, consoleModel.GnbRadioChannelModifyResponse{
+			Message: fmt.Sprintf("Invalid radio channel profile: %v", err),
+		})
+		return
+	}
+
+	switch {
+	case request.Imsi == "":
+		g.radioChannel.setCellProfile(profile)
+	case request.Reset:
+		g.radioChannel.deleteUeProfile(request.Imsi)
+	default:
+		g.radioChannel.setUeProfile(request.Imsi, profile)
+	}
+	g.applyRadioChannelProfiles()
+
+	message := "Cell radio channel modify success"
+	if request.Imsi != "" {
+		message = fmt.Sprintf("UE %s radio channel modify success", request.Imsi)
+	}
+	c.JSON(http.StatusOK, consoleModel.GnbRadioChannelModifyResponse{
+		Message: message,
+	})
+
+	g.ApiLog.Infoln(message)
+}
+
+func (g *Gnb) handleGnbCell(c *gin.Context) {
+	g.ApiLog.Infoln("Handling get gnb cell")
+
+	ranUeCount := make(map[*cell]int)
+	g.ranUeConns.Range(func(key, value any) bool {
+		ranUeCount[key.(*RanUe).GetServingCell()]++
+		return true
+	})
+
+	cells := make([]consoleModel.GnbCell, 0, len(g.cells))
+	for _, servingCell := range g.cells {
+		snssaiList := make([]consoleModel.SnssaiIE, 0, len(servingCell.snssai))
+		for _, cellSnssai := range servingCell.snssai {
+			snssai := util.SNssaiToModels(cellSnssai)
+			snssaiList = append(snssaiList, consoleModel.SnssaiIE{
+				Sst: strconv.Itoa(int(snssai.Sst)),
+				Sd:  snssai.Sd,
+			})
+		}
+		cells = append(cells, consoleModel.GnbCell{
+			NrCellId:   servingCell.String(),
+			Pci:        servingCell.pci,
+			Tac:        hex.EncodeToString(servingCell.tai.TAC.Value),
+			Snssai:     snssaiList,
+			RanUeCount: ranUeCount[servingCell],
+		})
+	}
+
+	c.JSON(http.StatusOK, consoleModel.GnbCellResponse{
+		Message: "Get gNB cell successful",
+		Cells:   cells,
+	})
+
+	g.ApiLog.Infoln("Get gnb cell successful")
+}
+
+func (g *Gnb) handleGnbUeCellModify(c *gin.Context) {
+	g.ApiLog.Infoln("Handling gnb ue cell modify")
+
+	var request consoleModel.GnbUeCellModifyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		g.ApiLog.Warnf("Error bind gnb ue cell modify request: %v", err)
+		c.JSON(http.StatusBadRequest, consoleModel.GnbUeCellModifyResponse{
+			Message: fmt.Sprintf("Error bind gnb ue cell modify request: %v", err),
+		})
+		return
+	}
+
+	nrCellIdentity, err := util.NrCellIdToUint64(request.NrCellId)
+	if err != nil {
+		g.ApiLog.Warnf("Invalid nr cell id: %v", err)
+		c.JSON(http.StatusBadRequest, consoleModel.GnbUeCellModifyResponse{
+			Message: fmt.Sprintf("Invalid nr cell id: %v", err),
+		})
+		return
+	}
+	targetCell, exists := g.getCell(nrCellIdentity)
+	if !exists {
+		g.ApiLog.Warnf("Cell %s not found", request.NrCellId)
+		c.JSON(http.StatusNotFound, consoleModel.GnbUeCellModifyResponse{
+			Message: fmt.Sprintf("Cell %s not found", request.NrCellId),
+		})
+		return
+	}
+
+	var ranUe *RanUe
+	g.ranUeConns.Range(func(key, value any) bool {
+		if key.(*RanUe).GetMobileIdentityIMSI() == request.Imsi {
+			ranUe = key.(*RanUe)
+		}
+		return true
+	})
+
+	if ranUe == nil {
+		g.ApiLog.Warnf("UE %s not found", request.Imsi)
+		c.JSON(http.StatusNotFound, consoleModel.GnbUeCellModifyResponse{
+			Message: fmt.Sprintf("UE %s not found", request.Imsi),
+		})
+		return
+	}
+	if err := g.processRrcReconfigurationWithSync(ranUe, targetCell); err != nil {
+		g.ApiLog.Errorf("Error process rrc reconfiguration with sync: %v", err)
+		c.JSON(http.StatusInternalServerError, consoleModel.GnbUeCellModifyResponse{
+			Message: fmt.Sprintf("Error process rrc reconfiguration with sync: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, consoleModel.GnbUeCellModifyResponse{
+		Message: fmt.Sprintf("UE %s cell modify success", request.Imsi),
+	})
+
+	g.ApiLog.Infof("Gnb ue %s moved to cell %s", request.Imsi, targetCell)
+}
+
+func (g *Gnb) handleGnbUeRrcSuspend(c *gin.Context) {
+	g.ApiLog.Infoln("Handling gnb ue rrc suspend")
+
+	var request consoleModel.GnbUeRrcSuspendRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		g.ApiLog.Warnf("Error bind gnb ue rrc suspend request: %v", err)
+		c.JSON(http.StatusBadRequest, consoleModel.GnbUeRrcSuspendResponse{
+			Message: fmt.Sprintf("Error bind gnb ue rrc suspend request: %v", err),
+		})
+		return
+	}
+
+	var ranUe *RanUe
+	g.ranUeConns.Range(func(key, value any) bool {
+		if key.(*RanUe).GetMobileIdentityIMSI() == request.Imsi {
+			ranUe = key.(*RanUe)
+		}
+		return true
+	})
+
+	if ranUe == nil {
+		g.ApiLog.Warnf("UE %s not found", request.Imsi)
+		c.JSON(http.StatusNotFound, consoleModel.GnbUeRrcSuspendResponse{
+			Message: fmt.Sprintf("UE %s not found", request.Imsi),
+		})
+		return
+	}
+	if err := g.processRrcRelease(ranUe, true); err != nil {
+		g.ApiLog.Errorf("Error process rrc release with suspend: %v", err)
+		c.JSON(http.StatusInternalServerError, consoleModel.GnbUeRrcSuspendResponse{
+			Message: fmt.Sprintf("Error process rrc release with suspend: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, consoleModel.GnbUeRrcSuspendResponse{
+		Message: fmt.Sprintf("UE %s rrc suspend success", request.Imsi),
+	})
+
+	g.ApiLog.Infof("Gnb ue %s suspended to %s", request.Imsi, protocol.RRC_STATE_INACTIVE)
+}
+
+func (g *Gnb) handleGnbUeRelease(c *gin.Context) {
+	g.ApiLog.Infoln("Handling gnb ue release")
+
+	var request consoleModel.GnbUeReleaseRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		g.ApiLog.Warnf("Error bind gnb ue release request: %v", err)
+		c.JSON(http.StatusBadRequest, consoleModel.GnbUeReleaseResponse{
+			Message: fmt.Sprintf("Error bind gnb ue release request: %v", err),
+		})
+		return
+	}
+
+	var ranUe *RanUe
+	g.ranUeConns.Range(func(key, value any) bool {
+		if key.(*RanUe).GetMobileIdentityIMSI() == request.Imsi {
+			ranUe = key.(*RanUe)
+		}
+		return true
+	})
+
+	if ranUe == nil {
+		g.ApiLog.Warnf("UE %s not found", request.Imsi)
+		c.JSON(http.StatusNotFound, consoleModel.GnbUeReleaseResponse{
+			Message: fmt.Sprintf("UE %s not found", request.Imsi),
+		})
+		return
+	}
+	if err := g.requestUeContextRelease(ranUe); err != nil {
+		g.ApiLog.Errorf("Error request ue context release: %v", err)
+		c.JSON(http.StatusInternalServerError, consoleModel.GnbUeReleaseResponse{
+			Message: fmt.Sprintf("Error request ue context release: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, consoleModel.GnbUeReleaseResponse{
+		Message: fmt.Sprintf("UE %s release requested", request.Imsi),
+	})
+
+	g.ApiLog.Infof("Gnb ue %s release requested", request.Imsi)
+}
+
+// applyRadioChannelProfiles brings the radio channel of every connected UE in line with the cell profile and the overrides
+func (g *Gnb) applyRadioChannelProfiles() {
+	g.ranUeConns.Range(func(key, value any) bool {
+		ranUe := key.(*RanUe)
+		profile, _ := g.radioChannel.getUeProfile(ranUe.GetMobileIdentityIMSI())
+		ranUe.SetRadioChannelProfile(profile)
+		return true
+	})
+
+	g.xnUeConns.Range(func(key, value any) bool {
+		xnUe := key.(*XnUe)
+		profile, _ := g.radioChannel.getUeProfile(xnUe.GetIMSI())
+		xnUe.SetRadioChannelProfile(profile)
+		return true
+	})
+}
@@ -6,116 +6,166 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
 	"net"
-	"strconv"
+	"net/netip"
 	"sync"
 
 	"github.com/Alonza0314/free-ran-ue/constant"
 	"github.com/Alonza0314/free-ran-ue/logger"
+	"github.com/Alonza0314/free-ran-ue/protocol"
 	"github.com/free5gc/aper"
 )
 
 type TeidGenerator struct {
-	teids sync.Map
-	mtx   sync.Mutex
+	allocator *idAllocator
 }
 
-func NewTeidGenerator() *TeidGenerator {
+// TEID 0 is reserved, so the TEIDs are allocated from [1, 2^32-1]
+func NewTeidGenerator(random bool) *TeidGenerator {
 	return &TeidGenerator{
-		teids: sync.Map{},
-		mtx:   sync.Mutex{},
+		allocator: newIdAllocator(1, math.MaxUint32, random),
 	}
 }
 
 func (t *TeidGenerator) AllocateTeid() aper.OctetString {
-	t.mtx.Lock()
-	defer t.mtx.Unlock()
-
-	for i := 1; i <= 65535; i++ {
-		if _, exists := t.teids.Load(int64(i)); !exists {
-			t.teids.Store(int64(i), true)
-
-			teid, err := hex.DecodeString(t.formatAsString(int64(i)))
-			if err != nil {
-				panic(fmt.Errorf("error decode teid: %v", err))
-			}
-
-			return aper.OctetString(teid)
-		}
+	value, err := t.allocator.allocate()
+	if err != nil {
+		return aper.OctetString{}
 	}
 
-	return aper.OctetString{}
+	return aper.OctetString(teidFromUint32(value))
 }
 
 func (t *TeidGenerator) ReleaseTeid(teid aper.OctetString) {
-	t.mtx.Lock()
-	defer t.mtx.Unlock()
-
 	if len(teid) == 0 {
 		return
 	}
-	value := t.deFormatFromString(hex.EncodeToString(teid))
 
-	if _, exists := t.teids.Load(value); exists {
-		t.teids.Delete(value)
-	} else {
+	if err := t.allocator.release(teidToUint32(teid)); err != nil {
 		panic(fmt.Errorf("attempting to release teid %s that is not allocated", hex.EncodeToString(teid)))
 	}
 }
 
-func (t *TeidGenerator) formatAsString(teid int64) string {
-	return fmt.Sprintf("%08x", teid)
+func teidFromUint32(teid uint32) []byte {
+	return binary.BigEndian.AppendUint32(make([]byte, 0, 4), teid)
 }
 
-func (t *TeidGenerator) deFormatFromString(teid string) int64 {
-	teidInt, err := strconv.ParseInt(teid, 16, 64)
-	if err != nil {
-		panic(fmt.Errorf("error deformat teid: %v", err))
+func teidToUint32(teid []byte) uint32 {
+	return binary.BigEndian.Uint32(teid)
+}
+
+// knownUpfs counts the references of each UPF, the configured UPF holds one for the lifetime of the gNB
+// and every PDU session anchored at a UPF holds one
+type knownUpfs struct {
+	references map[netip.Addr]int
+
+	mtx sync.Mutex
+}
+
+// acquire adds a reference of the UPF, returns true if the UPF was not known before
+func (k *knownUpfs) acquire(upfAddr netip.Addr) bool {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+
+	if k.references == nil {
+		k.references = make(map[netip.Addr]int)
+	}
+	k.references[upfAddr]++
+	return k.references[upfAddr] == 1
+}
+
+// release drops a reference of the UPF, returns true if it was the last one and the UPF is no longer known
+func (k *knownUpfs) release(upfAddr netip.Addr) bool {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+
+	references, exists := k.references[upfAddr]
+	if !exists {
+		return false
 	}
-	return teidInt
+	if references > 1 {
+		k.references[upfAddr] = references - 1
+		return false
+	}
+	delete(k.references, upfAddr)
+	return true
+}
+
+func (k *knownUpfs) isKnown(upfAddr netip.Addr) bool {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+
+	_, known := k.references[upfAddr]
+	return known
+}
+
+// uplink G-PDU together with the N3 address of the UPF anchoring its session
+type ulGtpPacket struct {
+	upfN3Addr *net.UDPAddr
+	packet    []byte
 }
 
-// get packet with GTP header from gtpChannel and forward to N3 connection
-func forwardGtpPacketToN3Conn(ctx context.Context, n3Conn *net.UDPConn, gtpChannel chan []byte, gnbLogger *logger.GnbLogger) {
+// get packet with GTP header from gtpChannel and send it to the session's UPF over the N3 connection
+func forwardGtpPacketToN3Conn(ctx context.Context, n3Conn *net.UDPConn, gtpChannel chan ulGtpPacket, gnbLogger *logger.GnbLogger) {
 	for {
 		select {
 		case <-ctx.Done():
 			gnbLogger.GtpLog.Debugln("Forward GTP packet to N3 connection stopped")
 			return
-		case packet := <-gtpChannel:
-			n, err := n3Conn.Write(packet)
+		case ulPacket := <-gtpChannel:
+			n, err := n3Conn.WriteToUDP(ulPacket.packet, ulPacket.upfN3Addr)
 			if err != nil {
-				gnbLogger.GtpLog.Errorf("Error writing GTP packet to N3 connection: %v", err)
-				return
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				gnbLogger.GtpLog.Errorf("Error writing GTP packet to UPF %s: %v", ulPacket.upfN3Addr, err)
+				continue
 			}
-			gnbLogger.GtpLog.Tracef("Forwarded %d bytes of GTP packet to N3 connection", n)
+			gnbLogger.GtpLog.Tracef("Forwarded %d bytes of GTP packet to UPF %s", n, ulPacket.upfN3Addr)
 			gnbLogger.GtpLog.Debugln("Forwarded GTP packet to N3 connection")
 		}
 	}
 }
 
-// receive GTP packet from N3 connection and forward to UE according to the GTP header's TEID
-func receiveGtpPacketFromN3Conn(ctx context.Context, n3Conn *net.UDPConn, ranDataPlaneServer *net.UDPConn, gnbLogger *logger.GnbLogger, dlTeidToUe *sync.Map) {
+// receive GTP packet from N3 connection and forward to UE according to the GTP header's TEID,
+// packets from addresses which are not a known UPF are dropped
+func receiveGtpPacketFromN3Conn(ctx context.Context, n3Conn *net.UDPConn, gnbLogger *logger.GnbLogger, knownUpfs *knownUpfs, dlTeidToUe *sync.Map) {
 	buffer := make([]byte, 4096)
 	for {
-		n, err := n3Conn.Read(buffer)
+		n, upfAddr, err := n3Conn.ReadFromUDPAddrPort(buffer)
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return
 			}
 			gnbLogger.GtpLog.Warnf("Error reading GTP packet from N3 connection: %v", err)
+			continue
 		}
 		gnbLogger.GtpLog.Tracef("Received %d bytes of GTP packet from N3 connection: %+v", n, buffer[:n])
-		gnbLogger.GtpLog.Tracef("Received %d bytes of GTP packet from N3 connection", n)
+		gnbLogger.GtpLog.Tracef("Received %d bytes of GTP packet from UPF %s", n, upfAddr)
+
+		if !knownUpfs.isKnown(upfAddr.Addr().Unmap()) {
+			gnbLogger.GtpLog.Warnf("Dropping GTP packet from unknown UPF %s", upfAddr)
+			continue
+		}
 
 		tmp := make([]byte, n)
 		copy(tmp, buffer[:n])
-		forwardPacketToUe(tmp, ranDataPlaneServer, dlTeidToUe, gnbLogger)
+		forwardPacketToUe(tmp, dlTeidToUe, gnbLogger)
+	}
+}
+
+// pageRanUe sends RAN paging to a UE in RRC_INACTIVE, the UE answers with RRCResumeRequest
+func pageRanUe(ranUe *RanUe, gnbLogger *logger.GnbLogger) {
+	if _, err := ranUe.SendToUe(protocol.MESSAGE_TYPE_PAGING, nil); err != nil {
+		gnbLogger.RanLog.Warnf("Error send paging to UE %s: %v", ranUe.GetMobileIdentityIMSI(), err)
+		return
 	}
+	gnbLogger.RanLog.Debugf("Send paging to UE %s", ranUe.GetMobileIdentityIMSI())
 }
 
 // format GTP packet and write to gtpChannel
-func formatGtpPacketAndWriteToGtpChannel(teid aper.OctetString, packet []byte, gtpChannel chan []byte, gnbLogger *logger.GnbLogger) {
+func formatGtpPacketAndWriteToGtpChannel(teid aper.OctetString, upfN3Addr *net.UDPAddr, packet []byte, gtpChannel chan ulGtpPacket, gnbLogger *logger.GnbLogger) {
 	gtpHeader := make([]byte, 12)
 
 	gtpHeader[0] = 0x32
@@ -127,51 +177,66 @@ func formatGtpPacketAndWriteToGtpChannel(teid aper.OctetString, packet []byte, g
 	gtpPacket := append(gtpHeader, packet...)
 	gnbLogger.GtpLog.Tracef("Formatted GTP packet: %+v", gtpPacket)
 
-	gtpChannel <- gtpPacket
+	gtpChannel <- ulGtpPacket{
+		upfN3Addr: upfN3Addr,
+		packet:    gtpPacket,
+	}
 	gnbLogger.GtpLog.Tracef("Wrote %d bytes of GTP packet to gtpChannel", len(gtpPacket))
 	gnbLogger.GtpLog.Debugln("Wrote GTP packet to gtpChannel")
 }
 
 // forward packet to UE according to the GTP header's TEID
-func forwardPacketToUe(gtpPacket []byte, ranDataPlaneServer *net.UDPConn, dlTeidToUe *sync.Map, gnbLogger *logger.GnbLogger) {
+func forwardPacketToUe(gtpPacket []byte, dlTeidToUe *sync.Map, gnbLogger *logger.GnbLogger) {
 	teid, payload, err := parseGtpPacket(gtpPacket)
 	if err != nil {
 		gnbLogger.GtpLog.Warnf("Error parsing GTP packet: %v", err)
 		return
 	}
-	gnbLogger.GtpLog.Tracef("Parsed GTP packet: TEID: %s, Payload: %+v", teid, payload)
+	gnbLogger.GtpLog.Tracef("Parsed GTP packet: TEID: %08x, Payload: %+v", teid, payload)
 
-	ue, exists := dlTeidToUe.Load(teid)
+	tunnel, exists := dlTeidToUe.Load(teid)
 	if !exists {
-		gnbLogger.GtpLog.Warnf("No UE found for DL TEID: %s", teid)
+		gnbLogger.GtpLog.Warnf("No UE found for DL TEID: %08x", teid)
 		return
 	}
+	pduSessionId := tunnel.(*dlTunnel).pduSessionId
 
-	switch u := ue.(type) {
+	switch u := tunnel.(*dlTunnel).ue.(type) {
 	case *RanUe:
-		gnbLogger.GtpLog.Debugf("Loaded UE %s for DL TEID: %s", u.GetMobileIdentityIMSI(), teid)
-		dataPlaneAddress := u.GetDataPlaneAddress()
-		if dataPlaneAddress == nil {
-			gnbLogger.GtpLog.Warnf("RAN UE %s data plane address not set yet, dropping packet", u.GetMobileIdentityIMSI())
+		gnbLogger.GtpLog.Debugf("Loaded UE %s PDU session %d for DL TEID: %08x", u.GetMobileIdentityIMSI(), pduSessionId, teid)
+		n, buffered, err := u.forwardDlPacket(pduSessionId, payload)
+		if errors.Is(err, errRadioChannelLoss) {
+			gnbLogger.GtpLog.Tracef("GTP packet to RAN UE %s %v", u.GetMobileIdentityIMSI(), err)
 			return
 		}
-		n, err := ranDataPlaneServer.WriteToUDP(payload, dataPlaneAddress)
 		if err != nil {
-			gnbLogger.GtpLog.Warnf("Error writing GTP packet to RAN UE: %v", err)
+			gnbLogger.GtpLog.Warnf("Error forwarding GTP packet to RAN UE %s: %v", u.GetMobileIdentityIMSI(), err)
+			return
+		}
+		if buffered {
+			if u.PageRrc() {
+				gnbLogger.GtpLog.Debugf("RAN UE %s in %s, buffered packet and paging UE", u.GetMobileIdentityIMSI(), protocol.RRC_STATE_INACTIVE)
+				go pageRanUe(u, gnbLogger)
+				return
+			}
+			gnbLogger.GtpLog.Debugf("RAN UE %s data plane address not set yet or UE suspended, buffered packet", u.GetMobileIdentityIMSI())
 			return
 		}
 		gnbLogger.GtpLog.Tracef("Forwarded %d bytes of GTP packet to RAN UE", n)
 		gnbLogger.GtpLog.Debugln("Forwarded GTP packet to RAN UE")
 	case *XnUe:
-		gnbLogger.GtpLog.Debugf("Loaded UE %s for DL TEID: %s", u.GetIMSI(), teid)
-		dataPlaneAddress := u.GetDataPlaneAddress()
-		if dataPlaneAddress == nil {
-			gnbLogger.GtpLog.Warnf("XN UE %s data plane address not set yet, dropping packet", u.GetIMSI())
+		gnbLogger.GtpLog.Debugf("Loaded UE %s PDU session %d for DL TEID: %08x", u.GetIMSI(), pduSessionId, teid)
+		n, buffered, err := u.forwardDlPacket(pduSessionId, payload)
+		if errors.Is(err, errRadioChannelLoss) {
+			gnbLogger.GtpLog.Tracef("GTP packet to XN UE %s %v", u.GetIMSI(), err)
 			return
 		}
-		n, err := ranDataPlaneServer.WriteToUDP(payload, dataPlaneAddress)
 		if err != nil {
-			gnbLogger.GtpLog.Warnf("Error writing GTP packet to XN UE: %v", err)
+			gnbLogger.GtpLog.Warnf("Error forwarding GTP packet to XN UE %s: %v", u.GetIMSI(), err)
+			return
+		}
+		if buffered {
+			gnbLogger.GtpLog.Debugf("XN UE %s data plane address not set yet, buffered packet", u.GetIMSI())
 			return
 		}
 		gnbLogger.GtpLog.Tracef("Forwarded %d bytes of GTP packet to XN UE", n)
@@ -180,7 +245,7 @@ func forwardPacketToUe(gtpPacket []byte, ranDataPlaneServer *net.UDPConn, dlTeid
 }
 
 // parse GTP packet, will return the TEID and payload
-func parseGtpPacket(gtpPacket []byte) (string, []byte, error) {
+func parseGtpPacket(gtpPacket []byte) (uint32, []byte, error) {
 	basicHeader, headerLength := gtpPacket[:8], 8
 
 	isNextExtensionHeader, isSequenceNumber, isNPDUNumber := false, false, false
@@ -202,19 +267,19 @@ func parseGtpPacket(gtpPacket []byte) (string, []byte, error) {
 	}
 
 	if !isNextExtensionHeader {
-		return hex.EncodeToString(basicHeader[4:]), gtpPacket[headerLength:], nil
+		return teidToUint32(basicHeader[4:]), gtpPacket[headerLength:], nil
 	}
 
 	for {
 		switch gtpPacket[headerLength] {
 		case constant.NEXT_EXTENSION_HEADER_TYPE_NO_MORE_EXTENSION_HEADERS:
 			headerLength += 1
-			return hex.EncodeToString(basicHeader[4:]), gtpPacket[headerLength:], nil
+			return teidToUint32(basicHeader[4:]), gtpPacket[headerLength:], nil
 		case constant.NEXT_EXTENSION_HEADER_TYPE_PDU_SESSION_CONTAINER:
 			extensionHeaderLength := gtpPacket[headerLength+1]
 			headerLength += 2 + int(extensionHeaderLength)*constant.NEXT_EXTENSION_HEADER_TYPE_PDU_SESSION_CONTAINER_LENGTH
 		default:
-			return "", nil, fmt.Errorf("unknown GTP extension header type: %d", gtpPacket[headerLength])
+			return 0, nil, fmt.Errorf("unknown GTP extension header type: %d", gtpPacket[headerLength])
 		}
 	}
 }
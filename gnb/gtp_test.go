@@ -0,0 +1,113 @@
+package gnb
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/free5gc/ngap/ngapConvert"
+	"github.com/free5gc/ngap/ngapType"
+)
+
+var testKnownUpfsCases = []struct {
+	name          string
+	acquire       []string
+	release       []string
+	expectedKnown map[string]bool
+}{
+	{
+		name:          "testUpfKnownWhileSessionAnchored",
+		acquire:       []string{"10.0.0.1", "10.0.0.1"},
+		release:       []string{"10.0.0.1"},
+		expectedKnown: map[string]bool{"10.0.0.1": true},
+	},
+	{
+		name:          "testUpfPrunedWithLastSession",
+		acquire:       []string{"10.0.0.1", "10.0.0.2"},
+		release:       []string{"10.0.0.2"},
+		expectedKnown: map[string]bool{"10.0.0.1": true, "10.0.0.2": false},
+	},
+	{
+		name:          "testReleaseOfUnknownUpf",
+		acquire:       []string{"10.0.0.1"},
+		release:       []string{"10.0.0.2"},
+		expectedKnown: map[string]bool{"10.0.0.1": true, "10.0.0.2": false},
+	},
+}
+
+func TestKnownUpfs(t *testing.T) {
+	for _, testCase := range testKnownUpfsCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			upfs := knownUpfs{}
+			for _, upf := range testCase.acquire {
+				upfs.acquire(netip.MustParseAddr(upf))
+			}
+			for _, upf := range testCase.release {
+				upfs.release(netip.MustParseAddr(upf))
+			}
+			for upf, expectedKnown := range testCase.expectedKnown {
+				if known := upfs.isKnown(netip.MustParseAddr(upf)); known != expectedKnown {
+					t.Errorf("UPF %s known: %t, expected %t", upf, known, expectedKnown)
+				}
+			}
+		})
+	}
+}
+
+func testPduSessionResourceSetupRequestTransfer(ipv4, ipv6 string) *ngapType.PDUSessionResourceSetupRequestTransfer {
+	return &ngapType.PDUSessionResourceSetupRequestTransfer{
+		ProtocolIEs: ngapType.ProtocolIEContainerPDUSessionResourceSetupRequestTransferIEs{
+			List: []ngapType.PDUSessionResourceSetupRequestTransferIEs{
+				{
+					Id: ngapType.ProtocolIEID{Value: ngapType.ProtocolIEIDULNGUUPTNLInformation},
+					Value: ngapType.PDUSessionResourceSetupRequestTransferIEsValue{
+						ULNGUUPTNLInformation: &ngapType.UPTransportLayerInformation{
+							Present: ngapType.UPTransportLayerInformationPresentGTPTunnel,
+							GTPTunnel: &ngapType.GTPTunnel{
+								TransportLayerAddress: ngapConvert.IPAddressToNgap(ipv4, ipv6),
+								GTPTEID:               ngapType.GTPTEID{Value: []byte{0x00, 0x00, 0x00, 0x01}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+var testCheckUlNgUUpTnlInformationCases = []struct {
+	name          string
+	transfer      *ngapType.PDUSessionResourceSetupRequestTransfer
+	expectedError bool
+}{
+	{
+		name:          "testIpv4Upf",
+		transfer:      testPduSessionResourceSetupRequestTransfer("10.0.0.1", ""),
+		expectedError: false,
+	},
+	{
+		name:          "testDualStackUpf",
+		transfer:      testPduSessionResourceSetupRequestTransfer("10.0.0.1", "2001:db8::1"),
+		expectedError: false,
+	},
+	{
+		name:          "testIpv6OnlyUpf",
+		transfer:      testPduSessionResourceSetupRequestTransfer("", "2001:db8::1"),
+		expectedError: true,
+	},
+	{
+		name:          "testNoUlNgUUpTnlInformation",
+		transfer:      &ngapType.PDUSessionResourceSetupRequestTransfer{},
+		expectedError: false,
+	},
+}
+
+func TestCheckUlNgUUpTnlInformation(t *testing.T) {
+	for _, testCase := range testCheckUlNgUUpTnlInformationCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := checkUlNgUUpTnlInformation(testCase.transfer)
+			if (err != nil) != testCase.expectedError {
+				t.Errorf("expected error %t, got %v", testCase.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package gnb
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+)
+
+const (
+	// maximum number of random draws before falling back to a linear probe
+	idAllocatorMaxRandomAttempts = 32
+	// maximum number of ids probed after the last random draw
+	idAllocatorMaxProbes = 1024
+)
+
+// idAllocator hands out unique uint32 identifiers from [minId, maxId] in O(1).
+//
+// In sequential mode, released ids are reused first (LIFO), otherwise the cursor
+// is advanced. In random mode, ids are drawn uniformly from the range and, when
+// the random draws keep colliding, a bounded linear probe follows the last draw.
+type idAllocator struct {
+	minId uint32
+	maxId uint32
+
+	random bool
+
+	cursor  uint64
+	freeIds []uint32
+	usedIds map[uint32]struct{}
+
+	mtx sync.Mutex
+}
+
+func newIdAllocator(minId, maxId uint32, random bool) *idAllocator {
+	return &idAllocator{
+		minId: minId,
+		maxId: maxId,
+
+		random: random,
+
+		cursor:  uint64(minId),
+		freeIds: make([]uint32, 0),
+		usedIds: make(map[uint32]struct{}),
+
+		mtx: sync.Mutex{},
+	}
+}
+
+func (a *idAllocator) size() uint64 {
+	return uint64(a.maxId) - uint64(a.minId) + 1
+}
+
+func (a *idAllocator) allocate() (uint32, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if uint64(len(a.usedIds)) >= a.size() {
+		return 0, fmt.Errorf("id space [%d, %d] exhausted", a.minId, a.maxId)
+	}
+
+	if a.random {
+		return a.allocateRandom()
+	}
+
+	for len(a.freeIds) > 0 {
+		id := a.freeIds[len(a.freeIds)-1]
+		a.freeIds = a.freeIds[:len(a.freeIds)-1]
+		if _, used := a.usedIds[id]; !used {
+			a.usedIds[id] = struct{}{}
+			return id, nil
+		}
+	}
+
+	for range a.size() {
+		id := uint32(a.cursor)
+		if a.cursor == uint64(a.maxId) {
+			a.cursor = uint64(a.minId)
+		} else {
+			a.cursor++
+		}
+		if _, used := a.usedIds[id]; !used {
+			a.usedIds[id] = struct{}{}
+			return id, nil
+		}
+	}
+
+	return 0, fmt.Errorf("id space [%d, %d] exhausted", a.minId, a.maxId)
+}
+
+// allocateRandom draws random ids and then probes the ids following the last draw, the caller holds mtx
+func (a *idAllocator) allocateRandom() (uint32, error) {
+	var offset uint64
+	for range idAllocatorMaxRandomAttempts {
+		offset = rand.Uint64N(a.size())
+		id := a.minId + uint32(offset)
+		if _, used := a.usedIds[id]; !used {
+			a.usedIds[id] = struct{}{}
+			return id, nil
+		}
+	}
+
+	for range min(uint64(idAllocatorMaxProbes), a.size()) {
+		offset = (offset + 1) % a.size()
+		id := a.minId + uint32(offset)
+		if _, used := a.usedIds[id]; !used {
+			a.usedIds[id] = struct{}{}
+			return id, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no free id found in [%d, %d] after %d random draws and %d probes", a.minId, a.maxId, idAllocatorMaxRandomAttempts, idAllocatorMaxProbes)
+}
+
+func (a *idAllocator) release(id uint32) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if _, used := a.usedIds[id]; !used {
+		return fmt.Errorf("id %d is not allocated", id)
+	}
+	delete(a.usedIds, id)
+
+	if !a.random {
+		a.freeIds = append(a.freeIds, id)
+	}
+	return nil
+}
+
+func (a *idAllocator) inUse() int {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	return len(a.usedIds)
+}
@@ -0,0 +1,131 @@
+package gnb
+
+import (
+	"math"
+	"testing"
+)
+
+var testIdAllocatorCases = []struct {
+	name   string
+	minId  uint32
+	maxId  uint32
+	random bool
+}{
+	{
+		name:   "testSequentialIdAllocator",
+		minId:  1,
+		maxId:  8,
+		random: false,
+	},
+	{
+		name:   "testRandomIdAllocator",
+		minId:  1,
+		maxId:  8,
+		random: true,
+	},
+	{
+		name:   "testSequentialIdAllocatorAtUpperBound",
+		minId:  math.MaxUint32 - 7,
+		maxId:  math.MaxUint32,
+		random: false,
+	},
+	{
+		name:   "testRandomIdAllocatorAtUpperBound",
+		minId:  math.MaxUint32 - 7,
+		maxId:  math.MaxUint32,
+		random: true,
+	},
+}
+
+func TestIdAllocator(t *testing.T) {
+	for _, testCase := range testIdAllocatorCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			allocator := newIdAllocator(testCase.minId, testCase.maxId, testCase.random)
+
+			allocated := make(map[uint32]struct{})
+			for range allocator.size() {
+				id, err := allocator.allocate()
+				if err != nil {
+					t.Fatalf("allocate: %v", err)
+				}
+				if id < testCase.minId || id > testCase.maxId {
+					t.Fatalf("id %d out of range [%d, %d]", id, testCase.minId, testCase.maxId)
+				}
+				if _, exists := allocated[id]; exists {
+					t.Fatalf("id %d allocated twice", id)
+				}
+				allocated[id] = struct{}{}
+			}
+
+			if _, err := allocator.allocate(); err == nil {
+				t.Fatalf("expected exhaustion error")
+			}
+
+			released := testCase.minId + 3
+			if err := allocator.release(released); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+			if err := allocator.release(released); err == nil {
+				t.Fatalf("expected error on double release")
+			}
+
+			id, err := allocator.allocate()
+			if err != nil {
+				t.Fatalf("allocate after release: %v", err)
+			}
+			if id != released {
+				t.Errorf("expected released id %d to be reused, got %d", released, id)
+			}
+			if allocator.inUse() != int(allocator.size()) {
+				t.Errorf("expected %d ids in use, got %d", allocator.size(), allocator.inUse())
+			}
+		})
+	}
+}
+
+func TestTeidGenerator(t *testing.T) {
+	teidGenerator := NewTeidGenerator(false)
+
+	teid := teidGenerator.AllocateTeid()
+	if len(teid) != 4 {
+		t.Fatalf("expected 4 bytes teid, got %d", len(teid))
+	}
+	if teidToUint32(teid) != 1 {
+		t.Errorf("expected first teid to be 1, got %d", teidToUint32(teid))
+	}
+	if string(teidFromUint32(teidToUint32(teid))) != string(teid) {
+		t.Errorf("teid conversion mismatch: %x", teid)
+	}
+
+	teidGenerator.ReleaseTeid(teid)
+}
+
+func TestRanUeNgapIdGenerator(t *testing.T) {
+	ranUeNgapIdGenerator := NewRanUeNgapIdGenerator()
+
+	ranUeId := ranUeNgapIdGenerator.AllocateRanUeId()
+	if ranUeId != 1 {
+		t.Fatalf("expected first RAN UE NGAP ID to be 1, got %d", ranUeId)
+	}
+	if err := ranUeNgapIdGenerator.ReleaseRanUeId(ranUeId); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if err := ranUeNgapIdGenerator.ReleaseRanUeId(ranUeId); err == nil {
+		t.Errorf("expected error on double release")
+	}
+	if err := ranUeNgapIdGenerator.ReleaseRanUeId(-1); err == nil {
+		t.Errorf("expected error on out of range release")
+	}
+}
+
+func BenchmarkTeidGenerator(b *testing.B) {
+	teidGenerator := NewTeidGenerator(true)
+	for range 100000 {
+		teidGenerator.AllocateTeid()
+	}
+
+	b.ResetTimer()
+	for range b.N {
+		teidGenerator.ReleaseTeid(teidGenerator.AllocateTeid())
+	}
+}
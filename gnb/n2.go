@@ -0,0 +1,376 @@
+package gnb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/free5gc/ngap"
+	"github.com/free5gc/ngap/ngapType"
+)
+
+// the largest NGAP PDU read from the N2 connection
+const n2MessageMaxLength = 65535
+
+// n2Message is an NGAP PDU from AMF with its encoding, which NR-DC passes on to the secondary gNB as it is
+type n2Message struct {
+	raw []byte
+	pdu *ngapType.NGAPPDU
+}
+
+// startN2Receiver starts the only reader of the N2 connection once N2 is set up, it dispatches each NGAP PDU from AMF
+// to the UE of its RAN UE NGAP ID, so that the UEs served at the same time never read each other's messages
+func (g *Gnb) startN2Receiver() {
+	g.n2Closed = make(chan struct{})
+	go g.receiveN2Messages()
+}
+
+func (g *Gnb) receiveN2Messages() {
+	defer close(g.n2Closed)
+
+	buffer := make([]byte, n2MessageMaxLength)
+	for {
+		n, err := g.n2Conn.Read(buffer)
+		if err != nil {
+			g.SctpLog.Infof("N2 receiver stopped: %v", err)
+			return
+		}
+		g.NgapLog.Tracef("Received %d bytes of NGAP PDU from AMF", n)
+
+		raw := make([]byte, n)
+		copy(raw, buffer[:n])
+		pdu, err := ngap.Decoder(raw)
+		if err != nil {
+			g.NgapLog.Warnf("Error decode NGAP PDU from AMF: %v", err)
+			continue
+		}
+		g.NgapLog.Tracef("NGAP PDU: %+v", pdu)
+
+		if _, _, ueAssociated := getN2MessageUeNgapId(pdu); !ueAssociated {
+			g.handleNonUeAssociatedN2Message(pdu)
+			continue
+		}
+
+		ranUe, exists := g.findN2MessageUe(pdu)
+		if !exists {
+			g.NgapLog.Warnf("Dropped NGAP PDU from AMF for no UE: %+v", pdu)
+			continue
+		}
+
+		// the modify confirm answers the modify indication of the API, which waits for it while the UE is served
+		if isPduSessionResourceModifyConfirm(pdu) {
+			select {
+			case ranUe.pduSessionModifyConfirm <- &n2Message{raw: raw, pdu: pdu}:
+			default:
+				g.NgapLog.Warnf("Unexpected PDU Session Resource Modify Confirm from AMF for UE %s", ranUe.GetMobileIdentityIMSI())
+			}
+			continue
+		}
+
+		select {
+		case ranUe.n2Messages <- &n2Message{raw: raw, pdu: pdu}:
+		case <-ranUe.released:
+			g.NgapLog.Warnf("Dropped NGAP PDU from AMF for released UE %s", ranUe.GetMobileIdentityIMSI())
+		}
+	}
+}
+
+func isPduSessionResourceModifyConfirm(pdu *ngapType.NGAPPDU) bool {
+	return pdu.Present == ngapType.NGAPPDUPresentSuccessfulOutcome && pdu.SuccessfulOutcome.ProcedureCode.Value == ngapType.ProcedureCodePDUSessionResourceModifyIndication
+}
+
+// receiveN2Message waits for the next NGAP PDU from AMF for the UE, an error indication of the UE fails the procedure waiting for it
+func (g *Gnb) receiveN2Message(ranUe *RanUe) (*n2Message, error) {
+	select {
+	case message := <-ranUe.n2Messages:
+		if cause, isErrorIndication := getErrorIndicationCause(message.pdu); isErrorIndication {
+			return nil, fmt.Errorf("error indication from AMF, cause: %s", ngapCauseToString(cause))
+		}
+		return message, nil
+	case <-g.n2Closed:
+		return nil, fmt.Errorf("N2 connection closed")
+	}
+}
+
+// receivePduSessionModifyConfirm waits for the pdu session resource modify confirm of the modify indication sent for the UE
+func (g *Gnb) receivePduSessionModifyConfirm(ranUe *RanUe) (*n2Message, error) {
+	timer := time.NewTimer(constant.NGAP_PROCEDURE_TIMEOUT)
+	defer timer.Stop()
+
+	select {
+	case message := <-ranUe.pduSessionModifyConfirm:
+		return message, nil
+	case <-g.n2Closed:
+		return nil, fmt.Errorf("N2 connection closed")
+	case <-timer.C:
+		return nil, fmt.Errorf("pdu session resource modify confirm not received in %v", constant.NGAP_PROCEDURE_TIMEOUT)
+	}
+}
+
+// findN2MessageUe finds the UE of the NGAP PDU by its RAN UE NGAP ID, a UE context release command carrying
+// the AMF UE NGAP ID only is matched by that
+func (g *Gnb) findN2MessageUe(pdu *ngapType.NGAPPDU) (*RanUe, bool) {
+	ranUeNgapId, amfUeNgapId, exists := getN2MessageUeNgapId(pdu)
+	if !exists {
+		return nil, false
+	}
+
+	if ranUeNgapId != -1 {
+		ranUe, exists := g.ranUeIdToUe.Load(ranUeNgapId)
+		if !exists {
+			return nil, false
+		}
+		return ranUe.(*RanUe), true
+	}
+
+	return g.findN2MessageUeByAmfUeNgapId(amfUeNgapId)
+}
+
+func (g *Gnb) findN2MessageUeByAmfUeNgapId(amfUeNgapId int64) (*RanUe, bool) {
+	var found *RanUe
+	g.ranUeConns.Range(func(key, value any) bool {
+		if ranUe := key.(*RanUe); ranUe.GetAmfUeId() == amfUeNgapId {
+			found = ranUe
+			return false
+		}
+		return true
+	})
+	return found, found != nil
+}
+
+// getN2MessageUeNgapId returns the RAN UE NGAP ID of the UE associated NGAP PDU from AMF, or -1 with the AMF UE NGAP ID
+// if the PDU carries that only
+func getN2MessageUeNgapId(pdu *ngapType.NGAPPDU) (int64, int64, bool) {
+	switch pdu.Present {
+	case ngapType.NGAPPDUPresentInitiatingMessage:
+		value := pdu.InitiatingMessage.Value
+		switch pdu.InitiatingMessage.ProcedureCode.Value {
+		case ngapType.ProcedureCodeDownlinkNASTransport:
+			for _, ie := range value.DownlinkNASTransport.ProtocolIEs.List {
+				if ie.Id.Value == ngapType.ProtocolIEIDRANUENGAPID {
+					return ie.Value.RANUENGAPID.Value, 0, true
+				}
+			}
+		case ngapType.ProcedureCodeInitialContextSetup:
+			for _, ie := range value.InitialContextSetupRequest.ProtocolIEs.List {
+				if ie.Id.Value == ngapType.ProtocolIEIDRANUENGAPID {
+					return ie.Value.RANUENGAPID.Value, 0, true
+				}
+			}
+		case ngapType.ProcedureCodePDUSessionResourceSetup:
+			for _, ie := range value.PDUSessionResourceSetupRequest.ProtocolIEs.List {
+				if ie.Id.Value == ngapType.ProtocolIEIDRANUENGAPID {
+					return ie.Value.RANUENGAPID.Value, 0, true
+				}
+			}
+		case ngapType.ProcedureCodePDUSessionResourceRelease:
+			for _, ie := range value.PDUSessionResourceReleaseCommand.ProtocolIEs.List {
+				if ie.Id.Value == ngapType.ProtocolIEIDRANUENGAPID {
+					return ie.Value.RANUENGAPID.Value, 0, true
+				}
+			}
+		case ngapType.ProcedureCodePDUSessionResourceModify:
+			for _, ie := range value.PDUSessionResourceModifyRequest.ProtocolIEs.List {
+				if ie.Id.Value == ngapType.ProtocolIEIDRANUENGAPID {
+					return ie.Value.RANUENGAPID.Value, 0, true
+				}
+			}
+		case ngapType.ProcedureCodeErrorIndication:
+			// an error indication is UE associated when it carries the UE NGAP IDs
+			amfUeNgapId := int64(-1)
+			for _, ie := range value.ErrorIndication.ProtocolIEs.List {
+				switch ie.Id.Value {
+				case ngapType.ProtocolIEIDRANUENGAPID:
+					return ie.Value.RANUENGAPID.Value, 0, true
+				case ngapType.ProtocolIEIDAMFUENGAPID:
+					amfUeNgapId = ie.Value.AMFUENGAPID.Value
+				}
+			}
+			if amfUeNgapId != -1 {
+				return -1, amfUeNgapId, true
+			}
+		case ngapType.ProcedureCodeUEContextRelease:
+			for _, ie := range value.UEContextReleaseCommand.ProtocolIEs.List {
+				if ie.Id.Value != ngapType.ProtocolIEIDUENGAPIDs || ie.Value.UENGAPIDs == nil {
+					continue
+				}
+				switch ie.Value.UENGAPIDs.Present {
+				case ngapType.UENGAPIDsPresentUENGAPIDPair:
+					return ie.Value.UENGAPIDs.UENGAPIDPair.RANUENGAPID.Value, 0, true
+				case ngapType.UENGAPIDsPresentAMFUENGAPID:
+					return -1, ie.Value.UENGAPIDs.AMFUENGAPID.Value, true
+				}
+			}
+		}
+	case ngapType.NGAPPDUPresentSuccessfulOutcome:
+		if pdu.SuccessfulOutcome.ProcedureCode.Value == ngapType.ProcedureCodePDUSessionResourceModifyIndication {
+			for _, ie := range pdu.SuccessfulOutcome.Value.PDUSessionResourceModifyConfirm.ProtocolIEs.List {
+				if ie.Id.Value == ngapType.ProtocolIEIDRANUENGAPID {
+					return ie.Value.RANUENGAPID.Value, 0, true
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// handleNonUeAssociatedN2Message handles the NGAP PDU from AMF which is not associated with a UE, an NG Reset releases the UEs
+// it names or all, an AMF Configuration Update is acknowledged, and the other procedures, e.g. Paging of a UE in RRC_IDLE
+// which the gNB does not keep, are rejected with an Error Indication
+func (g *Gnb) handleNonUeAssociatedN2Message(pdu *ngapType.NGAPPDU) {
+	if pdu.Present != ngapType.NGAPPDUPresentInitiatingMessage {
+		g.NgapLog.Warnf("Dropped NGAP PDU from AMF for no UE: %+v", pdu)
+		return
+	}
+
+	switch procedureCode := pdu.InitiatingMessage.ProcedureCode.Value; procedureCode {
+	case ngapType.ProcedureCodeNGReset:
+		if err := g.processNgReset(pdu.InitiatingMessage.Value.NGReset); err != nil {
+			g.NgapLog.Errorf("Error process NG reset: %v", err)
+		}
+	case ngapType.ProcedureCodeAMFConfigurationUpdate:
+		if err := g.processAmfConfigurationUpdate(); err != nil {
+			g.NgapLog.Errorf("Error process AMF configuration update: %v", err)
+		}
+	case ngapType.ProcedureCodeErrorIndication:
+		cause, _ := getErrorIndicationCause(pdu)
+		g.NgapLog.Warnf("Receive Error Indication from AMF, cause: %s", ngapCauseToString(cause))
+	default:
+		g.NgapLog.Warnf("Unsupported NGAP procedure %d from AMF, rejected with Error Indication", procedureCode)
+		if err := g.sendErrorIndication(ngapType.Cause{
+			Present:  ngapType.CausePresentProtocol,
+			Protocol: &ngapType.CauseProtocol{Value: ngapType.CauseProtocolPresentMessageNotCompatibleWithReceiverState},
+		}); err != nil {
+			g.NgapLog.Errorf("Error send error indication: %v", err)
+		}
+	}
+}
+
+// processNgReset releases the UEs of the reset, all of them for a reset of the NG interface, by closing their connections,
+// and acknowledges the reset with the UE associated logical NG connections of a partial reset
+func (g *Gnb) processNgReset(ngReset *ngapType.NGReset) error {
+	var resetType *ngapType.ResetType
+	for _, ie := range ngReset.ProtocolIEs.List {
+		if ie.Id.Value == ngapType.ProtocolIEIDResetType {
+			resetType = ie.Value.ResetType
+		}
+	}
+	if resetType == nil {
+		return fmt.Errorf("no reset type in NG reset")
+	}
+
+	var partOfNgInterface *ngapType.UEAssociatedLogicalNGConnectionList
+	switch resetType.Present {
+	case ngapType.ResetTypePresentNGInterface:
+		g.NgapLog.Warnln("Receive NG Reset of the NG interface from AMF, releasing all UEs")
+		g.ranUeConns.Range(func(key, value any) bool {
+			g.resetRanUe(key.(*RanUe))
+			return true
+		})
+	case ngapType.ResetTypePresentPartOfNGInterface:
+		partOfNgInterface = resetType.PartOfNGInterface
+		g.NgapLog.Warnf("Receive NG Reset of %d UEs from AMF", len(partOfNgInterface.List))
+		for _, item := range partOfNgInterface.List {
+			var ranUe *RanUe
+			var exists bool
+			switch {
+			case item.RANUENGAPID != nil:
+				var value any
+				if value, exists = g.ranUeIdToUe.Load(item.RANUENGAPID.Value); exists {
+					ranUe = value.(*RanUe)
+				}
+			case item.AMFUENGAPID != nil:
+				ranUe, exists = g.findN2MessageUeByAmfUeNgapId(item.AMFUENGAPID.Value)
+			}
+			if exists {
+				g.resetRanUe(ranUe)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported reset type: %d", resetType.Present)
+	}
+
+	ngResetAcknowledge, err := getNgResetAcknowledge(partOfNgInterface)
+	if err != nil {
+		return fmt.Errorf("error get NG reset acknowledge: %v", err)
+	}
+	n, err := g.n2Conn.Write(ngResetAcknowledge)
+	if err != nil {
+		return fmt.Errorf("error send NG reset acknowledge: %v", err)
+	}
+	g.NgapLog.Tracef("Sent %d bytes of NG reset acknowledge to AMF", n)
+	g.NgapLog.Debugln("Send NG Reset Acknowledge to AMF")
+	return nil
+}
+
+// resetRanUe releases the UE whose NG context is reset by closing its connection, the UE is cleaned up by its handler
+func (g *Gnb) resetRanUe(ranUe *RanUe) {
+	if err := ranUe.GetN1Conn().Close(); err != nil {
+		g.RanLog.Warnf("Error closing connection of reset UE %s: %v", ranUe.GetMobileIdentityIMSI(), err)
+	}
+	g.RanLog.Infof("UE %s released by NG reset", ranUe.GetMobileIdentityIMSI())
+}
+
+// processAmfConfigurationUpdate acknowledges the AMF configuration update, the gNB keeps no AMF configuration it changes
+func (g *Gnb) processAmfConfigurationUpdate() error {
+	g.NgapLog.Debugln("Receive AMF Configuration Update from AMF")
+
+	amfConfigurationUpdateAcknowledge, err := getAmfConfigurationUpdateAcknowledge()
+	if err != nil {
+		return fmt.Errorf("error get AMF configuration update acknowledge: %v", err)
+	}
+	n, err := g.n2Conn.Write(amfConfigurationUpdateAcknowledge)
+	if err != nil {
+		return fmt.Errorf("error send AMF configuration update acknowledge: %v", err)
+	}
+	g.NgapLog.Tracef("Sent %d bytes of AMF configuration update acknowledge to AMF", n)
+	g.NgapLog.Debugln("Send AMF Configuration Update Acknowledge to AMF")
+	return nil
+}
+
+func (g *Gnb) sendErrorIndication(cause ngapType.Cause) error {
+	errorIndication, err := getErrorIndication(cause)
+	if err != nil {
+		return fmt.Errorf("error get error indication: %v", err)
+	}
+	n, err := g.n2Conn.Write(errorIndication)
+	if err != nil {
+		return fmt.Errorf("error send error indication: %v", err)
+	}
+	g.NgapLog.Tracef("Sent %d bytes of error indication to AMF", n)
+	g.NgapLog.Debugln("Send Error Indication to AMF")
+	return nil
+}
+
+// getErrorIndicationCause returns the cause of the NGAP PDU if it is an error indication, nil if it carries none
+func getErrorIndicationCause(pdu *ngapType.NGAPPDU) (*ngapType.Cause, bool) {
+	if pdu.Present != ngapType.NGAPPDUPresentInitiatingMessage || pdu.InitiatingMessage.ProcedureCode.Value != ngapType.ProcedureCodeErrorIndication {
+		return nil, false
+	}
+	for _, ie := range pdu.InitiatingMessage.Value.ErrorIndication.ProtocolIEs.List {
+		if ie.Id.Value == ngapType.ProtocolIEIDCause {
+			return ie.Value.Cause, true
+		}
+	}
+	return nil, true
+}
+
+func ngapCauseToString(cause *ngapType.Cause) string {
+	if cause == nil {
+		return "none"
+	}
+	switch cause.Present {
+	case ngapType.CausePresentRadioNetwork:
+		return fmt.Sprintf("radio network %d", cause.RadioNetwork.Value)
+	case ngapType.CausePresentTransport:
+		return fmt.Sprintf("transport %d", cause.Transport.Value)
+	case ngapType.CausePresentNas:
+		return fmt.Sprintf("nas %d", cause.Nas.Value)
+	case ngapType.CausePresentProtocol:
+		return fmt.Sprintf("protocol %d", cause.Protocol.Value)
+	case ngapType.CausePresentMisc:
+		return fmt.Sprintf("misc %d", cause.Misc.Value)
+	default:
+		return fmt.Sprintf("unknown %d", cause.Present)
+	}
+}
@@ -0,0 +1,311 @@
+package gnb
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/channel"
+	"github.com/Alonza0314/free-ran-ue/logger"
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/free5gc/nas/nasType"
+	"github.com/free5gc/ngap"
+	"github.com/free5gc/ngap/ngapType"
+)
+
+func testDownlinkNasTransport(ranUeNgapId int64, nasPdu []byte) *ngapType.NGAPPDU {
+	pdu := &ngapType.NGAPPDU{
+		Present: ngapType.NGAPPDUPresentInitiatingMessage,
+		InitiatingMessage: &ngapType.InitiatingMessage{
+			ProcedureCode: ngapType.ProcedureCode{Value: ngapType.ProcedureCodeDownlinkNASTransport},
+			Value: ngapType.InitiatingMessageValue{
+				Present: ngapType.InitiatingMessagePresentDownlinkNASTransport,
+				DownlinkNASTransport: &ngapType.DownlinkNASTransport{
+					ProtocolIEs: ngapType.ProtocolIEContainerDownlinkNASTransportIEs{
+						List: []ngapType.DownlinkNASTransportIEs{
+							{
+								Id:    ngapType.ProtocolIEID{Value: ngapType.ProtocolIEIDAMFUENGAPID},
+								Value: ngapType.DownlinkNASTransportIEsValue{AMFUENGAPID: &ngapType.AMFUENGAPID{Value: 7}},
+							},
+							{
+								Id:    ngapType.ProtocolIEID{Value: ngapType.ProtocolIEIDRANUENGAPID},
+								Value: ngapType.DownlinkNASTransportIEsValue{RANUENGAPID: &ngapType.RANUENGAPID{Value: ranUeNgapId}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if nasPdu != nil {
+		downlinkNasTransport := pdu.InitiatingMessage.Value.DownlinkNASTransport
+		downlinkNasTransport.ProtocolIEs.List = append(downlinkNasTransport.ProtocolIEs.List, ngapType.DownlinkNASTransportIEs{
+			Id:    ngapType.ProtocolIEID{Value: ngapType.ProtocolIEIDNASPDU},
+			Value: ngapType.DownlinkNASTransportIEsValue{NASPDU: &ngapType.NASPDU{Value: nasPdu}},
+		})
+	}
+	return pdu
+}
+
+func testUeContextReleaseCommand(ueNgapIds ngapType.UENGAPIDs) *ngapType.NGAPPDU {
+	return &ngapType.NGAPPDU{
+		Present: ngapType.NGAPPDUPresentInitiatingMessage,
+		InitiatingMessage: &ngapType.InitiatingMessage{
+			ProcedureCode: ngapType.ProcedureCode{Value: ngapType.ProcedureCodeUEContextRelease},
+			Value: ngapType.InitiatingMessageValue{
+				Present: ngapType.InitiatingMessagePresentUEContextReleaseCommand,
+				UEContextReleaseCommand: &ngapType.UEContextReleaseCommand{
+					ProtocolIEs: ngapType.ProtocolIEContainerUEContextReleaseCommandIEs{
+						List: []ngapType.UEContextReleaseCommandIEs{
+							{
+								Id:    ngapType.ProtocolIEID{Value: ngapType.ProtocolIEIDUENGAPIDs},
+								Value: ngapType.UEContextReleaseCommandIEsValue{UENGAPIDs: &ueNgapIds},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func testErrorIndication(amfUeNgapId, ranUeNgapId int64) *ngapType.NGAPPDU {
+	pdu := buildErrorIndication(ngapType.Cause{
+		Present:  ngapType.CausePresentProtocol,
+		Protocol: &ngapType.CauseProtocol{Value: ngapType.CauseProtocolPresentUnspecified},
+	})
+	errorIndicationIEs := &pdu.InitiatingMessage.Value.ErrorIndication.ProtocolIEs
+	if amfUeNgapId != -1 {
+		errorIndicationIEs.List = append(errorIndicationIEs.List, ngapType.ErrorIndicationIEs{
+			Id:    ngapType.ProtocolIEID{Value: ngapType.ProtocolIEIDAMFUENGAPID},
+			Value: ngapType.ErrorIndicationIEsValue{AMFUENGAPID: &ngapType.AMFUENGAPID{Value: amfUeNgapId}},
+		})
+	}
+	if ranUeNgapId != -1 {
+		errorIndicationIEs.List = append(errorIndicationIEs.List, ngapType.ErrorIndicationIEs{
+			Id:    ngapType.ProtocolIEID{Value: ngapType.ProtocolIEIDRANUENGAPID},
+			Value: ngapType.ErrorIndicationIEsValue{RANUENGAPID: &ngapType.RANUENGAPID{Value: ranUeNgapId}},
+		})
+	}
+	return &pdu
+}
+
+var testFindN2MessageUeCases = []struct {
+	name          string
+	pdu           *ngapType.NGAPPDU
+	expectedUe    int
+	expectedFound bool
+}{
+	{
+		name:          "testDownlinkNasTransportOfFirstUe",
+		pdu:           testDownlinkNasTransport(1, nil),
+		expectedUe:    0,
+		expectedFound: true,
+	},
+	{
+		name:          "testDownlinkNasTransportOfSecondUe",
+		pdu:           testDownlinkNasTransport(2, nil),
+		expectedUe:    1,
+		expectedFound: true,
+	},
+	{
+		name:          "testDownlinkNasTransportOfUnknownUe",
+		pdu:           testDownlinkNasTransport(3, nil),
+		expectedFound: false,
+	},
+	{
+		name: "testUeContextReleaseCommandWithUeNgapIdPair",
+		pdu: testUeContextReleaseCommand(ngapType.UENGAPIDs{
+			Present: ngapType.UENGAPIDsPresentUENGAPIDPair,
+			UENGAPIDPair: &ngapType.UENGAPIDPair{
+				AMFUENGAPID: ngapType.AMFUENGAPID{Value: 20},
+				RANUENGAPID: ngapType.RANUENGAPID{Value: 2},
+			},
+		}),
+		expectedUe:    1,
+		expectedFound: true,
+	},
+	{
+		name: "testUeContextReleaseCommandWithAmfUeNgapId",
+		pdu: testUeContextReleaseCommand(ngapType.UENGAPIDs{
+			Present:     ngapType.UENGAPIDsPresentAMFUENGAPID,
+			AMFUENGAPID: &ngapType.AMFUENGAPID{Value: 10},
+		}),
+		expectedUe:    0,
+		expectedFound: true,
+	},
+	{
+		name:          "testErrorIndicationWithRanUeNgapId",
+		pdu:           testErrorIndication(20, 2),
+		expectedUe:    1,
+		expectedFound: true,
+	},
+	{
+		name:          "testErrorIndicationWithAmfUeNgapId",
+		pdu:           testErrorIndication(10, -1),
+		expectedUe:    0,
+		expectedFound: true,
+	},
+	{
+		name:          "testErrorIndicationWithoutUeNgapId",
+		pdu:           testErrorIndication(-1, -1),
+		expectedFound: false,
+	},
+	{
+		name: "testNonUeAssociatedPdu",
+		pdu: &ngapType.NGAPPDU{
+			Present: ngapType.NGAPPDUPresentInitiatingMessage,
+			InitiatingMessage: &ngapType.InitiatingMessage{
+				ProcedureCode: ngapType.ProcedureCode{Value: ngapType.ProcedureCodeAMFConfigurationUpdate},
+			},
+		},
+		expectedFound: false,
+	},
+}
+
+func TestFindN2MessageUe(t *testing.T) {
+	g := &Gnb{}
+	ranUes := []*RanUe{
+		{amfUeNgapId: 10, ranUeNgapId: 1},
+		{amfUeNgapId: 20, ranUeNgapId: 2},
+	}
+	for _, ranUe := range ranUes {
+		g.ranUeConns.Store(ranUe, struct{}{})
+		g.ranUeIdToUe.Store(ranUe.GetRanUeId(), ranUe)
+	}
+
+	for _, testCase := range testFindN2MessageUeCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ranUe, found := g.findN2MessageUe(testCase.pdu)
+			if found != testCase.expectedFound {
+				t.Fatalf("expected found %v, got %v", testCase.expectedFound, found)
+			}
+			if found && ranUe != ranUes[testCase.expectedUe] {
+				t.Errorf("expected UE with RAN UE NGAP ID %d, got %d", ranUes[testCase.expectedUe].GetRanUeId(), ranUe.GetRanUeId())
+			}
+		})
+	}
+}
+
+var testNonUeAssociatedN2MessageCases = []struct {
+	name               string
+	pdu                ngapType.NGAPPDU
+	expectedCode       int64
+	expectedIsResponse bool
+}{
+	{
+		name:               "testNgResetAcknowledgeOfNgInterface",
+		pdu:                buildNgResetAcknowledge(nil),
+		expectedCode:       ngapType.ProcedureCodeNGReset,
+		expectedIsResponse: true,
+	},
+	{
+		name: "testNgResetAcknowledgeOfPartOfNgInterface",
+		pdu: buildNgResetAcknowledge(&ngapType.UEAssociatedLogicalNGConnectionList{
+			List: []ngapType.UEAssociatedLogicalNGConnectionItem{
+				{RANUENGAPID: &ngapType.RANUENGAPID{Value: 1}},
+			},
+		}),
+		expectedCode:       ngapType.ProcedureCodeNGReset,
+		expectedIsResponse: true,
+	},
+	{
+		name:               "testAmfConfigurationUpdateAcknowledge",
+		pdu:                buildAmfConfigurationUpdateAcknowledge(),
+		expectedCode:       ngapType.ProcedureCodeAMFConfigurationUpdate,
+		expectedIsResponse: true,
+	},
+	{
+		name:               "testErrorIndication",
+		pdu:                *testErrorIndication(-1, -1),
+		expectedCode:       ngapType.ProcedureCodeErrorIndication,
+		expectedIsResponse: false,
+	},
+}
+
+func TestNonUeAssociatedN2Message(t *testing.T) {
+	for _, testCase := range testNonUeAssociatedN2MessageCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, _, ueAssociated := getN2MessageUeNgapId(&testCase.pdu); ueAssociated {
+				t.Fatalf("expected non UE associated NGAP PDU")
+			}
+
+			encoded, err := ngap.Encoder(testCase.pdu)
+			if err != nil {
+				t.Fatalf("error encode NGAP PDU: %v", err)
+			}
+			decoded, err := ngap.Decoder(encoded)
+			if err != nil {
+				t.Fatalf("error decode NGAP PDU: %v", err)
+			}
+
+			if testCase.expectedIsResponse {
+				if decoded.Present != ngapType.NGAPPDUPresentSuccessfulOutcome {
+					t.Fatalf("expected successful outcome, got %d", decoded.Present)
+				}
+				if decoded.SuccessfulOutcome.ProcedureCode.Value != testCase.expectedCode {
+					t.Errorf("expected procedure code %d, got %d", testCase.expectedCode, decoded.SuccessfulOutcome.ProcedureCode.Value)
+				}
+				return
+			}
+
+			cause, isErrorIndication := getErrorIndicationCause(decoded)
+			if !isErrorIndication || cause == nil {
+				t.Fatalf("expected error indication with cause")
+			}
+			if ngapCauseToString(cause) != "protocol 6" {
+				t.Errorf("expected cause protocol 6, got %s", ngapCauseToString(cause))
+			}
+		})
+	}
+}
+
+func TestServeN1ForwardsUnsolicitedDownlinkNas(t *testing.T) {
+	gnbLogger := logger.NewGnbLogger("error", "", true)
+	g := &Gnb{
+		n2Closed:  make(chan struct{}),
+		GnbLogger: &gnbLogger,
+	}
+
+	n1Conn, ueConn := net.Pipe()
+	defer ueConn.Close()
+	ranUe := NewRanUe(n1Conn, nil, NewRanUeNgapIdGenerator(), 4, time.Second, channel.Profile{})
+	ranUe.SetMobileIdentity5GS(nasType.MobileIdentity5GS{
+		Len:    13,
+		Buffer: []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10},
+	})
+
+	served := make(chan error, 1)
+	go func() {
+		served <- g.serveN1(ranUe)
+	}()
+
+	// the network sends the downlink nas transport while the UE sends nothing, e.g. a configuration update command
+	nasPdu := []byte{0x7e, 0x00, 0x54, 0x43, 0x01}
+	ranUe.n2Messages <- &n2Message{pdu: testDownlinkNasTransport(ranUe.GetRanUeId(), nasPdu)}
+
+	if err := ueConn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("set read deadline: %v", err)
+	}
+	message, err := protocol.ReadMessage(ueConn)
+	if err != nil {
+		t.Fatalf("expected downlink nas forwarded to UE, got %v", err)
+	}
+	if message.Type != protocol.MESSAGE_TYPE_NAS || !bytes.Equal(message.Payload, nasPdu) {
+		t.Errorf("expected NAS message %x, got %s message %x", nasPdu, message.Type, message.Payload)
+	}
+
+	// the UE is served until its connection is closed
+	if err := ueConn.Close(); err != nil {
+		t.Fatalf("close UE connection: %v", err)
+	}
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Errorf("expected serving N1 to end with an error on the closed UE connection")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected serving N1 to end with the UE connection")
+	}
+}
@@ -0,0 +1,84 @@
+package gnb
+
+import (
+	"fmt"
+
+	"github.com/free5gc/aper"
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasType"
+	"github.com/free5gc/ngap/ngapType"
+)
+
+// the plain 5GMM message follows the security header, message authentication code and sequence number of a protected nas message
+const nasSecurityHeaderLength = 7
+
+// getPlainNasPdu returns the plain 5GMM message of a plain or integrity protected only nas message,
+// a ciphered message is not readable by the gNB
+func getPlainNasPdu(nasPdu []byte) ([]byte, bool) {
+	if len(nasPdu) < 3 {
+		return nil, false
+	}
+
+	switch nas.GetSecurityHeaderType(nasPdu) & 0x0f {
+	case nas.SecurityHeaderTypePlainNas:
+		return nasPdu, true
+	case nas.SecurityHeaderTypeIntegrityProtected, nas.SecurityHeaderTypeIntegrityProtectedWithNew5gNasSecurityContext:
+		if len(nasPdu) < nasSecurityHeaderLength+3 {
+			return nil, false
+		}
+		return nasPdu[nasSecurityHeaderLength:], true
+	default:
+		return nil, false
+	}
+}
+
+// getFiveGSTmsi converts the 5G-S-TMSI of a service request to the one of the initial UE message, the AMF set id is
+// the 8 bits of its first octet and the top 2 bits of the second one, which the AMF pointer takes the rest of
+func getFiveGSTmsi(tmsi5GS nasType.TMSI5GS) *ngapType.FiveGSTMSI {
+	return &ngapType.FiveGSTMSI{
+		AMFSetID: ngapType.AMFSetID{
+			Value: aper.BitString{Bytes: []byte{tmsi5GS.Octet[1], tmsi5GS.Octet[2] & 0xc0}, BitLength: 10},
+		},
+		AMFPointer: ngapType.AMFPointer{
+			Value: aper.BitString{Bytes: []byte{(tmsi5GS.Octet[2] & 0x3f) << 2}, BitLength: 6},
+		},
+		FiveGTMSI: ngapType.FiveGTMSI{
+			Value: aper.OctetString(append([]byte{}, tmsi5GS.Octet[3:7]...)),
+		},
+	}
+}
+
+// getNasPduFromInitialContextSetupRequest returns the nas pdu of the initial context setup request, e.g. the registration accept,
+// nil if there is none
+func getNasPduFromInitialContextSetupRequest(initialContextSetupRequest *ngapType.InitialContextSetupRequest) []byte {
+	for _, ie := range initialContextSetupRequest.ProtocolIEs.List {
+		if ie.Id.Value == ngapType.ProtocolIEIDNASPDU && ie.Value.NASPDU != nil {
+			return append([]byte{}, ie.Value.NASPDU.Value...)
+		}
+	}
+	return nil
+}
+
+// getPduSessionResourceSetupListFromInitialContextSetupRequest returns the pdu sessions the initial context setup request sets up,
+// e.g. the ones whose user plane is reactivated by a service request
+func getPduSessionResourceSetupListFromInitialContextSetupRequest(initialContextSetupRequest *ngapType.InitialContextSetupRequest) []ngapType.PDUSessionResourceSetupItemCxtReq {
+	for _, ie := range initialContextSetupRequest.ProtocolIEs.List {
+		if ie.Id.Value == ngapType.ProtocolIEIDPDUSessionResourceSetupListCxtReq && ie.Value.PDUSessionResourceSetupListCxtReq != nil {
+			return ie.Value.PDUSessionResourceSetupListCxtReq.List
+		}
+	}
+	return nil
+}
+
+// getNasPduFromDownlinkNasTransport returns the nas pdu of the downlink nas transport
+func getNasPduFromDownlinkNasTransport(downlinkNasTransport *ngapType.DownlinkNASTransport) ([]byte, error) {
+	for _, ie := range downlinkNasTransport.ProtocolIEs.List {
+		if ie.Id.Value == ngapType.ProtocolIEIDNASPDU {
+			if ie.Value.NASPDU == nil {
+				return nil, fmt.Errorf("NASPDU is nil")
+			}
+			return append([]byte{}, ie.Value.NASPDU.Value...), nil
+		}
+	}
+	return nil, fmt.Errorf("no NASPDU")
+}
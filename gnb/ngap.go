@@ -9,7 +9,7 @@ import (
 	"github.com/free5gc/ngap/ngapType"
 )
 
-func buildNgapSetupRequest(gnbId []byte, gnbName string, plmnId ngapType.PLMNIdentity, tai ngapType.TAI, snssai ngapType.SNSSAI) ngapType.NGAPPDU {
+func buildNgapSetupRequest(gnbId []byte, gnbName string, plmnId ngapType.PLMNIdentity, supportedTaList ngapType.SupportedTAList) ngapType.NGAPPDU {
 	pdu := ngapType.NGAPPDU{}
 
 	pdu.Present = ngapType.NGAPPDUPresentInitiatingMessage
@@ -62,28 +62,7 @@ func buildNgapSetupRequest(gnbId []byte, gnbName string, plmnId ngapType.PLMNIde
 	ie.Id.Value = ngapType.ProtocolIEIDSupportedTAList
 	ie.Criticality.Value = ngapType.CriticalityPresentReject
 	ie.Value.Present = ngapType.NGSetupRequestIEsPresentSupportedTAList
-	ie.Value.SupportedTAList = new(ngapType.SupportedTAList)
-
-	supportedTAList := ie.Value.SupportedTAList
-
-	supportedTAItem := ngapType.SupportedTAItem{}
-	supportedTAItem.TAC.Value = aper.OctetString(tai.TAC.Value)
-
-	broadcastPLMNList := &supportedTAItem.BroadcastPLMNList
-	broadcastPLMNItem := ngapType.BroadcastPLMNItem{}
-	broadcastPLMNItem.PLMNIdentity.Value = tai.PLMNIdentity.Value
-
-	sliceSupportList := &broadcastPLMNItem.TAISliceSupportList
-	sliceSupportItem := ngapType.SliceSupportItem{}
-	sliceSupportItem.SNSSAI.SST.Value = aper.OctetString(snssai.SST.Value)
-	sliceSupportItem.SNSSAI.SD = new(ngapType.SD)
-	sliceSupportItem.SNSSAI.SD.Value = aper.OctetString(snssai.SD.Value)
-
-	sliceSupportList.List = append(sliceSupportList.List, sliceSupportItem)
-
-	broadcastPLMNList.List = append(broadcastPLMNList.List, broadcastPLMNItem)
-
-	supportedTAList.List = append(supportedTAList.List, supportedTAItem)
+	ie.Value.SupportedTAList = &supportedTaList
 
 	nGSetupRequestIEs.List = append(nGSetupRequestIEs.List, ie)
 
@@ -100,11 +79,28 @@ func buildNgapSetupRequest(gnbId []byte, gnbName string, plmnId ngapType.PLMNIde
 	return pdu
 }
 
-func getNgapSetupRequest(gnbId []byte, gnbName string, plmnId ngapType.PLMNIdentity, tai ngapType.TAI, snssai ngapType.SNSSAI) ([]byte, error) {
-	return ngap.Encoder(buildNgapSetupRequest(gnbId, gnbName, plmnId, tai, snssai))
+func getNgapSetupRequest(gnbId []byte, gnbName string, plmnId ngapType.PLMNIdentity, supportedTaList ngapType.SupportedTAList) ([]byte, error) {
+	return ngap.Encoder(buildNgapSetupRequest(gnbId, gnbName, plmnId, supportedTaList))
+}
+
+// buildUserLocationInformation reports the serving cell of the UE
+func buildUserLocationInformation(nrCgi ngapType.NRCGI, tai ngapType.TAI) *ngapType.UserLocationInformation {
+	userLocationInformation := new(ngapType.UserLocationInformation)
+	userLocationInformation.Present = ngapType.UserLocationInformationPresentUserLocationInformationNR
+	userLocationInformation.UserLocationInformationNR = new(ngapType.UserLocationInformationNR)
+
+	userLocationInformationNR := userLocationInformation.UserLocationInformationNR
+	userLocationInformationNR.NRCGI.PLMNIdentity.Value = nrCgi.PLMNIdentity.Value
+	userLocationInformationNR.NRCGI.NRCellIdentity.Value = nrCgi.NRCellIdentity.Value
+
+	userLocationInformationNR.TAI.PLMNIdentity.Value = tai.PLMNIdentity.Value
+	userLocationInformationNR.TAI.TAC.Value = tai.TAC.Value
+
+	return userLocationInformation
 }
 
-func buildInitialUeMessage(ranUeNgapId int64, ueRegistrationRequest []byte, plmnId ngapType.PLMNIdentity, tai ngapType.TAI) ngapType.NGAPPDU {
+// buildInitialUeMessage carries the initial nas of the UE, and the 5G-S-TMSI of a UE sending a service request
+func buildInitialUeMessage(ranUeNgapId int64, initialNas []byte, fiveGSTmsi *ngapType.FiveGSTMSI, nrCgi ngapType.NRCGI, tai ngapType.TAI) ngapType.NGAPPDU {
 	pdu := ngapType.NGAPPDU{}
 
 	pdu.Present = ngapType.NGAPPDUPresentInitiatingMessage
@@ -140,7 +136,7 @@ func buildInitialUeMessage(ranUeNgapId int64, ueRegistrationRequest []byte, plmn
 	ie.Value.NASPDU = new(ngapType.NASPDU)
 
 	nasPDU := ie.Value.NASPDU
-	nasPDU.Value = ueRegistrationRequest
+	nasPDU.Value = initialNas
 
 	initialUEMessageIEs.List = append(initialUEMessageIEs.List, ie)
 
@@ -149,20 +145,7 @@ func buildInitialUeMessage(ranUeNgapId int64, ueRegistrationRequest []byte, plmn
 	ie.Id.Value = ngapType.ProtocolIEIDUserLocationInformation
 	ie.Criticality.Value = ngapType.CriticalityPresentReject
 	ie.Value.Present = ngapType.InitialUEMessageIEsPresentUserLocationInformation
-	ie.Value.UserLocationInformation = new(ngapType.UserLocationInformation)
-
-	userLocationInformation := ie.Value.UserLocationInformation
-	userLocationInformation.Present = ngapType.UserLocationInformationPresentUserLocationInformationNR
-	userLocationInformation.UserLocationInformationNR = new(ngapType.UserLocationInformationNR)
-
-	userLocationInformationNR := userLocationInformation.UserLocationInformationNR
-	userLocationInformationNR.NRCGI.PLMNIdentity.Value = plmnId.Value
-	userLocationInformationNR.NRCGI.NRCellIdentity.Value = aper.BitString{
-		Bytes:     []byte{0x00, 0x00, 0x00, 0x00, 0x10},
-		BitLength: 36,
-	}
-	userLocationInformationNR.TAI.PLMNIdentity.Value = tai.PLMNIdentity.Value
-	userLocationInformationNR.TAI.TAC.Value = tai.TAC.Value
+	ie.Value.UserLocationInformation = buildUserLocationInformation(nrCgi, tai)
 
 	initialUEMessageIEs.List = append(initialUEMessageIEs.List, ie)
 
@@ -178,6 +161,17 @@ func buildInitialUeMessage(ranUeNgapId int64, ueRegistrationRequest []byte, plmn
 
 	initialUEMessageIEs.List = append(initialUEMessageIEs.List, ie)
 
+	// 5G-S-TMSI
+	if fiveGSTmsi != nil {
+		ie = ngapType.InitialUEMessageIEs{}
+		ie.Id.Value = ngapType.ProtocolIEIDFiveGSTMSI
+		ie.Criticality.Value = ngapType.CriticalityPresentReject
+		ie.Value.Present = ngapType.InitialUEMessageIEsPresentFiveGSTMSI
+		ie.Value.FiveGSTMSI = fiveGSTmsi
+
+		initialUEMessageIEs.List = append(initialUEMessageIEs.List, ie)
+	}
+
 	// UE Context Request
 	ie = ngapType.InitialUEMessageIEs{}
 	ie.Id.Value = ngapType.ProtocolIEIDUEContextRequest
@@ -190,12 +184,12 @@ func buildInitialUeMessage(ranUeNgapId int64, ueRegistrationRequest []byte, plmn
 	return pdu
 }
 
-func getInitialUeMessage(ranUeNgapId int64, ueRegistrationRequest []byte, plmnId ngapType.PLMNIdentity, tai ngapType.TAI) ([]byte, error) {
-	initialUeMessage := buildInitialUeMessage(ranUeNgapId, ueRegistrationRequest, plmnId, tai)
+func getInitialUeMessage(ranUeNgapId int64, initialNas []byte, fiveGSTmsi *ngapType.FiveGSTMSI, nrCgi ngapType.NRCGI, tai ngapType.TAI) ([]byte, error) {
+	initialUeMessage := buildInitialUeMessage(ranUeNgapId, initialNas, fiveGSTmsi, nrCgi, tai)
 	return ngap.Encoder(initialUeMessage)
 }
 
-func buildUplinkNasTransport(amfUeNgapId int64, ranUeNgapId int64, plmnId ngapType.PLMNIdentity, tai ngapType.TAI, nasPdu []byte) ngapType.NGAPPDU {
+func buildUplinkNasTransport(amfUeNgapId int64, ranUeNgapId int64, nrCgi ngapType.NRCGI, tai ngapType.TAI, nasPdu []byte) ngapType.NGAPPDU {
 	pdu := ngapType.NGAPPDU{}
 
 	pdu.Present = ngapType.NGAPPDUPresentInitiatingMessage
@@ -252,33 +246,20 @@ func buildUplinkNasTransport(amfUeNgapId int64, ranUeNgapId int64, plmnId ngapTy
 	ie.Id.Value = ngapType.ProtocolIEIDUserLocationInformation
 	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
 	ie.Value.Present = ngapType.UplinkNASTransportIEsPresentUserLocationInformation
-	ie.Value.UserLocationInformation = new(ngapType.UserLocationInformation)
-
-	userLocationInformation := ie.Value.UserLocationInformation
-	userLocationInformation.Present = ngapType.UserLocationInformationPresentUserLocationInformationNR
-	userLocationInformation.UserLocationInformationNR = new(ngapType.UserLocationInformationNR)
-
-	userLocationInformationNR := userLocationInformation.UserLocationInformationNR
-	userLocationInformationNR.NRCGI.PLMNIdentity.Value = plmnId.Value
-	userLocationInformationNR.NRCGI.NRCellIdentity.Value = aper.BitString{
-		Bytes:     []byte{0x00, 0x00, 0x00, 0x00, 0x10},
-		BitLength: 36,
-	}
-
-	userLocationInformationNR.TAI.PLMNIdentity.Value = tai.PLMNIdentity.Value
-	userLocationInformationNR.TAI.TAC.Value = tai.TAC.Value
+	ie.Value.UserLocationInformation = buildUserLocationInformation(nrCgi, tai)
 
 	uplinkNasTransportIEs.List = append(uplinkNasTransportIEs.List, ie)
 
 	return pdu
 }
 
-func getUplinkNasTransport(amfUeNgapId int64, ranUeNgapId int64, plmnId ngapType.PLMNIdentity, tai ngapType.TAI, nasPdu []byte) ([]byte, error) {
-	uplinkNasTransport := buildUplinkNasTransport(amfUeNgapId, ranUeNgapId, plmnId, tai, nasPdu)
+func getUplinkNasTransport(amfUeNgapId int64, ranUeNgapId int64, nrCgi ngapType.NRCGI, tai ngapType.TAI, nasPdu []byte) ([]byte, error) {
+	uplinkNasTransport := buildUplinkNasTransport(amfUeNgapId, ranUeNgapId, nrCgi, tai, nasPdu)
 	return ngap.Encoder(uplinkNasTransport)
 }
 
-func buildNgapInitialContextSetupResponse(amfUeNgapId, ranUeNgapId int64) ngapType.NGAPPDU {
+// buildNgapInitialContextSetupResponse lists the pdu sessions set up by the request, e.g. reactivated by a service request
+func buildNgapInitialContextSetupResponse(amfUeNgapId, ranUeNgapId int64, pduSessionResourceSetupItems []ngapType.PDUSessionResourceSetupItemCxtRes) ngapType.NGAPPDU {
 	pdu := ngapType.NGAPPDU{}
 
 	pdu.Present = ngapType.NGAPPDUPresentSuccessfulOutcome
@@ -318,11 +299,23 @@ func buildNgapInitialContextSetupResponse(amfUeNgapId, ranUeNgapId int64) ngapTy
 
 	initialContextSetupResponseIEs.List = append(initialContextSetupResponseIEs.List, ie)
 
+	// PDU Session Resource Setup Response List
+	if len(pduSessionResourceSetupItems) > 0 {
+		ie = ngapType.InitialContextSetupResponseIEs{}
+		ie.Id.Value = ngapType.ProtocolIEIDPDUSessionResourceSetupListCxtRes
+		ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+		ie.Value.Present = ngapType.InitialContextSetupResponseIEsPresentPDUSessionResourceSetupListCxtRes
+		ie.Value.PDUSessionResourceSetupListCxtRes = new(ngapType.PDUSessionResourceSetupListCxtRes)
+		ie.Value.PDUSessionResourceSetupListCxtRes.List = pduSessionResourceSetupItems
+
+		initialContextSetupResponseIEs.List = append(initialContextSetupResponseIEs.List, ie)
+	}
+
 	return pdu
 }
 
-func getNgapInitialContextSetupResponse(amfUeNgapId, ranUeNgapId int64) ([]byte, error) {
-	initialContextSetupResponse := buildNgapInitialContextSetupResponse(amfUeNgapId, ranUeNgapId)
+func getNgapInitialContextSetupResponse(amfUeNgapId, ranUeNgapId int64, pduSessionResourceSetupItems []ngapType.PDUSessionResourceSetupItemCxtRes) ([]byte, error) {
+	initialContextSetupResponse := buildNgapInitialContextSetupResponse(amfUeNgapId, ranUeNgapId, pduSessionResourceSetupItems)
 	return ngap.Encoder(initialContextSetupResponse)
 }
 
@@ -431,7 +424,7 @@ func getPduSessionResourceSetupResponse(amfUeNgapId, ranUeNgapId, pduSessionId i
 	return ngap.Encoder(pduSessionResourceSetupResponse)
 }
 
-func buildNgapUeContextReleaseCompleteMessage(amfUeNgapId, ranUeNgapId int64, pduSessionIdList []int64, plmnId ngapType.PLMNIdentity, tai ngapType.TAI) ngapType.NGAPPDU {
+func buildNgapUeContextReleaseCompleteMessage(amfUeNgapId, ranUeNgapId int64, pduSessionIdList []int64, nrCgi ngapType.NRCGI, tai ngapType.TAI) ngapType.NGAPPDU {
 	pdu := ngapType.NGAPPDU{}
 
 	pdu.Present = ngapType.NGAPPDUPresentSuccessfulOutcome
@@ -476,21 +469,7 @@ func buildNgapUeContextReleaseCompleteMessage(amfUeNgapId, ranUeNgapId int64, pd
 	ie.Id.Value = ngapType.ProtocolIEIDUserLocationInformation
 	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
 	ie.Value.Present = ngapType.UEContextReleaseCompleteIEsPresentUserLocationInformation
-	ie.Value.UserLocationInformation = new(ngapType.UserLocationInformation)
-
-	userLocationInformation := ie.Value.UserLocationInformation
-	userLocationInformation.Present = ngapType.UserLocationInformationPresentUserLocationInformationNR
-	userLocationInformation.UserLocationInformationNR = new(ngapType.UserLocationInformationNR)
-
-	userLocationInformationNR := userLocationInformation.UserLocationInformationNR
-	userLocationInformationNR.NRCGI.PLMNIdentity.Value = plmnId.Value
-	userLocationInformationNR.NRCGI.NRCellIdentity.Value = aper.BitString{
-		Bytes:     []byte{0x00, 0x00, 0x00, 0x00, 0x10},
-		BitLength: 36,
-	}
-
-	userLocationInformationNR.TAI.PLMNIdentity.Value = tai.PLMNIdentity.Value
-	userLocationInformationNR.TAI.TAC.Value = tai.TAC.Value
+	ie.Value.UserLocationInformation = buildUserLocationInformation(nrCgi, tai)
 
 	uEContextReleaseCompleteIEs.List = append(uEContextReleaseCompleteIEs.List, ie)
 
@@ -516,11 +495,92 @@ func buildNgapUeContextReleaseCompleteMessage(amfUeNgapId, ranUeNgapId int64, pd
 	return pdu
 }
 
-func getNgapUeContextReleaseCompleteMessage(amfUeNgapId, ranUeNgapId int64, pduSessionIdList []int64, plmnId ngapType.PLMNIdentity, tai ngapType.TAI) ([]byte, error) {
-	ngapUeContextReleaseComplete := buildNgapUeContextReleaseCompleteMessage(amfUeNgapId, ranUeNgapId, pduSessionIdList, plmnId, tai)
+func getNgapUeContextReleaseCompleteMessage(amfUeNgapId, ranUeNgapId int64, pduSessionIdList []int64, nrCgi ngapType.NRCGI, tai ngapType.TAI) ([]byte, error) {
+	ngapUeContextReleaseComplete := buildNgapUeContextReleaseCompleteMessage(amfUeNgapId, ranUeNgapId, pduSessionIdList, nrCgi, tai)
 	return ngap.Encoder(ngapUeContextReleaseComplete)
 }
 
+// buildNgapUeContextReleaseRequest asks AMF to release the UE context, listing the pdu sessions of the UE
+func buildNgapUeContextReleaseRequest(amfUeNgapId, ranUeNgapId int64, pduSessionIdList []int64, causeRadioNetwork aper.Enumerated) ngapType.NGAPPDU {
+	pdu := ngapType.NGAPPDU{}
+
+	pdu.Present = ngapType.NGAPPDUPresentInitiatingMessage
+	pdu.InitiatingMessage = new(ngapType.InitiatingMessage)
+
+	initiatingMessage := pdu.InitiatingMessage
+	initiatingMessage.ProcedureCode.Value = ngapType.ProcedureCodeUEContextReleaseRequest
+	initiatingMessage.Criticality.Value = ngapType.CriticalityPresentIgnore
+
+	initiatingMessage.Value.Present = ngapType.InitiatingMessagePresentUEContextReleaseRequest
+	initiatingMessage.Value.UEContextReleaseRequest = new(ngapType.UEContextReleaseRequest)
+
+	uEContextReleaseRequest := initiatingMessage.Value.UEContextReleaseRequest
+	uEContextReleaseRequestIEs := &uEContextReleaseRequest.ProtocolIEs
+
+	// AMF UE NGAP ID
+	ie := ngapType.UEContextReleaseRequestIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDAMFUENGAPID
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	ie.Value.Present = ngapType.UEContextReleaseRequestIEsPresentAMFUENGAPID
+	ie.Value.AMFUENGAPID = new(ngapType.AMFUENGAPID)
+
+	aMFUENGAPID := ie.Value.AMFUENGAPID
+	aMFUENGAPID.Value = amfUeNgapId
+
+	uEContextReleaseRequestIEs.List = append(uEContextReleaseRequestIEs.List, ie)
+
+	// RAN UE NGAP ID
+	ie = ngapType.UEContextReleaseRequestIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDRANUENGAPID
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	ie.Value.Present = ngapType.UEContextReleaseRequestIEsPresentRANUENGAPID
+	ie.Value.RANUENGAPID = new(ngapType.RANUENGAPID)
+
+	rANUENGAPID := ie.Value.RANUENGAPID
+	rANUENGAPID.Value = ranUeNgapId
+
+	uEContextReleaseRequestIEs.List = append(uEContextReleaseRequestIEs.List, ie)
+
+	// PDU Session Resource List
+	if len(pduSessionIdList) > 0 {
+		ie = ngapType.UEContextReleaseRequestIEs{}
+		ie.Id.Value = ngapType.ProtocolIEIDPDUSessionResourceListCxtRelReq
+		ie.Criticality.Value = ngapType.CriticalityPresentReject
+		ie.Value.Present = ngapType.UEContextReleaseRequestIEsPresentPDUSessionResourceListCxtRelReq
+		ie.Value.PDUSessionResourceListCxtRelReq = new(ngapType.PDUSessionResourceListCxtRelReq)
+
+		pDUSessionResourceListCxtRelReq := ie.Value.PDUSessionResourceListCxtRelReq
+		for _, pduSessionId := range pduSessionIdList {
+			pDUSessionResourceItemCxtRelReq := ngapType.PDUSessionResourceItemCxtRelReq{}
+			pDUSessionResourceItemCxtRelReq.PDUSessionID.Value = pduSessionId
+			pDUSessionResourceListCxtRelReq.List = append(pDUSessionResourceListCxtRelReq.List, pDUSessionResourceItemCxtRelReq)
+		}
+
+		uEContextReleaseRequestIEs.List = append(uEContextReleaseRequestIEs.List, ie)
+	}
+
+	// Cause
+	ie = ngapType.UEContextReleaseRequestIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDCause
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.UEContextReleaseRequestIEsPresentCause
+	ie.Value.Cause = new(ngapType.Cause)
+
+	cause := ie.Value.Cause
+	cause.Present = ngapType.CausePresentRadioNetwork
+	cause.RadioNetwork = new(ngapType.CauseRadioNetwork)
+	cause.RadioNetwork.Value = causeRadioNetwork
+
+	uEContextReleaseRequestIEs.List = append(uEContextReleaseRequestIEs.List, ie)
+
+	return pdu
+}
+
+func getNgapUeContextReleaseRequest(amfUeNgapId, ranUeNgapId int64, pduSessionIdList []int64, causeRadioNetwork aper.Enumerated) ([]byte, error) {
+	ngapUeContextReleaseRequest := buildNgapUeContextReleaseRequest(amfUeNgapId, ranUeNgapId, pduSessionIdList, causeRadioNetwork)
+	return ngap.Encoder(ngapUeContextReleaseRequest)
+}
+
 func buildPDUSessionResourceModifyIndicationTransfer(dlTeid []byte, ranN3Ip string, qosId int64) ngapType.PDUSessionResourceModifyIndicationTransfer {
 	transferMessage := ngapType.PDUSessionResourceModifyIndicationTransfer{}
 
@@ -609,4 +669,234 @@ func buildPDUSessionResourceModifyIndication(amfUeNgapId, ranUeNgapId int64, pdu
 func getPDUSessionResourceModifyIndication(amfUeNgapId, ranUeNgapId int64, pduSessionId int64, pduSessionResourceModifyIndicationTransferMessage []byte) ([]byte, error) {
 	pduSessionResourceModifyIndication := buildPDUSessionResourceModifyIndication(amfUeNgapId, ranUeNgapId, pduSessionId, pduSessionResourceModifyIndicationTransferMessage)
 	return ngap.Encoder(pduSessionResourceModifyIndication)
-}
\ No newline at end of file
+}
+
+func buildPduSessionResourceReleaseResponseTransfer() ngapType.PDUSessionResourceReleaseResponseTransfer {
+	return ngapType.PDUSessionResourceReleaseResponseTransfer{}
+}
+
+func getPduSessionResourceReleaseResponseTransfer() ([]byte, error) {
+	transferMessage := buildPduSessionResourceReleaseResponseTransfer()
+	encodedTransferMessage, err := aper.MarshalWithParams(transferMessage, "valueExt")
+	if err != nil {
+		return nil, fmt.Errorf("error marshal pdu session resource release response transfer message: %v", err)
+	}
+	return encodedTransferMessage, nil
+}
+
+// buildPduSessionResourceReleaseResponse lists the released pdu sessions, each with the same release response transfer
+func buildPduSessionResourceReleaseResponse(amfUeNgapId, ranUeNgapId int64, pduSessionIdList []int64, pduSessionResourceReleaseResponseTransferMessage []byte) ngapType.NGAPPDU {
+	pdu := ngapType.NGAPPDU{}
+
+	pdu.Present = ngapType.NGAPPDUPresentSuccessfulOutcome
+	pdu.SuccessfulOutcome = new(ngapType.SuccessfulOutcome)
+
+	successfulOutcome := pdu.SuccessfulOutcome
+	successfulOutcome.ProcedureCode.Value = ngapType.ProcedureCodePDUSessionResourceRelease
+	successfulOutcome.Criticality.Value = ngapType.CriticalityPresentReject
+
+	successfulOutcome.Value.Present = ngapType.SuccessfulOutcomePresentPDUSessionResourceReleaseResponse
+	successfulOutcome.Value.PDUSessionResourceReleaseResponse = new(ngapType.PDUSessionResourceReleaseResponse)
+
+	releaseResponseIEs := &successfulOutcome.Value.PDUSessionResourceReleaseResponse.ProtocolIEs
+
+	// AMF UE NGAP ID
+	ie := ngapType.PDUSessionResourceReleaseResponseIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDAMFUENGAPID
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.PDUSessionResourceReleaseResponseIEsPresentAMFUENGAPID
+	ie.Value.AMFUENGAPID = new(ngapType.AMFUENGAPID)
+	ie.Value.AMFUENGAPID.Value = amfUeNgapId
+	releaseResponseIEs.List = append(releaseResponseIEs.List, ie)
+
+	// RAN UE NGAP ID
+	ie = ngapType.PDUSessionResourceReleaseResponseIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDRANUENGAPID
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.PDUSessionResourceReleaseResponseIEsPresentRANUENGAPID
+	ie.Value.RANUENGAPID = new(ngapType.RANUENGAPID)
+	ie.Value.RANUENGAPID.Value = ranUeNgapId
+	releaseResponseIEs.List = append(releaseResponseIEs.List, ie)
+
+	// PDU Session Resource Released List
+	ie = ngapType.PDUSessionResourceReleaseResponseIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDPDUSessionResourceReleasedListRelRes
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.PDUSessionResourceReleaseResponseIEsPresentPDUSessionResourceReleasedListRelRes
+	ie.Value.PDUSessionResourceReleasedListRelRes = new(ngapType.PDUSessionResourceReleasedListRelRes)
+
+	for _, pduSessionId := range pduSessionIdList {
+		releasedItem := ngapType.PDUSessionResourceReleasedItemRelRes{}
+		releasedItem.PDUSessionID.Value = pduSessionId
+		releasedItem.PDUSessionResourceReleaseResponseTransfer = pduSessionResourceReleaseResponseTransferMessage
+		ie.Value.PDUSessionResourceReleasedListRelRes.List = append(ie.Value.PDUSessionResourceReleasedListRelRes.List, releasedItem)
+	}
+
+	releaseResponseIEs.List = append(releaseResponseIEs.List, ie)
+
+	return pdu
+}
+
+func getPduSessionResourceReleaseResponse(amfUeNgapId, ranUeNgapId int64, pduSessionIdList []int64, pduSessionResourceReleaseResponseTransferMessage []byte) ([]byte, error) {
+	pduSessionResourceReleaseResponse := buildPduSessionResourceReleaseResponse(amfUeNgapId, ranUeNgapId, pduSessionIdList, pduSessionResourceReleaseResponseTransferMessage)
+	return ngap.Encoder(pduSessionResourceReleaseResponse)
+}
+
+// buildPduSessionResourceModifyResponseTransfer keeps the DL tunnel of the pdu session, so no IE is present
+func buildPduSessionResourceModifyResponseTransfer() ngapType.PDUSessionResourceModifyResponseTransfer {
+	return ngapType.PDUSessionResourceModifyResponseTransfer{}
+}
+
+func getPduSessionResourceModifyResponseTransfer() ([]byte, error) {
+	transferMessage := buildPduSessionResourceModifyResponseTransfer()
+	encodedTransferMessage, err := aper.MarshalWithParams(transferMessage, "valueExt")
+	if err != nil {
+		return nil, fmt.Errorf("error marshal pdu session resource modify response transfer message: %v", err)
+	}
+	return encodedTransferMessage, nil
+}
+
+// buildPduSessionResourceModifyResponse lists the modified pdu sessions, each with the same modify response transfer
+func buildPduSessionResourceModifyResponse(amfUeNgapId, ranUeNgapId int64, pduSessionIdList []int64, pduSessionResourceModifyResponseTransferMessage []byte) ngapType.NGAPPDU {
+	pdu := ngapType.NGAPPDU{}
+
+	pdu.Present = ngapType.NGAPPDUPresentSuccessfulOutcome
+	pdu.SuccessfulOutcome = new(ngapType.SuccessfulOutcome)
+
+	successfulOutcome := pdu.SuccessfulOutcome
+	successfulOutcome.ProcedureCode.Value = ngapType.ProcedureCodePDUSessionResourceModify
+	successfulOutcome.Criticality.Value = ngapType.CriticalityPresentReject
+
+	successfulOutcome.Value.Present = ngapType.SuccessfulOutcomePresentPDUSessionResourceModifyResponse
+	successfulOutcome.Value.PDUSessionResourceModifyResponse = new(ngapType.PDUSessionResourceModifyResponse)
+
+	modifyResponseIEs := &successfulOutcome.Value.PDUSessionResourceModifyResponse.ProtocolIEs
+
+	// AMF UE NGAP ID
+	ie := ngapType.PDUSessionResourceModifyResponseIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDAMFUENGAPID
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.PDUSessionResourceModifyResponseIEsPresentAMFUENGAPID
+	ie.Value.AMFUENGAPID = new(ngapType.AMFUENGAPID)
+	ie.Value.AMFUENGAPID.Value = amfUeNgapId
+	modifyResponseIEs.List = append(modifyResponseIEs.List, ie)
+
+	// RAN UE NGAP ID
+	ie = ngapType.PDUSessionResourceModifyResponseIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDRANUENGAPID
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.PDUSessionResourceModifyResponseIEsPresentRANUENGAPID
+	ie.Value.RANUENGAPID = new(ngapType.RANUENGAPID)
+	ie.Value.RANUENGAPID.Value = ranUeNgapId
+	modifyResponseIEs.List = append(modifyResponseIEs.List, ie)
+
+	// PDU Session Resource Modify Response List
+	ie = ngapType.PDUSessionResourceModifyResponseIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDPDUSessionResourceModifyListModRes
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.PDUSessionResourceModifyResponseIEsPresentPDUSessionResourceModifyListModRes
+	ie.Value.PDUSessionResourceModifyListModRes = new(ngapType.PDUSessionResourceModifyListModRes)
+
+	for _, pduSessionId := range pduSessionIdList {
+		modifyItem := ngapType.PDUSessionResourceModifyItemModRes{}
+		modifyItem.PDUSessionID.Value = pduSessionId
+		modifyItem.PDUSessionResourceModifyResponseTransfer = pduSessionResourceModifyResponseTransferMessage
+		ie.Value.PDUSessionResourceModifyListModRes.List = append(ie.Value.PDUSessionResourceModifyListModRes.List, modifyItem)
+	}
+
+	modifyResponseIEs.List = append(modifyResponseIEs.List, ie)
+
+	return pdu
+}
+
+func getPduSessionResourceModifyResponse(amfUeNgapId, ranUeNgapId int64, pduSessionIdList []int64, pduSessionResourceModifyResponseTransferMessage []byte) ([]byte, error) {
+	pduSessionResourceModifyResponse := buildPduSessionResourceModifyResponse(amfUeNgapId, ranUeNgapId, pduSessionIdList, pduSessionResourceModifyResponseTransferMessage)
+	return ngap.Encoder(pduSessionResourceModifyResponse)
+}
+
+func buildNgResetAcknowledge(partOfNgInterface *ngapType.UEAssociatedLogicalNGConnectionList) ngapType.NGAPPDU {
+	pdu := ngapType.NGAPPDU{}
+
+	pdu.Present = ngapType.NGAPPDUPresentSuccessfulOutcome
+	pdu.SuccessfulOutcome = new(ngapType.SuccessfulOutcome)
+
+	successfulOutcome := pdu.SuccessfulOutcome
+	successfulOutcome.ProcedureCode.Value = ngapType.ProcedureCodeNGReset
+	successfulOutcome.Criticality.Value = ngapType.CriticalityPresentReject
+
+	successfulOutcome.Value.Present = ngapType.SuccessfulOutcomePresentNGResetAcknowledge
+	successfulOutcome.Value.NGResetAcknowledge = new(ngapType.NGResetAcknowledge)
+
+	nGResetAcknowledgeIEs := &successfulOutcome.Value.NGResetAcknowledge.ProtocolIEs
+
+	// UE-associated Logical NG-connection List, only for the reset of part of the NG interface
+	if partOfNgInterface != nil && len(partOfNgInterface.List) > 0 {
+		ie := ngapType.NGResetAcknowledgeIEs{}
+		ie.Id.Value = ngapType.ProtocolIEIDUEAssociatedLogicalNGConnectionList
+		ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+		ie.Value.Present = ngapType.NGResetAcknowledgeIEsPresentUEAssociatedLogicalNGConnectionList
+		ie.Value.UEAssociatedLogicalNGConnectionList = partOfNgInterface
+
+		nGResetAcknowledgeIEs.List = append(nGResetAcknowledgeIEs.List, ie)
+	}
+
+	return pdu
+}
+
+func getNgResetAcknowledge(partOfNgInterface *ngapType.UEAssociatedLogicalNGConnectionList) ([]byte, error) {
+	ngResetAcknowledge := buildNgResetAcknowledge(partOfNgInterface)
+	return ngap.Encoder(ngResetAcknowledge)
+}
+
+func buildAmfConfigurationUpdateAcknowledge() ngapType.NGAPPDU {
+	pdu := ngapType.NGAPPDU{}
+
+	pdu.Present = ngapType.NGAPPDUPresentSuccessfulOutcome
+	pdu.SuccessfulOutcome = new(ngapType.SuccessfulOutcome)
+
+	successfulOutcome := pdu.SuccessfulOutcome
+	successfulOutcome.ProcedureCode.Value = ngapType.ProcedureCodeAMFConfigurationUpdate
+	successfulOutcome.Criticality.Value = ngapType.CriticalityPresentReject
+
+	successfulOutcome.Value.Present = ngapType.SuccessfulOutcomePresentAMFConfigurationUpdateAcknowledge
+	successfulOutcome.Value.AMFConfigurationUpdateAcknowledge = new(ngapType.AMFConfigurationUpdateAcknowledge)
+
+	return pdu
+}
+
+func getAmfConfigurationUpdateAcknowledge() ([]byte, error) {
+	amfConfigurationUpdateAcknowledge := buildAmfConfigurationUpdateAcknowledge()
+	return ngap.Encoder(amfConfigurationUpdateAcknowledge)
+}
+
+func buildErrorIndication(cause ngapType.Cause) ngapType.NGAPPDU {
+	pdu := ngapType.NGAPPDU{}
+
+	pdu.Present = ngapType.NGAPPDUPresentInitiatingMessage
+	pdu.InitiatingMessage = new(ngapType.InitiatingMessage)
+
+	initiatingMessage := pdu.InitiatingMessage
+	initiatingMessage.ProcedureCode.Value = ngapType.ProcedureCodeErrorIndication
+	initiatingMessage.Criticality.Value = ngapType.CriticalityPresentIgnore
+
+	initiatingMessage.Value.Present = ngapType.InitiatingMessagePresentErrorIndication
+	initiatingMessage.Value.ErrorIndication = new(ngapType.ErrorIndication)
+
+	errorIndicationIEs := &initiatingMessage.Value.ErrorIndication.ProtocolIEs
+
+	// Cause
+	ie := ngapType.ErrorIndicationIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDCause
+	ie.Criticality.Value = ngapType.CriticalityPresentIgnore
+	ie.Value.Present = ngapType.ErrorIndicationIEsPresentCause
+	ie.Value.Cause = &cause
+
+	errorIndicationIEs.List = append(errorIndicationIEs.List, ie)
+
+	return pdu
+}
+
+func getErrorIndication(cause ngapType.Cause) ([]byte, error) {
+	errorIndication := buildErrorIndication(cause)
+	return ngap.Encoder(errorIndication)
+}
@@ -15,8 +15,7 @@ var testBuildNgapSetupRequestCases = []struct {
 	gnbId   []byte
 	gnbName string
 	plmnId  ngapType.PLMNIdentity
-	tai     ngapType.TAI
-	snssai  ngapType.SNSSAI
+	cells   []*cell
 }{
 	{
 		name:    "testBuildNgapSetupRequest",
@@ -25,21 +24,24 @@ var testBuildNgapSetupRequestCases = []struct {
 		plmnId: ngapType.PLMNIdentity{
 			Value: aper.OctetString("\x02\xF8\x39"),
 		},
-		tai: ngapType.TAI{
-			TAC: ngapType.TAC{
-				Value: aper.OctetString("\x00\x00\x01"),
-			},
-			PLMNIdentity: ngapType.PLMNIdentity{
-				Value: aper.OctetString("\x02\xF8\x39"),
-			},
-		},
-		snssai: ngapType.SNSSAI{
-			SST: ngapType.SST{
-				Value: aper.OctetString("\x01"),
-			},
-			SD: &ngapType.SD{
-				Value: aper.OctetString("\x01\x02\x03"),
-			},
+		cells: []*cell{
+			newCell(0x000000001, 1, ngapType.PLMNIdentity{Value: aper.OctetString("\x02\xF8\x39")}, ngapType.TAI{
+				TAC: ngapType.TAC{
+					Value: aper.OctetString("\x00\x00\x01"),
+				},
+				PLMNIdentity: ngapType.PLMNIdentity{
+					Value: aper.OctetString("\x02\xF8\x39"),
+				},
+			}, []ngapType.SNSSAI{
+				{
+					SST: ngapType.SST{
+						Value: aper.OctetString("\x01"),
+					},
+					SD: &ngapType.SD{
+						Value: aper.OctetString("\x01\x02\x03"),
+					},
+				},
+			}),
 		},
 	},
 }
@@ -47,7 +49,7 @@ var testBuildNgapSetupRequestCases = []struct {
 func TestBuildNgapSetupRequest(t *testing.T) {
 	for _, testCase := range testBuildNgapSetupRequestCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			pdu := buildNgapSetupRequest(testCase.gnbId, testCase.gnbName, testCase.plmnId, testCase.tai, testCase.snssai)
+			pdu := buildNgapSetupRequest(testCase.gnbId, testCase.gnbName, testCase.plmnId, getSupportedTaList(testCase.cells))
 			encodeData, err := ngap.Encoder(pdu)
 			if err != nil {
 				t.Fatalf("Failed to encode NGAP setup request: %v", err)
@@ -67,15 +69,65 @@ var testBuildIntialUeMessageCases = []struct {
 	name                  string
 	ranUeNgapId           int64
 	ueRegistrationRequest []byte
-	plmnId                ngapType.PLMNIdentity
+	fiveGSTmsi            *ngapType.FiveGSTMSI
+	nrCgi                 ngapType.NRCGI
 	tai                   ngapType.TAI
 }{
 	{
 		name:                  "testBuildIntialUeMessage",
 		ranUeNgapId:           1,
 		ueRegistrationRequest: []byte("\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
-		plmnId: ngapType.PLMNIdentity{
-			Value: aper.OctetString("\x02\xF8\x39"),
+		nrCgi: ngapType.NRCGI{
+			PLMNIdentity: ngapType.PLMNIdentity{
+				Value: aper.OctetString("\x02\xF8\x39"),
+			},
+			NRCellIdentity: ngapType.NRCellIdentity{
+				Value: aper.BitString{
+					Bytes:     []byte{0x00, 0x00, 0x00, 0x00, 0x10},
+					BitLength: 36,
+				},
+			},
+		},
+		tai: ngapType.TAI{
+			TAC: ngapType.TAC{
+				Value: aper.OctetString("\x00\x00\x01"),
+			},
+			PLMNIdentity: ngapType.PLMNIdentity{
+				Value: aper.OctetString("\x02\xF8\x39"),
+			},
+		},
+	},
+	{
+		name:                  "testBuildIntialUeMessageWith5gSTmsi",
+		ranUeNgapId:           1,
+		ueRegistrationRequest: []byte("\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
+		fiveGSTmsi: &ngapType.FiveGSTMSI{
+			AMFSetID: ngapType.AMFSetID{
+				Value: aper.BitString{
+					Bytes:     []byte{0x00, 0x40},
+					BitLength: 10,
+				},
+			},
+			AMFPointer: ngapType.AMFPointer{
+				Value: aper.BitString{
+					Bytes:     []byte{0x04},
+					BitLength: 6,
+				},
+			},
+			FiveGTMSI: ngapType.FiveGTMSI{
+				Value: aper.OctetString("\x00\x00\x00\x01"),
+			},
+		},
+		nrCgi: ngapType.NRCGI{
+			PLMNIdentity: ngapType.PLMNIdentity{
+				Value: aper.OctetString("\x02\xF8\x39"),
+			},
+			NRCellIdentity: ngapType.NRCellIdentity{
+				Value: aper.BitString{
+					Bytes:     []byte{0x00, 0x00, 0x00, 0x00, 0x10},
+					BitLength: 36,
+				},
+			},
 		},
 		tai: ngapType.TAI{
 			TAC: ngapType.TAC{
@@ -91,7 +143,7 @@ var testBuildIntialUeMessageCases = []struct {
 func TestBuildIntialUeMessage(t *testing.T) {
 	for _, testCase := range testBuildIntialUeMessageCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			pdu := buildInitialUeMessage(testCase.ranUeNgapId, testCase.ueRegistrationRequest, testCase.plmnId, testCase.tai)
+			pdu := buildInitialUeMessage(testCase.ranUeNgapId, testCase.ueRegistrationRequest, testCase.fiveGSTmsi, testCase.nrCgi, testCase.tai)
 			encodeData, err := ngap.Encoder(pdu)
 			if err != nil {
 				t.Fatalf("Failed to encode NGAP initial ue message: %v", err)
@@ -111,7 +163,7 @@ var testBuildUplinkNasTransportCases = []struct {
 	name        string
 	amfUeNgapId int64
 	ranUeNgapId int64
-	plmnId      ngapType.PLMNIdentity
+	nrCgi       ngapType.NRCGI
 	tai         ngapType.TAI
 	nasPdu      []byte
 }{
@@ -119,8 +171,16 @@ var testBuildUplinkNasTransportCases = []struct {
 		name:        "testBuildUplinkNasTransport",
 		amfUeNgapId: 1,
 		ranUeNgapId: 1,
-		plmnId: ngapType.PLMNIdentity{
-			Value: aper.OctetString("\x02\xF8\x39"),
+		nrCgi: ngapType.NRCGI{
+			PLMNIdentity: ngapType.PLMNIdentity{
+				Value: aper.OctetString("\x02\xF8\x39"),
+			},
+			NRCellIdentity: ngapType.NRCellIdentity{
+				Value: aper.BitString{
+					Bytes:     []byte{0x00, 0x00, 0x00, 0x00, 0x10},
+					BitLength: 36,
+				},
+			},
 		},
 		tai: ngapType.TAI{
 			TAC: ngapType.TAC{
@@ -137,7 +197,7 @@ var testBuildUplinkNasTransportCases = []struct {
 func TestBuildUplinkNasTransport(t *testing.T) {
 	for _, testCase := range testBuildUplinkNasTransportCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			pdu := buildUplinkNasTransport(testCase.amfUeNgapId, testCase.ranUeNgapId, testCase.plmnId, testCase.tai, testCase.nasPdu)
+			pdu := buildUplinkNasTransport(testCase.amfUeNgapId, testCase.ranUeNgapId, testCase.nrCgi, testCase.tai, testCase.nasPdu)
 			encodeData, err := ngap.Encoder(pdu)
 			if err != nil {
 				t.Fatalf("Failed to encode NGAP uplink nas transport: %v", err)
@@ -154,21 +214,35 @@ func TestBuildUplinkNasTransport(t *testing.T) {
 }
 
 var testBuildNgapInitialContextSetupResponseCases = []struct {
-	name        string
-	amfUeNgapId int64
-	ranUeNgapId int64
+	name                         string
+	amfUeNgapId                  int64
+	ranUeNgapId                  int64
+	pduSessionResourceSetupItems []ngapType.PDUSessionResourceSetupItemCxtRes
 }{
 	{
 		name:        "testBuildNgapInitialContextSetupResponse",
 		amfUeNgapId: 1,
 		ranUeNgapId: 1,
 	},
+	{
+		name:        "testBuildNgapInitialContextSetupResponseWithPduSessions",
+		amfUeNgapId: 1,
+		ranUeNgapId: 1,
+		pduSessionResourceSetupItems: []ngapType.PDUSessionResourceSetupItemCxtRes{
+			{
+				PDUSessionID: ngapType.PDUSessionID{
+					Value: 1,
+				},
+				PDUSessionResourceSetupResponseTransfer: aper.OctetString("\x00\x00\x00\x01"),
+			},
+		},
+	},
 }
 
 func TestBuildNgapInitialContextSetupResponse(t *testing.T) {
 	for _, testCase := range testBuildNgapInitialContextSetupResponseCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			pdu := buildNgapInitialContextSetupResponse(testCase.amfUeNgapId, testCase.ranUeNgapId)
+			pdu := buildNgapInitialContextSetupResponse(testCase.amfUeNgapId, testCase.ranUeNgapId, testCase.pduSessionResourceSetupItems)
 			encodeData, err := ngap.Encoder(pdu)
 			if err != nil {
 				t.Fatalf("Failed to encode NGAP initial context setup response: %v", err)
@@ -313,7 +387,7 @@ var testBuildNgapUeContextReleaseCompleteMessageCases = []struct {
 	amfUeNgapId      int64
 	ranUeNgapId      int64
 	pduSessionIdList []int64
-	plmnId           ngapType.PLMNIdentity
+	nrCgi            ngapType.NRCGI
 	tai              ngapType.TAI
 }{
 	{
@@ -321,8 +395,16 @@ var testBuildNgapUeContextReleaseCompleteMessageCases = []struct {
 		amfUeNgapId:      1,
 		ranUeNgapId:      1,
 		pduSessionIdList: []int64{1},
-		plmnId: ngapType.PLMNIdentity{
-			Value: aper.OctetString("\x02\xF8\x39"),
+		nrCgi: ngapType.NRCGI{
+			PLMNIdentity: ngapType.PLMNIdentity{
+				Value: aper.OctetString("\x02\xF8\x39"),
+			},
+			NRCellIdentity: ngapType.NRCellIdentity{
+				Value: aper.BitString{
+					Bytes:     []byte{0x00, 0x00, 0x00, 0x00, 0x10},
+					BitLength: 36,
+				},
+			},
 		},
 		tai: ngapType.TAI{
 			TAC: ngapType.TAC{
@@ -338,7 +420,7 @@ var testBuildNgapUeContextReleaseCompleteMessageCases = []struct {
 func TestBuildNgapUeContextReleaseCompleteMessage(t *testing.T) {
 	for _, testCase := range testBuildNgapUeContextReleaseCompleteMessageCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			pdu := buildNgapUeContextReleaseCompleteMessage(testCase.amfUeNgapId, testCase.ranUeNgapId, testCase.pduSessionIdList, testCase.plmnId, testCase.tai)
+			pdu := buildNgapUeContextReleaseCompleteMessage(testCase.amfUeNgapId, testCase.ranUeNgapId, testCase.pduSessionIdList, testCase.nrCgi, testCase.tai)
 			encodeData, err := ngap.Encoder(pdu)
 			if err != nil {
 				t.Fatalf("Failed to encode NGAP ue context release command: %v", err)
@@ -354,6 +436,47 @@ func TestBuildNgapUeContextReleaseCompleteMessage(t *testing.T) {
 	}
 }
 
+var testBuildNgapUeContextReleaseRequestCases = []struct {
+	name              string
+	amfUeNgapId       int64
+	ranUeNgapId       int64
+	pduSessionIdList  []int64
+	causeRadioNetwork aper.Enumerated
+}{
+	{
+		name:              "testBuildNgapUeContextReleaseRequest",
+		amfUeNgapId:       1,
+		ranUeNgapId:       1,
+		pduSessionIdList:  []int64{1, 2},
+		causeRadioNetwork: ngapType.CauseRadioNetworkPresentUserInactivity,
+	},
+	{
+		name:              "testBuildNgapUeContextReleaseRequestWithoutPduSession",
+		amfUeNgapId:       1,
+		ranUeNgapId:       1,
+		causeRadioNetwork: ngapType.CauseRadioNetworkPresentUserInactivity,
+	},
+}
+
+func TestBuildNgapUeContextReleaseRequest(t *testing.T) {
+	for _, testCase := range testBuildNgapUeContextReleaseRequestCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			pdu := buildNgapUeContextReleaseRequest(testCase.amfUeNgapId, testCase.ranUeNgapId, testCase.pduSessionIdList, testCase.causeRadioNetwork)
+			encodeData, err := ngap.Encoder(pdu)
+			if err != nil {
+				t.Fatalf("Failed to encode NGAP ue context release request: %v", err)
+			} else {
+				decodeData, err := ngap.Decoder(encodeData)
+				if err != nil {
+					t.Fatalf("Failed to decode NGAP ue context release request: %v", err)
+				} else if !reflect.DeepEqual(pdu, *decodeData) {
+					t.Fatalf("NGAP ue context release request mismatch")
+				}
+			}
+		})
+	}
+}
+
 var testBuildPDUSessionResourceModifyIndicationTransferCases = []struct {
 	name    string
 	dlTeid  []byte
@@ -386,3 +509,77 @@ func TestBuildPDUSessionResourceModifyIndicationTransfer(t *testing.T) {
 		})
 	}
 }
+
+var testBuildPduSessionResourceReleaseResponseCases = []struct {
+	name             string
+	amfUeNgapId      int64
+	ranUeNgapId      int64
+	pduSessionIdList []int64
+}{
+	{
+		name:             "testBuildPduSessionResourceReleaseResponse",
+		amfUeNgapId:      1,
+		ranUeNgapId:      1,
+		pduSessionIdList: []int64{1, 2},
+	},
+}
+
+func TestBuildPduSessionResourceReleaseResponse(t *testing.T) {
+	for _, testCase := range testBuildPduSessionResourceReleaseResponseCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			transferMessage, err := getPduSessionResourceReleaseResponseTransfer()
+			if err != nil {
+				t.Fatalf("Failed to get pdu session resource release response transfer: %v", err)
+			}
+			pdu := buildPduSessionResourceReleaseResponse(testCase.amfUeNgapId, testCase.ranUeNgapId, testCase.pduSessionIdList, transferMessage)
+			encodeData, err := ngap.Encoder(pdu)
+			if err != nil {
+				t.Fatalf("Failed to encode NGAP pdu session resource release response: %v", err)
+			} else {
+				decodeData, err := ngap.Decoder(encodeData)
+				if err != nil {
+					t.Fatalf("Failed to decode NGAP pdu session resource release response: %v", err)
+				} else if !reflect.DeepEqual(pdu, *decodeData) {
+					t.Fatalf("NGAP pdu session resource release response mismatch")
+				}
+			}
+		})
+	}
+}
+
+var testBuildPduSessionResourceModifyResponseCases = []struct {
+	name             string
+	amfUeNgapId      int64
+	ranUeNgapId      int64
+	pduSessionIdList []int64
+}{
+	{
+		name:             "testBuildPduSessionResourceModifyResponse",
+		amfUeNgapId:      1,
+		ranUeNgapId:      1,
+		pduSessionIdList: []int64{1},
+	},
+}
+
+func TestBuildPduSessionResourceModifyResponse(t *testing.T) {
+	for _, testCase := range testBuildPduSessionResourceModifyResponseCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			transferMessage, err := getPduSessionResourceModifyResponseTransfer()
+			if err != nil {
+				t.Fatalf("Failed to get pdu session resource modify response transfer: %v", err)
+			}
+			pdu := buildPduSessionResourceModifyResponse(testCase.amfUeNgapId, testCase.ranUeNgapId, testCase.pduSessionIdList, transferMessage)
+			encodeData, err := ngap.Encoder(pdu)
+			if err != nil {
+				t.Fatalf("Failed to encode NGAP pdu session resource modify response: %v", err)
+			} else {
+				decodeData, err := ngap.Decoder(encodeData)
+				if err != nil {
+					t.Fatalf("Failed to decode NGAP pdu session resource modify response: %v", err)
+				} else if !reflect.DeepEqual(pdu, *decodeData) {
+					t.Fatalf("NGAP pdu session resource modify response mismatch")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package gnb
+
+import (
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/free5gc/aper"
+)
+
+// pduSession is the N3 tunnel of a PDU session at the RAN, the DL TEID is allocated by the RAN
+// and the UL TEID and UPF come from the PDU session resource setup request transfer
+type pduSession struct {
+	pduSessionId uint8
+
+	ulTeid aper.OctetString
+	dlTeid aper.OctetString
+
+	upfN3Addr *net.UDPAddr
+}
+
+func newPduSession(pduSessionId uint8, dlTeid aper.OctetString) *pduSession {
+	return &pduSession{
+		pduSessionId: pduSessionId,
+
+		ulTeid: aper.OctetString{},
+		dlTeid: dlTeid,
+	}
+}
+
+func (p *pduSession) GetPduSessionId() uint8 {
+	return p.pduSessionId
+}
+
+func (p *pduSession) GetUlTeid() aper.OctetString {
+	return p.ulTeid
+}
+
+func (p *pduSession) GetDlTeid() aper.OctetString {
+	return p.dlTeid
+}
+
+func (p *pduSession) GetUpfN3Addr() *net.UDPAddr {
+	return p.upfN3Addr
+}
+
+func (p *pduSession) SetUlTeid(ulTeid aper.OctetString) {
+	p.ulTeid = ulTeid
+}
+
+func (p *pduSession) SetUpfN3Addr(upfN3Addr *net.UDPAddr) {
+	p.upfN3Addr = upfN3Addr
+}
+
+// dlTunnel is the value of dlTeidToUe, the UE and the PDU session which a DL TEID is allocated for
+type dlTunnel struct {
+	ue           any
+	pduSessionId uint8
+}
+
+// pduSessions are the PDU sessions of a UE by PDU session ID, the primary session is the first one established,
+// which is the session split by NR-DC, once it is released the next established session becomes the primary one
+type pduSessions struct {
+	sessions            map[uint8]*pduSession
+	primaryPduSessionId uint8
+
+	pduSessionMtx sync.Mutex
+}
+
+func newPduSessions() pduSessions {
+	return pduSessions{
+		sessions: make(map[uint8]*pduSession),
+
+		pduSessionMtx: sync.Mutex{},
+	}
+}
+
+func (p *pduSessions) AddPduSession(session *pduSession) {
+	p.pduSessionMtx.Lock()
+	defer p.pduSessionMtx.Unlock()
+
+	if p.primaryPduSessionId == 0 {
+		p.primaryPduSessionId = session.pduSessionId
+	}
+	p.sessions[session.pduSessionId] = session
+}
+
+func (p *pduSessions) GetPduSession(pduSessionId uint8) (*pduSession, bool) {
+	p.pduSessionMtx.Lock()
+	defer p.pduSessionMtx.Unlock()
+
+	session, exists := p.sessions[pduSessionId]
+	return session, exists
+}
+
+// GetPduSessionList returns the PDU sessions in the order of PDU session ID
+func (p *pduSessions) GetPduSessionList() []*pduSession {
+	p.pduSessionMtx.Lock()
+	defer p.pduSessionMtx.Unlock()
+
+	sessions := make([]*pduSession, 0, len(p.sessions))
+	for _, session := range p.sessions {
+		sessions = append(sessions, session)
+	}
+	slices.SortFunc(sessions, func(a, b *pduSession) int {
+		return int(a.pduSessionId) - int(b.pduSessionId)
+	})
+	return sessions
+}
+
+func (p *pduSessions) GetPrimaryPduSession() (*pduSession, bool) {
+	p.pduSessionMtx.Lock()
+	defer p.pduSessionMtx.Unlock()
+
+	session, exists := p.sessions[p.primaryPduSessionId]
+	return session, exists
+}
+
+func (p *pduSessions) RemovePduSession(pduSessionId uint8) (*pduSession, bool) {
+	p.pduSessionMtx.Lock()
+	defer p.pduSessionMtx.Unlock()
+
+	session, exists := p.sessions[pduSessionId]
+	delete(p.sessions, pduSessionId)
+	if pduSessionId == p.primaryPduSessionId {
+		p.primaryPduSessionId = 0
+	}
+	return session, exists
+}
+
+// releasePduSessions removes every PDU session and releases their DL TEIDs, returns the released sessions
+func (p *pduSessions) releasePduSessions(teidGenerator *TeidGenerator) []*pduSession {
+	sessions := p.GetPduSessionList()
+
+	p.pduSessionMtx.Lock()
+	defer p.pduSessionMtx.Unlock()
+
+	for _, session := range sessions {
+		teidGenerator.ReleaseTeid(session.dlTeid)
+		delete(p.sessions, session.pduSessionId)
+	}
+	p.primaryPduSessionId = 0
+	return sessions
+}
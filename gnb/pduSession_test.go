@@ -0,0 +1,67 @@
+package gnb
+
+import (
+	"testing"
+)
+
+var testPduSessionsPrimaryCases = []struct {
+	name                    string
+	added                   []uint8
+	removed                 []uint8
+	expectedPrimary         uint8
+	expectedPrimaryAfterAdd uint8
+}{
+	{
+		name:                    "testFirstAddedIsPrimary",
+		added:                   []uint8{1, 2},
+		removed:                 nil,
+		expectedPrimary:         1,
+		expectedPrimaryAfterAdd: 1,
+	},
+	{
+		name:                    "testPrimaryKeptOnSecondaryRelease",
+		added:                   []uint8{1, 2},
+		removed:                 []uint8{2},
+		expectedPrimary:         1,
+		expectedPrimaryAfterAdd: 1,
+	},
+	{
+		name:                    "testNextAddedIsPrimaryAfterPrimaryRelease",
+		added:                   []uint8{1, 2},
+		removed:                 []uint8{1},
+		expectedPrimary:         0,
+		expectedPrimaryAfterAdd: 9,
+	},
+}
+
+func TestPduSessionsPrimary(t *testing.T) {
+	for _, testCase := range testPduSessionsPrimaryCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			sessions := newPduSessions()
+			for _, pduSessionId := range testCase.added {
+				sessions.AddPduSession(newPduSession(pduSessionId, nil))
+			}
+			for _, pduSessionId := range testCase.removed {
+				if _, exists := sessions.RemovePduSession(pduSessionId); !exists {
+					t.Fatalf("pdu session %d does not exist", pduSessionId)
+				}
+			}
+			if primaryPduSessionId := getPrimaryPduSessionId(&sessions); primaryPduSessionId != testCase.expectedPrimary {
+				t.Errorf("expected primary pdu session %d, got %d", testCase.expectedPrimary, primaryPduSessionId)
+			}
+
+			sessions.AddPduSession(newPduSession(9, nil))
+			if primaryPduSessionId := getPrimaryPduSessionId(&sessions); primaryPduSessionId != testCase.expectedPrimaryAfterAdd {
+				t.Errorf("expected primary pdu session %d after add, got %d", testCase.expectedPrimaryAfterAdd, primaryPduSessionId)
+			}
+		})
+	}
+}
+
+func getPrimaryPduSessionId(sessions *pduSessions) uint8 {
+	primary, exists := sessions.GetPrimaryPduSession()
+	if !exists {
+		return 0
+	}
+	return primary.GetPduSessionId()
+}
@@ -0,0 +1,168 @@
+package gnb
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/channel"
+	consoleModel "github.com/Alonza0314/free-ran-ue/console/model"
+	"github.com/Alonza0314/free-ran-ue/model"
+)
+
+var errRadioChannelLoss = errors.New("lost on radio channel")
+
+// ueRadioChannel is the emulated radio channel between the UE and the cell,
+// the control and data paths of the UE share the links of the same direction
+type ueRadioChannel struct {
+	ulRadioLink *channel.Link
+	dlRadioLink *channel.Link
+}
+
+func newUeRadioChannel(profile channel.Profile) ueRadioChannel {
+	return ueRadioChannel{
+		ulRadioLink: channel.NewLink(profile),
+		dlRadioLink: channel.NewLink(profile),
+	}
+}
+
+func (r *ueRadioChannel) GetRadioChannelProfile() channel.Profile {
+	return r.dlRadioLink.GetProfile()
+}
+
+func (r *ueRadioChannel) SetRadioChannelProfile(profile channel.Profile) {
+	r.ulRadioLink.SetProfile(profile)
+	r.dlRadioLink.SetProfile(profile)
+}
+
+// radioChannel keeps the radio channel profile of the cell and the per UE overrides by IMSI
+type radioChannel struct {
+	cellProfile channel.Profile
+	ueProfiles  map[string]channel.Profile
+
+	mtx sync.RWMutex
+}
+
+func newRadioChannel(radioChannelIE model.RadioChannelIE) *radioChannel {
+	ueProfiles := make(map[string]channel.Profile, len(radioChannelIE.Ue))
+	for _, ueRadioChannelIE := range radioChannelIE.Ue {
+		ueProfiles[ueRadioChannelIE.Imsi] = radioChannelProfileFromIE(ueRadioChannelIE.Profile)
+	}
+
+	return &radioChannel{
+		cellProfile: radioChannelProfileFromIE(radioChannelIE.Cell),
+		ueProfiles:  ueProfiles,
+
+		mtx: sync.RWMutex{},
+	}
+}
+
+func (r *radioChannel) getCellProfile() channel.Profile {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	return r.cellProfile
+}
+
+func (r *radioChannel) setCellProfile(profile channel.Profile) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.cellProfile = profile
+}
+
+// getUeProfile returns the profile of the UE, which is the cell profile if the UE has no override
+func (r *radioChannel) getUeProfile(imsi string) (channel.Profile, bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	if profile, exists := r.ueProfiles[imsi]; exists {
+		return profile, true
+	}
+	return r.cellProfile, false
+}
+
+func (r *radioChannel) setUeProfile(imsi string, profile channel.Profile) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.ueProfiles[imsi] = profile
+}
+
+func (r *radioChannel) deleteUeProfile(imsi string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.ueProfiles, imsi)
+}
+
+func (r *radioChannel) getUeProfiles() map[string]channel.Profile {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	ueProfiles := make(map[string]channel.Profile, len(r.ueProfiles))
+	for imsi, profile := range r.ueProfiles {
+		ueProfiles[imsi] = profile
+	}
+	return ueProfiles
+}
+
+func radioChannelProfileFromIE(profileIE model.RadioChannelProfileIE) channel.Profile {
+	outages := make([]channel.Outage, 0, len(profileIE.Outages))
+	for _, outageIE := range profileIE.Outages {
+		outages = append(outages, channel.Outage{
+			Start:    outageIE.Start,
+			Duration: outageIE.Duration,
+			Period:   outageIE.Period,
+		})
+	}
+
+	return channel.Profile{
+		Latency:   profileIE.Latency,
+		Jitter:    profileIE.Jitter,
+		Loss:      profileIE.Loss,
+		Reorder:   profileIE.Reorder,
+		Bandwidth: profileIE.Bandwidth,
+		Outages:   outages,
+	}
+}
+
+func radioChannelProfileFromApi(profile consoleModel.GnbRadioChannelProfile) channel.Profile {
+	outages := make([]channel.Outage, 0, len(profile.Outages))
+	for _, outage := range profile.Outages {
+		outages = append(outages, channel.Outage{
+			Start:    time.Duration(outage.StartMs) * time.Millisecond,
+			Duration: time.Duration(outage.DurationMs) * time.Millisecond,
+			Period:   time.Duration(outage.PeriodMs) * time.Millisecond,
+		})
+	}
+
+	return channel.Profile{
+		Latency:   time.Duration(profile.LatencyMs) * time.Millisecond,
+		Jitter:    time.Duration(profile.JitterMs) * time.Millisecond,
+		Loss:      profile.Loss,
+		Reorder:   profile.Reorder,
+		Bandwidth: profile.BandwidthKbps,
+		Outages:   outages,
+	}
+}
+
+func radioChannelProfileToApi(profile channel.Profile) consoleModel.GnbRadioChannelProfile {
+	outages := make([]consoleModel.GnbRadioChannelOutage, 0, len(profile.Outages))
+	for _, outage := range profile.Outages {
+		outages = append(outages, consoleModel.GnbRadioChannelOutage{
+			StartMs:    outage.Start.Milliseconds(),
+			DurationMs: outage.Duration.Milliseconds(),
+			PeriodMs:   outage.Period.Milliseconds(),
+		})
+	}
+
+	return consoleModel.GnbRadioChannelProfile{
+		LatencyMs:     profile.Latency.Milliseconds(),
+		JitterMs:      profile.Jitter.Milliseconds(),
+		Loss:          profile.Loss,
+		Reorder:       profile.Reorder,
+		BandwidthKbps: profile.Bandwidth,
+		Outages:       outages,
+	}
+}
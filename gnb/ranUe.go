@@ -1,45 +1,51 @@
 package gnb
 
 import (
+	"encoding/hex"
 	"fmt"
+	"math"
 	"net"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/free5gc/aper"
+	"github.com/Alonza0314/free-ran-ue/channel"
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/free5gc/nas/nasConvert"
+	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/nas/nasType"
 )
 
 type RanUeNgapIdGenerator struct {
-	usedRanUeIds sync.Map
-	mtx          sync.Mutex
+	allocator *idAllocator
 }
 
+// RAN UE NGAP ID is an INTEGER (0..2^32-1), 0 is kept unused
 func NewRanUeNgapIdGenerator() *RanUeNgapIdGenerator {
 	return &RanUeNgapIdGenerator{
-		usedRanUeIds: sync.Map{},
-		mtx:          sync.Mutex{},
+		allocator: newIdAllocator(1, math.MaxUint32, false),
 	}
 }
 
 func (g *RanUeNgapIdGenerator) AllocateRanUeId() int64 {
-	g.mtx.Lock()
-	defer g.mtx.Unlock()
-
-	for i := 1; i <= 65535; i++ {
-		if _, exists := g.usedRanUeIds.Load(int64(i)); !exists {
-			g.usedRanUeIds.Store(int64(i), true)
-			return int64(i)
-		}
+	ranUeId, err := g.allocator.allocate()
+	if err != nil {
+		return -1
 	}
 
-	return -1
+	return int64(ranUeId)
 }
 
-func (g *RanUeNgapIdGenerator) ReleaseRanUeId(ranUeId int64) {
-	g.mtx.Lock()
-	defer g.mtx.Unlock()
+func (g *RanUeNgapIdGenerator) ReleaseRanUeId(ranUeId int64) error {
+	if ranUeId < 0 || ranUeId > math.MaxUint32 {
+		return fmt.Errorf("RAN UE NGAP ID %d out of range", ranUeId)
+	}
 
-	g.usedRanUeIds.Delete(ranUeId)
+	if err := g.allocator.release(uint32(ranUeId)); err != nil {
+		return fmt.Errorf("error release RAN UE NGAP ID: %v", err)
+	}
+	return nil
 }
 
 type RanUe struct {
@@ -48,38 +54,89 @@ type RanUe struct {
 
 	mobileIdentity5GS nasType.MobileIdentity5GS
 
-	ulTeid aper.OctetString
-	dlTeid aper.OctetString
+	pduSessions
+
+	n1Conn     net.Conn
+	n1WriteMtx sync.Mutex
+
+	// the messages from UE read by the N1 receiver, n1Err is the error ending it, set before n1Messages is closed
+	n1Messages chan *protocol.Message
+	n1Err      error
+
+	// the NGAP PDUs from AMF dispatched by the N2 receiver, the pdu session resource modify confirm goes to the modify indication
+	// of the API instead, and released is closed with the release of the UE
+	n2Messages              chan *n2Message
+	pduSessionModifyConfirm chan *n2Message
+	released                chan struct{}
+
+	ueDataPlane
 
-	n1Conn           net.Conn
-	dataPlaneAddress *net.UDPAddr
+	rrcState                   protocol.RrcState
+	rrcUeIdentity              uint64
+	servingCell                *cell
+	rrcTransactionId           uint8
+	rrcMeasurements            []protocol.RrcMeasurement
+	rrcReconfigurationComplete chan uint8
+	rrcMtx                     sync.Mutex
+
+	// a UE suspended to RRC_INACTIVE is paged once on downlink data and resumes with the transaction of RRCResume
+	rrcPaged               bool
+	rrcResumePending       bool
+	rrcResumeTransactionId uint8
 
 	nrdcIndicator    bool
 	nrdcIndicatorMtx sync.Mutex
+
+	// kGnb derives K_SN of the secondary node with a fresh sk counter for each secondary cell group addition
+	kGnb                 []byte
+	skCounter            uint16
+	asSecurityContext    *protocol.AsSecurityContext
+	asSecurityContextMtx sync.Mutex
 }
 
-func NewRanUe(n1Conn net.Conn, ranUeNgapIdGenerator *RanUeNgapIdGenerator) *RanUe {
+func NewRanUe(n1Conn net.Conn, servingCell *cell, ranUeNgapIdGenerator *RanUeNgapIdGenerator, dlBufferSize int, dlBufferMaxAge time.Duration, radioChannelProfile channel.Profile) *RanUe {
 	ranUeId := ranUeNgapIdGenerator.AllocateRanUeId()
 	if ranUeId == -1 {
 		panic("Failed to allocate ranUeId")
 	}
 
-	return &RanUe{
+	ranUe := &RanUe{
 		amfUeNgapId: 1,
 		ranUeNgapId: ranUeId,
 
 		mobileIdentity5GS: nasType.MobileIdentity5GS{},
 
-		n1Conn: n1Conn,
+		pduSessions: newPduSessions(),
+
+		n1Conn:     n1Conn,
+		n1WriteMtx: sync.Mutex{},
+		n1Messages: make(chan *protocol.Message),
+
+		n2Messages:              make(chan *n2Message, constant.N2_MESSAGE_QUEUE_SIZE),
+		pduSessionModifyConfirm: make(chan *n2Message, 1),
+		released:                make(chan struct{}),
+
+		ueDataPlane: newUeDataPlane(dlBufferSize, dlBufferMaxAge, radioChannelProfile),
+
+		rrcState:                   protocol.RRC_STATE_IDLE,
+		servingCell:                servingCell,
+		rrcMeasurements:            make([]protocol.RrcMeasurement, 0),
+		rrcReconfigurationComplete: make(chan uint8, 1),
+		rrcMtx:                     sync.Mutex{},
 
 		nrdcIndicator:    false,
 		nrdcIndicatorMtx: sync.Mutex{},
+
+		asSecurityContextMtx: sync.Mutex{},
 	}
+	go ranUe.receiveN1Messages()
+	return ranUe
 }
 
-func (r *RanUe) Release(ranUeNgapIdGenerator *RanUeNgapIdGenerator, teidGenerator *TeidGenerator) {
-	ranUeNgapIdGenerator.ReleaseRanUeId(r.ranUeNgapId)
-	teidGenerator.ReleaseTeid(r.dlTeid)
+func (r *RanUe) Release(ranUeNgapIdGenerator *RanUeNgapIdGenerator, teidGenerator *TeidGenerator) error {
+	close(r.released)
+	r.releasePduSessions(teidGenerator)
+	return ranUeNgapIdGenerator.ReleaseRanUeId(r.ranUeNgapId)
 }
 
 func (r *RanUe) GetAmfUeId() int64 {
@@ -90,49 +147,154 @@ func (r *RanUe) GetRanUeId() int64 {
 	return r.ranUeNgapId
 }
 
+// GetMobileIdentityIMSI is the IMSI of the null scheme SUCI the UE registered with, a SUCI concealed by the UE is kept as it is,
+// a UE registering with its 5G-GUTI is named by the GUTI and a UE sending a service request by its 5G-S-TMSI
 func (r *RanUe) GetMobileIdentityIMSI() string {
+	if buffer := r.mobileIdentity5GS.Buffer; len(buffer) > 0 {
+		switch buffer[0] & 0x07 {
+		case nasMessage.MobileIdentity5GSType5gGuti:
+			_, guti := nasConvert.GutiToString(buffer)
+			return "5g-guti-" + guti
+		case nasMessage.MobileIdentity5GSType5gSTmsi:
+			return "5g-s-tmsi-" + hex.EncodeToString(buffer[1:])
+		}
+	}
+
 	suci := r.mobileIdentity5GS.GetSUCI()
-	return fmt.Sprintf("imsi-%s%s%s", suci[7:10], suci[11:13], suci[20:])
+
+	// suci-0-<mcc>-<mnc>-<routing indicator>-<protection scheme>-<home network public key id>-<scheme output>
+	fields := strings.Split(suci, "-")
+	if len(fields) != 8 || fields[5] != "0" {
+		return suci
+	}
+	return fmt.Sprintf("imsi-%s%s%s", fields[2], fields[3], fields[7])
 }
 
-func (r *RanUe) GetUlTeid() aper.OctetString {
-	return r.ulTeid
+func (r *RanUe) GetN1Conn() net.Conn {
+	return r.n1Conn
 }
 
-func (r *RanUe) GetDlTeid() aper.OctetString {
-	return r.dlTeid
+// SendToUe frames the payload as the given message type and writes it to the UE after the downlink radio channel delay,
+// messages are secured and queued on the downlink in order under n1WriteMtx since the API and the UE procedure may send at the same time,
+// so that the counts are written in order, and the delay is waited for outside of it
+func (r *RanUe) SendToUe(messageType protocol.MessageType, payload []byte) (int, error) {
+	r.n1WriteMtx.Lock()
+	message := protocol.NewMessage(messageType, payload)
+	if asSecurityContext := r.GetAsSecurityContext(); asSecurityContext != nil {
+		securedMessage, err := asSecurityContext.SecureMessage(message)
+		if err != nil {
+			r.n1WriteMtx.Unlock()
+			return 0, fmt.Errorf("error secure %s message: %v", messageType, err)
+		}
+		message = securedMessage
+	}
+	data, err := message.Marshal()
+	if err != nil {
+		r.n1WriteMtx.Unlock()
+		return 0, err
+	}
+	delivered := r.dlRadioLink.SendStream(data, func(data []byte) error {
+		_, err := r.n1Conn.Write(data)
+		return err
+	})
+	r.n1WriteMtx.Unlock()
+
+	if err := <-delivered; err != nil {
+		return 0, err
+	}
+	return len(data), nil
 }
 
-func (r *RanUe) GetN1Conn() net.Conn {
-	return r.n1Conn
+// receiveN1Messages is the only reader of the N1 connection, so that serving the UE waits for its messages
+// and the NGAP PDUs from AMF at the same time, it ends with the connection
+func (r *RanUe) receiveN1Messages() {
+	defer close(r.n1Messages)
+	for {
+		message, err := protocol.ReadMessage(r.n1Conn)
+		if err != nil {
+			r.n1Err = err
+			return
+		}
+
+		select {
+		case r.n1Messages <- message:
+		case <-r.released:
+			return
+		}
+	}
 }
 
-func (r *RanUe) GetDataPlaneAddress() *net.UDPAddr {
-	return r.dataPlaneAddress
+// ReceiveMessageFromUe reads the next message of any type from the UE, delayed by the uplink radio channel,
+// and verifies and deciphers it once AS security is activated
+func (r *RanUe) ReceiveMessageFromUe() (*protocol.Message, error) {
+	message, received := <-r.n1Messages
+	return r.openUeMessage(message, received)
 }
 
-func (r *RanUe) SetAmfUeId(amfUeId int64) {
-	r.amfUeNgapId = amfUeId
+// openUeMessage takes a message of the N1 receiver as ReceiveMessageFromUe does, received is false once the receiver has ended
+func (r *RanUe) openUeMessage(message *protocol.Message, received bool) (*protocol.Message, error) {
+	if !received {
+		return nil, r.n1Err
+	}
+
+	r.ulRadioLink.WaitStream(protocol.HEADER_LENGTH + len(message.Payload))
+	if asSecurityContext := r.GetAsSecurityContext(); asSecurityContext != nil {
+		return asSecurityContext.UnsecureMessage(message)
+	}
+	return message, nil
 }
 
-func (r *RanUe) SetRanUeId(ranUeId int64) {
-	r.ranUeNgapId = ranUeId
+// ReceiveFromUe reads the next message from the UE and checks that it is of the expected type
+func (r *RanUe) ReceiveFromUe(messageType protocol.MessageType) ([]byte, error) {
+	message, err := r.ReceiveMessageFromUe()
+	if err != nil {
+		return nil, err
+	}
+	if message.Type != messageType {
+		return nil, fmt.Errorf("unexpected message type %s, expected %s", message.Type, messageType)
+	}
+
+	return message.Payload, nil
 }
 
-func (r *RanUe) SetMobileIdentity5GS(mobileIdentity5GS nasType.MobileIdentity5GS) {
-	r.mobileIdentity5GS = mobileIdentity5GS
+// SendRrcToUe encodes the rrc message and sends it to the UE
+func (r *RanUe) SendRrcToUe(rrcMessage *protocol.RrcMessage) (int, error) {
+	payload, err := rrcMessage.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	return r.SendToUe(protocol.MESSAGE_TYPE_RRC, payload)
+}
+
+// ReceiveRrcFromUe reads the next message from the UE and checks that it is the expected rrc message
+func (r *RanUe) ReceiveRrcFromUe(rrcMessageType protocol.RrcMessageType) (*protocol.RrcMessage, error) {
+	payload, err := r.ReceiveFromUe(protocol.MESSAGE_TYPE_RRC)
+	if err != nil {
+		return nil, err
+	}
+
+	rrcMessage := &protocol.RrcMessage{}
+	if err := rrcMessage.Unmarshal(payload); err != nil {
+		return nil, err
+	}
+	if rrcMessage.Type != rrcMessageType {
+		return nil, fmt.Errorf("unexpected rrc message %s, expected %s", rrcMessage.Type, rrcMessageType)
+	}
+
+	return rrcMessage, nil
 }
 
-func (r *RanUe) SetUlTeid(ulTeid aper.OctetString) {
-	r.ulTeid = ulTeid
+func (r *RanUe) SetAmfUeId(amfUeId int64) {
+	r.amfUeNgapId = amfUeId
 }
 
-func (r *RanUe) SetDlTeid(dlTeid aper.OctetString) {
-	r.dlTeid = dlTeid
+func (r *RanUe) SetRanUeId(ranUeId int64) {
+	r.ranUeNgapId = ranUeId
 }
 
-func (r *RanUe) SetDataPlaneAddress(dataPlaneAddress *net.UDPAddr) {
-	r.dataPlaneAddress = dataPlaneAddress
+func (r *RanUe) SetMobileIdentity5GS(mobileIdentity5GS nasType.MobileIdentity5GS) {
+	r.mobileIdentity5GS = mobileIdentity5GS
 }
 
 func (r *RanUe) IsNrdcActivated() bool {
@@ -152,3 +314,164 @@ func (r *RanUe) DeactivateNrdc() {
 	defer r.nrdcIndicatorMtx.Unlock()
 	r.nrdcIndicator = false
 }
+
+func (r *RanUe) GetRrcState() protocol.RrcState {
+	r.rrcMtx.Lock()
+	defer r.rrcMtx.Unlock()
+
+	return r.rrcState
+}
+
+func (r *RanUe) SetRrcState(rrcState protocol.RrcState) {
+	r.rrcMtx.Lock()
+	defer r.rrcMtx.Unlock()
+
+	r.rrcState = rrcState
+}
+
+// SuspendRrc moves the UE to RRC_INACTIVE, its downlink packets are buffered until it resumes
+func (r *RanUe) SuspendRrc() {
+	r.rrcMtx.Lock()
+	r.rrcState = protocol.RRC_STATE_INACTIVE
+	r.rrcPaged = false
+	r.rrcResumePending = false
+	r.rrcMtx.Unlock()
+
+	r.SuspendDataPlane()
+}
+
+// PageRrc returns true if the UE is in RRC_INACTIVE and not paged yet since it was suspended
+func (r *RanUe) PageRrc() bool {
+	r.rrcMtx.Lock()
+	defer r.rrcMtx.Unlock()
+
+	if r.rrcState != protocol.RRC_STATE_INACTIVE || r.rrcPaged {
+		return false
+	}
+	r.rrcPaged = true
+	return true
+}
+
+// StartRrcResume checks the resume identity of RRCResumeRequest and returns the transaction id of RRCResume
+func (r *RanUe) StartRrcResume(resumeIdentity uint64) (uint8, error) {
+	r.rrcMtx.Lock()
+	defer r.rrcMtx.Unlock()
+
+	if r.rrcState != protocol.RRC_STATE_INACTIVE {
+		return 0, fmt.Errorf("UE is in %s", r.rrcState)
+	}
+	if resumeIdentity != uint64(r.ranUeNgapId) {
+		return 0, fmt.Errorf("resume identity %x, expected %x", resumeIdentity, r.ranUeNgapId)
+	}
+
+	r.rrcTransactionId = (r.rrcTransactionId + 1) % 4
+	r.rrcResumePending = true
+	r.rrcResumeTransactionId = r.rrcTransactionId
+	return r.rrcResumeTransactionId, nil
+}
+
+// CompleteRrcResume moves the UE back to RRC_CONNECTED on RRCResumeComplete of the transaction of RRCResume
+func (r *RanUe) CompleteRrcResume(transactionId uint8) error {
+	r.rrcMtx.Lock()
+	defer r.rrcMtx.Unlock()
+
+	if !r.rrcResumePending || transactionId != r.rrcResumeTransactionId {
+		return fmt.Errorf("no rrc resume of transaction %d", transactionId)
+	}
+	r.rrcState = protocol.RRC_STATE_CONNECTED
+	r.rrcResumePending = false
+	return nil
+}
+
+func (r *RanUe) GetRrcUeIdentity() uint64 {
+	r.rrcMtx.Lock()
+	defer r.rrcMtx.Unlock()
+
+	return r.rrcUeIdentity
+}
+
+func (r *RanUe) SetRrcUeIdentity(rrcUeIdentity uint64) {
+	r.rrcMtx.Lock()
+	defer r.rrcMtx.Unlock()
+
+	r.rrcUeIdentity = rrcUeIdentity
+}
+
+func (r *RanUe) GetServingCell() *cell {
+	r.rrcMtx.Lock()
+	defer r.rrcMtx.Unlock()
+
+	return r.servingCell
+}
+
+func (r *RanUe) SetServingCell(servingCell *cell) {
+	r.rrcMtx.Lock()
+	defer r.rrcMtx.Unlock()
+
+	r.servingCell = servingCell
+}
+
+// NextRrcTransactionId returns the next RRC transaction identifier, which is 0..3 as in TS 38.331
+func (r *RanUe) NextRrcTransactionId() uint8 {
+	r.rrcMtx.Lock()
+	defer r.rrcMtx.Unlock()
+
+	r.rrcTransactionId = (r.rrcTransactionId + 1) % 4
+	return r.rrcTransactionId
+}
+
+func (r *RanUe) SetRrcMeasurements(rrcMeasurements []protocol.RrcMeasurement) {
+	r.rrcMtx.Lock()
+	defer r.rrcMtx.Unlock()
+
+	r.rrcMeasurements = rrcMeasurements
+}
+
+// GetRrcMeasurement returns the latest reported measurement of the cell
+func (r *RanUe) GetRrcMeasurement(cell protocol.RrcCell) (protocol.RrcMeasurement, bool) {
+	r.rrcMtx.Lock()
+	defer r.rrcMtx.Unlock()
+
+	for _, rrcMeasurement := range r.rrcMeasurements {
+		if rrcMeasurement.Cell == cell {
+			return rrcMeasurement, true
+		}
+	}
+	return protocol.RrcMeasurement{}, false
+}
+
+func (r *RanUe) GetAsSecurityContext() *protocol.AsSecurityContext {
+	r.asSecurityContextMtx.Lock()
+	defer r.asSecurityContextMtx.Unlock()
+
+	return r.asSecurityContext
+}
+
+// SetAsSecurityContext activates AS security on the control connection and the data plane of the UE
+func (r *RanUe) SetAsSecurityContext(kGnb []byte, asSecurityContext *protocol.AsSecurityContext) {
+	r.asSecurityContextMtx.Lock()
+	defer r.asSecurityContextMtx.Unlock()
+
+	r.kGnb = kGnb
+	r.asSecurityContext = asSecurityContext
+	r.SetDrbSecurity(asSecurityContext.Drb)
+}
+
+// NextSecondaryNodeKey returns a fresh sk counter and the K_SN derived with it as in TS 33.501 6.10.2
+func (r *RanUe) NextSecondaryNodeKey() (uint16, []byte, error) {
+	r.asSecurityContextMtx.Lock()
+	defer r.asSecurityContextMtx.Unlock()
+
+	if r.asSecurityContext == nil {
+		return 0, nil, fmt.Errorf("AS security is not activated")
+	}
+
+	skCounter := r.skCounter
+	kSn, err := protocol.DeriveKsn(r.kGnb, skCounter)
+	if err != nil {
+		return 0, nil, err
+	}
+	r.skCounter++
+
+	return skCounter, kSn, nil
+}
@@ -0,0 +1,122 @@
+package gnb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/channel"
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/free5gc/nas/nasType"
+)
+
+var testGetMobileIdentityIMSICases = []struct {
+	name              string
+	mobileIdentity5GS nasType.MobileIdentity5GS
+	expectedImsi      string
+}{
+	{
+		name: "test2DigitMnc",
+		mobileIdentity5GS: nasType.MobileIdentity5GS{
+			Len:    13,
+			Buffer: []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10},
+		},
+		expectedImsi: "imsi-208930000000001",
+	},
+	{
+		name: "test3DigitMnc",
+		mobileIdentity5GS: nasType.MobileIdentity5GS{
+			Len:    13,
+			Buffer: []byte{0x01, 0x13, 0x00, 0x14, 0xf0, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf1},
+		},
+		expectedImsi: "imsi-310410000000001",
+	},
+	{
+		name: "testConcealedSuci",
+		mobileIdentity5GS: nasType.MobileIdentity5GS{
+			Len:    10,
+			Buffer: []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0xff, 0x01, 0x03, 0xab, 0xcd},
+		},
+		expectedImsi: "suci-0-208-93-0-1-3-abcd",
+	},
+	{
+		name: "test5gGuti",
+		mobileIdentity5GS: nasType.MobileIdentity5GS{
+			Len:    11,
+			Buffer: []byte{0xf2, 0x02, 0xf8, 0x39, 0xca, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x01},
+		},
+		expectedImsi: "5g-guti-20893cafe0000000001",
+	},
+	{
+		name: "test5gSTmsi",
+		mobileIdentity5GS: nasType.MobileIdentity5GS{
+			Len:    7,
+			Buffer: []byte{0xf4, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x01},
+		},
+		expectedImsi: "5g-s-tmsi-fe0000000001",
+	},
+}
+
+func TestGetMobileIdentityIMSI(t *testing.T) {
+	for _, testCase := range testGetMobileIdentityIMSICases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ranUe := &RanUe{mobileIdentity5GS: testCase.mobileIdentity5GS}
+			if imsi := ranUe.GetMobileIdentityIMSI(); imsi != testCase.expectedImsi {
+				t.Errorf("expected imsi %s, got %s", testCase.expectedImsi, imsi)
+			}
+		})
+	}
+}
+
+var testRrcResumeCases = []struct {
+	name           string
+	resumeIdentity uint64
+	expectedError  bool
+	expectedState  protocol.RrcState
+}{
+	{
+		name:           "testResumeWithResumeIdentity",
+		resumeIdentity: 7,
+		expectedError:  false,
+		expectedState:  protocol.RRC_STATE_CONNECTED,
+	},
+	{
+		name:           "testResumeWithUnknownResumeIdentity",
+		resumeIdentity: 8,
+		expectedError:  true,
+		expectedState:  protocol.RRC_STATE_INACTIVE,
+	},
+}
+
+func TestRrcResume(t *testing.T) {
+	for _, testCase := range testRrcResumeCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ranUe := &RanUe{
+				ranUeNgapId: 7,
+				rrcState:    protocol.RRC_STATE_CONNECTED,
+				ueDataPlane: newUeDataPlane(4, time.Second, channel.Profile{}),
+			}
+
+			ranUe.SuspendRrc()
+			if _, buffered, _ := ranUe.forwardDlPacket(1, []byte{0x45}); !buffered {
+				t.Fatalf("expected downlink packet buffered while suspended")
+			}
+			if !ranUe.PageRrc() {
+				t.Errorf("expected UE paged on downlink data while suspended")
+			}
+			if ranUe.PageRrc() {
+				t.Errorf("expected UE paged once per suspension")
+			}
+
+			transactionId, err := ranUe.StartRrcResume(testCase.resumeIdentity)
+			if err == nil {
+				err = ranUe.CompleteRrcResume(transactionId)
+			}
+			if (err != nil) != testCase.expectedError {
+				t.Errorf("expected error %t, got %v", testCase.expectedError, err)
+			}
+			if state := ranUe.GetRrcState(); state != testCase.expectedState {
+				t.Errorf("expected state %s, got %s", testCase.expectedState, state)
+			}
+		})
+	}
+}
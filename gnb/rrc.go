@@ -0,0 +1,332 @@
+package gnb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/free5gc/nas/security"
+	"github.com/free5gc/ngap/ngapType"
+)
+
+// processRrcConnectionSetup runs RRCSetupRequest / RRCSetup / RRCSetupComplete with the UE,
+// returns the initial NAS message carried in RRCSetupComplete
+func (g *Gnb) processRrcConnectionSetup(ranUe *RanUe) ([]byte, error) {
+	g.RanLog.Infoln("Processing RRC connection setup")
+
+	rrcSetupRequest, err := ranUe.ReceiveRrcFromUe(protocol.RRC_SETUP_REQUEST)
+	if err != nil {
+		return nil, fmt.Errorf("error receive rrc setup request from UE: %v", err)
+	}
+	ranUe.SetRrcUeIdentity(rrcSetupRequest.UeIdentity)
+	g.RanLog.Tracef("RRC setup request: %+v", rrcSetupRequest)
+	g.RanLog.Debugf("Receive RRC Setup Request from UE %x, cause: %d", rrcSetupRequest.UeIdentity, rrcSetupRequest.EstablishmentCause)
+
+	// the UE camps on the cell of the listener it connected to unless it selects another cell
+	if rrcSetupRequest.NrCellIdentity != protocol.RRC_NR_CELL_IDENTITY_NONE {
+		selectedCell, exists := g.getCell(rrcSetupRequest.NrCellIdentity)
+		if !exists {
+			return nil, fmt.Errorf("error rrc setup request: cell %09x not served by gNB", rrcSetupRequest.NrCellIdentity)
+		}
+		ranUe.SetServingCell(selectedCell)
+	}
+	servingCell := ranUe.GetServingCell()
+
+	transactionId := ranUe.NextRrcTransactionId()
+	n, err := ranUe.SendRrcToUe(&protocol.RrcMessage{
+		Type:           protocol.RRC_SETUP,
+		TransactionId:  transactionId,
+		NrCellIdentity: servingCell.nrCellIdentity,
+		Tac:            servingCell.getTac(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error send rrc setup to UE: %v", err)
+	}
+	g.RanLog.Tracef("Sent %d bytes of RRC Setup to UE", n)
+	g.RanLog.Debugf("Send RRC Setup to UE on cell %s", servingCell)
+
+	rrcSetupComplete, err := ranUe.ReceiveRrcFromUe(protocol.RRC_SETUP_COMPLETE)
+	if err != nil {
+		return nil, fmt.Errorf("error receive rrc setup complete from UE: %v", err)
+	}
+	if rrcSetupComplete.TransactionId != transactionId {
+		return nil, fmt.Errorf("error rrc setup complete: transaction id %d, expected %d", rrcSetupComplete.TransactionId, transactionId)
+	}
+	if len(rrcSetupComplete.DedicatedNas) == 0 {
+		return nil, fmt.Errorf("error rrc setup complete: no dedicated nas")
+	}
+	g.RanLog.Debugln("Receive RRC Setup Complete from UE")
+
+	ranUe.SetRrcState(protocol.RRC_STATE_CONNECTED)
+	g.RanLog.Infof("UE %x in %s", rrcSetupRequest.UeIdentity, protocol.RRC_STATE_CONNECTED)
+	return rrcSetupComplete.DedicatedNas, nil
+}
+
+// processRrcSecurityMode activates AS security with KgNB from the initial context setup request,
+// SecurityModeCommand is integrity protected only, and ciphering starts after SecurityModeComplete as in TS 38.331 5.3.4
+func (g *Gnb) processRrcSecurityMode(ranUe *RanUe, kGnb []byte, ueSecurityCapabilities *ngapType.UESecurityCapabilities) error {
+	g.RanLog.Infoln("Processing RRC security mode")
+
+	cipheringAlgorithm, integrityAlgorithm := selectAsAlgorithms(ueSecurityCapabilities)
+	asSecurityContext, err := protocol.NewAsSecurityContext(kGnb, cipheringAlgorithm, integrityAlgorithm, security.DirectionDownlink)
+	if err != nil {
+		return fmt.Errorf("error new as security context: %v", err)
+	}
+	ranUe.SetAsSecurityContext(kGnb, asSecurityContext)
+	g.RanLog.Tracef("KgNB: %+v", kGnb)
+
+	transactionId := ranUe.NextRrcTransactionId()
+	n, err := ranUe.SendRrcToUe(&protocol.RrcMessage{
+		Type:               protocol.RRC_SECURITY_MODE_COMMAND,
+		TransactionId:      transactionId,
+		CipheringAlgorithm: cipheringAlgorithm,
+		IntegrityAlgorithm: integrityAlgorithm,
+	})
+	if err != nil {
+		return fmt.Errorf("error send rrc security mode command to UE: %v", err)
+	}
+	g.RanLog.Tracef("Sent %d bytes of RRC Security Mode Command to UE", n)
+	g.RanLog.Debugf("Send RRC Security Mode Command to UE, NEA%d, NIA%d", cipheringAlgorithm, integrityAlgorithm)
+
+	rrcSecurityModeComplete, err := ranUe.ReceiveRrcFromUe(protocol.RRC_SECURITY_MODE_COMPLETE)
+	if err != nil {
+		return fmt.Errorf("error receive rrc security mode complete from UE: %v", err)
+	}
+	if rrcSecurityModeComplete.TransactionId != transactionId {
+		return fmt.Errorf("error rrc security mode complete: transaction id %d, expected %d", rrcSecurityModeComplete.TransactionId, transactionId)
+	}
+	g.RanLog.Debugln("Receive RRC Security Mode Complete from UE")
+
+	asSecurityContext.Srb.ActivateCiphering()
+	g.RanLog.Infof("UE %s AS security activated with NEA%d and NIA%d", ranUe.GetMobileIdentityIMSI(), cipheringAlgorithm, integrityAlgorithm)
+	return nil
+}
+
+// sendRrcReconfiguration sends RRCReconfiguration to the UE and returns its transaction id,
+// the caller waits for the RRCReconfigurationComplete of the same transaction id,
+// the sk counter is only meaningful when the secondary cell group is added
+func (g *Gnb) sendRrcReconfiguration(ranUe *RanUe, drbToAdd []uint8, drbToRelease []uint8, scg protocol.RrcScgAction, skCounter uint16, dedicatedNas []byte) (uint8, error) {
+	if ranUe.GetRrcState() != protocol.RRC_STATE_CONNECTED {
+		return 0, fmt.Errorf("UE is in %s", ranUe.GetRrcState())
+	}
+
+	transactionId := ranUe.NextRrcTransactionId()
+	n, err := ranUe.SendRrcToUe(&protocol.RrcMessage{
+		Type:          protocol.RRC_RECONFIGURATION,
+		TransactionId: transactionId,
+		DrbToAdd:      drbToAdd,
+		DrbToRelease:  drbToRelease,
+		Scg:           scg,
+		SkCounter:     skCounter,
+		DedicatedNas:  dedicatedNas,
+	})
+	if err != nil {
+		return 0, err
+	}
+	g.RanLog.Tracef("Sent %d bytes of RRC Reconfiguration to UE", n)
+	g.RanLog.Debugf("Send RRC Reconfiguration to UE, drb to add: %v, drb to release: %v, scg: %d, sk counter: %d", drbToAdd, drbToRelease, scg, skCounter)
+
+	return transactionId, nil
+}
+
+// receiveRrcReconfigurationComplete reads the messages of the UE until the RRCReconfigurationComplete of the transaction
+// for a procedure of serveN1, an uplink nas message in between, e.g. a configuration update complete, is sent to AMF
+func (g *Gnb) receiveRrcReconfigurationComplete(ranUe *RanUe, transactionId uint8) error {
+	for {
+		message, err := ranUe.ReceiveMessageFromUe()
+		if err != nil {
+			return fmt.Errorf("error receive rrc reconfiguration complete from UE: %v", err)
+		}
+		switch message.Type {
+		case protocol.MESSAGE_TYPE_NAS:
+			if err := g.sendUplinkNasTransport(ranUe, message.Payload); err != nil {
+				return fmt.Errorf("error relay uplink nas to AMF: %v", err)
+			}
+			continue
+		case protocol.MESSAGE_TYPE_RRC:
+		default:
+			return fmt.Errorf("error receive rrc reconfiguration complete from UE: unexpected message type %s", message.Type)
+		}
+
+		rrcMessage := &protocol.RrcMessage{}
+		if err := rrcMessage.Unmarshal(message.Payload); err != nil {
+			return fmt.Errorf("error unmarshal rrc message from UE: %v", err)
+		}
+		if rrcMessage.Type != protocol.RRC_RECONFIGURATION_COMPLETE {
+			g.handleUeRrcMessage(ranUe, message.Payload)
+			continue
+		}
+		if rrcMessage.TransactionId != transactionId {
+			return fmt.Errorf("error rrc reconfiguration complete: transaction id %d, expected %d", rrcMessage.TransactionId, transactionId)
+		}
+		g.RanLog.Debugln("Receive RRC Reconfiguration Complete from UE")
+		return nil
+	}
+}
+
+// waitRrcReconfigurationComplete waits for the RRCReconfigurationComplete delivered by the uplink loop of the UE
+func (g *Gnb) waitRrcReconfigurationComplete(ranUe *RanUe, transactionId uint8) error {
+	timer := time.NewTimer(constant.RRC_PROCEDURE_TIMEOUT)
+	defer timer.Stop()
+
+	for {
+		select {
+		case completedTransactionId := <-ranUe.rrcReconfigurationComplete:
+			if completedTransactionId != transactionId {
+				g.RanLog.Warnf("Ignored RRC Reconfiguration Complete of transaction %d, waiting for %d", completedTransactionId, transactionId)
+				continue
+			}
+			g.RanLog.Debugln("Receive RRC Reconfiguration Complete from UE")
+			return nil
+		case <-timer.C:
+			return fmt.Errorf("rrc reconfiguration complete of transaction %d not received in %v", transactionId, constant.RRC_PROCEDURE_TIMEOUT)
+		}
+	}
+}
+
+// processRrcReconfigurationWithSync moves the connected UE to another cell of the gNB,
+// the UE context and its sessions stay on the gNB so that no NGAP procedure is needed
+func (g *Gnb) processRrcReconfigurationWithSync(ranUe *RanUe, targetCell *cell) error {
+	if ranUe.GetRrcState() != protocol.RRC_STATE_CONNECTED {
+		return fmt.Errorf("UE is in %s", ranUe.GetRrcState())
+	}
+	sourceCell := ranUe.GetServingCell()
+	if sourceCell == targetCell {
+		return fmt.Errorf("UE already on cell %s", targetCell)
+	}
+
+	transactionId := ranUe.NextRrcTransactionId()
+	n, err := ranUe.SendRrcToUe(&protocol.RrcMessage{
+		Type:           protocol.RRC_RECONFIGURATION,
+		TransactionId:  transactionId,
+		NrCellIdentity: targetCell.nrCellIdentity,
+		Tac:            targetCell.getTac(),
+	})
+	if err != nil {
+		return fmt.Errorf("error send rrc reconfiguration with sync to UE: %v", err)
+	}
+	g.RanLog.Tracef("Sent %d bytes of RRC Reconfiguration with sync to UE", n)
+	g.RanLog.Debugf("Send RRC Reconfiguration with sync to UE, target cell: %s", targetCell)
+
+	if err := g.waitRrcReconfigurationComplete(ranUe, transactionId); err != nil {
+		return err
+	}
+
+	ranUe.SetServingCell(targetCell)
+	g.RanLog.Infof("UE %s moved from cell %s to cell %s", ranUe.GetMobileIdentityIMSI(), sourceCell, targetCell)
+	return nil
+}
+
+// processRrcRelease releases the UE to RRC_IDLE, or suspends it to RRC_INACTIVE keeping its UE context, PDU sessions
+// and AS security at the gNB, the suspended UE resumes with RRCResumeRequest on paging or on its own uplink
+func (g *Gnb) processRrcRelease(ranUe *RanUe, suspend bool) error {
+	rrcRelease := &protocol.RrcMessage{
+		Type:          protocol.RRC_RELEASE,
+		TransactionId: ranUe.NextRrcTransactionId(),
+		Suspend:       suspend,
+	}
+	if suspend {
+		if ranUe.GetRrcState() != protocol.RRC_STATE_CONNECTED {
+			return fmt.Errorf("UE is in %s", ranUe.GetRrcState())
+		}
+		if ranUe.IsNrdcActivated() {
+			return fmt.Errorf("suspend with NR-DC activated is not supported")
+		}
+		rrcRelease.ResumeIdentity = uint64(ranUe.GetRanUeId())
+		ranUe.SuspendRrc()
+	}
+
+	n, err := ranUe.SendRrcToUe(rrcRelease)
+	if err != nil {
+		if suspend {
+			ranUe.SetRrcState(protocol.RRC_STATE_CONNECTED)
+			if _, err := ranUe.ResumeDataPlane(); err != nil {
+				g.GtpLog.Warnf("Error flush buffered DL packets to UE %s: %v", ranUe.GetMobileIdentityIMSI(), err)
+			}
+		}
+		return fmt.Errorf("error send rrc release to UE: %v", err)
+	}
+	g.RanLog.Tracef("Sent %d bytes of RRC Release to UE", n)
+	g.RanLog.Debugf("Send RRC Release to UE, suspend: %t", suspend)
+
+	if !suspend {
+		ranUe.SetRrcState(protocol.RRC_STATE_IDLE)
+	}
+	g.RanLog.Infof("UE %s in %s", ranUe.GetMobileIdentityIMSI(), ranUe.GetRrcState())
+	return nil
+}
+
+// processRrcResume answers RRCResumeRequest of a suspended UE with RRCResume, the UE is back in RRC_CONNECTED
+// on RRCResumeComplete
+func (g *Gnb) processRrcResume(ranUe *RanUe, rrcResumeRequest *protocol.RrcMessage) error {
+	g.RanLog.Debugf("Receive RRC Resume Request from UE %s, cause: %d", ranUe.GetMobileIdentityIMSI(), rrcResumeRequest.EstablishmentCause)
+
+	transactionId, err := ranUe.StartRrcResume(rrcResumeRequest.ResumeIdentity)
+	if err != nil {
+		return fmt.Errorf("error rrc resume request: %v", err)
+	}
+
+	n, err := ranUe.SendRrcToUe(&protocol.RrcMessage{
+		Type:          protocol.RRC_RESUME,
+		TransactionId: transactionId,
+	})
+	if err != nil {
+		return fmt.Errorf("error send rrc resume to UE: %v", err)
+	}
+	g.RanLog.Tracef("Sent %d bytes of RRC Resume to UE", n)
+	g.RanLog.Debugln("Send RRC Resume to UE")
+	return nil
+}
+
+func (g *Gnb) handleUeRrcMessage(ranUe *RanUe, payload []byte) {
+	rrcMessage := &protocol.RrcMessage{}
+	if err := rrcMessage.Unmarshal(payload); err != nil {
+		g.RanLog.Warnf("Error unmarshal rrc message from UE %s: %v", ranUe.GetMobileIdentityIMSI(), err)
+		return
+	}
+	g.RanLog.Tracef("Received %s from UE %s: %+v", rrcMessage.Type, ranUe.GetMobileIdentityIMSI(), rrcMessage)
+
+	switch rrcMessage.Type {
+	case protocol.RRC_RECONFIGURATION_COMPLETE:
+		select {
+		case ranUe.rrcReconfigurationComplete <- rrcMessage.TransactionId:
+		default:
+			g.RanLog.Warnf("Unexpected RRC Reconfiguration Complete from UE %s", ranUe.GetMobileIdentityIMSI())
+		}
+	case protocol.RRC_RESUME_REQUEST:
+		if err := g.processRrcResume(ranUe, rrcMessage); err != nil {
+			g.RanLog.Warnf("Error process rrc resume of UE %s: %v", ranUe.GetMobileIdentityIMSI(), err)
+		}
+	case protocol.RRC_RESUME_COMPLETE:
+		if err := ranUe.CompleteRrcResume(rrcMessage.TransactionId); err != nil {
+			g.RanLog.Warnf("Error complete rrc resume of UE %s: %v", ranUe.GetMobileIdentityIMSI(), err)
+			return
+		}
+		g.RanLog.Debugln("Receive RRC Resume Complete from UE")
+		g.RanLog.Infof("UE %s in %s", ranUe.GetMobileIdentityIMSI(), protocol.RRC_STATE_CONNECTED)
+
+		flushed, err := ranUe.ResumeDataPlane()
+		if err != nil {
+			g.GtpLog.Warnf("Error flush buffered DL packets to UE %s: %v", ranUe.GetMobileIdentityIMSI(), err)
+		}
+		g.GtpLog.Debugf("Flushed %d buffered DL packets to UE %s", flushed, ranUe.GetMobileIdentityIMSI())
+	case protocol.RRC_MEASUREMENT_REPORT:
+		ranUe.SetRrcMeasurements(rrcMessage.Measurements)
+		g.RanLog.Debugf("Receive Measurement Report from UE %s: %+v", ranUe.GetMobileIdentityIMSI(), rrcMessage.Measurements)
+	default:
+		g.RanLog.Warnf("Unexpected %s from UE %s", rrcMessage.Type, ranUe.GetMobileIdentityIMSI())
+	}
+}
+
+// checkScgAddition checks the latest measurement report of the UE before adding the secondary cell group
+func (g *Gnb) checkScgAddition(ranUe *RanUe) error {
+	rrcMeasurement, reported := ranUe.GetRrcMeasurement(protocol.RRC_CELL_SECONDARY)
+	if !reported {
+		return fmt.Errorf("secondary cell not reported by UE")
+	}
+	if rrcMeasurement.Rsrp < constant.RRC_SCG_ADDITION_RSRP_THRESHOLD {
+		return fmt.Errorf("secondary cell rsrp %d dBm below %d dBm", rrcMeasurement.Rsrp, constant.RRC_SCG_ADDITION_RSRP_THRESHOLD)
+	}
+	return nil
+}
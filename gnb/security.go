@@ -0,0 +1,75 @@
+package gnb
+
+import (
+	"fmt"
+
+	"github.com/free5gc/aper"
+	"github.com/free5gc/nas/security"
+	"github.com/free5gc/ngap/ngapType"
+)
+
+// the AS algorithms in the order of preference of the gNB, the NULL algorithms are the last resort
+var (
+	cipheringAlgorithmPriority = []uint8{security.AlgCiphering128NEA2, security.AlgCiphering128NEA1, security.AlgCiphering128NEA3, security.AlgCiphering128NEA0}
+	integrityAlgorithmPriority = []uint8{security.AlgIntegrity128NIA2, security.AlgIntegrity128NIA1, security.AlgIntegrity128NIA3, security.AlgIntegrity128NIA0}
+)
+
+// getAsSecurityFromInitialContextSetupRequest returns KgNB and the UE security capabilities in the initial context setup request
+func getAsSecurityFromInitialContextSetupRequest(initialContextSetupRequest *ngapType.InitialContextSetupRequest) ([]byte, *ngapType.UESecurityCapabilities, error) {
+	var kGnb []byte
+	var ueSecurityCapabilities *ngapType.UESecurityCapabilities
+
+	for _, ie := range initialContextSetupRequest.ProtocolIEs.List {
+		switch ie.Id.Value {
+		case ngapType.ProtocolIEIDSecurityKey:
+			if ie.Value.SecurityKey == nil {
+				return nil, nil, fmt.Errorf("security key is nil")
+			}
+			kGnb = append([]byte{}, ie.Value.SecurityKey.Value.Bytes...)
+		case ngapType.ProtocolIEIDUESecurityCapabilities:
+			ueSecurityCapabilities = ie.Value.UESecurityCapabilities
+		}
+	}
+
+	if len(kGnb) != 32 {
+		return nil, nil, fmt.Errorf("invalid security key of %d bytes", len(kGnb))
+	}
+	if ueSecurityCapabilities == nil {
+		return nil, nil, fmt.Errorf("no ue security capabilities")
+	}
+	return kGnb, ueSecurityCapabilities, nil
+}
+
+// selectAsAlgorithms selects the ciphering and integrity algorithms of the highest priority supported by the UE
+func selectAsAlgorithms(ueSecurityCapabilities *ngapType.UESecurityCapabilities) (uint8, uint8) {
+	cipheringAlgorithm := selectAsAlgorithm(cipheringAlgorithmPriority, ueSecurityCapabilities.NRencryptionAlgorithms.Value)
+	integrityAlgorithm := selectAsAlgorithm(integrityAlgorithmPriority, ueSecurityCapabilities.NRintegrityProtectionAlgorithms.Value)
+
+	return cipheringAlgorithm, integrityAlgorithm
+}
+
+// selectAsAlgorithm walks the priority list, the first bit of the capability is algorithm 1 as in TS 38.413 9.3.1.86,
+// algorithm 0 is always supported
+func selectAsAlgorithm(priority []uint8, supported aper.BitString) uint8 {
+	for _, algorithm := range priority {
+		if algorithm == 0 {
+			return algorithm
+		}
+		if len(supported.Bytes) > 0 && supported.Bytes[0]&(0x80>>(algorithm-1)) != 0 {
+			return algorithm
+		}
+	}
+	return 0
+}
+
+// getSecondaryNodeSecurity derives K_SN with a fresh sk counter for adding the secondary cell group of the UE,
+// returns the sk counter for RRCReconfiguration and the security for the secondary gNB
+func getSecondaryNodeSecurity(ranUe *RanUe) (uint16, []byte, error) {
+	skCounter, kSn, err := ranUe.NextSecondaryNodeKey()
+	if err != nil {
+		return 0, nil, fmt.Errorf("error derive K_SN: %v", err)
+	}
+
+	asSecurityContext := ranUe.GetAsSecurityContext()
+	return skCounter, newXnSecurity(asSecurityContext.CipheringAlgorithm, asSecurityContext.IntegrityAlgorithm, kSn), nil
+}
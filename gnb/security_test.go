@@ -0,0 +1,57 @@
+package gnb
+
+import (
+	"testing"
+
+	"github.com/free5gc/aper"
+	"github.com/free5gc/nas/security"
+	"github.com/free5gc/ngap/ngapType"
+)
+
+var testSelectAsAlgorithmsCases = []struct {
+	name                       string
+	encryptionAlgorithms       []byte
+	integrityAlgorithms        []byte
+	expectedCipheringAlgorithm uint8
+	expectedIntegrityAlgorithm uint8
+}{
+	{
+		name:                       "testAllSupported",
+		encryptionAlgorithms:       []byte{0xe0, 0x00},
+		integrityAlgorithms:        []byte{0xe0, 0x00},
+		expectedCipheringAlgorithm: security.AlgCiphering128NEA2,
+		expectedIntegrityAlgorithm: security.AlgIntegrity128NIA2,
+	},
+	{
+		name:                       "testNea1Nia3",
+		encryptionAlgorithms:       []byte{0x80, 0x00},
+		integrityAlgorithms:        []byte{0x20, 0x00},
+		expectedCipheringAlgorithm: security.AlgCiphering128NEA1,
+		expectedIntegrityAlgorithm: security.AlgIntegrity128NIA3,
+	},
+	{
+		name:                       "testNullOnly",
+		encryptionAlgorithms:       []byte{0x00, 0x00},
+		integrityAlgorithms:        []byte{0x00, 0x00},
+		expectedCipheringAlgorithm: security.AlgCiphering128NEA0,
+		expectedIntegrityAlgorithm: security.AlgIntegrity128NIA0,
+	},
+}
+
+func TestSelectAsAlgorithms(t *testing.T) {
+	for _, testCase := range testSelectAsAlgorithmsCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ueSecurityCapabilities := &ngapType.UESecurityCapabilities{}
+			ueSecurityCapabilities.NRencryptionAlgorithms.Value = aper.BitString{Bytes: testCase.encryptionAlgorithms, BitLength: 16}
+			ueSecurityCapabilities.NRintegrityProtectionAlgorithms.Value = aper.BitString{Bytes: testCase.integrityAlgorithms, BitLength: 16}
+
+			cipheringAlgorithm, integrityAlgorithm := selectAsAlgorithms(ueSecurityCapabilities)
+			if cipheringAlgorithm != testCase.expectedCipheringAlgorithm {
+				t.Errorf("expected ciphering algorithm %d, got %d", testCase.expectedCipheringAlgorithm, cipheringAlgorithm)
+			}
+			if integrityAlgorithm != testCase.expectedIntegrityAlgorithm {
+				t.Errorf("expected integrity algorithm %d, got %d", testCase.expectedIntegrityAlgorithm, integrityAlgorithm)
+			}
+		})
+	}
+}
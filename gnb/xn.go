@@ -6,34 +6,55 @@ import (
 	"fmt"
 	"net"
 
-	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/protocol"
 	"github.com/free5gc/aper"
+	"github.com/free5gc/nas/security"
 	"github.com/free5gc/ngap"
 	"github.com/free5gc/ngap/ngapConvert"
 	"github.com/free5gc/ngap/ngapType"
 )
 
+// Token is the UE's data plane token issued by the master gNB, which the UE also registers with at the secondary gNB,
+// Security is the AS security of the UE at the secondary gNB, see newXnSecurity
 type XnPdu struct {
-	ImsiLength uint16
-	Imsi       string
-	Data       []byte
+	ImsiLength     uint16
+	Imsi           string
+	TokenLength    uint8
+	Token          []byte
+	SecurityLength uint8
+	Security       []byte
+	Data           []byte
 }
 
 func NewXnPdu(imsi string, data []byte) *XnPdu {
 	return &XnPdu{
-		ImsiLength: 0,
-		Imsi:       imsi,
-		Data:       data,
+		ImsiLength:     0,
+		Imsi:           imsi,
+		TokenLength:    0,
+		Token:          []byte{},
+		SecurityLength: 0,
+		Security:       []byte{},
+		Data:           data,
 	}
 }
 
 func (x *XnPdu) Marshal() ([]byte, error) {
 	imsiBytes := []byte(x.Imsi)
+	if len(x.Token) > 0xff {
+		return nil, fmt.Errorf("token too long")
+	}
+	if len(x.Security) > 0xff {
+		return nil, fmt.Errorf("security too long")
+	}
 
 	buffer := make([]byte, 2)
 	binary.BigEndian.PutUint16(buffer, uint16(len(imsiBytes)))
 
 	buffer = append(buffer, imsiBytes...)
+	buffer = append(buffer, uint8(len(x.Token)))
+	buffer = append(buffer, x.Token...)
+	buffer = append(buffer, uint8(len(x.Security)))
+	buffer = append(buffer, x.Security...)
 	buffer = append(buffer, x.Data...)
 
 	return buffer, nil
@@ -54,11 +75,55 @@ func (x *XnPdu) Unmarshal(data []byte) error {
 	x.Imsi = string(data[:x.ImsiLength])
 	data = data[x.ImsiLength:]
 
+	if len(data) < 1 {
+		return fmt.Errorf("data too short")
+	}
+
+	x.TokenLength = data[0]
+	data = data[1:]
+
+	if len(data) < int(x.TokenLength) {
+		return fmt.Errorf("data too short")
+	}
+
+	x.Token = data[:x.TokenLength]
+	data = data[x.TokenLength:]
+
+	if len(data) < 1 {
+		return fmt.Errorf("data too short")
+	}
+
+	x.SecurityLength = data[0]
+	data = data[1:]
+
+	if len(data) < int(x.SecurityLength) {
+		return fmt.Errorf("data too short")
+	}
+
+	x.Security = data[:x.SecurityLength]
+	data = data[x.SecurityLength:]
+
 	x.Data = data
 
 	return nil
 }
 
+// newXnSecurity carries the AS algorithms selected by the master gNB and K_SN to the secondary gNB:
+//
+//	| ciphering algorithm (1) | integrity algorithm (1) | K_SN (32) |
+func newXnSecurity(cipheringAlgorithm, integrityAlgorithm uint8, kSn []byte) []byte {
+	return append([]byte{cipheringAlgorithm, integrityAlgorithm}, kSn...)
+}
+
+// newXnUeAsSecurityContext derives the AS security of the UE at the secondary gNB from K_SN
+func newXnUeAsSecurityContext(xnSecurity []byte) (*protocol.AsSecurityContext, error) {
+	if len(xnSecurity) != 2+32 {
+		return nil, fmt.Errorf("invalid xn security of %d bytes", len(xnSecurity))
+	}
+
+	return protocol.NewAsSecurityContext(xnSecurity[2:], xnSecurity[0], xnSecurity[1], security.DirectionDownlink)
+}
+
 func xnInterfaceProcessor(conn net.Conn, g *Gnb) {
 	buffer := make([]byte, 4096)
 	n, err := conn.Read(buffer)
@@ -85,7 +150,7 @@ func xnInterfaceProcessor(conn net.Conn, g *Gnb) {
 
 	switch ngapPdu.Present {
 	case ngapType.NGAPPDUPresentInitiatingMessage:
-		xnPduPresentInitiatingMessageDispatcher(g, conn, xnPdu.Imsi, ngapPdu)
+		xnPduPresentInitiatingMessageDispatcher(g, conn, xnPdu.Imsi, xnPdu.Token, xnPdu.Security, ngapPdu)
 	case ngapType.NGAPPDUPresentSuccessfulOutcome:
 		xnPduPresentSuccessfulOutcomeDispatcher(g, conn, xnPdu.Imsi, ngapPdu)
 	default:
@@ -94,14 +159,14 @@ func xnInterfaceProcessor(conn net.Conn, g *Gnb) {
 	}
 }
 
-func xnPduPresentInitiatingMessageDispatcher(g *Gnb, conn net.Conn, imsi string, ngapPdu *ngapType.NGAPPDU) {
+func xnPduPresentInitiatingMessageDispatcher(g *Gnb, conn net.Conn, imsi string, dataPlaneToken []byte, xnSecurity []byte, ngapPdu *ngapType.NGAPPDU) {
 	switch ngapPdu.InitiatingMessage.ProcedureCode.Value {
 	case ngapType.ProcedureCodePDUSessionResourceSetup:
 		g.XnLog.Infoln("Processing NGAP PDU Session Resource Setup Request")
-		xnPduSessionResourceSetupProcessor(g, conn, imsi, ngapPdu)
+		xnPduSessionResourceSetupProcessor(g, conn, imsi, dataPlaneToken, xnSecurity, ngapPdu)
 	case ngapType.ProcedureCodePDUSessionResourceModifyIndication:
 		g.XnLog.Infoln("Processing NGAP PDU Session Resource Modify Indication")
-		xnPduSessionResourceModifyIndicationProcessor(g, conn, imsi, ngapPdu)
+		xnPduSessionResourceModifyIndicationProcessor(g, conn, imsi, dataPlaneToken, xnSecurity, ngapPdu)
 	default:
 		g.XnLog.Warnf("Unknown NGAP PDU Procedure Code: %v", ngapPdu.InitiatingMessage.ProcedureCode.Value)
 		return
@@ -119,8 +184,9 @@ func xnPduPresentSuccessfulOutcomeDispatcher(g *Gnb, conn net.Conn, imsi string,
 	}
 }
 
-func xnPduSessionResourceSetupProcessor(g *Gnb, conn net.Conn, imsi string, ngapPduSessionResourceSetup *ngapType.NGAPPDU) {
+func xnPduSessionResourceSetupProcessor(g *Gnb, conn net.Conn, imsi string, dataPlaneToken []byte, xnSecurity []byte, ngapPduSessionResourceSetup *ngapType.NGAPPDU) {
 	var pduSessionResourceSetupRequestTransfer ngapType.PDUSessionResourceSetupRequestTransfer
+	var pduSessionId uint8
 
 	for _, ie := range ngapPduSessionResourceSetup.InitiatingMessage.Value.PDUSessionResourceSetupRequest.ProtocolIEs.List {
 		switch ie.Id.Value {
@@ -128,6 +194,7 @@ func xnPduSessionResourceSetupProcessor(g *Gnb, conn net.Conn, imsi string, ngap
 		case ngapType.ProtocolIEIDRANUENGAPID:
 		case ngapType.ProtocolIEIDPDUSessionResourceSetupListSUReq:
 			for _, pduSessionResourceSetupItem := range ie.Value.PDUSessionResourceSetupListSUReq.List {
+				pduSessionId = uint8(pduSessionResourceSetupItem.PDUSessionID.Value)
 				if err := aper.UnmarshalWithParams(pduSessionResourceSetupItem.PDUSessionResourceSetupRequestTransfer, &pduSessionResourceSetupRequestTransfer, "valueExt"); err != nil {
 					g.XnLog.Warnf("Error unmarshal pdu session resource setup request transfer: %v", err)
 					return
@@ -138,16 +205,30 @@ func xnPduSessionResourceSetupProcessor(g *Gnb, conn net.Conn, imsi string, ngap
 		}
 	}
 
-	xnUe := NewXnUe(imsi, g.teidGenerator.AllocateTeid(), nil)
+	asSecurityContext, err := newXnUeAsSecurityContext(xnSecurity)
+	if err != nil {
+		g.XnLog.Warnf("Error new xn ue as security context: %v", err)
+		return
+	}
+
+	radioChannelProfile, _ := g.radioChannel.getUeProfile(imsi)
+	xnUe := NewXnUe(imsi, pduSessionId, g.teidGenerator.AllocateTeid(), g.dlBufferSize, g.dlBufferMaxAge, radioChannelProfile)
 	g.xnUeConns.Store(xnUe, struct{}{})
 	g.XnLog.Debugf("Allocated DLTEID for XnUe: %s", hex.EncodeToString(xnUe.GetDlTeid()))
 
+	xnUe.SetDrbSecurity(asSecurityContext.Drb)
+	g.XnLog.Debugf("Set AS security of XnUe %s with NEA%d and NIA%d", imsi, asSecurityContext.CipheringAlgorithm, asSecurityContext.IntegrityAlgorithm)
+
+	xnUe.SetDataPlaneToken(dataPlaneToken)
+	g.dataPlaneIdentityToUe.Store(imsi, xnUe)
+
 	for _, ie := range pduSessionResourceSetupRequestTransfer.ProtocolIEs.List {
 		switch ie.Id.Value {
 		case ngapType.ProtocolIEIDPDUSessionAggregateMaximumBitRate:
 		case ngapType.ProtocolIEIDULNGUUPTNLInformation:
 		case ngapType.ProtocolIEIDAdditionalULNGUUPTNLInformation:
 			xnUe.SetUlTeid(ie.Value.AdditionalULNGUUPTNLInformation.List[0].NGUUPTNLInformation.GTPTunnel.GTPTEID.Value)
+			g.anchorPduSession(&xnUe.pduSession, ie.Value.AdditionalULNGUUPTNLInformation.List[0].NGUUPTNLInformation.GTPTunnel.TransportLayerAddress)
 		case ngapType.ProtocolIEIDPDUSessionType:
 		case ngapType.ProtocolIEIDQosFlowSetupRequestList:
 		}
@@ -191,19 +272,11 @@ func xnPduSessionResourceSetupProcessor(g *Gnb, conn net.Conn, imsi string, ngap
 	g.XnLog.Tracef("Sent %d bytes of DC QoS Flow per TNL Information to XN", n)
 	g.XnLog.Debugln("Send DC QoS Flow per TNL Information to XN")
 
-	// 先存储到 map，确保接收端能找到 UE
-	g.dlTeidToUe.Store(hex.EncodeToString(xnUe.GetDlTeid()), xnUe)
-	g.XnLog.Debugf("Stored XN UE %s with DL TEID %s to dlTeidToUe", xnUe.GetIMSI(), hex.EncodeToString(xnUe.GetDlTeid()))
-
-	// 然后通过 channel 通知接收端
-	g.dlTeidAndUeTypeChannel <- dlTeidAndUeType{
-		dlTeid: xnUe.GetDlTeid(),
-		ueType: constant.UE_TYPE_XN,
-	}
-	g.XnLog.Debugf("Sent DL TEID %s to dlTeidAndUeTypeChannel", hex.EncodeToString(xnUe.GetDlTeid()))
+	g.dlTeidToUe.Store(teidToUint32(xnUe.GetDlTeid()), &dlTunnel{ue: xnUe, pduSessionId: xnUe.GetPduSessionId()})
+	g.XnLog.Debugf("Stored XN UE %s PDU session %d with DL TEID %s to dlTeidToUe", xnUe.GetIMSI(), xnUe.GetPduSessionId(), hex.EncodeToString(xnUe.GetDlTeid()))
 }
 
-func xnPduSessionResourceModifyIndicationProcessor(g *Gnb, conn net.Conn, imsi string, ngapPduSessionResourceModifyIndication *ngapType.NGAPPDU) {
+func xnPduSessionResourceModifyIndicationProcessor(g *Gnb, conn net.Conn, imsi string, dataPlaneToken []byte, xnSecurity []byte, ngapPduSessionResourceModifyIndication *ngapType.NGAPPDU) {
 	if xnReleaseUeProcessor(g, conn, imsi, ngapPduSessionResourceModifyIndication) {
 		g.XnLog.Infof("XnUe released for imsi: %s", imsi)
 		ngapPdu, err := ngap.Encoder(*ngapPduSessionResourceModifyIndication)
@@ -246,14 +319,14 @@ func xnPduSessionResourceModifyIndicationProcessor(g *Gnb, conn net.Conn, imsi s
 		}
 	}
 
-	var pduSessionResourceModifyIndicationTransferMessageRaw []byte
-
-	for _, pduSessionResourceModifyItem := range pduSessionResourceModifyIndicationIE.Value.PDUSessionResourceModifyListModInd.List {
-		switch pduSessionResourceModifyItem.PDUSessionID.Value {
-		case 4:
-			pduSessionResourceModifyIndicationTransferMessageRaw = pduSessionResourceModifyItem.PDUSessionResourceModifyIndicationTransfer
-		}
+	// the master gNB indicates the pdu session split by NR-DC only
+	pduSessionResourceModifyListModInd := pduSessionResourceModifyIndicationIE.Value.PDUSessionResourceModifyListModInd
+	if len(pduSessionResourceModifyListModInd.List) == 0 {
+		g.XnLog.Warnf("No pdu session in pdu session resource modify indication for imsi: %s", imsi)
+		return
 	}
+	pduSessionId := uint8(pduSessionResourceModifyListModInd.List[0].PDUSessionID.Value)
+	pduSessionResourceModifyIndicationTransferMessageRaw := pduSessionResourceModifyListModInd.List[0].PDUSessionResourceModifyIndicationTransfer
 
 	pduSessionResourceModifyIndicationTransfer := ngapType.PDUSessionResourceModifyIndicationTransfer{}
 	if err := aper.UnmarshalWithParams(pduSessionResourceModifyIndicationTransferMessageRaw, &pduSessionResourceModifyIndicationTransfer, "valueExt"); err != nil {
@@ -262,10 +335,23 @@ func xnPduSessionResourceModifyIndicationProcessor(g *Gnb, conn net.Conn, imsi s
 	}
 	g.XnLog.Tracef("Get PDUSessionResourceModifyIndicationTransfer: %+v", pduSessionResourceModifyIndicationTransfer)
 
-	xnUe := NewXnUe(imsi, g.teidGenerator.AllocateTeid(), nil)
+	asSecurityContext, err := newXnUeAsSecurityContext(xnSecurity)
+	if err != nil {
+		g.XnLog.Warnf("Error new xn ue as security context: %v", err)
+		return
+	}
+
+	radioChannelProfile, _ := g.radioChannel.getUeProfile(imsi)
+	xnUe := NewXnUe(imsi, pduSessionId, g.teidGenerator.AllocateTeid(), g.dlBufferSize, g.dlBufferMaxAge, radioChannelProfile)
 	g.xnUeConns.Store(xnUe, struct{}{})
 	g.XnLog.Debugf("Allocated DLTEID for XnUe: %s", hex.EncodeToString(xnUe.GetDlTeid()))
 
+	xnUe.SetDrbSecurity(asSecurityContext.Drb)
+	g.XnLog.Debugf("Set AS security of XnUe %s with NEA%d and NIA%d", imsi, asSecurityContext.CipheringAlgorithm, asSecurityContext.IntegrityAlgorithm)
+
+	xnUe.SetDataPlaneToken(dataPlaneToken)
+	g.dataPlaneIdentityToUe.Store(imsi, xnUe)
+
 	// DC QoS Flow per TNL Information
 	DCQosFlowPerTNLInformationItem := ngapType.QosFlowPerTNLInformationItem{}
 	DCQosFlowPerTNLInformationItem.QosFlowPerTNLInformation.UPTransportLayerInformation.Present = ngapType.UPTransportLayerInformationPresentGTPTunnel
@@ -293,12 +379,7 @@ func xnPduSessionResourceModifyIndicationProcessor(g *Gnb, conn net.Conn, imsi s
 		return
 	}
 
-	for i := range pduSessionResourceModifyIndicationIE.Value.PDUSessionResourceModifyListModInd.List {
-		switch pduSessionResourceModifyIndicationIE.Value.PDUSessionResourceModifyListModInd.List[i].PDUSessionID.Value {
-		case 4:
-			pduSessionResourceModifyIndicationIE.Value.PDUSessionResourceModifyListModInd.List[i].PDUSessionResourceModifyIndicationTransfer = pduSessionResourceModifyIndicationTransferMarshal
-		}
-	}
+	pduSessionResourceModifyListModInd.List[0].PDUSessionResourceModifyIndicationTransfer = pduSessionResourceModifyIndicationTransferMarshal
 	g.XnLog.Tracef("Get PDUSessionResourceModifyIndicationTransfer: %+v", pduSessionResourceModifyIndicationTransfer)
 
 	ngapPdu, err := ngap.Encoder(*ngapPduSessionResourceModifyIndication)
@@ -324,6 +405,21 @@ func xnPduSessionResourceModifyIndicationProcessor(g *Gnb, conn net.Conn, imsi s
 }
 
 func xnPduSessionResourceModifyConfirmProcessor(g *Gnb, conn net.Conn, imsi string, ngapPduSessionResourceModifyConfirm *ngapType.NGAPPDU) {
+	var xnUe *XnUe
+
+	g.xnUeConns.Range(func(key, value interface{}) bool {
+		if key.(*XnUe).GetIMSI() == imsi {
+			xnUe = key.(*XnUe)
+			return false
+		}
+		return true
+	})
+
+	if xnUe == nil {
+		g.XnLog.Warnf("XnUe not found for imsi: %s", imsi)
+		return
+	}
+
 	var pduSessionResourceModifyListModCfm *ngapType.PDUSessionResourceModifyListModCfm
 	var pduSessionResourceModifyConfirmtransferRaw aper.OctetString
 
@@ -337,8 +433,7 @@ func xnPduSessionResourceModifyConfirmProcessor(g *Gnb, conn net.Conn, imsi stri
 	}
 
 	for _, pduSessionResourceModifyItem := range pduSessionResourceModifyListModCfm.List {
-		switch pduSessionResourceModifyItem.PDUSessionID.Value {
-		case 4:
+		if uint8(pduSessionResourceModifyItem.PDUSessionID.Value) == xnUe.GetPduSessionId() {
 			pduSessionResourceModifyConfirmtransferRaw = pduSessionResourceModifyItem.PDUSessionResourceModifyConfirmTransfer
 		}
 	}
@@ -350,22 +445,8 @@ func xnPduSessionResourceModifyConfirmProcessor(g *Gnb, conn net.Conn, imsi stri
 	}
 	g.XnLog.Tracef("Get PDUSessionResourceModifyConfirmTransfer: %+v", pduSessionResourceModifyConfirmtransfer)
 
-	var xnUe *XnUe
-
-	g.xnUeConns.Range(func(key, value interface{}) bool {
-		if key.(*XnUe).GetIMSI() == imsi {
-			xnUe = key.(*XnUe)
-			return false
-		}
-		return true
-	})
-
-	if xnUe == nil {
-		g.XnLog.Warnf("XnUe not found for imsi: %s", imsi)
-		return
-	}
-
 	xnUe.SetUlTeid(pduSessionResourceModifyConfirmtransfer.ULNGUUPTNLInformation.GTPTunnel.GTPTEID.Value)
+	g.anchorPduSession(&xnUe.pduSession, pduSessionResourceModifyConfirmtransfer.ULNGUUPTNLInformation.GTPTunnel.TransportLayerAddress)
 
 	xnPdu := NewXnPdu(imsi, []byte{})
 	xnPduBytes, err := xnPdu.Marshal()
@@ -382,14 +463,8 @@ func xnPduSessionResourceModifyConfirmProcessor(g *Gnb, conn net.Conn, imsi stri
 	g.XnLog.Tracef("Sent %d bytes of NGAP PDU Session Resource Modify Confirm to XN", n)
 	g.XnLog.Debugln("Send NGAP PDU Session Resource Modify Confirm to XN")
 
-	g.dlTeidToUe.Store(hex.EncodeToString(xnUe.GetDlTeid()), xnUe)
-	g.XnLog.Debugf("Stored XN UE %s with DL TEID %s to dlTeidToUe", xnUe.GetIMSI(), hex.EncodeToString(xnUe.GetDlTeid()))
-
-	g.dlTeidAndUeTypeChannel <- dlTeidAndUeType{
-		dlTeid: xnUe.GetDlTeid(),
-		ueType: constant.UE_TYPE_XN,
-	}
-	g.XnLog.Debugf("Sent DL TEID %s to dlTeidAndUeTypeChannel", hex.EncodeToString(xnUe.GetDlTeid()))
+	g.dlTeidToUe.Store(teidToUint32(xnUe.GetDlTeid()), &dlTunnel{ue: xnUe, pduSessionId: xnUe.GetPduSessionId()})
+	g.XnLog.Debugf("Stored XN UE %s PDU session %d with DL TEID %s to dlTeidToUe", xnUe.GetIMSI(), xnUe.GetPduSessionId(), hex.EncodeToString(xnUe.GetDlTeid()))
 }
 
 func xnReleaseUeProcessor(g *Gnb, conn net.Conn, imsi string, ngapPduSessionResourceModifyConfirm *ngapType.NGAPPDU) bool {
@@ -407,13 +482,21 @@ func xnReleaseUeProcessor(g *Gnb, conn net.Conn, imsi string, ngapPduSessionReso
 		return false
 	}
 
-	g.dlTeidToUe.Delete(hex.EncodeToString(xnUe.GetDlTeid()))
+	g.dlTeidToUe.Delete(teidToUint32(xnUe.GetDlTeid()))
 	g.XnLog.Debugf("Deleted XN UE %s with DL TEID %s from dlTeidToUe", xnUe.GetIMSI(), hex.EncodeToString(xnUe.GetDlTeid()))
 
-	g.addressToUe.Delete(xnUe.GetDataPlaneAddress().String())
-	g.XnLog.Debugf("Deleted XN UE %s with data plane address %s from addressToUe", xnUe.GetIMSI(), xnUe.GetDataPlaneAddress().String())
+	if dataPlaneAddress := xnUe.GetDataPlaneAddress(); dataPlaneAddress != nil {
+		g.addressToUe.Delete(dataPlaneAddress.String())
+		g.XnLog.Debugf("Deleted XN UE %s with data plane address %s from addressToUe", xnUe.GetIMSI(), dataPlaneAddress.String())
+	}
+
+	g.dataPlaneIdentityToUe.CompareAndDelete(xnUe.GetIMSI(), xnUe)
+	g.XnLog.Debugf("Deleted XN UE %s from dataPlaneIdentityToUe", xnUe.GetIMSI())
 
 	xnUe.Release(g.teidGenerator)
+	if upfN3Addr := xnUe.GetUpfN3Addr(); upfN3Addr != nil {
+		g.releaseKnownUpf(upfN3Addr)
+	}
 	g.XnLog.Debugf("Released XN UE %s with DL TEID %s", xnUe.GetIMSI(), hex.EncodeToString(xnUe.GetDlTeid()))
 
 	g.xnUeConns.Delete(xnUe)
@@ -1,28 +1,28 @@
 package gnb
 
 import (
-	"net"
+	"time"
 
+	"github.com/Alonza0314/free-ran-ue/channel"
 	"github.com/free5gc/aper"
 )
 
+// XnUe is a UE whose secondary cell group is served by this gNB, it carries the PDU session split by NR-DC
 type XnUe struct {
 	imsi string
 
-	ulTeid aper.OctetString
-	dlTeid aper.OctetString
+	pduSession
 
-	dataPlaneAddress *net.UDPAddr
+	ueDataPlane
 }
 
-func NewXnUe(imsi string, dlTeid aper.OctetString, dataPlaneAddress *net.UDPAddr) *XnUe {
+func NewXnUe(imsi string, pduSessionId uint8, dlTeid aper.OctetString, dlBufferSize int, dlBufferMaxAge time.Duration, radioChannelProfile channel.Profile) *XnUe {
 	return &XnUe{
 		imsi: imsi,
 
-		ulTeid: aper.OctetString{},
-		dlTeid: dlTeid,
+		pduSession: *newPduSession(pduSessionId, dlTeid),
 
-		dataPlaneAddress: dataPlaneAddress,
+		ueDataPlane: newUeDataPlane(dlBufferSize, dlBufferMaxAge, radioChannelProfile),
 	}
 }
 
@@ -33,23 +33,3 @@ func (x *XnUe) Release(teidGenerator *TeidGenerator) {
 func (x *XnUe) GetIMSI() string {
 	return x.imsi
 }
-
-func (x *XnUe) GetUlTeid() aper.OctetString {
-	return x.ulTeid
-}
-
-func (x *XnUe) GetDlTeid() aper.OctetString {
-	return x.dlTeid
-}
-
-func (x *XnUe) GetDataPlaneAddress() *net.UDPAddr {
-	return x.dataPlaneAddress
-}
-
-func (x *XnUe) SetUlTeid(ulTeid aper.OctetString) {
-	x.ulTeid = ulTeid
-}
-
-func (x *XnUe) SetDataPlaneAddress(dataPlaneAddress *net.UDPAddr) {
-	x.dataPlaneAddress = dataPlaneAddress
-}
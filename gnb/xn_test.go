@@ -0,0 +1,70 @@
+package gnb
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/channel"
+	"github.com/Alonza0314/free-ran-ue/logger"
+	"github.com/free5gc/nas/nasType"
+)
+
+func TestReleaseSecondaryNode(t *testing.T) {
+	gnbLogger := logger.NewGnbLogger("error", "", true)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen xn: %v", err)
+	}
+	defer listener.Close()
+
+	secondary := &Gnb{
+		teidGenerator: NewTeidGenerator(false),
+		GnbLogger:     &gnbLogger,
+	}
+	xnUe := NewXnUe("imsi-208930000000001", 1, secondary.teidGenerator.AllocateTeid(), 4, time.Second, channel.Profile{})
+	xnUe.SetDataPlaneToken([]byte{0x01, 0x02})
+	secondary.xnUeConns.Store(xnUe, struct{}{})
+	secondary.dataPlaneIdentityToUe.Store(xnUe.GetIMSI(), xnUe)
+	secondary.dlTeidToUe.Store(teidToUint32(xnUe.GetDlTeid()), &dlTunnel{ue: xnUe, pduSessionId: 1})
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		xnInterfaceProcessor(conn, secondary)
+	}()
+
+	master := &Gnb{
+		ranN3Ip: "127.0.0.1",
+		xnInterface: xnInterface{
+			xnDialIp:   "127.0.0.1",
+			xnDialPort: listener.Addr().(*net.TCPAddr).Port,
+		},
+		GnbLogger: &gnbLogger,
+	}
+	ranUe := &RanUe{
+		amfUeNgapId: 1,
+		ranUeNgapId: 1,
+		mobileIdentity5GS: nasType.MobileIdentity5GS{
+			Len:    13,
+			Buffer: []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10},
+		},
+	}
+
+	// the primary pdu session split by NR-DC is released at the master gNB
+	master.releaseSecondaryNode(ranUe, newPduSession(1, []byte{0x00, 0x00, 0x00, 0x01}))
+
+	if _, exists := secondary.xnUeConns.Load(xnUe); exists {
+		t.Errorf("expected XnUe released at the secondary gNB")
+	}
+	if _, exists := secondary.dataPlaneIdentityToUe.Load(xnUe.GetIMSI()); exists {
+		t.Errorf("expected data plane identity of XnUe released at the secondary gNB")
+	}
+	if _, exists := secondary.dlTeidToUe.Load(teidToUint32(xnUe.GetDlTeid())); exists {
+		t.Errorf("expected DL TEID of XnUe released at the secondary gNB")
+	}
+}
@@ -33,3 +33,17 @@ func NewUeLogger(level loggergoUtil.LogLevelString, filePath string, debugMode b
 		TunLog: logger.WithTags(constant.UE_TAG, constant.TUN_TAG),
 	}
 }
+
+// ForUe returns the loggers of one UE of multi UE mode, they write to the same logger tagged by the imsi of the UE
+func (l *UeLogger) ForUe(imsi string) UeLogger {
+	return UeLogger{
+		Logger: l.Logger,
+
+		CfgLog: l.Logger.WithTags(constant.UE_TAG, imsi, constant.CONFIG_TAG),
+		UeLog:  l.Logger.WithTags(constant.UE_TAG, imsi, constant.UE_TAG),
+		RanLog: l.Logger.WithTags(constant.UE_TAG, imsi, constant.RAN_TAG),
+		NasLog: l.Logger.WithTags(constant.UE_TAG, imsi, constant.NAS_TAG),
+		PduLog: l.Logger.WithTags(constant.UE_TAG, imsi, constant.PDU_TAG),
+		TunLog: l.Logger.WithTags(constant.UE_TAG, imsi, constant.TUN_TAG),
+	}
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type GnbConfig struct {
 	Gnb    GnbIE    `yaml:"gnb" valid:"required"`
 	Logger LoggerIE `yaml:"logger" valid:"required"`
@@ -30,6 +32,14 @@ type GnbIE struct {
 	Tai    TaiIE    `yaml:"tai" valid:"required"`
 	Snssai SnssaiIE `yaml:"snssai" valid:"required"`
 
+	Cells []CellIE `yaml:"cells"`
+
+	TeidAllocation string `yaml:"teidAllocation"`
+
+	DlBuffer DlBufferIE `yaml:"dlBuffer"`
+
+	RadioChannel RadioChannelIE `yaml:"radioChannel"`
+
 	StaticNrdc bool `yaml:"staticNrdc"`
 
 	XnInterface XnInterfaceIE `yaml:"xnInterface"`
@@ -37,6 +47,20 @@ type GnbIE struct {
 	Api ApiIE `yaml:"api" valid:"required"`
 }
 
+// CellIE is one cell of the gNB, the tac and the slices default to the ones of the gNB,
+// and the cell shares the RAN control and data plane listeners of the gNB unless its own are given
+type CellIE struct {
+	NrCellId string     `yaml:"nrCellId" valid:"required"`
+	Pci      int        `yaml:"pci" valid:"required"`
+	Tac      string     `yaml:"tac"`
+	Snssai   []SnssaiIE `yaml:"snssai"`
+
+	RanControlPlaneIp   string `yaml:"ranControlPlaneIp"`
+	RanControlPlanePort int    `yaml:"ranControlPlanePort"`
+	RanDataPlaneIp      string `yaml:"ranDataPlaneIp"`
+	RanDataPlanePort    int    `yaml:"ranDataPlanePort"`
+}
+
 type XnInterfaceIE struct {
 	Enable bool `yaml:"enable" valid:"required"`
 
@@ -47,6 +71,39 @@ type XnInterfaceIE struct {
 	XnDialPort int    `yaml:"xnDialPort" valid:"required"`
 }
 
+type DlBufferIE struct {
+	Size   int           `yaml:"size"`
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+type RadioChannelIE struct {
+	Cell RadioChannelProfileIE `yaml:"cell"`
+	Ue   []UeRadioChannelIE    `yaml:"ue"`
+}
+
+type UeRadioChannelIE struct {
+	Imsi    string                `yaml:"imsi" valid:"required"`
+	Profile RadioChannelProfileIE `yaml:"profile"`
+}
+
+type RadioChannelProfileIE struct {
+	Latency time.Duration `yaml:"latency"`
+	Jitter  time.Duration `yaml:"jitter"`
+
+	Loss    float64 `yaml:"loss"`
+	Reorder float64 `yaml:"reorder"`
+
+	Bandwidth int `yaml:"bandwidth"`
+
+	Outages []RadioChannelOutageIE `yaml:"outages"`
+}
+
+type RadioChannelOutageIE struct {
+	Start    time.Duration `yaml:"start"`
+	Duration time.Duration `yaml:"duration"`
+	Period   time.Duration `yaml:"period"`
+}
+
 type ApiIE struct {
 	Ip   string `yaml:"ip" valid:"required"`
 	Port int    `yaml:"port" valid:"required"`
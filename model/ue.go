@@ -1,10 +1,15 @@
 package model
 
-import "github.com/free5gc/openapi/models"
+import (
+	"time"
+
+	"github.com/free5gc/openapi/models"
+)
 
 type UeConfig struct {
-	Ue     UeIE     `yaml:"ue" valid:"required"`
-	Logger LoggerIE `yaml:"logger" valid:"required"`
+	Ue      UeIE      `yaml:"ue" valid:"required"`
+	MultiUe MultiUeIE `yaml:"multiUe"`
+	Logger  LoggerIE  `yaml:"logger" valid:"required"`
 }
 
 type UeIE struct {
@@ -15,23 +20,68 @@ type UeIE struct {
 	RanControlPlanePort int `yaml:"ranControlPlanePort" valid:"required"`
 	RanDataPlanePort    int `yaml:"ranDataPlanePort" valid:"required"`
 
+	NrCellId string `yaml:"nrCellId"`
+
 	PlmnId PlmnIdIE `yaml:"plmnId" valid:"required"`
 	Msin   string   `yaml:"msin" valid:"required"`
 
+	Suci SuciIE `yaml:"suci"`
+
 	AccessType                 models.AccessType            `yaml:"accessType" valid:"required"`
 	AuthenticationSubscription AuthenticationSubscriptionIE `yaml:"authenticationSubscription" valid:"required"`
 
 	CipheringAlgorithm CipheringAlgorithmIE `yaml:"cipheringAlgorithm" valid:"required"`
 	IntegrityAlgorithm IntegrityAlgorithmIE `yaml:"integrityAlgorithm" valid:"required"`
 
-	PduSession PduSessionIE `yaml:"pduSession" valid:"required"`
+	// the default configured NSSAI, requested in the registration until the network configures the NSSAI of the UE
+	ConfiguredNssai []SnssaiIE `yaml:"configuredNssai"`
+
+	PduSessions []PduSessionIE `yaml:"pduSessions" valid:"required"`
 
 	Nrdc NrdcIE `yaml:"nrdc"`
 
 	UeTunnelDevice string `yaml:"ueTunnelDevice" valid:"required"`
+
+	NasRetry NasRetryIE `yaml:"nasRetry"`
+
+	StateDir string `yaml:"stateDir"`
+
+	Api UeApiIE `yaml:"api"`
+}
+
+// UeApiIE serves the REST API of the UE at ip:port, which reads the state of the UE and drives its procedures,
+// in multi UE mode one server serves every UE under its IMSI
+type UeApiIE struct {
+	Enable bool   `yaml:"enable"`
+	Ip     string `yaml:"ip"`
+	Port   int    `yaml:"port"`
+}
+
+// NasRetryIE is the retry and back-off policy of the NAS procedures, a zero field takes the default of TS 24.501 10.2.
+// A failed registration is attempted again up to registrationAttempts, after registrationInterval as T3511 or after the
+// back-off timer of the network, and a back-off timer longer than maxBackoff gives the procedure up instead of waiting
+type NasRetryIE struct {
+	RegistrationAttempts int           `yaml:"registrationAttempts"`
+	RegistrationInterval time.Duration `yaml:"registrationInterval"`
+	Retransmissions      int           `yaml:"retransmissions"`
+	MaxBackoff           time.Duration `yaml:"maxBackoff"`
+
+	T3510 time.Duration `yaml:"t3510"`
+	T3521 time.Duration `yaml:"t3521"`
+	T3580 time.Duration `yaml:"t3580"`
+}
+
+// SuciIE is how the MSIN is concealed in the SUCI, the home network public key is the hex of the raw X25519 key of profileA
+// or the compressed or uncompressed secp256r1 point of profileB, and the null scheme sends the MSIN in clear
+type SuciIE struct {
+	RoutingIndicator       string `yaml:"routingIndicator"`
+	ProtectionScheme       string `yaml:"protectionScheme"`
+	HomeNetworkPublicKeyId uint8  `yaml:"homeNetworkPublicKeyId"`
+	HomeNetworkPublicKey   string `yaml:"homeNetworkPublicKey"`
 }
 
 type AuthenticationSubscriptionIE struct {
+	AuthenticationMethod          string `yaml:"authenticationMethod"`
 	EncPermanentKey               string `yaml:"encPermanentKey" valid:"required"`
 	EncOpcKey                     string `yaml:"encOpcKey" valid:"required"`
 	AuthenticationManagementField string `yaml:"authenticationManagementField" valid:"required"`
@@ -52,9 +102,18 @@ type CipheringAlgorithmIE struct {
 	Nea3 bool `yaml:"nea3" valid:"required"`
 }
 
+// PduSessionIE is a PDU session of the UE, the first session takes ueTunnelDevice of the ue section if it has no tunnel device,
+// and an on demand session is not established at start. An Ethernet session uses its tunnel device as a TAP device,
+// and an Unstructured session exchanges its payloads as the datagrams of the local UDP address unstructuredSocket.
+// A session without snssai takes the first S-NSSAI of the allowed NSSAI
 type PduSessionIE struct {
-	Dnn    string   `yaml:"dnn" valid:"required"`
-	Snssai SnssaiIE `yaml:"snssai" valid:"required"`
+	Id                 uint8    `yaml:"id" valid:"required"`
+	Type               string   `yaml:"type"`
+	Dnn                string   `yaml:"dnn" valid:"required"`
+	Snssai             SnssaiIE `yaml:"snssai"`
+	UeTunnelDevice     string   `yaml:"ueTunnelDevice"`
+	UnstructuredSocket string   `yaml:"unstructuredSocket"`
+	OnDemand           bool     `yaml:"onDemand"`
 }
 
 type NrdcIE struct {
@@ -67,3 +126,55 @@ type DcDataPlaneIE struct {
 	Ip   string `yaml:"ip" valid:"required"`
 	Port int    `yaml:"port" valid:"required"`
 }
+
+// MultiUeIE runs count UEs built from the ue section in one process,
+// the i-th UE takes msin startMsin + i and the i-th tunnel device counted from ueTunnelDevice
+type MultiUeIE struct {
+	Enable    bool   `yaml:"enable"`
+	StartMsin string `yaml:"startMsin"`
+	Count     int    `yaml:"count"`
+
+	KeyDerivation string `yaml:"keyDerivation"`
+	KeyFile       string `yaml:"keyFile"`
+
+	AttachRate  float64 `yaml:"attachRate"`
+	Concurrency int     `yaml:"concurrency"`
+
+	DataPlane string `yaml:"dataPlane"`
+
+	Traffic MultiUeTrafficIE `yaml:"traffic"`
+}
+
+// MultiUeTrafficIE has every UE of the userspace data plane send an ICMP echo request to the target over each of its
+// IPv4 PDU sessions once per interval, no traffic is sent without a target
+type MultiUeTrafficIE struct {
+	Target   string        `yaml:"target"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// MultiUeKeyIE is an entry of the key file of multi UE mode, an empty sequence number takes the one of the ue section
+type MultiUeKeyIE struct {
+	Msin            string `yaml:"msin" valid:"required"`
+	EncPermanentKey string `yaml:"encPermanentKey" valid:"required"`
+	EncOpcKey       string `yaml:"encOpcKey" valid:"required"`
+	SequenceNumber  string `yaml:"sequenceNumber"`
+}
+
+// UeStateIE is the state a UE keeps in imsi-<supi>.yaml of its state directory across runs, the 5G-GUTI and K_AMF are hex strings
+// and the counts are the 24 bits NAS COUNTs of the native NAS security context
+type UeStateIE struct {
+	SequenceNumber     string                `yaml:"sequenceNumber"`
+	Guti5G             string                `yaml:"guti5G"`
+	NasSecurityContext *NasSecurityContextIE `yaml:"nasSecurityContext"`
+	AllowedNssai       []SnssaiIE            `yaml:"allowedNssai"`
+	ConfiguredNssai    []SnssaiIE            `yaml:"configuredNssai"`
+}
+
+type NasSecurityContextIE struct {
+	NgKsi              uint8  `yaml:"ngKsi"`
+	KAmf               string `yaml:"kAmf"`
+	CipheringAlgorithm uint8  `yaml:"cipheringAlgorithm"`
+	IntegrityAlgorithm uint8  `yaml:"integrityAlgorithm"`
+	UlCount            uint32 `yaml:"ulCount"`
+	DlCount            uint32 `yaml:"dlCount"`
+}
@@ -0,0 +1,314 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/free5gc/util/ueauth"
+)
+
+/*
+EAP-AKA' packets of RFC 5448 are carried in the EAP message IE of the 5GMM messages:
+
+	| code (1) | identifier (1) | length (2) | type (1) | subtype (1) | reserved (2) | attributes |
+
+EAP-Success and EAP-Failure are the first 4 bytes only. Each attribute is | type (1) | length (1) | value |,
+the length counts the whole attribute in units of 4 bytes. AT_MAC is HMAC-SHA-256 with K_aut over the packet
+with the MAC zeroed, truncated to 16 bytes.
+*/
+
+const (
+	EAP_CODE_REQUEST  uint8 = 0x01
+	EAP_CODE_RESPONSE uint8 = 0x02
+	EAP_CODE_SUCCESS  uint8 = 0x03
+	EAP_CODE_FAILURE  uint8 = 0x04
+
+	EAP_TYPE_AKA_PRIME uint8 = 50
+)
+
+const (
+	EAP_AKA_SUBTYPE_CHALLENGE               uint8 = 1
+	EAP_AKA_SUBTYPE_AUTHENTICATION_REJECT   uint8 = 2
+	EAP_AKA_SUBTYPE_SYNCHRONIZATION_FAILURE uint8 = 4
+	EAP_AKA_SUBTYPE_CLIENT_ERROR            uint8 = 14
+)
+
+const (
+	EAP_AT_RAND              uint8 = 1
+	EAP_AT_AUTN              uint8 = 2
+	EAP_AT_RES               uint8 = 3
+	EAP_AT_AUTS              uint8 = 4
+	EAP_AT_MAC               uint8 = 11
+	EAP_AT_CLIENT_ERROR_CODE uint8 = 22
+	EAP_AT_KDF_INPUT         uint8 = 23
+	EAP_AT_KDF               uint8 = 24
+)
+
+const (
+	// RFC 5448 3.3, the KDF of CK' and IK' in TS 33.402 A.2
+	EAP_AKA_PRIME_KDF uint16 = 1
+
+	// RFC 4187 10.19, the peer is unable to process the packet
+	EAP_AKA_CLIENT_ERROR_UNABLE_TO_PROCESS uint16 = 0
+
+	EAP_HEADER_LENGTH     = 4
+	EAP_AKA_HEADER_LENGTH = 8
+	EAP_AKA_MAC_LENGTH    = 16
+)
+
+// EapAkaPrimePacket is an EAP-AKA' packet with the attributes the UE supports, an attribute is absent if its value is empty
+type EapAkaPrimePacket struct {
+	Code       uint8
+	Identifier uint8
+	Subtype    uint8
+
+	Rand            []byte
+	Autn            []byte
+	Res             []byte
+	Auts            []byte
+	KdfInput        string
+	Kdf             uint16
+	ClientErrorCode uint16
+	Mac             []byte
+}
+
+// EapAkaPrimeKeys are the keys of PRF' in RFC 5448 3.3
+type EapAkaPrimeKeys struct {
+	KEncr []byte
+	KAut  []byte
+	KRe   []byte
+	Msk   []byte
+	Emsk  []byte
+}
+
+// Encode encodes the packet, AT_MAC is calculated with K_aut if the key is given
+func (p *EapAkaPrimePacket) Encode(kAut []byte) []byte {
+	packet := []byte{p.Code, p.Identifier, 0x00, 0x00}
+	if p.Code == EAP_CODE_SUCCESS || p.Code == EAP_CODE_FAILURE {
+		binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+		return packet
+	}
+	packet = append(packet, EAP_TYPE_AKA_PRIME, p.Subtype, 0x00, 0x00)
+
+	if len(p.Rand) > 0 {
+		packet = appendEapAttribute(packet, EAP_AT_RAND, append([]byte{0x00, 0x00}, p.Rand...))
+	}
+	if len(p.Autn) > 0 {
+		packet = appendEapAttribute(packet, EAP_AT_AUTN, append([]byte{0x00, 0x00}, p.Autn...))
+	}
+	if len(p.Res) > 0 {
+		packet = appendEapAttribute(packet, EAP_AT_RES, append(binary.BigEndian.AppendUint16(nil, uint16(len(p.Res)*8)), p.Res...))
+	}
+	if len(p.Auts) > 0 {
+		packet = appendEapAttribute(packet, EAP_AT_AUTS, p.Auts)
+	}
+	if p.KdfInput != "" {
+		packet = appendEapAttribute(packet, EAP_AT_KDF_INPUT, append(binary.BigEndian.AppendUint16(nil, uint16(len(p.KdfInput))), p.KdfInput...))
+	}
+	if p.Kdf != 0 {
+		packet = appendEapAttribute(packet, EAP_AT_KDF, binary.BigEndian.AppendUint16(nil, p.Kdf))
+	}
+	if p.Subtype == EAP_AKA_SUBTYPE_CLIENT_ERROR {
+		packet = appendEapAttribute(packet, EAP_AT_CLIENT_ERROR_CODE, binary.BigEndian.AppendUint16(nil, p.ClientErrorCode))
+	}
+
+	macOffset := -1
+	if kAut != nil {
+		macOffset = len(packet) + 4
+		packet = appendEapAttribute(packet, EAP_AT_MAC, make([]byte, 2+EAP_AKA_MAC_LENGTH))
+	}
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+
+	if macOffset >= 0 {
+		copy(packet[macOffset:], eapAkaPrimeMac(kAut, packet))
+	}
+	return packet
+}
+
+// appendEapAttribute appends the attribute with its value padded to the 4 bytes unit
+func appendEapAttribute(packet []byte, attributeType uint8, value []byte) []byte {
+	length := (2 + len(value) + 3) / 4
+	packet = append(packet, attributeType, uint8(length))
+	packet = append(packet, value...)
+	return append(packet, make([]byte, length*4-2-len(value))...)
+}
+
+// eapAttribute is an attribute of a packet with the offset of its value in the packet
+type eapAttribute struct {
+	attributeType uint8
+	offset        int
+	value         []byte
+}
+
+func decodeEapAttributes(packet []byte) ([]eapAttribute, error) {
+	var attributes []eapAttribute
+	for offset := EAP_AKA_HEADER_LENGTH; offset < len(packet); {
+		if len(packet)-offset < 4 {
+			return nil, fmt.Errorf("truncated attribute at %d", offset)
+		}
+		length := int(packet[offset+1]) * 4
+		if length == 0 || offset+length > len(packet) {
+			return nil, fmt.Errorf("invalid length %d of attribute %d", length, packet[offset])
+		}
+		attributes = append(attributes, eapAttribute{
+			attributeType: packet[offset],
+			offset:        offset + 2,
+			value:         packet[offset+2 : offset+length],
+		})
+		offset += length
+	}
+	return attributes, nil
+}
+
+// DecodeEapAkaPrimePacket decodes an EAP-AKA' packet, the skippable attributes of type 128 and above are ignored
+func DecodeEapAkaPrimePacket(packet []byte) (*EapAkaPrimePacket, error) {
+	if len(packet) < EAP_HEADER_LENGTH {
+		return nil, fmt.Errorf("eap packet too short: %d bytes", len(packet))
+	}
+	length := int(binary.BigEndian.Uint16(packet[2:4]))
+	if length < EAP_HEADER_LENGTH || length > len(packet) {
+		return nil, fmt.Errorf("invalid eap length %d of %d bytes", length, len(packet))
+	}
+	packet = packet[:length]
+
+	p := &EapAkaPrimePacket{
+		Code:       packet[0],
+		Identifier: packet[1],
+	}
+	switch p.Code {
+	case EAP_CODE_SUCCESS, EAP_CODE_FAILURE:
+		return p, nil
+	case EAP_CODE_REQUEST, EAP_CODE_RESPONSE:
+	default:
+		return nil, fmt.Errorf("invalid eap code %d", p.Code)
+	}
+
+	if length < EAP_AKA_HEADER_LENGTH {
+		return nil, fmt.Errorf("eap-aka' packet too short: %d bytes", length)
+	}
+	if packet[4] != EAP_TYPE_AKA_PRIME {
+		return nil, fmt.Errorf("unsupported eap type %d", packet[4])
+	}
+	p.Subtype = packet[5]
+
+	attributes, err := decodeEapAttributes(packet)
+	if err != nil {
+		return nil, err
+	}
+	for _, attribute := range attributes {
+		value := attribute.value
+		switch attribute.attributeType {
+		case EAP_AT_RAND, EAP_AT_AUTN:
+			if len(value) != 18 {
+				return nil, fmt.Errorf("invalid length %d of attribute %d", len(value), attribute.attributeType)
+			}
+			if attribute.attributeType == EAP_AT_RAND {
+				p.Rand = value[2:]
+			} else {
+				p.Autn = value[2:]
+			}
+		case EAP_AT_RES:
+			resLength := int(binary.BigEndian.Uint16(value[:2])+7) / 8
+			if resLength > len(value)-2 {
+				return nil, fmt.Errorf("invalid res length %d", resLength)
+			}
+			p.Res = value[2 : 2+resLength]
+		case EAP_AT_AUTS:
+			if len(value) != 14 {
+				return nil, fmt.Errorf("invalid length %d of auts", len(value))
+			}
+			p.Auts = value
+		case EAP_AT_KDF_INPUT:
+			nameLength := int(binary.BigEndian.Uint16(value[:2]))
+			if nameLength > len(value)-2 {
+				return nil, fmt.Errorf("invalid network name length %d", nameLength)
+			}
+			p.KdfInput = string(value[2 : 2+nameLength])
+		case EAP_AT_KDF:
+			// the first AT_KDF is the KDF offered by the server
+			if p.Kdf == 0 {
+				p.Kdf = binary.BigEndian.Uint16(value[:2])
+			}
+		case EAP_AT_CLIENT_ERROR_CODE:
+			p.ClientErrorCode = binary.BigEndian.Uint16(value[:2])
+		case EAP_AT_MAC:
+			if len(value) != 2+EAP_AKA_MAC_LENGTH {
+				return nil, fmt.Errorf("invalid length %d of mac", len(value))
+			}
+			p.Mac = value[2:]
+		default:
+			if attribute.attributeType < 128 {
+				return nil, fmt.Errorf("unsupported non-skippable attribute %d", attribute.attributeType)
+			}
+		}
+	}
+	return p, nil
+}
+
+// VerifyEapAkaPrimeMac checks the AT_MAC of the packet with K_aut, a packet without AT_MAC fails
+func VerifyEapAkaPrimeMac(packet []byte, kAut []byte) (bool, error) {
+	if len(packet) < EAP_AKA_HEADER_LENGTH {
+		return false, fmt.Errorf("eap-aka' packet too short: %d bytes", len(packet))
+	}
+	packet = packet[:binary.BigEndian.Uint16(packet[2:4])]
+
+	attributes, err := decodeEapAttributes(packet)
+	if err != nil {
+		return false, err
+	}
+	for _, attribute := range attributes {
+		if attribute.attributeType != EAP_AT_MAC || len(attribute.value) != 2+EAP_AKA_MAC_LENGTH {
+			continue
+		}
+		zeroed := make([]byte, len(packet))
+		copy(zeroed, packet)
+		clear(zeroed[attribute.offset+2 : attribute.offset+2+EAP_AKA_MAC_LENGTH])
+		return hmac.Equal(eapAkaPrimeMac(kAut, zeroed), attribute.value[2:]), nil
+	}
+	return false, nil
+}
+
+func eapAkaPrimeMac(kAut []byte, packet []byte) []byte {
+	mac := hmac.New(sha256.New, kAut)
+	mac.Write(packet)
+	return mac.Sum(nil)[:EAP_AKA_MAC_LENGTH]
+}
+
+// DeriveCkPrimeIkPrime derives CK' and IK' from CK, IK, the network name of AT_KDF_INPUT and SQN xor AK as in TS 33.402 A.2
+func DeriveCkPrimeIkPrime(ck, ik []byte, networkName string, sqnXorAk []byte) ([]byte, []byte, error) {
+	P0 := []byte(networkName)
+	P1 := sqnXorAk
+
+	derived, err := ueauth.GetKDFValue(append(append([]byte{}, ck...), ik...), ueauth.FC_FOR_CK_PRIME_IK_PRIME_DERIVATION, P0, ueauth.KDFLen(P0), P1, ueauth.KDFLen(P1))
+	if err != nil {
+		return nil, nil, err
+	}
+	return derived[:16], derived[16:], nil
+}
+
+// DeriveEapAkaPrimeKeys derives the keys with PRF' of RFC 5448 3.4 from IK' || CK' and the identity of the peer
+func DeriveEapAkaPrimeKeys(ckPrime, ikPrime []byte, identity string) *EapAkaPrimeKeys {
+	key := append(append([]byte{}, ikPrime...), ckPrime...)
+	seed := []byte("EAP-AKA'" + identity)
+
+	// T1 = HMAC(K, S | 0x01), Tn = HMAC(K, Tn-1 | S | n)
+	keyMaterial, t := make([]byte, 0, 224), []byte{}
+	for n := uint8(1); len(keyMaterial) < 208; n++ {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(t)
+		mac.Write(seed)
+		mac.Write([]byte{n})
+		t = mac.Sum(nil)
+		keyMaterial = append(keyMaterial, t...)
+	}
+
+	return &EapAkaPrimeKeys{
+		KEncr: keyMaterial[0:16],
+		KAut:  keyMaterial[16:48],
+		KRe:   keyMaterial[48:80],
+		Msk:   keyMaterial[80:144],
+		Emsk:  keyMaterial[144:208],
+	}
+}
@@ -0,0 +1,156 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// RFC 5448 Appendix C, test case 1
+var testDeriveEapAkaPrimeKeysCases = []struct {
+	name            string
+	identity        string
+	networkName     string
+	ck              string
+	ik              string
+	sqnXorAk        string
+	expectedCkPrime string
+	expectedIkPrime string
+	expectedKEncr   string
+	expectedKAut    string
+}{
+	{
+		name:            "testRfc5448Case1",
+		identity:        "0555444333222111",
+		networkName:     "WLAN",
+		ck:              "5349fbe098649f948f5d2e973a81c00f",
+		ik:              "9744871ad32bf9bbd1dd5ce54e3e2e5a",
+		sqnXorAk:        "bb52e91c747a",
+		expectedCkPrime: "0093962d0dd84aa5684b045c9edffa04",
+		expectedIkPrime: "ccfc230ca74fcc96c0a5d61164f5a76c",
+		expectedKEncr:   "766fa0a6c317174b812d52fbcd11a179",
+		expectedKAut:    "0842ea722ff6835bfa2032499fc3ec23c2f0e388b4f07543ffc677f1696d71ea",
+	},
+}
+
+func TestDeriveEapAkaPrimeKeys(t *testing.T) {
+	for _, testCase := range testDeriveEapAkaPrimeKeysCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ck, _ := hex.DecodeString(testCase.ck)
+			ik, _ := hex.DecodeString(testCase.ik)
+			sqnXorAk, _ := hex.DecodeString(testCase.sqnXorAk)
+
+			ckPrime, ikPrime, err := DeriveCkPrimeIkPrime(ck, ik, testCase.networkName, sqnXorAk)
+			if err != nil {
+				t.Fatalf("derive ck' ik': %v", err)
+			}
+			if hex.EncodeToString(ckPrime) != testCase.expectedCkPrime || hex.EncodeToString(ikPrime) != testCase.expectedIkPrime {
+				t.Fatalf("ck' %x ik' %x, expected %s %s", ckPrime, ikPrime, testCase.expectedCkPrime, testCase.expectedIkPrime)
+			}
+
+			keys := DeriveEapAkaPrimeKeys(ckPrime, ikPrime, testCase.identity)
+			if hex.EncodeToString(keys.KEncr) != testCase.expectedKEncr || hex.EncodeToString(keys.KAut) != testCase.expectedKAut {
+				t.Fatalf("k_encr %x k_aut %x, expected %s %s", keys.KEncr, keys.KAut, testCase.expectedKEncr, testCase.expectedKAut)
+			}
+			if len(keys.KRe) != 32 || len(keys.Msk) != 64 || len(keys.Emsk) != 64 {
+				t.Fatalf("k_re %d msk %d emsk %d bytes", len(keys.KRe), len(keys.Msk), len(keys.Emsk))
+			}
+		})
+	}
+}
+
+var testKAut = bytes.Repeat([]byte{0x0a}, 32)
+
+var testEapAkaPrimePacketCases = []struct {
+	name   string
+	packet EapAkaPrimePacket
+	kAut   []byte
+}{
+	{
+		name: "testChallengeRequest",
+		packet: EapAkaPrimePacket{
+			Code:       EAP_CODE_REQUEST,
+			Identifier: 0x2a,
+			Subtype:    EAP_AKA_SUBTYPE_CHALLENGE,
+			Rand:       bytes.Repeat([]byte{0x11}, 16),
+			Autn:       bytes.Repeat([]byte{0x22}, 16),
+			KdfInput:   "5G:mnc093.mcc208.3gppnetwork.org",
+			Kdf:        EAP_AKA_PRIME_KDF,
+		},
+		kAut: testKAut,
+	},
+	{
+		name: "testChallengeResponse",
+		packet: EapAkaPrimePacket{
+			Code:       EAP_CODE_RESPONSE,
+			Identifier: 0x2a,
+			Subtype:    EAP_AKA_SUBTYPE_CHALLENGE,
+			Res:        bytes.Repeat([]byte{0x33}, 8),
+		},
+		kAut: testKAut,
+	},
+	{
+		name: "testSynchronizationFailure",
+		packet: EapAkaPrimePacket{
+			Code:       EAP_CODE_RESPONSE,
+			Identifier: 0x2a,
+			Subtype:    EAP_AKA_SUBTYPE_SYNCHRONIZATION_FAILURE,
+			Auts:       bytes.Repeat([]byte{0x44}, 14),
+		},
+	},
+	{
+		name: "testClientError",
+		packet: EapAkaPrimePacket{
+			Code:            EAP_CODE_RESPONSE,
+			Identifier:      0x2a,
+			Subtype:         EAP_AKA_SUBTYPE_CLIENT_ERROR,
+			ClientErrorCode: EAP_AKA_CLIENT_ERROR_UNABLE_TO_PROCESS,
+		},
+	},
+	{
+		name: "testSuccess",
+		packet: EapAkaPrimePacket{
+			Code:       EAP_CODE_SUCCESS,
+			Identifier: 0x2a,
+		},
+	},
+}
+
+func TestEapAkaPrimePacket(t *testing.T) {
+	for _, testCase := range testEapAkaPrimePacketCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			encoded := testCase.packet.Encode(testCase.kAut)
+			if len(encoded)%4 != 0 || int(encoded[2])<<8|int(encoded[3]) != len(encoded) {
+				t.Fatalf("invalid eap length of %x", encoded)
+			}
+
+			decoded, err := DecodeEapAkaPrimePacket(encoded)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if decoded.Code != testCase.packet.Code || decoded.Identifier != testCase.packet.Identifier || decoded.Subtype != testCase.packet.Subtype {
+				t.Fatalf("decoded header %+v, expected %+v", decoded, testCase.packet)
+			}
+			if !bytes.Equal(decoded.Rand, testCase.packet.Rand) || !bytes.Equal(decoded.Autn, testCase.packet.Autn) ||
+				!bytes.Equal(decoded.Res, testCase.packet.Res) || !bytes.Equal(decoded.Auts, testCase.packet.Auts) ||
+				decoded.KdfInput != testCase.packet.KdfInput || decoded.Kdf != testCase.packet.Kdf || decoded.ClientErrorCode != testCase.packet.ClientErrorCode {
+				t.Fatalf("decoded attributes %+v, expected %+v", decoded, testCase.packet)
+			}
+			if testCase.kAut == nil {
+				if decoded.Mac != nil {
+					t.Fatalf("unexpected mac %x", decoded.Mac)
+				}
+				return
+			}
+
+			valid, err := VerifyEapAkaPrimeMac(encoded, testCase.kAut)
+			if err != nil || !valid {
+				t.Fatalf("verify mac: %v %v", valid, err)
+			}
+			encoded[len(encoded)-1] ^= 0x01
+			if valid, _ := VerifyEapAkaPrimeMac(encoded, testCase.kAut); valid {
+				t.Fatalf("tampered mac verified")
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+Every message between RAN and UE on the control connection is framed as:
+
+	| version (1) | type (1) | length (4, big endian) | payload (length) |
+
+The length only counts the payload, so a receiver always knows where a message ends
+regardless of how the TCP stream is segmented.
+*/
+
+const (
+	VERSION uint8 = 1
+
+	HEADER_LENGTH      = 6
+	MAX_PAYLOAD_LENGTH = 1 << 20
+)
+
+type MessageType uint8
+
+const (
+	MESSAGE_TYPE_NAS              MessageType = 0x01
+	MESSAGE_TYPE_TUNNEL_UPDATE    MessageType = 0x02 // superseded by RRCReconfiguration, the value is kept reserved
+	MESSAGE_TYPE_RELEASE          MessageType = 0x03
+	MESSAGE_TYPE_PAGING           MessageType = 0x04
+	MESSAGE_TYPE_REJECT           MessageType = 0x05
+	MESSAGE_TYPE_DATA_PLANE_TOKEN MessageType = 0x06
+	MESSAGE_TYPE_RRC              MessageType = 0x07
+	MESSAGE_TYPE_SECURED          MessageType = 0x08 // a message protected by AS security, see security.go
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case MESSAGE_TYPE_NAS:
+		return "NAS"
+	case MESSAGE_TYPE_TUNNEL_UPDATE:
+		return "Tunnel Update"
+	case MESSAGE_TYPE_RELEASE:
+		return "Release"
+	case MESSAGE_TYPE_PAGING:
+		return "Paging"
+	case MESSAGE_TYPE_REJECT:
+		return "Reject"
+	case MESSAGE_TYPE_DATA_PLANE_TOKEN:
+		return "Data Plane Token"
+	case MESSAGE_TYPE_RRC:
+		return "RRC"
+	case MESSAGE_TYPE_SECURED:
+		return "Secured"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(t))
+	}
+}
+
+// Cause is the one byte payload of release and reject messages
+type Cause uint8
+
+const (
+	CAUSE_NORMAL           Cause = 0x00
+	CAUSE_UNSPECIFIED      Cause = 0x01
+	CAUSE_PROTOCOL_ERROR   Cause = 0x02
+	CAUSE_NETWORK_FAILURE  Cause = 0x03
+	CAUSE_RESOURCE_LIMITED Cause = 0x04
+)
+
+func (c Cause) String() string {
+	switch c {
+	case CAUSE_NORMAL:
+		return "Normal"
+	case CAUSE_UNSPECIFIED:
+		return "Unspecified"
+	case CAUSE_PROTOCOL_ERROR:
+		return "Protocol Error"
+	case CAUSE_NETWORK_FAILURE:
+		return "Network Failure"
+	case CAUSE_RESOURCE_LIMITED:
+		return "Resource Limited"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(c))
+	}
+}
+
+// CauseFromPayload returns the cause carried by a release or reject message
+func CauseFromPayload(payload []byte) Cause {
+	if len(payload) == 0 {
+		return CAUSE_UNSPECIFIED
+	}
+	return Cause(payload[0])
+}
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	ErrPayloadTooLarge    = errors.New("payload too large")
+	ErrMessageTooShort    = errors.New("message too short")
+)
+
+type Message struct {
+	Version uint8
+	Type    MessageType
+	Payload []byte
+}
+
+func NewMessage(messageType MessageType, payload []byte) *Message {
+	return &Message{
+		Version: VERSION,
+		Type:    messageType,
+		Payload: payload,
+	}
+}
+
+func (m *Message) Marshal() ([]byte, error) {
+	if len(m.Payload) > MAX_PAYLOAD_LENGTH {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, len(m.Payload))
+	}
+
+	buffer := make([]byte, HEADER_LENGTH, HEADER_LENGTH+len(m.Payload))
+	buffer[0] = m.Version
+	buffer[1] = uint8(m.Type)
+	binary.BigEndian.PutUint32(buffer[2:HEADER_LENGTH], uint32(len(m.Payload)))
+	buffer = append(buffer, m.Payload...)
+
+	return buffer, nil
+}
+
+// Unmarshal decodes exactly one message from data
+func (m *Message) Unmarshal(data []byte) error {
+	length, err := m.unmarshalHeader(data)
+	if err != nil {
+		return err
+	}
+
+	if len(data)-HEADER_LENGTH != length {
+		return fmt.Errorf("%w: length %d, payload %d bytes", ErrMessageTooShort, length, len(data)-HEADER_LENGTH)
+	}
+	m.Payload = append([]byte{}, data[HEADER_LENGTH:]...)
+
+	return nil
+}
+
+func (m *Message) unmarshalHeader(header []byte) (int, error) {
+	if len(header) < HEADER_LENGTH {
+		return 0, fmt.Errorf("%w: header %d bytes", ErrMessageTooShort, len(header))
+	}
+
+	m.Version = header[0]
+	if m.Version != VERSION {
+		return 0, fmt.Errorf("%w: %d", ErrUnsupportedVersion, m.Version)
+	}
+	m.Type = MessageType(header[1])
+
+	length := binary.BigEndian.Uint32(header[2:HEADER_LENGTH])
+	if length > MAX_PAYLOAD_LENGTH {
+		return 0, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, length)
+	}
+
+	return int(length), nil
+}
+
+// ReadMessage reads one whole message from the stream
+func ReadMessage(reader io.Reader) (*Message, error) {
+	header := make([]byte, HEADER_LENGTH)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	message := &Message{}
+	length, err := message.unmarshalHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	message.Payload = make([]byte, length)
+	if _, err := io.ReadFull(reader, message.Payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// WriteMessage writes one whole message to the stream, returns the number of bytes written
+func WriteMessage(writer io.Writer, message *Message) (int, error) {
+	data, err := message.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	return writer.Write(data)
+}
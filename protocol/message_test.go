@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+var testMessageCases = []struct {
+	name        string
+	messageType MessageType
+	payload     []byte
+}{
+	{
+		name:        "testNasMessage",
+		messageType: MESSAGE_TYPE_NAS,
+		payload:     []byte{0x7e, 0x00, 0x41, 0x79},
+	},
+	{
+		name:        "testTunnelUpdateMessage",
+		messageType: MESSAGE_TYPE_TUNNEL_UPDATE,
+		payload:     []byte{},
+	},
+	{
+		name:        "testReleaseMessage",
+		messageType: MESSAGE_TYPE_RELEASE,
+		payload:     []byte{0x01},
+	},
+	{
+		name:        "testLargeMessage",
+		messageType: MESSAGE_TYPE_NAS,
+		payload:     bytes.Repeat([]byte{0xab}, 4096),
+	},
+}
+
+func TestMessageMarshalUnmarshal(t *testing.T) {
+	for _, testCase := range testMessageCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			data, err := NewMessage(testCase.messageType, testCase.payload).Marshal()
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if len(data) != HEADER_LENGTH+len(testCase.payload) {
+				t.Fatalf("expected %d bytes, got %d", HEADER_LENGTH+len(testCase.payload), len(data))
+			}
+
+			message := &Message{}
+			if err := message.Unmarshal(data); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if message.Version != VERSION || message.Type != testCase.messageType || !bytes.Equal(message.Payload, testCase.payload) {
+				t.Errorf("message mismatch: %+v", message)
+			}
+		})
+	}
+}
+
+func TestReadWriteMessageStream(t *testing.T) {
+	stream := &bytes.Buffer{}
+	for _, testCase := range testMessageCases {
+		if _, err := WriteMessage(stream, NewMessage(testCase.messageType, testCase.payload)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	for _, testCase := range testMessageCases {
+		message, err := ReadMessage(stream)
+		if err != nil {
+			t.Fatalf("%s: read: %v", testCase.name, err)
+		}
+		if message.Type != testCase.messageType || !bytes.Equal(message.Payload, testCase.payload) {
+			t.Errorf("%s: message mismatch: %+v", testCase.name, message)
+		}
+	}
+
+	if _, err := ReadMessage(stream); !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF at end of stream, got %v", err)
+	}
+}
+
+var testInvalidMessageCases = []struct {
+	name        string
+	data        []byte
+	expectedErr error
+}{
+	{
+		name:        "testUnsupportedVersion",
+		data:        []byte{0x02, 0x01, 0x00, 0x00, 0x00, 0x00},
+		expectedErr: ErrUnsupportedVersion,
+	},
+	{
+		name:        "testPayloadTooLarge",
+		data:        []byte{VERSION, 0x01, 0xff, 0xff, 0xff, 0xff},
+		expectedErr: ErrPayloadTooLarge,
+	},
+	{
+		name:        "testShortHeader",
+		data:        []byte{VERSION, 0x01, 0x00},
+		expectedErr: ErrMessageTooShort,
+	},
+	{
+		name:        "testTruncatedPayload",
+		data:        []byte{VERSION, 0x01, 0x00, 0x00, 0x00, 0x04, 0x7e},
+		expectedErr: ErrMessageTooShort,
+	},
+}
+
+func TestUnmarshalInvalidMessage(t *testing.T) {
+	for _, testCase := range testInvalidMessageCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := (&Message{}).Unmarshal(testCase.data); !errors.Is(err, testCase.expectedErr) {
+				t.Errorf("expected %v, got %v", testCase.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestReadTruncatedMessage(t *testing.T) {
+	stream := bytes.NewReader([]byte{VERSION, 0x01, 0x00, 0x00, 0x00, 0x04, 0x7e})
+	if _, err := ReadMessage(stream); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected unexpected EOF, got %v", err)
+	}
+}
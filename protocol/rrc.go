@@ -0,0 +1,349 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+RRC messages are carried as the payload of MESSAGE_TYPE_RRC:
+
+	| rrc type (1) | transaction id (1) | body |
+
+The body depends on the rrc type:
+
+	RRCSetupRequest:             ue identity (8) | establishment cause (1) | nr cell identity (8)
+	RRCSetup:                    nr cell identity (8) | tac (3)
+	RRCSetupComplete:            dedicated nas
+	RRCReconfiguration:          drb to add count (1) | drb ids | drb to release count (1) | drb ids | scg action (1) | sk counter (2) |
+	                             nr cell identity (8) | tac (3) | dedicated nas
+	RRCReconfigurationComplete:  -
+	RRCRelease:                  suspend (1) | resume identity (8)
+	MeasurementReport:           count (1) | { cell (1) | rsrp (2) | rsrq (2) } ...
+	SecurityModeCommand:         ciphering algorithm (1) | integrity algorithm (1)
+	SecurityModeComplete:        -
+	RRCResumeRequest:            resume identity (8) | establishment cause (1)
+	RRCResume:                   -
+	RRCResumeComplete:           -
+
+The nr cell identity is the cell selected by the UE in RRCSetupRequest, the serving cell in RRCSetup,
+and the target cell of the reconfiguration with sync in RRCReconfiguration, RRC_NR_CELL_IDENTITY_NONE if absent.
+
+This is a lightweight stand-in of TS 38.331, not its ASN.1 encoding.
+*/
+
+type RrcMessageType uint8
+
+const (
+	RRC_SETUP_REQUEST            RrcMessageType = 0x01
+	RRC_SETUP                    RrcMessageType = 0x02
+	RRC_SETUP_COMPLETE           RrcMessageType = 0x03
+	RRC_RECONFIGURATION          RrcMessageType = 0x04
+	RRC_RECONFIGURATION_COMPLETE RrcMessageType = 0x05
+	RRC_RELEASE                  RrcMessageType = 0x06
+	RRC_MEASUREMENT_REPORT       RrcMessageType = 0x07
+	RRC_SECURITY_MODE_COMMAND    RrcMessageType = 0x08
+	RRC_SECURITY_MODE_COMPLETE   RrcMessageType = 0x09
+	RRC_RESUME_REQUEST           RrcMessageType = 0x0a
+	RRC_RESUME                   RrcMessageType = 0x0b
+	RRC_RESUME_COMPLETE          RrcMessageType = 0x0c
+)
+
+func (t RrcMessageType) String() string {
+	switch t {
+	case RRC_SETUP_REQUEST:
+		return "RRCSetupRequest"
+	case RRC_SETUP:
+		return "RRCSetup"
+	case RRC_SETUP_COMPLETE:
+		return "RRCSetupComplete"
+	case RRC_RECONFIGURATION:
+		return "RRCReconfiguration"
+	case RRC_RECONFIGURATION_COMPLETE:
+		return "RRCReconfigurationComplete"
+	case RRC_RELEASE:
+		return "RRCRelease"
+	case RRC_MEASUREMENT_REPORT:
+		return "MeasurementReport"
+	case RRC_SECURITY_MODE_COMMAND:
+		return "SecurityModeCommand"
+	case RRC_SECURITY_MODE_COMPLETE:
+		return "SecurityModeComplete"
+	case RRC_RESUME_REQUEST:
+		return "RRCResumeRequest"
+	case RRC_RESUME:
+		return "RRCResume"
+	case RRC_RESUME_COMPLETE:
+		return "RRCResumeComplete"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(t))
+	}
+}
+
+type RrcState uint8
+
+const (
+	RRC_STATE_IDLE RrcState = iota
+	RRC_STATE_CONNECTED
+	RRC_STATE_INACTIVE
+)
+
+func (s RrcState) String() string {
+	switch s {
+	case RRC_STATE_IDLE:
+		return "RRC_IDLE"
+	case RRC_STATE_CONNECTED:
+		return "RRC_CONNECTED"
+	case RRC_STATE_INACTIVE:
+		return "RRC_INACTIVE"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(s))
+	}
+}
+
+type RrcEstablishmentCause uint8
+
+const (
+	RRC_ESTABLISHMENT_CAUSE_EMERGENCY RrcEstablishmentCause = iota
+	RRC_ESTABLISHMENT_CAUSE_HIGH_PRIORITY_ACCESS
+	RRC_ESTABLISHMENT_CAUSE_MT_ACCESS
+	RRC_ESTABLISHMENT_CAUSE_MO_SIGNALLING
+	RRC_ESTABLISHMENT_CAUSE_MO_DATA
+)
+
+type RrcScgAction uint8
+
+const (
+	RRC_SCG_NONE RrcScgAction = iota
+	RRC_SCG_ADD
+	RRC_SCG_RELEASE
+)
+
+// the nr cell identity is 36 bits, 0 is kept for no cell
+const RRC_NR_CELL_IDENTITY_NONE uint64 = 0
+
+type RrcCell uint8
+
+const (
+	RRC_CELL_PRIMARY   RrcCell = 0x00
+	RRC_CELL_SECONDARY RrcCell = 0x01
+)
+
+// RrcMeasurement is the result of one cell, rsrp in dBm and rsrq in dB
+type RrcMeasurement struct {
+	Cell RrcCell
+	Rsrp int16
+	Rsrq int16
+}
+
+type RrcMessage struct {
+	Type          RrcMessageType
+	TransactionId uint8
+
+	// RRCSetupRequest, the establishment cause is the resume cause of RRCResumeRequest
+	UeIdentity         uint64
+	EstablishmentCause RrcEstablishmentCause
+
+	// RRCSetupRequest, RRCSetup, RRCReconfiguration
+	NrCellIdentity uint64
+	Tac            uint32
+
+	// RRCSetupComplete, RRCReconfiguration
+	DedicatedNas []byte
+
+	// RRCReconfiguration, the sk counter derives K_SN of the added secondary cell group
+	DrbToAdd     []uint8
+	DrbToRelease []uint8
+	Scg          RrcScgAction
+	SkCounter    uint16
+
+	// RRCRelease, the resume identity of a suspended UE is sent back in RRCResumeRequest
+	Suspend        bool
+	ResumeIdentity uint64
+
+	// MeasurementReport
+	Measurements []RrcMeasurement
+
+	// SecurityModeCommand
+	CipheringAlgorithm uint8
+	IntegrityAlgorithm uint8
+}
+
+func (m *RrcMessage) Marshal() ([]byte, error) {
+	buffer := []byte{uint8(m.Type), m.TransactionId}
+
+	switch m.Type {
+	case RRC_SETUP_REQUEST:
+		buffer = binary.BigEndian.AppendUint64(buffer, m.UeIdentity)
+		buffer = append(buffer, uint8(m.EstablishmentCause))
+		buffer = binary.BigEndian.AppendUint64(buffer, m.NrCellIdentity)
+	case RRC_SETUP:
+		buffer = binary.BigEndian.AppendUint64(buffer, m.NrCellIdentity)
+		buffer = appendTac(buffer, m.Tac)
+	case RRC_RECONFIGURATION_COMPLETE, RRC_SECURITY_MODE_COMPLETE, RRC_RESUME, RRC_RESUME_COMPLETE:
+	case RRC_RESUME_REQUEST:
+		buffer = binary.BigEndian.AppendUint64(buffer, m.ResumeIdentity)
+		buffer = append(buffer, uint8(m.EstablishmentCause))
+	case RRC_SETUP_COMPLETE:
+		buffer = append(buffer, m.DedicatedNas...)
+	case RRC_RECONFIGURATION:
+		if len(m.DrbToAdd) > 0xff || len(m.DrbToRelease) > 0xff {
+			return nil, fmt.Errorf("too many drbs in %s", m.Type)
+		}
+		buffer = append(buffer, uint8(len(m.DrbToAdd)))
+		buffer = append(buffer, m.DrbToAdd...)
+		buffer = append(buffer, uint8(len(m.DrbToRelease)))
+		buffer = append(buffer, m.DrbToRelease...)
+		buffer = append(buffer, uint8(m.Scg))
+		buffer = binary.BigEndian.AppendUint16(buffer, m.SkCounter)
+		buffer = binary.BigEndian.AppendUint64(buffer, m.NrCellIdentity)
+		buffer = appendTac(buffer, m.Tac)
+		buffer = append(buffer, m.DedicatedNas...)
+	case RRC_RELEASE:
+		suspend := uint8(0)
+		if m.Suspend {
+			suspend = 1
+		}
+		buffer = append(buffer, suspend)
+		buffer = binary.BigEndian.AppendUint64(buffer, m.ResumeIdentity)
+	case RRC_MEASUREMENT_REPORT:
+		if len(m.Measurements) > 0xff {
+			return nil, fmt.Errorf("too many measurements in %s", m.Type)
+		}
+		buffer = append(buffer, uint8(len(m.Measurements)))
+		for _, measurement := range m.Measurements {
+			buffer = append(buffer, uint8(measurement.Cell))
+			buffer = binary.BigEndian.AppendUint16(buffer, uint16(measurement.Rsrp))
+			buffer = binary.BigEndian.AppendUint16(buffer, uint16(measurement.Rsrq))
+		}
+	case RRC_SECURITY_MODE_COMMAND:
+		buffer = append(buffer, m.CipheringAlgorithm, m.IntegrityAlgorithm)
+	default:
+		return nil, fmt.Errorf("unknown rrc message type %s", m.Type)
+	}
+
+	return buffer, nil
+}
+
+func (m *RrcMessage) Unmarshal(data []byte) error {
+	reader := rrcReader{data: data}
+
+	m.Type = RrcMessageType(reader.uint8())
+	m.TransactionId = reader.uint8()
+
+	switch m.Type {
+	case RRC_SETUP_REQUEST:
+		m.UeIdentity = reader.uint64()
+		m.EstablishmentCause = RrcEstablishmentCause(reader.uint8())
+		m.NrCellIdentity = reader.uint64()
+	case RRC_SETUP:
+		m.NrCellIdentity = reader.uint64()
+		m.Tac = reader.tac()
+	case RRC_RECONFIGURATION_COMPLETE, RRC_SECURITY_MODE_COMPLETE, RRC_RESUME, RRC_RESUME_COMPLETE:
+	case RRC_RESUME_REQUEST:
+		m.ResumeIdentity = reader.uint64()
+		m.EstablishmentCause = RrcEstablishmentCause(reader.uint8())
+	case RRC_SETUP_COMPLETE:
+		m.DedicatedNas = reader.rest()
+	case RRC_RECONFIGURATION:
+		m.DrbToAdd = reader.bytes(int(reader.uint8()))
+		m.DrbToRelease = reader.bytes(int(reader.uint8()))
+		m.Scg = RrcScgAction(reader.uint8())
+		m.SkCounter = reader.uint16()
+		m.NrCellIdentity = reader.uint64()
+		m.Tac = reader.tac()
+		m.DedicatedNas = reader.rest()
+	case RRC_RELEASE:
+		m.Suspend = reader.uint8() != 0
+		m.ResumeIdentity = reader.uint64()
+	case RRC_MEASUREMENT_REPORT:
+		count := int(reader.uint8())
+		m.Measurements = make([]RrcMeasurement, 0, count)
+		for range count {
+			m.Measurements = append(m.Measurements, RrcMeasurement{
+				Cell: RrcCell(reader.uint8()),
+				Rsrp: int16(reader.uint16()),
+				Rsrq: int16(reader.uint16()),
+			})
+		}
+	case RRC_SECURITY_MODE_COMMAND:
+		m.CipheringAlgorithm = reader.uint8()
+		m.IntegrityAlgorithm = reader.uint8()
+	default:
+		if reader.err == nil {
+			return fmt.Errorf("unknown rrc message type %s", m.Type)
+		}
+	}
+
+	if reader.err != nil {
+		return fmt.Errorf("error unmarshal %s: %w", m.Type, reader.err)
+	}
+	return nil
+}
+
+// rrcReader reads big endian fields and remembers the first out of range read
+type rrcReader struct {
+	data []byte
+	err  error
+}
+
+func (r *rrcReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = fmt.Errorf("%w: need %d bytes, %d left", ErrMessageTooShort, n, len(r.data))
+		return nil
+	}
+
+	value := append([]byte{}, r.data[:n]...)
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *rrcReader) uint8() uint8 {
+	if value := r.bytes(1); value != nil {
+		return value[0]
+	}
+	return 0
+}
+
+func (r *rrcReader) uint16() uint16 {
+	if value := r.bytes(2); value != nil {
+		return binary.BigEndian.Uint16(value)
+	}
+	return 0
+}
+
+func (r *rrcReader) uint64() uint64 {
+	if value := r.bytes(8); value != nil {
+		return binary.BigEndian.Uint64(value)
+	}
+	return 0
+}
+
+// tac is 3 bytes as in TS 38.413 9.3.3.10
+func (r *rrcReader) tac() uint32 {
+	if value := r.bytes(3); value != nil {
+		return uint32(value[0])<<16 | uint32(value[1])<<8 | uint32(value[2])
+	}
+	return 0
+}
+
+func appendTac(buffer []byte, tac uint32) []byte {
+	return append(buffer, byte(tac>>16), byte(tac>>8), byte(tac))
+}
+
+func (r *rrcReader) rest() []byte {
+	return r.bytes(len(r.data))
+}
+
+// NewRrcMessage wraps the rrc message into a message of MESSAGE_TYPE_RRC
+func NewRrcMessage(rrcMessage *RrcMessage) (*Message, error) {
+	payload, err := rrcMessage.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMessage(MESSAGE_TYPE_RRC, payload), nil
+}
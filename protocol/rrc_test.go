@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testRrcMessageCases = []struct {
+	name       string
+	rrcMessage RrcMessage
+}{
+	{
+		name: "testRrcSetupRequest",
+		rrcMessage: RrcMessage{
+			Type:               RRC_SETUP_REQUEST,
+			UeIdentity:         0x7fffffffff,
+			EstablishmentCause: RRC_ESTABLISHMENT_CAUSE_MO_SIGNALLING,
+			NrCellIdentity:     0x000000002,
+		},
+	},
+	{
+		name: "testRrcSetup",
+		rrcMessage: RrcMessage{
+			Type:           RRC_SETUP,
+			TransactionId:  1,
+			NrCellIdentity: 0xfffffffff,
+			Tac:            0x000002,
+		},
+	},
+	{
+		name: "testRrcSetupComplete",
+		rrcMessage: RrcMessage{
+			Type:          RRC_SETUP_COMPLETE,
+			TransactionId: 1,
+			DedicatedNas:  []byte{0x7e, 0x00, 0x41},
+		},
+	},
+	{
+		name: "testRrcReconfiguration",
+		rrcMessage: RrcMessage{
+			Type:          RRC_RECONFIGURATION,
+			TransactionId: 2,
+			DrbToAdd:      []uint8{1, 2},
+			DrbToRelease:  []uint8{3},
+			Scg:           RRC_SCG_ADD,
+			SkCounter:     0x0102,
+			DedicatedNas:  []byte{0x7e, 0x00, 0x68},
+		},
+	},
+	{
+		name: "testRrcReconfigurationWithSync",
+		rrcMessage: RrcMessage{
+			Type:           RRC_RECONFIGURATION,
+			TransactionId:  4,
+			DrbToAdd:       []uint8{},
+			DrbToRelease:   []uint8{},
+			NrCellIdentity: 0x000000003,
+			Tac:            0x010203,
+		},
+	},
+	{
+		name: "testRrcRelease",
+		rrcMessage: RrcMessage{
+			Type:           RRC_RELEASE,
+			TransactionId:  3,
+			Suspend:        true,
+			ResumeIdentity: 0x1234567890,
+		},
+	},
+	{
+		name: "testRrcResumeRequest",
+		rrcMessage: RrcMessage{
+			Type:               RRC_RESUME_REQUEST,
+			ResumeIdentity:     0x1234567890,
+			EstablishmentCause: RRC_ESTABLISHMENT_CAUSE_MT_ACCESS,
+		},
+	},
+	{
+		name: "testRrcResume",
+		rrcMessage: RrcMessage{
+			Type:          RRC_RESUME,
+			TransactionId: 5,
+		},
+	},
+	{
+		name: "testRrcMeasurementReport",
+		rrcMessage: RrcMessage{
+			Type: RRC_MEASUREMENT_REPORT,
+			Measurements: []RrcMeasurement{
+				{Cell: RRC_CELL_PRIMARY, Rsrp: -80, Rsrq: -10},
+				{Cell: RRC_CELL_SECONDARY, Rsrp: -140, Rsrq: -20},
+			},
+		},
+	},
+	{
+		name: "testRrcSecurityModeCommand",
+		rrcMessage: RrcMessage{
+			Type:               RRC_SECURITY_MODE_COMMAND,
+			TransactionId:      1,
+			CipheringAlgorithm: 2,
+			IntegrityAlgorithm: 2,
+		},
+	},
+}
+
+func TestRrcMessageMarshalUnmarshal(t *testing.T) {
+	for _, testCase := range testRrcMessageCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			data, err := testCase.rrcMessage.Marshal()
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+
+			rrcMessage := RrcMessage{}
+			if err := rrcMessage.Unmarshal(data); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+
+			expected := testCase.rrcMessage
+			if expected.DedicatedNas == nil && (expected.Type == RRC_SETUP_COMPLETE || expected.Type == RRC_RECONFIGURATION) {
+				expected.DedicatedNas = []byte{}
+			}
+			if !reflect.DeepEqual(rrcMessage, expected) {
+				t.Errorf("expected %+v, got %+v", expected, rrcMessage)
+			}
+		})
+	}
+}
+
+func TestRrcMessageUnmarshalTruncated(t *testing.T) {
+	for _, testCase := range testRrcMessageCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			data, err := testCase.rrcMessage.Marshal()
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if len(data) <= 2 || testCase.rrcMessage.Type == RRC_SETUP_COMPLETE {
+				return
+			}
+
+			if err := (&RrcMessage{}).Unmarshal(data[:len(data)-len(testCase.rrcMessage.DedicatedNas)-1]); !errors.Is(err, ErrMessageTooShort) {
+				t.Errorf("expected %v, got %v", ErrMessageTooShort, err)
+			}
+		})
+	}
+}
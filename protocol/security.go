@@ -0,0 +1,345 @@
+package protocol
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/free5gc/nas/security"
+	"github.com/free5gc/util/ueauth"
+)
+
+/*
+After AS security is activated by the RRC security mode procedure, every message on the control connection is
+carried as the payload of MESSAGE_TYPE_SECURED, and every user plane packet is protected the same way:
+
+	| count (4, big endian) | sdu | MAC-I (4) |
+
+The MAC-I is calculated over count and sdu, then sdu and MAC-I are ciphered, as PDCP does in TS 38.323.
+The sdu of a secured message is | type (1) | payload |. Signalling uses SRB1 and is received in order,
+user plane uses the DRB and is received through a replay window, since the radio channel may drop or reorder it.
+*/
+
+// TS 33.501 A.16, K_SN for dual connectivity
+const FC_FOR_KSN_DERIVATION = "6C"
+
+const (
+	PDCP_COUNT_LENGTH = 4
+	PDCP_MAC_LENGTH   = 4
+
+	// the user plane packets accepted behind the highest received count
+	PDCP_REPLAY_WINDOW = 64
+)
+
+// TS 33.501 D.2, the bearer input is the radio bearer identity minus one
+const (
+	SRB1_BEARER uint8 = 0x00
+	DRB1_BEARER uint8 = 0x00
+)
+
+var (
+	ErrIntegrityCheckFailed = errors.New("integrity check failed")
+	ErrCountOutOfOrder      = errors.New("count out of order")
+	ErrCountReplayed        = errors.New("count replayed or too old")
+)
+
+// DeriveKgnb derives KgNB from KAMF and the uplink NAS COUNT as in TS 33.501 A.9
+func DeriveKgnb(kAmf []byte, ulNasCount uint32) ([]byte, error) {
+	P0 := binary.BigEndian.AppendUint32(nil, ulNasCount)
+	P1 := []byte{security.AccessType3GPP}
+
+	return ueauth.GetKDFValue(kAmf, ueauth.FC_FOR_KGNB_KN3IWF_DERIVATION, P0, ueauth.KDFLen(P0), P1, ueauth.KDFLen(P1))
+}
+
+// DeriveKsn derives the key of the secondary node from KgNB and the SK counter as in TS 33.501 A.16
+func DeriveKsn(kGnb []byte, skCounter uint16) ([]byte, error) {
+	P0 := binary.BigEndian.AppendUint16(nil, skCounter)
+
+	return ueauth.GetKDFValue(kGnb, FC_FOR_KSN_DERIVATION, P0, ueauth.KDFLen(P0))
+}
+
+// deriveAsAlgorithmKey derives K_RRCenc, K_RRCint, K_UPenc or K_UPint as in TS 33.501 A.8, the key is the 128 least significant bits
+func deriveAsAlgorithmKey(key []byte, algorithmDistinguisher, algorithm uint8) ([16]byte, error) {
+	P0 := []byte{algorithmDistinguisher}
+	P1 := []byte{algorithm}
+
+	var algorithmKey [16]byte
+	derived, err := ueauth.GetKDFValue(key, ueauth.FC_FOR_ALGORITHM_KEY_DERIVATION, P0, ueauth.KDFLen(P0), P1, ueauth.KDFLen(P1))
+	if err != nil {
+		return algorithmKey, err
+	}
+	copy(algorithmKey[:], derived[len(derived)-16:])
+	return algorithmKey, nil
+}
+
+// AsSecurityContext is the AS security of one side of the RAN-UE link, derived from KgNB, or K_SN for the secondary node
+type AsSecurityContext struct {
+	CipheringAlgorithm uint8
+	IntegrityAlgorithm uint8
+
+	Srb *PdcpEntity
+	Drb *PdcpEntity
+}
+
+// NewAsSecurityContext derives the AS keys, direction is the direction this side transmits in,
+// ciphering of the SRB starts after the security mode procedure while the DRB is ciphered at once
+func NewAsSecurityContext(key []byte, cipheringAlgorithm, integrityAlgorithm uint8, direction uint8) (*AsSecurityContext, error) {
+	kRrcEnc, err := deriveAsAlgorithmKey(key, security.NRRCEncAlg, cipheringAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("error derive K_RRCenc: %w", err)
+	}
+	kRrcInt, err := deriveAsAlgorithmKey(key, security.NRRCIntAlg, integrityAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("error derive K_RRCint: %w", err)
+	}
+	kUpEnc, err := deriveAsAlgorithmKey(key, security.NUpEncAlg, cipheringAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("error derive K_UPenc: %w", err)
+	}
+	kUpInt, err := deriveAsAlgorithmKey(key, security.NUpIntAlg, integrityAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("error derive K_UPint: %w", err)
+	}
+
+	srb := newPdcpEntity(cipheringAlgorithm, integrityAlgorithm, kRrcEnc, kRrcInt, SRB1_BEARER, direction, true)
+	drb := newPdcpEntity(cipheringAlgorithm, integrityAlgorithm, kUpEnc, kUpInt, DRB1_BEARER, direction, false)
+	drb.ActivateCiphering()
+
+	return &AsSecurityContext{
+		CipheringAlgorithm: cipheringAlgorithm,
+		IntegrityAlgorithm: integrityAlgorithm,
+
+		Srb: srb,
+		Drb: drb,
+	}, nil
+}
+
+// SecureMessage protects the message on SRB1 and wraps it into a message of MESSAGE_TYPE_SECURED
+func (c *AsSecurityContext) SecureMessage(message *Message) (*Message, error) {
+	pdu, err := c.Srb.Protect(append([]byte{uint8(message.Type)}, message.Payload...))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMessage(MESSAGE_TYPE_SECURED, pdu), nil
+}
+
+// UnsecureMessage verifies and deciphers a message of MESSAGE_TYPE_SECURED, a message without protection is rejected
+func (c *AsSecurityContext) UnsecureMessage(message *Message) (*Message, error) {
+	if message.Type != MESSAGE_TYPE_SECURED {
+		return nil, fmt.Errorf("unprotected %s message after AS security activation", message.Type)
+	}
+
+	sdu, err := c.Srb.Unprotect(message.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(sdu) < 1 {
+		return nil, fmt.Errorf("%w: empty secured message", ErrMessageTooShort)
+	}
+
+	return &Message{
+		Version: message.Version,
+		Type:    MessageType(sdu[0]),
+		Payload: sdu[1:],
+	}, nil
+}
+
+// UnverifiedMessage returns the message inside a secured message whose SRB ciphering is not active yet, without verifying it,
+// it is only for reading the algorithms of SecurityModeCommand before the keys are derived, which are then used to verify it
+func UnverifiedMessage(message *Message) (*Message, error) {
+	if message.Type != MESSAGE_TYPE_SECURED {
+		return nil, fmt.Errorf("unexpected message type %s, expected %s", message.Type, MESSAGE_TYPE_SECURED)
+	}
+	if len(message.Payload) < PDCP_COUNT_LENGTH+1+PDCP_MAC_LENGTH {
+		return nil, fmt.Errorf("%w: secured message of %d bytes", ErrMessageTooShort, len(message.Payload))
+	}
+
+	sdu := message.Payload[PDCP_COUNT_LENGTH : len(message.Payload)-PDCP_MAC_LENGTH]
+	return &Message{
+		Version: message.Version,
+		Type:    MessageType(sdu[0]),
+		Payload: append([]byte{}, sdu[1:]...),
+	}, nil
+}
+
+// PdcpEntity protects one radio bearer in both directions
+type PdcpEntity struct {
+	cipheringAlgorithm uint8
+	integrityAlgorithm uint8
+
+	kEnc [16]byte
+	kInt [16]byte
+
+	bearer      uint8
+	txDirection uint8
+	inOrder     bool
+	ciphering   bool
+
+	txCount uint32
+
+	// in order bearers expect rxCount next, the others keep a window of the counts received behind rxHighest
+	rxCount    uint32
+	rxHighest  uint32
+	rxWindow   uint64
+	rxReceived bool
+
+	mtx sync.Mutex
+}
+
+func newPdcpEntity(cipheringAlgorithm, integrityAlgorithm uint8, kEnc, kInt [16]byte, bearer, txDirection uint8, inOrder bool) *PdcpEntity {
+	return &PdcpEntity{
+		cipheringAlgorithm: cipheringAlgorithm,
+		integrityAlgorithm: integrityAlgorithm,
+
+		kEnc: kEnc,
+		kInt: kInt,
+
+		bearer:      bearer,
+		txDirection: txDirection,
+		inOrder:     inOrder,
+
+		mtx: sync.Mutex{},
+	}
+}
+
+func (p *PdcpEntity) ActivateCiphering() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.ciphering = true
+}
+
+func (p *PdcpEntity) IsCipheringActivated() bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.ciphering
+}
+
+// Protect returns the pdu of the sdu with the next transmit count
+func (p *PdcpEntity) Protect(sdu []byte) ([]byte, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.txCount == math.MaxUint32 {
+		return nil, fmt.Errorf("count wraps around, AS key refresh required")
+	}
+	count := p.txCount
+
+	pdu := make([]byte, 0, PDCP_COUNT_LENGTH+len(sdu)+PDCP_MAC_LENGTH)
+	pdu = binary.BigEndian.AppendUint32(pdu, count)
+	pdu = append(pdu, sdu...)
+
+	mac, err := p.mac(count, p.txDirection, pdu)
+	if err != nil {
+		return nil, err
+	}
+	pdu = append(pdu, mac...)
+
+	if err := p.cipher(count, p.txDirection, pdu[PDCP_COUNT_LENGTH:]); err != nil {
+		return nil, err
+	}
+
+	p.txCount++
+	return pdu, nil
+}
+
+// Unprotect deciphers and verifies the pdu, and returns its sdu
+func (p *PdcpEntity) Unprotect(pdu []byte) ([]byte, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if len(pdu) < PDCP_COUNT_LENGTH+PDCP_MAC_LENGTH {
+		return nil, fmt.Errorf("%w: pdu of %d bytes", ErrMessageTooShort, len(pdu))
+	}
+	count := binary.BigEndian.Uint32(pdu[:PDCP_COUNT_LENGTH])
+	if err := p.checkRxCount(count); err != nil {
+		return nil, err
+	}
+
+	rxDirection := p.txDirection ^ 1
+	plain := append([]byte{}, pdu...)
+	if err := p.cipher(count, rxDirection, plain[PDCP_COUNT_LENGTH:]); err != nil {
+		return nil, err
+	}
+
+	macOffset := len(plain) - PDCP_MAC_LENGTH
+	mac, err := p.mac(count, rxDirection, plain[:macOffset])
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(mac, plain[macOffset:]) != 1 {
+		return nil, fmt.Errorf("%w at count %d", ErrIntegrityCheckFailed, count)
+	}
+
+	p.updateRxCount(count)
+	return plain[PDCP_COUNT_LENGTH:macOffset], nil
+}
+
+func (p *PdcpEntity) checkRxCount(count uint32) error {
+	if p.inOrder {
+		if count != p.rxCount {
+			return fmt.Errorf("%w: count %d, expected %d", ErrCountOutOfOrder, count, p.rxCount)
+		}
+		return nil
+	}
+
+	if !p.rxReceived || count > p.rxHighest {
+		return nil
+	}
+	offset := p.rxHighest - count
+	if offset >= PDCP_REPLAY_WINDOW || p.rxWindow&(1<<offset) != 0 {
+		return fmt.Errorf("%w: count %d, highest %d", ErrCountReplayed, count, p.rxHighest)
+	}
+	return nil
+}
+
+// updateRxCount moves the receive state after the pdu of count is verified
+func (p *PdcpEntity) updateRxCount(count uint32) {
+	if p.inOrder {
+		p.rxCount++
+		return
+	}
+
+	switch {
+	case !p.rxReceived:
+		p.rxHighest, p.rxWindow, p.rxReceived = count, 1, true
+	case count > p.rxHighest:
+		if shift := count - p.rxHighest; shift < PDCP_REPLAY_WINDOW {
+			p.rxWindow = p.rxWindow<<shift | 1
+		} else {
+			p.rxWindow = 1
+		}
+		p.rxHighest = count
+	default:
+		p.rxWindow |= 1 << (p.rxHighest - count)
+	}
+}
+
+func (p *PdcpEntity) mac(count uint32, direction uint8, data []byte) ([]byte, error) {
+	if p.integrityAlgorithm == security.AlgIntegrity128NIA0 {
+		return make([]byte, PDCP_MAC_LENGTH), nil
+	}
+
+	mac, err := security.NASMacCalculate(p.integrityAlgorithm, p.kInt, count, p.bearer, direction, data)
+	if err != nil {
+		return nil, fmt.Errorf("error calculate MAC-I: %w", err)
+	}
+	return mac, nil
+}
+
+// cipher ciphers or deciphers data in place
+func (p *PdcpEntity) cipher(count uint32, direction uint8, data []byte) error {
+	if !p.ciphering || p.cipheringAlgorithm == security.AlgCiphering128NEA0 || len(data) == 0 {
+		return nil
+	}
+
+	if err := security.NASEncrypt(p.cipheringAlgorithm, p.kEnc, count, p.bearer, direction, data); err != nil {
+		return fmt.Errorf("error cipher: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/free5gc/nas/security"
+)
+
+var testKAmf = bytes.Repeat([]byte{0x5a}, 32)
+
+func newTestAsSecurityContexts(t *testing.T, kGnbUe, kGnbRan []byte, cipheringAlgorithm, integrityAlgorithm uint8) (*AsSecurityContext, *AsSecurityContext) {
+	ueContext, err := NewAsSecurityContext(kGnbUe, cipheringAlgorithm, integrityAlgorithm, security.DirectionUplink)
+	if err != nil {
+		t.Fatalf("new ue as security context: %v", err)
+	}
+	ranContext, err := NewAsSecurityContext(kGnbRan, cipheringAlgorithm, integrityAlgorithm, security.DirectionDownlink)
+	if err != nil {
+		t.Fatalf("new ran as security context: %v", err)
+	}
+	return ueContext, ranContext
+}
+
+var testAsSecurityAlgorithmCases = []struct {
+	name               string
+	cipheringAlgorithm uint8
+	integrityAlgorithm uint8
+}{
+	{
+		name:               "testNea0Nia0",
+		cipheringAlgorithm: security.AlgCiphering128NEA0,
+		integrityAlgorithm: security.AlgIntegrity128NIA0,
+	},
+	{
+		name:               "testNea1Nia1",
+		cipheringAlgorithm: security.AlgCiphering128NEA1,
+		integrityAlgorithm: security.AlgIntegrity128NIA1,
+	},
+	{
+		name:               "testNea2Nia2",
+		cipheringAlgorithm: security.AlgCiphering128NEA2,
+		integrityAlgorithm: security.AlgIntegrity128NIA2,
+	},
+	{
+		name:               "testNea3Nia3",
+		cipheringAlgorithm: security.AlgCiphering128NEA3,
+		integrityAlgorithm: security.AlgIntegrity128NIA3,
+	},
+}
+
+func TestAsSecuritySecureMessage(t *testing.T) {
+	kGnb, err := DeriveKgnb(testKAmf, 0)
+	if err != nil {
+		t.Fatalf("derive kgnb: %v", err)
+	}
+
+	for _, testCase := range testAsSecurityAlgorithmCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ueContext, ranContext := newTestAsSecurityContexts(t, kGnb, kGnb, testCase.cipheringAlgorithm, testCase.integrityAlgorithm)
+			payload := []byte{0x7e, 0x00, 0x41, 0x79, 0x00, 0x0d}
+
+			// integrity only before the security mode procedure completes, then ciphered
+			for _, ciphering := range []bool{false, true} {
+				if ciphering {
+					ueContext.Srb.ActivateCiphering()
+					ranContext.Srb.ActivateCiphering()
+				}
+
+				secured, err := ranContext.SecureMessage(NewMessage(MESSAGE_TYPE_NAS, payload))
+				if err != nil {
+					t.Fatalf("secure: %v", err)
+				}
+				if secured.Type != MESSAGE_TYPE_SECURED {
+					t.Fatalf("expected %s, got %s", MESSAGE_TYPE_SECURED, secured.Type)
+				}
+				clear := bytes.Contains(secured.Payload, payload)
+				if ciphering && testCase.cipheringAlgorithm != security.AlgCiphering128NEA0 && clear {
+					t.Errorf("payload is not ciphered")
+				}
+				if !ciphering && !clear {
+					t.Errorf("payload is ciphered before activation")
+				}
+
+				message, err := ueContext.UnsecureMessage(secured)
+				if err != nil {
+					t.Fatalf("unsecure: %v", err)
+				}
+				if message.Type != MESSAGE_TYPE_NAS || !bytes.Equal(message.Payload, payload) {
+					t.Errorf("message mismatch: %+v", message)
+				}
+			}
+		})
+	}
+}
+
+func TestAsSecurityKgnbMismatch(t *testing.T) {
+	kGnbUe, err := DeriveKgnb(testKAmf, 0)
+	if err != nil {
+		t.Fatalf("derive kgnb: %v", err)
+	}
+	kGnbRan, err := DeriveKgnb(testKAmf, 1)
+	if err != nil {
+		t.Fatalf("derive kgnb: %v", err)
+	}
+	if bytes.Equal(kGnbUe, kGnbRan) {
+		t.Fatalf("kgnb does not depend on the uplink nas count")
+	}
+
+	ueContext, ranContext := newTestAsSecurityContexts(t, kGnbUe, kGnbRan, security.AlgCiphering128NEA2, security.AlgIntegrity128NIA2)
+	secured, err := ranContext.SecureMessage(NewMessage(MESSAGE_TYPE_RRC, []byte{0x08, 0x00, 0x02, 0x02}))
+	if err != nil {
+		t.Fatalf("secure: %v", err)
+	}
+
+	// the algorithms are readable before the keys are known, the mac is not
+	unverified, err := UnverifiedMessage(secured)
+	if err != nil || unverified.Type != MESSAGE_TYPE_RRC {
+		t.Fatalf("unverified message: %+v, %v", unverified, err)
+	}
+	if _, err := ueContext.UnsecureMessage(secured); !errors.Is(err, ErrIntegrityCheckFailed) {
+		t.Errorf("expected %v, got %v", ErrIntegrityCheckFailed, err)
+	}
+}
+
+func TestAsSecuritySrbInOrder(t *testing.T) {
+	kGnb, err := DeriveKgnb(testKAmf, 0)
+	if err != nil {
+		t.Fatalf("derive kgnb: %v", err)
+	}
+	ueContext, ranContext := newTestAsSecurityContexts(t, kGnb, kGnb, security.AlgCiphering128NEA2, security.AlgIntegrity128NIA2)
+
+	first, err := ueContext.SecureMessage(NewMessage(MESSAGE_TYPE_RRC, []byte{0x09, 0x00}))
+	if err != nil {
+		t.Fatalf("secure: %v", err)
+	}
+	second, err := ueContext.SecureMessage(NewMessage(MESSAGE_TYPE_NAS, []byte{0x7e}))
+	if err != nil {
+		t.Fatalf("secure: %v", err)
+	}
+
+	if _, err := ranContext.UnsecureMessage(second); !errors.Is(err, ErrCountOutOfOrder) {
+		t.Errorf("expected %v, got %v", ErrCountOutOfOrder, err)
+	}
+	if _, err := ranContext.UnsecureMessage(first); err != nil {
+		t.Errorf("unsecure first: %v", err)
+	}
+	if _, err := ranContext.UnsecureMessage(second); err != nil {
+		t.Errorf("unsecure second: %v", err)
+	}
+	if _, err := ranContext.UnsecureMessage(NewMessage(MESSAGE_TYPE_NAS, []byte{0x7e})); err == nil {
+		t.Errorf("expected unprotected message to be rejected")
+	}
+}
+
+func TestAsSecurityTamper(t *testing.T) {
+	kGnb, err := DeriveKgnb(testKAmf, 0)
+	if err != nil {
+		t.Fatalf("derive kgnb: %v", err)
+	}
+
+	for _, testCase := range testAsSecurityAlgorithmCases[1:] {
+		t.Run(testCase.name, func(t *testing.T) {
+			ueContext, ranContext := newTestAsSecurityContexts(t, kGnb, kGnb, testCase.cipheringAlgorithm, testCase.integrityAlgorithm)
+
+			pdu, err := ueContext.Drb.Protect([]byte{0x45, 0x00, 0x00, 0x1c, 0x00, 0x01})
+			if err != nil {
+				t.Fatalf("protect: %v", err)
+			}
+			pdu[PDCP_COUNT_LENGTH] ^= 0x01
+
+			if _, err := ranContext.Drb.Unprotect(pdu); !errors.Is(err, ErrIntegrityCheckFailed) {
+				t.Errorf("expected %v, got %v", ErrIntegrityCheckFailed, err)
+			}
+		})
+	}
+}
+
+var testDrbReplayWindowCases = []struct {
+	name     string
+	received []uint32
+	accepted []bool
+}{
+	{
+		name:     "testInOrder",
+		received: []uint32{0, 1, 2, 3},
+		accepted: []bool{true, true, true, true},
+	},
+	{
+		name:     "testLossAndReorder",
+		received: []uint32{0, 3, 1, 2, 5},
+		accepted: []bool{true, true, true, true, true},
+	},
+	{
+		name:     "testReplay",
+		received: []uint32{0, 1, 1, 0},
+		accepted: []bool{true, true, false, false},
+	},
+	{
+		name:     "testBehindWindow",
+		received: []uint32{0, PDCP_REPLAY_WINDOW + 1, 1, 2},
+		accepted: []bool{true, true, false, true},
+	},
+}
+
+func TestAsSecurityDrbReplayWindow(t *testing.T) {
+	kGnb, err := DeriveKgnb(testKAmf, 0)
+	if err != nil {
+		t.Fatalf("derive kgnb: %v", err)
+	}
+
+	for _, testCase := range testDrbReplayWindowCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ueContext, ranContext := newTestAsSecurityContexts(t, kGnb, kGnb, security.AlgCiphering128NEA2, security.AlgIntegrity128NIA2)
+
+			pdus := make(map[uint32][]byte)
+			for count := uint32(0); count <= PDCP_REPLAY_WINDOW+1; count++ {
+				pdu, err := ueContext.Drb.Protect([]byte{0x45, byte(count)})
+				if err != nil {
+					t.Fatalf("protect: %v", err)
+				}
+				pdus[count] = pdu
+			}
+
+			for i, count := range testCase.received {
+				sdu, err := ranContext.Drb.Unprotect(pdus[count])
+				if testCase.accepted[i] {
+					if err != nil || !bytes.Equal(sdu, []byte{0x45, byte(count)}) {
+						t.Errorf("count %d: expected accepted, got %v", count, err)
+					}
+				} else if !errors.Is(err, ErrCountReplayed) {
+					t.Errorf("count %d: expected %v, got %v", count, ErrCountReplayed, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDeriveKsn(t *testing.T) {
+	kGnb, err := DeriveKgnb(testKAmf, 0)
+	if err != nil {
+		t.Fatalf("derive kgnb: %v", err)
+	}
+
+	kSn0, err := DeriveKsn(kGnb, 0)
+	if err != nil {
+		t.Fatalf("derive ksn: %v", err)
+	}
+	kSn1, err := DeriveKsn(kGnb, 1)
+	if err != nil {
+		t.Fatalf("derive ksn: %v", err)
+	}
+	if len(kSn0) != 32 || bytes.Equal(kSn0, kSn1) || bytes.Equal(kSn0, kGnb) {
+		t.Errorf("unexpected ksn: %x, %x", kSn0, kSn1)
+	}
+}
+
+// the expected keys are computed independently with HMAC-SHA-256 over S = FC || P0 || L0 || P1 || L1 as in TS 33.220 B.2
+var testDeriveKgnbCases = []struct {
+	name         string
+	kAmf         string
+	ulNasCount   uint32
+	expectedKgnb string
+}{
+	{
+		name:         "testKgnbCountZero",
+		kAmf:         "b7d2d0bb5a1a2e7e9a3f3c4b1f0e6d8c2a5b7c9d0e1f2a3b4c5d6e7f8091a2b3",
+		ulNasCount:   0,
+		expectedKgnb: "5247164408ae91752bc879e02d43b3db142f2d6c08846a9a0878e1ee8d969a6c",
+	},
+	{
+		name:         "testKgnbCountNonZero",
+		kAmf:         "b7d2d0bb5a1a2e7e9a3f3c4b1f0e6d8c2a5b7c9d0e1f2a3b4c5d6e7f8091a2b3",
+		ulNasCount:   0x01020304,
+		expectedKgnb: "5e826712c51d9d59aeac7e9b481615ff50c6bef598007e50aba2c6d0e497a729",
+	},
+}
+
+func TestDeriveKgnbKnownAnswer(t *testing.T) {
+	for _, testCase := range testDeriveKgnbCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			kAmf, err := hex.DecodeString(testCase.kAmf)
+			if err != nil {
+				t.Fatalf("decode kamf: %v", err)
+			}
+
+			kGnb, err := DeriveKgnb(kAmf, testCase.ulNasCount)
+			if err != nil {
+				t.Fatalf("derive kgnb: %v", err)
+			}
+			if hex.EncodeToString(kGnb) != testCase.expectedKgnb {
+				t.Errorf("expected %s, got %x", testCase.expectedKgnb, kGnb)
+			}
+		})
+	}
+}
+
+var testDeriveAsAlgorithmKeyCases = []struct {
+	name                   string
+	algorithmDistinguisher uint8
+	algorithm              uint8
+	expectedKey            string
+}{
+	{
+		name:                   "testKRrcEncNea1",
+		algorithmDistinguisher: security.NRRCEncAlg,
+		algorithm:              security.AlgCiphering128NEA1,
+		expectedKey:            "9d1f1ae1987df38c41c6db781656cf35",
+	},
+	{
+		name:                   "testKRrcEncNea2",
+		algorithmDistinguisher: security.NRRCEncAlg,
+		algorithm:              security.AlgCiphering128NEA2,
+		expectedKey:            "96fc30c5a5fefeee8c0af02ef2c76766",
+	},
+	{
+		name:                   "testKRrcIntNia1",
+		algorithmDistinguisher: security.NRRCIntAlg,
+		algorithm:              security.AlgIntegrity128NIA1,
+		expectedKey:            "e9a0931e1ae404cc4e73d8a3b259e837",
+	},
+	{
+		name:                   "testKRrcIntNia2",
+		algorithmDistinguisher: security.NRRCIntAlg,
+		algorithm:              security.AlgIntegrity128NIA2,
+		expectedKey:            "ed0333afb51f65afbc8423e0e4c3f21b",
+	},
+	{
+		name:                   "testKUpEncNea1",
+		algorithmDistinguisher: security.NUpEncAlg,
+		algorithm:              security.AlgCiphering128NEA1,
+		expectedKey:            "7a7f438e0462e3b4ab361591982b9a39",
+	},
+	{
+		name:                   "testKUpEncNea2",
+		algorithmDistinguisher: security.NUpEncAlg,
+		algorithm:              security.AlgCiphering128NEA2,
+		expectedKey:            "da4814d5218519b448197ce7cc4c5011",
+	},
+	{
+		name:                   "testKUpIntNia1",
+		algorithmDistinguisher: security.NUpIntAlg,
+		algorithm:              security.AlgIntegrity128NIA1,
+		expectedKey:            "9d1ba867dfcf8a40a8e758f05797ca42",
+	},
+	{
+		name:                   "testKUpIntNia2",
+		algorithmDistinguisher: security.NUpIntAlg,
+		algorithm:              security.AlgIntegrity128NIA2,
+		expectedKey:            "6336cd605666061d0358ac4a73823783",
+	},
+}
+
+func TestDeriveAsAlgorithmKeyKnownAnswer(t *testing.T) {
+	kGnb, err := hex.DecodeString("5247164408ae91752bc879e02d43b3db142f2d6c08846a9a0878e1ee8d969a6c")
+	if err != nil {
+		t.Fatalf("decode kgnb: %v", err)
+	}
+
+	for _, testCase := range testDeriveAsAlgorithmKeyCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			key, err := deriveAsAlgorithmKey(kGnb, testCase.algorithmDistinguisher, testCase.algorithm)
+			if err != nil {
+				t.Fatalf("derive as algorithm key: %v", err)
+			}
+			if hex.EncodeToString(key[:]) != testCase.expectedKey {
+				t.Errorf("expected %s, got %x", testCase.expectedKey, key)
+			}
+		})
+	}
+}
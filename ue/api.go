@@ -0,0 +1,542 @@
+package ue
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	consoleModel "github.com/Alonza0314/free-ran-ue/console/model"
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/logger"
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/nas/nasConvert"
+	"github.com/free5gc/openapi/models"
+	"github.com/gin-gonic/gin"
+)
+
+// apiProcedures are the procedures of the UE driven by the API, the UE itself
+type apiProcedures interface {
+	Register() error
+	Deregister() error
+	ServiceRequest() error
+	EstablishPduSession(pduSessionId uint8) error
+	ReleasePduSession(pduSessionId uint8) error
+}
+
+type api struct {
+	enable bool
+	ip     string
+	port   int
+
+	procedures apiProcedures
+
+	router *gin.Engine
+	server *http.Server
+}
+
+// apiRoute is a route of the UE API, served for the single UE or for each UE of multi UE mode
+type apiRoute struct {
+	name    string
+	method  string
+	pattern string
+	handler func(*Ue, *gin.Context)
+}
+
+var apiRoutes = []apiRoute{
+	{
+		name:    "UE Registration",
+		method:  constant.API_UE_REGISTRATION_GET_METHOD,
+		pattern: constant.API_UE_REGISTRATION,
+		handler: (*Ue).handleUeRegistration,
+	},
+	{
+		name:    "UE Registration Register",
+		method:  constant.API_UE_REGISTRATION_REGISTER_METHOD,
+		pattern: constant.API_UE_REGISTRATION,
+		handler: (*Ue).handleUeRegistrationRegister,
+	},
+	{
+		name:    "UE Deregistration",
+		method:  constant.API_UE_DEREGISTRATION_METHOD,
+		pattern: constant.API_UE_DEREGISTRATION,
+		handler: (*Ue).handleUeDeregistration,
+	},
+	{
+		name:    "UE Service Request",
+		method:  constant.API_UE_SERVICE_REQUEST_METHOD,
+		pattern: constant.API_UE_SERVICE_REQUEST,
+		handler: (*Ue).handleUeServiceRequest,
+	},
+	{
+		name:    "UE PDU Session",
+		method:  constant.API_UE_PDU_SESSION_GET_METHOD,
+		pattern: constant.API_UE_PDU_SESSION,
+		handler: (*Ue).handleUePduSession,
+	},
+	{
+		name:    "UE PDU Session Establish",
+		method:  constant.API_UE_PDU_SESSION_ESTABLISH_METHOD,
+		pattern: constant.API_UE_PDU_SESSION_ESTABLISH,
+		handler: (*Ue).handleUePduSessionEstablish,
+	},
+	{
+		name:    "UE PDU Session Release",
+		method:  constant.API_UE_PDU_SESSION_RELEASE_METHOD,
+		pattern: constant.API_UE_PDU_SESSION_RELEASE,
+		handler: (*Ue).handleUePduSessionRelease,
+	},
+	{
+		name:    "UE NRDC",
+		method:  constant.API_UE_NRDC_GET_METHOD,
+		pattern: constant.API_UE_NRDC,
+		handler: (*Ue).handleUeNrdc,
+	},
+	{
+		name:    "UE NRDC Uplink Leg Modify",
+		method:  constant.API_UE_NRDC_UPLINK_LEG_METHOD,
+		pattern: constant.API_UE_NRDC_UPLINK_LEG,
+		handler: (*Ue).handleUeNrdcUplinkLegModify,
+	},
+	{
+		name:    "UE Counters",
+		method:  constant.API_UE_COUNTERS_GET_METHOD,
+		pattern: constant.API_UE_COUNTERS,
+		handler: (*Ue).handleUeCounters,
+	},
+}
+
+func (a *api) startServer(routes util.Routes, ueLogger *logger.UeLogger) {
+	ueLogger.UeLog.Infoln("Starting API server")
+
+	a.router = util.NewGinRouter(constant.API_PREFIX_UE, routes)
+
+	a.server = &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", a.ip, a.port),
+		Handler: a.router,
+	}
+
+	go func() {
+		if err := a.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			ueLogger.UeLog.Errorf("Failed to start API server: %v", err)
+		}
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+
+	ueLogger.UeLog.Infoln("============= API Info =============")
+	ueLogger.UeLog.Infof("API access address: %s:%d", a.ip, a.port)
+	ueLogger.UeLog.Infoln("====================================")
+
+	ueLogger.UeLog.Infoln("API server started")
+}
+
+func (a *api) stopServer(ueLogger *logger.UeLogger) {
+	ueLogger.UeLog.Infoln("Stopping API server")
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+
+	if err := a.server.Shutdown(shutdownCtx); err != nil {
+		ueLogger.UeLog.Errorf("Failed to stop API server: %v", err)
+	} else {
+		ueLogger.UeLog.Infoln("API server stopped successfully")
+	}
+}
+
+func (u *Ue) startApiServer() {
+	u.api.startServer(u.initApiRoutes(), u.UeLogger)
+}
+
+func (u *Ue) stopApiServer() {
+	u.api.stopServer(u.UeLogger)
+}
+
+func (u *Ue) initApiRoutes() util.Routes {
+	routes := make(util.Routes, 0, len(apiRoutes))
+	for _, route := range apiRoutes {
+		handler := route.handler
+		routes = append(routes, util.Route{
+			Name:        route.name,
+			Method:      route.method,
+			Pattern:     route.pattern,
+			HandlerFunc: func(c *gin.Context) { handler(u, c) },
+		})
+	}
+	return routes
+}
+
+func (m *MultiUe) startApiServer() {
+	m.api.startServer(m.initApiRoutes(), m.UeLogger)
+}
+
+func (m *MultiUe) stopApiServer() {
+	m.api.stopServer(m.UeLogger)
+}
+
+// initApiRoutes serves the UE API of each UE under its IMSI, e.g. /api/ue/imsi-208930000000001/registration
+func (m *MultiUe) initApiRoutes() util.Routes {
+	routes := make(util.Routes, 0, len(apiRoutes))
+	for _, route := range apiRoutes {
+		handler := route.handler
+		routes = append(routes, util.Route{
+			Name:    "Multi " + route.name,
+			Method:  route.method,
+			Pattern: "/:" + constant.API_UE_IMSI_PARAM + route.pattern,
+			HandlerFunc: func(c *gin.Context) {
+				imsi := c.Param(constant.API_UE_IMSI_PARAM)
+				ue, exists := m.imsiToUe[imsi]
+				if !exists {
+					m.UeLog.Warnf("UE %s not found", imsi)
+					c.JSON(http.StatusNotFound, consoleModel.MultiUeNotFoundResponse{
+						Message: fmt.Sprintf("UE %s not found", imsi),
+					})
+					return
+				}
+				handler(ue, c)
+			},
+		})
+	}
+	return routes
+}
+
+func snssaisToApi(nssai []models.Snssai) []consoleModel.SnssaiIE {
+	snssaiList := make([]consoleModel.SnssaiIE, 0, len(nssai))
+	for _, snssai := range nssai {
+		snssaiList = append(snssaiList, consoleModel.SnssaiIE{
+			Sst: strconv.Itoa(int(snssai.Sst)),
+			Sd:  snssai.Sd,
+		})
+	}
+	return snssaiList
+}
+
+// getRegistration is the registration state of the UE read by the API
+func (u *Ue) getRegistration() consoleModel.UeRegistration {
+	guti := ""
+	if guti5G := u.getGuti5G(); guti5G != nil {
+		_, guti = nasConvert.GutiToString(guti5G)
+	}
+
+	rejectedNssai := u.getRejectedNssai()
+	rejectedSnssaiList := make([]consoleModel.UeRejectedSnssai, 0, len(rejectedNssai))
+	for _, rejectedSnssai := range rejectedNssai {
+		rejectedSnssaiList = append(rejectedSnssaiList, consoleModel.UeRejectedSnssai{
+			Sst:   strconv.Itoa(int(rejectedSnssai.Snssai.Sst)),
+			Sd:    rejectedSnssai.Snssai.Sd,
+			Cause: rejectedSnssaiCauseToString(rejectedSnssai.Cause),
+		})
+	}
+
+	u.registrationContextMtx.Lock()
+	allowedNssai := snssaisToApi(u.allowedNssai)
+	configuredNssai := snssaisToApi(u.configuredNssai)
+	u.registrationContextMtx.Unlock()
+
+	u.nasStatusMtx.Lock()
+	registrationAttempts := u.registrationAttempts
+	u.nasStatusMtx.Unlock()
+
+	nrCellIdentity, tac := u.getServingCell()
+	return consoleModel.UeRegistration{
+		Imsi:       "imsi-" + u.supi,
+		Registered: u.registered.Load(),
+		RrcState:   u.getRrcState().String(),
+		NrCellId:   fmt.Sprintf("%09x", nrCellIdentity),
+		Tac:        fmt.Sprintf("%06x", tac),
+		Guti5G:     guti,
+
+		AllowedNssai:    allowedNssai,
+		ConfiguredNssai: configuredNssai,
+		RejectedNssai:   rejectedSnssaiList,
+
+		RegistrationAttempts: registrationAttempts,
+		NasStatus:            u.getNasStatusSummary(),
+	}
+}
+
+// getPduSessions lists the pdu sessions of the config, an established session with the UE IPs and QoS rules of its accept
+func (u *Ue) getPduSessions() []consoleModel.UePduSession {
+	nrdcEnabled := u.isNrdcEnabled()
+
+	u.pduSessionMtx.Lock()
+	defer u.pduSessionMtx.Unlock()
+
+	pduSessions := make([]consoleModel.UePduSession, 0, len(u.pduSessions))
+	for _, session := range u.pduSessions {
+		pduSession := consoleModel.UePduSession{
+			PduSessionId: int(session.id),
+			Type:         pduSessionTypeName(session.pduSessionType),
+			Dnn:          session.dnn,
+			OnDemand:     session.onDemand,
+
+			Established:  session.established,
+			UeIps:        []string{},
+			QosRules:     []string{},
+			TunnelDevice: session.ueTunnelDeviceName,
+		}
+		if session.unstructuredSocket != "" {
+			pduSession.TunnelDevice = session.unstructuredSocket
+		}
+		if session.sNssai != nil {
+			pduSession.Snssai = &consoleModel.SnssaiIE{
+				Sst: strconv.Itoa(int(session.sNssai.Sst)),
+				Sd:  session.sNssai.Sd,
+			}
+		}
+
+		if session.established {
+			accept := session.pduSessionEstablishmentAccept
+			pduSession.Type = pduSessionTypeName(accept.pduSessionType)
+			pduSession.Snssai = &consoleModel.SnssaiIE{
+				Sst: strconv.Itoa(int(accept.sst)),
+				Sd:  fmt.Sprintf("%x", accept.sd),
+			}
+			pduSession.UeIps = accept.getUeIps()
+			if accept.isIp() {
+				pduSession.QosRules = util.GetQosRule(accept.qosRule, u.UeLogger)
+			}
+			pduSession.NrdcSplit = nrdcEnabled && session.id == u.primaryPduSessionId
+		}
+		pduSessions = append(pduSessions, pduSession)
+	}
+	return pduSessions
+}
+
+func (u *Ue) getNrdc() consoleModel.UeNrdc {
+	primaryPduSessionId := u.getPrimaryPduSessionId()
+
+	u.rwLock.RLock()
+	defer u.rwLock.RUnlock()
+
+	return consoleModel.UeNrdc{
+		Enable:              u.nrdc.static,
+		ScgActive:           u.nrdc.enable && u.nrdc.scgDrb != nil,
+		PrimaryPduSessionId: int(primaryPduSessionId),
+		SpecifiedFlows:      append([]string{}, u.nrdc.specifiedFlow...),
+		UplinkLeg:           u.nrdc.uplinkLeg,
+	}
+}
+
+func (u *Ue) getCounters() consoleModel.UeCounters {
+	return consoleModel.UeCounters{
+		UlPackets:    u.ulPackets.Load(),
+		UlBytes:      u.ulBytes.Load(),
+		ScgUlPackets: u.scgUlPackets.Load(),
+		DlPackets:    u.dlPackets.Load(),
+		DlBytes:      u.dlBytes.Load(),
+
+		UserspaceUlPackets: u.userspaceTraffic.ulPackets.Load(),
+		UserspaceDlPackets: u.userspaceDlPackets.Load(),
+	}
+}
+
+func (u *Ue) handleUeRegistration(c *gin.Context) {
+	u.UeLog.Infoln("Handling get ue registration")
+
+	c.JSON(http.StatusOK, consoleModel.UeRegistrationResponse{
+		Message:      "Get UE registration successful",
+		Registration: u.getRegistration(),
+	})
+
+	u.UeLog.Infoln("Get ue registration successful")
+}
+
+func (u *Ue) handleUeRegistrationRegister(c *gin.Context) {
+	u.UeLog.Infoln("Handling ue registration")
+
+	if u.registered.Load() {
+		u.UeLog.Warnln("UE is already registered")
+		c.JSON(http.StatusConflict, consoleModel.UeRegistrationResponse{
+			Message:      "UE is already registered",
+			Registration: u.getRegistration(),
+		})
+		return
+	}
+	if err := u.api.procedures.Register(); err != nil {
+		u.UeLog.Errorf("Error register ue: %v", err)
+		c.JSON(http.StatusInternalServerError, consoleModel.UeRegistrationResponse{
+			Message:      fmt.Sprintf("Error register ue: %v", err),
+			Registration: u.getRegistration(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, consoleModel.UeRegistrationResponse{
+		Message:      "UE registration success",
+		Registration: u.getRegistration(),
+	})
+
+	u.UeLog.Infoln("Ue registration completed")
+}
+
+func (u *Ue) handleUeServiceRequest(c *gin.Context) {
+	u.UeLog.Infoln("Handling ue service request")
+
+	if err := u.api.procedures.ServiceRequest(); err != nil {
+		u.UeLog.Errorf("Error service request: %v", err)
+		c.JSON(http.StatusInternalServerError, consoleModel.UeServiceRequestResponse{
+			Message:      fmt.Sprintf("Error service request: %v", err),
+			Registration: u.getRegistration(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, consoleModel.UeServiceRequestResponse{
+		Message:      "UE service request success",
+		Registration: u.getRegistration(),
+	})
+
+	u.UeLog.Infoln("Ue service request completed")
+}
+
+func (u *Ue) handleUeDeregistration(c *gin.Context) {
+	u.UeLog.Infoln("Handling ue deregistration")
+
+	if err := u.api.procedures.Deregister(); err != nil {
+		u.UeLog.Errorf("Error deregister ue: %v", err)
+		c.JSON(http.StatusInternalServerError, consoleModel.UeDeregistrationResponse{
+			Message: fmt.Sprintf("Error deregister ue: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, consoleModel.UeDeregistrationResponse{
+		Message: "UE deregistration success",
+	})
+
+	u.UeLog.Infoln("Ue deregistration completed")
+}
+
+func (u *Ue) handleUePduSession(c *gin.Context) {
+	u.UeLog.Infoln("Handling get ue pdu session")
+
+	c.JSON(http.StatusOK, consoleModel.UePduSessionResponse{
+		Message:     "Get UE PDU session successful",
+		PduSessions: u.getPduSessions(),
+	})
+
+	u.UeLog.Infoln("Get ue pdu session successful")
+}
+
+// bindPduSessionModifyRequest reads the pdu session of the request, answering a request of no pdu session of the config
+func (u *Ue) bindPduSessionModifyRequest(c *gin.Context) (uint8, bool) {
+	var request consoleModel.UePduSessionModifyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		u.UeLog.Warnf("Error bind ue pdu session modify request: %v", err)
+		c.JSON(http.StatusBadRequest, consoleModel.UePduSessionModifyResponse{
+			Message: fmt.Sprintf("Error bind ue pdu session modify request: %v", err),
+		})
+		return 0, false
+	}
+
+	if request.PduSessionId < constant.PDU_SESSION_ID_MIN || request.PduSessionId > constant.PDU_SESSION_ID_MAX {
+		u.UeLog.Warnf("Invalid pdu session id: %d", request.PduSessionId)
+		c.JSON(http.StatusBadRequest, consoleModel.UePduSessionModifyResponse{
+			Message: fmt.Sprintf("Invalid pdu session id: %d, range should be %d-%d", request.PduSessionId, constant.PDU_SESSION_ID_MIN, constant.PDU_SESSION_ID_MAX),
+		})
+		return 0, false
+	}
+	if _, exists := u.getPduSession(uint8(request.PduSessionId)); !exists {
+		u.UeLog.Warnf("PDU session %d not found", request.PduSessionId)
+		c.JSON(http.StatusNotFound, consoleModel.UePduSessionModifyResponse{
+			Message: fmt.Sprintf("PDU session %d not found", request.PduSessionId),
+		})
+		return 0, false
+	}
+	return uint8(request.PduSessionId), true
+}
+
+func (u *Ue) handleUePduSessionEstablish(c *gin.Context) {
+	u.UeLog.Infoln("Handling ue pdu session establish")
+
+	pduSessionId, ok := u.bindPduSessionModifyRequest(c)
+	if !ok {
+		return
+	}
+	if err := u.api.procedures.EstablishPduSession(pduSessionId); err != nil {
+		u.UeLog.Errorf("Error establish pdu session %d: %v", pduSessionId, err)
+		c.JSON(http.StatusInternalServerError, consoleModel.UePduSessionModifyResponse{
+			Message: fmt.Sprintf("Error establish pdu session %d: %v", pduSessionId, err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, consoleModel.UePduSessionModifyResponse{
+		Message: fmt.Sprintf("PDU session %d establish success", pduSessionId),
+	})
+
+	u.UeLog.Infof("Ue pdu session %d establish completed", pduSessionId)
+}
+
+func (u *Ue) handleUePduSessionRelease(c *gin.Context) {
+	u.UeLog.Infoln("Handling ue pdu session release")
+
+	pduSessionId, ok := u.bindPduSessionModifyRequest(c)
+	if !ok {
+		return
+	}
+	if err := u.api.procedures.ReleasePduSession(pduSessionId); err != nil {
+		u.UeLog.Errorf("Error release pdu session %d: %v", pduSessionId, err)
+		c.JSON(http.StatusInternalServerError, consoleModel.UePduSessionModifyResponse{
+			Message: fmt.Sprintf("Error release pdu session %d: %v", pduSessionId, err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, consoleModel.UePduSessionModifyResponse{
+		Message: fmt.Sprintf("PDU session %d release success", pduSessionId),
+	})
+
+	u.UeLog.Infof("Ue pdu session %d release completed", pduSessionId)
+}
+
+func (u *Ue) handleUeNrdc(c *gin.Context) {
+	u.UeLog.Infoln("Handling get ue nrdc")
+
+	c.JSON(http.StatusOK, consoleModel.UeNrdcResponse{
+		Message: "Get UE NRDC successful",
+		Nrdc:    u.getNrdc(),
+	})
+
+	u.UeLog.Infoln("Get ue nrdc successful")
+}
+
+func (u *Ue) handleUeNrdcUplinkLegModify(c *gin.Context) {
+	u.UeLog.Infoln("Handling ue nrdc uplink leg modify")
+
+	var request consoleModel.UeNrdcUplinkLegModifyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		u.UeLog.Warnf("Error bind ue nrdc uplink leg modify request: %v", err)
+		c.JSON(http.StatusBadRequest, consoleModel.UeNrdcUplinkLegModifyResponse{
+			Message: fmt.Sprintf("Error bind ue nrdc uplink leg modify request: %v", err),
+		})
+		return
+	}
+
+	if err := u.setUplinkLeg(request.UplinkLeg); err != nil {
+		u.UeLog.Warnf("Invalid nrdc uplink leg: %v", err)
+		c.JSON(http.StatusBadRequest, consoleModel.UeNrdcUplinkLegModifyResponse{
+			Message: fmt.Sprintf("Invalid nrdc uplink leg: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, consoleModel.UeNrdcUplinkLegModifyResponse{
+		Message: fmt.Sprintf("NRDC uplink leg modified to %s", request.UplinkLeg),
+	})
+
+	u.UeLog.Infof("Ue nrdc uplink leg modified to %s", request.UplinkLeg)
+}
+
+func (u *Ue) handleUeCounters(c *gin.Context) {
+	u.UeLog.Infoln("Handling get ue counters")
+
+	c.JSON(http.StatusOK, consoleModel.UeCountersResponse{
+		Message:  "Get UE counters successful",
+		Counters: u.getCounters(),
+	})
+
+	u.UeLog.Infoln("Get ue counters successful")
+}
@@ -0,0 +1,294 @@
+package ue
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	consoleModel "github.com/Alonza0314/free-ran-ue/console/model"
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/logger"
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/go-playground/assert"
+)
+
+var testUeApiCases = []struct {
+	name              string
+	pattern           string
+	body              string
+	expectedCode      int
+	expectedUplinkLeg string
+}{
+	{
+		name:              "testUplinkLegMcg",
+		pattern:           constant.API_UE_NRDC_UPLINK_LEG,
+		body:              `{"uplinkLeg": "mcg"}`,
+		expectedCode:      http.StatusOK,
+		expectedUplinkLeg: constant.UE_NRDC_UPLINK_LEG_MCG,
+	},
+	{
+		name:              "testUplinkLegInvalid",
+		pattern:           constant.API_UE_NRDC_UPLINK_LEG,
+		body:              `{"uplinkLeg": "both"}`,
+		expectedCode:      http.StatusBadRequest,
+		expectedUplinkLeg: constant.UE_NRDC_UPLINK_LEG_AUTO,
+	},
+	{
+		name:              "testUplinkLegBadBody",
+		pattern:           constant.API_UE_NRDC_UPLINK_LEG,
+		body:              `{"uplinkLeg": 1}`,
+		expectedCode:      http.StatusBadRequest,
+		expectedUplinkLeg: constant.UE_NRDC_UPLINK_LEG_AUTO,
+	},
+	{
+		name:              "testPduSessionIdOutOfRange",
+		pattern:           constant.API_UE_PDU_SESSION_ESTABLISH,
+		body:              `{"pduSessionId": 16}`,
+		expectedCode:      http.StatusBadRequest,
+		expectedUplinkLeg: constant.UE_NRDC_UPLINK_LEG_AUTO,
+	},
+	{
+		name:              "testPduSessionNotInConfig",
+		pattern:           constant.API_UE_PDU_SESSION_RELEASE,
+		body:              `{"pduSessionId": 2}`,
+		expectedCode:      http.StatusNotFound,
+		expectedUplinkLeg: constant.UE_NRDC_UPLINK_LEG_AUTO,
+	},
+	{
+		name:              "testPduSessionOfUnregisteredUe",
+		pattern:           constant.API_UE_PDU_SESSION_ESTABLISH,
+		body:              `{"pduSessionId": 1}`,
+		expectedCode:      http.StatusInternalServerError,
+		expectedUplinkLeg: constant.UE_NRDC_UPLINK_LEG_AUTO,
+	},
+}
+
+func TestUeApi(t *testing.T) {
+	ueLogger := logger.NewUeLogger("error", "", true)
+
+	for _, testCase := range testUeApiCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ue := &Ue{
+				pduSessions: []*pduSession{{id: 1}},
+				nrdc: nrdc{
+					uplinkLeg: constant.UE_NRDC_UPLINK_LEG_AUTO,
+				},
+				UeLogger: &ueLogger,
+			}
+			ue.api.procedures = ue
+			router := util.NewGinRouter(constant.API_PREFIX_UE, ue.initApiRoutes())
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, string(constant.API_PREFIX_UE)+testCase.pattern, strings.NewReader(testCase.body))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, testCase.expectedCode, w.Code)
+			assert.Equal(t, testCase.expectedUplinkLeg, ue.nrdc.uplinkLeg)
+		})
+	}
+}
+
+// testApiProcedures stands in for the procedures of the UE, which need a gNB and an AMF
+type testApiProcedures struct {
+	called []string
+}
+
+func (p *testApiProcedures) Register() error {
+	p.called = append(p.called, "Register")
+	return nil
+}
+
+func (p *testApiProcedures) Deregister() error {
+	p.called = append(p.called, "Deregister")
+	return nil
+}
+
+func (p *testApiProcedures) ServiceRequest() error {
+	p.called = append(p.called, "ServiceRequest")
+	return nil
+}
+
+func (p *testApiProcedures) EstablishPduSession(pduSessionId uint8) error {
+	p.called = append(p.called, fmt.Sprintf("EstablishPduSession %d", pduSessionId))
+	return nil
+}
+
+func (p *testApiProcedures) ReleasePduSession(pduSessionId uint8) error {
+	p.called = append(p.called, fmt.Sprintf("ReleasePduSession %d", pduSessionId))
+	return nil
+}
+
+func testJsonKeys(object map[string]any) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var testRegistrationKeys = []string{"allowedNssai", "configuredNssai", "guti5G", "imsi", "nasStatus", "nrCellId", "registered", "registrationAttempts", "rejectedNssai", "rrcState", "tac"}
+
+var testUeApiHandlerCases = []struct {
+	name               string
+	method             string
+	pattern            string
+	body               string
+	expectedCalled     []string
+	expectedKeys       []string
+	expectedNestedKey  string
+	expectedNestedKeys []string
+}{
+	{
+		name:               "testGetRegistration",
+		method:             http.MethodGet,
+		pattern:            constant.API_UE_REGISTRATION,
+		expectedKeys:       []string{"message", "registration"},
+		expectedNestedKey:  "registration",
+		expectedNestedKeys: testRegistrationKeys,
+	},
+	{
+		name:               "testRegister",
+		method:             http.MethodPost,
+		pattern:            constant.API_UE_REGISTRATION,
+		expectedCalled:     []string{"Register"},
+		expectedKeys:       []string{"message", "registration"},
+		expectedNestedKey:  "registration",
+		expectedNestedKeys: testRegistrationKeys,
+	},
+	{
+		name:           "testDeregister",
+		method:         http.MethodPost,
+		pattern:        constant.API_UE_DEREGISTRATION,
+		expectedCalled: []string{"Deregister"},
+		expectedKeys:   []string{"message"},
+	},
+	{
+		name:               "testServiceRequest",
+		method:             http.MethodPost,
+		pattern:            constant.API_UE_SERVICE_REQUEST,
+		expectedCalled:     []string{"ServiceRequest"},
+		expectedKeys:       []string{"message", "registration"},
+		expectedNestedKey:  "registration",
+		expectedNestedKeys: testRegistrationKeys,
+	},
+	{
+		name:         "testGetPduSession",
+		method:       http.MethodGet,
+		pattern:      constant.API_UE_PDU_SESSION,
+		expectedKeys: []string{"message", "pduSessions"},
+	},
+	{
+		name:           "testEstablishPduSession",
+		method:         http.MethodPost,
+		pattern:        constant.API_UE_PDU_SESSION_ESTABLISH,
+		body:           `{"pduSessionId": 1}`,
+		expectedCalled: []string{"EstablishPduSession 1"},
+		expectedKeys:   []string{"message"},
+	},
+	{
+		name:           "testReleasePduSession",
+		method:         http.MethodPost,
+		pattern:        constant.API_UE_PDU_SESSION_RELEASE,
+		body:           `{"pduSessionId": 1}`,
+		expectedCalled: []string{"ReleasePduSession 1"},
+		expectedKeys:   []string{"message"},
+	},
+	{
+		name:               "testGetCounters",
+		method:             http.MethodGet,
+		pattern:            constant.API_UE_COUNTERS,
+		expectedKeys:       []string{"counters", "message"},
+		expectedNestedKey:  "counters",
+		expectedNestedKeys: []string{"dlBytes", "dlPackets", "scgUlPackets", "ulBytes", "ulPackets", "userspaceDlPackets", "userspaceUlPackets"},
+	},
+}
+
+func TestUeApiHandlers(t *testing.T) {
+	ueLogger := logger.NewUeLogger("error", "", true)
+
+	for _, testCase := range testUeApiHandlerCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ue := &Ue{
+				authentication: authentication{supi: "208930000000001"},
+				pduSessions:    []*pduSession{{id: 1}},
+				UeLogger:       &ueLogger,
+			}
+			procedures := &testApiProcedures{}
+			ue.api.procedures = procedures
+			router := util.NewGinRouter(constant.API_PREFIX_UE, ue.initApiRoutes())
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(testCase.method, string(constant.API_PREFIX_UE)+testCase.pattern, strings.NewReader(testCase.body))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, testCase.expectedCalled, procedures.called)
+
+			response := map[string]any{}
+			assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, testCase.expectedKeys, testJsonKeys(response))
+			if testCase.expectedNestedKey != "" {
+				nested, ok := response[testCase.expectedNestedKey].(map[string]any)
+				assert.Equal(t, true, ok)
+				assert.Equal(t, testCase.expectedNestedKeys, testJsonKeys(nested))
+			}
+		})
+	}
+}
+
+var testMultiUeApiCases = []struct {
+	name         string
+	imsi         string
+	expectedCode int
+}{
+	{
+		name:         "testUeOfRange",
+		imsi:         "imsi-208930000000002",
+		expectedCode: http.StatusOK,
+	},
+	{
+		name:         "testUeOutOfRange",
+		imsi:         "imsi-208930000000003",
+		expectedCode: http.StatusNotFound,
+	},
+}
+
+func TestMultiUeApi(t *testing.T) {
+	ueLogger := logger.NewUeLogger("error", "", true)
+
+	ues := []*Ue{
+		{authentication: authentication{supi: "208930000000001"}, UeLogger: &ueLogger},
+		{authentication: authentication{supi: "208930000000002"}, UeLogger: &ueLogger},
+	}
+	multiUe := &MultiUe{
+		ues: ues,
+		imsiToUe: map[string]*Ue{
+			"imsi-208930000000001": ues[0],
+			"imsi-208930000000002": ues[1],
+		},
+		UeLogger: &ueLogger,
+	}
+	router := util.NewGinRouter(constant.API_PREFIX_UE, multiUe.initApiRoutes())
+
+	for _, testCase := range testMultiUeApiCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, string(constant.API_PREFIX_UE)+"/"+testCase.imsi+constant.API_UE_REGISTRATION, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, testCase.expectedCode, w.Code)
+			if testCase.expectedCode != http.StatusOK {
+				return
+			}
+
+			response := consoleModel.UeRegistrationResponse{}
+			assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, testCase.imsi, response.Registration.Imsi)
+		})
+	}
+}
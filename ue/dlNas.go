@@ -0,0 +1,326 @@
+package ue
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+)
+
+// handleNasMessage dispatches a downlink nas message received after registration, outside any UE requested procedure,
+// a message the UE does not support is answered with a 5GMM status
+func (u *Ue) handleNasMessage(payload []byte) {
+	// the raw message is kept for a pdu session procedure waiting for it, as decoding deciphers the payload in place
+	raw := make([]byte, len(payload))
+	copy(raw, payload)
+
+	// the security mode command comes with the new security context of the algorithms the network selects
+	var nasPdu *nas.Message
+	var err error
+	if nas.GetSecurityHeaderType(payload) == nas.SecurityHeaderTypeIntegrityProtectedWithNew5gNasSecurityContext {
+		nasPdu, err = u.decodeSecurityModeCommand(payload)
+		var reject *securityModeRejectError
+		if errors.As(err, &reject) {
+			if err := u.sendSecurityModeReject(reject); err != nil {
+				u.NasLog.Errorf("Error answer security mode command: %+v", err)
+			}
+			return
+		}
+	} else {
+		nasPdu, err = nasDecode(u, nas.GetSecurityHeaderType(payload), payload)
+	}
+	if err != nil {
+		if nasPdu == nil {
+			u.NasLog.Warnf("Dropped downlink NAS failing the security check: %+v", err)
+			return
+		}
+		u.NasLog.Warnf("Error decode downlink NAS: %+v", err)
+		u.sendStatus5GMM(nasMessage.Cause5GMMMessageTypeNonExistentOrNotImplemented)
+		return
+	}
+	u.NasLog.Tracef("Downlink NAS: %+v", nasPdu)
+
+	switch nasPdu.GmmHeader.GetMessageType() {
+	case nas.MsgTypeRegistrationAccept, nas.MsgTypeRegistrationReject:
+		u.handleRegistrationNas(nasPdu)
+	case nas.MsgTypeConfigurationUpdateCommand:
+		u.handleConfigurationUpdateCommand(nasPdu.ConfigurationUpdateCommand)
+	case nas.MsgTypeDeregistrationRequestUETerminatedDeregistration:
+		u.handleNetworkDeregistrationRequest(nasPdu.DeregistrationRequestUETerminatedDeregistration)
+	case nas.MsgTypeIdentityRequest:
+		u.handleIdentityRequest(nasPdu.IdentityRequest)
+	case nas.MsgTypeSecurityModeCommand:
+		u.handleSecurityModeCommand()
+	case nas.MsgTypeDLNASTransport:
+		u.handleDlNasTransport(raw, nasPdu)
+	case nas.MsgTypeStatus5GMM:
+		u.NasLog.Warnf("Receive 5GMM Status from RAN, cause: %s", nasMessage.Cause5GMMToString(nasPdu.Status5GMM.GetCauseValue()))
+	default:
+		u.NasLog.Warnf("Unsupported downlink NAS message type: %d", nasPdu.GmmHeader.GetMessageType())
+		u.sendStatus5GMM(nasMessage.Cause5GMMMessageTypeNonExistentOrNotImplemented)
+	}
+}
+
+// handleRegistrationNas hands the registration accept or reject to the registration update waiting for it
+func (u *Ue) handleRegistrationNas(nasPdu *nas.Message) {
+	u.nasWaiterMtx.Lock()
+	defer u.nasWaiterMtx.Unlock()
+
+	if !u.registrationNasWaiting {
+		u.NasLog.Warnf("Dropped registration NAS message type %d, no registration update waiting for it", nasPdu.GmmHeader.GetMessageType())
+		return
+	}
+
+	select {
+	case u.registrationNas <- nasPdu:
+	default:
+		u.NasLog.Warnf("Dropped registration NAS message type %d, a registration NAS is already pending", nasPdu.GmmHeader.GetMessageType())
+	}
+}
+
+// setRegistrationNasWaiting registers the registration update waiting for its accept or reject, a message handed to it
+// and not taken is dropped once it stops waiting
+func (u *Ue) setRegistrationNasWaiting(waiting bool) {
+	u.nasWaiterMtx.Lock()
+	defer u.nasWaiterMtx.Unlock()
+
+	u.registrationNasWaiting = waiting
+	if !waiting {
+		select {
+		case <-u.registrationNas:
+		default:
+		}
+	}
+}
+
+// setPduSessionNasWaiting registers the pdu session procedure waiting for its 5GSM message, a message handed to it
+// and not taken is dropped once it stops waiting
+func (u *Ue) setPduSessionNasWaiting(waiting bool) {
+	u.nasWaiterMtx.Lock()
+	defer u.nasWaiterMtx.Unlock()
+
+	u.pduSessionNasWaiting = waiting
+	if !waiting {
+		select {
+		case <-u.dedicatedNas:
+		default:
+		}
+	}
+}
+
+func (u *Ue) handleConfigurationUpdateCommand(configurationUpdateCommand *nasMessage.ConfigurationUpdateCommand) {
+	u.NasLog.Infoln("Receive Configuration Update Command from RAN")
+
+	if configurationUpdateCommand.GUTI5G != nil {
+		guti5G := configurationUpdateCommand.GUTI5G
+		u.NasLog.Infof("Configuration update 5G-GUTI, AMF region: %d, AMF set: %d, AMF pointer: %d, 5G-TMSI: %x", guti5G.GetAMFRegionID(), guti5G.GetAMFSetID(), guti5G.GetAMFPointer(), guti5G.GetTMSI5G())
+		u.setGuti5G(guti5G.Octet)
+		u.saveState()
+	}
+	if configurationUpdateCommand.FullNameForNetwork != nil {
+		u.NasLog.Infof("Configuration update full network name: %x", configurationUpdateCommand.FullNameForNetwork.GetTextString())
+	}
+
+	// the complete is only sent when the network asks for the acknowledgement
+	if configurationUpdateCommand.ConfigurationUpdateIndication == nil || configurationUpdateCommand.ConfigurationUpdateIndication.GetACK() == 0 {
+		return
+	}
+
+	configurationUpdateComplete, err := getConfigurationUpdateComplete()
+	if err != nil {
+		u.NasLog.Errorf("Error get configuration update complete: %+v", err)
+		return
+	}
+	u.NasLog.Tracef("Configuration update complete: %+v", configurationUpdateComplete)
+
+	if err := u.sendSecuredNas(configurationUpdateComplete); err != nil {
+		u.NasLog.Errorf("Error send configuration update complete: %+v", err)
+		return
+	}
+	u.NasLog.Debugln("Send Configuration Update Complete to RAN")
+}
+
+// handleNetworkDeregistrationRequest accepts the deregistration by the network and tears down the pdu sessions,
+// the UE stays deregistered even if re-registration is required
+func (u *Ue) handleNetworkDeregistrationRequest(deregistrationRequest *nasMessage.DeregistrationRequestUETerminatedDeregistration) {
+	if deregistrationRequest.Cause5GMM != nil {
+		u.NasLog.Infof("Receive Deregistration Request from RAN, cause: %s", nasMessage.Cause5GMMToString(deregistrationRequest.Cause5GMM.GetCauseValue()))
+	} else {
+		u.NasLog.Infoln("Receive Deregistration Request from RAN")
+	}
+
+	deregistrationAccept, err := getDeregistrationAcceptUeTerminated()
+	if err != nil {
+		u.NasLog.Errorf("Error get deregistration accept: %+v", err)
+		return
+	}
+	u.NasLog.Tracef("Deregistration accept: %+v", deregistrationAccept)
+
+	if err := u.sendSecuredNas(deregistrationAccept); err != nil {
+		u.NasLog.Errorf("Error send deregistration accept: %+v", err)
+		return
+	}
+	u.NasLog.Debugln("Send Deregistration Accept to RAN")
+
+	u.registered.Store(false)
+	u.saveState()
+	for _, session := range u.pduSessions {
+		if _, established := u.getUeTunnelDevice(session.id); !established {
+			continue
+		}
+		u.tearDownPduSessionTunnelDevice(session)
+		u.setPduSessionReleased(session)
+	}
+
+	if deregistrationRequest.GetReRegistrationRequired() != 0 {
+		u.UeLog.Warnln("UE deregistered by the network, re-registration required")
+	} else {
+		u.UeLog.Infoln("UE deregistered by the network")
+	}
+}
+
+func (u *Ue) handleIdentityRequest(identityRequest *nasMessage.IdentityRequest) {
+	identityType := identityRequest.GetTypeOfIdentity()
+	u.NasLog.Infof("Receive Identity Request from RAN, identity type: %d", identityType)
+	if identityType != nasMessage.MobileIdentity5GSTypeSuci {
+		u.NasLog.Warnf("Identity type %d is not available, answered with no identity", identityType)
+	}
+
+	mobileIdentity5GS, err := buildUeMobileIdentity5GS(u.mcc, u.mnc, u.msin, &u.suciProfile)
+	if err != nil {
+		u.NasLog.Errorf("Error build ue mobile identity 5gs: %+v", err)
+		return
+	}
+
+	identityResponse, err := getIdentityResponse(identityType, mobileIdentity5GS)
+	if err != nil {
+		u.NasLog.Errorf("Error get identity response: %+v", err)
+		return
+	}
+	u.NasLog.Tracef("Identity response: %+v", identityResponse)
+
+	if err := u.sendSecuredNas(identityResponse); err != nil {
+		u.NasLog.Errorf("Error send identity response: %+v", err)
+		return
+	}
+	u.NasLog.Debugln("Send Identity Response to RAN")
+}
+
+// handleSecurityModeCommand takes the new 5G NAS security context of a security mode command after registration,
+// of the algorithms the network selects, and the counts restart with the security mode complete
+func (u *Ue) handleSecurityModeCommand() {
+	u.NasLog.Infoln("Receive Security Mode Command from RAN")
+
+	nasSecurityModeCompleteMessage, err := getNasSecurityModeCompleteMessage(nil)
+	if err != nil {
+		u.NasLog.Errorf("Error get nas security mode complete message: %+v", err)
+		return
+	}
+	u.NasLog.Tracef("NAS security mode complete message: %+v", nasSecurityModeCompleteMessage)
+
+	encodedNasSecurityModeCompleteMessage, err := encodeNasPduWithSecurity(nasSecurityModeCompleteMessage, nas.SecurityHeaderTypeIntegrityProtectedAndCipheredWithNew5gNasSecurityContext, u, true, true)
+	if err != nil {
+		u.NasLog.Errorf("Error encode nas security mode complete message: %+v", err)
+		return
+	}
+
+	n, err := u.sendToRan(protocol.MESSAGE_TYPE_NAS, encodedNasSecurityModeCompleteMessage)
+	if err != nil {
+		u.NasLog.Errorf("Error send nas security mode complete message: %+v", err)
+		return
+	}
+	u.NasLog.Tracef("Sent %d bytes of NAS Security Mode Complete Message to RAN", n)
+	u.NasLog.Debugln("Send NAS Security Mode Complete Message to RAN")
+
+	u.saveState()
+}
+
+// handleDlNasTransport hands the 5GSM message to the pdu session procedure waiting for it, e.g. a reject,
+// otherwise the message is initiated by the network and handled here
+func (u *Ue) handleDlNasTransport(raw []byte, dlNasTransport *nas.Message) {
+	if u.handToPduSessionProcedure(raw) {
+		return
+	}
+
+	gsmMessage, err := getNasPduFromDlNasTransport(dlNasTransport)
+	if err != nil {
+		u.NasLog.Warnf("Error get 5gsm message from dl nas transport: %+v", err)
+		return
+	}
+	u.NasLog.Tracef("NAS 5GSM message: %+v", gsmMessage)
+
+	switch gsmMessage.GsmHeader.GetMessageType() {
+	case nas.MsgTypePDUSessionReleaseCommand:
+		releaseCommand := gsmMessage.PDUSessionReleaseCommand
+		pduSessionId := releaseCommand.GetPDUSessionID()
+		u.NasLog.Infof("Receive NAS PDU Session Release Command of PDU session %d from RAN, cause: %d", pduSessionId, releaseCommand.GetCauseValue())
+
+		if session, err := u.getEstablishedPduSession(pduSessionId); err == nil {
+			u.tearDownPduSessionTunnelDevice(session)
+			u.setPduSessionReleased(session)
+		}
+
+		pduSessionReleaseComplete, err := getPduSessionReleaseComplete(pduSessionId, releaseCommand.GetPTI())
+		if err != nil {
+			u.NasLog.Errorf("Error get pdu session release complete: %+v", err)
+			return
+		}
+		if err := u.sendPduSessionNas(pduSessionId, pduSessionReleaseComplete); err != nil {
+			u.NasLog.Errorf("Error send pdu session release complete: %+v", err)
+			return
+		}
+		u.PduLog.Infof("UE %s PDU session %d released by the network", u.supi, pduSessionId)
+	case nas.MsgTypeStatus5GSM:
+		u.NasLog.Warnf("Receive 5GSM Status of PDU session %d from RAN, cause: %d", gsmMessage.Status5GSM.GetPDUSessionID(), gsmMessage.Status5GSM.GetCauseValue())
+	default:
+		u.NasLog.Warnf("Unexpected 5GSM message type %d without pdu session procedure", gsmMessage.GsmHeader.GetMessageType())
+	}
+}
+
+// handToPduSessionProcedure hands the DL NAS transport to the pdu session procedure waiting for it,
+// and returns whether one is waiting
+func (u *Ue) handToPduSessionProcedure(raw []byte) bool {
+	u.nasWaiterMtx.Lock()
+	defer u.nasWaiterMtx.Unlock()
+
+	if !u.pduSessionNasWaiting {
+		return false
+	}
+	select {
+	case u.dedicatedNas <- raw:
+	default:
+		u.NasLog.Warnln("Dropped DL NAS transport, the pdu session procedure has a pending message")
+	}
+	return true
+}
+
+// sendSecuredNas sends the plain nas message to RAN integrity protected and ciphered with the current security context
+func (u *Ue) sendSecuredNas(nasPdu []byte) error {
+	encodedNasPdu, err := encodeNasPduWithSecurity(nasPdu, nas.SecurityHeaderTypeIntegrityProtectedAndCiphered, u, true, false)
+	if err != nil {
+		return fmt.Errorf("error encode nas: %+v", err)
+	}
+
+	n, err := u.sendToRan(protocol.MESSAGE_TYPE_NAS, encodedNasPdu)
+	if err != nil {
+		return fmt.Errorf("error send nas: %+v", err)
+	}
+	u.NasLog.Tracef("Sent %d bytes of NAS to RAN", n)
+	return nil
+}
+
+func (u *Ue) sendStatus5GMM(cause uint8) {
+	status5GMM, err := getStatus5GMM(cause)
+	if err != nil {
+		u.NasLog.Errorf("Error get 5GMM status: %+v", err)
+		return
+	}
+
+	if err := u.sendSecuredNas(status5GMM); err != nil {
+		u.NasLog.Errorf("Error send 5GMM status: %+v", err)
+		return
+	}
+	u.NasLog.Debugf("Send 5GMM Status to RAN, cause: %s", nasMessage.Cause5GMMToString(cause))
+}
@@ -0,0 +1,58 @@
+package ue
+
+import (
+	"testing"
+
+	"github.com/Alonza0314/free-ran-ue/logger"
+	"github.com/free5gc/nas"
+	"github.com/go-playground/assert"
+)
+
+var testNasWaiterCases = []struct {
+	name             string
+	waiting          bool
+	expectedHandedTo bool
+}{
+	{
+		name:             "testProcedureWaiting",
+		waiting:          true,
+		expectedHandedTo: true,
+	},
+	{
+		name:             "testNoProcedureWaiting",
+		waiting:          false,
+		expectedHandedTo: false,
+	},
+}
+
+func TestNasWaiter(t *testing.T) {
+	ueLogger := logger.NewUeLogger("error", "", true)
+
+	for _, testCase := range testNasWaiterCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ue := &Ue{
+				UeLogger:     &ueLogger,
+				dedicatedNas: make(chan []byte, 1),
+				registrationContext: registrationContext{
+					registrationNas: make(chan *nas.Message, 1),
+				},
+			}
+			ue.setRegistrationNasWaiting(testCase.waiting)
+			ue.setPduSessionNasWaiting(testCase.waiting)
+
+			registrationAccept := nas.NewMessage()
+			registrationAccept.GmmMessage = nas.NewGmmMessage()
+			registrationAccept.GmmHeader.SetMessageType(nas.MsgTypeRegistrationAccept)
+			ue.handleRegistrationNas(registrationAccept)
+			assert.Equal(t, testCase.expectedHandedTo, len(ue.registrationNas) == 1)
+			assert.Equal(t, testCase.expectedHandedTo, ue.handToPduSessionProcedure([]byte{0x7e, 0x00, 0x68}))
+			assert.Equal(t, testCase.expectedHandedTo, len(ue.dedicatedNas) == 1)
+
+			// a message handed to a procedure and not taken is dropped once it stops waiting
+			ue.setRegistrationNasWaiting(false)
+			ue.setPduSessionNasWaiting(false)
+			assert.Equal(t, 0, len(ue.registrationNas))
+			assert.Equal(t, 0, len(ue.dedicatedNas))
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package ue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/logger"
+	"github.com/Alonza0314/free-ran-ue/model"
+	"github.com/Alonza0314/free-ran-ue/util"
+)
+
+// the name of a network interface is at most IFNAMSIZ - 1 characters on Linux
+const tunnelDeviceNameMaxLength = 15
+
+var errMultiUeNotStarted = errors.New("not started")
+
+type multiUeResult struct {
+	attached   bool
+	err        error
+	attachTime time.Duration
+}
+
+// MultiUe runs a range of UEs in one process, each with its own NAS context and data plane
+type MultiUe struct {
+	ues     []*Ue
+	results []multiUeResult
+
+	attachRate  float64
+	concurrency int
+
+	// the UE API serves each UE under its IMSI
+	api
+	imsiToUe map[string]*Ue
+
+	*logger.UeLogger
+}
+
+func NewMultiUe(config *model.UeConfig, ueLogger *logger.UeLogger) *MultiUe {
+	ueConfigs, err := buildMultiUeConfigs(config)
+	if err != nil {
+		ueLogger.CfgLog.Errorf("Error building multi UE configs: %v", err)
+		return nil
+	}
+
+	ues := make([]*Ue, 0, len(ueConfigs))
+	results := make([]multiUeResult, 0, len(ueConfigs))
+	imsiToUe := make(map[string]*Ue, len(ueConfigs))
+	for i := range ueConfigs {
+		imsi := "imsi-" + ueConfigs[i].Ue.PlmnId.Mcc + ueConfigs[i].Ue.PlmnId.Mnc + ueConfigs[i].Ue.Msin
+		ueLoggerForUe := ueLogger.ForUe(imsi)
+		ue := NewUe(&ueConfigs[i], &ueLoggerForUe)
+		ues = append(ues, ue)
+		results = append(results, multiUeResult{err: errMultiUeNotStarted})
+		imsiToUe[imsi] = ue
+	}
+
+	concurrency := config.MultiUe.Concurrency
+	if concurrency == 0 {
+		concurrency = constant.MULTI_UE_DEFAULT_CONCURRENCY
+	}
+
+	return &MultiUe{
+		ues:     ues,
+		results: results,
+
+		attachRate:  config.MultiUe.AttachRate,
+		concurrency: concurrency,
+
+		api: api{
+			enable: config.Ue.Api.Enable,
+			ip:     config.Ue.Api.Ip,
+			port:   config.Ue.Api.Port,
+
+			router: nil,
+			server: nil,
+		},
+		imsiToUe: imsiToUe,
+
+		UeLogger: ueLogger,
+	}
+}
+
+// Start attaches the UEs at the attach rate with at most concurrency UEs attaching at the same time,
+// it returns once every UE is attached or failed, and fails only if no UE is attached
+func (m *MultiUe) Start(ctx context.Context, wg *sync.WaitGroup) error {
+	m.UeLog.Infof("Starting %d UEs, attach rate: %v/s, concurrency: %d", len(m.ues), m.attachRate, m.concurrency)
+
+	var attachTick <-chan time.Time
+	if m.attachRate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / m.attachRate))
+		defer ticker.Stop()
+		attachTick = ticker.C
+	}
+
+	semaphore := make(chan struct{}, m.concurrency)
+	attachWg := sync.WaitGroup{}
+
+ATTACH_LOOP:
+	for i, ue := range m.ues {
+		if i > 0 && attachTick != nil {
+			select {
+			case <-ctx.Done():
+				break ATTACH_LOOP
+			case <-attachTick:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			break ATTACH_LOOP
+		case semaphore <- struct{}{}:
+		}
+
+		attachWg.Add(1)
+		go func(i int, ue *Ue) {
+			defer attachWg.Done()
+			defer func() { <-semaphore }()
+
+			startTime := time.Now()
+			err := ue.Start(ctx, wg)
+			m.results[i] = multiUeResult{
+				attached:   err == nil,
+				err:        err,
+				attachTime: time.Since(startTime),
+			}
+		}(i, ue)
+	}
+	attachWg.Wait()
+
+	for _, result := range m.results {
+		if result.attached {
+			if m.api.enable {
+				m.startApiServer()
+			}
+			m.UeLog.Infoln("Multi UE started")
+			return nil
+		}
+	}
+	return fmt.Errorf("no UE attached")
+}
+
+// Stop deregisters the attached UEs, at most concurrency at the same time, after logging the userspace traffic of each
+func (m *MultiUe) Stop() {
+	m.UeLog.Infof("Stopping %d UEs", len(m.ues))
+
+	if m.api.enable {
+		m.stopApiServer()
+	}
+
+	for i, ue := range m.ues {
+		if m.results[i].attached && ue.userspaceTraffic.target != nil {
+			m.UeLog.Infof("imsi-%s: userspace traffic to %s, %d UL, %d DL packets", ue.supi, ue.userspaceTraffic.target, ue.userspaceTraffic.ulPackets.Load(), ue.userspaceDlPackets.Load())
+		}
+	}
+
+	semaphore := make(chan struct{}, m.concurrency)
+	stopWg := sync.WaitGroup{}
+	for i, ue := range m.ues {
+		if !m.results[i].attached {
+			continue
+		}
+
+		semaphore <- struct{}{}
+		stopWg.Add(1)
+		go func(ue *Ue) {
+			defer stopWg.Done()
+			defer func() { <-semaphore }()
+
+			ue.Stop()
+		}(ue)
+	}
+	stopWg.Wait()
+
+	m.UeLog.Infoln("Multi UE stopped")
+}
+
+// PrintSummary logs whether each UE is attached, with its attach time and the UE IP of each PDU session, or why it is not
+// with the registration attempts, reject causes and back-off timers of the UE
+func (m *MultiUe) PrintSummary() {
+	attached, failed, notStarted := 0, 0, 0
+
+	m.UeLog.Infoln("========= Multi UE Summary =========")
+	for i, ue := range m.ues {
+		result := m.results[i]
+		switch {
+		case result.attached:
+			attached++
+			m.UeLog.Infof("imsi-%s: attached in %v, UE IP of PDU sessions: %s", ue.supi, result.attachTime.Round(time.Millisecond), ue.getPduSessionSummary())
+		case errors.Is(result.err, errMultiUeNotStarted):
+			notStarted++
+			m.UeLog.Warnf("imsi-%s: %v", ue.supi, result.err)
+		default:
+			failed++
+			m.UeLog.Errorf("imsi-%s: failed in %v, %v, %s", ue.supi, result.attachTime.Round(time.Millisecond), result.err, ue.getNasStatusSummary())
+		}
+	}
+	m.UeLog.Infof("%d UEs: %d attached, %d failed, %d not started", len(m.ues), attached, failed, notStarted)
+	m.UeLog.Infoln("====================================")
+}
+
+// buildMultiUeConfigs derives the config of each UE of multi UE mode from the ue section,
+// the key of a UE comes from the key file if one is given, or else from the key derivation
+func buildMultiUeConfigs(config *model.UeConfig) ([]model.UeConfig, error) {
+	multiUe := config.MultiUe
+
+	startMsin := config.Ue.Msin
+	if multiUe.StartMsin != "" {
+		startMsin = multiUe.StartMsin
+	}
+	msin, err := strconv.ParseUint(startMsin, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("error parse start msin %s: %v", startMsin, err)
+	}
+
+	keys := make(map[string]model.MultiUeKeyIE)
+	if multiUe.KeyFile != "" {
+		keyList := []model.MultiUeKeyIE{}
+		if err := util.LoadFromYaml(multiUe.KeyFile, &keyList); err != nil {
+			return nil, fmt.Errorf("error load key file %s: %v", multiUe.KeyFile, err)
+		}
+		for _, key := range keyList {
+			keys[key.Msin] = key
+		}
+	}
+
+	ueConfigs := make([]model.UeConfig, 0, multiUe.Count)
+	for i := 0; i < multiUe.Count; i++ {
+		ueConfig := *config
+		ueConfig.Ue.Msin = fmt.Sprintf("%0*d", len(startMsin), msin+uint64(i))
+
+		authenticationSubscription := &ueConfig.Ue.AuthenticationSubscription
+		switch {
+		case multiUe.KeyFile != "":
+			key, exists := keys[ueConfig.Ue.Msin]
+			if !exists {
+				return nil, fmt.Errorf("no key of msin %s in key file %s", ueConfig.Ue.Msin, multiUe.KeyFile)
+			}
+			authenticationSubscription.EncPermanentKey = key.EncPermanentKey
+			authenticationSubscription.EncOpcKey = key.EncOpcKey
+			if key.SequenceNumber != "" {
+				authenticationSubscription.SequenceNumber = key.SequenceNumber
+			}
+		case multiUe.KeyDerivation == constant.MULTI_UE_KEY_DERIVATION_INCREMENT:
+			if authenticationSubscription.EncPermanentKey, err = incrementHexString(authenticationSubscription.EncPermanentKey, i); err != nil {
+				return nil, fmt.Errorf("error derive enc permanent key of msin %s: %v", ueConfig.Ue.Msin, err)
+			}
+			if authenticationSubscription.EncOpcKey, err = incrementHexString(authenticationSubscription.EncOpcKey, i); err != nil {
+				return nil, fmt.Errorf("error derive enc opc key of msin %s: %v", ueConfig.Ue.Msin, err)
+			}
+		}
+		if err := util.ValidateAuthenticationSubscription(authenticationSubscription); err != nil {
+			return nil, fmt.Errorf("invalid key of msin %s: %v", ueConfig.Ue.Msin, err)
+		}
+
+		// the UE API is served once for all UEs by the multi UE
+		ueConfig.Ue.Api.Enable = false
+
+		// the pdu sessions are copied so that each UE has its own tunnel devices and unstructured sockets
+		ueConfig.Ue.PduSessions = append([]model.PduSessionIE(nil), config.Ue.PduSessions...)
+		if multiUe.DataPlane == constant.MULTI_UE_DATA_PLANE_USERSPACE {
+			ueConfig.Ue.UeTunnelDevice = ""
+			for j := range ueConfig.Ue.PduSessions {
+				ueConfig.Ue.PduSessions[j].UeTunnelDevice = ""
+				ueConfig.Ue.PduSessions[j].UnstructuredSocket = ""
+			}
+		} else {
+			if ueConfig.Ue.UeTunnelDevice, err = nthTunnelDeviceName(config.Ue.UeTunnelDevice, i); err != nil {
+				return nil, err
+			}
+			for j := range ueConfig.Ue.PduSessions {
+				if ueConfig.Ue.PduSessions[j].UnstructuredSocket != "" {
+					if ueConfig.Ue.PduSessions[j].UnstructuredSocket, err = nthUnstructuredSocket(config.Ue.PduSessions[j].UnstructuredSocket, i); err != nil {
+						return nil, err
+					}
+				}
+				if ueConfig.Ue.PduSessions[j].UeTunnelDevice == "" {
+					continue
+				}
+				if ueConfig.Ue.PduSessions[j].UeTunnelDevice, err = nthTunnelDeviceName(config.Ue.PduSessions[j].UeTunnelDevice, i); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		ueConfigs = append(ueConfigs, ueConfig)
+	}
+
+	return ueConfigs, nil
+}
+
+// incrementHexString adds n to the hex string as an unsigned integer of the same length, wrapping around on overflow
+func incrementHexString(hexString string, n int) (string, error) {
+	value, ok := new(big.Int).SetString(hexString, 16)
+	if !ok {
+		return "", fmt.Errorf("invalid hex string: %s", hexString)
+	}
+
+	modulus := new(big.Int).Lsh(big.NewInt(1), uint(4*len(hexString)))
+	value.Add(value, big.NewInt(int64(n)))
+	value.Mod(value, modulus)
+
+	return fmt.Sprintf("%0*x", len(hexString), value), nil
+}
+
+// nthTunnelDeviceName counts n devices on from the name, e.g. ueTun0 -> ueTun3, a name without a number gets one appended
+func nthTunnelDeviceName(name string, n int) (string, error) {
+	prefix := strings.TrimRight(name, "0123456789")
+	number := 0
+	if prefix != name {
+		var err error
+		if number, err = strconv.Atoi(name[len(prefix):]); err != nil {
+			return "", fmt.Errorf("error parse tunnel device number of %s: %v", name, err)
+		}
+	}
+
+	deviceName := prefix + strconv.Itoa(number+n)
+	if len(deviceName) > tunnelDeviceNameMaxLength {
+		return "", fmt.Errorf("tunnel device name %s exceeds %d characters", deviceName, tunnelDeviceNameMaxLength)
+	}
+	return deviceName, nil
+}
+
+// nthUnstructuredSocket counts n ports on from the port of the unstructured socket, e.g. 127.0.0.1:9000 -> 127.0.0.1:9003
+func nthUnstructuredSocket(address string, n int) (string, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("error split unstructured socket %s: %v", address, err)
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return "", fmt.Errorf("error parse unstructured socket port of %s: %v", address, err)
+	}
+
+	if port+n > 65535 {
+		return "", fmt.Errorf("unstructured socket port of %s exceeds 65535 for UE %d", address, n)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port+n)), nil
+}
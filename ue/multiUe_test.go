@@ -0,0 +1,151 @@
+package ue
+
+import (
+	"testing"
+
+	"github.com/Alonza0314/free-ran-ue/model"
+	"github.com/go-playground/assert"
+)
+
+var testMultiUeBaseIe = model.UeIE{
+	Msin: "0000000001",
+	AuthenticationSubscription: model.AuthenticationSubscriptionIE{
+		EncPermanentKey:               "8baf473f2f8fd09487cccbd7097c6862",
+		EncOpcKey:                     "8e27b6af0e692e750f32667a3b14605d",
+		AuthenticationManagementField: "8000",
+		SequenceNumber:                "000000000023",
+	},
+	PduSessions: []model.PduSessionIE{
+		{
+			Id:  1,
+			Dnn: "internet",
+		},
+		{
+			Id:             2,
+			Dnn:            "ims",
+			UeTunnelDevice: "imsTun0",
+		},
+	},
+	UeTunnelDevice: "ueTun0",
+}
+
+var testBuildMultiUeConfigsCases = []struct {
+	name                    string
+	multiUe                 model.MultiUeIE
+	expectedMsins           []string
+	expectedEncPermanentKey []string
+	expectedTunnelDevices   []string
+
+	expectedPduSessionTunnelDevices [][]string
+}{
+	{
+		name: "testSharedKeyWithTun",
+		multiUe: model.MultiUeIE{
+			Enable: true,
+			Count:  3,
+		},
+		expectedMsins:           []string{"0000000001", "0000000002", "0000000003"},
+		expectedEncPermanentKey: []string{"8baf473f2f8fd09487cccbd7097c6862", "8baf473f2f8fd09487cccbd7097c6862", "8baf473f2f8fd09487cccbd7097c6862"},
+		expectedTunnelDevices:   []string{"ueTun0", "ueTun1", "ueTun2"},
+
+		expectedPduSessionTunnelDevices: [][]string{{"", "imsTun0"}, {"", "imsTun1"}, {"", "imsTun2"}},
+	},
+	{
+		name: "testIncrementKeyWithUserspace",
+		multiUe: model.MultiUeIE{
+			Enable:        true,
+			StartMsin:     "0000000099",
+			Count:         2,
+			KeyDerivation: "increment",
+			DataPlane:     "userspace",
+		},
+		expectedMsins:           []string{"0000000099", "0000000100"},
+		expectedEncPermanentKey: []string{"8baf473f2f8fd09487cccbd7097c6862", "8baf473f2f8fd09487cccbd7097c6863"},
+		expectedTunnelDevices:   []string{"", ""},
+
+		expectedPduSessionTunnelDevices: [][]string{{"", ""}, {"", ""}},
+	},
+}
+
+func TestBuildMultiUeConfigs(t *testing.T) {
+	for _, testCase := range testBuildMultiUeConfigsCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ueConfigs, err := buildMultiUeConfigs(&model.UeConfig{
+				Ue:      testMultiUeBaseIe,
+				MultiUe: testCase.multiUe,
+			})
+			assert.Equal(t, nil, err)
+			assert.Equal(t, len(testCase.expectedMsins), len(ueConfigs))
+			assert.Equal(t, "imsTun0", testMultiUeBaseIe.PduSessions[1].UeTunnelDevice)
+			for i, ueConfig := range ueConfigs {
+				assert.Equal(t, testCase.expectedMsins[i], ueConfig.Ue.Msin)
+				assert.Equal(t, testCase.expectedEncPermanentKey[i], ueConfig.Ue.AuthenticationSubscription.EncPermanentKey)
+				assert.Equal(t, testCase.expectedTunnelDevices[i], ueConfig.Ue.UeTunnelDevice)
+				for j, pduSession := range ueConfig.Ue.PduSessions {
+					assert.Equal(t, testCase.expectedPduSessionTunnelDevices[i][j], pduSession.UeTunnelDevice)
+				}
+			}
+		})
+	}
+}
+
+var testIncrementHexStringCases = []struct {
+	name      string
+	hexString string
+	n         int
+	expected  string
+}{
+	{
+		name:      "testIncrement",
+		hexString: "00ff",
+		n:         1,
+		expected:  "0100",
+	},
+	{
+		name:      "testIncrementWrapAround",
+		hexString: "ffff",
+		n:         2,
+		expected:  "0001",
+	},
+}
+
+func TestIncrementHexString(t *testing.T) {
+	for _, testCase := range testIncrementHexStringCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := incrementHexString(testCase.hexString, testCase.n)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, testCase.expected, result)
+		})
+	}
+}
+
+var testNthUnstructuredSocketCases = []struct {
+	name          string
+	address       string
+	n             int
+	expected      string
+	expectedError bool
+}{
+	{
+		name:     "testNthUnstructuredSocket",
+		address:  "127.0.0.1:9000",
+		n:        3,
+		expected: "127.0.0.1:9003",
+	},
+	{
+		name:          "testUnstructuredSocketPortOverflow",
+		address:       "127.0.0.1:65535",
+		n:             1,
+		expectedError: true,
+	},
+}
+
+func TestNthUnstructuredSocket(t *testing.T) {
+	for _, testCase := range testNthUnstructuredSocketCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := nthUnstructuredSocket(testCase.address, testCase.n)
+			assert.Equal(t, testCase.expectedError, err != nil)
+			assert.Equal(t, testCase.expected, result)
+		})
+	}
+}
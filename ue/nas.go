@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
 	"reflect"
 
 	"github.com/Alonza0314/free-ran-ue/util"
@@ -25,7 +26,12 @@ func nasDecode(ue *Ue, securityHeaderType uint8, payload []byte) (*nas.Message,
 	msg.SecurityHeaderType = uint8(nas.GetSecurityHeaderType(payload) & 0x0f)
 	if securityHeaderType == nas.SecurityHeaderTypePlainNas {
 		return msg, msg.PlainNasDecode(&payload)
-	} else if ue.integrityAlgorithm == security.AlgIntegrity128NIA0 {
+	}
+
+	ue.securityContextMtx.Lock()
+	defer ue.securityContextMtx.Unlock()
+
+	if ue.integrityAlgorithm == security.AlgIntegrity128NIA0 {
 		payload = payload[3:]
 		if err := security.NASEncrypt(ue.cipheringAlgorithm, ue.kNasEnc, ue.dlCount.Get(), ue.getBearerType(), security.DirectionDownlink, payload); err != nil {
 			return nil, err
@@ -86,6 +92,20 @@ func nasEncode(nasMessage *nas.Message, securityContextAvailable bool, newSecuri
 		return nasMessage.PlainNasEncode()
 	}
 
+	payload, err := nasEncodeWithSecurityContext(nasMessage, newSecurityContext, ue)
+	if err != nil {
+		return nil, err
+	}
+
+	// the uplink NAS COUNT is persisted before the message leaves, so that a restarted UE never reuses a COUNT
+	ue.saveState()
+	return payload, nil
+}
+
+func nasEncodeWithSecurityContext(nasMessage *nas.Message, newSecurityContext bool, ue *Ue) ([]byte, error) {
+	ue.securityContextMtx.Lock()
+	defer ue.securityContextMtx.Unlock()
+
 	if newSecurityContext {
 		ue.ulCount.Set(0, 0)
 		ue.dlCount.Set(0, 0)
@@ -113,50 +133,115 @@ func nasEncode(nasMessage *nas.Message, securityContextAvailable bool, newSecuri
 	msgSecurityHeader := []byte{nasMessage.SecurityHeader.ProtocolDiscriminator, nasMessage.SecurityHeader.SecurityHeaderType}
 	payload = append(msgSecurityHeader, payload[:]...)
 
+	// each protected uplink message takes a new count, KgNB is derived from the count of security mode complete
+	ue.ulCount.AddOne()
+
 	return payload, nil
 }
 
-func buildUeMobileIdentity5GS(supi string) nasType.MobileIdentity5GS {
-	supiBytes := util.SupiToBytes(supi)
+// cipherNasMessageContainer ciphers the value of the NAS message container of an initial NAS message with the uplink COUNT
+// the message carrying it is protected with next, as the AMF deciphers it with the COUNT of the message
+func cipherNasMessageContainer(nasMessageContainer []byte, ue *Ue) ([]byte, error) {
+	ue.securityContextMtx.Lock()
+	defer ue.securityContextMtx.Unlock()
+
+	ciphered := append([]byte{}, nasMessageContainer...)
+	if err := security.NASEncrypt(ue.cipheringAlgorithm, ue.kNasEnc, ue.ulCount.Get(), ue.getBearerType(), security.DirectionUplink, ciphered); err != nil {
+		return nil, err
+	}
+	return ciphered, nil
+}
+
+// buildUeMobileIdentity5GS builds the SUCI of the UE, concealed again with a fresh ephemeral key on each call for profile A or B
+func buildUeMobileIdentity5GS(mcc, mnc, msin string, suciProfile *util.SuciProfile) (nasType.MobileIdentity5GS, error) {
+	suci, err := util.SupiToSuci(mcc, mnc, msin, suciProfile)
+	if err != nil {
+		return nasType.MobileIdentity5GS{}, err
+	}
+	return nasType.MobileIdentity5GS{
+		Len:    uint16(len(suci)),
+		Buffer: suci,
+	}, nil
+}
+
+// buildGutiMobileIdentity5GS builds the 5GS mobile identity of the 5G-GUTI, laid out as the 5G-GUTI of the registration accept
+func buildGutiMobileIdentity5GS(guti5G []byte) nasType.MobileIdentity5GS {
 	return nasType.MobileIdentity5GS{
-		Len:    uint16(len(supiBytes)),
-		Buffer: supiBytes,
+		Len:    uint16(len(guti5G)),
+		Buffer: append([]byte{}, guti5G...),
 	}
 }
 
-func buildUeSecurityCapability(cipheringAlgorithm uint8, integrityAlgorithm uint8) nasType.UESecurityCapability {
+// buildTmsi5GS builds the 5G-S-TMSI of the 5G-GUTI, its AMF set id, AMF pointer and 5G-TMSI, which identifies the UE in a service request
+func buildTmsi5GS(guti5G []byte) nasType.TMSI5GS {
+	tmsi5GS := nasType.TMSI5GS{Len: 7}
+	tmsi5GS.Octet[0] = 0xf0 | nasMessage.MobileIdentity5GSType5gSTmsi
+	copy(tmsi5GS.Octet[1:], guti5G[5:11])
+	return tmsi5GS
+}
+
+// buildUeSecurityCapability advertises all the enabled algorithms, the EPS algorithms of the same number as the 5G ones,
+// as the 128-bit EEA and EIA are the NEA and NIA of TS 33.501 5.11.1
+func buildUeSecurityCapability(cipheringAlgorithms []uint8, integrityAlgorithms []uint8) nasType.UESecurityCapability {
 	ueSecurityCapability := nasType.UESecurityCapability{
 		Iei:    nasMessage.RegistrationRequestUESecurityCapabilityType,
-		Len:    2,
-		Buffer: []byte{0x00, 0x00},
+		Len:    4,
+		Buffer: []byte{0x00, 0x00, 0x00, 0x00},
 	}
 
-	switch cipheringAlgorithm {
-	case security.AlgCiphering128NEA0:
-		ueSecurityCapability.SetEA0_5G(1)
-	case security.AlgCiphering128NEA1:
-		ueSecurityCapability.SetEA1_128_5G(1)
-	case security.AlgCiphering128NEA2:
-		ueSecurityCapability.SetEA2_128_5G(1)
-	case security.AlgCiphering128NEA3:
-		ueSecurityCapability.SetEA3_128_5G(1)
+	for _, cipheringAlgorithm := range cipheringAlgorithms {
+		switch cipheringAlgorithm {
+		case security.AlgCiphering128NEA0:
+			ueSecurityCapability.SetEA0_5G(1)
+			ueSecurityCapability.SetEEA0(1)
+		case security.AlgCiphering128NEA1:
+			ueSecurityCapability.SetEA1_128_5G(1)
+			ueSecurityCapability.SetEEA1_128(1)
+		case security.AlgCiphering128NEA2:
+			ueSecurityCapability.SetEA2_128_5G(1)
+			ueSecurityCapability.SetEEA2_128(1)
+		case security.AlgCiphering128NEA3:
+			ueSecurityCapability.SetEA3_128_5G(1)
+			ueSecurityCapability.SetEEA3_128(1)
+		}
 	}
 
-	switch integrityAlgorithm {
-	case security.AlgIntegrity128NIA0:
-		ueSecurityCapability.SetIA0_5G(1)
-	case security.AlgIntegrity128NIA1:
-		ueSecurityCapability.SetIA1_128_5G(1)
-	case security.AlgIntegrity128NIA2:
-		ueSecurityCapability.SetIA2_128_5G(1)
-	case security.AlgIntegrity128NIA3:
-		ueSecurityCapability.SetIA3_128_5G(1)
+	for _, integrityAlgorithm := range integrityAlgorithms {
+		switch integrityAlgorithm {
+		case security.AlgIntegrity128NIA0:
+			ueSecurityCapability.SetIA0_5G(1)
+			ueSecurityCapability.SetEIA0(1)
+		case security.AlgIntegrity128NIA1:
+			ueSecurityCapability.SetIA1_128_5G(1)
+			ueSecurityCapability.SetEIA1_128(1)
+		case security.AlgIntegrity128NIA2:
+			ueSecurityCapability.SetIA2_128_5G(1)
+			ueSecurityCapability.SetEIA2_128(1)
+		case security.AlgIntegrity128NIA3:
+			ueSecurityCapability.SetIA3_128_5G(1)
+			ueSecurityCapability.SetEIA3_128(1)
+		}
 	}
 
 	return ueSecurityCapability
 }
 
-func buildUeRegistrationRequest(registrationType uint8, mobileIdentity5GS *nasType.MobileIdentity5GS, requestedNSSAI *nasType.RequestedNSSAI, ueSecurityCapability *nasType.UESecurityCapability, capability5GMM *nasType.Capability5GMM, nasMessageContainer []uint8, uplinkDataStatus *nasType.UplinkDataStatus) ([]byte, error) {
+// buildRequestedNssai builds the requested NSSAI of the S-NSSAIs, nil without S-NSSAI as the IE is left out then
+func buildRequestedNssai(nssai []models.Snssai) *nasType.RequestedNSSAI {
+	if len(nssai) == 0 {
+		return nil
+	}
+
+	requestedNssai := nasType.NewRequestedNSSAI(nasMessage.RegistrationRequestRequestedNSSAIType)
+	value := util.NssaiToNas(nssai)
+	requestedNssai.SetLen(uint8(len(value)))
+	requestedNssai.SetSNSSAIValue(value)
+	return requestedNssai
+}
+
+// buildUeRegistrationRequest builds the registration request with the ngKSI of the current security context,
+// the no key available ngKSI makes the network authenticate the UE
+func buildUeRegistrationRequest(registrationType uint8, ngKsi uint8, mobileIdentity5GS *nasType.MobileIdentity5GS, requestedNSSAI *nasType.RequestedNSSAI, ueSecurityCapability *nasType.UESecurityCapability, capability5GMM *nasType.Capability5GMM, nasMessageContainer []uint8, uplinkDataStatus *nasType.UplinkDataStatus) ([]byte, error) {
 	m := nas.NewMessage()
 	m.GmmMessage = nas.NewGmmMessage()
 	m.GmmHeader.SetMessageType(nas.MsgTypeRegistrationRequest)
@@ -167,7 +252,7 @@ func buildUeRegistrationRequest(registrationType uint8, mobileIdentity5GS *nasTy
 	registrationRequest.SpareHalfOctetAndSecurityHeaderType.SetSpareHalfOctet(0x00)
 	registrationRequest.RegistrationRequestMessageIdentity.SetMessageType(nas.MsgTypeRegistrationRequest)
 	registrationRequest.NgksiAndRegistrationType5GS.SetTSC(nasMessage.TypeOfSecurityContextFlagNative)
-	registrationRequest.NgksiAndRegistrationType5GS.SetNasKeySetIdentifiler(0x7)
+	registrationRequest.NgksiAndRegistrationType5GS.SetNasKeySetIdentifiler(ngKsi)
 	registrationRequest.NgksiAndRegistrationType5GS.SetFOR(1)
 	registrationRequest.NgksiAndRegistrationType5GS.SetRegistrationType5GS(registrationType)
 	registrationRequest.MobileIdentity5GS = *mobileIdentity5GS
@@ -194,11 +279,60 @@ func buildUeRegistrationRequest(registrationType uint8, mobileIdentity5GS *nasTy
 	return request.Bytes(), nil
 }
 
-func getUeRegistrationRequest(registrationType uint8, mobileIdentity5GS *nasType.MobileIdentity5GS, requestedNSSAI *nasType.RequestedNSSAI, ueSecurityCapability *nasType.UESecurityCapability, capability5GMM *nasType.Capability5GMM, nasMessageContainer []uint8, uplinkDataStatus *nasType.UplinkDataStatus) ([]byte, error) {
-	return buildUeRegistrationRequest(registrationType, mobileIdentity5GS, requestedNSSAI, ueSecurityCapability, capability5GMM, nasMessageContainer, uplinkDataStatus)
+func getUeRegistrationRequest(registrationType uint8, ngKsi uint8, mobileIdentity5GS *nasType.MobileIdentity5GS, requestedNSSAI *nasType.RequestedNSSAI, ueSecurityCapability *nasType.UESecurityCapability, capability5GMM *nasType.Capability5GMM, nasMessageContainer []uint8, uplinkDataStatus *nasType.UplinkDataStatus) ([]byte, error) {
+	return buildUeRegistrationRequest(registrationType, ngKsi, mobileIdentity5GS, requestedNSSAI, ueSecurityCapability, capability5GMM, nasMessageContainer, uplinkDataStatus)
 }
 
-func buildAuthenticationResponse(authenticationResponseParam []byte) ([]byte, error) {
+// buildServiceRequest builds the service request of the 5G-S-TMSI with the ngKSI of the native security context, the uplink data
+// status and the pdu session status list the pdu sessions whose user plane is reactivated and the ones the UE keeps
+func buildServiceRequest(serviceType uint8, ngKsi uint8, tmsi5GS nasType.TMSI5GS, uplinkDataStatus []uint8, pduSessionStatus []uint8, nasMessageContainer []uint8) ([]byte, error) {
+	m := nas.NewMessage()
+	m.GmmMessage = nas.NewGmmMessage()
+	m.GmmHeader.SetMessageType(nas.MsgTypeServiceRequest)
+
+	serviceRequest := nasMessage.NewServiceRequest(0)
+	serviceRequest.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSMobilityManagementMessage)
+	serviceRequest.SpareHalfOctetAndSecurityHeaderType.SetSecurityHeaderType(nas.SecurityHeaderTypePlainNas)
+	serviceRequest.SpareHalfOctetAndSecurityHeaderType.SetSpareHalfOctet(0x00)
+	serviceRequest.ServiceRequestMessageIdentity.SetMessageType(nas.MsgTypeServiceRequest)
+	serviceRequest.ServiceTypeAndNgksi.SetTSC(nasMessage.TypeOfSecurityContextFlagNative)
+	serviceRequest.ServiceTypeAndNgksi.SetNasKeySetIdentifiler(ngKsi)
+	serviceRequest.ServiceTypeAndNgksi.SetServiceTypeValue(serviceType)
+	serviceRequest.TMSI5GS = tmsi5GS
+
+	if uplinkDataStatus != nil {
+		serviceRequest.UplinkDataStatus = nasType.NewUplinkDataStatus(nasMessage.ServiceRequestUplinkDataStatusType)
+		serviceRequest.UplinkDataStatus.SetLen(uint8(len(uplinkDataStatus)))
+		serviceRequest.UplinkDataStatus.Buffer = uplinkDataStatus
+	}
+	if pduSessionStatus != nil {
+		serviceRequest.PDUSessionStatus = nasType.NewPDUSessionStatus(nasMessage.ServiceRequestPDUSessionStatusType)
+		serviceRequest.PDUSessionStatus.SetLen(uint8(len(pduSessionStatus)))
+		serviceRequest.PDUSessionStatus.Buffer = pduSessionStatus
+	}
+
+	if nasMessageContainer != nil {
+		serviceRequest.NASMessageContainer = nasType.NewNASMessageContainer(nasMessage.ServiceRequestNASMessageContainerType)
+		serviceRequest.NASMessageContainer.SetLen(uint16(len(nasMessageContainer)))
+		serviceRequest.NASMessageContainer.SetNASMessageContainerContents(nasMessageContainer)
+	}
+
+	m.GmmMessage.ServiceRequest = serviceRequest
+
+	request := new(bytes.Buffer)
+	if err := m.GmmMessageEncode(request); err != nil {
+		return nil, err
+	}
+
+	return request.Bytes(), nil
+}
+
+func getServiceRequest(serviceType uint8, ngKsi uint8, tmsi5GS nasType.TMSI5GS, uplinkDataStatus []uint8, pduSessionStatus []uint8, nasMessageContainer []uint8) ([]byte, error) {
+	return buildServiceRequest(serviceType, ngKsi, tmsi5GS, uplinkDataStatus, pduSessionStatus, nasMessageContainer)
+}
+
+// buildAuthenticationResponse builds the authentication response of RES* for 5G AKA or of the EAP-Response for EAP-AKA'
+func buildAuthenticationResponse(authenticationResponseParam []byte, eapMessage []byte) ([]byte, error) {
 	m := nas.NewMessage()
 	m.GmmMessage = nas.NewGmmMessage()
 	m.GmmHeader.SetMessageType(nas.MsgTypeAuthenticationResponse)
@@ -217,6 +351,12 @@ func buildAuthenticationResponse(authenticationResponseParam []byte) ([]byte, er
 		copy(authenticationResponse.AuthenticationResponseParameter.Octet[:], authenticationResponseParam[0:16])
 	}
 
+	if len(eapMessage) > 0 {
+		authenticationResponse.EAPMessage = nasType.NewEAPMessage(nasMessage.AuthenticationResponseEAPMessageType)
+		authenticationResponse.EAPMessage.SetLen(uint16(len(eapMessage)))
+		authenticationResponse.EAPMessage.SetEAPMessage(eapMessage)
+	}
+
 	m.GmmMessage.AuthenticationResponse = authenticationResponse
 
 	response := new(bytes.Buffer)
@@ -227,8 +367,45 @@ func buildAuthenticationResponse(authenticationResponseParam []byte) ([]byte, er
 	return response.Bytes(), nil
 }
 
-func getAuthenticationResponse(authenticationResponseParam []byte) ([]byte, error) {
-	return buildAuthenticationResponse(authenticationResponseParam)
+func getAuthenticationResponse(authenticationResponseParam []byte, eapMessage []byte) ([]byte, error) {
+	return buildAuthenticationResponse(authenticationResponseParam, eapMessage)
+}
+
+// buildAuthenticationFailure builds the authentication failure of the cause, the AUTS is only carried by a synch failure
+func buildAuthenticationFailure(cause uint8, auts []byte) ([]byte, error) {
+	m := nas.NewMessage()
+	m.GmmMessage = nas.NewGmmMessage()
+	m.GmmHeader.SetMessageType(nas.MsgTypeAuthenticationFailure)
+
+	authenticationFailure := nasMessage.NewAuthenticationFailure(0)
+	authenticationFailure.ExtendedProtocolDiscriminator.SetExtendedProtocolDiscriminator(
+		nasMessage.Epd5GSMobilityManagementMessage)
+	authenticationFailure.SpareHalfOctetAndSecurityHeaderType.SetSecurityHeaderType(nas.SecurityHeaderTypePlainNas)
+	authenticationFailure.SpareHalfOctetAndSecurityHeaderType.SetSpareHalfOctet(0)
+	authenticationFailure.AuthenticationFailureMessageIdentity.SetMessageType(nas.MsgTypeAuthenticationFailure)
+	authenticationFailure.Cause5GMM.SetCauseValue(cause)
+
+	if cause == nasMessage.Cause5GMMSynchFailure {
+		var authenticationFailureParameter [14]uint8
+		copy(authenticationFailureParameter[:], auts)
+		authenticationFailure.AuthenticationFailureParameter = nasType.NewAuthenticationFailureParameter(
+			nasMessage.AuthenticationFailureAuthenticationFailureParameterType)
+		authenticationFailure.AuthenticationFailureParameter.SetLen(uint8(len(authenticationFailureParameter)))
+		authenticationFailure.AuthenticationFailureParameter.SetAuthenticationFailureParameter(authenticationFailureParameter)
+	}
+
+	m.GmmMessage.AuthenticationFailure = authenticationFailure
+
+	failure := new(bytes.Buffer)
+	if err := m.GmmMessageEncode(failure); err != nil {
+		return nil, err
+	}
+
+	return failure.Bytes(), nil
+}
+
+func getAuthenticationFailure(cause uint8, auts []byte) ([]byte, error) {
+	return buildAuthenticationFailure(cause, auts)
 }
 
 func buildNasSecurityModeCompleteMessage(nasMessageContainer []byte) ([]byte, error) {
@@ -272,6 +449,32 @@ func getNasSecurityModeCompleteMessage(nasMessageContainer []byte) ([]byte, erro
 	return buildNasSecurityModeCompleteMessage(nasMessageContainer)
 }
 
+func buildSecurityModeReject(cause uint8) ([]byte, error) {
+	m := nas.NewMessage()
+	m.GmmMessage = nas.NewGmmMessage()
+	m.GmmHeader.SetMessageType(nas.MsgTypeSecurityModeReject)
+
+	securityModeReject := nasMessage.NewSecurityModeReject(0)
+	securityModeReject.ExtendedProtocolDiscriminator.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSMobilityManagementMessage)
+	securityModeReject.SpareHalfOctetAndSecurityHeaderType.SetSecurityHeaderType(nas.SecurityHeaderTypePlainNas)
+	securityModeReject.SpareHalfOctetAndSecurityHeaderType.SetSpareHalfOctet(0)
+	securityModeReject.SecurityModeRejectMessageIdentity.SetMessageType(nas.MsgTypeSecurityModeReject)
+	securityModeReject.Cause5GMM.SetCauseValue(cause)
+
+	m.GmmMessage.SecurityModeReject = securityModeReject
+
+	reject := new(bytes.Buffer)
+	if err := m.GmmMessageEncode(reject); err != nil {
+		return nil, err
+	}
+
+	return reject.Bytes(), nil
+}
+
+func getSecurityModeReject(cause uint8) ([]byte, error) {
+	return buildSecurityModeReject(cause)
+}
+
 func buildNasRegistrationCompleteMessage(sorTransparentContainer []byte) ([]byte, error) {
 	m := nas.NewMessage()
 	m.GmmMessage = nas.NewGmmMessage()
@@ -305,7 +508,7 @@ func getNasRegistrationCompleteMessage(nasMessageContainer []byte) ([]byte, erro
 	return buildNasRegistrationCompleteMessage(nasMessageContainer)
 }
 
-func buildPduSessionEstablishmentRequest(pduSessionId uint8) ([]byte, error) {
+func buildPduSessionEstablishmentRequest(pduSessionId uint8, pduSessionType uint8) ([]byte, error) {
 	m := nas.NewMessage()
 	m.GsmMessage = nas.NewGsmMessage()
 	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionEstablishmentRequest)
@@ -319,7 +522,7 @@ func buildPduSessionEstablishmentRequest(pduSessionId uint8) ([]byte, error) {
 	pduSessionEstablishmentRequest.IntegrityProtectionMaximumDataRate.SetMaximumDataRatePerUEForUserPlaneIntegrityProtectionForUpLink(0xff)
 
 	pduSessionEstablishmentRequest.PDUSessionType = nasType.NewPDUSessionType(nasMessage.PDUSessionEstablishmentRequestPDUSessionTypeType)
-	pduSessionEstablishmentRequest.PDUSessionType.SetPDUSessionTypeValue(uint8(0x01)) //IPv4 type
+	pduSessionEstablishmentRequest.PDUSessionType.SetPDUSessionTypeValue(pduSessionType)
 
 	pduSessionEstablishmentRequest.SSCMode = nasType.NewSSCMode(nasMessage.PDUSessionEstablishmentRequestSSCModeType)
 	pduSessionEstablishmentRequest.SSCMode.SetSSCMode(uint8(0x01)) //SSC Mode 1
@@ -344,10 +547,133 @@ func buildPduSessionEstablishmentRequest(pduSessionId uint8) ([]byte, error) {
 	return request.Bytes(), nil
 }
 
-func getPduSessionEstablishmentRequest(pduSessionId uint8) ([]byte, error) {
-	return buildPduSessionEstablishmentRequest(pduSessionId)
+func getPduSessionEstablishmentRequest(pduSessionId uint8, pduSessionType uint8) ([]byte, error) {
+	return buildPduSessionEstablishmentRequest(pduSessionId, pduSessionType)
+}
+
+// parsePduAddress splits the PDU address information of TS 24.501 9.11.4.10 into the IPv4 address
+// and the IPv6 interface identifier by the PDU session type, the IPv4v6 information is the interface identifier followed by the IPv4 address
+func parsePduAddress(pduSessionType uint8, pduAddressInformation [12]uint8) (string, [8]uint8, error) {
+	var interfaceIdentifier [8]uint8
+	switch pduSessionType {
+	case nasMessage.PDUSessionTypeIPv4:
+		return net.IP(pduAddressInformation[:4]).String(), interfaceIdentifier, nil
+	case nasMessage.PDUSessionTypeIPv6:
+		copy(interfaceIdentifier[:], pduAddressInformation[:8])
+		return "", interfaceIdentifier, nil
+	case nasMessage.PDUSessionTypeIPv4IPv6:
+		copy(interfaceIdentifier[:], pduAddressInformation[:8])
+		return net.IP(pduAddressInformation[8:12]).String(), interfaceIdentifier, nil
+	default:
+		return "", interfaceIdentifier, fmt.Errorf("unsupported pdu session type: %d", pduSessionType)
+	}
+}
+
+func buildPduSessionReleaseRequest(pduSessionId uint8, pti uint8) ([]byte, error) {
+	m := nas.NewMessage()
+	m.GsmMessage = nas.NewGsmMessage()
+	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionReleaseRequest)
+
+	pduSessionReleaseRequest := nasMessage.NewPDUSessionReleaseRequest(0)
+	pduSessionReleaseRequest.ExtendedProtocolDiscriminator.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	pduSessionReleaseRequest.SetMessageType(nas.MsgTypePDUSessionReleaseRequest)
+	pduSessionReleaseRequest.PDUSessionID.SetPDUSessionID(pduSessionId)
+	pduSessionReleaseRequest.PTI.SetPTI(pti)
+
+	pduSessionReleaseRequest.Cause5GSM = nasType.NewCause5GSM(nasMessage.PDUSessionReleaseRequestCause5GSMType)
+	pduSessionReleaseRequest.Cause5GSM.SetCauseValue(nasMessage.Cause5GSMRegularDeactivation)
+
+	m.GsmMessage.PDUSessionReleaseRequest = pduSessionReleaseRequest
+
+	request := new(bytes.Buffer)
+	if err := m.GsmMessageEncode(request); err != nil {
+		return nil, err
+	}
+
+	return request.Bytes(), nil
+}
+
+func getPduSessionReleaseRequest(pduSessionId uint8, pti uint8) ([]byte, error) {
+	return buildPduSessionReleaseRequest(pduSessionId, pti)
+}
+
+func buildPduSessionReleaseComplete(pduSessionId uint8, pti uint8) ([]byte, error) {
+	m := nas.NewMessage()
+	m.GsmMessage = nas.NewGsmMessage()
+	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionReleaseComplete)
+
+	pduSessionReleaseComplete := nasMessage.NewPDUSessionReleaseComplete(0)
+	pduSessionReleaseComplete.ExtendedProtocolDiscriminator.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	pduSessionReleaseComplete.SetMessageType(nas.MsgTypePDUSessionReleaseComplete)
+	pduSessionReleaseComplete.PDUSessionID.SetPDUSessionID(pduSessionId)
+	pduSessionReleaseComplete.PTI.SetPTI(pti)
+
+	m.GsmMessage.PDUSessionReleaseComplete = pduSessionReleaseComplete
+
+	complete := new(bytes.Buffer)
+	if err := m.GsmMessageEncode(complete); err != nil {
+		return nil, err
+	}
+
+	return complete.Bytes(), nil
+}
+
+func getPduSessionReleaseComplete(pduSessionId uint8, pti uint8) ([]byte, error) {
+	return buildPduSessionReleaseComplete(pduSessionId, pti)
+}
+
+func buildPduSessionModificationRequest(pduSessionId uint8, pti uint8) ([]byte, error) {
+	m := nas.NewMessage()
+	m.GsmMessage = nas.NewGsmMessage()
+	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionModificationRequest)
+
+	pduSessionModificationRequest := nasMessage.NewPDUSessionModificationRequest(0)
+	pduSessionModificationRequest.ExtendedProtocolDiscriminator.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	pduSessionModificationRequest.SetMessageType(nas.MsgTypePDUSessionModificationRequest)
+	pduSessionModificationRequest.PDUSessionID.SetPDUSessionID(pduSessionId)
+	pduSessionModificationRequest.PTI.SetPTI(pti)
+
+	m.GsmMessage.PDUSessionModificationRequest = pduSessionModificationRequest
+
+	request := new(bytes.Buffer)
+	if err := m.GsmMessageEncode(request); err != nil {
+		return nil, err
+	}
+
+	return request.Bytes(), nil
+}
+
+func getPduSessionModificationRequest(pduSessionId uint8, pti uint8) ([]byte, error) {
+	return buildPduSessionModificationRequest(pduSessionId, pti)
+}
+
+func buildPduSessionModificationComplete(pduSessionId uint8, pti uint8) ([]byte, error) {
+	m := nas.NewMessage()
+	m.GsmMessage = nas.NewGsmMessage()
+	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionModificationComplete)
+
+	pduSessionModificationComplete := nasMessage.NewPDUSessionModificationComplete(0)
+	pduSessionModificationComplete.ExtendedProtocolDiscriminator.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	pduSessionModificationComplete.SetMessageType(nas.MsgTypePDUSessionModificationComplete)
+	pduSessionModificationComplete.PDUSessionID.SetPDUSessionID(pduSessionId)
+	pduSessionModificationComplete.PTI.SetPTI(pti)
+
+	m.GsmMessage.PDUSessionModificationComplete = pduSessionModificationComplete
+
+	complete := new(bytes.Buffer)
+	if err := m.GsmMessageEncode(complete); err != nil {
+		return nil, err
+	}
+
+	return complete.Bytes(), nil
+}
+
+func getPduSessionModificationComplete(pduSessionId uint8, pti uint8) ([]byte, error) {
+	return buildPduSessionModificationComplete(pduSessionId, pti)
 }
 
+// buildUlNasTransportMessage wraps a 5GSM message into the UL NAS transport, the request type is omitted when it is 0,
+// which is the case for every 5GSM message other than the PDU session establishment request
 func buildUlNasTransportMessage(nasMessageContainer []byte, pduSessionId uint8, requestType uint8, dnn string, sNssai *models.Snssai) ([]byte, error) {
 	m := nas.NewMessage()
 	m.GmmMessage = nas.NewGmmMessage()
@@ -360,9 +686,11 @@ func buildUlNasTransportMessage(nasMessageContainer []byte, pduSessionId uint8,
 	ulNasTransport.PduSessionID2Value = new(nasType.PduSessionID2Value)
 	ulNasTransport.PduSessionID2Value.SetIei(nasMessage.ULNASTransportPduSessionID2ValueType)
 	ulNasTransport.PduSessionID2Value.SetPduSessionID2Value(pduSessionId)
-	ulNasTransport.RequestType = new(nasType.RequestType)
-	ulNasTransport.RequestType.SetIei(nasMessage.ULNASTransportRequestTypeType)
-	ulNasTransport.RequestType.SetRequestTypeValue(requestType)
+	if requestType != 0 {
+		ulNasTransport.RequestType = new(nasType.RequestType)
+		ulNasTransport.RequestType.SetIei(nasMessage.ULNASTransportRequestTypeType)
+		ulNasTransport.RequestType.SetRequestTypeValue(requestType)
+	}
 
 	if dnn != "" {
 		ulNasTransport.DNN = new(nasType.DNN)
@@ -435,8 +763,119 @@ func getUeDeRegistrationRequest(accessType uint8, switchOff uint8, ngKsi uint8,
 	return buildUeDeRegistrationRequest(accessType, switchOff, ngKsi, mobileIdentity5GS)
 }
 
-func getNasPduFromNasPduSessionEstablishmentAccept(nasPduSessionEstablishmentAccept *nas.Message) (*nas.Message, error) {
-	content := nasPduSessionEstablishmentAccept.DLNASTransport.GetPayloadContainerContents()
+func buildConfigurationUpdateComplete() ([]byte, error) {
+	m := nas.NewMessage()
+	m.GmmMessage = nas.NewGmmMessage()
+	m.GmmHeader.SetMessageType(nas.MsgTypeConfigurationUpdateComplete)
+
+	configurationUpdateComplete := nasMessage.NewConfigurationUpdateComplete(0)
+	configurationUpdateComplete.ExtendedProtocolDiscriminator.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSMobilityManagementMessage)
+	configurationUpdateComplete.SpareHalfOctetAndSecurityHeaderType.SetSecurityHeaderType(nas.SecurityHeaderTypePlainNas)
+	configurationUpdateComplete.SpareHalfOctetAndSecurityHeaderType.SetSpareHalfOctet(0)
+	configurationUpdateComplete.ConfigurationUpdateCompleteMessageIdentity.SetMessageType(nas.MsgTypeConfigurationUpdateComplete)
+
+	m.GmmMessage.ConfigurationUpdateComplete = configurationUpdateComplete
+
+	complete := new(bytes.Buffer)
+	if err := m.GmmMessageEncode(complete); err != nil {
+		return nil, err
+	}
+
+	return complete.Bytes(), nil
+}
+
+func getConfigurationUpdateComplete() ([]byte, error) {
+	return buildConfigurationUpdateComplete()
+}
+
+// buildIdentityResponse answers the identity request with the SUCI, or with no identity for any other requested type
+func buildIdentityResponse(identityType uint8, mobileIdentity5GS nasType.MobileIdentity5GS) ([]byte, error) {
+	m := nas.NewMessage()
+	m.GmmMessage = nas.NewGmmMessage()
+	m.GmmHeader.SetMessageType(nas.MsgTypeIdentityResponse)
+
+	identityResponse := nasMessage.NewIdentityResponse(0)
+	identityResponse.ExtendedProtocolDiscriminator.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSMobilityManagementMessage)
+	identityResponse.SpareHalfOctetAndSecurityHeaderType.SetSecurityHeaderType(nas.SecurityHeaderTypePlainNas)
+	identityResponse.SpareHalfOctetAndSecurityHeaderType.SetSpareHalfOctet(0)
+	identityResponse.IdentityResponseMessageIdentity.SetMessageType(nas.MsgTypeIdentityResponse)
+
+	if identityType == nasMessage.MobileIdentity5GSTypeSuci {
+		identityResponse.MobileIdentity.SetLen(mobileIdentity5GS.GetLen())
+		identityResponse.MobileIdentity.SetMobileIdentityContents(mobileIdentity5GS.GetMobileIdentity5GSContents())
+	} else {
+		identityResponse.MobileIdentity.SetLen(1)
+		identityResponse.MobileIdentity.SetMobileIdentityContents([]uint8{nasMessage.MobileIdentity5GSTypeNoIdentity})
+	}
+
+	m.GmmMessage.IdentityResponse = identityResponse
+
+	response := new(bytes.Buffer)
+	if err := m.GmmMessageEncode(response); err != nil {
+		return nil, err
+	}
+
+	return response.Bytes(), nil
+}
+
+func getIdentityResponse(identityType uint8, mobileIdentity5GS nasType.MobileIdentity5GS) ([]byte, error) {
+	return buildIdentityResponse(identityType, mobileIdentity5GS)
+}
+
+func buildDeregistrationAcceptUeTerminated() ([]byte, error) {
+	m := nas.NewMessage()
+	m.GmmMessage = nas.NewGmmMessage()
+	m.GmmHeader.SetMessageType(nas.MsgTypeDeregistrationAcceptUETerminatedDeregistration)
+
+	deregistrationAccept := nasMessage.NewDeregistrationAcceptUETerminatedDeregistration(0)
+	deregistrationAccept.ExtendedProtocolDiscriminator.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSMobilityManagementMessage)
+	deregistrationAccept.SpareHalfOctetAndSecurityHeaderType.SetSecurityHeaderType(nas.SecurityHeaderTypePlainNas)
+	deregistrationAccept.SpareHalfOctetAndSecurityHeaderType.SetSpareHalfOctet(0)
+	deregistrationAccept.DeregistrationAcceptMessageIdentity.SetMessageType(nas.MsgTypeDeregistrationAcceptUETerminatedDeregistration)
+
+	m.GmmMessage.DeregistrationAcceptUETerminatedDeregistration = deregistrationAccept
+
+	accept := new(bytes.Buffer)
+	if err := m.GmmMessageEncode(accept); err != nil {
+		return nil, err
+	}
+
+	return accept.Bytes(), nil
+}
+
+func getDeregistrationAcceptUeTerminated() ([]byte, error) {
+	return buildDeregistrationAcceptUeTerminated()
+}
+
+func buildStatus5GMM(cause uint8) ([]byte, error) {
+	m := nas.NewMessage()
+	m.GmmMessage = nas.NewGmmMessage()
+	m.GmmHeader.SetMessageType(nas.MsgTypeStatus5GMM)
+
+	status5GMM := nasMessage.NewStatus5GMM(0)
+	status5GMM.ExtendedProtocolDiscriminator.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSMobilityManagementMessage)
+	status5GMM.SpareHalfOctetAndSecurityHeaderType.SetSecurityHeaderType(nas.SecurityHeaderTypePlainNas)
+	status5GMM.SpareHalfOctetAndSecurityHeaderType.SetSpareHalfOctet(0)
+	status5GMM.STATUSMessageIdentity5GMM.SetMessageType(nas.MsgTypeStatus5GMM)
+	status5GMM.Cause5GMM.SetCauseValue(cause)
+
+	m.GmmMessage.Status5GMM = status5GMM
+
+	status := new(bytes.Buffer)
+	if err := m.GmmMessageEncode(status); err != nil {
+		return nil, err
+	}
+
+	return status.Bytes(), nil
+}
+
+func getStatus5GMM(cause uint8) ([]byte, error) {
+	return buildStatus5GMM(cause)
+}
+
+// getNasPduFromDlNasTransport decodes the 5GSM message carried in the payload container of the DL NAS transport
+func getNasPduFromDlNasTransport(dlNasTransport *nas.Message) (*nas.Message, error) {
+	content := dlNasTransport.DLNASTransport.GetPayloadContainerContents()
 
 	nasMessage := new(nas.Message)
 	if err := nasMessage.PlainNasDecode(&content); err != nil {
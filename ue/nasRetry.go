@@ -0,0 +1,338 @@
+package ue
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/model"
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/nasType"
+)
+
+// the 5GMM cause #62 of TS 24.501 9.11.3.2 missing in the nas library
+const cause5GMMNoNetworkSlicesAvailable uint8 = 0x3e
+
+type nasTimer struct {
+	name     string
+	duration time.Duration
+}
+
+// nasTimerExpiredError is the expiry of a NAS timer waiting for the answer of the network,
+// after every transmission of the request when it is retransmitted
+type nasTimerExpiredError struct {
+	timer    nasTimer
+	expiries int
+}
+
+func (e *nasTimerExpiredError) Error() string {
+	if e.expiries > 1 {
+		return fmt.Sprintf("%s expired %d times, %v each", e.timer.name, e.expiries, e.timer.duration)
+	}
+	return fmt.Sprintf("%s expired after %v", e.timer.name, e.timer.duration)
+}
+
+// nasRetryPolicy is the retry and back-off policy of the NAS procedures built from the config
+type nasRetryPolicy struct {
+	registrationAttempts int
+	registrationInterval time.Duration
+	retransmissions      int
+	maxBackoff           time.Duration
+
+	t3510 nasTimer
+	t3517 nasTimer
+	t3521 nasTimer
+	t3580 nasTimer
+	t3581 nasTimer
+	t3582 nasTimer
+}
+
+func durationOrDefault(duration, defaultDuration time.Duration) time.Duration {
+	if duration == 0 {
+		return defaultDuration
+	}
+	return duration
+}
+
+func newNasRetryPolicy(nasRetryIe *model.NasRetryIE) nasRetryPolicy {
+	registrationAttempts := nasRetryIe.RegistrationAttempts
+	if registrationAttempts == 0 {
+		registrationAttempts = constant.UE_MAX_REGISTRATION_ATTEMPTS
+	}
+	retransmissions := nasRetryIe.Retransmissions
+	if retransmissions == 0 {
+		retransmissions = constant.UE_MAX_NAS_RETRANSMISSIONS
+	}
+
+	return nasRetryPolicy{
+		registrationAttempts: registrationAttempts,
+		registrationInterval: durationOrDefault(nasRetryIe.RegistrationInterval, constant.UE_T3511),
+		retransmissions:      retransmissions,
+		maxBackoff:           durationOrDefault(nasRetryIe.MaxBackoff, constant.UE_DEFAULT_MAX_NAS_BACKOFF_TIME),
+
+		t3510: nasTimer{name: "T3510", duration: durationOrDefault(nasRetryIe.T3510, constant.UE_T3510)},
+		t3517: nasTimer{name: "T3517", duration: constant.UE_T3517},
+		t3521: nasTimer{name: "T3521", duration: durationOrDefault(nasRetryIe.T3521, constant.UE_T3521)},
+		t3580: nasTimer{name: "T3580", duration: durationOrDefault(nasRetryIe.T3580, constant.UE_T3580)},
+		t3581: nasTimer{name: "T3581", duration: constant.UE_T3581},
+		t3582: nasTimer{name: "T3582", duration: constant.UE_T3582},
+	}
+}
+
+// withNasTimer runs the procedure reading from RAN with the NAS timer as the read deadline of the RAN control plane,
+// a procedure failing once the timer is up fails with its expiry
+func (u *Ue) withNasTimer(timer nasTimer, procedure func() error) error {
+	expiry := time.Now().Add(timer.duration)
+	u.ranControlPlaneReader.setDeadline(expiry)
+
+	err := procedure()
+	u.ranControlPlaneReader.setDeadline(time.Time{})
+	if err != nil && !time.Now().Before(expiry) {
+		u.NasLog.Debugf("%s expired: %+v", timer.name, err)
+		return &nasTimerExpiredError{timer: timer, expiries: 1}
+	}
+	return err
+}
+
+// retransmitOnExpiry sends the request and receives its answer under the NAS timer, the request is sent again on each expiry
+// up to the retransmissions of the retry policy and the procedure is aborted on the next expiry as in TS 24.501
+func (u *Ue) retransmitOnExpiry(timer nasTimer, send func() error, receive func(nasTimer) error) error {
+	for transmission := 1; ; transmission++ {
+		if err := send(); err != nil {
+			return err
+		}
+
+		err := receive(timer)
+		var expired *nasTimerExpiredError
+		if !errors.As(err, &expired) {
+			return err
+		}
+		if transmission > u.nasRetry.retransmissions {
+			return &nasTimerExpiredError{timer: timer, expiries: transmission}
+		}
+		u.NasLog.Warnf("%v, retransmission %d of %d", err, transmission, u.nasRetry.retransmissions)
+	}
+}
+
+// registrationRejectError is a registration reject of the network, with T3346 of a congested network
+type registrationRejectError struct {
+	cause uint8
+	t3346 time.Duration
+}
+
+func newRegistrationRejectError(registrationReject *nasMessage.RegistrationReject) *registrationRejectError {
+	reject := &registrationRejectError{
+		cause: registrationReject.GetCauseValue(),
+	}
+	if registrationReject.T3346Value != nil {
+		reject.t3346 = util.GprsTimer2ToDuration(registrationReject.T3346Value.GetGPRSTimer2Value())
+	}
+	return reject
+}
+
+func (e *registrationRejectError) Error() string {
+	if e.t3346 > 0 {
+		return fmt.Sprintf("registration rejected, cause: %s, T3346: %v", nasMessage.Cause5GMMToString(e.cause), e.t3346)
+	}
+	return fmt.Sprintf("registration rejected, cause: %s", nasMessage.Cause5GMMToString(e.cause))
+}
+
+type registrationRejectAction int
+
+const (
+	// the registration is attempted again after T3511 up to the attempt counter
+	registrationRejectRetry registrationRejectAction = iota
+	// the registration is attempted again with the SUCI right away
+	registrationRejectRetryWithSuci
+	// the registration is attempted again on the expiry of T3346
+	registrationRejectBackoff
+	// the UE is barred and the registration is not attempted again
+	registrationRejectGiveUp
+)
+
+// registrationRejectActionOf is what the UE does after a registration reject as in TS 24.501 5.5.1.2.5, a congestion
+// without T3346 or with a zero or deactivated T3346 is handled as the other causes
+func registrationRejectActionOf(reject *registrationRejectError) registrationRejectAction {
+	switch reject.cause {
+	case nasMessage.Cause5GMMIllegalUE, nasMessage.Cause5GMMIllegalME, nasMessage.Cause5GMM5GSServicesNotAllowed,
+		nasMessage.Cause5GMMPLMNNotAllowed, nasMessage.Cause5GMMTrackingAreaNotAllowed, nasMessage.Cause5GMMRoamingNotAllowedInThisTrackingArea,
+		nasMessage.Cause5GMMNoSuitableCellsInTrackingArea, nasMessage.Cause5GMMN1ModeNotAllowed, nasMessage.Cause5GMMServingNetworkNotAuthorized,
+		cause5GMMNoNetworkSlicesAvailable:
+		return registrationRejectGiveUp
+	case nasMessage.Cause5GMMUEIdentityCannotBeDerivedByTheNetwork, nasMessage.Cause5GMMImplicitlyDeregistered:
+		return registrationRejectRetryWithSuci
+	case nasMessage.Cause5GMMCongestion:
+		if reject.t3346 > 0 {
+			return registrationRejectBackoff
+		}
+		return registrationRejectRetry
+	default:
+		return registrationRejectRetry
+	}
+}
+
+// isRegistrationContextDeletedBy tells if the reject cause deletes the 5G-GUTI, TAI list and ngKSI of the UE as in TS 24.501 5.5.1.2.5
+func isRegistrationContextDeletedBy(cause uint8) bool {
+	switch cause {
+	case nasMessage.Cause5GMMIllegalUE, nasMessage.Cause5GMMIllegalME, nasMessage.Cause5GMM5GSServicesNotAllowed,
+		nasMessage.Cause5GMMUEIdentityCannotBeDerivedByTheNetwork, nasMessage.Cause5GMMImplicitlyDeregistered,
+		nasMessage.Cause5GMMPLMNNotAllowed, nasMessage.Cause5GMMTrackingAreaNotAllowed, nasMessage.Cause5GMMRoamingNotAllowedInThisTrackingArea,
+		nasMessage.Cause5GMMNoSuitableCellsInTrackingArea, nasMessage.Cause5GMMN1ModeNotAllowed:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleRegistrationReject keeps the cause in the NAS status and deletes the 5G-GUTI, TAI list and native NAS security context
+// if the cause says so, and returns what the UE does next
+func (u *Ue) handleRegistrationReject(reject *registrationRejectError) registrationRejectAction {
+	u.NasLog.Warnf("Receive Registration Reject from RAN, %v", reject)
+	u.setLast5GmmCause(reject.cause)
+
+	if isRegistrationContextDeletedBy(reject.cause) {
+		u.deleteRegistrationContext()
+	}
+	return registrationRejectActionOf(reject)
+}
+
+// deleteRegistrationContext deletes the 5G-GUTI, TAI list and native NAS security context of the UE
+func (u *Ue) deleteRegistrationContext() {
+	u.registrationContextMtx.Lock()
+	u.guti5G = nil
+	u.taiList = nil
+	u.registrationContextMtx.Unlock()
+
+	u.securityContextMtx.Lock()
+	u.ngKsi = uint8(nasMessage.NasKeySetIdentifierNoKeyIsAvailable)
+	u.kAmf = nil
+	u.securityContextMtx.Unlock()
+	u.saveState()
+	u.NasLog.Infoln("Deleted the 5G-GUTI, TAI list and NAS security context of the UE")
+}
+
+// backoffTimer is the T3396 of a DNN given by a pdu session establishment reject, a deactivated timer runs until the UE stops
+type backoffTimer struct {
+	expiry      time.Time
+	deactivated bool
+}
+
+// nasStatus is the outcome of the NAS procedures of the UE, e.g. for the summary of multi UE mode
+type nasStatus struct {
+	registrationAttempts int
+	last5GmmCause        uint8
+	last5GsmCause        uint8
+	t3396                map[string]backoffTimer
+	// the T3346 of a service reject of a congested network, no service request is sent until it expires
+	t3346Expiry time.Time
+
+	nasStatusMtx sync.Mutex
+}
+
+func (u *Ue) setRegistrationAttempts(registrationAttempts int) {
+	u.nasStatusMtx.Lock()
+	defer u.nasStatusMtx.Unlock()
+
+	u.registrationAttempts = registrationAttempts
+}
+
+func (u *Ue) setLast5GmmCause(cause uint8) {
+	u.nasStatusMtx.Lock()
+	defer u.nasStatusMtx.Unlock()
+
+	u.last5GmmCause = cause
+}
+
+func (u *Ue) setLast5GsmCause(cause uint8) {
+	u.nasStatusMtx.Lock()
+	defer u.nasStatusMtx.Unlock()
+
+	u.last5GsmCause = cause
+}
+
+// startT3396 starts the back-off timer of the DNN as in TS 24.501 6.4.1.4.3, a zero timer lets the UE request the DNN again
+func (u *Ue) startT3396(dnn string, backoffTimerValue *nasType.BackoffTimerValue) {
+	u.nasStatusMtx.Lock()
+	defer u.nasStatusMtx.Unlock()
+
+	if u.t3396 == nil {
+		u.t3396 = make(map[string]backoffTimer)
+	}
+
+	if backoffTimerValue.GetUnitTimerValue() == util.GprsTimerUnitDeactivated {
+		u.t3396[dnn] = backoffTimer{deactivated: true}
+		u.PduLog.Warnf("T3396 of DNN %s deactivated, the DNN is not requested again until the UE stops", dnn)
+		return
+	}
+
+	duration := util.GprsTimer3ToDuration(backoffTimerValue.GetUnitTimerValue(), backoffTimerValue.GetTimerValue())
+	if duration == 0 {
+		delete(u.t3396, dnn)
+		return
+	}
+	u.t3396[dnn] = backoffTimer{expiry: time.Now().Add(duration)}
+	u.PduLog.Warnf("T3396 of DNN %s started, %v", dnn, duration)
+}
+
+// checkT3396 fails a pdu session establishment of the DNN while its back-off timer runs
+func (u *Ue) checkT3396(dnn string) error {
+	u.nasStatusMtx.Lock()
+	defer u.nasStatusMtx.Unlock()
+
+	backoff, exists := u.t3396[dnn]
+	if !exists {
+		return nil
+	}
+	if backoff.deactivated {
+		return fmt.Errorf("T3396 of DNN %s deactivated by the network", dnn)
+	}
+	if remaining := time.Until(backoff.expiry); remaining > 0 {
+		return fmt.Errorf("T3396 of DNN %s running, %v left", dnn, remaining.Round(time.Second))
+	}
+	delete(u.t3396, dnn)
+	return nil
+}
+
+// getNasStatusSummary tells the registration attempts, the last reject causes, the running back-off timers and the rejected
+// S-NSSAIs of the UE
+func (u *Ue) getNasStatusSummary() string {
+	rejectedNssai := u.getRejectedNssai()
+
+	u.nasStatusMtx.Lock()
+	defer u.nasStatusMtx.Unlock()
+
+	summary := []string{fmt.Sprintf("registration attempts: %d", u.registrationAttempts)}
+	if u.last5GmmCause != 0 {
+		summary = append(summary, fmt.Sprintf("5GMM cause: %s", nasMessage.Cause5GMMToString(u.last5GmmCause)))
+	}
+	if u.last5GsmCause != 0 {
+		summary = append(summary, fmt.Sprintf("5GSM cause: %d", u.last5GsmCause))
+	}
+
+	dnns := make([]string, 0, len(u.t3396))
+	for dnn := range u.t3396 {
+		dnns = append(dnns, dnn)
+	}
+	slices.Sort(dnns)
+	for _, dnn := range dnns {
+		if backoff := u.t3396[dnn]; backoff.deactivated {
+			summary = append(summary, fmt.Sprintf("T3396 of DNN %s: deactivated", dnn))
+		} else if remaining := time.Until(backoff.expiry); remaining > 0 {
+			summary = append(summary, fmt.Sprintf("T3396 of DNN %s: %v left", dnn, remaining.Round(time.Second)))
+		}
+	}
+
+	if remaining := time.Until(u.t3346Expiry); remaining > 0 {
+		summary = append(summary, fmt.Sprintf("T3346: %v left", remaining.Round(time.Second)))
+	}
+
+	for _, rejectedSnssai := range rejectedNssai {
+		summary = append(summary, fmt.Sprintf("rejected S-NSSAI (%s): %s", snssaiToString(rejectedSnssai.Snssai), rejectedSnssaiCauseToString(rejectedSnssai.Cause)))
+	}
+	return strings.Join(summary, ", ")
+}
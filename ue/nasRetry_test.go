@@ -0,0 +1,201 @@
+package ue
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/logger"
+	"github.com/Alonza0314/free-ran-ue/model"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/nasType"
+	"github.com/go-playground/assert"
+)
+
+var testRegistrationRejectCases = []struct {
+	name                   string
+	t3346Value             *nasType.T3346Value
+	cause                  uint8
+	expectedAction         registrationRejectAction
+	expectedContextDeleted bool
+	expectedError          string
+}{
+	{
+		name:                   "testIllegalUe",
+		cause:                  nasMessage.Cause5GMMIllegalUE,
+		expectedAction:         registrationRejectGiveUp,
+		expectedContextDeleted: true,
+		expectedError:          "registration rejected, cause: Illegal UE (3)",
+	},
+	{
+		name:                   "testNoNetworkSlicesAvailable",
+		cause:                  cause5GMMNoNetworkSlicesAvailable,
+		expectedAction:         registrationRejectGiveUp,
+		expectedContextDeleted: false,
+		expectedError:          fmt.Sprintf("registration rejected, cause: %s", nasMessage.Cause5GMMToString(cause5GMMNoNetworkSlicesAvailable)),
+	},
+	{
+		name:                   "testImplicitlyDeregistered",
+		cause:                  nasMessage.Cause5GMMImplicitlyDeregistered,
+		expectedAction:         registrationRejectRetryWithSuci,
+		expectedContextDeleted: true,
+		expectedError:          "registration rejected, cause: Implicitly deregistered (10)",
+	},
+	{
+		name:  "testCongestionWithT3346",
+		cause: nasMessage.Cause5GMMCongestion,
+		t3346Value: &nasType.T3346Value{
+			Iei:   nasMessage.RegistrationRejectT3346ValueType,
+			Len:   1,
+			Octet: 0x0f,
+		},
+		expectedAction:         registrationRejectBackoff,
+		expectedContextDeleted: false,
+		expectedError:          "registration rejected, cause: Congestion (22), T3346: 30s",
+	},
+	{
+		name:  "testCongestionWithDeactivatedT3346",
+		cause: nasMessage.Cause5GMMCongestion,
+		t3346Value: &nasType.T3346Value{
+			Iei:   nasMessage.RegistrationRejectT3346ValueType,
+			Len:   1,
+			Octet: 0xe0,
+		},
+		expectedAction:         registrationRejectRetry,
+		expectedContextDeleted: false,
+		expectedError:          "registration rejected, cause: Congestion (22)",
+	},
+	{
+		name:                   "testProtocolError",
+		cause:                  nasMessage.Cause5GMMProtocolErrorUnspecified,
+		expectedAction:         registrationRejectRetry,
+		expectedContextDeleted: false,
+		expectedError:          "registration rejected, cause: Protocol error unspecified (111)",
+	},
+}
+
+func TestRegistrationReject(t *testing.T) {
+	for _, testCase := range testRegistrationRejectCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			registrationReject := nasMessage.NewRegistrationReject(0)
+			registrationReject.SetCauseValue(testCase.cause)
+			registrationReject.T3346Value = testCase.t3346Value
+
+			reject := newRegistrationRejectError(registrationReject)
+			assert.Equal(t, testCase.expectedError, reject.Error())
+			assert.Equal(t, testCase.expectedAction, registrationRejectActionOf(reject))
+			assert.Equal(t, testCase.expectedContextDeleted, isRegistrationContextDeletedBy(testCase.cause))
+		})
+	}
+}
+
+var testT3396Cases = []struct {
+	name          string
+	unit          uint8
+	timerValue    uint8
+	expectedError string
+}{
+	{
+		name:          "testRunning",
+		unit:          0x05,
+		timerValue:    2,
+		expectedError: "T3396 of DNN internet running, 2m0s left",
+	},
+	{
+		name:          "testDeactivated",
+		unit:          0x07,
+		timerValue:    0,
+		expectedError: "T3396 of DNN internet deactivated by the network",
+	},
+	{
+		name:          "testZero",
+		unit:          0x05,
+		timerValue:    0,
+		expectedError: "",
+	},
+}
+
+func TestT3396(t *testing.T) {
+	ueLogger := logger.NewUeLogger("error", "", true)
+
+	for _, testCase := range testT3396Cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ue := &Ue{
+				UeLogger: &ueLogger,
+			}
+
+			backoffTimerValue := &nasType.BackoffTimerValue{}
+			backoffTimerValue.SetUnitTimerValue(testCase.unit)
+			backoffTimerValue.SetTimerValue(testCase.timerValue)
+			ue.startT3396("internet", backoffTimerValue)
+
+			err := ue.checkT3396("internet")
+			if testCase.expectedError == "" {
+				assert.Equal(t, nil, err)
+			} else {
+				assert.Equal(t, testCase.expectedError, err.Error())
+			}
+			assert.Equal(t, nil, ue.checkT3396("ims"))
+		})
+	}
+}
+
+var testRetransmitOnExpiryCases = []struct {
+	name                  string
+	expiries              int
+	expectedTransmissions int
+	expectedError         string
+}{
+	{
+		name:                  "testAnswerOfFirstTransmission",
+		expiries:              0,
+		expectedTransmissions: 1,
+		expectedError:         "",
+	},
+	{
+		name:                  "testAnswerOfRetransmission",
+		expiries:              2,
+		expectedTransmissions: 3,
+		expectedError:         "",
+	},
+	{
+		name:                  "testNoAnswer",
+		expiries:              10,
+		expectedTransmissions: 3,
+		expectedError:         "T3580 expired 3 times, 10ms each",
+	},
+}
+
+func TestRetransmitOnExpiry(t *testing.T) {
+	ueLogger := logger.NewUeLogger("error", "", true)
+
+	for _, testCase := range testRetransmitOnExpiryCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ue := &Ue{
+				nasRetry: newNasRetryPolicy(&model.NasRetryIE{
+					Retransmissions: 2,
+					T3580:           10 * time.Millisecond,
+				}),
+				UeLogger: &ueLogger,
+			}
+
+			transmissions := 0
+			err := ue.retransmitOnExpiry(ue.nasRetry.t3580, func() error {
+				transmissions++
+				return nil
+			}, func(timer nasTimer) error {
+				if transmissions <= testCase.expiries {
+					return &nasTimerExpiredError{timer: timer, expiries: 1}
+				}
+				return nil
+			})
+
+			assert.Equal(t, testCase.expectedTransmissions, transmissions)
+			if testCase.expectedError == "" {
+				assert.Equal(t, nil, err)
+			} else {
+				assert.Equal(t, testCase.expectedError, err.Error())
+			}
+		})
+	}
+}
@@ -1,22 +1,31 @@
 package ue
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/nas"
 	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/nas/nasType"
+	"github.com/free5gc/nas/security"
 	"github.com/free5gc/openapi/models"
 	"github.com/go-playground/assert"
 )
 
 var testBuildUeMobileIdentity5GSCases = []struct {
-	name     string
-	supi     string
-	expected nasType.MobileIdentity5GS
+	name        string
+	mcc         string
+	mnc         string
+	msin        string
+	suciProfile util.SuciProfile
+	expected    nasType.MobileIdentity5GS
 }{
 	{
-		name: "imsi-2089300007487",
-		supi: "2089300007487",
+		name: "imsi-208930000007487",
+		mcc:  "208",
+		mnc:  "93",
+		msin: "0000007487",
 		expected: nasType.MobileIdentity5GS{
 			Len:    13,
 			Buffer: []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x47, 0x78},
@@ -24,18 +33,34 @@ var testBuildUeMobileIdentity5GSCases = []struct {
 	},
 	{
 		name: "imsi-208930000000001",
-		supi: "208930000000001",
+		mcc:  "208",
+		mnc:  "93",
+		msin: "0000000001",
 		expected: nasType.MobileIdentity5GS{
 			Len:    13,
 			Buffer: []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10},
 		},
 	},
+	{
+		name: "imsi-208930000000001-routing-indicator-17",
+		mcc:  "208",
+		mnc:  "93",
+		msin: "0000000001",
+		suciProfile: util.SuciProfile{
+			RoutingIndicator: "17",
+		},
+		expected: nasType.MobileIdentity5GS{
+			Len:    13,
+			Buffer: []byte{0x01, 0x02, 0xf8, 0x39, 0x71, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10},
+		},
+	},
 }
 
 func TestBuildUeMobileIdentity5GS(t *testing.T) {
 	for _, testCase := range testBuildUeMobileIdentity5GSCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			result := buildUeMobileIdentity5GS(testCase.supi)
+			result, err := buildUeMobileIdentity5GS(testCase.mcc, testCase.mnc, testCase.msin, &testCase.suciProfile)
+			assert.Equal(t, nil, err)
 			assert.Equal(t, testCase.expected.Len, result.Len)
 			assert.Equal(t, testCase.expected.Buffer, result.Buffer)
 		})
@@ -44,12 +69,14 @@ func TestBuildUeMobileIdentity5GS(t *testing.T) {
 
 var testBuildUeRegistrationRequestCases = []struct {
 	name              string
+	ngKsi             uint8
 	mobileIdentity5GS nasType.MobileIdentity5GS
 	expectedError     error
 	expected          []byte
 }{
 	{
-		name: "imsi-208930000007487",
+		name:  "imsi-208930000007487",
+		ngKsi: uint8(nasMessage.NasKeySetIdentifierNoKeyIsAvailable),
 		mobileIdentity5GS: nasType.MobileIdentity5GS{
 			Len:    12,
 			Buffer: []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x47, 0x78},
@@ -57,12 +84,56 @@ var testBuildUeRegistrationRequestCases = []struct {
 		expectedError: nil,
 		expected:      []byte{0x7e, 0x00, 0x41, 0x79, 0x00, 0x0c, 0x01, 0x02, 0xf8, 0x39, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x47, 0x78},
 	},
+	{
+		name:              "5g-guti-ngksi-2",
+		ngKsi:             2,
+		mobileIdentity5GS: buildGutiMobileIdentity5GS([]byte{0xf2, 0x02, 0xf8, 0x39, 0xca, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x01}),
+		expectedError:     nil,
+		expected:          []byte{0x7e, 0x00, 0x41, 0x29, 0x00, 0x0b, 0xf2, 0x02, 0xf8, 0x39, 0xca, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x01},
+	},
 }
 
 func TestBuildUeRegistrationRequest(t *testing.T) {
 	for _, testCase := range testBuildUeRegistrationRequestCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			result, err := buildUeRegistrationRequest(nasMessage.RegistrationType5GSInitialRegistration, &testCase.mobileIdentity5GS, nil, nil, nil, nil, nil)
+			result, err := buildUeRegistrationRequest(nasMessage.RegistrationType5GSInitialRegistration, testCase.ngKsi, &testCase.mobileIdentity5GS, nil, nil, nil, nil, nil)
+			assert.Equal(t, testCase.expectedError, err)
+			assert.Equal(t, testCase.expected, result)
+		})
+	}
+}
+
+var testBuildServiceRequestCases = []struct {
+	name             string
+	serviceType      uint8
+	guti5G           []byte
+	uplinkDataStatus []uint8
+	pduSessionStatus []uint8
+	expectedError    error
+	expected         []byte
+}{
+	{
+		name:          "5g-s-tmsi-signalling",
+		serviceType:   nasMessage.ServiceTypeSignalling,
+		guti5G:        []byte{0xf2, 0x02, 0xf8, 0x39, 0xca, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x01},
+		expectedError: nil,
+		expected:      []byte{0x7e, 0x00, 0x4c, 0x02, 0x00, 0x07, 0xf4, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x01},
+	},
+	{
+		name:             "5g-s-tmsi-data-pdu-session-1",
+		serviceType:      nasMessage.ServiceTypeData,
+		guti5G:           []byte{0xf2, 0x02, 0xf8, 0x39, 0xca, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x01},
+		uplinkDataStatus: []uint8{0x02, 0x00},
+		pduSessionStatus: []uint8{0x02, 0x00},
+		expectedError:    nil,
+		expected:         []byte{0x7e, 0x00, 0x4c, 0x12, 0x00, 0x07, 0xf4, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x01, 0x40, 0x02, 0x02, 0x00, 0x50, 0x02, 0x02, 0x00},
+	},
+}
+
+func TestBuildServiceRequest(t *testing.T) {
+	for _, testCase := range testBuildServiceRequestCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := buildServiceRequest(testCase.serviceType, 2, buildTmsi5GS(testCase.guti5G), testCase.uplinkDataStatus, testCase.pduSessionStatus, nil)
 			assert.Equal(t, testCase.expectedError, err)
 			assert.Equal(t, testCase.expected, result)
 		})
@@ -72,6 +143,7 @@ func TestBuildUeRegistrationRequest(t *testing.T) {
 var testBuildAuthenticationResponseCases = []struct {
 	name          string
 	param         []byte
+	eapMessage    []byte
 	expectedError error
 }{
 	{
@@ -79,13 +151,23 @@ var testBuildAuthenticationResponseCases = []struct {
 		param:         []byte{0x7e, 0x00, 0x41, 0x79, 0x00, 0x0c, 0x01, 0x02, 0xf8, 0x39, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x47, 0x78},
 		expectedError: nil,
 	},
+	{
+		name:          "testBuildAuthenticationResponseWithEapMessage",
+		eapMessage:    []byte{0x02, 0x2a, 0x00, 0x08, 0x32, 0x02, 0x00, 0x00},
+		expectedError: nil,
+	},
 }
 
 func TestBuildAuthenticationResponse(t *testing.T) {
 	for _, testCase := range testBuildAuthenticationResponseCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			_, err := buildAuthenticationResponse(testCase.param)
+			result, err := buildAuthenticationResponse(testCase.param, testCase.eapMessage)
 			assert.Equal(t, testCase.expectedError, err)
+			if testCase.eapMessage != nil {
+				m := nas.NewMessage()
+				assert.Equal(t, nil, m.GmmMessageDecode(&result))
+				assert.Equal(t, testCase.eapMessage, m.AuthenticationResponse.GetEAPMessage())
+			}
 		})
 	}
 }
@@ -133,26 +215,79 @@ func TestBuildNasRegistrationCompleteMessage(t *testing.T) {
 }
 
 var testBuildPduSessionEstablishmentRequestCases = []struct {
-	name          string
-	pduSessionId  uint8
-	expectedError error
+	name           string
+	pduSessionId   uint8
+	pduSessionType uint8
+	expectedError  error
 }{
 	{
-		name:          "testBuildPduSessionEstablishmentRequest",
-		pduSessionId:  4,
-		expectedError: nil,
+		name:           "testBuildPduSessionEstablishmentRequest",
+		pduSessionId:   4,
+		pduSessionType: nasMessage.PDUSessionTypeIPv4,
+		expectedError:  nil,
+	},
+	{
+		name:           "testBuildIpv4v6PduSessionEstablishmentRequest",
+		pduSessionId:   5,
+		pduSessionType: nasMessage.PDUSessionTypeIPv4IPv6,
+		expectedError:  nil,
 	},
 }
 
 func TestBuildPduSessionEstablishmentRequest(t *testing.T) {
 	for _, testCase := range testBuildPduSessionEstablishmentRequestCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			_, err := buildPduSessionEstablishmentRequest(testCase.pduSessionId)
+			_, err := buildPduSessionEstablishmentRequest(testCase.pduSessionId, testCase.pduSessionType)
 			assert.Equal(t, testCase.expectedError, err)
 		})
 	}
 }
 
+var testParsePduAddressCases = []struct {
+	name                        string
+	pduSessionType              uint8
+	pduAddressInformation       [12]uint8
+	expectedIpv4                string
+	expectedInterfaceIdentifier [8]uint8
+	expectedError               error
+}{
+	{
+		name:                  "testIpv4",
+		pduSessionType:        nasMessage.PDUSessionTypeIPv4,
+		pduAddressInformation: [12]uint8{10, 60, 0, 1},
+		expectedIpv4:          "10.60.0.1",
+	},
+	{
+		name:                        "testIpv6",
+		pduSessionType:              nasMessage.PDUSessionTypeIPv6,
+		pduAddressInformation:       [12]uint8{0, 0, 0, 0, 0, 0, 0, 1},
+		expectedInterfaceIdentifier: [8]uint8{0, 0, 0, 0, 0, 0, 0, 1},
+	},
+	{
+		name:                        "testIpv4v6",
+		pduSessionType:              nasMessage.PDUSessionTypeIPv4IPv6,
+		pduAddressInformation:       [12]uint8{0, 0, 0, 0, 0, 0, 0, 2, 10, 60, 0, 2},
+		expectedIpv4:                "10.60.0.2",
+		expectedInterfaceIdentifier: [8]uint8{0, 0, 0, 0, 0, 0, 0, 2},
+	},
+	{
+		name:           "testUnsupported",
+		pduSessionType: 0x05,
+		expectedError:  fmt.Errorf("unsupported pdu session type: 5"),
+	},
+}
+
+func TestParsePduAddress(t *testing.T) {
+	for _, testCase := range testParsePduAddressCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ipv4, interfaceIdentifier, err := parsePduAddress(testCase.pduSessionType, testCase.pduAddressInformation)
+			assert.Equal(t, testCase.expectedError, err)
+			assert.Equal(t, testCase.expectedIpv4, ipv4)
+			assert.Equal(t, testCase.expectedInterfaceIdentifier, interfaceIdentifier)
+		})
+	}
+}
+
 var testBuildUlNasTransportMessageCases = []struct {
 	name                string
 	nasMessageContainer []byte
@@ -214,3 +349,159 @@ func TestBuildUeDeRegistrationRequest(t *testing.T) {
 		})
 	}
 }
+
+var testBuildPduSessionReleaseAndModificationCases = []struct {
+	name                string
+	pduSessionId        uint8
+	pti                 uint8
+	build               func(uint8, uint8) ([]byte, error)
+	expectedMessageType uint8
+}{
+	{
+		name:                "testBuildPduSessionReleaseRequest",
+		pduSessionId:        4,
+		pti:                 1,
+		build:               buildPduSessionReleaseRequest,
+		expectedMessageType: nas.MsgTypePDUSessionReleaseRequest,
+	},
+	{
+		name:                "testBuildPduSessionReleaseComplete",
+		pduSessionId:        4,
+		pti:                 0,
+		build:               buildPduSessionReleaseComplete,
+		expectedMessageType: nas.MsgTypePDUSessionReleaseComplete,
+	},
+	{
+		name:                "testBuildPduSessionModificationRequest",
+		pduSessionId:        5,
+		pti:                 2,
+		build:               buildPduSessionModificationRequest,
+		expectedMessageType: nas.MsgTypePDUSessionModificationRequest,
+	},
+	{
+		name:                "testBuildPduSessionModificationComplete",
+		pduSessionId:        5,
+		pti:                 2,
+		build:               buildPduSessionModificationComplete,
+		expectedMessageType: nas.MsgTypePDUSessionModificationComplete,
+	},
+}
+
+func TestBuildPduSessionReleaseAndModification(t *testing.T) {
+	for _, testCase := range testBuildPduSessionReleaseAndModificationCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := testCase.build(testCase.pduSessionId, testCase.pti)
+			assert.Equal(t, nil, err)
+
+			message := new(nas.Message)
+			assert.Equal(t, nil, message.PlainNasDecode(&result))
+			assert.Equal(t, testCase.expectedMessageType, message.GsmHeader.GetMessageType())
+			assert.Equal(t, testCase.pduSessionId, result[1])
+			assert.Equal(t, testCase.pti, result[2])
+		})
+	}
+}
+
+var testBuildDownlinkNasResponseCases = []struct {
+	name                string
+	build               func() ([]byte, error)
+	expectedMessageType uint8
+}{
+	{
+		name:                "testBuildConfigurationUpdateComplete",
+		build:               buildConfigurationUpdateComplete,
+		expectedMessageType: nas.MsgTypeConfigurationUpdateComplete,
+	},
+	{
+		name:                "testBuildDeregistrationAcceptUeTerminated",
+		build:               buildDeregistrationAcceptUeTerminated,
+		expectedMessageType: nas.MsgTypeDeregistrationAcceptUETerminatedDeregistration,
+	},
+	{
+		name: "testBuildSuciIdentityResponse",
+		build: func() ([]byte, error) {
+			return buildIdentityResponse(nasMessage.MobileIdentity5GSTypeSuci, nasType.MobileIdentity5GS{
+				Len:    13,
+				Buffer: []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x47, 0x78},
+			})
+		},
+		expectedMessageType: nas.MsgTypeIdentityResponse,
+	},
+	{
+		name: "testBuildNoIdentityResponse",
+		build: func() ([]byte, error) {
+			return buildIdentityResponse(nasMessage.MobileIdentity5GSTypeImei, nasType.MobileIdentity5GS{
+				Len:    13,
+				Buffer: []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x47, 0x78},
+			})
+		},
+		expectedMessageType: nas.MsgTypeIdentityResponse,
+	},
+	{
+		name: "testBuildStatus5GMM",
+		build: func() ([]byte, error) {
+			return buildStatus5GMM(nasMessage.Cause5GMMMessageTypeNonExistentOrNotImplemented)
+		},
+		expectedMessageType: nas.MsgTypeStatus5GMM,
+	},
+	{
+		name: "testBuildSynchFailureAuthenticationFailure",
+		build: func() ([]byte, error) {
+			return buildAuthenticationFailure(nasMessage.Cause5GMMSynchFailure, make([]byte, 14))
+		},
+		expectedMessageType: nas.MsgTypeAuthenticationFailure,
+	},
+	{
+		name: "testBuildMacFailureAuthenticationFailure",
+		build: func() ([]byte, error) {
+			return buildAuthenticationFailure(nasMessage.Cause5GMMMACFailure, nil)
+		},
+		expectedMessageType: nas.MsgTypeAuthenticationFailure,
+	},
+}
+
+func TestBuildDownlinkNasResponse(t *testing.T) {
+	for _, testCase := range testBuildDownlinkNasResponseCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := testCase.build()
+			assert.Equal(t, nil, err)
+
+			message := new(nas.Message)
+			assert.Equal(t, nil, message.PlainNasDecode(&result))
+			assert.Equal(t, testCase.expectedMessageType, message.GmmHeader.GetMessageType())
+		})
+	}
+}
+
+func TestCipherNasMessageContainer(t *testing.T) {
+	ue := &Ue{
+		authentication: authentication{
+			cipheringAlgorithm: security.AlgCiphering128NEA2,
+			integrityAlgorithm: security.AlgIntegrity128NIA2,
+			kNasEnc:            [16]byte{0x01, 0x02, 0x03},
+			kNasInt:            [16]byte{0x04, 0x05, 0x06},
+		},
+		accessType: models.AccessType__3_GPP_ACCESS,
+	}
+	ue.ulCount.Set(0, 5)
+
+	mobileIdentity5GS := buildGutiMobileIdentity5GS([]byte{0xf2, 0x02, 0xf8, 0x39, 0xca, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x01})
+	entireRegistrationRequest, err := buildUeRegistrationRequest(nasMessage.RegistrationType5GSInitialRegistration, 1, &mobileIdentity5GS, nil, nil, nil, nil, nil)
+	assert.Equal(t, nil, err)
+
+	nasMessageContainer, err := cipherNasMessageContainer(entireRegistrationRequest, ue)
+	assert.Equal(t, nil, err)
+	registrationRequest, err := buildUeRegistrationRequest(nasMessage.RegistrationType5GSInitialRegistration, 1, &mobileIdentity5GS, nil, nil, nil, nasMessageContainer, nil)
+	assert.Equal(t, nil, err)
+	protected, err := encodeNasPduWithSecurity(registrationRequest, nas.SecurityHeaderTypeIntegrityProtected, ue, true, false)
+	assert.Equal(t, nil, err)
+
+	// the AMF deciphers the container with the COUNT of the message carrying it
+	m := nas.NewMessage()
+	plain := protected[7:]
+	assert.Equal(t, nil, m.PlainNasDecode(&plain))
+	assert.Equal(t, uint8(5), protected[6])
+	contents := m.RegistrationRequest.NASMessageContainer.GetNASMessageContainerContents()
+	assert.Equal(t, nil, security.NASEncrypt(security.AlgCiphering128NEA2, ue.kNasEnc, 5, security.Bearer3GPP, security.DirectionUplink, contents))
+	assert.Equal(t, entireRegistrationRequest, contents)
+}
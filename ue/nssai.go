@@ -0,0 +1,131 @@
+package ue
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Alonza0314/free-ran-ue/model"
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi/models"
+)
+
+// rejectedSnssaiCauseNssaaFailedOrRevoked and rejectedSnssaiCauseMaximumNumberOfUesReached are the causes of TS 24.501 9.11.3.46
+// the nas library has no constant of
+const (
+	rejectedSnssaiCauseNssaaFailedOrRevoked      uint8 = 0x02
+	rejectedSnssaiCauseMaximumNumberOfUesReached uint8 = 0x03
+)
+
+func rejectedSnssaiCauseToString(cause uint8) string {
+	switch cause {
+	case nasMessage.RejectedSnssaiCauseNotAvailableInCurrentPlmn:
+		return "not available in the current PLMN"
+	case nasMessage.RejectedSnssaiCauseNotAvailableInCurrentRegistrationArea:
+		return "not available in the current registration area"
+	case rejectedSnssaiCauseNssaaFailedOrRevoked:
+		return "network slice-specific authentication and authorization failed or revoked"
+	case rejectedSnssaiCauseMaximumNumberOfUesReached:
+		return "maximum number of UEs reached"
+	default:
+		return fmt.Sprintf("cause %d", cause)
+	}
+}
+
+func snssaiToString(snssai models.Snssai) string {
+	if snssai.Sd == "" {
+		return fmt.Sprintf("sst: %d", snssai.Sst)
+	}
+	return fmt.Sprintf("sst: %d, sd: %s", snssai.Sst, snssai.Sd)
+}
+
+// isSameSnssai compares the S-NSSAIs regardless of the case of the SD, the hex of the config may be upper case
+func isSameSnssai(a, b models.Snssai) bool {
+	return a.Sst == b.Sst && strings.EqualFold(a.Sd, b.Sd)
+}
+
+func snssaiIesToModels(snssaiIes []model.SnssaiIE) ([]models.Snssai, error) {
+	nssai := make([]models.Snssai, 0, len(snssaiIes))
+	for _, snssaiIe := range snssaiIes {
+		sst, err := strconv.Atoi(snssaiIe.Sst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid s-nssai sst %s: %+v", snssaiIe.Sst, err)
+		}
+		nssai = append(nssai, models.Snssai{Sst: int32(sst), Sd: snssaiIe.Sd})
+	}
+	return nssai, nil
+}
+
+func snssaisToIes(nssai []models.Snssai) []model.SnssaiIE {
+	snssaiIes := make([]model.SnssaiIE, 0, len(nssai))
+	for _, snssai := range nssai {
+		snssaiIes = append(snssaiIes, model.SnssaiIE{Sst: strconv.Itoa(int(snssai.Sst)), Sd: snssai.Sd})
+	}
+	return snssaiIes
+}
+
+func (u *Ue) getAllowedNssai() []models.Snssai {
+	u.registrationContextMtx.Lock()
+	defer u.registrationContextMtx.Unlock()
+
+	return append([]models.Snssai{}, u.allowedNssai...)
+}
+
+func (u *Ue) getRejectedNssai() []util.RejectedSnssai {
+	u.registrationContextMtx.Lock()
+	defer u.registrationContextMtx.Unlock()
+
+	return append([]util.RejectedSnssai{}, u.rejectedNssai...)
+}
+
+// getRequestedNssai is the configured NSSAI of the network, or the default configured NSSAI of the config before the network
+// configures one, without the S-NSSAIs rejected by the last registration as TS 24.501 4.6.2.3
+func (u *Ue) getRequestedNssai() []models.Snssai {
+	u.registrationContextMtx.Lock()
+	defer u.registrationContextMtx.Unlock()
+
+	configuredNssai := u.configuredNssai
+	if len(configuredNssai) == 0 {
+		configuredNssai = u.defaultConfiguredNssai
+	}
+
+	requestedNssai := make([]models.Snssai, 0, len(configuredNssai))
+	for _, snssai := range configuredNssai {
+		rejected := false
+		for _, rejectedSnssai := range u.rejectedNssai {
+			if isSameSnssai(snssai, rejectedSnssai.Snssai) {
+				rejected = true
+				break
+			}
+		}
+		if !rejected {
+			requestedNssai = append(requestedNssai, snssai)
+		}
+	}
+	return requestedNssai
+}
+
+// selectPduSessionSnssai chooses the S-NSSAI of the pdu session from the allowed NSSAI, the S-NSSAI of the session if it is allowed
+// or the first allowed S-NSSAI for a session without one, and the S-NSSAI of the session is requested as it is without allowed NSSAI
+func (u *Ue) selectPduSessionSnssai(session *pduSession) (*models.Snssai, error) {
+	allowedNssai := u.getAllowedNssai()
+	if len(allowedNssai) == 0 {
+		return session.sNssai, nil
+	}
+	if session.sNssai == nil {
+		return &allowedNssai[0], nil
+	}
+
+	for _, snssai := range allowedNssai {
+		if isSameSnssai(snssai, *session.sNssai) {
+			return &snssai, nil
+		}
+	}
+	for _, rejectedSnssai := range u.getRejectedNssai() {
+		if isSameSnssai(rejectedSnssai.Snssai, *session.sNssai) {
+			return nil, fmt.Errorf("S-NSSAI %s of pdu session %d rejected by the network, %s", snssaiToString(*session.sNssai), session.id, rejectedSnssaiCauseToString(rejectedSnssai.Cause))
+		}
+	}
+	return nil, fmt.Errorf("S-NSSAI %s of pdu session %d is not in the allowed NSSAI", snssaiToString(*session.sNssai), session.id)
+}
@@ -0,0 +1,136 @@
+package ue
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Alonza0314/free-ran-ue/logger"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/nasType"
+	"github.com/free5gc/openapi/models"
+	"github.com/go-playground/assert"
+)
+
+var testSelectPduSessionSnssaiCases = []struct {
+	name           string
+	sNssai         *models.Snssai
+	expectedSnssai *models.Snssai
+	expectedError  error
+}{
+	{
+		name:           "testAllowedSnssai",
+		sNssai:         &models.Snssai{Sst: 2},
+		expectedSnssai: &models.Snssai{Sst: 2},
+	},
+	{
+		name:           "testAllowedSnssaiOfUpperCaseSd",
+		sNssai:         &models.Snssai{Sst: 1, Sd: "ABCDEF"},
+		expectedSnssai: &models.Snssai{Sst: 1, Sd: "abcdef"},
+	},
+	{
+		name:           "testFirstAllowedSnssai",
+		sNssai:         nil,
+		expectedSnssai: &models.Snssai{Sst: 1, Sd: "abcdef"},
+	},
+	{
+		name:          "testRejectedSnssai",
+		sNssai:        &models.Snssai{Sst: 3},
+		expectedError: errors.New("S-NSSAI sst: 3 of pdu session 1 rejected by the network, not available in the current PLMN"),
+	},
+	{
+		name:          "testNotAllowedSnssai",
+		sNssai:        &models.Snssai{Sst: 4},
+		expectedError: errors.New("S-NSSAI sst: 4 of pdu session 1 is not in the allowed NSSAI"),
+	},
+}
+
+func TestSelectPduSessionSnssai(t *testing.T) {
+	ueLogger := logger.NewUeLogger("error", "", true)
+
+	for _, testCase := range testSelectPduSessionSnssaiCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ue := &Ue{
+				registrationContext: registrationContext{
+					defaultConfiguredNssai: []models.Snssai{{Sst: 1, Sd: "abcdef"}},
+					t3512Restart:           make(chan struct{}, 1),
+				},
+				UeLogger: &ueLogger,
+			}
+
+			registrationAccept := nasMessage.NewRegistrationAccept(0)
+			registrationAccept.AllowedNSSAI = &nasType.AllowedNSSAI{
+				Iei:    nasMessage.RegistrationAcceptAllowedNSSAIType,
+				Len:    7,
+				Buffer: []uint8{0x04, 0x01, 0xab, 0xcd, 0xef, 0x01, 0x02},
+			}
+			registrationAccept.ConfiguredNSSAI = &nasType.ConfiguredNSSAI{
+				Iei:    nasMessage.RegistrationAcceptConfiguredNSSAIType,
+				Len:    9,
+				Buffer: []uint8{0x04, 0x01, 0xab, 0xcd, 0xef, 0x01, 0x02, 0x01, 0x03},
+			}
+			registrationAccept.RejectedNSSAI = &nasType.RejectedNSSAI{
+				Iei:    nasMessage.RegistrationAcceptRejectedNSSAIType,
+				Len:    2,
+				Buffer: []uint8{0x10, 0x03},
+			}
+			assert.Equal(t, nil, ue.applyRegistrationAccept(registrationAccept))
+			assert.Equal(t, []models.Snssai{{Sst: 1, Sd: "abcdef"}, {Sst: 2}}, ue.getRequestedNssai())
+
+			sNssai, err := ue.selectPduSessionSnssai(&pduSession{id: 1, sNssai: testCase.sNssai})
+			assert.Equal(t, testCase.expectedSnssai, sNssai)
+			assert.Equal(t, testCase.expectedError, err)
+		})
+	}
+}
+
+var testRequestedNssaiCases = []struct {
+	name                   string
+	defaultConfiguredNssai []models.Snssai
+	configuredNssai        []models.Snssai
+	expectedNssai          []models.Snssai
+	expectedIe             *nasType.RequestedNSSAI
+}{
+	{
+		name:                   "testDefaultConfiguredNssai",
+		defaultConfiguredNssai: []models.Snssai{{Sst: 1, Sd: "010203"}},
+		expectedNssai:          []models.Snssai{{Sst: 1, Sd: "010203"}},
+		expectedIe: &nasType.RequestedNSSAI{
+			Iei:    nasMessage.RegistrationRequestRequestedNSSAIType,
+			Len:    5,
+			Buffer: []uint8{0x04, 0x01, 0x01, 0x02, 0x03},
+		},
+	},
+	{
+		name:                   "testConfiguredNssaiOfNetwork",
+		defaultConfiguredNssai: []models.Snssai{{Sst: 1, Sd: "010203"}},
+		configuredNssai:        []models.Snssai{{Sst: 2}},
+		expectedNssai:          []models.Snssai{{Sst: 2}},
+		expectedIe: &nasType.RequestedNSSAI{
+			Iei:    nasMessage.RegistrationRequestRequestedNSSAIType,
+			Len:    2,
+			Buffer: []uint8{0x01, 0x02},
+		},
+	},
+	{
+		name:          "testNoConfiguredNssai",
+		expectedNssai: []models.Snssai{},
+		expectedIe:    nil,
+	},
+}
+
+func TestRequestedNssai(t *testing.T) {
+	for _, testCase := range testRequestedNssaiCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ue := &Ue{
+				registrationContext: registrationContext{
+					defaultConfiguredNssai: testCase.defaultConfiguredNssai,
+					configuredNssai:        testCase.configuredNssai,
+				},
+			}
+
+			requestedNssai := ue.getRequestedNssai()
+			assert.Equal(t, testCase.expectedNssai, requestedNssai)
+			assert.Equal(t, testCase.expectedIe, buildRequestedNssai(requestedNssai))
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package ue
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/model"
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi/models"
+)
+
+// pduSession is a PDU session of the UE with its own tunnel device, a TUN device of an IP session, a TAP device of an Ethernet session
+// or the socket of an Unstructured session, the data plane of a session without tunnel device is kept in user space
+type pduSession struct {
+	id             uint8
+	pduSessionType uint8
+	dnn            string
+	sNssai         *models.Snssai
+	onDemand       bool
+
+	ueTunnelDeviceName string
+	unstructuredSocket string
+	ueTunnelDevice     io.ReadWriteCloser
+
+	established bool
+	pduSessionEstablishmentAccept
+}
+
+// an IPv6 PDU session gets the interface identifier in the accept and the prefix in the router advertisement over the session
+type pduSessionEstablishmentAccept struct {
+	pduSessionType            uint8
+	ueIp                      string
+	ueIpv6InterfaceIdentifier [8]uint8
+	ueIpv6                    string
+	qosRule                   []uint8
+	dnn                       string
+	sst                       uint8
+	sd                        [3]uint8
+}
+
+func (a *pduSessionEstablishmentAccept) isIp() bool {
+	switch a.pduSessionType {
+	case nasMessage.PDUSessionTypeIPv4, nasMessage.PDUSessionTypeIPv6, nasMessage.PDUSessionTypeIPv4IPv6:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *pduSessionEstablishmentAccept) hasIpv6() bool {
+	return a.pduSessionType == nasMessage.PDUSessionTypeIPv6 || a.pduSessionType == nasMessage.PDUSessionTypeIPv4IPv6
+}
+
+// getUeIps lists the IPv4 and IPv6 addresses of the PDU session, the IPv6 link local address until the prefix is advertised
+func (a *pduSessionEstablishmentAccept) getUeIps() []string {
+	ueIps := make([]string, 0, 2)
+	if a.ueIp != "" {
+		ueIps = append(ueIps, a.ueIp)
+	}
+	if a.hasIpv6() {
+		if a.ueIpv6 != "" {
+			ueIps = append(ueIps, a.ueIpv6)
+		} else {
+			ueIps = append(ueIps, util.Ipv6LinkLocalAddress(a.ueIpv6InterfaceIdentifier).String())
+		}
+	}
+	return ueIps
+}
+
+func pduSessionTypeName(pduSessionType uint8) string {
+	switch pduSessionType {
+	case nasMessage.PDUSessionTypeIPv4:
+		return constant.PDU_SESSION_TYPE_IPV4
+	case nasMessage.PDUSessionTypeIPv6:
+		return constant.PDU_SESSION_TYPE_IPV6
+	case nasMessage.PDUSessionTypeIPv4IPv6:
+		return constant.PDU_SESSION_TYPE_IPV4V6
+	case nasMessage.PDUSessionTypeEthernet:
+		return constant.PDU_SESSION_TYPE_ETHERNET
+	case nasMessage.PDUSessionTypeUnstructured:
+		return constant.PDU_SESSION_TYPE_UNSTRUCTURED
+	default:
+		return fmt.Sprintf("unknown(%d)", pduSessionType)
+	}
+}
+
+// dataPlanePacket is a user plane packet of a PDU session between the tunnel devices and RAN
+type dataPlanePacket struct {
+	pduSessionId uint8
+	payload      []byte
+}
+
+// newPduSessions builds the PDU sessions of the config, the first session takes the tunnel device of the UE if it has none
+func newPduSessions(pduSessionIEs []model.PduSessionIE, ueTunnelDeviceName string) ([]*pduSession, error) {
+	pduSessions := make([]*pduSession, 0, len(pduSessionIEs))
+	for i, pduSessionIE := range pduSessionIEs {
+		// a session without S-NSSAI takes one of the allowed NSSAI on establishment
+		var sNssai *models.Snssai
+		if pduSessionIE.Snssai != (model.SnssaiIE{}) {
+			sstInt, err := strconv.Atoi(pduSessionIE.Snssai.Sst)
+			if err != nil {
+				return nil, fmt.Errorf("error converting sst of pdu session %d to int: %+v", pduSessionIE.Id, err)
+			}
+			sNssai = &models.Snssai{
+				Sst: int32(sstInt),
+				Sd:  pduSessionIE.Snssai.Sd,
+			}
+		}
+
+		tunnelDeviceName := pduSessionIE.UeTunnelDevice
+		if i == 0 && tunnelDeviceName == "" {
+			tunnelDeviceName = ueTunnelDeviceName
+		}
+
+		pduSessionType := nasMessage.PDUSessionTypeIPv4
+		switch pduSessionIE.Type {
+		case constant.PDU_SESSION_TYPE_IPV6:
+			pduSessionType = nasMessage.PDUSessionTypeIPv6
+		case constant.PDU_SESSION_TYPE_IPV4V6:
+			pduSessionType = nasMessage.PDUSessionTypeIPv4IPv6
+		case constant.PDU_SESSION_TYPE_ETHERNET:
+			pduSessionType = nasMessage.PDUSessionTypeEthernet
+		case constant.PDU_SESSION_TYPE_UNSTRUCTURED:
+			pduSessionType = nasMessage.PDUSessionTypeUnstructured
+			tunnelDeviceName = ""
+		}
+
+		pduSessions = append(pduSessions, &pduSession{
+			id:             pduSessionIE.Id,
+			pduSessionType: pduSessionType,
+			dnn:            pduSessionIE.Dnn,
+			sNssai:         sNssai,
+			onDemand:       pduSessionIE.OnDemand,
+
+			ueTunnelDeviceName: tunnelDeviceName,
+			unstructuredSocket: pduSessionIE.UnstructuredSocket,
+		})
+	}
+	return pduSessions, nil
+}
+
+func (u *Ue) getPduSession(pduSessionId uint8) (*pduSession, bool) {
+	u.pduSessionMtx.Lock()
+	defer u.pduSessionMtx.Unlock()
+
+	for _, session := range u.pduSessions {
+		if session.id == pduSessionId {
+			return session, true
+		}
+	}
+	return nil, false
+}
+
+// getUeTunnelDevice returns the tunnel device of an established PDU session, nil if its data plane is in user space
+func (u *Ue) getUeTunnelDevice(pduSessionId uint8) (io.ReadWriteCloser, bool) {
+	u.pduSessionMtx.Lock()
+	defer u.pduSessionMtx.Unlock()
+
+	for _, session := range u.pduSessions {
+		if session.id == pduSessionId && session.established {
+			return session.ueTunnelDevice, true
+		}
+	}
+	return nil, false
+}
+
+func (u *Ue) getPrimaryPduSessionId() uint8 {
+	u.pduSessionMtx.Lock()
+	defer u.pduSessionMtx.Unlock()
+
+	return u.primaryPduSessionId
+}
+
+// setPduSessionEstablished records the accept of the PDU session, the first established session is the primary one
+// whose QoS rules select the flows of NR-DC
+func (u *Ue) setPduSessionEstablished(session *pduSession, accept pduSessionEstablishmentAccept, specifiedFlow []string) {
+	u.pduSessionMtx.Lock()
+	defer u.pduSessionMtx.Unlock()
+
+	if u.primaryPduSessionId == 0 {
+		u.primaryPduSessionId = session.id
+		u.nrdc.specifiedFlow = append(u.nrdc.specifiedFlow, specifiedFlow...)
+	}
+	session.pduSessionEstablishmentAccept = accept
+	session.established = true
+}
+
+// setUeIpv6 records the IPv6 address formed from the advertised prefix, returns false if the session is not waiting for one
+func (u *Ue) setUeIpv6(pduSessionId uint8, ueIpv6 string) (*pduSession, bool) {
+	u.pduSessionMtx.Lock()
+	defer u.pduSessionMtx.Unlock()
+
+	for _, session := range u.pduSessions {
+		if session.id == pduSessionId && session.established && session.hasIpv6() && session.ueIpv6 == "" {
+			session.ueIpv6 = ueIpv6
+			return session, true
+		}
+	}
+	return nil, false
+}
+
+func (u *Ue) getUeIpv6(pduSessionId uint8) string {
+	u.pduSessionMtx.Lock()
+	defer u.pduSessionMtx.Unlock()
+
+	for _, session := range u.pduSessions {
+		if session.id == pduSessionId {
+			return session.ueIpv6
+		}
+	}
+	return ""
+}
+
+// setPduSessionReleased forgets the accept of the released PDU session, NR-DC loses its flows with the primary session
+func (u *Ue) setPduSessionReleased(session *pduSession) {
+	u.pduSessionMtx.Lock()
+	defer u.pduSessionMtx.Unlock()
+
+	if u.primaryPduSessionId == session.id {
+		u.primaryPduSessionId = 0
+		u.nrdc.specifiedFlow = nil
+	}
+	session.pduSessionEstablishmentAccept = pduSessionEstablishmentAccept{}
+	session.established = false
+}
+
+// setPduSessionQosRule replaces the QoS rules of the PDU session with the authorized ones of a modification command,
+// the flows of NR-DC follow the rules of the primary session
+func (u *Ue) setPduSessionQosRule(session *pduSession, qosRule []uint8) {
+	u.pduSessionMtx.Lock()
+	defer u.pduSessionMtx.Unlock()
+
+	session.qosRule = qosRule
+	if !session.isIp() {
+		return
+	}
+
+	specifiedFlow := util.GetQosRule(qosRule, u.UeLogger)
+	u.PduLog.Infof("PDU session %d QoS rule: %+v", session.id, specifiedFlow)
+	if u.primaryPduSessionId == session.id {
+		u.nrdc.specifiedFlow = specifiedFlow
+	}
+}
+
+func (u *Ue) setUeTunnelDevice(session *pduSession, ueTunnelDevice io.ReadWriteCloser) {
+	u.pduSessionMtx.Lock()
+	defer u.pduSessionMtx.Unlock()
+
+	session.ueTunnelDevice = ueTunnelDevice
+}
+
+// getPduSessionSummary lists the UE IPs of each established PDU session, or the type of a session without UE IP,
+// e.g. 1:10.60.0.1, 2:10.61.0.1/2001:db8::1, 3:Ethernet
+func (u *Ue) getPduSessionSummary() string {
+	u.pduSessionMtx.Lock()
+	defer u.pduSessionMtx.Unlock()
+
+	summary := make([]string, 0, len(u.pduSessions))
+	for _, session := range u.pduSessions {
+		if !session.established {
+			continue
+		}
+		if session.isIp() {
+			summary = append(summary, fmt.Sprintf("%d:%s", session.id, strings.Join(session.getUeIps(), "/")))
+		} else {
+			summary = append(summary, fmt.Sprintf("%d:%s", session.id, pduSessionTypeName(session.pduSessionEstablishmentAccept.pduSessionType)))
+		}
+	}
+	return strings.Join(summary, ", ")
+}
+
+// receiveDedicatedNas waits under the NAS timer for the dedicated nas of an rrc reconfiguration handled by waitForRanMessage,
+// or for the DL NAS transport of a 5GSM message without one, e.g. a reject
+func (u *Ue) receiveDedicatedNas(timer nasTimer) ([]byte, error) {
+	select {
+	case dedicatedNas := <-u.dedicatedNas:
+		return dedicatedNas, nil
+	case <-time.After(timer.duration):
+		return nil, &nasTimerExpiredError{timer: timer, expiries: 1}
+	}
+}
+
+// EstablishPduSession establishes a PDU session of the config after the UE is started, e.g. an on demand session,
+// and brings up its data plane
+func (u *Ue) EstablishPduSession(pduSessionId uint8) error {
+	u.pduSessionProcedureMtx.Lock()
+	defer u.pduSessionProcedureMtx.Unlock()
+
+	if !u.registered.Load() {
+		return fmt.Errorf("UE is not registered")
+	}
+
+	session, exists := u.getPduSession(pduSessionId)
+	if !exists {
+		return fmt.Errorf("no pdu session %d in config", pduSessionId)
+	}
+	if _, established := u.getUeTunnelDevice(pduSessionId); established {
+		return fmt.Errorf("pdu session %d is already established", pduSessionId)
+	}
+	if err := u.resumeRrcConnection(protocol.RRC_ESTABLISHMENT_CAUSE_MO_SIGNALLING); err != nil {
+		return fmt.Errorf("error resume rrc connection: %+v", err)
+	}
+
+	if err := u.processPduSessionEstablishment(session, u.receiveDedicatedNas); err != nil {
+		return fmt.Errorf("error process pdu session %d establishment: %+v", pduSessionId, err)
+	}
+
+	if err := u.setupPduSessionTunnelDevice(session); err != nil {
+		return fmt.Errorf("error set up tunnel device of pdu session %d: %+v", pduSessionId, err)
+	}
+	return nil
+}
+
+// ReleasePduSession releases an established PDU session while the UE stays registered and tears down its data plane
+func (u *Ue) ReleasePduSession(pduSessionId uint8) error {
+	u.pduSessionProcedureMtx.Lock()
+	defer u.pduSessionProcedureMtx.Unlock()
+
+	session, err := u.getEstablishedPduSession(pduSessionId)
+	if err != nil {
+		return err
+	}
+	if err := u.resumeRrcConnection(protocol.RRC_ESTABLISHMENT_CAUSE_MO_SIGNALLING); err != nil {
+		return fmt.Errorf("error resume rrc connection: %+v", err)
+	}
+
+	if err := u.processPduSessionRelease(session); err != nil {
+		return fmt.Errorf("error process pdu session %d release: %+v", pduSessionId, err)
+	}
+	return nil
+}
+
+// ModifyPduSession requests the network to modify an established PDU session, e.g. to refresh its QoS rules
+func (u *Ue) ModifyPduSession(pduSessionId uint8) error {
+	u.pduSessionProcedureMtx.Lock()
+	defer u.pduSessionProcedureMtx.Unlock()
+
+	session, err := u.getEstablishedPduSession(pduSessionId)
+	if err != nil {
+		return err
+	}
+	if err := u.resumeRrcConnection(protocol.RRC_ESTABLISHMENT_CAUSE_MO_SIGNALLING); err != nil {
+		return fmt.Errorf("error resume rrc connection: %+v", err)
+	}
+
+	if err := u.processPduSessionModification(session); err != nil {
+		return fmt.Errorf("error process pdu session %d modification: %+v", pduSessionId, err)
+	}
+	return nil
+}
+
+func (u *Ue) getEstablishedPduSession(pduSessionId uint8) (*pduSession, error) {
+	session, exists := u.getPduSession(pduSessionId)
+	if !exists {
+		return nil, fmt.Errorf("no pdu session %d in config", pduSessionId)
+	}
+	if _, established := u.getUeTunnelDevice(pduSessionId); !established {
+		return nil, fmt.Errorf("pdu session %d is not established", pduSessionId)
+	}
+	return session, nil
+}
+
+// allocatePti returns the next procedure transaction identity of a UE requested pdu session procedure,
+// cycling through 1 to 254 as 0 and 255 are reserved, the caller holds pduSessionProcedureMtx
+func (u *Ue) allocatePti() uint8 {
+	u.pti = u.pti%254 + 1
+	return u.pti
+}
@@ -0,0 +1,103 @@
+package ue
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/protocol"
+)
+
+// ranControlPlaneReader is the only reader of a RAN control plane connection, it reads whole frames and delivers them
+// over a channel, so a consumer giving up on a read, e.g. on cancellation or the expiry of a NAS timer, never leaves
+// a frame half read and the next consumer gets the next frame intact
+type ranControlPlaneReader struct {
+	messages chan *protocol.Message
+	// the error ending the reader, set before messages is closed
+	err error
+
+	// the read deadline of the consumers, zero for none
+	deadline    time.Time
+	deadlineMtx sync.Mutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newRanControlPlaneReader(conn net.Conn) *ranControlPlaneReader {
+	r := &ranControlPlaneReader{
+		messages: make(chan *protocol.Message),
+
+		deadlineMtx: sync.Mutex{},
+
+		stop:     make(chan struct{}),
+		stopOnce: sync.Once{},
+	}
+	go r.run(conn)
+	return r
+}
+
+func (r *ranControlPlaneReader) run(conn net.Conn) {
+	defer close(r.messages)
+	for {
+		message, err := protocol.ReadMessage(conn)
+		if err != nil {
+			r.err = err
+			return
+		}
+
+		select {
+		case r.messages <- message:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// close stops delivering the frames, the connection is closed by the caller
+func (r *ranControlPlaneReader) close() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+func (r *ranControlPlaneReader) setDeadline(deadline time.Time) {
+	r.deadlineMtx.Lock()
+	defer r.deadlineMtx.Unlock()
+
+	r.deadline = deadline
+}
+
+func (r *ranControlPlaneReader) getDeadline() time.Time {
+	r.deadlineMtx.Lock()
+	defer r.deadlineMtx.Unlock()
+
+	return r.deadline
+}
+
+// receive waits for the next frame until the read deadline or the cancellation of ctx
+func (r *ranControlPlaneReader) receive(ctx context.Context) (*protocol.Message, error) {
+	var expiry <-chan time.Time
+	if deadline := r.getDeadline(); !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expiry = timer.C
+	}
+
+	select {
+	case message, ok := <-r.messages:
+		if !ok {
+			if r.err != nil {
+				return nil, r.err
+			}
+			return nil, net.ErrClosed
+		}
+		return message, nil
+	case <-expiry:
+		return nil, os.ErrDeadlineExceeded
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
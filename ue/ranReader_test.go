@@ -0,0 +1,77 @@
+package ue
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/go-playground/assert"
+)
+
+var testRanControlPlaneReaderCases = []struct {
+	name    string
+	payload []byte
+	split   int
+	giveUp  func(*ranControlPlaneReader) (context.Context, context.CancelFunc)
+	giveErr error
+}{
+	{
+		name:    "testCancelMidFrame",
+		payload: []byte{0x7e, 0x00, 0x41, 0x01, 0x02, 0x03},
+		split:   3,
+		giveUp: func(r *ranControlPlaneReader) (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		},
+		giveErr: context.DeadlineExceeded,
+	},
+	{
+		name:    "testDeadlineMidFrame",
+		payload: []byte{0x7e, 0x00, 0x56, 0x01},
+		split:   5,
+		giveUp: func(r *ranControlPlaneReader) (context.Context, context.CancelFunc) {
+			r.setDeadline(time.Now().Add(50 * time.Millisecond))
+			return context.Background(), func() {
+				r.setDeadline(time.Time{})
+			}
+		},
+		giveErr: os.ErrDeadlineExceeded,
+	},
+}
+
+func TestRanControlPlaneReader(t *testing.T) {
+	for _, testCase := range testRanControlPlaneReaderCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ranConn, ueConn := net.Pipe()
+			defer ranConn.Close()
+			defer ueConn.Close()
+
+			reader := newRanControlPlaneReader(ueConn)
+			defer reader.close()
+
+			frame, err := protocol.NewMessage(protocol.MESSAGE_TYPE_NAS, testCase.payload).Marshal()
+			assert.Equal(t, nil, err)
+
+			// only a part of the frame arrives before the consumer gives up
+			if _, err := ranConn.Write(frame[:testCase.split]); err != nil {
+				t.Fatalf("error write first part of frame: %v", err)
+			}
+			ctx, cancel := testCase.giveUp(reader)
+			_, err = reader.receive(ctx)
+			cancel()
+			assert.Equal(t, true, errors.Is(err, testCase.giveErr))
+
+			// the rest of the frame completes it for the next consumer
+			if _, err := ranConn.Write(frame[testCase.split:]); err != nil {
+				t.Fatalf("error write rest of frame: %v", err)
+			}
+			message, err := reader.receive(context.Background())
+			assert.Equal(t, nil, err)
+			assert.Equal(t, protocol.MESSAGE_TYPE_NAS, message.Type)
+			assert.Equal(t, testCase.payload, message.Payload)
+		})
+	}
+}
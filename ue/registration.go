@@ -0,0 +1,351 @@
+package ue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasConvert"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi/models"
+)
+
+// registrationContext keeps what the network assigned in the last registration accept,
+// the 5G-GUTI is kept after deregistration and identifies the UE in its next registration
+type registrationContext struct {
+	guti5G       []byte
+	taiList      []models.Tai
+	allowedNssai []models.Snssai
+	t3512        time.Duration
+
+	// the configured NSSAI of the network replaces the default one of the config, and the rejected NSSAI is the one of the last accept
+	defaultConfiguredNssai []models.Snssai
+	configuredNssai        []models.Snssai
+	rejectedNssai          []util.RejectedSnssai
+
+	// the registration accept or reject of a registration update, received by waitForRanMessage while the update waits for it
+	registrationNas chan *nas.Message
+	// T3512 restarts with each accepted registration
+	t3512Restart chan struct{}
+
+	registrationProcedureMtx sync.Mutex
+	registrationContextMtx   sync.Mutex
+}
+
+func registrationTypeName(registrationType uint8) string {
+	switch registrationType {
+	case nasMessage.RegistrationType5GSInitialRegistration:
+		return "initial registration"
+	case nasMessage.RegistrationType5GSMobilityRegistrationUpdating:
+		return "mobility registration updating"
+	case nasMessage.RegistrationType5GSPeriodicRegistrationUpdating:
+		return "periodic registration updating"
+	default:
+		return fmt.Sprintf("registration type %d", registrationType)
+	}
+}
+
+func (u *Ue) getGuti5G() []byte {
+	u.registrationContextMtx.Lock()
+	defer u.registrationContextMtx.Unlock()
+
+	if u.guti5G == nil {
+		return nil
+	}
+	return append([]byte{}, u.guti5G...)
+}
+
+func (u *Ue) setGuti5G(guti5G [11]uint8) {
+	u.registrationContextMtx.Lock()
+	defer u.registrationContextMtx.Unlock()
+
+	u.guti5G = append([]byte{}, guti5G[:]...)
+	_, guti := nasConvert.GutiToString(u.guti5G)
+	u.NasLog.Infof("5G-GUTI: %s", guti)
+}
+
+func (u *Ue) getT3512() time.Duration {
+	u.registrationContextMtx.Lock()
+	defer u.registrationContextMtx.Unlock()
+
+	return u.t3512
+}
+
+// isTrackingAreaRegistered tells if the TAC of the PLMN of the UE is in the TAI list of the last registration accept
+func (u *Ue) isTrackingAreaRegistered(tac uint32) bool {
+	u.registrationContextMtx.Lock()
+	defer u.registrationContextMtx.Unlock()
+
+	for _, tai := range u.taiList {
+		if tai.PlmnId.Mcc == u.mcc && tai.PlmnId.Mnc == u.mnc && tai.Tac == fmt.Sprintf("%06x", tac) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRegistrationAccept stores the 5G-GUTI, TAI list, allowed, configured and rejected NSSAI and T3512 of the registration accept
+// and restarts T3512, an IE left out of the accept keeps the stored value except T3512 which takes the default of TS 24.501 10.2
+// and the rejected NSSAI which is cleared
+func (u *Ue) applyRegistrationAccept(registrationAccept *nasMessage.RegistrationAccept) error {
+	var taiList []models.Tai
+	if registrationAccept.TAIList != nil {
+		var err error
+		if taiList, err = util.TaiListToModels(registrationAccept.TAIList.GetPartialTrackingAreaIdentityList()); err != nil {
+			return fmt.Errorf("error decode tai list: %+v", err)
+		}
+	}
+	var allowedNssai []models.Snssai
+	if registrationAccept.AllowedNSSAI != nil {
+		var err error
+		if allowedNssai, err = util.NssaiToModels(registrationAccept.AllowedNSSAI.GetSNSSAIValue()); err != nil {
+			return fmt.Errorf("error decode allowed nssai: %+v", err)
+		}
+	}
+	var configuredNssai []models.Snssai
+	if registrationAccept.ConfiguredNSSAI != nil {
+		var err error
+		if configuredNssai, err = util.NssaiToModels(registrationAccept.ConfiguredNSSAI.GetSNSSAIValue()); err != nil {
+			return fmt.Errorf("error decode configured nssai: %+v", err)
+		}
+	}
+	var rejectedNssai []util.RejectedSnssai
+	if registrationAccept.RejectedNSSAI != nil {
+		var err error
+		if rejectedNssai, err = util.RejectedNssaiToModels(registrationAccept.RejectedNSSAI.GetRejectedNSSAIContents()); err != nil {
+			return fmt.Errorf("error decode rejected nssai: %+v", err)
+		}
+	}
+	t3512 := constant.UE_DEFAULT_T3512
+	if registrationAccept.T3512Value != nil {
+		t3512 = util.GprsTimer3ToDuration(registrationAccept.T3512Value.GetUnit(), registrationAccept.T3512Value.GetTimerValue())
+	}
+
+	if registrationAccept.GUTI5G != nil {
+		u.setGuti5G(registrationAccept.GUTI5G.Octet)
+	}
+
+	u.registrationContextMtx.Lock()
+	if taiList != nil {
+		u.taiList = taiList
+	}
+	if allowedNssai != nil {
+		u.allowedNssai = allowedNssai
+	}
+	if configuredNssai != nil {
+		u.configuredNssai = configuredNssai
+	}
+	u.rejectedNssai = rejectedNssai
+	u.t3512 = t3512
+	u.registrationContextMtx.Unlock()
+
+	for _, tai := range taiList {
+		u.NasLog.Infof("Registered TAI, PLMN: %s%s, TAC: %s", tai.PlmnId.Mcc, tai.PlmnId.Mnc, tai.Tac)
+	}
+	for _, snssai := range allowedNssai {
+		u.NasLog.Infof("Allowed S-NSSAI, %s", snssaiToString(snssai))
+	}
+	for _, snssai := range configuredNssai {
+		u.NasLog.Debugf("Configured S-NSSAI, %s", snssaiToString(snssai))
+	}
+	for _, rejectedSnssai := range rejectedNssai {
+		u.NasLog.Warnf("Rejected S-NSSAI, %s, %s", snssaiToString(rejectedSnssai.Snssai), rejectedSnssaiCauseToString(rejectedSnssai.Cause))
+	}
+	if t3512 == 0 {
+		u.NasLog.Infoln("T3512 deactivated")
+	} else {
+		u.NasLog.Infof("T3512: %v", t3512)
+	}
+
+	select {
+	case u.t3512Restart <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// processRegistrationUpdate registers the UE again with its 5G-GUTI in RRC_CONNECTED, e.g. a mobility registration update
+// for a tracking area outside the TAI list or a periodic registration update on the expiry of T3512,
+// and the registration complete acknowledges a 5G-GUTI reallocated by the accept
+func (u *Ue) processRegistrationUpdate(registrationType uint8) error {
+	u.registrationProcedureMtx.Lock()
+	defer u.registrationProcedureMtx.Unlock()
+
+	u.NasLog.Infof("Processing %s", registrationTypeName(registrationType))
+
+	if !u.registered.Load() {
+		return fmt.Errorf("UE is not registered")
+	}
+	if err := u.resumeRrcConnection(protocol.RRC_ESTABLISHMENT_CAUSE_MO_SIGNALLING); err != nil {
+		return fmt.Errorf("error resume rrc connection: %+v", err)
+	}
+	if rrcState := u.getRrcState(); rrcState != protocol.RRC_STATE_CONNECTED {
+		return fmt.Errorf("registration update in %s is not supported", rrcState)
+	}
+	guti5G := u.getGuti5G()
+	if guti5G == nil {
+		return fmt.Errorf("no 5G-GUTI assigned to the UE")
+	}
+	mobileIdentity5GS := buildGutiMobileIdentity5GS(guti5G)
+	ueSecurityCapability := u.getUeSecurityCapability()
+
+	// the accept or reject received by waitForRanMessage is handed to the update until it returns
+	u.setRegistrationNasWaiting(true)
+	defer u.setRegistrationNasWaiting(false)
+
+	// send registration request
+	registrationRequest, err := getUeRegistrationRequest(registrationType, u.ngKsi, &mobileIdentity5GS, buildRequestedNssai(u.getRequestedNssai()), &ueSecurityCapability, u.get5GmmCapability(), nil, nil)
+	if err != nil {
+		return fmt.Errorf("error get ue registration request: %+v", err)
+	}
+	u.NasLog.Tracef("Registration request: %+v", registrationRequest)
+
+	if err := u.sendSecuredNas(registrationRequest); err != nil {
+		return fmt.Errorf("error send ue registration request: %+v", err)
+	}
+	u.NasLog.Debugln("Send UE registration request to RAN")
+
+	// receive registration accept
+	var nasPdu *nas.Message
+	select {
+	case nasPdu = <-u.registrationNas:
+	case <-time.After(u.nasRetry.t3510.duration):
+		return &nasTimerExpiredError{timer: u.nasRetry.t3510, expiries: 1}
+	}
+
+	switch nasPdu.GmmHeader.GetMessageType() {
+	case nas.MsgTypeRegistrationAccept:
+		u.NasLog.Debugln("Receive NAS Registration Accept from RAN")
+	case nas.MsgTypeRegistrationReject:
+		// a cause deleting the 5G-GUTI also ends the registration of the UE
+		reject := newRegistrationRejectError(nasPdu.RegistrationReject)
+		u.handleRegistrationReject(reject)
+		if isRegistrationContextDeletedBy(reject.cause) {
+			u.registered.Store(false)
+			u.NasLog.Warnln("UE deregistered by the registration reject")
+		}
+		return reject
+	default:
+		return fmt.Errorf("error nas pdu message type: %+v, expected registration accept", nasPdu.GmmHeader.GetMessageType())
+	}
+	u.NasLog.Tracef("NAS registration accept: %+v", nasPdu.RegistrationAccept)
+
+	if err := u.applyRegistrationAccept(nasPdu.RegistrationAccept); err != nil {
+		return fmt.Errorf("error apply registration accept: %+v", err)
+	}
+
+	// send registration complete
+	if nasPdu.RegistrationAccept.GUTI5G != nil {
+		nasRegistrationCompleteMessage, err := getNasRegistrationCompleteMessage(nil)
+		if err != nil {
+			return fmt.Errorf("error get nas registration complete message: %+v", err)
+		}
+		u.NasLog.Tracef("NAS registration complete message: %+v", nasRegistrationCompleteMessage)
+
+		if err := u.sendSecuredNas(nasRegistrationCompleteMessage); err != nil {
+			return fmt.Errorf("error send nas registration complete message: %+v", err)
+		}
+		u.NasLog.Debugln("Send NAS Registration Complete Message to RAN")
+	}
+
+	u.saveState()
+	u.NasLog.Infof("UE %s %s finished", u.supi, registrationTypeName(registrationType))
+	return nil
+}
+
+// processUeRegistrationWithRetry connects to RAN and registers the UE, a failed registration is attempted again as in
+// TS 24.501 5.5.1.2.7 up to the registration attempts of the retry policy, right away with the SUCI after a reject of the
+// 5G-GUTI, on the expiry of T3346 of a congested network or after T3511 otherwise, and a cause barring the UE gives up
+func (u *Ue) processUeRegistrationWithRetry(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		u.setRegistrationAttempts(attempt)
+
+		err := u.attemptUeRegistration()
+		if err == nil {
+			return nil
+		}
+
+		wait := u.nasRetry.registrationInterval
+		var reject *registrationRejectError
+		if errors.As(err, &reject) {
+			switch u.handleRegistrationReject(reject) {
+			case registrationRejectGiveUp:
+				return err
+			case registrationRejectRetryWithSuci:
+				wait = 0
+			case registrationRejectBackoff:
+				if reject.t3346 > u.nasRetry.maxBackoff {
+					return fmt.Errorf("%+v, longer than max backoff %v", err, u.nasRetry.maxBackoff)
+				}
+				wait = reject.t3346
+			}
+		}
+		if attempt >= u.nasRetry.registrationAttempts {
+			return fmt.Errorf("registration failed %d attempts, last error: %+v", attempt, err)
+		}
+		u.NasLog.Warnf("Registration attempt %d failed: %+v, attempting again in %v", attempt, err, wait)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("registration cancelled after %d attempts: %+v", attempt, err)
+		case <-time.After(wait):
+		}
+	}
+}
+
+// attemptUeRegistration connects to RAN and registers the UE under T3510, a failed attempt closes the connection
+// and leaves the UE in RRC_IDLE without AS security for the next one
+func (u *Ue) attemptUeRegistration() error {
+	if err := u.connectToRanControlPlane(); err != nil {
+		return fmt.Errorf("error connect to RAN: %+v", err)
+	}
+
+	err := u.withNasTimer(u.nasRetry.t3510, u.processUeRegistration)
+	if err == nil {
+		return nil
+	}
+
+	if err := u.closeRanControlPlane(); err != nil {
+		u.RanLog.Errorf("Error closing RAN connection: %v", err)
+	}
+	u.setAsSecurityContext(nil)
+	u.setRrcState(protocol.RRC_STATE_IDLE)
+	return err
+}
+
+// runPeriodicRegistrationUpdate starts the periodic registration update on the expiry of T3512,
+// the timer restarts with each accepted registration and a deactivated T3512 waits for the next one
+func (u *Ue) runPeriodicRegistrationUpdate(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	defer wg.Done()
+
+	for {
+		var expiry <-chan time.Time
+		var timer *time.Timer
+		if t3512 := u.getT3512(); t3512 > 0 {
+			timer = time.NewTimer(t3512)
+			expiry = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-u.t3512Restart:
+		case <-expiry:
+			u.NasLog.Infoln("T3512 expired")
+			if err := u.processRegistrationUpdate(nasMessage.RegistrationType5GSPeriodicRegistrationUpdating); err != nil {
+				u.NasLog.Warnf("Error process periodic registration update: %+v", err)
+			}
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
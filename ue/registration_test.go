@@ -0,0 +1,100 @@
+package ue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/logger"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/nasType"
+	"github.com/go-playground/assert"
+)
+
+var testApplyRegistrationAcceptCases = []struct {
+	name                   string
+	guti5G                 *nasType.GUTI5G
+	taiList                *nasType.TAIList
+	t3512Value             *nasType.T3512Value
+	expectedGuti5G         []byte
+	expectedT3512          time.Duration
+	expectedRegisteredTacs map[uint32]bool
+}{
+	{
+		name: "testGutiTaiListAndT3512",
+		guti5G: &nasType.GUTI5G{
+			Iei:   nasMessage.RegistrationAcceptGUTI5GType,
+			Len:   11,
+			Octet: [11]uint8{0xf2, 0x02, 0xf8, 0x39, 0xca, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x01},
+		},
+		taiList: &nasType.TAIList{
+			Iei:    nasMessage.RegistrationAcceptTAIListType,
+			Len:    10,
+			Buffer: []uint8{0x01, 0x02, 0xf8, 0x39, 0x00, 0x00, 0x01, 0x00, 0x00, 0x02},
+		},
+		t3512Value: &nasType.T3512Value{
+			Iei:   nasMessage.RegistrationAcceptT3512ValueType,
+			Len:   1,
+			Octet: 0x82,
+		},
+		expectedGuti5G: []byte{0xf2, 0x02, 0xf8, 0x39, 0xca, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x01},
+		expectedT3512:  60 * time.Second,
+		expectedRegisteredTacs: map[uint32]bool{
+			1: true,
+			2: true,
+			3: false,
+		},
+	},
+	{
+		name:           "testDefaultT3512",
+		expectedGuti5G: nil,
+		expectedT3512:  constant.UE_DEFAULT_T3512,
+		expectedRegisteredTacs: map[uint32]bool{
+			1: false,
+		},
+	},
+	{
+		name: "testDeactivatedT3512",
+		t3512Value: &nasType.T3512Value{
+			Iei:   nasMessage.RegistrationAcceptT3512ValueType,
+			Len:   1,
+			Octet: 0xe0,
+		},
+		expectedGuti5G: nil,
+		expectedT3512:  0,
+		expectedRegisteredTacs: map[uint32]bool{
+			1: false,
+		},
+	},
+}
+
+func TestApplyRegistrationAccept(t *testing.T) {
+	ueLogger := logger.NewUeLogger("error", "", true)
+
+	for _, testCase := range testApplyRegistrationAcceptCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ue := &Ue{
+				mcc: "208",
+				mnc: "93",
+				registrationContext: registrationContext{
+					t3512Restart: make(chan struct{}, 1),
+				},
+				UeLogger: &ueLogger,
+			}
+
+			registrationAccept := nasMessage.NewRegistrationAccept(0)
+			registrationAccept.GUTI5G = testCase.guti5G
+			registrationAccept.TAIList = testCase.taiList
+			registrationAccept.T3512Value = testCase.t3512Value
+
+			err := ue.applyRegistrationAccept(registrationAccept)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, testCase.expectedGuti5G, ue.getGuti5G())
+			assert.Equal(t, testCase.expectedT3512, ue.getT3512())
+			assert.Equal(t, 1, len(ue.t3512Restart))
+			for tac, registered := range testCase.expectedRegisteredTacs {
+				assert.Equal(t, registered, ue.isTrackingAreaRegistered(tac))
+			}
+		})
+	}
+}
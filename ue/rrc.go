@@ -0,0 +1,538 @@
+package ue
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/security"
+)
+
+// the ue identity in RRCSetupRequest is a 39 bits random value as in TS 38.331
+const rrcUeIdentityMask uint64 = 1<<39 - 1
+
+type rrc struct {
+	rrcState          protocol.RrcState
+	rrcUeIdentity     uint64
+	asSecurityContext *protocol.AsSecurityContext
+
+	// the cell selected in RRCSetupRequest, none to camp on the cell of the RAN listener
+	selectedNrCellIdentity uint64
+	servingNrCellIdentity  uint64
+	servingTac             uint32
+
+	// the resume identity of RRCRelease with suspend, rrcResumed is closed once the suspended UE is resumed
+	resumeIdentity     uint64
+	rrcResumeRequested bool
+	rrcResumed         chan struct{}
+
+	rrcMtx sync.Mutex
+}
+
+func (u *Ue) getRrcState() protocol.RrcState {
+	u.rrcMtx.Lock()
+	defer u.rrcMtx.Unlock()
+
+	return u.rrcState
+}
+
+func (u *Ue) setRrcState(rrcState protocol.RrcState) {
+	u.rrcMtx.Lock()
+	defer u.rrcMtx.Unlock()
+
+	if u.rrcState != rrcState {
+		u.RanLog.Infof("RRC state %s -> %s", u.rrcState, rrcState)
+	}
+	u.rrcState = rrcState
+}
+
+// suspendRrc moves the UE to RRC_INACTIVE on RRCRelease with suspend, the AS security context and the data radio bearers are kept
+func (u *Ue) suspendRrc(resumeIdentity uint64) {
+	u.rrcMtx.Lock()
+	defer u.rrcMtx.Unlock()
+
+	if u.rrcState != protocol.RRC_STATE_INACTIVE {
+		u.RanLog.Infof("RRC state %s -> %s", u.rrcState, protocol.RRC_STATE_INACTIVE)
+	}
+	u.rrcState = protocol.RRC_STATE_INACTIVE
+	u.resumeIdentity = resumeIdentity
+	u.rrcResumeRequested = false
+	u.rrcResumed = make(chan struct{})
+}
+
+func (u *Ue) getServingCell() (uint64, uint32) {
+	u.rrcMtx.Lock()
+	defer u.rrcMtx.Unlock()
+
+	return u.servingNrCellIdentity, u.servingTac
+}
+
+func (u *Ue) setServingCell(nrCellIdentity uint64, tac uint32) {
+	u.rrcMtx.Lock()
+	defer u.rrcMtx.Unlock()
+
+	u.servingNrCellIdentity, u.servingTac = nrCellIdentity, tac
+}
+
+func (u *Ue) getAsSecurityContext() *protocol.AsSecurityContext {
+	u.rrcMtx.Lock()
+	defer u.rrcMtx.Unlock()
+
+	return u.asSecurityContext
+}
+
+func (u *Ue) setAsSecurityContext(asSecurityContext *protocol.AsSecurityContext) {
+	u.rrcMtx.Lock()
+	defer u.rrcMtx.Unlock()
+
+	u.asSecurityContext = asSecurityContext
+}
+
+func (u *Ue) sendRrcToRan(rrcMessage *protocol.RrcMessage) (int, error) {
+	payload, err := rrcMessage.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	return u.sendToRan(protocol.MESSAGE_TYPE_RRC, payload)
+}
+
+func (u *Ue) receiveRrcFromRan(rrcMessageType protocol.RrcMessageType) (*protocol.RrcMessage, error) {
+	payload, err := u.receiveFromRan(protocol.MESSAGE_TYPE_RRC)
+	if err != nil {
+		return nil, err
+	}
+
+	rrcMessage := &protocol.RrcMessage{}
+	if err := rrcMessage.Unmarshal(payload); err != nil {
+		return nil, err
+	}
+	if rrcMessage.Type != rrcMessageType {
+		return nil, fmt.Errorf("unexpected rrc message %s, expected %s", rrcMessage.Type, rrcMessageType)
+	}
+
+	return rrcMessage, nil
+}
+
+// processRrcConnectionSetup brings the UE to RRC_CONNECTED, the initial nas message is carried in RRCSetupComplete
+func (u *Ue) processRrcConnectionSetup(initialNas []byte, establishmentCause protocol.RrcEstablishmentCause) error {
+	u.RanLog.Infoln("Processing RRC connection setup")
+
+	u.rrcUeIdentity = rand.Uint64() & rrcUeIdentityMask
+	n, err := u.sendRrcToRan(&protocol.RrcMessage{
+		Type:               protocol.RRC_SETUP_REQUEST,
+		UeIdentity:         u.rrcUeIdentity,
+		EstablishmentCause: establishmentCause,
+		NrCellIdentity:     u.selectedNrCellIdentity,
+	})
+	if err != nil {
+		return fmt.Errorf("error send rrc setup request: %+v", err)
+	}
+	u.RanLog.Tracef("Sent %d bytes of RRC Setup Request to RAN", n)
+	u.RanLog.Debugf("Send RRC Setup Request to RAN, ue identity: %x", u.rrcUeIdentity)
+
+	rrcSetup, err := u.receiveRrcFromRan(protocol.RRC_SETUP)
+	if err != nil {
+		return fmt.Errorf("error read rrc setup: %+v", err)
+	}
+	u.RanLog.Debugln("Receive RRC Setup from RAN")
+
+	u.setServingCell(rrcSetup.NrCellIdentity, rrcSetup.Tac)
+	u.RanLog.Infof("Camped on cell %09x, TAC %06x", rrcSetup.NrCellIdentity, rrcSetup.Tac)
+
+	n, err = u.sendRrcToRan(&protocol.RrcMessage{
+		Type:          protocol.RRC_SETUP_COMPLETE,
+		TransactionId: rrcSetup.TransactionId,
+		DedicatedNas:  initialNas,
+	})
+	if err != nil {
+		return fmt.Errorf("error send rrc setup complete: %+v", err)
+	}
+	u.RanLog.Tracef("Sent %d bytes of RRC Setup Complete to RAN", n)
+	u.RanLog.Debugln("Send RRC Setup Complete to RAN")
+
+	u.setRrcState(protocol.RRC_STATE_CONNECTED)
+	return nil
+}
+
+// processRrcSecurityMode activates AS security with KgNB, the algorithms in SecurityModeCommand are read
+// before it is verified with the keys derived from them, and ciphering starts after SecurityModeComplete as in TS 38.331 5.3.4,
+// the security mode command is read from RAN during registration and passed in
+func (u *Ue) processRrcSecurityMode(message *protocol.Message) error {
+	u.RanLog.Infoln("Processing RRC security mode")
+
+	if message.Type == protocol.MESSAGE_TYPE_REJECT {
+		return fmt.Errorf("rejected by RAN, cause: %s", protocol.CauseFromPayload(message.Payload))
+	}
+
+	unverifiedMessage, err := protocol.UnverifiedMessage(message)
+	if err != nil {
+		return fmt.Errorf("error read rrc security mode command: %+v", err)
+	}
+	if unverifiedMessage.Type != protocol.MESSAGE_TYPE_RRC {
+		return fmt.Errorf("unexpected message type %s, expected %s", unverifiedMessage.Type, protocol.MESSAGE_TYPE_RRC)
+	}
+	rrcSecurityModeCommand := &protocol.RrcMessage{}
+	if err := rrcSecurityModeCommand.Unmarshal(unverifiedMessage.Payload); err != nil {
+		return fmt.Errorf("error unmarshal rrc security mode command: %+v", err)
+	}
+	if rrcSecurityModeCommand.Type != protocol.RRC_SECURITY_MODE_COMMAND {
+		return fmt.Errorf("unexpected rrc message %s, expected %s", rrcSecurityModeCommand.Type, protocol.RRC_SECURITY_MODE_COMMAND)
+	}
+	u.RanLog.Debugf("Receive RRC Security Mode Command from RAN, NEA%d, NIA%d", rrcSecurityModeCommand.CipheringAlgorithm, rrcSecurityModeCommand.IntegrityAlgorithm)
+
+	asSecurityContext, err := protocol.NewAsSecurityContext(u.kGnb, rrcSecurityModeCommand.CipheringAlgorithm, rrcSecurityModeCommand.IntegrityAlgorithm, security.DirectionUplink)
+	if err != nil {
+		return fmt.Errorf("error new as security context: %+v", err)
+	}
+	if _, err := asSecurityContext.UnsecureMessage(message); err != nil {
+		return fmt.Errorf("error verify rrc security mode command, KgNB mismatch with RAN: %+v", err)
+	}
+	u.setAsSecurityContext(asSecurityContext)
+
+	n, err := u.sendRrcToRan(&protocol.RrcMessage{
+		Type:          protocol.RRC_SECURITY_MODE_COMPLETE,
+		TransactionId: rrcSecurityModeCommand.TransactionId,
+	})
+	if err != nil {
+		return fmt.Errorf("error send rrc security mode complete: %+v", err)
+	}
+	u.RanLog.Tracef("Sent %d bytes of RRC Security Mode Complete to RAN", n)
+	u.RanLog.Debugln("Send RRC Security Mode Complete to RAN")
+
+	asSecurityContext.Srb.ActivateCiphering()
+	u.RanLog.Infof("AS security activated with NEA%d and NIA%d", rrcSecurityModeCommand.CipheringAlgorithm, rrcSecurityModeCommand.IntegrityAlgorithm)
+	return nil
+}
+
+// setScgSecurity derives K_SN with the sk counter of the secondary cell group addition for the DRB of the secondary cell group
+func (u *Ue) setScgSecurity(skCounter uint16) error {
+	asSecurityContext := u.getAsSecurityContext()
+	if asSecurityContext == nil {
+		return fmt.Errorf("AS security is not activated")
+	}
+
+	kSn, err := protocol.DeriveKsn(u.kGnb, skCounter)
+	if err != nil {
+		return fmt.Errorf("error derive K_SN: %+v", err)
+	}
+	scgSecurityContext, err := protocol.NewAsSecurityContext(kSn, asSecurityContext.CipheringAlgorithm, asSecurityContext.IntegrityAlgorithm, security.DirectionUplink)
+	if err != nil {
+		return fmt.Errorf("error new scg as security context: %+v", err)
+	}
+
+	u.rwLock.Lock()
+	defer u.rwLock.Unlock()
+
+	u.nrdc.scgDrb = scgSecurityContext.Drb
+	u.RanLog.Debugf("Set AS security of the secondary cell group, sk counter: %d", skCounter)
+	return nil
+}
+
+// receiveRrcReconfiguration receives under the NAS timer the RRCReconfiguration setting up the data radio bearer and
+// returns the dedicated nas message carried in it, or returns the downlink nas of a 5GSM message without one, e.g. a reject,
+// any other downlink nas message before it is handled as after start, e.g. the configuration update command of the registration
+func (u *Ue) receiveRrcReconfiguration(timer nasTimer) ([]byte, error) {
+	var message *protocol.Message
+	if err := u.withNasTimer(timer, func() error {
+		for {
+			var err error
+			if message, err = u.readMessageFromRan(); err != nil || message.Type != protocol.MESSAGE_TYPE_NAS {
+				return err
+			}
+
+			u.handleNasMessage(message.Payload)
+			select {
+			case dedicatedNas := <-u.dedicatedNas:
+				message.Payload = dedicatedNas
+				return nil
+			default:
+			}
+		}
+	}); err != nil {
+		return nil, fmt.Errorf("error read rrc reconfiguration: %w", err)
+	}
+	switch message.Type {
+	case protocol.MESSAGE_TYPE_RRC:
+	case protocol.MESSAGE_TYPE_NAS:
+		u.RanLog.Debugln("Receive downlink NAS from RAN instead of RRC Reconfiguration")
+		return message.Payload, nil
+	case protocol.MESSAGE_TYPE_REJECT:
+		return nil, fmt.Errorf("rejected by RAN, cause: %s", protocol.CauseFromPayload(message.Payload))
+	default:
+		return nil, fmt.Errorf("unexpected message type %s, expected %s", message.Type, protocol.MESSAGE_TYPE_RRC)
+	}
+
+	rrcReconfiguration := &protocol.RrcMessage{}
+	if err := rrcReconfiguration.Unmarshal(message.Payload); err != nil {
+		return nil, fmt.Errorf("error unmarshal rrc reconfiguration: %+v", err)
+	}
+	if rrcReconfiguration.Type != protocol.RRC_RECONFIGURATION {
+		return nil, fmt.Errorf("unexpected rrc message %s, expected %s", rrcReconfiguration.Type, protocol.RRC_RECONFIGURATION)
+	}
+	u.RanLog.Tracef("RRC reconfiguration: %+v", rrcReconfiguration)
+	u.RanLog.Debugf("Receive RRC Reconfiguration from RAN, drb to add: %v", rrcReconfiguration.DrbToAdd)
+
+	if rrcReconfiguration.Scg == protocol.RRC_SCG_ADD {
+		if err := u.setScgSecurity(rrcReconfiguration.SkCounter); err != nil {
+			return nil, fmt.Errorf("error set scg security: %+v", err)
+		}
+	}
+
+	if err := u.sendRrcReconfigurationComplete(rrcReconfiguration.TransactionId); err != nil {
+		return nil, err
+	}
+
+	return rrcReconfiguration.DedicatedNas, nil
+}
+
+func (u *Ue) sendRrcReconfigurationComplete(transactionId uint8) error {
+	n, err := u.sendRrcToRan(&protocol.RrcMessage{
+		Type:          protocol.RRC_RECONFIGURATION_COMPLETE,
+		TransactionId: transactionId,
+	})
+	if err != nil {
+		return fmt.Errorf("error send rrc reconfiguration complete: %+v", err)
+	}
+	u.RanLog.Tracef("Sent %d bytes of RRC Reconfiguration Complete to RAN", n)
+	u.RanLog.Debugln("Send RRC Reconfiguration Complete to RAN")
+	return nil
+}
+
+func (u *Ue) handleRrcMessage(payload []byte) {
+	rrcMessage := &protocol.RrcMessage{}
+	if err := rrcMessage.Unmarshal(payload); err != nil {
+		u.RanLog.Warnf("Error unmarshal rrc message from RAN: %+v", err)
+		return
+	}
+	u.RanLog.Tracef("Received %s from RAN: %+v", rrcMessage.Type, rrcMessage)
+
+	switch rrcMessage.Type {
+	case protocol.RRC_RECONFIGURATION:
+		go u.handleRrcReconfiguration(rrcMessage)
+	case protocol.RRC_RELEASE:
+		if rrcMessage.Suspend {
+			u.suspendRrc(rrcMessage.ResumeIdentity)
+		} else {
+			u.setRrcState(protocol.RRC_STATE_IDLE)
+		}
+	case protocol.RRC_RESUME:
+		if err := u.completeRrcResume(rrcMessage.TransactionId); err != nil {
+			u.RanLog.Warnf("%+v", err)
+		}
+	default:
+		u.RanLog.Warnf("Unexpected %s from RAN", rrcMessage.Type)
+	}
+}
+
+// handleRrcReconfiguration applies the serving cell and secondary cell group change and completes the reconfiguration
+func (u *Ue) handleRrcReconfiguration(rrcReconfiguration *protocol.RrcMessage) {
+	u.RanLog.Debugf("Receive RRC Reconfiguration from RAN, drb to add: %v, drb to release: %v, scg: %d", rrcReconfiguration.DrbToAdd, rrcReconfiguration.DrbToRelease, rrcReconfiguration.Scg)
+
+	mobilityRegistrationUpdate := false
+	if rrcReconfiguration.NrCellIdentity != protocol.RRC_NR_CELL_IDENTITY_NONE {
+		sourceNrCellIdentity, _ := u.getServingCell()
+		u.setServingCell(rrcReconfiguration.NrCellIdentity, rrcReconfiguration.Tac)
+		u.RanLog.Infof("Moved from cell %09x to cell %09x, TAC %06x", sourceNrCellIdentity, rrcReconfiguration.NrCellIdentity, rrcReconfiguration.Tac)
+		mobilityRegistrationUpdate = u.registered.Load() && !u.isTrackingAreaRegistered(rrcReconfiguration.Tac)
+	}
+
+	switch rrcReconfiguration.Scg {
+	case protocol.RRC_SCG_ADD:
+		if !u.isNrdcEnabled() {
+			if err := u.setScgSecurity(rrcReconfiguration.SkCounter); err != nil {
+				u.RanLog.Warnf("Error set scg security: %+v", err)
+				break
+			}
+			u.updateDataPlane()
+		}
+	case protocol.RRC_SCG_RELEASE:
+		if u.isNrdcEnabled() {
+			u.updateDataPlane()
+		}
+	}
+
+	if err := u.sendRrcReconfigurationComplete(rrcReconfiguration.TransactionId); err != nil {
+		u.RanLog.Warnf("%+v", err)
+	}
+
+	// the dedicated nas is the 5GSM message of a pdu session procedure after start, handed to EstablishPduSession,
+	// ReleasePduSession or ModifyPduSession waiting for it, or initiated by the network
+	if len(rrcReconfiguration.DedicatedNas) > 0 {
+		u.handleNasMessage(rrcReconfiguration.DedicatedNas)
+	}
+
+	// a tracking area outside the TAI list of the last registration is registered by a mobility registration update,
+	// which waits for its accept received by waitForRanMessage
+	if mobilityRegistrationUpdate {
+		u.NasLog.Infof("TAC %06x is not in the registered TAI list", rrcReconfiguration.Tac)
+		go func() {
+			if err := u.processRegistrationUpdate(nasMessage.RegistrationType5GSMobilityRegistrationUpdating); err != nil {
+				u.NasLog.Warnf("Error process mobility registration update: %+v", err)
+			}
+		}()
+	}
+}
+
+// sendRrcResumeRequest asks the RAN to resume the suspended UE with its resume identity, returns false if the UE is not
+// in RRC_INACTIVE or the resume is already requested, together with the channel closed once the UE is resumed
+func (u *Ue) sendRrcResumeRequest(resumeCause protocol.RrcEstablishmentCause) (bool, <-chan struct{}, error) {
+	u.rrcMtx.Lock()
+	if u.rrcState != protocol.RRC_STATE_INACTIVE {
+		u.rrcMtx.Unlock()
+		return false, nil, nil
+	}
+	rrcResumed := u.rrcResumed
+	if u.rrcResumeRequested {
+		u.rrcMtx.Unlock()
+		return false, rrcResumed, nil
+	}
+	u.rrcResumeRequested = true
+	resumeIdentity := u.resumeIdentity
+	u.rrcMtx.Unlock()
+
+	n, err := u.sendRrcToRan(&protocol.RrcMessage{
+		Type:               protocol.RRC_RESUME_REQUEST,
+		ResumeIdentity:     resumeIdentity,
+		EstablishmentCause: resumeCause,
+	})
+	if err != nil {
+		u.rrcMtx.Lock()
+		u.rrcResumeRequested = false
+		u.rrcMtx.Unlock()
+		return false, nil, fmt.Errorf("error send rrc resume request: %+v", err)
+	}
+	u.RanLog.Tracef("Sent %d bytes of RRC Resume Request to RAN", n)
+	u.RanLog.Debugf("Send RRC Resume Request to RAN, resume identity: %x, cause: %d", resumeIdentity, resumeCause)
+	return true, rrcResumed, nil
+}
+
+// completeRrcResume answers RRCResume with RRCResumeComplete and brings the UE back to RRC_CONNECTED
+func (u *Ue) completeRrcResume(transactionId uint8) error {
+	u.rrcMtx.Lock()
+	requested := u.rrcState == protocol.RRC_STATE_INACTIVE && u.rrcResumeRequested
+	u.rrcMtx.Unlock()
+	if !requested {
+		return fmt.Errorf("unexpected rrc resume, no resume requested")
+	}
+	u.RanLog.Debugln("Receive RRC Resume from RAN")
+
+	n, err := u.sendRrcToRan(&protocol.RrcMessage{
+		Type:          protocol.RRC_RESUME_COMPLETE,
+		TransactionId: transactionId,
+	})
+	if err != nil {
+		return fmt.Errorf("error send rrc resume complete: %+v", err)
+	}
+	u.RanLog.Tracef("Sent %d bytes of RRC Resume Complete to RAN", n)
+	u.RanLog.Debugln("Send RRC Resume Complete to RAN")
+
+	u.rrcMtx.Lock()
+	defer u.rrcMtx.Unlock()
+
+	u.RanLog.Infof("RRC state %s -> %s", u.rrcState, protocol.RRC_STATE_CONNECTED)
+	u.rrcState = protocol.RRC_STATE_CONNECTED
+	u.rrcResumeRequested = false
+	close(u.rrcResumed)
+	return nil
+}
+
+// resumeRrcConnection resumes a UE in RRC_INACTIVE before it sends uplink data or NAS, RRCResume is handled by waitForRanMessage
+// which this waits for, a UE in another RRC state returns at once
+func (u *Ue) resumeRrcConnection(resumeCause protocol.RrcEstablishmentCause) error {
+	_, rrcResumed, err := u.sendRrcResumeRequest(resumeCause)
+	if err != nil || rrcResumed == nil {
+		return err
+	}
+
+	select {
+	case <-rrcResumed:
+		return nil
+	case <-time.After(constant.RRC_PROCEDURE_TIMEOUT):
+		u.rrcMtx.Lock()
+		u.rrcResumeRequested = false
+		u.rrcMtx.Unlock()
+		return fmt.Errorf("rrc resume not received in %v", constant.RRC_PROCEDURE_TIMEOUT)
+	}
+}
+
+// processRrcResume resumes a UE in RRC_INACTIVE reading RRCResume itself, for the procedures run after waitForRanMessage stopped
+func (u *Ue) processRrcResume(resumeCause protocol.RrcEstablishmentCause) error {
+	requested, _, err := u.sendRrcResumeRequest(resumeCause)
+	if err != nil || !requested {
+		return err
+	}
+
+	u.ranControlPlaneReader.setDeadline(time.Now().Add(constant.RRC_PROCEDURE_TIMEOUT))
+	rrcResume, err := u.receiveRrcFromRan(protocol.RRC_RESUME)
+	u.ranControlPlaneReader.setDeadline(time.Time{})
+	if err != nil {
+		u.rrcMtx.Lock()
+		u.rrcResumeRequested = false
+		u.rrcMtx.Unlock()
+		return fmt.Errorf("error read rrc resume: %+v", err)
+	}
+	return u.completeRrcResume(rrcResume.TransactionId)
+}
+
+// getRrcMeasurements measures the primary cell and, if the UE is configured with one, the secondary cell
+func (u *Ue) getRrcMeasurements() []protocol.RrcMeasurement {
+	rrcMeasurements := []protocol.RrcMeasurement{
+		{
+			Cell: protocol.RRC_CELL_PRIMARY,
+			Rsrp: constant.RRC_NOMINAL_RSRP,
+			Rsrq: constant.RRC_NOMINAL_RSRQ,
+		},
+	}
+	if u.nrdc.dcRanDataPlane.ip != "" {
+		rrcMeasurements = append(rrcMeasurements, protocol.RrcMeasurement{
+			Cell: protocol.RRC_CELL_SECONDARY,
+			Rsrp: constant.RRC_NOMINAL_RSRP,
+			Rsrq: constant.RRC_NOMINAL_RSRQ,
+		})
+	}
+
+	return rrcMeasurements
+}
+
+func (u *Ue) sendRrcMeasurementReport() error {
+	rrcMeasurements := u.getRrcMeasurements()
+
+	n, err := u.sendRrcToRan(&protocol.RrcMessage{
+		Type:         protocol.RRC_MEASUREMENT_REPORT,
+		Measurements: rrcMeasurements,
+	})
+	if err != nil {
+		return fmt.Errorf("error send measurement report: %+v", err)
+	}
+	u.RanLog.Tracef("Sent %d bytes of Measurement Report to RAN: %+v", n, rrcMeasurements)
+	return nil
+}
+
+// reportRrcMeasurement sends measurement reports periodically while the UE is in RRC_CONNECTED
+func (u *Ue) reportRrcMeasurement(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	defer wg.Done()
+
+	ticker := time.NewTicker(constant.RRC_MEASUREMENT_REPORT_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		if u.getRrcState() == protocol.RRC_STATE_CONNECTED {
+			if err := u.sendRrcMeasurementReport(); err != nil {
+				u.RanLog.Warnf("%+v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package ue
 
 import (
-	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"regexp"
 
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/protocol"
 	"github.com/free5gc/nas"
 	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/nas/security"
@@ -13,98 +15,20 @@ import (
 	"github.com/free5gc/util/ueauth"
 )
 
-func milenageF1(opc, k, rand, sqn, amf []byte, macA, macS []byte) error {
-	ik, ck, xres, autn, err := milenage.GenerateAKAParameters(opc, k, rand, sqn, amf)
-	if err != nil {
-		return err
-	}
-	// Suppress unused variable warnings
-	_ = ik
-	_ = ck
-	_ = xres
-
-	// AUTN = (SQN xor AK) || AMF || MAC-A
-	// MAC-A is the last 8 bytes of AUTN
-	if len(autn) >= 8 && macA != nil {
-		copy(macA, autn[len(autn)-8:])
-	}
-
-	// For MAC-S, use resync AMF (0000)
-	if macS != nil {
-		resyncAMFBytes, err := hex.DecodeString("0000")
-		if err != nil {
-			return err
-		}
-		ikS, ckS, xresS, autnS, err := milenage.GenerateAKAParameters(opc, k, rand, sqn, resyncAMFBytes)
-		if err != nil {
-			return err
-		}
-		// Suppress unused variable warnings
-		_ = ikS
-		_ = ckS
-		_ = xresS
-
-		if len(autnS) >= 8 {
-			copy(macS, autnS[len(autnS)-8:])
-		}
-	}
-
-	return nil
-}
-
-func milenageF2345(opc, k, rand []byte, res, ck, ik, ak, akstar []byte) error {
-	// Use GenerateAKAParameters to get basic parameters
-	ikOut, ckOut, resOut, autn, err := milenage.GenerateAKAParameters(opc, k, rand, make([]byte, 6), make([]byte, 2))
-	if err != nil {
-		return err
-	}
-
-	// Use GenerateKeysWithAUTN to get AK
-	sqnhe, akOut, ikOut2, ckOut2, resOut2, err := milenage.GenerateKeysWithAUTN(opc, k, rand, autn)
-	if err != nil {
-		return err
-	}
-	// Suppress unused variable warnings
-	_ = sqnhe
-	_ = ikOut2
-	_ = ckOut2
-	_ = resOut2
-
-	// Copy results to output parameters
-	if res != nil {
-		copy(res, resOut)
-	}
-	if ck != nil {
-		copy(ck, ckOut)
-	}
-	if ik != nil {
-		copy(ik, ikOut)
-	}
-	if ak != nil {
-		copy(ak, akOut)
-	}
-	if akstar != nil {
-		// For AK*, we need to use a different SQN, but due to API limitations, we use the same value for now
-		copy(akstar, akOut)
-	}
-
-	return nil
-}
-
-func deriveKAmf(supi string, key []byte, snName string, SQN, AK []byte) ([]byte, error) {
-	FC := ueauth.FC_FOR_KAUSF_DERIVATION
+// deriveKAusf derives KAUSF of 5G AKA from CK || IK as in TS 33.501 A.2
+func deriveKAusf(key []byte, snName string, SQN, AK []byte) ([]byte, error) {
 	P0 := []byte(snName)
 	SQNxorAK := make([]byte, 6)
 	for i := 0; i < len(SQN); i++ {
 		SQNxorAK[i] = SQN[i] ^ AK[i]
 	}
 	P1 := SQNxorAK
-	Kausf, err := ueauth.GetKDFValue(key, FC, P0, ueauth.KDFLen(P0), P1, ueauth.KDFLen(P1))
-	if err != nil {
-		return nil, fmt.Errorf("GetKDFValue error: %+v", err)
-	}
-	P0 = []byte(snName)
-	Kseaf, err := ueauth.GetKDFValue(Kausf, ueauth.FC_FOR_KSEAF_DERIVATION, P0, ueauth.KDFLen(P0))
+	return ueauth.GetKDFValue(key, ueauth.FC_FOR_KAUSF_DERIVATION, P0, ueauth.KDFLen(P0), P1, ueauth.KDFLen(P1))
+}
+
+func deriveKAmf(supi string, kAusf []byte, snName string) ([]byte, error) {
+	P0 := []byte(snName)
+	Kseaf, err := ueauth.GetKDFValue(kAusf, ueauth.FC_FOR_KSEAF_DERIVATION, P0, ueauth.KDFLen(P0))
 	if err != nil {
 		return nil, fmt.Errorf("GetKDFValue error: %+v", err)
 	}
@@ -117,7 +41,7 @@ func deriveKAmf(supi string, key []byte, snName string, SQN, AK []byte) ([]byte,
 
 	P0 = []byte(groups[1])
 	L0 := ueauth.KDFLen(P0)
-	P1 = []byte{0x00, 0x00}
+	P1 := []byte{0x00, 0x00}
 	L1 := ueauth.KDFLen(P1)
 
 	return ueauth.GetKDFValue(Kseaf, ueauth.FC_FOR_KAMF_DERIVATION, P0, L0, P1, L1)
@@ -149,67 +73,110 @@ func deriveAlgorithmKey(kAmf []byte, cipheringAlgorithm, integrityAlgorithm uint
 	return kenc, kint, nil
 }
 
-func deriveSequenceNumber(autn []byte, ak []uint8) []byte {
-	sqn := make([]byte, 6)
+// authenticationFailure is an authentication challenge rejected by the UE, with the 5GMM cause of the authentication failure
+// and the AUTS of a synch failure, a challenge of EAP-AKA' is rejected by the EAP-Response instead
+type authenticationFailure struct {
+	cause  uint8
+	auts   []byte
+	reason string
 
-	sqnXorAk := autn[0:6]
+	eapMessage []byte
+}
 
-	for i := 0; i < len(sqnXorAk); i++ {
-		sqn[i] = sqnXorAk[i] ^ ak[i]
+func (a *authenticationFailure) Error() string {
+	if a.reason != "" {
+		return fmt.Sprintf("authentication failure, %s", a.reason)
 	}
+	return fmt.Sprintf("authentication failure, cause: %s", nasMessage.Cause5GMMToString(a.cause))
+}
 
-	return sqn
+// isSqnFresh accepts the SQN of the network if it is ahead of the SQN of the UE but within the freshness window
+func isSqnFresh(sqnMs, sqn []byte) bool {
+	sqnMsValue, sqnValue := sqnToUint64(sqnMs), sqnToUint64(sqn)
+	return sqnValue > sqnMsValue && sqnValue-sqnMsValue <= constant.UE_SQN_FRESHNESS_WINDOW
 }
 
-func deriveResStarAndSetKey(supi string, cipheringAlgorithm, integrityAlgorithm uint8, sqn, amf, encPermanentKey, encOpcKey string, rand []byte, autn []byte, snName string) ([]byte, []byte, []byte, []byte, string, error) {
-	sqnHex, err := hex.DecodeString(sqn)
-	if err != nil {
-		return nil, nil, nil, nil, "", fmt.Errorf("error decode sqn: %v", err)
+func sqnToUint64(sqn []byte) uint64 {
+	var value uint64
+	for _, b := range sqn {
+		value = value<<8 | uint64(b)
 	}
+	return value
+}
 
-	amfHex, err := hex.DecodeString(amf)
+// akaResult is the outcome of a verified AUTN, with the SQN of the network
+type akaResult struct {
+	res []byte
+	ck  []byte
+	ik  []byte
+	ak  []byte
+	sqn []byte
+}
+
+// verifyAutn verifies AUTN as TS 33.102 6.3.3 for both 5G AKA and EAP-AKA', a challenge the UE rejects returns an *authenticationFailure
+func verifyAutn(sqn, encPermanentKey, encOpcKey string, rand []byte, autn []byte) (*akaResult, error) {
+	sqnHex, err := hex.DecodeString(sqn)
 	if err != nil {
-		return nil, nil, nil, nil, "", fmt.Errorf("error decode amf: %v", err)
+		return nil, fmt.Errorf("error decode sqn: %v", err)
 	}
 
 	kHex, err := hex.DecodeString(encPermanentKey)
 	if err != nil {
-		return nil, nil, nil, nil, "", fmt.Errorf("error decode encPermanentKey: %v", err)
+		return nil, fmt.Errorf("error decode encPermanentKey: %v", err)
 	}
 
 	opcHex, err := hex.DecodeString(encOpcKey)
 	if err != nil {
-		return nil, nil, nil, nil, "", fmt.Errorf("error decode encOpcKey: %v", err)
+		return nil, fmt.Errorf("error decode encOpcKey: %v", err)
 	}
 
-	macA, macS := make([]byte, 8), make([]byte, 8)
-	ck, ik := make([]byte, 16), make([]byte, 16)
-	res := make([]byte, 8)
-	ak, akStar := make([]byte, 6), make([]byte, 6)
+	// AUTN = SQN xor AK || AMF || MAC-A, milenage recovers the SQN of the network and checks MAC-A
+	autnSqn, ak, ik, ck, res, err := milenage.GenerateKeysWithAUTN(opcHex, kHex, rand, autn)
+	if err != nil {
+		var macFailure *milenage.MACFailureError
+		if errors.As(err, &macFailure) {
+			return nil, &authenticationFailure{cause: nasMessage.Cause5GMMMACFailure}
+		}
+		return nil, fmt.Errorf("error generate keys with autn: %v", err)
+	}
 
-	// generate macA and macS
-	if err := milenageF1(opcHex, kHex, rand, sqnHex, amfHex, macA, macS); err != nil {
-		return nil, nil, nil, nil, "", fmt.Errorf("error F1: %v", err)
+	// a stale SQN is answered with AUTS = SQN_MS xor AK* || MAC-S for the network to resynchronise to the SQN of the UE
+	if !isSqnFresh(sqnHex, autnSqn) {
+		auts, err := milenage.GenerateAUTS(opcHex, kHex, rand, sqnHex)
+		if err != nil {
+			return nil, fmt.Errorf("error generate auts: %v", err)
+		}
+		return nil, &authenticationFailure{cause: nasMessage.Cause5GMMSynchFailure, auts: auts}
 	}
 
-	//generate res, ck, ik, ak, akstar
-	if err := milenageF2345(opcHex, kHex, rand, res, ck, ik, ak, akStar); err != nil {
-		return nil, nil, nil, nil, "", fmt.Errorf("error F2345: %v", err)
+	// the separation bit of AMF is set for a 5G authentication vector as in TS 33.501 Annex A.3
+	if autn[6]&0x80 == 0 {
+		return nil, &authenticationFailure{cause: nasMessage.Cause5GMMNon5GAuthenticationUnacceptable}
 	}
 
-	// update sqn if sqn is not equal to autn's sqn
-	if newSqn := deriveSequenceNumber(autn[:], ak[:]); !bytes.Equal(sqnHex, newSqn) {
-		sqnHex = newSqn
+	return &akaResult{res: res, ck: ck, ik: ik, ak: ak, sqn: autnSqn}, nil
+}
+
+// deriveResStarAndSetKey verifies AUTN of 5G AKA and returns the keys, RES* and the SQN of the network,
+// a challenge the UE rejects returns an *authenticationFailure
+func deriveResStarAndSetKey(supi string, cipheringAlgorithm, integrityAlgorithm uint8, sqn, encPermanentKey, encOpcKey string, rand []byte, autn []byte, snName string) ([]byte, []byte, []byte, []byte, string, error) {
+	aka, err := verifyAutn(sqn, encPermanentKey, encOpcKey, rand, autn)
+	if err != nil {
+		return nil, nil, nil, nil, "", err
 	}
 
 	// derive RES*
-	key := append(ck, ik...)
+	key := append(aka.ck, aka.ik...)
 	FC := ueauth.FC_FOR_RES_STAR_XRES_STAR_DERIVATION
 	P0 := []byte(snName)
 	P1 := rand
-	P2 := res
+	P2 := aka.res
 
-	kAmf, err := deriveKAmf(supi, key, snName, sqnHex, ak)
+	kAusf, err := deriveKAusf(key, snName, aka.sqn, aka.ak)
+	if err != nil {
+		return nil, nil, nil, nil, "", fmt.Errorf("error deriveKAusf: %v", err)
+	}
+	kAmf, err := deriveKAmf(supi, kAusf, snName)
 	if err != nil {
 		return nil, nil, nil, nil, "", fmt.Errorf("error deriveKAmf: %v", err)
 	}
@@ -221,7 +188,88 @@ func deriveResStarAndSetKey(supi string, cipheringAlgorithm, integrityAlgorithm
 	if err != nil {
 		return nil, nil, nil, nil, "", fmt.Errorf("error GetKDFValue: %v", err)
 	}
-	return kAmf, kenc, kint, kdfVal_for_resStar[len(kdfVal_for_resStar)/2:], hex.EncodeToString(sqnHex), nil
+	return kAmf, kenc, kint, kdfVal_for_resStar[len(kdfVal_for_resStar)/2:], hex.EncodeToString(aka.sqn), nil
+}
+
+// deriveEapResponseAndSetKey answers the EAP-Request/AKA'-Challenge as RFC 5448 and returns the keys, the EAP-Response
+// and the SQN of the network, KAUSF is the first 256 bits of EMSK as in TS 33.501 6.1.3.1, a challenge the UE rejects
+// returns an *authenticationFailure with the EAP-Response rejecting it
+func deriveEapResponseAndSetKey(supi string, cipheringAlgorithm, integrityAlgorithm uint8, sqn, encPermanentKey, encOpcKey string, eapMessage []byte, snName string) ([]byte, []byte, []byte, []byte, string, error) {
+	challenge, err := protocol.DecodeEapAkaPrimePacket(eapMessage)
+	if err != nil {
+		return nil, nil, nil, nil, "", fmt.Errorf("error decode eap-aka' challenge: %v", err)
+	}
+	if challenge.Code != protocol.EAP_CODE_REQUEST || challenge.Subtype != protocol.EAP_AKA_SUBTYPE_CHALLENGE || len(challenge.Rand) == 0 || len(challenge.Autn) == 0 {
+		return nil, nil, nil, nil, "", newEapClientError(challenge.Identifier, fmt.Sprintf("unsupported eap-aka' code %d subtype %d", challenge.Code, challenge.Subtype))
+	}
+	if challenge.Kdf != protocol.EAP_AKA_PRIME_KDF {
+		return nil, nil, nil, nil, "", newEapClientError(challenge.Identifier, fmt.Sprintf("unsupported kdf %d", challenge.Kdf))
+	}
+	// the network name of the key derivation is the serving network the UE is registering to, as RFC 5448 3.1
+	if challenge.KdfInput != snName {
+		return nil, nil, nil, nil, "", &authenticationFailure{
+			cause:      nasMessage.Cause5GMMMACFailure,
+			reason:     fmt.Sprintf("network name %s is not the serving network %s", challenge.KdfInput, snName),
+			eapMessage: newEapResponse(challenge.Identifier, protocol.EAP_AKA_SUBTYPE_AUTHENTICATION_REJECT).Encode(nil),
+		}
+	}
+
+	aka, err := verifyAutn(sqn, encPermanentKey, encOpcKey, challenge.Rand, challenge.Autn)
+	var failure *authenticationFailure
+	if errors.As(err, &failure) {
+		if failure.cause == nasMessage.Cause5GMMSynchFailure {
+			response := newEapResponse(challenge.Identifier, protocol.EAP_AKA_SUBTYPE_SYNCHRONIZATION_FAILURE)
+			response.Auts = failure.auts
+			failure.eapMessage = response.Encode(nil)
+		} else {
+			failure.eapMessage = newEapResponse(challenge.Identifier, protocol.EAP_AKA_SUBTYPE_AUTHENTICATION_REJECT).Encode(nil)
+		}
+		return nil, nil, nil, nil, "", failure
+	}
+	if err != nil {
+		return nil, nil, nil, nil, "", err
+	}
+
+	ckPrime, ikPrime, err := protocol.DeriveCkPrimeIkPrime(aka.ck, aka.ik, challenge.KdfInput, challenge.Autn[:6])
+	if err != nil {
+		return nil, nil, nil, nil, "", fmt.Errorf("error derive ck' ik': %v", err)
+	}
+	eapKeys := protocol.DeriveEapAkaPrimeKeys(ckPrime, ikPrime, supi)
+
+	if valid, err := protocol.VerifyEapAkaPrimeMac(eapMessage, eapKeys.KAut); err != nil || !valid {
+		return nil, nil, nil, nil, "", newEapClientError(challenge.Identifier, "invalid AT_MAC of eap-aka' challenge")
+	}
+
+	kAmf, err := deriveKAmf(supi, eapKeys.Emsk[:32], snName)
+	if err != nil {
+		return nil, nil, nil, nil, "", fmt.Errorf("error deriveKAmf: %v", err)
+	}
+	kenc, kint, err := deriveAlgorithmKey(kAmf, cipheringAlgorithm, integrityAlgorithm)
+	if err != nil {
+		return nil, nil, nil, nil, "", fmt.Errorf("error deriveAlgorithmKey: %v", err)
+	}
+
+	response := newEapResponse(challenge.Identifier, protocol.EAP_AKA_SUBTYPE_CHALLENGE)
+	response.Res = aka.res
+	return kAmf, kenc, kint, response.Encode(eapKeys.KAut), hex.EncodeToString(aka.sqn), nil
+}
+
+func newEapResponse(identifier, subtype uint8) *protocol.EapAkaPrimePacket {
+	return &protocol.EapAkaPrimePacket{
+		Code:       protocol.EAP_CODE_RESPONSE,
+		Identifier: identifier,
+		Subtype:    subtype,
+	}
+}
+
+// newEapClientError rejects an EAP-AKA' request the UE cannot process with EAP-Response/AKA'-Client-Error
+func newEapClientError(identifier uint8, reason string) *authenticationFailure {
+	response := newEapResponse(identifier, protocol.EAP_AKA_SUBTYPE_CLIENT_ERROR)
+	response.ClientErrorCode = protocol.EAP_AKA_CLIENT_ERROR_UNABLE_TO_PROCESS
+	return &authenticationFailure{
+		reason:     reason,
+		eapMessage: response.Encode(nil),
+	}
 }
 
 func encodeNasPduWithSecurity(nasPdu []byte, securityHeaderType uint8, ue *Ue, securityContextAvailable bool, newSecurityContext bool) ([]byte, error) {
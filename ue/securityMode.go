@@ -0,0 +1,138 @@
+package ue
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/nasType"
+)
+
+// securityModeRejectError is a security mode command the UE does not accept, answered with a security mode reject of the cause
+type securityModeRejectError struct {
+	cause  uint8
+	reason string
+}
+
+func (s *securityModeRejectError) Error() string {
+	return fmt.Sprintf("security mode command rejected, cause: %s, %s", nasMessage.Cause5GMMToString(s.cause), s.reason)
+}
+
+// enabledAlgorithms lists the algorithms of the enabled flags of the config, the n-th flag is algorithm n
+func enabledAlgorithms(flags ...bool) []uint8 {
+	algorithms := make([]uint8, 0, len(flags))
+	for algorithm, enabled := range flags {
+		if enabled {
+			algorithms = append(algorithms, uint8(algorithm))
+		}
+	}
+	return algorithms
+}
+
+// isNasSecurityAlgorithmsEnabled tells if the UE may take a security context of the algorithm pair
+func (u *Ue) isNasSecurityAlgorithmsEnabled(cipheringAlgorithm, integrityAlgorithm uint8) bool {
+	return slices.Contains(u.supportedCipheringAlgorithms, cipheringAlgorithm) && slices.Contains(u.supportedIntegrityAlgorithms, integrityAlgorithm)
+}
+
+// getUeSecurityCapability is the UE security capability of the registration requests, replayed by the security mode command
+func (u *Ue) getUeSecurityCapability() nasType.UESecurityCapability {
+	return buildUeSecurityCapability(u.supportedCipheringAlgorithms, u.supportedIntegrityAlgorithms)
+}
+
+// checkSecurityModeCommand checks the selected algorithms are enabled and the replayed UE security capabilities are the sent ones
+// as in TS 24.501 5.4.2.3, so that the network has not bid the UE down to other algorithms
+func (u *Ue) checkSecurityModeCommand(securityModeCommand *nasMessage.SecurityModeCommand) error {
+	cipheringAlgorithm := securityModeCommand.SelectedNASSecurityAlgorithms.GetTypeOfCipheringAlgorithm()
+	integrityAlgorithm := securityModeCommand.SelectedNASSecurityAlgorithms.GetTypeOfIntegrityProtectionAlgorithm()
+	if !u.isNasSecurityAlgorithmsEnabled(cipheringAlgorithm, integrityAlgorithm) {
+		return &securityModeRejectError{
+			cause:  nasMessage.Cause5GMMSecurityModeRejectedUnspecified,
+			reason: fmt.Sprintf("selected NEA%d and NIA%d are not enabled", cipheringAlgorithm, integrityAlgorithm),
+		}
+	}
+
+	ueSecurityCapability := u.getUeSecurityCapability()
+	replayedUeSecurityCapabilities := securityModeCommand.ReplayedUESecurityCapabilities
+	if replayedUeSecurityCapabilities.GetLen() != ueSecurityCapability.GetLen() || !bytes.Equal(replayedUeSecurityCapabilities.Buffer, ueSecurityCapability.Buffer) {
+		return &securityModeRejectError{
+			cause:  nasMessage.Cause5GMMUESecurityCapabilitiesMismatch,
+			reason: fmt.Sprintf("replayed UE security capabilities 0x%x, sent 0x%x", replayedUeSecurityCapabilities.Buffer, ueSecurityCapability.Buffer),
+		}
+	}
+	return nil
+}
+
+// decodeSecurityModeCommand takes the algorithms of the security mode command of a new 5G NAS security context, the command is
+// checked before its MAC as the MAC is calculated with the NAS keys of the selected algorithms, derived from kAMF, and the
+// current security context is kept if the command is rejected
+func (u *Ue) decodeSecurityModeCommand(nasRaw []byte) (*nas.Message, error) {
+	// the integrity protected security mode command follows the security header, the MAC and the sequence number in cleartext
+	if len(nasRaw) <= 7 {
+		return nil, fmt.Errorf("security mode command of %d bytes is too short", len(nasRaw))
+	}
+	plainNas := append([]byte{}, nasRaw[7:]...)
+	unverifiedNasPdu := new(nas.Message)
+	if err := unverifiedNasPdu.PlainNasDecode(&plainNas); err != nil {
+		return nil, fmt.Errorf("error decode nas security mode command: %+v", err)
+	}
+	if unverifiedNasPdu.GmmMessage == nil || unverifiedNasPdu.GmmHeader.GetMessageType() != nas.MsgTypeSecurityModeCommand {
+		return nil, fmt.Errorf("error nas pdu message type of a new security context: %+v, expected security mode command", unverifiedNasPdu.GmmHeader.GetMessageType())
+	}
+
+	securityModeCommand := unverifiedNasPdu.SecurityModeCommand
+	if err := u.checkSecurityModeCommand(securityModeCommand); err != nil {
+		return nil, err
+	}
+	u.securityContextMtx.Lock()
+	kAmf := u.kAmf
+	u.securityContextMtx.Unlock()
+	if kAmf == nil {
+		return nil, &securityModeRejectError{cause: nasMessage.Cause5GMMSecurityModeRejectedUnspecified, reason: "no kAMF of an authentication"}
+	}
+
+	cipheringAlgorithm := securityModeCommand.SelectedNASSecurityAlgorithms.GetTypeOfCipheringAlgorithm()
+	integrityAlgorithm := securityModeCommand.SelectedNASSecurityAlgorithms.GetTypeOfIntegrityProtectionAlgorithm()
+	kenc, kint, err := deriveAlgorithmKey(kAmf, cipheringAlgorithm, integrityAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("error derive algorithm key: %+v", err)
+	}
+
+	u.securityContextMtx.Lock()
+	currentCipheringAlgorithm, currentIntegrityAlgorithm := u.cipheringAlgorithm, u.integrityAlgorithm
+	currentKNasEnc, currentKNasInt, currentDlCount := u.kNasEnc, u.kNasInt, u.dlCount
+
+	u.cipheringAlgorithm, u.integrityAlgorithm = cipheringAlgorithm, integrityAlgorithm
+	copy(u.kNasEnc[:], kenc[16:32])
+	copy(u.kNasInt[:], kint[16:32])
+	u.securityContextMtx.Unlock()
+
+	nasPdu, err := nasDecode(u, nas.GetSecurityHeaderType(nasRaw), nasRaw)
+	if err != nil {
+		u.securityContextMtx.Lock()
+		u.cipheringAlgorithm, u.integrityAlgorithm = currentCipheringAlgorithm, currentIntegrityAlgorithm
+		u.kNasEnc, u.kNasInt, u.dlCount = currentKNasEnc, currentKNasInt, currentDlCount
+		u.securityContextMtx.Unlock()
+		return nil, &securityModeRejectError{cause: nasMessage.Cause5GMMSecurityModeRejectedUnspecified, reason: fmt.Sprintf("%+v", err)}
+	}
+
+	u.NasLog.Infof("NAS security algorithms NEA%d and NIA%d selected by the network", cipheringAlgorithm, integrityAlgorithm)
+	return nasPdu, nil
+}
+
+// sendSecurityModeReject answers the rejected security mode command, in plain as the UE does not take its security context
+func (u *Ue) sendSecurityModeReject(reject *securityModeRejectError) error {
+	securityModeReject, err := getSecurityModeReject(reject.cause)
+	if err != nil {
+		return fmt.Errorf("error get security mode reject: %+v", err)
+	}
+	u.NasLog.Tracef("Security mode reject: %+v", securityModeReject)
+
+	if _, err := u.sendToRan(protocol.MESSAGE_TYPE_NAS, securityModeReject); err != nil {
+		return fmt.Errorf("error send security mode reject: %+v", err)
+	}
+	u.NasLog.Warnf("Send NAS Security Mode Reject to RAN, %s", reject.reason)
+	return nil
+}
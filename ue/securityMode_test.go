@@ -0,0 +1,168 @@
+package ue
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Alonza0314/free-ran-ue/logger"
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/nasType"
+	"github.com/free5gc/nas/security"
+	"github.com/go-playground/assert"
+)
+
+var testUeSecurityCapabilityCases = []struct {
+	name                 string
+	cipheringAlgorithms  []uint8
+	integrityAlgorithms  []uint8
+	expectedCapabilities []byte
+}{
+	{
+		name:                 "testSingleAlgorithm",
+		cipheringAlgorithms:  []uint8{security.AlgCiphering128NEA0},
+		integrityAlgorithms:  []uint8{security.AlgIntegrity128NIA2},
+		expectedCapabilities: []byte{0x80, 0x20, 0x80, 0x20},
+	},
+	{
+		name:                 "testAllAlgorithms",
+		cipheringAlgorithms:  []uint8{security.AlgCiphering128NEA0, security.AlgCiphering128NEA1, security.AlgCiphering128NEA2, security.AlgCiphering128NEA3},
+		integrityAlgorithms:  []uint8{security.AlgIntegrity128NIA1, security.AlgIntegrity128NIA2, security.AlgIntegrity128NIA3},
+		expectedCapabilities: []byte{0xf0, 0x70, 0xf0, 0x70},
+	},
+}
+
+func TestUeSecurityCapability(t *testing.T) {
+	for _, testCase := range testUeSecurityCapabilityCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ueSecurityCapability := buildUeSecurityCapability(testCase.cipheringAlgorithms, testCase.integrityAlgorithms)
+			assert.Equal(t, uint8(len(testCase.expectedCapabilities)), ueSecurityCapability.GetLen())
+			assert.Equal(t, testCase.expectedCapabilities, ueSecurityCapability.Buffer)
+		})
+	}
+}
+
+var testSecurityModeCommandCases = []struct {
+	name                       string
+	cipheringAlgorithm         uint8
+	integrityAlgorithm         uint8
+	replayedIntegrity          []uint8
+	corruptMac                 bool
+	expectedCause              uint8
+	expectedCipheringAlgorithm uint8
+	expectedIntegrityAlgorithm uint8
+}{
+	{
+		name:                       "testSelectedAlgorithms",
+		cipheringAlgorithm:         security.AlgCiphering128NEA2,
+		integrityAlgorithm:         security.AlgIntegrity128NIA2,
+		expectedCipheringAlgorithm: security.AlgCiphering128NEA2,
+		expectedIntegrityAlgorithm: security.AlgIntegrity128NIA2,
+	},
+	{
+		name:                       "testSelectedAlgorithmNotEnabled",
+		cipheringAlgorithm:         security.AlgCiphering128NEA2,
+		integrityAlgorithm:         security.AlgIntegrity128NIA3,
+		expectedCause:              nasMessage.Cause5GMMSecurityModeRejectedUnspecified,
+		expectedCipheringAlgorithm: security.AlgCiphering128NEA0,
+		expectedIntegrityAlgorithm: security.AlgIntegrity128NIA1,
+	},
+	{
+		name:                       "testReplayedCapabilitiesMismatch",
+		cipheringAlgorithm:         security.AlgCiphering128NEA0,
+		integrityAlgorithm:         security.AlgIntegrity128NIA1,
+		replayedIntegrity:          []uint8{security.AlgIntegrity128NIA1},
+		expectedCause:              nasMessage.Cause5GMMUESecurityCapabilitiesMismatch,
+		expectedCipheringAlgorithm: security.AlgCiphering128NEA0,
+		expectedIntegrityAlgorithm: security.AlgIntegrity128NIA1,
+	},
+	{
+		name:                       "testMacFailure",
+		cipheringAlgorithm:         security.AlgCiphering128NEA2,
+		integrityAlgorithm:         security.AlgIntegrity128NIA2,
+		corruptMac:                 true,
+		expectedCause:              nasMessage.Cause5GMMSecurityModeRejectedUnspecified,
+		expectedCipheringAlgorithm: security.AlgCiphering128NEA0,
+		expectedIntegrityAlgorithm: security.AlgIntegrity128NIA1,
+	},
+}
+
+// newTestSecurityModeCommand builds the security mode command of the AMF, integrity protected with a new security context
+func newTestSecurityModeCommand(t *testing.T, kAmf []byte, cipheringAlgorithm, integrityAlgorithm uint8, replayedUeSecurityCapability nasType.UESecurityCapability, corruptMac bool) []byte {
+	m := nas.NewMessage()
+	m.GmmMessage = nas.NewGmmMessage()
+	m.GmmHeader.SetMessageType(nas.MsgTypeSecurityModeCommand)
+
+	securityModeCommand := nasMessage.NewSecurityModeCommand(0)
+	securityModeCommand.ExtendedProtocolDiscriminator.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSMobilityManagementMessage)
+	securityModeCommand.SpareHalfOctetAndSecurityHeaderType.SetSecurityHeaderType(nas.SecurityHeaderTypePlainNas)
+	securityModeCommand.SecurityModeCommandMessageIdentity.SetMessageType(nas.MsgTypeSecurityModeCommand)
+	securityModeCommand.SelectedNASSecurityAlgorithms.SetTypeOfCipheringAlgorithm(cipheringAlgorithm)
+	securityModeCommand.SelectedNASSecurityAlgorithms.SetTypeOfIntegrityProtectionAlgorithm(integrityAlgorithm)
+	securityModeCommand.SpareHalfOctetAndNgksi.SetNasKeySetIdentifiler(1)
+	securityModeCommand.ReplayedUESecurityCapabilities.SetLen(replayedUeSecurityCapability.GetLen())
+	securityModeCommand.ReplayedUESecurityCapabilities.Buffer = replayedUeSecurityCapability.Buffer
+	m.GmmMessage.SecurityModeCommand = securityModeCommand
+
+	plainNas := new(bytes.Buffer)
+	if err := m.GmmMessageEncode(plainNas); err != nil {
+		t.Fatalf("error encode security mode command: %+v", err)
+	}
+
+	_, kint, err := deriveAlgorithmKey(kAmf, cipheringAlgorithm, integrityAlgorithm)
+	if err != nil {
+		t.Fatalf("error derive algorithm key: %+v", err)
+	}
+	var kNasInt [16]byte
+	copy(kNasInt[:], kint[16:32])
+
+	payload := append([]byte{0x00}, plainNas.Bytes()...)
+	mac32, err := security.NASMacCalculate(integrityAlgorithm, kNasInt, 0, security.OnlyOneBearer, security.DirectionDownlink, payload)
+	if err != nil {
+		t.Fatalf("error calculate nas mac: %+v", err)
+	}
+	if corruptMac {
+		mac32[0] ^= 0xff
+	}
+
+	securityHeader := []byte{nasMessage.Epd5GSMobilityManagementMessage, nas.SecurityHeaderTypeIntegrityProtectedWithNew5gNasSecurityContext}
+	return append(append(securityHeader, mac32...), payload...)
+}
+
+func TestSecurityModeCommand(t *testing.T) {
+	ueLogger := logger.NewUeLogger("error", "", true)
+
+	for _, testCase := range testSecurityModeCommandCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ue := &Ue{
+				authentication: authentication{
+					supportedCipheringAlgorithms: []uint8{security.AlgCiphering128NEA0, security.AlgCiphering128NEA2},
+					supportedIntegrityAlgorithms: []uint8{security.AlgIntegrity128NIA1, security.AlgIntegrity128NIA2},
+					cipheringAlgorithm:           security.AlgCiphering128NEA0,
+					integrityAlgorithm:           security.AlgIntegrity128NIA1,
+					kAmf:                         bytes.Repeat([]byte{0x5a}, 32),
+				},
+				UeLogger: &ueLogger,
+			}
+
+			replayedUeSecurityCapability := ue.getUeSecurityCapability()
+			if testCase.replayedIntegrity != nil {
+				replayedUeSecurityCapability = buildUeSecurityCapability(ue.supportedCipheringAlgorithms, testCase.replayedIntegrity)
+			}
+			nasRaw := newTestSecurityModeCommand(t, ue.kAmf, testCase.cipheringAlgorithm, testCase.integrityAlgorithm, replayedUeSecurityCapability, testCase.corruptMac)
+
+			nasPdu, err := ue.decodeSecurityModeCommand(nasRaw)
+			if testCase.expectedCause == 0 {
+				assert.Equal(t, nil, err)
+				assert.Equal(t, nas.MsgTypeSecurityModeCommand, nasPdu.GmmHeader.GetMessageType())
+			} else {
+				var reject *securityModeRejectError
+				assert.Equal(t, true, errors.As(err, &reject))
+				assert.Equal(t, testCase.expectedCause, reject.cause)
+			}
+			assert.Equal(t, testCase.expectedCipheringAlgorithm, ue.cipheringAlgorithm)
+			assert.Equal(t, testCase.expectedIntegrityAlgorithm, ue.integrityAlgorithm)
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package ue
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/security"
+	"github.com/free5gc/util/milenage"
+	"github.com/go-playground/assert"
+)
+
+const (
+	testEncPermanentKey = "8baf473f2f8fd09487cccbd7097c6862"
+	testEncOpcKey       = "8e27b6af0e692e750f32667a3b14605d"
+	testSqnMs           = "000000000020"
+)
+
+var testDeriveResStarAndSetKeyCases = []struct {
+	name          string
+	networkSqn    string
+	networkAmf    string
+	corruptMacA   bool
+	expectedCause uint8
+	expectedSqn   string
+}{
+	{
+		name:        "testFreshSqn",
+		networkSqn:  "000000000041",
+		networkAmf:  "8000",
+		expectedSqn: "000000000041",
+	},
+	{
+		name:          "testMacFailure",
+		networkSqn:    "000000000041",
+		networkAmf:    "8000",
+		corruptMacA:   true,
+		expectedCause: nasMessage.Cause5GMMMACFailure,
+	},
+	{
+		name:          "testReplayedSqn",
+		networkSqn:    testSqnMs,
+		networkAmf:    "8000",
+		expectedCause: nasMessage.Cause5GMMSynchFailure,
+	},
+	{
+		name:          "testSqnBeyondFreshnessWindow",
+		networkSqn:    "000010000021",
+		networkAmf:    "8000",
+		expectedCause: nasMessage.Cause5GMMSynchFailure,
+	},
+	{
+		name:          "testNon5GAuthentication",
+		networkSqn:    "000000000041",
+		networkAmf:    "0000",
+		expectedCause: nasMessage.Cause5GMMNon5GAuthenticationUnacceptable,
+	},
+}
+
+func TestDeriveResStarAndSetKey(t *testing.T) {
+	k, _ := hex.DecodeString(testEncPermanentKey)
+	opc, _ := hex.DecodeString(testEncOpcKey)
+	rand, _ := hex.DecodeString("0123456789abcdef0123456789abcdef")
+
+	for _, testCase := range testDeriveResStarAndSetKeyCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			networkSqn, _ := hex.DecodeString(testCase.networkSqn)
+			networkAmf, _ := hex.DecodeString(testCase.networkAmf)
+			_, _, _, autn, err := milenage.GenerateAKAParameters(opc, k, rand, networkSqn, networkAmf)
+			assert.Equal(t, nil, err)
+			if testCase.corruptMacA {
+				autn[len(autn)-1] ^= 0xff
+			}
+
+			_, _, _, resStar, newSqn, err := deriveResStarAndSetKey("supi-208930000000001", security.AlgCiphering128NEA0, security.AlgIntegrity128NIA2, testSqnMs, testEncPermanentKey, testEncOpcKey, rand, autn, "5G:mnc093.mcc208.3gppnetwork.org")
+			if testCase.expectedCause == 0 {
+				assert.Equal(t, nil, err)
+				assert.Equal(t, 16, len(resStar))
+				assert.Equal(t, testCase.expectedSqn, newSqn)
+				return
+			}
+
+			var failure *authenticationFailure
+			assert.Equal(t, true, errors.As(err, &failure))
+			assert.Equal(t, testCase.expectedCause, failure.cause)
+			if testCase.expectedCause != nasMessage.Cause5GMMSynchFailure {
+				return
+			}
+
+			// the network recovers the SQN of the UE from the AUTS
+			sqnMs, err := milenage.ValidateAUTS(opc, k, rand, failure.auts)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, testSqnMs, hex.EncodeToString(sqnMs))
+		})
+	}
+}
+
+var testDeriveEapResponseAndSetKeyCases = []struct {
+	name            string
+	networkSqn      string
+	networkName     string
+	corruptAutn     bool
+	corruptEapMac   bool
+	expectedSubtype uint8
+}{
+	{
+		name:            "testFreshSqn",
+		networkSqn:      "000000000041",
+		networkName:     "5G:mnc093.mcc208.3gppnetwork.org",
+		expectedSubtype: protocol.EAP_AKA_SUBTYPE_CHALLENGE,
+	},
+	{
+		name:            "testReplayedSqn",
+		networkSqn:      testSqnMs,
+		networkName:     "5G:mnc093.mcc208.3gppnetwork.org",
+		expectedSubtype: protocol.EAP_AKA_SUBTYPE_SYNCHRONIZATION_FAILURE,
+	},
+	{
+		name:            "testMacFailure",
+		networkSqn:      "000000000041",
+		networkName:     "5G:mnc093.mcc208.3gppnetwork.org",
+		corruptAutn:     true,
+		expectedSubtype: protocol.EAP_AKA_SUBTYPE_AUTHENTICATION_REJECT,
+	},
+	{
+		name:            "testOtherServingNetwork",
+		networkSqn:      "000000000041",
+		networkName:     "5G:mnc001.mcc001.3gppnetwork.org",
+		expectedSubtype: protocol.EAP_AKA_SUBTYPE_AUTHENTICATION_REJECT,
+	},
+	{
+		name:            "testInvalidEapMac",
+		networkSqn:      "000000000041",
+		networkName:     "5G:mnc093.mcc208.3gppnetwork.org",
+		corruptEapMac:   true,
+		expectedSubtype: protocol.EAP_AKA_SUBTYPE_CLIENT_ERROR,
+	},
+}
+
+func TestDeriveEapResponseAndSetKey(t *testing.T) {
+	k, _ := hex.DecodeString(testEncPermanentKey)
+	opc, _ := hex.DecodeString(testEncOpcKey)
+	rand, _ := hex.DecodeString("0123456789abcdef0123456789abcdef")
+	supi, snName := "imsi-208930000000001", "5G:mnc093.mcc208.3gppnetwork.org"
+
+	for _, testCase := range testDeriveEapResponseAndSetKeyCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			// the challenge of the network as the AUSF builds it
+			networkSqn, _ := hex.DecodeString(testCase.networkSqn)
+			ik, ck, xres, autn, err := milenage.GenerateAKAParameters(opc, k, rand, networkSqn, []byte{0x80, 0x00})
+			assert.Equal(t, nil, err)
+			ckPrime, ikPrime, err := protocol.DeriveCkPrimeIkPrime(ck, ik, testCase.networkName, autn[:6])
+			assert.Equal(t, nil, err)
+			eapKeys := protocol.DeriveEapAkaPrimeKeys(ckPrime, ikPrime, supi)
+			if testCase.corruptAutn {
+				autn[len(autn)-1] ^= 0xff
+			}
+			challenge := (&protocol.EapAkaPrimePacket{
+				Code:       protocol.EAP_CODE_REQUEST,
+				Identifier: 0x2a,
+				Subtype:    protocol.EAP_AKA_SUBTYPE_CHALLENGE,
+				Rand:       rand,
+				Autn:       autn,
+				KdfInput:   testCase.networkName,
+				Kdf:        protocol.EAP_AKA_PRIME_KDF,
+			}).Encode(eapKeys.KAut)
+			if testCase.corruptEapMac {
+				challenge[len(challenge)-1] ^= 0xff
+			}
+
+			kAmf, _, _, eapResponse, newSqn, err := deriveEapResponseAndSetKey(supi, security.AlgCiphering128NEA0, security.AlgIntegrity128NIA2, testSqnMs, testEncPermanentKey, testEncOpcKey, challenge, snName)
+			if testCase.expectedSubtype != protocol.EAP_AKA_SUBTYPE_CHALLENGE {
+				var failure *authenticationFailure
+				assert.Equal(t, true, errors.As(err, &failure))
+				eapResponse = failure.eapMessage
+			} else {
+				assert.Equal(t, nil, err)
+				assert.Equal(t, testCase.networkSqn, newSqn)
+
+				// KAUSF is the first 256 bits of EMSK
+				expectedKAmf, err := deriveKAmf(supi, eapKeys.Emsk[:32], snName)
+				assert.Equal(t, nil, err)
+				assert.Equal(t, expectedKAmf, kAmf)
+			}
+
+			response, err := protocol.DecodeEapAkaPrimePacket(eapResponse)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, protocol.EAP_CODE_RESPONSE, response.Code)
+			assert.Equal(t, uint8(0x2a), response.Identifier)
+			assert.Equal(t, testCase.expectedSubtype, response.Subtype)
+
+			switch testCase.expectedSubtype {
+			case protocol.EAP_AKA_SUBTYPE_CHALLENGE:
+				assert.Equal(t, xres, response.Res)
+				valid, err := protocol.VerifyEapAkaPrimeMac(eapResponse, eapKeys.KAut)
+				assert.Equal(t, nil, err)
+				assert.Equal(t, true, valid)
+			case protocol.EAP_AKA_SUBTYPE_SYNCHRONIZATION_FAILURE:
+				sqnMs, err := milenage.ValidateAUTS(opc, k, rand, response.Auts)
+				assert.Equal(t, nil, err)
+				assert.Equal(t, testSqnMs, hex.EncodeToString(sqnMs))
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package ue
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasConvert"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/nasType"
+)
+
+// serviceRejectError is a service reject of the network, with T3346 of a congested network
+type serviceRejectError struct {
+	cause uint8
+	t3346 time.Duration
+}
+
+func newServiceRejectError(serviceReject *nasMessage.ServiceReject) *serviceRejectError {
+	reject := &serviceRejectError{
+		cause: serviceReject.GetCauseValue(),
+	}
+	if serviceReject.T3346Value != nil {
+		reject.t3346 = util.GprsTimer2ToDuration(serviceReject.T3346Value.GetGPRSTimer2Value())
+	}
+	return reject
+}
+
+func (e *serviceRejectError) Error() string {
+	if e.t3346 > 0 {
+		return fmt.Sprintf("service rejected, cause: %s, T3346: %v", nasMessage.Cause5GMMToString(e.cause), e.t3346)
+	}
+	return fmt.Sprintf("service rejected, cause: %s", nasMessage.Cause5GMMToString(e.cause))
+}
+
+type serviceRejectAction int
+
+const (
+	// the UE stays registered and may request the service again
+	serviceRejectStayRegistered serviceRejectAction = iota
+	// the UE stays registered and requests the service again on the expiry of T3346
+	serviceRejectBackoff
+	// the UE is deregistered and registers again with an initial registration
+	serviceRejectRegisterAgain
+	// the UE is deregistered and barred
+	serviceRejectDeregistered
+)
+
+// serviceRejectActionOf is what the UE does after a service reject as in TS 24.501 5.6.1.5, a congestion
+// without T3346 or with a zero or deactivated T3346 is handled as the other causes
+func serviceRejectActionOf(reject *serviceRejectError) serviceRejectAction {
+	switch {
+	case reject.cause == nasMessage.Cause5GMMUEIdentityCannotBeDerivedByTheNetwork, reject.cause == nasMessage.Cause5GMMImplicitlyDeregistered:
+		return serviceRejectRegisterAgain
+	case isRegistrationContextDeletedBy(reject.cause), reject.cause == cause5GMMNoNetworkSlicesAvailable:
+		return serviceRejectDeregistered
+	case reject.cause == nasMessage.Cause5GMMCongestion && reject.t3346 > 0:
+		return serviceRejectBackoff
+	default:
+		return serviceRejectStayRegistered
+	}
+}
+
+// handleServiceReject keeps the cause in the NAS status, starts T3346 of a congested network, and deregisters the UE locally
+// with its pdu sessions released for a cause which says so, and returns what the UE does next
+func (u *Ue) handleServiceReject(reject *serviceRejectError) serviceRejectAction {
+	u.NasLog.Warnf("Receive Service Reject from RAN, %v", reject)
+	u.setLast5GmmCause(reject.cause)
+
+	action := serviceRejectActionOf(reject)
+	switch action {
+	case serviceRejectBackoff:
+		u.startServiceT3346(reject.t3346)
+	case serviceRejectRegisterAgain, serviceRejectDeregistered:
+		u.registered.Store(false)
+		for _, session := range u.pduSessions {
+			if _, established := u.getUeTunnelDevice(session.id); established {
+				u.setPduSessionReleased(session)
+			}
+		}
+		if isRegistrationContextDeletedBy(reject.cause) {
+			u.deleteRegistrationContext()
+		}
+		u.saveState()
+		u.NasLog.Warnln("UE deregistered by the service reject")
+	}
+	return action
+}
+
+func (u *Ue) startServiceT3346(t3346 time.Duration) {
+	u.nasStatusMtx.Lock()
+	defer u.nasStatusMtx.Unlock()
+
+	u.t3346Expiry = time.Now().Add(t3346)
+	u.NasLog.Warnf("T3346 started, %v", t3346)
+}
+
+// checkServiceT3346 fails a service request while T3346 of a service reject runs
+func (u *Ue) checkServiceT3346() error {
+	u.nasStatusMtx.Lock()
+	defer u.nasStatusMtx.Unlock()
+
+	if remaining := time.Until(u.t3346Expiry); remaining > 0 {
+		return fmt.Errorf("T3346 running, %v left", remaining.Round(time.Second))
+	}
+	return nil
+}
+
+// releaseInactivePduSessions releases locally the established pdu sessions which the pdu session status of the network
+// tells inactive, as in TS 24.501 5.6.1.4
+func (u *Ue) releaseInactivePduSessions(pduSessionStatus *nasType.PDUSessionStatus) {
+	if pduSessionStatus == nil {
+		return
+	}
+
+	active := nasConvert.PSIToBooleanArray(pduSessionStatus.Buffer)
+	for _, session := range u.pduSessions {
+		if _, established := u.getUeTunnelDevice(session.id); established && !active[session.id] {
+			u.setPduSessionReleased(session)
+			u.PduLog.Warnf("PDU session %d is inactive in the network, released locally", session.id)
+		}
+	}
+}
+
+// ServiceRequest brings the registered UE released to RRC_IDLE by the network back to 5GMM-CONNECTED with a service request,
+// the user plane of its established pdu sessions is reactivated and the data plane is brought up again
+func (u *Ue) ServiceRequest() error {
+	u.attachmentMtx.Lock()
+	defer u.attachmentMtx.Unlock()
+
+	if !u.registered.Load() {
+		return fmt.Errorf("UE is not registered")
+	}
+	if rrcState := u.getRrcState(); rrcState != protocol.RRC_STATE_IDLE {
+		return fmt.Errorf("service request in %s is not supported", rrcState)
+	}
+
+	// a pdu session procedure in progress is done before the data plane of its session is brought down
+	u.pduSessionProcedureMtx.Lock()
+	defer u.pduSessionProcedureMtx.Unlock()
+
+	// the connections to RAN of the released UE are closed and its pdu sessions kept, a UE left in RRC_IDLE
+	// by a failed service request has no connection
+	if u.attachment != nil {
+		u.stopAttachmentRoutines()
+		u.releaseConnections()
+		u.attachment = nil
+	}
+
+	err := u.attemptServiceRequest()
+	var reject *serviceRejectError
+	if errors.As(err, &reject) && u.handleServiceReject(reject) == serviceRejectRegisterAgain {
+		if err := u.attach(u.ctx); err != nil {
+			return fmt.Errorf("%+v, error register again: %+v", reject, err)
+		}
+		return fmt.Errorf("%+v, UE registered again", reject)
+	}
+	if err != nil {
+		u.UeLog.Errorf("Error processing service request: %v, %s", err, u.getNasStatusSummary())
+		return err
+	}
+
+	if err := u.receiveDataPlaneRegistration(); err != nil {
+		u.UeLog.Errorf("Error receiving data plane registration: %v", err)
+		if err := u.closeRanControlPlane(); err != nil {
+			u.UeLog.Errorf("Error closing RAN connection: %v", err)
+		}
+		u.setAsSecurityContext(nil)
+		u.setRrcState(protocol.RRC_STATE_IDLE)
+		return err
+	}
+	time.Sleep(1 * time.Second)
+
+	if err := u.startAttachment(u.ctx); err != nil {
+		u.setAsSecurityContext(nil)
+		u.setRrcState(protocol.RRC_STATE_IDLE)
+		return err
+	}
+	u.UeLog.Infof("UE service request accepted, PDU sessions: %s", u.getPduSessionSummary())
+	return nil
+}
+
+// attemptServiceRequest connects to RAN and sends the service request under T3517, the procedure is aborted on the expiry
+// of T3517 without retransmission as in TS 24.501 5.6.1.7, a failed attempt closes the connection and leaves the UE in RRC_IDLE
+func (u *Ue) attemptServiceRequest() error {
+	if err := u.checkServiceT3346(); err != nil {
+		return err
+	}
+	if err := u.connectToRanControlPlane(); err != nil {
+		return fmt.Errorf("error connect to RAN: %+v", err)
+	}
+
+	err := u.withNasTimer(u.nasRetry.t3517, u.processServiceRequest)
+	if err == nil {
+		return nil
+	}
+
+	if err := u.closeRanControlPlane(); err != nil {
+		u.RanLog.Errorf("Error closing RAN connection: %v", err)
+	}
+	u.setAsSecurityContext(nil)
+	u.setRrcState(protocol.RRC_STATE_IDLE)
+	return err
+}
+
+// processServiceRequest requests the service with the 5G-S-TMSI of the UE, for data if it has established pdu sessions whose
+// user plane is reactivated or for signalling otherwise. With a native NAS security context the request is integrity protected
+// and carries the entire request ciphered in the NAS message container, the network may authenticate the UE again
+func (u *Ue) processServiceRequest() error {
+	u.NasLog.Infoln("Processing service request")
+
+	guti5G := u.getGuti5G()
+	if guti5G == nil {
+		return fmt.Errorf("no 5G-GUTI assigned to the UE")
+	}
+	tmsi5GS := buildTmsi5GS(guti5G)
+
+	var psi [16]bool
+	pduSessionIds := make([]uint8, 0)
+	for _, session := range u.pduSessions {
+		if _, established := u.getUeTunnelDevice(session.id); established {
+			psi[session.id] = true
+			pduSessionIds = append(pduSessionIds, session.id)
+		}
+	}
+	pduSessionStatus := nasConvert.PSIToBuf(psi)
+
+	serviceType, establishmentCause := nasMessage.ServiceTypeSignalling, protocol.RRC_ESTABLISHMENT_CAUSE_MO_SIGNALLING
+	var uplinkDataStatus []uint8
+	if len(pduSessionIds) > 0 {
+		serviceType, establishmentCause = nasMessage.ServiceTypeData, protocol.RRC_ESTABLISHMENT_CAUSE_MO_DATA
+		uplinkDataStatus = pduSessionStatus
+	}
+	u.NasLog.Debugf("Service type: %d, PDU sessions to reactivate: %v", serviceType, pduSessionIds)
+
+	nativeSecurityContext := u.hasNativeSecurityContext()
+	ngKsi := uint8(nasMessage.NasKeySetIdentifierNoKeyIsAvailable)
+	if nativeSecurityContext {
+		u.securityContextMtx.Lock()
+		ngKsi = u.ngKsi
+		u.securityContextMtx.Unlock()
+	}
+
+	// send service request, with the native security context only the cleartext IEs are outside the NAS message container
+	entireServiceRequest, err := getServiceRequest(serviceType, ngKsi, tmsi5GS, uplinkDataStatus, pduSessionStatus, nil)
+	if err != nil {
+		return fmt.Errorf("error get entire service request: %+v", err)
+	}
+	serviceRequest := entireServiceRequest
+	if nativeSecurityContext {
+		nasMessageContainer, err := cipherNasMessageContainer(entireServiceRequest, u)
+		if err != nil {
+			return fmt.Errorf("error cipher nas message container: %+v", err)
+		}
+		if serviceRequest, err = getServiceRequest(serviceType, ngKsi, tmsi5GS, nil, nil, nasMessageContainer); err != nil {
+			return fmt.Errorf("error get service request: %+v", err)
+		}
+		if serviceRequest, err = encodeNasPduWithSecurity(serviceRequest, nas.SecurityHeaderTypeIntegrityProtected, u, true, false); err != nil {
+			return fmt.Errorf("error encode service request: %+v", err)
+		}
+	}
+	u.NasLog.Tracef("Service request: %+v", serviceRequest)
+
+	if err := u.processRrcConnectionSetup(serviceRequest, establishmentCause); err != nil {
+		return fmt.Errorf("error process rrc connection setup: %+v", err)
+	}
+	u.NasLog.Debugln("Send service request")
+
+	// the SUCI answers the identity request of a network which does not know the 5G-S-TMSI
+	suci, err := buildUeMobileIdentity5GS(u.mcc, u.mnc, u.msin, &u.suciProfile)
+	if err != nil {
+		return fmt.Errorf("error build ue mobile identity 5gs: %+v", err)
+	}
+	nasPdu, rrcSecurityModeCommand, err := u.processUeAuthentication(suci)
+	if err != nil {
+		return err
+	}
+	if nasPdu != nil {
+		u.NasLog.Tracef("NAS security mode command: %+v", nasPdu)
+		u.NasLog.Debugln("Receive NAS Security Mode Command from RAN")
+
+		// the security mode complete carries the entire service request with the ngKSI of the new security context
+		entireServiceRequest, err := getServiceRequest(serviceType, u.ngKsi, tmsi5GS, uplinkDataStatus, pduSessionStatus, nil)
+		if err != nil {
+			return fmt.Errorf("error get entire service request: %+v", err)
+		}
+		if err := u.sendNasSecurityModeComplete(entireServiceRequest); err != nil {
+			return err
+		}
+
+		if rrcSecurityModeCommand, err = u.readMessageFromRan(); err != nil {
+			return fmt.Errorf("error read rrc security mode command: %+v", err)
+		}
+		if rrcSecurityModeCommand.Type == protocol.MESSAGE_TYPE_NAS {
+			return u.decodeNasReject(rrcSecurityModeCommand.Payload)
+		}
+	} else if !nativeSecurityContext {
+		return fmt.Errorf("error rrc security mode command from RAN without nas security mode command")
+	}
+
+	// KgNB is derived with the uplink nas count of the last uplink nas message as the AMF does for the initial context setup
+	u.securityContextMtx.Lock()
+	u.kGnb, err = protocol.DeriveKgnb(u.kAmf, u.ulCount.Get()-1)
+	u.securityContextMtx.Unlock()
+	if err != nil {
+		return fmt.Errorf("error derive KgNB: %+v", err)
+	}
+	u.NasLog.Tracef("KgNB: %+v", u.kGnb)
+
+	if err := u.processRrcSecurityMode(rrcSecurityModeCommand); err != nil {
+		return fmt.Errorf("error process rrc security mode: %+v", err)
+	}
+
+	// receive nas service accept
+	nasServiceAcceptRaw, err := u.receiveFromRan(protocol.MESSAGE_TYPE_NAS)
+	if err != nil {
+		return fmt.Errorf("error read nas service accept: %+v", err)
+	}
+	u.NasLog.Tracef("Received %d bytes of NAS Service Accept from RAN", len(nasServiceAcceptRaw))
+
+	nasServiceAccept, err := nasDecode(u, nas.GetSecurityHeaderType(nasServiceAcceptRaw), nasServiceAcceptRaw)
+	if err != nil {
+		return fmt.Errorf("error decode nas service accept: %+v", err)
+	}
+	switch nasServiceAccept.GmmHeader.GetMessageType() {
+	case nas.MsgTypeServiceAccept:
+	case nas.MsgTypeServiceReject:
+		return newServiceRejectError(nasServiceAccept.ServiceReject)
+	default:
+		return fmt.Errorf("error nas pdu message type: %+v, expected service accept", nasServiceAccept.GmmHeader.GetMessageType())
+	}
+	u.NasLog.Tracef("NAS service accept: %+v", nasServiceAccept.ServiceAccept)
+	u.NasLog.Debugln("Receive NAS Service Accept from RAN")
+
+	u.releaseInactivePduSessions(nasServiceAccept.ServiceAccept.PDUSessionStatus)
+
+	u.NasLog.Infoln("Service request finished")
+	return nil
+}
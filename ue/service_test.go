@@ -0,0 +1,73 @@
+package ue
+
+import (
+	"testing"
+
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/nasType"
+	"github.com/go-playground/assert"
+)
+
+var testServiceRejectCases = []struct {
+	name           string
+	t3346Value     *nasType.T3346Value
+	cause          uint8
+	expectedAction serviceRejectAction
+	expectedError  string
+}{
+	{
+		name:           "testIllegalUe",
+		cause:          nasMessage.Cause5GMMIllegalUE,
+		expectedAction: serviceRejectDeregistered,
+		expectedError:  "service rejected, cause: Illegal UE (3)",
+	},
+	{
+		name:           "testUeIdentityCannotBeDerived",
+		cause:          nasMessage.Cause5GMMUEIdentityCannotBeDerivedByTheNetwork,
+		expectedAction: serviceRejectRegisterAgain,
+		expectedError:  "service rejected, cause: UE identity cannot be derived by the network (9)",
+	},
+	{
+		name:           "testImplicitlyDeregistered",
+		cause:          nasMessage.Cause5GMMImplicitlyDeregistered,
+		expectedAction: serviceRejectRegisterAgain,
+		expectedError:  "service rejected, cause: Implicitly deregistered (10)",
+	},
+	{
+		name:  "testCongestionWithT3346",
+		cause: nasMessage.Cause5GMMCongestion,
+		t3346Value: &nasType.T3346Value{
+			Iei:   nasMessage.ServiceRejectT3346ValueType,
+			Len:   1,
+			Octet: 0x0f,
+		},
+		expectedAction: serviceRejectBackoff,
+		expectedError:  "service rejected, cause: Congestion (22), T3346: 30s",
+	},
+	{
+		name:           "testCongestionWithoutT3346",
+		cause:          nasMessage.Cause5GMMCongestion,
+		expectedAction: serviceRejectStayRegistered,
+		expectedError:  "service rejected, cause: Congestion (22)",
+	},
+	{
+		name:           "testRestrictedServiceArea",
+		cause:          nasMessage.Cause5GMMRestrictedServiceArea,
+		expectedAction: serviceRejectStayRegistered,
+		expectedError:  "service rejected, cause: Restricted service area (28)",
+	},
+}
+
+func TestServiceReject(t *testing.T) {
+	for _, testCase := range testServiceRejectCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			serviceReject := nasMessage.NewServiceReject(0)
+			serviceReject.SetCauseValue(testCase.cause)
+			serviceReject.T3346Value = testCase.t3346Value
+
+			reject := newServiceRejectError(serviceReject)
+			assert.Equal(t, testCase.expectedError, reject.Error())
+			assert.Equal(t, testCase.expectedAction, serviceRejectActionOf(reject))
+		})
+	}
+}
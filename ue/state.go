@@ -0,0 +1,154 @@
+package ue
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Alonza0314/free-ran-ue/model"
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/nas/nasMessage"
+)
+
+// ueStateFilePath is the state file of the UE in the state directory, one per IMSI so that the UEs of multi UE mode share the directory
+func ueStateFilePath(stateDir, supi string) string {
+	return filepath.Join(stateDir, fmt.Sprintf("imsi-%s.yaml", supi))
+}
+
+// hasNativeSecurityContext tells if the UE keeps a native NAS security context of a successful authentication
+func (u *Ue) hasNativeSecurityContext() bool {
+	u.securityContextMtx.Lock()
+	defer u.securityContextMtx.Unlock()
+
+	return u.hasNativeSecurityContextLocked()
+}
+
+// hasNativeSecurityContextLocked is hasNativeSecurityContext for a caller holding securityContextMtx
+func (u *Ue) hasNativeSecurityContextLocked() bool {
+	return u.ngKsi != uint8(nasMessage.NasKeySetIdentifierNoKeyIsAvailable) && u.kAmf != nil
+}
+
+// loadState resumes the SQN, 5G-GUTI, allowed and configured NSSAI and native NAS security context of the state file, a missing state file
+// leaves the UE as configured and a security context of algorithms which are no longer enabled is not resumed
+func (u *Ue) loadState() error {
+	if u.stateFilePath == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(u.stateFilePath), 0o755); err != nil {
+		return fmt.Errorf("error create ue state directory: %+v", err)
+	}
+
+	var state model.UeStateIE
+	if err := util.LoadFromYaml(u.stateFilePath, &state); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			u.CfgLog.Infof("No UE state file %s, starting from the config", u.stateFilePath)
+			return nil
+		}
+		return fmt.Errorf("error load ue state file %s: %+v", u.stateFilePath, err)
+	}
+
+	if state.SequenceNumber != "" {
+		u.authenticationSubscription.sequenceNumber = state.SequenceNumber
+	}
+
+	if state.Guti5G != "" {
+		guti5G, err := hex.DecodeString(state.Guti5G)
+		if err != nil || len(guti5G) != 11 {
+			return fmt.Errorf("invalid 5G-GUTI %s in ue state file", state.Guti5G)
+		}
+		u.guti5G = guti5G
+	}
+
+	if len(state.AllowedNssai) > 0 {
+		allowedNssai, err := snssaiIesToModels(state.AllowedNssai)
+		if err != nil {
+			return fmt.Errorf("invalid allowed nssai in ue state file: %+v", err)
+		}
+		u.allowedNssai = allowedNssai
+	}
+	if len(state.ConfiguredNssai) > 0 {
+		configuredNssai, err := snssaiIesToModels(state.ConfiguredNssai)
+		if err != nil {
+			return fmt.Errorf("invalid configured nssai in ue state file: %+v", err)
+		}
+		u.configuredNssai = configuredNssai
+	}
+
+	if nasSecurityContext := state.NasSecurityContext; nasSecurityContext != nil {
+		if !u.isNasSecurityAlgorithmsEnabled(nasSecurityContext.CipheringAlgorithm, nasSecurityContext.IntegrityAlgorithm) {
+			u.CfgLog.Warnf("NAS security context of NEA%d and NIA%d in ue state file is not resumed, the algorithms are not enabled", nasSecurityContext.CipheringAlgorithm, nasSecurityContext.IntegrityAlgorithm)
+		} else {
+			kAmf, err := hex.DecodeString(nasSecurityContext.KAmf)
+			if err != nil || len(kAmf) != 32 {
+				return fmt.Errorf("invalid kAMF in ue state file")
+			}
+			kenc, kint, err := deriveAlgorithmKey(kAmf, nasSecurityContext.CipheringAlgorithm, nasSecurityContext.IntegrityAlgorithm)
+			if err != nil {
+				return fmt.Errorf("error derive algorithm key: %+v", err)
+			}
+
+			u.securityContextMtx.Lock()
+			u.cipheringAlgorithm = nasSecurityContext.CipheringAlgorithm
+			u.integrityAlgorithm = nasSecurityContext.IntegrityAlgorithm
+			u.ngKsi = nasSecurityContext.NgKsi
+			u.kAmf = kAmf
+			copy(u.kNasEnc[:], kenc[16:32])
+			copy(u.kNasInt[:], kint[16:32])
+			u.ulCount.Set(uint16(nasSecurityContext.UlCount>>8), uint8(nasSecurityContext.UlCount))
+			u.dlCount.Set(uint16(nasSecurityContext.DlCount>>8), uint8(nasSecurityContext.DlCount))
+			u.securityContextMtx.Unlock()
+		}
+	}
+
+	u.CfgLog.Infof("Loaded UE state file %s, 5G-GUTI: %t, NAS security context: %t", u.stateFilePath, u.guti5G != nil, u.hasNativeSecurityContext())
+	return nil
+}
+
+// saveState writes the SQN, 5G-GUTI, allowed and configured NSSAI and native NAS security context of the UE to the state file,
+// it is called when they change, before each protected uplink NAS message and on deregistration and stop for the NAS COUNTs
+func (u *Ue) saveState() {
+	if u.stateFilePath == "" {
+		return
+	}
+
+	u.stateMtx.Lock()
+	defer u.stateMtx.Unlock()
+
+	state := model.UeStateIE{
+		SequenceNumber: u.authenticationSubscription.sequenceNumber,
+	}
+	if guti5G := u.getGuti5G(); guti5G != nil {
+		state.Guti5G = hex.EncodeToString(guti5G)
+	}
+
+	u.registrationContextMtx.Lock()
+	if len(u.allowedNssai) > 0 {
+		state.AllowedNssai = snssaisToIes(u.allowedNssai)
+	}
+	if len(u.configuredNssai) > 0 {
+		state.ConfiguredNssai = snssaisToIes(u.configuredNssai)
+	}
+	u.registrationContextMtx.Unlock()
+
+	u.securityContextMtx.Lock()
+	if u.hasNativeSecurityContextLocked() {
+		state.NasSecurityContext = &model.NasSecurityContextIE{
+			NgKsi:              u.ngKsi,
+			KAmf:               hex.EncodeToString(u.kAmf),
+			CipheringAlgorithm: u.cipheringAlgorithm,
+			IntegrityAlgorithm: u.integrityAlgorithm,
+			UlCount:            u.ulCount.Get(),
+			DlCount:            u.dlCount.Get(),
+		}
+	}
+	u.securityContextMtx.Unlock()
+
+	if err := util.SaveToYamlAtomically(u.stateFilePath, &state); err != nil {
+		u.UeLog.Warnf("Error save ue state file %s: %+v", u.stateFilePath, err)
+		return
+	}
+	u.UeLog.Tracef("Saved UE state file %s", u.stateFilePath)
+}
@@ -0,0 +1,128 @@
+package ue
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Alonza0314/free-ran-ue/logger"
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/nas/security"
+	"github.com/free5gc/openapi/models"
+	"github.com/go-playground/assert"
+)
+
+var testUeStateCases = []struct {
+	name                  string
+	guti5G                []byte
+	allowedNssai          []models.Snssai
+	ngKsi                 uint8
+	kAmf                  []byte
+	ulCount               uint32
+	dlCount               uint32
+	loadIntegrity         uint8
+	expectedNativeContext bool
+}{
+	{
+		name:   "testNativeSecurityContext",
+		guti5G: []byte{0xf2, 0x02, 0xf8, 0x39, 0xca, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x01},
+		allowedNssai: []models.Snssai{
+			{Sst: 1, Sd: "010203"},
+		},
+		ngKsi:                 2,
+		kAmf:                  make([]byte, 32),
+		ulCount:               0x000105,
+		dlCount:               0x000003,
+		loadIntegrity:         security.AlgIntegrity128NIA2,
+		expectedNativeContext: true,
+	},
+	{
+		name:                  "testNoSecurityContext",
+		guti5G:                []byte{0xf2, 0x02, 0xf8, 0x39, 0xca, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x02},
+		ngKsi:                 uint8(nasMessage.NasKeySetIdentifierNoKeyIsAvailable),
+		loadIntegrity:         security.AlgIntegrity128NIA2,
+		expectedNativeContext: false,
+	},
+	{
+		name:                  "testSecurityContextOfOtherAlgorithm",
+		guti5G:                []byte{0xf2, 0x02, 0xf8, 0x39, 0xca, 0xfe, 0x00, 0x00, 0x00, 0x00, 0x03},
+		ngKsi:                 1,
+		kAmf:                  make([]byte, 32),
+		loadIntegrity:         security.AlgIntegrity128NIA1,
+		expectedNativeContext: false,
+	},
+}
+
+func newTestStateUe(ueLogger *logger.UeLogger, stateFilePath string, integrityAlgorithm uint8) *Ue {
+	return &Ue{
+		authentication: authentication{
+			supi:                         "208930000000001",
+			supportedCipheringAlgorithms: []uint8{security.AlgCiphering128NEA0},
+			supportedIntegrityAlgorithms: []uint8{integrityAlgorithm},
+			cipheringAlgorithm:           security.AlgCiphering128NEA0,
+			integrityAlgorithm:           integrityAlgorithm,
+			ngKsi:                        uint8(nasMessage.NasKeySetIdentifierNoKeyIsAvailable),
+		},
+		authenticationSubscription: authenticationSubscription{
+			sequenceNumber: "000000000023",
+		},
+		stateFilePath: stateFilePath,
+		UeLogger:      ueLogger,
+	}
+}
+
+func TestUeState(t *testing.T) {
+	ueLogger := logger.NewUeLogger("error", "", true)
+
+	for _, testCase := range testUeStateCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			stateFilePath := ueStateFilePath(filepath.Join(t.TempDir(), "state"), "208930000000001")
+
+			ue := newTestStateUe(&ueLogger, stateFilePath, security.AlgIntegrity128NIA2)
+			assert.Equal(t, nil, ue.loadState())
+
+			ue.authenticationSubscription.sequenceNumber = "000000000042"
+			ue.guti5G = testCase.guti5G
+			ue.allowedNssai = testCase.allowedNssai
+			ue.ngKsi = testCase.ngKsi
+			ue.kAmf = testCase.kAmf
+			ue.ulCount.Set(uint16(testCase.ulCount>>8), uint8(testCase.ulCount))
+			ue.dlCount.Set(uint16(testCase.dlCount>>8), uint8(testCase.dlCount))
+			ue.saveState()
+
+			loaded := newTestStateUe(&ueLogger, stateFilePath, testCase.loadIntegrity)
+			assert.Equal(t, nil, loaded.loadState())
+			assert.Equal(t, "000000000042", loaded.authenticationSubscription.sequenceNumber)
+			assert.Equal(t, testCase.guti5G, loaded.getGuti5G())
+			assert.Equal(t, testCase.allowedNssai, loaded.allowedNssai)
+			assert.Equal(t, testCase.expectedNativeContext, loaded.hasNativeSecurityContext())
+			if testCase.expectedNativeContext {
+				assert.Equal(t, testCase.ngKsi, loaded.ngKsi)
+				assert.Equal(t, testCase.kAmf, loaded.kAmf)
+				assert.Equal(t, testCase.ulCount, loaded.ulCount.Get())
+				assert.Equal(t, testCase.dlCount, loaded.dlCount.Get())
+			}
+		})
+	}
+}
+
+func TestUeStateUlCountBeforeUplink(t *testing.T) {
+	ueLogger := logger.NewUeLogger("error", "", true)
+	stateFilePath := ueStateFilePath(filepath.Join(t.TempDir(), "state"), "208930000000001")
+
+	ue := newTestStateUe(&ueLogger, stateFilePath, security.AlgIntegrity128NIA2)
+	assert.Equal(t, nil, ue.loadState())
+	ue.ngKsi = 1
+	ue.kAmf = make([]byte, 32)
+	ue.ulCount.Set(0, 7)
+
+	deregistrationRequest, err := getUeDeRegistrationRequest(nasMessage.AccessType3GPP, 0x00, ue.ngKsi, buildGutiMobileIdentity5GS(testUeStateCases[0].guti5G))
+	assert.Equal(t, nil, err)
+	_, err = encodeNasPduWithSecurity(deregistrationRequest, nas.SecurityHeaderTypeIntegrityProtected, ue, true, false)
+	assert.Equal(t, nil, err)
+
+	// a UE restarted right after the message has left resumes the next count
+	loaded := newTestStateUe(&ueLogger, stateFilePath, security.AlgIntegrity128NIA2)
+	assert.Equal(t, nil, loaded.loadState())
+	assert.Equal(t, uint32(8), loaded.ulCount.Get())
+}
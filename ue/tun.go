@@ -7,7 +7,9 @@ import (
 	"github.com/songgao/water"
 )
 
-func bringUpUeTunnelDevice(ueTunnelDeviceName string, ip string) (*water.Interface, error) {
+// bringUpUeTunnelDevice brings up the tunnel device with the IPv4 address and the IPv6 link local address of the PDU session,
+// either of which may be empty
+func bringUpUeTunnelDevice(ueTunnelDeviceName string, ip string, ipv6LinkLocal string) (*water.Interface, error) {
 	tunCfg := water.Config{
 		DeviceType: water.TUN,
 	}
@@ -18,10 +20,14 @@ func bringUpUeTunnelDevice(ueTunnelDeviceName string, ip string) (*water.Interfa
 		return nil, fmt.Errorf("error creating tunnel device: %v", err)
 	}
 
-	cmds := [][]string{
-		{"ip", "addr", "add", fmt.Sprintf("%s/32", ip), "dev", ueTunnelDeviceName},
-		{"ip", "link", "set", "dev", ueTunnelDeviceName, "up"},
+	cmds := make([][]string, 0, 3)
+	if ip != "" {
+		cmds = append(cmds, []string{"ip", "addr", "add", fmt.Sprintf("%s/32", ip), "dev", ueTunnelDeviceName})
+	}
+	if ipv6LinkLocal != "" {
+		cmds = append(cmds, []string{"ip", "-6", "addr", "add", fmt.Sprintf("%s/64", ipv6LinkLocal), "dev", ueTunnelDeviceName, "nodad"})
 	}
+	cmds = append(cmds, []string{"ip", "link", "set", "dev", ueTunnelDeviceName, "up"})
 
 	for _, cmd := range cmds {
 		if err := exec.Command(cmd[0], cmd[1:]...).Run(); err != nil {
@@ -32,6 +38,33 @@ func bringUpUeTunnelDevice(ueTunnelDeviceName string, ip string) (*water.Interfa
 	return tun, nil
 }
 
+// bringUpUeTapDevice brings up the TAP device of an Ethernet PDU session, which carries the Ethernet frames without UE IP
+func bringUpUeTapDevice(ueTapDeviceName string) (*water.Interface, error) {
+	tapCfg := water.Config{
+		DeviceType: water.TAP,
+	}
+	tapCfg.Name = ueTapDeviceName
+
+	tap, err := water.New(tapCfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating tap device: %v", err)
+	}
+
+	if err := exec.Command("ip", "link", "set", "dev", ueTapDeviceName, "up").Run(); err != nil {
+		return nil, fmt.Errorf("error bringing up tap device: %v", err)
+	}
+
+	return tap, nil
+}
+
+// addUeTunnelDeviceIpv6Address adds the IPv6 address formed from the advertised prefix to the tunnel device
+func addUeTunnelDeviceIpv6Address(ueTunnelDeviceName string, ipv6 string) error {
+	if err := exec.Command("ip", "-6", "addr", "add", fmt.Sprintf("%s/128", ipv6), "dev", ueTunnelDeviceName, "nodad").Run(); err != nil {
+		return fmt.Errorf("error adding ipv6 address to tunnel device: %v", err)
+	}
+	return nil
+}
+
 func bringDownUeTunnelDevice(ueTunnelDeviceName string) error {
 	cmds := [][]string{
 		{"ip", "link", "set", "dev", ueTunnelDeviceName, "down"},
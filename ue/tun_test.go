@@ -9,12 +9,19 @@ var testUeTunnelDeviceName = []struct {
 	name             string
 	tunnelDeviceName string
 	ip               string
+	ipv6LinkLocal    string
 }{
 	{
 		name:             "test1",
 		tunnelDeviceName: "ueTun0",
 		ip:               "10.60.0.1",
 	},
+	{
+		name:             "testIpv4v6",
+		tunnelDeviceName: "ueTun1",
+		ip:               "10.60.0.2",
+		ipv6LinkLocal:    "fe80::2",
+	},
 }
 
 func TestUeTunnelDeviceName(t *testing.T) {
@@ -23,7 +30,7 @@ func TestUeTunnelDeviceName(t *testing.T) {
 	}
 	for _, test := range testUeTunnelDeviceName {
 		t.Run(test.name, func(t *testing.T) {
-			_, err := bringUpUeTunnelDevice(test.tunnelDeviceName, test.ip)
+			_, err := bringUpUeTunnelDevice(test.tunnelDeviceName, test.ip, test.ipv6LinkLocal)
 			if err != nil {
 				t.Fatalf("Error bringing up tunnel device: %v", err)
 			}
@@ -38,3 +45,34 @@ func TestUeTunnelDeviceName(t *testing.T) {
 		})
 	}
 }
+
+var testUeTapDeviceName = []struct {
+	name          string
+	tapDeviceName string
+}{
+	{
+		name:          "testEthernet",
+		tapDeviceName: "ueTap0",
+	},
+}
+
+func TestUeTapDeviceName(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Skipping test because it requires root privileges")
+	}
+	for _, test := range testUeTapDeviceName {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := bringUpUeTapDevice(test.tapDeviceName)
+			if err != nil {
+				t.Fatalf("Error bringing up tap device: %v", err)
+			}
+			defer func() {
+				if err := bringDownUeTunnelDevice(test.tapDeviceName); err != nil {
+					t.Fatalf("Error bringing down tap device: %v", err)
+				}
+			}()
+
+			t.Logf("Tap device %s brought up", test.tapDeviceName)
+		})
+	}
+}
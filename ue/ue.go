@@ -2,38 +2,54 @@ package ue
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net"
-	"strconv"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Alonza0314/free-ran-ue/constant"
 	"github.com/Alonza0314/free-ran-ue/logger"
 	"github.com/Alonza0314/free-ran-ue/model"
+	"github.com/Alonza0314/free-ran-ue/protocol"
 	"github.com/Alonza0314/free-ran-ue/util"
 	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasConvert"
 	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/nas/nasType"
 	"github.com/free5gc/nas/security"
 	"github.com/free5gc/openapi/models"
-	"github.com/songgao/water"
 )
 
 type authentication struct {
 	supi string
 
+	// the algorithms enabled in the config, all advertised in the UE security capability
+	supportedCipheringAlgorithms []uint8
+	supportedIntegrityAlgorithms []uint8
+
+	// the algorithms of the current security context, selected by the security mode command of the network
 	cipheringAlgorithm uint8
 	integrityAlgorithm uint8
 
+	// the ngKSI of the current security context, given by the accepted authentication request
+	ngKsi uint8
+
 	kNasEnc [16]byte
 	kNasInt [16]byte
 	kAmf    []uint8
+	kGnb    []uint8
 
 	ulCount security.Count
 	dlCount security.Count
+
+	// guards the security context above against the state file and NAS of the other routines
+	securityContextMtx sync.Mutex
 }
 
 type authenticationSubscription struct {
@@ -44,19 +60,6 @@ type authenticationSubscription struct {
 	sequenceNumber                string
 }
 
-type pduSession struct {
-	dnn    string
-	sNssai *models.Snssai
-}
-
-type pduSessionEstablishmentAccept struct {
-	ueIp    string
-	qosRule []uint8
-	dnn     string
-	sst     uint8
-	sd      [3]uint8
-}
-
 type dcRanDataPlane struct {
 	ip   string
 	port int
@@ -64,10 +67,30 @@ type dcRanDataPlane struct {
 
 type nrdc struct {
 	enable bool
+	// the NR-DC of the config, restored for every registration
+	static bool
 	dcRanDataPlane
 	dcLocalDataPlaneIp string
 	specifiedFlow      []string
-	rwLock             sync.RWMutex
+	scgDrb             *protocol.PdcpEntity
+	// the uplink leg of the primary pdu session, forced to a cell group by the API
+	uplinkLeg string
+	rwLock    sync.RWMutex
+}
+
+// attachment is the run of the UE from its registration to its deregistration, its routines stop with the cancel
+type attachment struct {
+	cancel context.CancelFunc
+	wg     *sync.WaitGroup
+}
+
+// dataPlaneCounters counts the data plane packets of the UE since start
+type dataPlaneCounters struct {
+	ulPackets    atomic.Uint64
+	ulBytes      atomic.Uint64
+	scgUlPackets atomic.Uint64
+	dlPackets    atomic.Uint64
+	dlBytes      atomic.Uint64
 }
 
 type Ue struct {
@@ -78,30 +101,78 @@ type Ue struct {
 	ranControlPlanePort int
 	ranDataPlanePort    int
 
-	ranControlPlaneConn net.Conn
-	ranDataPlaneConn    net.Conn
-	dcRanDataPlaneConn  net.Conn
+	ranControlPlaneConn   net.Conn
+	ranControlPlaneReader *ranControlPlaneReader
+	ranDataPlaneConn      net.Conn
+	dcRanDataPlaneConn    net.Conn
+
+	ranControlPlaneWriteMtx sync.Mutex
 
 	mcc  string
 	mnc  string
 	msin string
 
+	suciProfile util.SuciProfile
+
 	authentication
 
 	accessType models.AccessType
 	authenticationSubscription
 
-	pduSession
+	pduSessions            []*pduSession
+	primaryPduSessionId    uint8
+	pduSessionMtx          sync.Mutex
+	pduSessionProcedureMtx sync.Mutex
+
+	// the procedure transaction identity of the last UE requested pdu session procedure, guarded by pduSessionProcedureMtx
+	pti uint8
+
+	// the dedicated nas of an rrc reconfiguration or the DL NAS transport of a 5GSM message for the pdu session procedure
+	// waiting for it, e.g. the accept of an on demand pdu session
+	dedicatedNas chan []byte
+
+	// the UE requested procedures waiting for their downlink nas message, which is handed to the procedure instead of
+	// being handled as initiated by the network
+	registrationNasWaiting bool
+	pduSessionNasWaiting   bool
+	nasWaiterMtx           sync.Mutex
+
+	// cleared by the deregistration of either the UE or the network
+	registered atomic.Bool
+
+	registrationContext
+
+	// the retry and back-off policy of the NAS procedures and their outcome
+	nasRetry nasRetryPolicy
+	nasStatus
+
+	// the state file kept across runs, empty without a state directory
+	stateFilePath string
+	stateMtx      sync.Mutex
 
 	nrdc
 
-	ueTunnelDeviceName string
-	ueTunnelDevice     *water.Interface
+	// the context of Start, a registration by the API attaches the UE under it
+	ctx           context.Context
+	attachment    *attachment
+	attachmentMtx sync.Mutex
+
+	dataPlaneCounters
+
+	// the downlink packets of a pdu session kept in user space are counted and dropped
+	userspaceDlPackets atomic.Uint64
+	userspaceTraffic   userspaceTraffic
+
+	readFromTun chan dataPlanePacket
+	readFromRan chan dataPlanePacket
+
+	dataPlaneRegistration util.DataPlaneRegistration
+	// the counter of the data plane registration requests, increased with each request against replay
+	dataPlaneRegistrationCounter atomic.Uint64
 
-	readFromTun chan []byte
-	readFromRan chan []byte
+	rrc
 
-	pduSessionEstablishmentAccept
+	api
 
 	*logger.UeLogger
 }
@@ -109,34 +180,53 @@ type Ue struct {
 func NewUe(config *model.UeConfig, logger *logger.UeLogger) *Ue {
 	supi := config.Ue.PlmnId.Mcc + config.Ue.PlmnId.Mnc + config.Ue.Msin
 
+	// the first enabled algorithms derive the keys of an authentication until the network selects its own
+	supportedIntegrityAlgorithms := enabledAlgorithms(config.Ue.IntegrityAlgorithm.Nia0, config.Ue.IntegrityAlgorithm.Nia1, config.Ue.IntegrityAlgorithm.Nia2, config.Ue.IntegrityAlgorithm.Nia3)
 	var integrityAlgorithm uint8
-	if config.Ue.IntegrityAlgorithm.Nia0 {
-		integrityAlgorithm = security.AlgIntegrity128NIA0
-	} else if config.Ue.IntegrityAlgorithm.Nia1 {
-		integrityAlgorithm = security.AlgIntegrity128NIA1
-	} else if config.Ue.IntegrityAlgorithm.Nia2 {
-		integrityAlgorithm = security.AlgIntegrity128NIA2
-	} else if config.Ue.IntegrityAlgorithm.Nia3 {
-		integrityAlgorithm = security.AlgIntegrity128NIA3
+	if len(supportedIntegrityAlgorithms) > 0 {
+		integrityAlgorithm = supportedIntegrityAlgorithms[0]
 	}
 
+	supportedCipheringAlgorithms := enabledAlgorithms(config.Ue.CipheringAlgorithm.Nea0, config.Ue.CipheringAlgorithm.Nea1, config.Ue.CipheringAlgorithm.Nea2, config.Ue.CipheringAlgorithm.Nea3)
 	var cipheringAlgorithm uint8
-	if config.Ue.CipheringAlgorithm.Nea0 {
-		cipheringAlgorithm = security.AlgCiphering128NEA0
-	} else if config.Ue.CipheringAlgorithm.Nea1 {
-		cipheringAlgorithm = security.AlgCiphering128NEA1
-	} else if config.Ue.CipheringAlgorithm.Nea2 {
-		cipheringAlgorithm = security.AlgCiphering128NEA2
-	} else if config.Ue.CipheringAlgorithm.Nea3 {
-		cipheringAlgorithm = security.AlgCiphering128NEA3
+	if len(supportedCipheringAlgorithms) > 0 {
+		cipheringAlgorithm = supportedCipheringAlgorithms[0]
+	}
+
+	authenticationMethod := models.AuthMethod(config.Ue.AuthenticationSubscription.AuthenticationMethod)
+	if authenticationMethod == "" {
+		authenticationMethod = models.AuthMethod__5_G_AKA
+	}
+
+	pduSessions, err := newPduSessions(config.Ue.PduSessions, config.Ue.UeTunnelDevice)
+	if err != nil {
+		logger.CfgLog.Errorf("Error building pdu sessions: %v", err)
+	}
+
+	defaultConfiguredNssai, err := snssaiIesToModels(config.Ue.ConfiguredNssai)
+	if err != nil {
+		logger.CfgLog.Errorf("Error building configured nssai: %v", err)
 	}
 
-	sstInt, err := strconv.Atoi(config.Ue.PduSession.Snssai.Sst)
+	homeNetworkPublicKey, err := hex.DecodeString(config.Ue.Suci.HomeNetworkPublicKey)
 	if err != nil {
-		logger.CfgLog.Errorf("Error converting sst to int: %v", err)
+		logger.CfgLog.Errorf("Error decoding home network public key: %v", err)
 	}
 
-	return &Ue{
+	selectedNrCellIdentity := protocol.RRC_NR_CELL_IDENTITY_NONE
+	if config.Ue.NrCellId != "" {
+		selectedNrCellIdentity, err = util.NrCellIdToUint64(config.Ue.NrCellId)
+		if err != nil {
+			logger.CfgLog.Errorf("Error converting nr cell id: %v", err)
+		}
+	}
+
+	stateFilePath := ""
+	if config.Ue.StateDir != "" {
+		stateFilePath = ueStateFilePath(config.Ue.StateDir, supi)
+	}
+
+	ue := &Ue{
 		ranControlPlaneIp: config.Ue.RanControlPlaneIp,
 		ranDataPlaneIp:    config.Ue.RanDataPlaneIp,
 		localDataPlaneIp:  config.Ue.LocalDataPlaneIp,
@@ -148,117 +238,330 @@ func NewUe(config *model.UeConfig, logger *logger.UeLogger) *Ue {
 		mnc:  config.Ue.PlmnId.Mnc,
 		msin: config.Ue.Msin,
 
+		suciProfile: util.SuciProfile{
+			RoutingIndicator:       config.Ue.Suci.RoutingIndicator,
+			ProtectionScheme:       config.Ue.Suci.ProtectionScheme,
+			HomeNetworkPublicKeyId: config.Ue.Suci.HomeNetworkPublicKeyId,
+			HomeNetworkPublicKey:   homeNetworkPublicKey,
+		},
+
 		authentication: authentication{
 			supi: supi,
 
+			supportedCipheringAlgorithms: supportedCipheringAlgorithms,
+			supportedIntegrityAlgorithms: supportedIntegrityAlgorithms,
+
 			cipheringAlgorithm: cipheringAlgorithm,
 			integrityAlgorithm: integrityAlgorithm,
 
+			ngKsi: uint8(nasMessage.NasKeySetIdentifierNoKeyIsAvailable),
+
 			ulCount: security.Count{},
 			dlCount: security.Count{},
+
+			securityContextMtx: sync.Mutex{},
 		},
 
 		accessType: models.AccessType(config.Ue.AccessType),
 		authenticationSubscription: authenticationSubscription{
-			authenticationMethod:          models.AuthMethod__5_G_AKA,
+			authenticationMethod:          authenticationMethod,
 			encPermanentKey:               config.Ue.AuthenticationSubscription.EncPermanentKey,
 			encOpcKey:                     config.Ue.AuthenticationSubscription.EncOpcKey,
 			authenticationManagementField: config.Ue.AuthenticationSubscription.AuthenticationManagementField,
 			sequenceNumber:                config.Ue.AuthenticationSubscription.SequenceNumber,
 		},
 
-		pduSession: pduSession{
-			dnn: config.Ue.PduSession.Dnn,
-			sNssai: &models.Snssai{
-				Sst: int32(sstInt),
-				Sd:  config.Ue.PduSession.Snssai.Sd,
-			},
+		pduSessions:            pduSessions,
+		pduSessionMtx:          sync.Mutex{},
+		pduSessionProcedureMtx: sync.Mutex{},
+
+		dedicatedNas: make(chan []byte, 1),
+		nasWaiterMtx: sync.Mutex{},
+
+		registrationContext: registrationContext{
+			defaultConfiguredNssai: defaultConfiguredNssai,
+			registrationNas:        make(chan *nas.Message, 1),
+			t3512Restart:           make(chan struct{}, 1),
 		},
 
+		nasRetry: newNasRetryPolicy(&config.Ue.NasRetry),
+
+		stateFilePath: stateFilePath,
+
+		userspaceTraffic: newUserspaceTraffic(&config.MultiUe),
+
 		nrdc: nrdc{
 			enable: config.Ue.Nrdc.Enable,
+			static: config.Ue.Nrdc.Enable,
 			dcRanDataPlane: dcRanDataPlane{
 				ip:   config.Ue.Nrdc.DcRanDataPlane.Ip,
 				port: config.Ue.Nrdc.DcRanDataPlane.Port,
 			},
 			dcLocalDataPlaneIp: config.Ue.Nrdc.DcLocalDataPlaneIp,
 			specifiedFlow:      make([]string, 0),
+			uplinkLeg:          constant.UE_NRDC_UPLINK_LEG_AUTO,
 			rwLock:             sync.RWMutex{},
 		},
 
-		ueTunnelDeviceName: config.Ue.UeTunnelDevice,
+		rrc: rrc{
+			rrcState:               protocol.RRC_STATE_IDLE,
+			selectedNrCellIdentity: selectedNrCellIdentity,
+			rrcMtx:                 sync.Mutex{},
+		},
+
+		api: api{
+			enable: config.Ue.Api.Enable,
+			ip:     config.Ue.Api.Ip,
+			port:   config.Ue.Api.Port,
+
+			router: nil,
+			server: nil,
+		},
 
 		UeLogger: logger,
 	}
+	ue.api.procedures = ue
+
+	if err := ue.loadState(); err != nil {
+		logger.CfgLog.Errorf("Error loading UE state: %v", err)
+	}
+	return ue
 }
 
 func (u *Ue) Start(ctx context.Context, wg *sync.WaitGroup) error {
 	u.UeLog.Infof("Starting UE: imsi-%s", u.supi)
 
-	if err := u.connectToRanControlPlane(); err != nil {
-		u.UeLog.Errorf("Error connecting to RAN: %v", err)
+	u.attachmentMtx.Lock()
+	u.ctx = ctx
+	err := u.attach(ctx)
+	u.attachmentMtx.Unlock()
+	if err != nil {
 		return err
 	}
 
-	if err := u.processUeRegistration(); err != nil {
-		u.UeLog.Errorf("Error processing UE registration: %v", err)
-		if err := u.ranControlPlaneConn.Close(); err != nil {
-			u.UeLog.Errorf("Error closing RAN connection: %v", err)
+	// the routines of the current attachment are done before Stop deregisters the UE
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+
+		u.attachmentMtx.Lock()
+		defer u.attachmentMtx.Unlock()
+		u.stopAttachmentRoutines()
+	}()
+
+	if u.api.enable {
+		u.startApiServer()
+	}
+
+	u.UeLog.Infof("UE started, %s", u.getNasStatusSummary())
+	return nil
+}
+
+func (u *Ue) Stop() {
+	u.UeLog.Infof("Stopping UE: imsi-%s", u.supi)
+
+	if u.api.enable {
+		u.stopApiServer()
+	}
+
+	u.attachmentMtx.Lock()
+	defer u.attachmentMtx.Unlock()
+
+	if u.attachment == nil && !u.registered.Load() {
+		u.UeLog.Infoln("UE already deregistered")
+	} else {
+		u.stopAttachmentRoutines()
+		if !u.registered.Load() {
+			u.UeLog.Infoln("UE already deregistered by the network")
+		} else if err := u.processUeDeregistration(); err != nil {
+			u.UeLog.Errorf("Error processing UE deregistration: %v", err)
 		}
+		u.releaseAttachment()
+	}
+
+	// the 5G-GUTI and NAS security context are kept for the next start
+	u.saveState()
+
+	u.UeLog.Infoln("UE stopped")
+}
+
+// Register registers the UE again after its deregistration by the API or by the network, and establishes the pdu sessions
+// which are not on demand as at start
+func (u *Ue) Register() error {
+	u.attachmentMtx.Lock()
+	defer u.attachmentMtx.Unlock()
+
+	if u.registered.Load() {
+		return fmt.Errorf("UE is already registered")
+	}
+	if u.attachment != nil {
+		// the connections of a UE deregistered by the network are still up
+		u.stopAttachmentRoutines()
+		u.releaseAttachment()
+	}
+
+	if err := u.attach(u.ctx); err != nil {
+		return err
+	}
+	u.UeLog.Infof("UE registered again, %s", u.getNasStatusSummary())
+	return nil
+}
+
+// Deregister deregisters the UE which keeps running, its pdu sessions and connections to RAN are released
+// until the UE registers again with Register
+func (u *Ue) Deregister() error {
+	u.attachmentMtx.Lock()
+	defer u.attachmentMtx.Unlock()
+
+	if u.attachment == nil && !u.registered.Load() {
+		return fmt.Errorf("UE is not registered")
+	}
+
+	// a pdu session procedure in progress is done before its session is released
+	u.pduSessionProcedureMtx.Lock()
+	defer u.pduSessionProcedureMtx.Unlock()
+
+	u.stopAttachmentRoutines()
+	var err error
+	if u.registered.Load() {
+		err = u.processUeDeregistration()
+	}
+	u.releaseAttachment()
+	u.saveState()
+
+	if err != nil {
+		return fmt.Errorf("error process ue deregistration: %+v", err)
+	}
+	u.UeLog.Infoln("UE deregistered, waiting for registration")
+	return nil
+}
+
+// attach registers the UE, establishes the pdu sessions which are not on demand and brings up the data plane,
+// the routines of the registered UE run under the attachment until it is stopped, the caller holds attachmentMtx
+func (u *Ue) attach(ctx context.Context) error {
+	if err := u.processUeRegistrationWithRetry(ctx); err != nil {
+		u.UeLog.Errorf("Error processing UE registration: %v, %s", err, u.getNasStatusSummary())
 		return err
 	}
 	time.Sleep(1 * time.Second)
 
-	if err := u.processPduSessionEstablishment(); err != nil {
-		u.UeLog.Errorf("Error processing PDU session establishment: %v", err)
-		if err := u.ranControlPlaneConn.Close(); err != nil {
+	if err := u.receiveDataPlaneRegistration(); err != nil {
+		u.UeLog.Errorf("Error receiving data plane registration: %v", err)
+		if err := u.closeRanControlPlane(); err != nil {
 			u.UeLog.Errorf("Error closing RAN connection: %v", err)
 		}
 		return err
 	}
+
+	// the pdu sessions are established one by one, an on demand session is left to EstablishPduSession
+	pduSessionProcedureStarted := false
+	for _, session := range u.pduSessions {
+		if session.onDemand {
+			continue
+		}
+		if pduSessionProcedureStarted {
+			time.Sleep(1 * time.Second)
+		}
+		pduSessionProcedureStarted = true
+
+		if err := u.processPduSessionEstablishment(session, u.receiveRrcReconfiguration); err != nil {
+			u.UeLog.Errorf("Error processing PDU session %d establishment: %v, %s", session.id, err, u.getNasStatusSummary())
+			if err := u.closeRanControlPlane(); err != nil {
+				u.UeLog.Errorf("Error closing RAN connection: %v", err)
+			}
+			return err
+		}
+	}
 	time.Sleep(1 * time.Second)
 
+	return u.startAttachment(ctx)
+}
+
+// startAttachment brings up the data plane of the established pdu sessions and starts the routines of the attachment,
+// the connection to the RAN control plane is closed on failure, the caller holds attachmentMtx
+func (u *Ue) startAttachment(ctx context.Context) error {
 	if err := u.connectToRanDataPlane(); err != nil {
 		u.UeLog.Errorf("Error connecting to RAN data plane: %v", err)
-		if err := u.ranControlPlaneConn.Close(); err != nil {
+		if err := u.closeRanControlPlane(); err != nil {
 			u.UeLog.Errorf("Error closing RAN connection: %v", err)
 		}
 		return err
 	}
 
-	if err := u.setupTunnelDevice(); err != nil {
-		u.UeLog.Errorf("Error setting up tunnel device: %v", err)
+	if err := u.setupDataPlane(); err != nil {
+		u.UeLog.Errorf("Error setting up data plane: %v", err)
 		if err := u.ranDataPlaneConn.Close(); err != nil {
 			u.UeLog.Errorf("Error closing RAN connection: %v", err)
 		}
-		if err := u.ranControlPlaneConn.Close(); err != nil {
+		if err := u.closeRanControlPlane(); err != nil {
 			u.UeLog.Errorf("Error closing RAN connection: %v", err)
 		}
 		return err
 	}
 
+	attachmentCtx, cancel := context.WithCancel(ctx)
+	u.attachment = &attachment{
+		cancel: cancel,
+		wg:     &sync.WaitGroup{},
+	}
+
 	// wait for RAN message
-	go u.waitForRanMessage(ctx, wg)
+	go u.waitForRanMessage(attachmentCtx, u.attachment.wg)
 
 	// handle data plane
-	go u.handleDataPlane(ctx, wg)
+	go u.handleDataPlane(attachmentCtx, u.attachment.wg)
+
+	// send the uplink traffic of the data plane kept in user space
+	go u.generateUserspaceTraffic(attachmentCtx, u.attachment.wg)
+
+	// report measurements of the primary and secondary cells to RAN
+	go u.reportRrcMeasurement(attachmentCtx, u.attachment.wg)
+
+	// keep the data plane registration alive, e.g. rebind after a NAT port change
+	go u.refreshDataPlaneRegistration(attachmentCtx, u.attachment.wg)
+
+	// register again on the expiry of T3512
+	go u.runPeriodicRegistrationUpdate(attachmentCtx, u.attachment.wg)
 
-	u.UeLog.Infoln("UE started")
 	return nil
 }
 
-func (u *Ue) Stop() {
-	u.UeLog.Infof("Stopping UE: imsi-%s", u.supi)
+// stopAttachmentRoutines stops the routines of the attachment, waitForRanMessage gives up on its read without consuming
+// a frame so that the deregistration can still read from RAN, the caller holds attachmentMtx
+func (u *Ue) stopAttachmentRoutines() {
+	if u.attachment == nil {
+		return
+	}
+	u.attachment.cancel()
+	u.attachment.wg.Wait()
+}
 
-	if err := u.processUeDeregistration(); err != nil {
-		u.UeLog.Errorf("Error processing UE deregistration: %v", err)
+// releaseAttachment tears down the data plane and the pdu sessions and closes the connections to RAN, the connections of a UE
+// left in RRC_IDLE by a failed service request are closed already, the caller holds attachmentMtx
+func (u *Ue) releaseAttachment() {
+	if u.attachment != nil {
+		u.releaseConnections()
 	}
+	for _, session := range u.pduSessions {
+		if _, established := u.getUeTunnelDevice(session.id); established {
+			u.setPduSessionReleased(session)
+		}
+	}
+
+	u.attachment = nil
+}
 
+// releaseConnections tears down the data plane and closes the connections to RAN keeping the pdu sessions, the AS security context
+// ends with the connection and NR-DC is back to the one of the config, the caller holds attachmentMtx
+func (u *Ue) releaseConnections() {
 	close(u.readFromTun)
 	close(u.readFromRan)
 
-	if err := u.cleanUpTunnelDevice(); err != nil {
-		u.UeLog.Errorf("Error cleaning up tunnel device: %v", err)
+	u.cleanUpTunnelDevices()
+	select {
+	case <-u.dedicatedNas:
+	default:
 	}
 
 	if err := u.ranDataPlaneConn.Close(); err != nil {
@@ -271,11 +574,17 @@ func (u *Ue) Stop() {
 		}
 	}
 
-	if err := u.ranControlPlaneConn.Close(); err != nil {
+	if err := u.closeRanControlPlane(); err != nil {
 		u.UeLog.Errorf("Error closing RAN connection: %v", err)
 	}
 
-	u.UeLog.Infoln("UE stopped")
+	u.setAsSecurityContext(nil)
+	u.setRrcState(protocol.RRC_STATE_IDLE)
+
+	u.rwLock.Lock()
+	u.nrdc.enable = u.nrdc.static
+	u.nrdc.scgDrb = nil
+	u.rwLock.Unlock()
 }
 
 func (u *Ue) connectToRanControlPlane() error {
@@ -291,11 +600,18 @@ func (u *Ue) connectToRanControlPlane() error {
 	u.RanLog.Debugln("Dial TCP to RAN control plane success")
 
 	u.ranControlPlaneConn = conn
+	u.ranControlPlaneReader = newRanControlPlaneReader(conn)
 
 	u.RanLog.Infof("Connected to RAN control plane: %s:%d", u.ranControlPlaneIp, u.ranControlPlanePort)
 	return nil
 }
 
+// closeRanControlPlane stops the reader of the RAN control plane and closes the connection
+func (u *Ue) closeRanControlPlane() error {
+	u.ranControlPlaneReader.close()
+	return u.ranControlPlaneConn.Close()
+}
+
 func (u *Ue) connectToRanDataPlane() error {
 	u.RanLog.Infoln("Connecting to RAN data plane")
 
@@ -308,11 +624,10 @@ func (u *Ue) connectToRanDataPlane() error {
 	u.ranDataPlaneConn = conn
 	u.RanLog.Debugln("Dial UDP to RAN data plane success")
 
-	_, err = u.ranDataPlaneConn.Write([]byte(constant.UE_DATA_PLANE_INITIAL_PACKET))
-	if err != nil {
-		return fmt.Errorf("error send initial packet: %+v", err)
+	if err := u.sendDataPlaneRegistration(u.ranDataPlaneConn); err != nil {
+		return err
 	}
-	u.RanLog.Debugln("Sent initial packet to RAN data plane UDP server")
+	u.RanLog.Debugln("Sent data plane registration to RAN data plane UDP server")
 
 	if u.isNrdcEnabled() {
 		conn, err := util.UdpDialWithOptionalLocalAddress(u.nrdc.dcRanDataPlane.ip, u.nrdc.dcRanDataPlane.port, u.nrdc.dcLocalDataPlaneIp)
@@ -322,134 +637,232 @@ func (u *Ue) connectToRanDataPlane() error {
 		u.dcRanDataPlaneConn = conn
 		u.RanLog.Debugln("Dial UDP to DC RAN data plane success")
 
-		_, err = u.dcRanDataPlaneConn.Write([]byte(constant.UE_DATA_PLANE_INITIAL_PACKET))
-		if err != nil {
-			return fmt.Errorf("error send initial packet: %+v", err)
+		if err := u.sendDataPlaneRegistration(u.dcRanDataPlaneConn); err != nil {
+			return err
 		}
-		u.RanLog.Debugln("Sent initial packet to DC RAN data plane UDP server")
+		u.RanLog.Debugln("Sent data plane registration to DC RAN data plane UDP server")
 	}
 
 	u.RanLog.Infof("Connected to RAN data plane: %s:%d", u.ranDataPlaneIp, u.ranDataPlanePort)
 	return nil
 }
 
-func (u *Ue) processUeRegistration() error {
-	u.RanLog.Infoln("Processing UE Registration")
+func (u *Ue) receiveDataPlaneRegistration() error {
+	u.RanLog.Infoln("Receiving data plane registration")
 
-	mobileIdentity5GS := buildUeMobileIdentity5GS(u.supi)
-	u.NasLog.Tracef("Mobile identity 5GS: %+v", mobileIdentity5GS)
+	// the rrc reconfigurations of the pdu sessions reactivated by a service request come before the token
+	var message *protocol.Message
+	for {
+		var err error
+		if message, err = u.readMessageFromRan(); err != nil {
+			return fmt.Errorf("error read data plane token message: %+v", err)
+		}
+		if message.Type != protocol.MESSAGE_TYPE_RRC {
+			break
+		}
+		rrcReconfiguration := &protocol.RrcMessage{}
+		if err := rrcReconfiguration.Unmarshal(message.Payload); err != nil {
+			return fmt.Errorf("error unmarshal rrc reconfiguration: %+v", err)
+		}
+		if rrcReconfiguration.Type != protocol.RRC_RECONFIGURATION {
+			return fmt.Errorf("unexpected rrc message %s, expected %s", rrcReconfiguration.Type, protocol.RRC_RECONFIGURATION)
+		}
+		u.handleRrcReconfiguration(rrcReconfiguration)
+	}
+	switch message.Type {
+	case protocol.MESSAGE_TYPE_DATA_PLANE_TOKEN:
+	case protocol.MESSAGE_TYPE_REJECT:
+		return fmt.Errorf("rejected by RAN, cause: %s", protocol.CauseFromPayload(message.Payload))
+	default:
+		return fmt.Errorf("unexpected message type %s, expected %s", message.Type, protocol.MESSAGE_TYPE_DATA_PLANE_TOKEN)
+	}
+	dataPlaneTokenMessage := message.Payload
+	u.RanLog.Tracef("Received %d bytes of data plane token message from RAN", len(dataPlaneTokenMessage))
 
-	ueSecurityCapability := buildUeSecurityCapability(u.cipheringAlgorithm, u.integrityAlgorithm)
-	u.NasLog.Tracef("UE security capability: %+v", ueSecurityCapability)
+	if err := u.dataPlaneRegistration.Unmarshal(constant.UE_DATA_PLANE_TOKEN, dataPlaneTokenMessage); err != nil {
+		return fmt.Errorf("error unmarshal data plane token message: %+v", err)
+	}
 
-	// send ue registration request
-	registrationRequest, err := getUeRegistrationRequest(nasMessage.RegistrationType5GSInitialRegistration, &mobileIdentity5GS, nil, &ueSecurityCapability, nil, nil, nil)
+	u.RanLog.Infof("Received data plane registration for %s", u.dataPlaneRegistration.Identity)
+	return nil
+}
+
+func (u *Ue) sendDataPlaneRegistration(conn net.Conn) error {
+	dataPlaneRegistration, err := util.NewDataPlaneRegistrationRequest(&u.dataPlaneRegistration, u.dataPlaneRegistrationCounter.Add(1)).Marshal()
 	if err != nil {
-		return fmt.Errorf("error get ue registration request: %+v", err)
+		return fmt.Errorf("error marshal data plane registration: %+v", err)
 	}
-	u.NasLog.Tracef("Get UE %s registration request: %+v", u.supi, registrationRequest)
 
-	n, err := u.ranControlPlaneConn.Write(registrationRequest)
+	n, err := conn.Write(dataPlaneRegistration)
 	if err != nil {
-		return fmt.Errorf("error send ue registration request: %+v", err)
+		return fmt.Errorf("error send data plane registration: %+v", err)
 	}
-	u.NasLog.Tracef("Sent %d bytes of UE %s registration request", n, u.supi)
-	u.NasLog.Debugln("Send UE registration request")
+	u.RanLog.Tracef("Sent %d bytes of data plane registration to %s", n, conn.RemoteAddr().String())
+	return nil
+}
 
-	// receive nas authentication request
-	nasAuthenticationRequestRaw := make([]byte, 1024)
-	n, err = u.ranControlPlaneConn.Read(nasAuthenticationRequestRaw)
-	if err != nil {
-		return fmt.Errorf("error read nas authentication request: %+v", err)
+func (u *Ue) refreshDataPlaneRegistration(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	defer wg.Done()
+
+	ticker := time.NewTicker(constant.UE_DATA_PLANE_REGISTRATION_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := u.sendDataPlaneRegistration(u.ranDataPlaneConn); err != nil {
+				u.RanLog.Warnf("Error refresh data plane registration: %+v", err)
+			}
+
+			u.rwLock.RLock()
+			if u.nrdc.enable && u.dcRanDataPlaneConn != nil {
+				if err := u.sendDataPlaneRegistration(u.dcRanDataPlaneConn); err != nil {
+					u.RanLog.Warnf("Error refresh DC data plane registration: %+v", err)
+				}
+			}
+			u.rwLock.RUnlock()
+		}
 	}
-	u.NasLog.Tracef("Received %d bytes of NAS Authentication Request from RAN", n)
+}
+
+// processUeRegistration registers the UE with the 5G-GUTI of its last registration, or with its SUCI for the first one,
+// and the SUCI answers the identity request of a network which does not know the 5G-GUTI. With a native NAS security context
+// the request carries its ngKSI and is integrity protected, so that the network may take the context without authentication
+func (u *Ue) processUeRegistration() error {
+	u.RanLog.Infoln("Processing UE Registration")
 
-	nasPdu, err := nasDecode(u, nas.GetSecurityHeaderType(nasAuthenticationRequestRaw[:n]), nasAuthenticationRequestRaw[:n])
+	suci, err := buildUeMobileIdentity5GS(u.mcc, u.mnc, u.msin, &u.suciProfile)
 	if err != nil {
-		return fmt.Errorf("error decode nas authentication request: %+v", err)
+		return fmt.Errorf("error build ue mobile identity 5gs: %+v", err)
 	}
-	if nasPdu.GmmHeader.GetMessageType() != nas.MsgTypeAuthenticationRequest {
-		return fmt.Errorf("error nas pdu message type: %+v, expected authenticatoin request", nasPdu)
+
+	mobileIdentity5GS := suci
+	nativeSecurityContext := false
+	if guti5G := u.getGuti5G(); guti5G != nil {
+		mobileIdentity5GS = buildGutiMobileIdentity5GS(guti5G)
+		nativeSecurityContext = u.hasNativeSecurityContext()
+		_, guti := nasConvert.GutiToString(guti5G)
+		u.NasLog.Infof("Initial registration with 5G-GUTI: %s, native NAS security context: %t", guti, nativeSecurityContext)
 	}
-	u.NasLog.Tracef("NAS authentication request: %+v", nasPdu)
-	u.NasLog.Debugln("Receive NAS Authentication Request from RAN")
+	u.NasLog.Tracef("Mobile identity 5GS: %+v", mobileIdentity5GS)
 
-	// calculate for RES* and send nas authentication response
-	rand, autn := nasPdu.AuthenticationRequest.GetRANDValue(), nasPdu.AuthenticationRequest.GetAUTN()
-	kAmf, kenc, kint, resStar, newSqn, err := deriveResStarAndSetKey(fmt.Sprintf("supi-%s", u.supi), u.cipheringAlgorithm, u.integrityAlgorithm, u.authenticationSubscription.sequenceNumber, u.authenticationSubscription.authenticationManagementField, u.authenticationSubscription.encPermanentKey, u.authenticationSubscription.encOpcKey, rand[:], autn[:], "5G:mnc093.mcc208.3gppnetwork.org")
-	if err != nil {
-		return fmt.Errorf("error derive res star and set key: %+v", err)
-	} else {
-		u.kAmf = kAmf
-		copy(u.kNasEnc[:], kenc[16:32])
-		copy(u.kNasInt[:], kint[16:32])
-		u.authenticationSubscription.sequenceNumber = newSqn
+	ueSecurityCapability := u.getUeSecurityCapability()
+	u.NasLog.Tracef("UE security capability: %+v", ueSecurityCapability)
 
-		u.NasLog.Tracef("RES*: %+v", resStar)
-		u.NasLog.Tracef("kAMF: %+v", kAmf)
-		u.NasLog.Tracef("kNAS_ENC: %+v", kenc)
-		u.NasLog.Tracef("kNAS_INT: %+v", kint)
-		u.NasLog.Tracef("New SQN: %s", newSqn)
+	// send ue registration request
+	ngKsi := uint8(nasMessage.NasKeySetIdentifierNoKeyIsAvailable)
+	if nativeSecurityContext {
+		u.securityContextMtx.Lock()
+		ngKsi = u.ngKsi
+		u.securityContextMtx.Unlock()
 	}
-
-	authenticationResponse, err := getAuthenticationResponse(resStar)
+	// with the native security context the request carries the cleartext IEs, and the entire request ciphered
+	// in the NAS message container as in TS 24.501 4.4.6
+	var nasMessageContainer []byte
+	if nativeSecurityContext {
+		entireRegistrationRequest, err := getUeRegistrationRequest(nasMessage.RegistrationType5GSInitialRegistration, ngKsi, &mobileIdentity5GS, buildRequestedNssai(u.getRequestedNssai()), &ueSecurityCapability, u.get5GmmCapability(), nil, nil)
+		if err != nil {
+			return fmt.Errorf("error get entire ue registration request: %+v", err)
+		}
+		if nasMessageContainer, err = cipherNasMessageContainer(entireRegistrationRequest, u); err != nil {
+			return fmt.Errorf("error cipher nas message container: %+v", err)
+		}
+	}
+	registrationRequest, err := getUeRegistrationRequest(nasMessage.RegistrationType5GSInitialRegistration, ngKsi, &mobileIdentity5GS, nil, &ueSecurityCapability, nil, nasMessageContainer, nil)
 	if err != nil {
-		return fmt.Errorf("error get authentication response: %+v", err)
+		return fmt.Errorf("error get ue registration request: %+v", err)
 	}
-	u.NasLog.Tracef("Authentication response: %+v", authenticationResponse)
+	u.NasLog.Tracef("Get UE %s registration request: %+v", u.supi, registrationRequest)
 
-	n, err = u.ranControlPlaneConn.Write(authenticationResponse)
-	if err != nil {
-		return fmt.Errorf("error send authentication response: %+v", err)
+	// the cleartext IEs are integrity protected without ciphering
+	if nativeSecurityContext {
+		if registrationRequest, err = encodeNasPduWithSecurity(registrationRequest, nas.SecurityHeaderTypeIntegrityProtected, u, true, false); err != nil {
+			return fmt.Errorf("error encode ue registration request: %+v", err)
+		}
 	}
-	u.NasLog.Tracef("Sent %d bytes of Authentication Response to RAN", n)
-	u.NasLog.Debugln("Send Authentication Response to RAN")
 
-	// receive nas security mode command message
-	nasSecurityCommandRaw := make([]byte, 1024)
-	n, err = u.ranControlPlaneConn.Read(nasSecurityCommandRaw)
-	if err != nil {
-		return fmt.Errorf("error read nas security command: %+v", err)
+	if err := u.processRrcConnectionSetup(registrationRequest, protocol.RRC_ESTABLISHMENT_CAUSE_MO_SIGNALLING); err != nil {
+		return fmt.Errorf("error process rrc connection setup: %+v", err)
 	}
-	u.NasLog.Tracef("Received %d bytes of NAS Security Mode Command from RAN", n)
+	u.NasLog.Debugln("Send UE registration request")
 
-	nasPdu, err = nasDecode(u, nas.GetSecurityHeaderType(nasSecurityCommandRaw[:n]), nasSecurityCommandRaw[:n])
+	// answer the authentication requests until one is accepted and the security mode command follows,
+	// or the rrc security mode command of a network taking the native security context comes right away
+	nasPdu, rrcSecurityModeCommand, err := u.processUeAuthentication(suci)
 	if err != nil {
-		return fmt.Errorf("error get nas pdu: %+v", err)
+		return err
 	}
-	if nasPdu.GmmHeader.GetMessageType() != nas.MsgTypeSecurityModeCommand {
-		return fmt.Errorf("error nas pdu message type: %+v, expected security mode command", nasPdu)
+	if nasPdu != nil {
+		u.NasLog.Tracef("NAS security mode command: %+v", nasPdu)
+		u.NasLog.Debugln("Receive NAS Security Mode Command from RAN")
+
+		// the security mode complete carries the registration request with the 5GMM capability and the requested NSSAI,
+		// which are not cleartext IEs, under the new security context of the authentication
+		registrationRequestWith5Gmm, err := getUeRegistrationRequest(nasMessage.RegistrationType5GSInitialRegistration, u.ngKsi, &mobileIdentity5GS, buildRequestedNssai(u.getRequestedNssai()), &ueSecurityCapability, u.get5GmmCapability(), nil, nil)
+		if err != nil {
+			return fmt.Errorf("error get ue registration request with 5GMM: %+v", err)
+		}
+		u.NasLog.Tracef("Registration request with 5GMM: %+v", registrationRequestWith5Gmm)
+
+		if err := u.sendNasSecurityModeComplete(registrationRequestWith5Gmm); err != nil {
+			return err
+		}
+
+		// the network may still reject the registration instead of setting up the initial context, e.g. for the subscription
+		if rrcSecurityModeCommand, err = u.readMessageFromRan(); err != nil {
+			return fmt.Errorf("error read rrc security mode command: %+v", err)
+		}
+		if rrcSecurityModeCommand.Type == protocol.MESSAGE_TYPE_NAS {
+			return u.decodeNasReject(rrcSecurityModeCommand.Payload)
+		}
+	} else if !nativeSecurityContext {
+		return fmt.Errorf("error rrc security mode command from RAN without nas security mode command")
+	} else {
+		u.NasLog.Infoln("Network takes the native NAS security context without authentication")
 	}
-	u.NasLog.Tracef("NAS security mode command: %+v", nasPdu)
-	u.NasLog.Debugln("Receive NAS Security Mode Command from RAN")
 
-	// send nas security mode complete message
-	registrationRequestWith5Gmm, err := getUeRegistrationRequest(nasMessage.RegistrationType5GSInitialRegistration, &mobileIdentity5GS, nil, &ueSecurityCapability, u.get5GmmCapability(), nil, nil)
+	// KgNB is derived with the uplink nas count of security mode complete, or of the registration request protected by the native
+	// security context, as the AMF does for the initial context setup
+	u.securityContextMtx.Lock()
+	u.kGnb, err = protocol.DeriveKgnb(u.kAmf, u.ulCount.Get()-1)
+	u.securityContextMtx.Unlock()
 	if err != nil {
-		return fmt.Errorf("error get ue registration request with 5GMM: %+v", err)
+		return fmt.Errorf("error derive KgNB: %+v", err)
 	}
-	u.NasLog.Tracef("Registration request with 5GMM: %+v", registrationRequestWith5Gmm)
+	u.NasLog.Tracef("KgNB: %+v", u.kGnb)
 
-	nasSecurityModeCompleteMessage, err := getNasSecurityModeCompleteMessage(registrationRequestWith5Gmm)
-	if err != nil {
-		return fmt.Errorf("error get nas security mode complete message: %+v", err)
+	// receive rrc security mode command and activate AS security
+	if err := u.processRrcSecurityMode(rrcSecurityModeCommand); err != nil {
+		return fmt.Errorf("error process rrc security mode: %+v", err)
 	}
-	u.NasLog.Tracef("NAS security mode complete message: %+v", nasSecurityModeCompleteMessage)
 
-	encodedNasSecurityModeCompleteMessage, err := encodeNasPduWithSecurity(nasSecurityModeCompleteMessage, nas.SecurityHeaderTypeIntegrityProtectedAndCipheredWithNew5gNasSecurityContext, u, true, true)
+	// receive nas registration accept
+	nasRegistrationAcceptRaw, err := u.receiveFromRan(protocol.MESSAGE_TYPE_NAS)
 	if err != nil {
-		return fmt.Errorf("error encode nas security mode complete message: %+v", err)
+		return fmt.Errorf("error read nas registration accept: %+v", err)
 	}
-	u.NasLog.Tracef("Encoded NAS security mode complete message: %+v", encodedNasSecurityModeCompleteMessage)
+	u.NasLog.Tracef("Received %d bytes of NAS Registration Accept from RAN", len(nasRegistrationAcceptRaw))
 
-	n, err = u.ranControlPlaneConn.Write(encodedNasSecurityModeCompleteMessage)
+	nasRegistrationAccept, err := nasDecode(u, nas.GetSecurityHeaderType(nasRegistrationAcceptRaw), nasRegistrationAcceptRaw)
 	if err != nil {
-		return fmt.Errorf("error send nas security mode complete message: %+v", err)
+		return fmt.Errorf("error decode nas registration accept: %+v", err)
 	}
-	u.NasLog.Tracef("Sent %d bytes of NAS Security Mode Complete Message to RAN", n)
-	u.NasLog.Debugln("Send NAS Security Mode Complete Message to RAN")
+	switch nasRegistrationAccept.GmmHeader.GetMessageType() {
+	case nas.MsgTypeRegistrationAccept:
+	case nas.MsgTypeRegistrationReject:
+		return newRegistrationRejectError(nasRegistrationAccept.RegistrationReject)
+	default:
+		return fmt.Errorf("error nas pdu message type: %+v, expected registration accept", nasRegistrationAccept.GmmHeader.GetMessageType())
+	}
+	u.NasLog.Tracef("NAS registration accept: %+v", nasRegistrationAccept.RegistrationAccept)
+	u.NasLog.Debugln("Receive NAS Registration Accept from RAN")
 
-	time.Sleep(500 * time.Microsecond)
+	if err := u.applyRegistrationAccept(nasRegistrationAccept.RegistrationAccept); err != nil {
+		return fmt.Errorf("error apply registration accept: %+v", err)
+	}
 
 	// send nas registration complete message to RAN
 	nasRegistrationCompleteMessage, err := getNasRegistrationCompleteMessage(nil)
@@ -464,77 +877,527 @@ func (u *Ue) processUeRegistration() error {
 	}
 	u.NasLog.Tracef("Encoded NAS registration complete message: %+v", encodedNasRegistrationCompleteMessage)
 
-	n, err = u.ranControlPlaneConn.Write(encodedNasRegistrationCompleteMessage)
+	n, err := u.sendToRan(protocol.MESSAGE_TYPE_NAS, encodedNasRegistrationCompleteMessage)
 	if err != nil {
 		return fmt.Errorf("error send nas registration complete message: %+v", err)
 	}
 	u.NasLog.Tracef("Sent %d bytes of NAS Registration Complete Message to RAN", n)
 	u.NasLog.Debugln("Send NAS Registration Complete Message to RAN")
 
+	u.registered.Store(true)
+	u.saveState()
 	u.RanLog.Infoln("UE Registration finished")
 	return nil
 }
 
-func (u *Ue) processPduSessionEstablishment() error {
-	u.PduLog.Infoln("Processing PDU session establishment")
-
-	// send pdu session establishment request
-	pduSessionEstablishmentRequest, err := getPduSessionEstablishmentRequest(constant.PDU_SESSION_ID)
+// decodeNasReject returns the registration reject or the service reject of the nas message as an error
+func (u *Ue) decodeNasReject(nasRaw []byte) error {
+	nasPdu, err := nasDecode(u, nas.GetSecurityHeaderType(nasRaw), nasRaw)
 	if err != nil {
-		return fmt.Errorf("error get pdu session establishment request: %+v", err)
+		return fmt.Errorf("error decode nas reject: %+v", err)
 	}
-	u.NasLog.Tracef("PDU session establishment request: %+v", pduSessionEstablishmentRequest)
+	switch nasPdu.GmmHeader.GetMessageType() {
+	case nas.MsgTypeRegistrationReject:
+		return newRegistrationRejectError(nasPdu.RegistrationReject)
+	case nas.MsgTypeServiceReject:
+		return newServiceRejectError(nasPdu.ServiceReject)
+	default:
+		return fmt.Errorf("error nas pdu message type: %+v, expected rrc security mode command or reject", nasPdu.GmmHeader.GetMessageType())
+	}
+}
 
-	ulNasTransportPduSessionEstablishmentRequest, err := getUlNasTransportMessage(pduSessionEstablishmentRequest, constant.PDU_SESSION_ID, nasMessage.ULNASTransportRequestTypeInitialRequest, u.pduSession.dnn, u.pduSession.sNssai)
+// sendNasSecurityModeComplete completes the security mode command with the entire initial nas message in the NAS message container,
+// the registration request or the service request, under the new security context of the authentication
+func (u *Ue) sendNasSecurityModeComplete(initialNasMessage []byte) error {
+	nasSecurityModeCompleteMessage, err := getNasSecurityModeCompleteMessage(initialNasMessage)
 	if err != nil {
-		return fmt.Errorf("error get ul nas transport pdu session establishment request: %+v", err)
+		return fmt.Errorf("error get nas security mode complete message: %+v", err)
 	}
-	u.NasLog.Tracef("UL NAS transport pdu session establishment request: %+v", ulNasTransportPduSessionEstablishmentRequest)
+	u.NasLog.Tracef("NAS security mode complete message: %+v", nasSecurityModeCompleteMessage)
 
-	encodedUlNasTransportPduSessionEstablishmentRequest, err := encodeNasPduWithSecurity(ulNasTransportPduSessionEstablishmentRequest, nas.SecurityHeaderTypeIntegrityProtectedAndCiphered, u, true, false)
+	encodedNasSecurityModeCompleteMessage, err := encodeNasPduWithSecurity(nasSecurityModeCompleteMessage, nas.SecurityHeaderTypeIntegrityProtectedAndCipheredWithNew5gNasSecurityContext, u, true, true)
 	if err != nil {
-		return fmt.Errorf("error encode ul nas transport pdu session establishment request: %+v", err)
+		return fmt.Errorf("error encode nas security mode complete message: %+v", err)
 	}
-	u.NasLog.Tracef("Encoded UL NAS transport pdu session establishment request: %+v", encodedUlNasTransportPduSessionEstablishmentRequest)
+	u.NasLog.Tracef("Encoded NAS security mode complete message: %+v", encodedNasSecurityModeCompleteMessage)
 
-	n, err := u.ranControlPlaneConn.Write(encodedUlNasTransportPduSessionEstablishmentRequest)
+	n, err := u.sendToRan(protocol.MESSAGE_TYPE_NAS, encodedNasSecurityModeCompleteMessage)
 	if err != nil {
-		return fmt.Errorf("error send ul nas transport pdu session establishment request: %+v", err)
+		return fmt.Errorf("error send nas security mode complete message: %+v", err)
+	}
+	u.NasLog.Tracef("Sent %d bytes of NAS Security Mode Complete Message to RAN", n)
+	u.NasLog.Debugln("Send NAS Security Mode Complete Message to RAN")
+	return nil
+}
+
+// processPduSessionEstablishment establishes the pdu session, the accept comes in the dedicated nas of
+// the rrc reconfiguration which sets up the data radio bearer of the session, and the request is retransmitted on
+// the expiry of T3580. The DNN of a reject with a back-off timer is not requested again until T3396 expires
+func (u *Ue) processPduSessionEstablishment(session *pduSession, receiveDedicatedNas func(nasTimer) ([]byte, error)) error {
+	u.PduLog.Infof("Processing PDU session %d establishment", session.id)
+
+	// the 5GSM message of the network answering the request is handed to the procedure until it returns
+	u.setPduSessionNasWaiting(true)
+	defer u.setPduSessionNasWaiting(false)
+
+	if err := u.checkT3396(session.dnn); err != nil {
+		return err
 	}
-	u.NasLog.Tracef("Sent %d bytes of UL NAS transport pdu session establishment request to RAN", n)
-	u.NasLog.Debugln("Send UL NAS transport pdu session establishment request to RAN")
 
-	// receive pdu session establishment accept
-	nasPduSessionEstablishmentAcceptRaw := make([]byte, 1024)
-	n, err = u.ranControlPlaneConn.Read(nasPduSessionEstablishmentAcceptRaw)
+	// send pdu session establishment request
+	pduSessionEstablishmentRequest, err := getPduSessionEstablishmentRequest(session.id, session.pduSessionType)
 	if err != nil {
-		return fmt.Errorf("error read nas pdu session establishment accept: %+v", err)
+		return fmt.Errorf("error get pdu session establishment request: %+v", err)
 	}
-	u.NasLog.Tracef("Received %d bytes of NAS PDU Session Establishment Accept from RAN", n)
+	u.NasLog.Tracef("PDU session establishment request: %+v", pduSessionEstablishmentRequest)
 
-	nasPduSessionEstablishmentAccept, err := nasDecode(u, nas.GetSecurityHeaderType(nasPduSessionEstablishmentAcceptRaw[:n]), nasPduSessionEstablishmentAcceptRaw[:n])
+	sNssai, err := u.selectPduSessionSnssai(session)
 	if err != nil {
-		return fmt.Errorf("error decode nas pdu session establishment accept: %+v", err)
+		return err
 	}
-	if nasPduSessionEstablishmentAccept.GmmHeader.GetMessageType() != nas.MsgTypeDLNASTransport {
-		return fmt.Errorf("error nas pdu message type: %+v, expected pdu session establishment accept", nasPduSessionEstablishmentAccept.GmmHeader.GetMessageType())
+	if sNssai != nil {
+		u.PduLog.Debugf("PDU session %d requested with S-NSSAI, %s", session.id, snssaiToString(*sNssai))
 	}
-	u.NasLog.Tracef("NAS PDU Session Establishment Accept: %+v", nasPduSessionEstablishmentAccept)
+
+	ulNasTransportPduSessionEstablishmentRequest, err := getUlNasTransportMessage(pduSessionEstablishmentRequest, session.id, nasMessage.ULNASTransportRequestTypeInitialRequest, session.dnn, sNssai)
+	if err != nil {
+		return fmt.Errorf("error get ul nas transport pdu session establishment request: %+v", err)
+	}
+	u.NasLog.Tracef("UL NAS transport pdu session establishment request: %+v", ulNasTransportPduSessionEstablishmentRequest)
+
+	// receive pdu session establishment accept
+	var nasPduSessionEstablishmentAcceptRaw []byte
+	if err := u.retransmitOnExpiry(u.nasRetry.t3580, func() error {
+		encodedUlNasTransportPduSessionEstablishmentRequest, err := encodeNasPduWithSecurity(ulNasTransportPduSessionEstablishmentRequest, nas.SecurityHeaderTypeIntegrityProtectedAndCiphered, u, true, false)
+		if err != nil {
+			return fmt.Errorf("error encode ul nas transport pdu session establishment request: %+v", err)
+		}
+		u.NasLog.Tracef("Encoded UL NAS transport pdu session establishment request: %+v", encodedUlNasTransportPduSessionEstablishmentRequest)
+
+		n, err := u.sendToRan(protocol.MESSAGE_TYPE_NAS, encodedUlNasTransportPduSessionEstablishmentRequest)
+		if err != nil {
+			return fmt.Errorf("error send ul nas transport pdu session establishment request: %+v", err)
+		}
+		u.NasLog.Tracef("Sent %d bytes of UL NAS transport pdu session establishment request to RAN", n)
+		u.NasLog.Debugln("Send UL NAS transport pdu session establishment request to RAN")
+		return nil
+	}, func(timer nasTimer) error {
+		var err error
+		nasPduSessionEstablishmentAcceptRaw, err = receiveDedicatedNas(timer)
+		return err
+	}); err != nil {
+		return fmt.Errorf("error read nas pdu session establishment accept: %+v", err)
+	}
+	u.NasLog.Tracef("Received %d bytes of NAS PDU Session Establishment Accept from RAN", len(nasPduSessionEstablishmentAcceptRaw))
+
+	nasPduSessionEstablishmentAccept, err := nasDecode(u, nas.GetSecurityHeaderType(nasPduSessionEstablishmentAcceptRaw), nasPduSessionEstablishmentAcceptRaw)
+	if err != nil {
+		return fmt.Errorf("error decode nas pdu session establishment accept: %+v", err)
+	}
+	if nasPduSessionEstablishmentAccept.GmmHeader.GetMessageType() != nas.MsgTypeDLNASTransport {
+		return fmt.Errorf("error nas pdu message type: %+v, expected pdu session establishment accept", nasPduSessionEstablishmentAccept.GmmHeader.GetMessageType())
+	}
+	u.NasLog.Tracef("NAS PDU Session Establishment Accept: %+v", nasPduSessionEstablishmentAccept)
 	u.NasLog.Debugln("Receive NAS PDU Session Establishment Accept from RAN")
 
 	// store ue information
-	if err := u.extractUeInformationFromNasPduSessionEstablishmentAccept(nasPduSessionEstablishmentAccept); err != nil {
+	if err := u.extractUeInformationFromNasPduSessionEstablishmentAccept(session, nasPduSessionEstablishmentAccept); err != nil {
 		return fmt.Errorf("error extract ue information from nas pdu session establishment accept: %+v", err)
 	}
 
-	u.PduLog.Infof("UE %s PDU session establishment complete", u.supi)
+	u.PduLog.Infof("UE %s PDU session %d establishment complete", u.supi, session.id)
+	return nil
+}
+
+// processPduSessionRelease requests the release of the pdu session, retransmitted on the expiry of T3582, tears down
+// its tunnel device on the release command and answers with the release complete
+func (u *Ue) processPduSessionRelease(session *pduSession) error {
+	u.PduLog.Infof("Processing PDU session %d release", session.id)
+
+	// the 5GSM message of the network answering the request is handed to the procedure until it returns
+	u.setPduSessionNasWaiting(true)
+	defer u.setPduSessionNasWaiting(false)
+
+	// send pdu session release request
+	pti := u.allocatePti()
+	pduSessionReleaseRequest, err := getPduSessionReleaseRequest(session.id, pti)
+	if err != nil {
+		return fmt.Errorf("error get pdu session release request: %+v", err)
+	}
+	u.NasLog.Tracef("PDU session release request: %+v", pduSessionReleaseRequest)
+
+	// receive pdu session release command
+	var gsmMessage *nas.Message
+	if err := u.retransmitOnExpiry(u.nasRetry.t3582, func() error {
+		if err := u.sendPduSessionNas(session.id, pduSessionReleaseRequest); err != nil {
+			return fmt.Errorf("error send pdu session release request: %+v", err)
+		}
+		u.NasLog.Debugln("Send UL NAS transport pdu session release request to RAN")
+		return nil
+	}, func(timer nasTimer) error {
+		var err error
+		gsmMessage, err = u.receivePduSessionNas(timer)
+		return err
+	}); err != nil {
+		return fmt.Errorf("error receive pdu session release command: %+v", err)
+	}
+
+	switch gsmMessage.GsmHeader.GetMessageType() {
+	case nas.MsgTypePDUSessionReleaseCommand:
+		releaseCommand := gsmMessage.PDUSessionReleaseCommand
+		u.NasLog.Debugf("Receive NAS PDU Session Release Command from RAN, cause: %d", releaseCommand.GetCauseValue())
+
+		u.tearDownPduSessionTunnelDevice(session)
+		u.setPduSessionReleased(session)
+
+		// send pdu session release complete
+		pduSessionReleaseComplete, err := getPduSessionReleaseComplete(session.id, releaseCommand.GetPTI())
+		if err != nil {
+			return fmt.Errorf("error get pdu session release complete: %+v", err)
+		}
+		u.NasLog.Tracef("PDU session release complete: %+v", pduSessionReleaseComplete)
+
+		if err := u.sendPduSessionNas(session.id, pduSessionReleaseComplete); err != nil {
+			return fmt.Errorf("error send pdu session release complete: %+v", err)
+		}
+		u.NasLog.Debugln("Send UL NAS transport pdu session release complete to RAN")
+	case nas.MsgTypePDUSessionReleaseReject:
+		return fmt.Errorf("pdu session release rejected, cause: %d", gsmMessage.PDUSessionReleaseReject.GetCauseValue())
+	default:
+		return fmt.Errorf("error nas gsm message type: %+v, expected pdu session release command", gsmMessage.GsmHeader.GetMessageType())
+	}
+
+	u.PduLog.Infof("UE %s PDU session %d release complete", u.supi, session.id)
+	return nil
+}
+
+// processPduSessionModification requests the modification of the pdu session, retransmitted on the expiry of T3581,
+// applies the authorized QoS rules of the modification command and answers with the modification complete
+func (u *Ue) processPduSessionModification(session *pduSession) error {
+	u.PduLog.Infof("Processing PDU session %d modification", session.id)
+
+	// the 5GSM message of the network answering the request is handed to the procedure until it returns
+	u.setPduSessionNasWaiting(true)
+	defer u.setPduSessionNasWaiting(false)
+
+	// send pdu session modification request
+	pti := u.allocatePti()
+	pduSessionModificationRequest, err := getPduSessionModificationRequest(session.id, pti)
+	if err != nil {
+		return fmt.Errorf("error get pdu session modification request: %+v", err)
+	}
+	u.NasLog.Tracef("PDU session modification request: %+v", pduSessionModificationRequest)
+
+	// receive pdu session modification command
+	var gsmMessage *nas.Message
+	if err := u.retransmitOnExpiry(u.nasRetry.t3581, func() error {
+		if err := u.sendPduSessionNas(session.id, pduSessionModificationRequest); err != nil {
+			return fmt.Errorf("error send pdu session modification request: %+v", err)
+		}
+		u.NasLog.Debugln("Send UL NAS transport pdu session modification request to RAN")
+		return nil
+	}, func(timer nasTimer) error {
+		var err error
+		gsmMessage, err = u.receivePduSessionNas(timer)
+		return err
+	}); err != nil {
+		return fmt.Errorf("error receive pdu session modification command: %+v", err)
+	}
+
+	switch gsmMessage.GsmHeader.GetMessageType() {
+	case nas.MsgTypePDUSessionModificationCommand:
+		modificationCommand := gsmMessage.PDUSessionModificationCommand
+		u.NasLog.Debugln("Receive NAS PDU Session Modification Command from RAN")
+
+		if modificationCommand.SessionAMBR != nil {
+			u.PduLog.Infof("PDU session %d session AMBR, uplink: %x, downlink: %x", session.id, modificationCommand.SessionAMBR.GetSessionAMBRForUplink(), modificationCommand.SessionAMBR.GetSessionAMBRForDownlink())
+		}
+		if modificationCommand.AuthorizedQosRules != nil {
+			u.setPduSessionQosRule(session, modificationCommand.AuthorizedQosRules.GetQosRule())
+		}
+
+		// send pdu session modification complete
+		pduSessionModificationComplete, err := getPduSessionModificationComplete(session.id, modificationCommand.GetPTI())
+		if err != nil {
+			return fmt.Errorf("error get pdu session modification complete: %+v", err)
+		}
+		u.NasLog.Tracef("PDU session modification complete: %+v", pduSessionModificationComplete)
+
+		if err := u.sendPduSessionNas(session.id, pduSessionModificationComplete); err != nil {
+			return fmt.Errorf("error send pdu session modification complete: %+v", err)
+		}
+		u.NasLog.Debugln("Send UL NAS transport pdu session modification complete to RAN")
+	case nas.MsgTypePDUSessionModificationReject:
+		return fmt.Errorf("pdu session modification rejected, cause: %d", gsmMessage.PDUSessionModificationReject.GetCauseValue())
+	default:
+		return fmt.Errorf("error nas gsm message type: %+v, expected pdu session modification command", gsmMessage.GsmHeader.GetMessageType())
+	}
+
+	u.PduLog.Infof("UE %s PDU session %d modification complete", u.supi, session.id)
+	return nil
+}
+
+// sendPduSessionNas sends the 5GSM message of the pdu session to RAN in a secured UL NAS transport
+func (u *Ue) sendPduSessionNas(pduSessionId uint8, gsmMessage []byte) error {
+	ulNasTransport, err := getUlNasTransportMessage(gsmMessage, pduSessionId, 0, "", nil)
+	if err != nil {
+		return fmt.Errorf("error get ul nas transport: %+v", err)
+	}
+	u.NasLog.Tracef("UL NAS transport: %+v", ulNasTransport)
+
+	return u.sendSecuredNas(ulNasTransport)
+}
+
+// receivePduSessionNas waits under the NAS timer for the DL NAS transport in the dedicated nas of an rrc reconfiguration
+// and returns the 5GSM message it carries
+func (u *Ue) receivePduSessionNas(timer nasTimer) (*nas.Message, error) {
+	dlNasTransportRaw, err := u.receiveDedicatedNas(timer)
+	if err != nil {
+		return nil, err
+	}
+	u.NasLog.Tracef("Received %d bytes of DL NAS transport from RAN", len(dlNasTransportRaw))
+
+	dlNasTransport, err := nasDecode(u, nas.GetSecurityHeaderType(dlNasTransportRaw), dlNasTransportRaw)
+	if err != nil {
+		return nil, fmt.Errorf("error decode dl nas transport: %+v", err)
+	}
+	if dlNasTransport.GmmHeader.GetMessageType() != nas.MsgTypeDLNASTransport {
+		return nil, fmt.Errorf("error nas pdu message type: %+v, expected dl nas transport", dlNasTransport.GmmHeader.GetMessageType())
+	}
+
+	gsmMessage, err := getNasPduFromDlNasTransport(dlNasTransport)
+	if err != nil {
+		return nil, fmt.Errorf("error get 5gsm message from dl nas transport: %+v", err)
+	}
+	u.NasLog.Tracef("NAS 5GSM message: %+v", gsmMessage)
+	return gsmMessage, nil
+}
+
+// processUeAuthentication answers the authentication requests of the network until the security mode command, which is
+// returned, a rejected challenge is answered with an authentication failure, or the EAP-Response rejecting it for EAP-AKA',
+// and the network may identify the UE or authenticate it again, e.g. after resynchronising its SQN, and the registration is
+// given up after consecutive authentication failures, and the rrc security mode command is returned instead if the network
+// takes the native security context of the registration request
+func (u *Ue) processUeAuthentication(mobileIdentity5GS nasType.MobileIdentity5GS) (*nas.Message, *protocol.Message, error) {
+	authenticationFailures := 0
+	for {
+		message, err := u.readMessageFromRan()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error read nas authentication request: %+v", err)
+		}
+		switch message.Type {
+		case protocol.MESSAGE_TYPE_NAS:
+		case protocol.MESSAGE_TYPE_SECURED:
+			return nil, message, nil
+		case protocol.MESSAGE_TYPE_REJECT:
+			return nil, nil, fmt.Errorf("rejected by RAN, cause: %s", protocol.CauseFromPayload(message.Payload))
+		default:
+			return nil, nil, fmt.Errorf("unexpected message type %s, expected %s", message.Type, protocol.MESSAGE_TYPE_NAS)
+		}
+		nasRaw := message.Payload
+		u.NasLog.Tracef("Received %d bytes of NAS from RAN", len(nasRaw))
+
+		// the security mode command comes with the new security context of the algorithms the network selects
+		var nasPdu *nas.Message
+		if nas.GetSecurityHeaderType(nasRaw) == nas.SecurityHeaderTypeIntegrityProtectedWithNew5gNasSecurityContext {
+			nasPdu, err = u.decodeSecurityModeCommand(nasRaw)
+			var reject *securityModeRejectError
+			if errors.As(err, &reject) {
+				if err := u.sendSecurityModeReject(reject); err != nil {
+					return nil, nil, err
+				}
+				return nil, nil, reject
+			}
+		} else {
+			nasPdu, err = nasDecode(u, nas.GetSecurityHeaderType(nasRaw), nasRaw)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error decode nas authentication request: %+v", err)
+		}
+
+		switch nasPdu.GmmHeader.GetMessageType() {
+		case nas.MsgTypeAuthenticationRequest:
+		case nas.MsgTypeIdentityRequest:
+			u.NasLog.Infof("Receive Identity Request from RAN during authentication, identity type: %d", nasPdu.IdentityRequest.GetTypeOfIdentity())
+			identityResponse, err := getIdentityResponse(nasPdu.IdentityRequest.GetTypeOfIdentity(), mobileIdentity5GS)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error get identity response: %+v", err)
+			}
+			if _, err := u.sendToRan(protocol.MESSAGE_TYPE_NAS, identityResponse); err != nil {
+				return nil, nil, fmt.Errorf("error send identity response: %+v", err)
+			}
+			u.NasLog.Debugln("Send Identity Response to RAN")
+			continue
+		case nas.MsgTypeAuthenticationResult:
+			u.NasLog.Debugln("Receive NAS Authentication Result from RAN")
+			if err := u.checkEapResult(nasPdu.AuthenticationResult.GetEAPMessage()); err != nil {
+				return nil, nil, err
+			}
+			continue
+		case nas.MsgTypeAuthenticationReject:
+			return nil, nil, fmt.Errorf("authentication rejected by the network")
+		case nas.MsgTypeRegistrationReject:
+			return nil, nil, newRegistrationRejectError(nasPdu.RegistrationReject)
+		case nas.MsgTypeServiceReject:
+			return nil, nil, newServiceRejectError(nasPdu.ServiceReject)
+		case nas.MsgTypeSecurityModeCommand:
+			// the EAP-Success of EAP-AKA' may come with the security mode command instead of an authentication result
+			if nasPdu.SecurityModeCommand.EAPMessage != nil {
+				if err := u.checkEapResult(nasPdu.SecurityModeCommand.GetEAPMessage()); err != nil {
+					return nil, nil, err
+				}
+			}
+			return nasPdu, nil, nil
+		default:
+			return nil, nil, fmt.Errorf("error nas pdu message type: %+v, expected authentication request or security mode command", nasPdu)
+		}
+		u.NasLog.Tracef("NAS authentication request: %+v", nasPdu)
+		u.NasLog.Debugln("Receive NAS Authentication Request from RAN")
+
+		// the network selects the method of the subscription, the challenge is checked against the method of the UE
+		authenticationMethod := models.AuthMethod__5_G_AKA
+		if nasPdu.AuthenticationRequest.EAPMessage != nil {
+			authenticationMethod = models.AuthMethod_EAP_AKA_PRIME
+		}
+		if authenticationMethod != u.authenticationSubscription.authenticationMethod {
+			return nil, nil, fmt.Errorf("error authentication method %s of the network, expected %s", authenticationMethod, u.authenticationSubscription.authenticationMethod)
+		}
+
+		var kAmf, kenc, kint, resStar, eapResponse []byte
+		var newSqn string
+		snName := util.ServingNetworkName(u.mcc, u.mnc)
+		if authenticationMethod == models.AuthMethod_EAP_AKA_PRIME {
+			kAmf, kenc, kint, eapResponse, newSqn, err = deriveEapResponseAndSetKey(fmt.Sprintf("imsi-%s", u.supi), u.cipheringAlgorithm, u.integrityAlgorithm, u.authenticationSubscription.sequenceNumber, u.authenticationSubscription.encPermanentKey, u.authenticationSubscription.encOpcKey, nasPdu.AuthenticationRequest.GetEAPMessage(), snName)
+		} else {
+			// calculate for RES* and send nas authentication response
+			rand, autn := nasPdu.AuthenticationRequest.GetRANDValue(), nasPdu.AuthenticationRequest.GetAUTN()
+			kAmf, kenc, kint, resStar, newSqn, err = deriveResStarAndSetKey(fmt.Sprintf("supi-%s", u.supi), u.cipheringAlgorithm, u.integrityAlgorithm, u.authenticationSubscription.sequenceNumber, u.authenticationSubscription.encPermanentKey, u.authenticationSubscription.encOpcKey, rand[:], autn[:], snName)
+		}
+		var failure *authenticationFailure
+		if errors.As(err, &failure) {
+			authenticationFailures++
+			u.NasLog.Warnf("Reject authentication request, %v", failure)
+
+			if err := u.sendAuthenticationFailure(failure); err != nil {
+				return nil, nil, err
+			}
+
+			if authenticationFailures >= constant.UE_MAX_AUTHENTICATION_FAILURES {
+				return nil, nil, fmt.Errorf("error authentication failed %d consecutive times", authenticationFailures)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error derive authentication response and set key: %+v", err)
+		}
+		authenticationFailures = 0
+
+		u.securityContextMtx.Lock()
+		u.ngKsi = nasPdu.AuthenticationRequest.SpareHalfOctetAndNgksi.GetNasKeySetIdentifiler()
+		u.kAmf = kAmf
+		copy(u.kNasEnc[:], kenc[16:32])
+		copy(u.kNasInt[:], kint[16:32])
+		u.securityContextMtx.Unlock()
+		u.authenticationSubscription.sequenceNumber = newSqn
+		u.saveState()
+
+		u.NasLog.Tracef("RES*: %+v", resStar)
+		u.NasLog.Tracef("EAP response: %+v", eapResponse)
+		u.NasLog.Tracef("kAMF: %+v", kAmf)
+		u.NasLog.Tracef("kNAS_ENC: %+v", kenc)
+		u.NasLog.Tracef("kNAS_INT: %+v", kint)
+		u.NasLog.Tracef("New SQN: %s", newSqn)
+
+		authenticationResponse, err := getAuthenticationResponse(resStar, eapResponse)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error get authentication response: %+v", err)
+		}
+		u.NasLog.Tracef("Authentication response: %+v", authenticationResponse)
+
+		n, err := u.sendToRan(protocol.MESSAGE_TYPE_NAS, authenticationResponse)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error send authentication response: %+v", err)
+		}
+		u.NasLog.Tracef("Sent %d bytes of Authentication Response to RAN", n)
+		u.NasLog.Debugln("Send Authentication Response to RAN")
+	}
+}
+
+// sendAuthenticationFailure rejects the challenge with an authentication failure of 5G AKA,
+// or with an authentication response of the EAP-Response rejecting the challenge of EAP-AKA'
+func (u *Ue) sendAuthenticationFailure(failure *authenticationFailure) error {
+	if failure.eapMessage != nil {
+		authenticationResponse, err := getAuthenticationResponse(nil, failure.eapMessage)
+		if err != nil {
+			return fmt.Errorf("error get authentication response: %+v", err)
+		}
+		u.NasLog.Tracef("Authentication response: %+v", authenticationResponse)
+
+		if _, err := u.sendToRan(protocol.MESSAGE_TYPE_NAS, authenticationResponse); err != nil {
+			return fmt.Errorf("error send authentication response: %+v", err)
+		}
+		u.NasLog.Debugln("Send Authentication Response of EAP-AKA' rejection to RAN")
+		return nil
+	}
+
+	authenticationFailureMessage, err := getAuthenticationFailure(failure.cause, failure.auts)
+	if err != nil {
+		return fmt.Errorf("error get authentication failure: %+v", err)
+	}
+	u.NasLog.Tracef("Authentication failure: %+v", authenticationFailureMessage)
+
+	if _, err := u.sendToRan(protocol.MESSAGE_TYPE_NAS, authenticationFailureMessage); err != nil {
+		return fmt.Errorf("error send authentication failure: %+v", err)
+	}
+	u.NasLog.Debugln("Send Authentication Failure to RAN")
 	return nil
 }
 
+// checkEapResult accepts the EAP-Success of EAP-AKA', an EAP-Failure fails the authentication
+func (u *Ue) checkEapResult(eapMessage []byte) error {
+	eapResult, err := protocol.DecodeEapAkaPrimePacket(eapMessage)
+	if err != nil {
+		return fmt.Errorf("error decode eap result: %+v", err)
+	}
+
+	switch eapResult.Code {
+	case protocol.EAP_CODE_SUCCESS:
+		u.NasLog.Infoln("EAP-AKA' authentication succeeded")
+		return nil
+	case protocol.EAP_CODE_FAILURE:
+		return fmt.Errorf("EAP-AKA' authentication failed by the network")
+	default:
+		return fmt.Errorf("error eap code %d, expected eap success or failure", eapResult.Code)
+	}
+}
+
+// processUeDeregistration deregisters the UE with its 5G-GUTI, the request is integrity protected but not ciphered
+// as it only carries cleartext IEs, which lets the RAN tell it from the other uplink NAS and release the UE after the accept.
+// The request is retransmitted on the expiry of T3521 and the UE deregisters locally on the last one as in TS 24.501 5.5.2.2.6
 func (u *Ue) processUeDeregistration() error {
 	u.RanLog.Infoln("Processing UE deregistration")
 
-	mobileIdentity5GS := buildUeMobileIdentity5GS(u.supi)
+	// a UE released to RRC_IDLE has no connection to send the request on, it is deregistered locally
+	if u.getRrcState() == protocol.RRC_STATE_IDLE {
+		u.NasLog.Warnln("UE in RRC_IDLE, UE deregistered locally")
+		u.registered.Store(false)
+		u.saveState()
+		return nil
+	}
+
+	if err := u.processRrcResume(protocol.RRC_ESTABLISHMENT_CAUSE_MO_SIGNALLING); err != nil {
+		return fmt.Errorf("error resume rrc connection: %+v", err)
+	}
+
+	var mobileIdentity5GS nasType.MobileIdentity5GS
+	if guti5G := u.getGuti5G(); guti5G != nil {
+		mobileIdentity5GS = buildGutiMobileIdentity5GS(guti5G)
+	} else {
+		var err error
+		if mobileIdentity5GS, err = buildUeMobileIdentity5GS(u.mcc, u.mnc, u.msin, &u.suciProfile); err != nil {
+			return fmt.Errorf("error build ue mobile identity 5gs: %+v", err)
+		}
+	}
 	u.NasLog.Tracef("Mobile identity 5GS: %+v", mobileIdentity5GS)
 
 	// send ue deregistration request
@@ -544,28 +1407,43 @@ func (u *Ue) processUeDeregistration() error {
 	}
 	u.NasLog.Tracef("Get UE deregistration request: %+v", deregistrationRequest)
 
-	encodedDeregistrationRequest, err := encodeNasPduWithSecurity(deregistrationRequest, nas.SecurityHeaderTypeIntegrityProtectedAndCiphered, u, true, false)
-	if err != nil {
-		return fmt.Errorf("error encode ue deregistration request: %+v", err)
-	}
-	u.NasLog.Tracef("Encoded UE deregistration request: %+v", encodedDeregistrationRequest)
+	// receive ue deregistration accept
+	var ueDeRegistrationAcceptRaw []byte
+	err = u.retransmitOnExpiry(u.nasRetry.t3521, func() error {
+		encodedDeregistrationRequest, err := encodeNasPduWithSecurity(deregistrationRequest, nas.SecurityHeaderTypeIntegrityProtected, u, true, false)
+		if err != nil {
+			return fmt.Errorf("error encode ue deregistration request: %+v", err)
+		}
+		u.NasLog.Tracef("Encoded UE deregistration request: %+v", encodedDeregistrationRequest)
 
-	n, err := u.ranControlPlaneConn.Write(encodedDeregistrationRequest)
-	if err != nil {
-		return fmt.Errorf("error send ue deregistration request: %+v", err)
+		n, err := u.sendToRan(protocol.MESSAGE_TYPE_NAS, encodedDeregistrationRequest)
+		if err != nil {
+			return fmt.Errorf("error send ue deregistration request: %+v", err)
+		}
+		u.NasLog.Tracef("Sent %d bytes of UE deregistration request to RAN", n)
+		u.NasLog.Debugln("Send UE deregistration request to RAN")
+		return nil
+	}, func(timer nasTimer) error {
+		return u.withNasTimer(timer, func() error {
+			var err error
+			ueDeRegistrationAcceptRaw, err = u.receiveFromRan(protocol.MESSAGE_TYPE_NAS)
+			return err
+		})
+	})
+	var expired *nasTimerExpiredError
+	if errors.As(err, &expired) {
+		u.NasLog.Warnf("%v, UE deregistered locally", err)
+		u.setRrcState(protocol.RRC_STATE_IDLE)
+		u.registered.Store(false)
+		u.saveState()
+		return nil
 	}
-	u.NasLog.Tracef("Sent %d bytes of UE deregistration request to RAN", n)
-	u.NasLog.Debugln("Send UE deregistration request to RAN")
-
-	// receive ue deregistration accept
-	ueDeRegistrationAcceptRaw := make([]byte, 1024)
-	n, err = u.ranControlPlaneConn.Read(ueDeRegistrationAcceptRaw)
 	if err != nil {
 		return fmt.Errorf("error read ue deregistration accept: %+v", err)
 	}
-	u.NasLog.Tracef("Received %d bytes of UE deregistration accept from RAN", n)
+	u.NasLog.Tracef("Received %d bytes of UE deregistration accept from RAN", len(ueDeRegistrationAcceptRaw))
 
-	ueDeRegistrationAccept, err := nasDecode(u, nas.GetSecurityHeaderType(ueDeRegistrationAcceptRaw[:n]), ueDeRegistrationAcceptRaw[:n])
+	ueDeRegistrationAccept, err := nasDecode(u, nas.GetSecurityHeaderType(ueDeRegistrationAcceptRaw), ueDeRegistrationAcceptRaw)
 	if err != nil {
 		return fmt.Errorf("error decode ue deregistration accept: %+v", err)
 	}
@@ -575,12 +1453,26 @@ func (u *Ue) processUeDeregistration() error {
 	u.NasLog.Tracef("NAS UE deregistration accept: %+v", ueDeRegistrationAccept)
 	u.NasLog.Debugln("Receive NAS UE deregistration accept from RAN")
 
+	// receive rrc release
+	rrcRelease, err := u.receiveRrcFromRan(protocol.RRC_RELEASE)
+	if err != nil {
+		return fmt.Errorf("error read rrc release: %+v", err)
+	}
+	u.RanLog.Debugln("Receive RRC Release from RAN")
+	if rrcRelease.Suspend {
+		u.setRrcState(protocol.RRC_STATE_INACTIVE)
+	} else {
+		u.setRrcState(protocol.RRC_STATE_IDLE)
+	}
+
+	u.registered.Store(false)
+	u.saveState()
 	u.RanLog.Infoln("UE deregistration complete")
 	return nil
 }
 
-func (u *Ue) extractUeInformationFromNasPduSessionEstablishmentAccept(nasPduSessionEstablishmentAccept *nas.Message) error {
-	nasMessage, err := getNasPduFromNasPduSessionEstablishmentAccept(nasPduSessionEstablishmentAccept)
+func (u *Ue) extractUeInformationFromNasPduSessionEstablishmentAccept(session *pduSession, nasPduSessionEstablishmentAccept *nas.Message) error {
+	nasMessage, err := getNasPduFromDlNasTransport(nasPduSessionEstablishmentAccept)
 	if err != nil {
 		return fmt.Errorf("error get nas pdu from nas pdu session establishment accept: %+v", err)
 	}
@@ -588,26 +1480,49 @@ func (u *Ue) extractUeInformationFromNasPduSessionEstablishmentAccept(nasPduSess
 
 	switch nasMessage.GsmHeader.GetMessageType() {
 	case nas.MsgTypePDUSessionEstablishmentAccept:
-		pduSessionEstablishmentAccept := nasMessage.PDUSessionEstablishmentAccept
+		establishmentAccept := nasMessage.PDUSessionEstablishmentAccept
+		accept := pduSessionEstablishmentAccept{}
 
-		pduAddress := pduSessionEstablishmentAccept.GetPDUAddressInformation()
-		u.pduSessionEstablishmentAccept.ueIp = fmt.Sprintf("%d.%d.%d.%d", pduAddress[0], pduAddress[1], pduAddress[2], pduAddress[3])
-		u.PduLog.Infof("PDU session UE IP: %s", u.pduSessionEstablishmentAccept.ueIp)
+		accept.pduSessionType = establishmentAccept.SelectedSSCModeAndSelectedPDUSessionType.GetPDUSessionType()
+		if accept.pduSessionType != session.pduSessionType {
+			u.PduLog.Warnf("PDU session %d type %s selected instead of %s", session.id, pduSessionTypeName(accept.pduSessionType), pduSessionTypeName(session.pduSessionType))
+		}
 
-		u.pduSessionEstablishmentAccept.qosRule = pduSessionEstablishmentAccept.AuthorizedQosRules.GetQosRule()
-		u.nrdc.specifiedFlow = append(u.nrdc.specifiedFlow, util.GetQosRule(u.pduSessionEstablishmentAccept.qosRule, u.UeLogger)...)
-		u.PduLog.Infof("PDU session QoS rule: %+v", u.nrdc.specifiedFlow)
+		// only an IP PDU session has a PDU address and IP packet filters in its QoS rules
+		accept.qosRule = establishmentAccept.AuthorizedQosRules.GetQosRule()
+		var specifiedFlow []string
+		if accept.isIp() {
+			if establishmentAccept.PDUAddress == nil {
+				return fmt.Errorf("no pdu address in pdu session establishment accept")
+			}
+			if accept.ueIp, accept.ueIpv6InterfaceIdentifier, err = parsePduAddress(accept.pduSessionType, establishmentAccept.GetPDUAddressInformation()); err != nil {
+				return fmt.Errorf("error parse pdu address: %+v", err)
+			}
+			u.PduLog.Infof("PDU session %d UE IP: %s", session.id, strings.Join(accept.getUeIps(), ", "))
 
-		u.pduSessionEstablishmentAccept.dnn = pduSessionEstablishmentAccept.GetDNN()
-		u.PduLog.Infof("PDU session DNN: %s", u.pduSessionEstablishmentAccept.dnn)
+			specifiedFlow = util.GetQosRule(accept.qosRule, u.UeLogger)
+			u.PduLog.Infof("PDU session %d QoS rule: %+v", session.id, specifiedFlow)
+		} else {
+			u.PduLog.Infof("PDU session %d type: %s", session.id, pduSessionTypeName(accept.pduSessionType))
+		}
+
+		accept.dnn = establishmentAccept.GetDNN()
+		u.PduLog.Infof("PDU session %d DNN: %s", session.id, accept.dnn)
+
+		accept.sst = establishmentAccept.GetSST()
+		accept.sd = establishmentAccept.GetSD()
+		u.PduLog.Infof("PDU session %d SNSSAI, sst: %d, sd: %s", session.id, accept.sst, fmt.Sprintf("%x%x%x", accept.sd[0], accept.sd[1], accept.sd[2]))
 
-		u.pduSessionEstablishmentAccept.sst = pduSessionEstablishmentAccept.GetSST()
-		u.pduSessionEstablishmentAccept.sd = pduSessionEstablishmentAccept.GetSD()
-		u.PduLog.Infof("PDU session SNSSAI, sst: %d, sd: %s", u.pduSessionEstablishmentAccept.sst, fmt.Sprintf("%x%x%x", u.pduSessionEstablishmentAccept.sd[0], u.pduSessionEstablishmentAccept.sd[1], u.pduSessionEstablishmentAccept.sd[2]))
+		u.setPduSessionEstablished(session, accept, specifiedFlow)
 	case nas.MsgTypePDUSessionReleaseCommand:
 		return fmt.Errorf("not implemented: PDUSessionReleaseCommand")
 	case nas.MsgTypePDUSessionEstablishmentReject:
-		return fmt.Errorf("not implemented: PDUSessionEstablishmentReject")
+		establishmentReject := nasMessage.PDUSessionEstablishmentReject
+		u.setLast5GsmCause(establishmentReject.GetCauseValue())
+		if establishmentReject.BackoffTimerValue != nil {
+			u.startT3396(session.dnn, establishmentReject.BackoffTimerValue)
+		}
+		return fmt.Errorf("pdu session establishment rejected, cause: %d", establishmentReject.GetCauseValue())
 	default:
 		return fmt.Errorf("not implemented: %+v", nasMessage.GsmHeader.GetMessageType())
 	}
@@ -618,181 +1533,354 @@ func (u *Ue) extractUeInformationFromNasPduSessionEstablishmentAccept(nasPduSess
 func (u *Ue) waitForRanMessage(ctx context.Context, wg *sync.WaitGroup) {
 	u.RanLog.Infoln("Waiting for RAN message")
 	wg.Add(1)
+	defer wg.Done()
+
+	defer u.RanLog.Infoln("Stop waiting for RAN message")
 
-	buffer := make([]byte, 1024)
 	for {
-		if err := u.ranControlPlaneConn.SetReadDeadline(time.Now().Add(1 * time.Second)); err != nil {
-			u.RanLog.Errorf("Error set read deadline: %+v", err)
-			goto STOP_WAITING
+		message, err := u.readMessageFromRanWithContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+				return
+			}
+			u.RanLog.Warnf("Error read from ran control plane: %+v", err)
+			return
 		}
-		select {
-		case <-ctx.Done():
-			if err := u.ranControlPlaneConn.SetReadDeadline(time.Time{}); err != nil {
-				u.RanLog.Errorf("Error set read deadline: %+v", err)
+		u.RanLog.Tracef("Received %d bytes of %s message from RAN", len(message.Payload), message.Type)
+
+		switch message.Type {
+		case protocol.MESSAGE_TYPE_RRC:
+			u.handleRrcMessage(message.Payload)
+		case protocol.MESSAGE_TYPE_RELEASE:
+			u.RanLog.Warnf("Released by RAN, cause: %s", protocol.CauseFromPayload(message.Payload))
+			u.setRrcState(protocol.RRC_STATE_IDLE)
+		case protocol.MESSAGE_TYPE_NAS:
+			u.handleNasMessage(message.Payload)
+		case protocol.MESSAGE_TYPE_PAGING:
+			if u.getRrcState() != protocol.RRC_STATE_INACTIVE {
+				u.RanLog.Debugln("Received paging from RAN while connected, ignored")
+				continue
 			}
-			goto STOP_WAITING
-		default:
-			n, err := u.ranControlPlaneConn.Read(buffer)
-			if err != nil {
-				if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
-					goto STOP_WAITING
+			u.RanLog.Infoln("Received paging from RAN in RRC_INACTIVE, resuming")
+			go func() {
+				if err := u.resumeRrcConnection(protocol.RRC_ESTABLISHMENT_CAUSE_MT_ACCESS); err != nil {
+					u.RanLog.Warnf("Error resume rrc connection on paging: %+v", err)
 				}
-				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-					continue
-				}
-				u.RanLog.Warnf("Error read from ran control plane: %+v", err)
-			}
+			}()
+		default:
+			u.RanLog.Warnf("Received unexpected %s message from RAN: %+v", message.Type, message.Payload)
+		}
+	}
+}
 
-			switch string(buffer[:n]) {
-			case constant.UE_TUNNEL_UPDATE:
-				go u.updateDataPlane()
-			default:
-				u.RanLog.Warnf("Received unknown message from RAN: %+v", buffer[:n])
-			}
+// sendToRan frames the payload as the given message type and writes it to the RAN control plane,
+// the message is secured once AS security is activated
+func (u *Ue) sendToRan(messageType protocol.MessageType, payload []byte) (int, error) {
+	u.ranControlPlaneWriteMtx.Lock()
+	defer u.ranControlPlaneWriteMtx.Unlock()
+
+	message := protocol.NewMessage(messageType, payload)
+	if asSecurityContext := u.getAsSecurityContext(); asSecurityContext != nil {
+		securedMessage, err := asSecurityContext.SecureMessage(message)
+		if err != nil {
+			return 0, fmt.Errorf("error secure %s message: %+v", messageType, err)
 		}
+		message = securedMessage
 	}
-STOP_WAITING:
-	u.RanLog.Infoln("Stop waiting for RAN message")
-	wg.Done()
+
+	return protocol.WriteMessage(u.ranControlPlaneConn, message)
 }
 
-func (u *Ue) setupTunnelDevice() error {
-	u.TunLog.Infoln("Setting up UE tunnel device")
+// readMessageFromRan reads the next message from the RAN control plane, verified and deciphered once AS security is activated
+func (u *Ue) readMessageFromRan() (*protocol.Message, error) {
+	return u.readMessageFromRanWithContext(context.Background())
+}
 
-	waterInterface, err := bringUpUeTunnelDevice(u.ueTunnelDeviceName, u.ueIp)
+// readMessageFromRanWithContext is readMessageFromRan giving up on the cancellation of ctx, a frame arriving later
+// is left to the next read
+func (u *Ue) readMessageFromRanWithContext(ctx context.Context) (*protocol.Message, error) {
+	message, err := u.ranControlPlaneReader.receive(ctx)
 	if err != nil {
-		return fmt.Errorf("error bring up ue tunnel device: %+v", err)
+		return nil, err
 	}
-	u.TunLog.Debugln("Bring up ue tunnel device success")
 
-	u.ueTunnelDevice = waterInterface
+	if asSecurityContext := u.getAsSecurityContext(); asSecurityContext != nil {
+		return asSecurityContext.UnsecureMessage(message)
+	}
+	return message, nil
+}
 
-	// go routine for read data from TUN
-	u.readFromTun = make(chan []byte)
-	go func() {
-		buffer := make([]byte, 4096)
-		for {
-			n, err := u.ueTunnelDevice.Read(buffer)
-			if err != nil {
-				u.TunLog.Errorf("Error read from ue tunnel device: %+v", err)
-				return
-			}
-			version := buffer[0] >> 4
-			if version == 6 {
-				continue
-			}
+// receiveFromRan reads the next message from the RAN control plane and checks that it is of the expected type,
+// a reject from RAN is returned as an error
+func (u *Ue) receiveFromRan(messageType protocol.MessageType) ([]byte, error) {
+	message, err := u.readMessageFromRan()
+	if err != nil {
+		return nil, err
+	}
+	if message.Type == protocol.MESSAGE_TYPE_REJECT {
+		return nil, fmt.Errorf("rejected by RAN, cause: %s", protocol.CauseFromPayload(message.Payload))
+	}
+	if message.Type != messageType {
+		return nil, fmt.Errorf("unexpected message type %s, expected %s", message.Type, messageType)
+	}
 
-			tmp := make([]byte, n)
-			copy(tmp, buffer[:n])
-			u.readFromTun <- tmp
-		}
-	}()
-	u.TunLog.Debugln("Read from TUN started")
+	return message.Payload, nil
+}
+
+// setupDataPlane starts reading the downlink packets from RAN and brings up the tunnel device of each established pdu session
+func (u *Ue) setupDataPlane() error {
+	u.readFromTun = make(chan dataPlanePacket)
 
 	// go routing for read data from RAN
-	u.readFromRan = make(chan []byte, 2)
+	u.readFromRan = make(chan dataPlanePacket, 2)
+	go u.readFromRanDataPlane(u.ranDataPlaneConn, u.getAsSecurityContext().Drb, "RAN")
+	u.TunLog.Debugln("Read from RAN started")
+
+	if u.isNrdcEnabled() {
+		go u.readFromRanDataPlane(u.dcRanDataPlaneConn, u.getScgDrb(), "DC RAN")
+		u.TunLog.Debugln("Read from DC RAN data plane started")
+	}
+
+	for _, session := range u.pduSessions {
+		if _, established := u.getUeTunnelDevice(session.id); !established {
+			continue
+		}
+		if err := u.setupPduSessionTunnelDevice(session); err != nil {
+			u.cleanUpTunnelDevices()
+			return fmt.Errorf("error set up tunnel device of pdu session %d: %+v", session.id, err)
+		}
+	}
+	return nil
+}
+
+// setupPduSessionTunnelDevice brings up the tunnel device of the pdu session by the selected pdu session type
+// and forwards the uplink packets read from it to readFromTun, an IPv6 session then solicits its prefix
+func (u *Ue) setupPduSessionTunnelDevice(session *pduSession) error {
+	if session.hasIpv6() {
+		defer func() {
+			go u.solicitRouterAdvertisement(session)
+		}()
+	}
+
+	var ueTunnelDevice io.ReadWriteCloser
+	switch session.pduSessionEstablishmentAccept.pduSessionType {
+	case nasMessage.PDUSessionTypeUnstructured:
+		if session.unstructuredSocket == "" {
+			u.TunLog.Infof("UE data plane of PDU session %d kept in user space", session.id)
+			return nil
+		}
+
+		u.TunLog.Infof("Setting up UE unstructured socket of PDU session %d", session.id)
+
+		socket, err := newUnstructuredSocket(session.unstructuredSocket)
+		if err != nil {
+			return fmt.Errorf("error set up ue unstructured socket: %+v", err)
+		}
+		u.TunLog.Debugln("Set up ue unstructured socket success")
+
+		ueTunnelDevice = socket
+	case nasMessage.PDUSessionTypeEthernet:
+		if session.ueTunnelDeviceName == "" {
+			u.TunLog.Infof("UE data plane of PDU session %d kept in user space", session.id)
+			return nil
+		}
+
+		u.TunLog.Infof("Setting up UE tap device of PDU session %d", session.id)
+
+		waterInterface, err := bringUpUeTapDevice(session.ueTunnelDeviceName)
+		if err != nil {
+			return fmt.Errorf("error bring up ue tap device: %+v", err)
+		}
+		u.TunLog.Debugln("Bring up ue tap device success")
+
+		ueTunnelDevice = waterInterface
+	default:
+		if session.ueTunnelDeviceName == "" {
+			u.TunLog.Infof("UE data plane of PDU session %d kept in user space", session.id)
+			return nil
+		}
+
+		u.TunLog.Infof("Setting up UE tunnel device of PDU session %d", session.id)
+
+		ipv6LinkLocal := ""
+		if session.hasIpv6() {
+			ipv6LinkLocal = util.Ipv6LinkLocalAddress(session.ueIpv6InterfaceIdentifier).String()
+		}
+		waterInterface, err := bringUpUeTunnelDevice(session.ueTunnelDeviceName, session.ueIp, ipv6LinkLocal)
+		if err != nil {
+			return fmt.Errorf("error bring up ue tunnel device: %+v", err)
+		}
+		u.TunLog.Debugln("Bring up ue tunnel device success")
+
+		ueTunnelDevice = waterInterface
+	}
+
+	u.setUeTunnelDevice(session, ueTunnelDevice)
+
+	// go routine for read data from TUN, TAP or unstructured socket
 	go func() {
 		buffer := make([]byte, 4096)
 		for {
-			n, err := u.ranDataPlaneConn.Read(buffer)
+			n, err := ueTunnelDevice.Read(buffer)
 			if err != nil {
-				if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
-					u.TunLog.Debugln("RAN data plane connection closed")
+				if errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrClosed) {
+					u.TunLog.Debugf("UE tunnel device of PDU session %d closed", session.id)
 					return
 				}
-				u.RanLog.Errorf("Error read from ran data plane: %+v", err)
+				u.TunLog.Errorf("Error read from ue tunnel device of PDU session %d: %+v", session.id, err)
 				return
 			}
-
 			tmp := make([]byte, n)
 			copy(tmp, buffer[:n])
-			u.readFromRan <- tmp
+			u.readFromTun <- dataPlanePacket{pduSessionId: session.id, payload: tmp}
 		}
 	}()
-	u.TunLog.Debugln("Read from RAN started")
+	u.TunLog.Debugf("Read from tunnel device of PDU session %d started", session.id)
 
-	if u.isNrdcEnabled() {
-		go func() {
-			buffer := make([]byte, 4096)
-			for {
-				n, err := u.dcRanDataPlaneConn.Read(buffer)
-				if err != nil {
-					if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
-						u.TunLog.Debugln("DC RAN data plane connection closed")
-						return
-					}
-					u.RanLog.Errorf("Error read from dc ran data plane: %+v", err)
-				}
+	if session.pduSessionEstablishmentAccept.pduSessionType == nasMessage.PDUSessionTypeUnstructured {
+		u.TunLog.Infof("UE unstructured socket of PDU session %d setup on %s", session.id, session.unstructuredSocket)
+	} else {
+		u.TunLog.Infof("UE tunnel device of PDU session %d setup as %s", session.id, session.ueTunnelDeviceName)
+	}
+	return nil
+}
 
-				tmp := make([]byte, n)
-				copy(tmp, buffer[:n])
-				u.readFromRan <- tmp
+// readFromRanDataPlane forwards the downlink packets of a cell group to readFromRan,
+// a packet failing the AS security check of the DRB is dropped
+func (u *Ue) readFromRanDataPlane(conn net.Conn, drb *protocol.PdcpEntity, name string) {
+	buffer := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+				u.TunLog.Debugf("%s data plane connection closed", name)
+				return
 			}
-		}()
-		u.TunLog.Debugln("Read from DC RAN data plane started")
+			u.RanLog.Errorf("Error read from %s data plane: %+v", name, err)
+			return
+		}
+
+		pduSessionId, packet, err := util.UnprotectDataPlanePacket(drb, buffer[:n])
+		if err != nil {
+			u.RanLog.Warnf("Dropped downlink packet from %s data plane: %+v", name, err)
+			continue
+		}
+		u.readFromRan <- dataPlanePacket{pduSessionId: pduSessionId, payload: packet}
 	}
+}
 
-	u.TunLog.Infof("UE tunnel device setup as %s", u.ueTunnelDeviceName)
-	return nil
+// writeToRanDataPlane protects the uplink packet of the pdu session on the DRB of the cell group and writes it to its data plane,
+// returns the length of the packet
+func (u *Ue) writeToRanDataPlane(conn net.Conn, drb *protocol.PdcpEntity, packet dataPlanePacket) (int, error) {
+	pdu, err := util.ProtectDataPlanePacket(drb, packet.pduSessionId, packet.payload)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := conn.Write(pdu); err != nil {
+		return 0, err
+	}
+	return len(packet.payload), nil
 }
 
-func (u *Ue) cleanUpTunnelDevice() error {
-	u.TunLog.Infoln("Cleaning up UE tunnel device")
+// cleanUpTunnelDevices tears down the tunnel device of every pdu session
+func (u *Ue) cleanUpTunnelDevices() {
+	for _, session := range u.pduSessions {
+		u.tearDownPduSessionTunnelDevice(session)
+	}
+}
+
+// tearDownPduSessionTunnelDevice brings down and closes the tunnel device of the pdu session, or closes the socket of an unstructured one,
+// the reader of the device stops on the close
+func (u *Ue) tearDownPduSessionTunnelDevice(session *pduSession) {
+	ueTunnelDevice, _ := u.getUeTunnelDevice(session.id)
+	if ueTunnelDevice == nil {
+		return
+	}
+
+	if socket, ok := ueTunnelDevice.(*unstructuredSocket); ok {
+		if err := socket.Close(); err != nil {
+			u.TunLog.Errorf("Error close ue unstructured socket %s: %+v", session.unstructuredSocket, err)
+			return
+		}
+		u.setUeTunnelDevice(session, nil)
+		u.TunLog.Infof("UE unstructured socket %s closed", session.unstructuredSocket)
+		return
+	}
+
+	u.TunLog.Infof("Cleaning up UE tunnel device of PDU session %d", session.id)
 
-	if err := bringDownUeTunnelDevice(u.ueTunnelDeviceName); err != nil {
-		return fmt.Errorf("error bring down ue tunnel device: %+v", err)
+	if err := bringDownUeTunnelDevice(session.ueTunnelDeviceName); err != nil {
+		u.TunLog.Errorf("Error bring down ue tunnel device %s: %+v", session.ueTunnelDeviceName, err)
+		return
 	}
+	if err := ueTunnelDevice.Close(); err != nil {
+		u.TunLog.Warnf("Error close ue tunnel device %s: %+v", session.ueTunnelDeviceName, err)
+	}
+	u.setUeTunnelDevice(session, nil)
 	u.TunLog.Debugln("Bring down ue tunnel device success")
 
-	u.TunLog.Infoln("UE tunnel device cleaned up")
-	return nil
+	u.TunLog.Infof("UE tunnel device %s cleaned up", session.ueTunnelDeviceName)
 }
 
 func (u *Ue) handleDataPlane(ctx context.Context, wg *sync.WaitGroup) {
 	wg.Add(1)
 
-	// forward data from TUN to RAN and RAN to TUN
+	// forward data from TUN to RAN and RAN to TUN, NR-DC splits the specified flows of the primary pdu session only
 	for {
 		select {
 		case <-ctx.Done():
 			goto HANDLE_DATA_PLANE_FINISH
-		case buffer := <-u.readFromTun:
-			if !u.isNrdcEnabled() {
-				n, err := u.ranDataPlaneConn.Write(buffer)
+		case packet := <-u.readFromTun:
+			// a suspended UE resumes before its uplink data
+			if err := u.resumeRrcConnection(protocol.RRC_ESTABLISHMENT_CAUSE_MO_DATA); err != nil {
+				u.RanLog.Warnf("Dropped %d bytes of PDU session %d data: %+v", len(packet.payload), packet.pduSessionId, err)
+				continue
+			}
+			if u.isUplinkOnScg(packet) {
+				n, err := u.writeToRanDataPlane(u.dcRanDataPlaneConn, u.getScgDrb(), packet)
 				if err != nil {
 					if errors.Is(err, net.ErrClosed) {
 						goto HANDLE_DATA_PLANE_FINISH
 					}
-					u.RanLog.Warnf("Error sent to ran data plane: %+v", err)
+					u.RanLog.Warnf("Error sent to dc ran data plane: %+v", err)
+				} else {
+					u.countUplink(n, true)
 				}
-				u.RanLog.Tracef("Sent %d bytes of data to RAN: %+v", n, buffer[:n])
+				u.RanLog.Tracef("Sent %d bytes of PDU session %d data to DC RAN: %+v", n, packet.pduSessionId, packet.payload[:n])
 			} else {
-				if util.IsIpInSpecifiedFlow(buffer, u.nrdc.specifiedFlow) {
-					n, err := u.dcRanDataPlaneConn.Write(buffer)
-					if err != nil {
-						if errors.Is(err, net.ErrClosed) {
-							goto HANDLE_DATA_PLANE_FINISH
-						}
-						u.RanLog.Warnf("Error sent to dc ran data plane: %+v", err)
+				n, err := u.writeToRanDataPlane(u.ranDataPlaneConn, u.getAsSecurityContext().Drb, packet)
+				if err != nil {
+					if errors.Is(err, net.ErrClosed) {
+						goto HANDLE_DATA_PLANE_FINISH
 					}
-					u.RanLog.Tracef("Sent %d bytes of data to DC RAN: %+v", n, buffer[:n])
+					u.RanLog.Warnf("Error sent to ran data plane: %+v", err)
 				} else {
-					n, err := u.ranDataPlaneConn.Write(buffer)
-					if err != nil {
-						if errors.Is(err, net.ErrClosed) {
-							goto HANDLE_DATA_PLANE_FINISH
-						}
-						u.RanLog.Warnf("Error sent to ran data plane: %+v", err)
-					}
-					u.RanLog.Tracef("Sent %d bytes of data to RAN: %+v", n, buffer[:n])
+					u.countUplink(n, false)
 				}
+				u.RanLog.Tracef("Sent %d bytes of PDU session %d data to RAN: %+v", n, packet.pduSessionId, packet.payload[:n])
+			}
+		case packet := <-u.readFromRan:
+			if u.handleRouterAdvertisement(packet) {
+				continue
+			}
+			ueTunnelDevice, established := u.getUeTunnelDevice(packet.pduSessionId)
+			if !established {
+				u.TunLog.Warnf("Dropped %d bytes of data of unknown PDU session %d", len(packet.payload), packet.pduSessionId)
+				continue
 			}
-		case buffer := <-u.readFromRan:
-			n, err := u.ueTunnelDevice.Write(buffer)
+			u.dlPackets.Add(1)
+			u.dlBytes.Add(uint64(len(packet.payload)))
+			if ueTunnelDevice == nil {
+				u.userspaceDlPackets.Add(1)
+				u.TunLog.Tracef("Dropped %d bytes of PDU session %d data in user space", len(packet.payload), packet.pduSessionId)
+				continue
+			}
+			n, err := ueTunnelDevice.Write(packet.payload)
 			if err != nil {
 				u.TunLog.Warnf("Error write to ue tunnel device: %+v", err)
 			}
-			u.TunLog.Tracef("Wrote %d bytes of data to TUN: %+v", n, buffer[:n])
+			u.TunLog.Tracef("Wrote %d bytes of PDU session %d data to TUN: %+v", n, packet.pduSessionId, packet.payload[:n])
 		}
 	}
 
@@ -800,6 +1888,60 @@ HANDLE_DATA_PLANE_FINISH:
 	wg.Done()
 }
 
+// solicitRouterAdvertisement sends router solicitations over the IPv6 pdu session until its prefix is advertised
+func (u *Ue) solicitRouterAdvertisement(session *pduSession) {
+	routerSolicitation := util.BuildRouterSolicitation(util.Ipv6LinkLocalAddress(session.ueIpv6InterfaceIdentifier))
+
+	for i := 0; i < constant.UE_IPV6_MAX_ROUTER_SOLICITATIONS; i++ {
+		if u.getUeIpv6(session.id) != "" {
+			return
+		}
+
+		if _, err := u.writeToRanDataPlane(u.ranDataPlaneConn, u.getAsSecurityContext().Drb, dataPlanePacket{pduSessionId: session.id, payload: routerSolicitation}); err != nil {
+			u.PduLog.Warnf("Error send router solicitation of PDU session %d: %+v", session.id, err)
+			return
+		}
+		u.PduLog.Debugf("Sent router solicitation of PDU session %d", session.id)
+
+		time.Sleep(constant.UE_IPV6_ROUTER_SOLICITATION_INTERVAL)
+	}
+
+	if u.getUeIpv6(session.id) == "" {
+		u.PduLog.Warnf("No router advertisement of PDU session %d, only the link local address is configured", session.id)
+	}
+}
+
+// handleRouterAdvertisement forms the IPv6 address of a pdu session waiting for its prefix from the router advertisement,
+// returns true if the packet is consumed
+func (u *Ue) handleRouterAdvertisement(packet dataPlanePacket) bool {
+	session, exists := u.getPduSession(packet.pduSessionId)
+	if !exists || !session.hasIpv6() {
+		return false
+	}
+
+	prefix, prefixLength, ok := util.ParseRouterAdvertisementPrefix(packet.payload)
+	if !ok {
+		return false
+	}
+	if prefixLength != 64 {
+		u.PduLog.Warnf("Ignored advertised prefix %s/%d of PDU session %d, expected a /64 prefix", prefix, prefixLength, packet.pduSessionId)
+		return true
+	}
+	ueIpv6 := util.Ipv6GlobalAddress(prefix, session.ueIpv6InterfaceIdentifier).String()
+	if _, waiting := u.setUeIpv6(packet.pduSessionId, ueIpv6); !waiting {
+		// the periodic advertisements of a configured session are for the tunnel device
+		return false
+	}
+	u.PduLog.Infof("PDU session %d UE IPv6: %s, prefix %s/%d", packet.pduSessionId, ueIpv6, prefix, prefixLength)
+
+	if ueTunnelDevice, _ := u.getUeTunnelDevice(packet.pduSessionId); ueTunnelDevice != nil {
+		if err := addUeTunnelDeviceIpv6Address(session.ueTunnelDeviceName, ueIpv6); err != nil {
+			u.TunLog.Warnf("Error configure IPv6 address of PDU session %d: %+v", packet.pduSessionId, err)
+		}
+	}
+	return true
+}
+
 func (u *Ue) updateDataPlane() {
 	u.TunLog.Infoln("Updating data plane")
 
@@ -814,30 +1956,13 @@ func (u *Ue) updateDataPlane() {
 		}
 		u.dcRanDataPlaneConn = conn
 
-		_, err = u.dcRanDataPlaneConn.Write([]byte(constant.UE_DATA_PLANE_INITIAL_PACKET))
-		if err != nil {
-			u.TunLog.Errorf("Error send initial packet: %+v", err)
+		if err := u.sendDataPlaneRegistration(u.dcRanDataPlaneConn); err != nil {
+			u.TunLog.Errorf("Error send data plane registration: %+v", err)
 			return
 		}
-		u.RanLog.Debugln("Sent initial packet to DC RAN data plane UDP server")
-
-		go func() {
-			buffer := make([]byte, 4096)
-			for {
-				n, err := u.dcRanDataPlaneConn.Read(buffer)
-				if err != nil {
-					if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
-						u.TunLog.Debugln("DC RAN data plane connection closed")
-						return
-					}
-					u.RanLog.Errorf("Error read from dc ran data plane: %+v", err)
-				}
+		u.RanLog.Debugln("Sent data plane registration to DC RAN data plane UDP server")
 
-				tmp := make([]byte, n)
-				copy(tmp, buffer[:n])
-				u.readFromRan <- tmp
-			}
-		}()
+		go u.readFromRanDataPlane(u.dcRanDataPlaneConn, u.nrdc.scgDrb, "DC RAN")
 		u.TunLog.Debugln("Read from DC RAN data plane started")
 
 		u.nrdc.enable = true
@@ -846,6 +1971,7 @@ func (u *Ue) updateDataPlane() {
 		if err := u.dcRanDataPlaneConn.Close(); err != nil {
 			u.UeLog.Errorf("Error closing DC RAN connection: %v", err)
 		}
+		u.nrdc.scgDrb = nil
 
 		u.nrdc.enable = false
 		u.TunLog.Infoln("Data plane is updated to non-NRDC mode")
@@ -877,3 +2003,55 @@ func (u *Ue) isNrdcEnabled() bool {
 
 	return u.nrdc.enable
 }
+
+func (u *Ue) getScgDrb() *protocol.PdcpEntity {
+	u.rwLock.RLock()
+	defer u.rwLock.RUnlock()
+
+	return u.nrdc.scgDrb
+}
+
+// isUplinkOnScg tells if the uplink packet goes to the secondary cell group, only the primary pdu session is split by NR-DC,
+// its specified flows by default or all or none of its packets when the uplink leg is forced
+func (u *Ue) isUplinkOnScg(packet dataPlanePacket) bool {
+	primaryPduSessionId := u.getPrimaryPduSessionId()
+
+	u.rwLock.RLock()
+	defer u.rwLock.RUnlock()
+
+	if !u.nrdc.enable || packet.pduSessionId != primaryPduSessionId {
+		return false
+	}
+	switch u.nrdc.uplinkLeg {
+	case constant.UE_NRDC_UPLINK_LEG_MCG:
+		return false
+	case constant.UE_NRDC_UPLINK_LEG_SCG:
+		return true
+	default:
+		return util.IsIpInSpecifiedFlow(packet.payload, u.nrdc.specifiedFlow)
+	}
+}
+
+// setUplinkLeg forces the uplink of the primary pdu session to a cell group, or back to the specified flows with auto
+func (u *Ue) setUplinkLeg(uplinkLeg string) error {
+	switch uplinkLeg {
+	case constant.UE_NRDC_UPLINK_LEG_AUTO, constant.UE_NRDC_UPLINK_LEG_MCG, constant.UE_NRDC_UPLINK_LEG_SCG:
+	default:
+		return fmt.Errorf("invalid uplink leg: %s, must be %s, %s or %s", uplinkLeg, constant.UE_NRDC_UPLINK_LEG_AUTO, constant.UE_NRDC_UPLINK_LEG_MCG, constant.UE_NRDC_UPLINK_LEG_SCG)
+	}
+
+	u.rwLock.Lock()
+	defer u.rwLock.Unlock()
+
+	u.nrdc.uplinkLeg = uplinkLeg
+	u.TunLog.Infof("NR-DC uplink leg of the primary PDU session: %s", uplinkLeg)
+	return nil
+}
+
+func (u *Ue) countUplink(n int, scg bool) {
+	u.ulPackets.Add(1)
+	u.ulBytes.Add(uint64(n))
+	if scg {
+		u.scgUlPackets.Add(1)
+	}
+}
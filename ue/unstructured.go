@@ -0,0 +1,62 @@
+package ue
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// unstructuredSocket carries the payloads of an Unstructured PDU session as the datagrams of a local UDP address,
+// an uplink payload is a datagram received from an application and a downlink payload goes to the last application heard from
+type unstructuredSocket struct {
+	conn *net.UDPConn
+
+	peer    *net.UDPAddr
+	peerMtx sync.Mutex
+}
+
+func newUnstructuredSocket(address string) (*unstructuredSocket, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error resolve unstructured socket address: %v", err)
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error listen on unstructured socket: %v", err)
+	}
+
+	return &unstructuredSocket{
+		conn: conn,
+
+		peerMtx: sync.Mutex{},
+	}, nil
+}
+
+func (s *unstructuredSocket) Read(payload []byte) (int, error) {
+	n, peer, err := s.conn.ReadFromUDP(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	s.peerMtx.Lock()
+	s.peer = peer
+	s.peerMtx.Unlock()
+
+	return n, nil
+}
+
+func (s *unstructuredSocket) Write(payload []byte) (int, error) {
+	s.peerMtx.Lock()
+	peer := s.peer
+	s.peerMtx.Unlock()
+
+	if peer == nil {
+		return 0, fmt.Errorf("no application has sent to unstructured socket %s yet", s.conn.LocalAddr())
+	}
+	return s.conn.WriteToUDP(payload, peer)
+}
+
+func (s *unstructuredSocket) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,57 @@
+package ue
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-playground/assert"
+)
+
+var testUnstructuredSocketCases = []struct {
+	name            string
+	uplinkPayload   []byte
+	downlinkPayload []byte
+}{
+	{
+		name:            "testUplinkAndDownlinkPayload",
+		uplinkPayload:   []byte{0x01, 0x02, 0x03},
+		downlinkPayload: []byte{0x04, 0x05},
+	},
+}
+
+func TestUnstructuredSocket(t *testing.T) {
+	for _, testCase := range testUnstructuredSocketCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			socket, err := newUnstructuredSocket("127.0.0.1:0")
+			assert.Equal(t, nil, err)
+			defer func() {
+				assert.Equal(t, nil, socket.Close())
+			}()
+
+			_, err = socket.Write(testCase.downlinkPayload)
+			assert.NotEqual(t, nil, err)
+
+			application, err := net.DialUDP("udp", nil, socket.conn.LocalAddr().(*net.UDPAddr))
+			assert.Equal(t, nil, err)
+			defer func() {
+				assert.Equal(t, nil, application.Close())
+			}()
+
+			_, err = application.Write(testCase.uplinkPayload)
+			assert.Equal(t, nil, err)
+
+			buffer := make([]byte, 64)
+			n, err := socket.Read(buffer)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, testCase.uplinkPayload, buffer[:n])
+
+			n, err = socket.Write(testCase.downlinkPayload)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, len(testCase.downlinkPayload), n)
+
+			n, err = application.Read(buffer)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, testCase.downlinkPayload, buffer[:n])
+		})
+	}
+}
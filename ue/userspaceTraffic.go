@@ -0,0 +1,91 @@
+package ue
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/model"
+	"github.com/Alonza0314/free-ran-ue/util"
+)
+
+// userspaceTraffic is the uplink of the data plane kept in user space, an ICMP echo request to the target over each
+// IPv4 pdu session per interval, whose echo replies come back as the userspace downlink packets
+type userspaceTraffic struct {
+	target   net.IP
+	interval time.Duration
+
+	ulPackets atomic.Uint64
+}
+
+func newUserspaceTraffic(multiUe *model.MultiUeIE) userspaceTraffic {
+	if !multiUe.Enable || multiUe.Traffic.Target == "" {
+		return userspaceTraffic{}
+	}
+
+	interval := multiUe.Traffic.Interval
+	if interval == 0 {
+		interval = constant.MULTI_UE_DEFAULT_TRAFFIC_INTERVAL
+	}
+	return userspaceTraffic{
+		target:   net.ParseIP(multiUe.Traffic.Target).To4(),
+		interval: interval,
+	}
+}
+
+// generateUserspaceTraffic sends the echo requests to handleDataPlane as the packets read from a tunnel device,
+// so that they take the uplink of a tunnel device, e.g. a resume from RRC_INACTIVE and the split of NR-DC
+func (u *Ue) generateUserspaceTraffic(ctx context.Context, wg *sync.WaitGroup) {
+	if u.userspaceTraffic.target == nil {
+		return
+	}
+	wg.Add(1)
+	defer wg.Done()
+
+	u.TunLog.Infof("Sending userspace traffic to %s every %v", u.userspaceTraffic.target, u.userspaceTraffic.interval)
+
+	ticker := time.NewTicker(u.userspaceTraffic.interval)
+	defer ticker.Stop()
+
+	var sequenceNumber uint16
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sequenceNumber++
+		for _, packet := range u.getUserspaceEchoRequests(sequenceNumber) {
+			select {
+			case <-ctx.Done():
+				return
+			case u.readFromTun <- packet:
+				u.userspaceTraffic.ulPackets.Add(1)
+				u.TunLog.Tracef("Sent echo request %d of PDU session %d in user space", sequenceNumber, packet.pduSessionId)
+			}
+		}
+	}
+}
+
+// getUserspaceEchoRequests builds an echo request from the UE IP of each established IPv4 pdu session kept in user space,
+// identified by the pdu session id
+func (u *Ue) getUserspaceEchoRequests(sequenceNumber uint16) []dataPlanePacket {
+	u.pduSessionMtx.Lock()
+	defer u.pduSessionMtx.Unlock()
+
+	packets := make([]dataPlanePacket, 0, len(u.pduSessions))
+	for _, session := range u.pduSessions {
+		if !session.established || session.ueTunnelDevice != nil || session.ueIp == "" {
+			continue
+		}
+		packets = append(packets, dataPlanePacket{
+			pduSessionId: session.id,
+			payload:      util.BuildIcmpEchoRequest(net.ParseIP(session.ueIp), u.userspaceTraffic.target, uint16(session.id), sequenceNumber),
+		})
+	}
+	return packets
+}
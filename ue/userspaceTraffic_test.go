@@ -0,0 +1,43 @@
+package ue
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/go-playground/assert"
+)
+
+func TestGetUserspaceEchoRequests(t *testing.T) {
+	ue := &Ue{
+		pduSessions: []*pduSession{
+			{
+				id:                            1,
+				established:                   true,
+				pduSessionEstablishmentAccept: pduSessionEstablishmentAccept{pduSessionType: nasMessage.PDUSessionTypeIPv4, ueIp: "10.60.0.1"},
+			},
+			{
+				id:                            2,
+				established:                   true,
+				ueTunnelDevice:                &unstructuredSocket{},
+				pduSessionEstablishmentAccept: pduSessionEstablishmentAccept{pduSessionType: nasMessage.PDUSessionTypeIPv4, ueIp: "10.60.0.2"},
+			},
+			{
+				id:                            3,
+				established:                   true,
+				pduSessionEstablishmentAccept: pduSessionEstablishmentAccept{pduSessionType: nasMessage.PDUSessionTypeEthernet},
+			},
+			{
+				id: 4,
+			},
+		},
+		userspaceTraffic: userspaceTraffic{target: net.ParseIP("10.60.0.254").To4()},
+	}
+
+	// only the established IPv4 session kept in user space sends an echo request
+	packets := ue.getUserspaceEchoRequests(3)
+	assert.Equal(t, 1, len(packets))
+	assert.Equal(t, uint8(1), packets[0].pduSessionId)
+	assert.Equal(t, util.BuildIcmpEchoRequest(net.ParseIP("10.60.0.1"), net.ParseIP("10.60.0.254"), 1, 3), packets[0].payload)
+}
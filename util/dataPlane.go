@@ -0,0 +1,184 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/protocol"
+)
+
+// DataPlaneRegistration is issued by the RAN to the UE over the control connection,
+// the token is the key of the data plane registration requests of the UE and never sent over the data plane
+type DataPlaneRegistration struct {
+	Identity string
+	Token    []byte
+}
+
+// DataPlaneRegistrationRequest is sent by the UE over the data plane to bind its data plane address,
+// authenticated by a MAC keyed with the token and replay protected by a counter increasing with each request
+type DataPlaneRegistrationRequest struct {
+	Identity string
+	Counter  uint64
+	Mac      []byte
+}
+
+func NewDataPlaneToken() ([]byte, error) {
+	token := make([]byte, constant.UE_DATA_PLANE_TOKEN_LENGTH)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("error generate data plane token: %v", err)
+	}
+	return token, nil
+}
+
+// IsDataPlaneRegistration reports whether the packet received on the data plane is a registration,
+// the first octet of a registration never carries an IP version
+func IsDataPlaneRegistration(packet []byte) bool {
+	return len(packet) > 0 && packet[0] == constant.UE_DATA_PLANE_REGISTRATION
+}
+
+// ProtectDataPlanePacket protects the user plane packet of the PDU session on the DRB and prefixes it with UE_DATA_PLANE_PDU,
+// the PDU session ID is the first octet of the protected sdu so that it is integrity protected along with the packet
+func ProtectDataPlanePacket(drb *protocol.PdcpEntity, pduSessionId uint8, packet []byte) ([]byte, error) {
+	if drb == nil {
+		return nil, errors.New("AS security of the DRB is not activated")
+	}
+
+	sdu := make([]byte, 0, 1+len(packet))
+	sdu = append(sdu, pduSessionId)
+	sdu = append(sdu, packet...)
+
+	pdu, err := drb.Protect(sdu)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{constant.UE_DATA_PLANE_PDU}, pdu...), nil
+}
+
+// UnprotectDataPlanePacket verifies and deciphers a packet from ProtectDataPlanePacket,
+// and returns the PDU session ID and the user plane packet
+func UnprotectDataPlanePacket(drb *protocol.PdcpEntity, packet []byte) (uint8, []byte, error) {
+	if drb == nil {
+		return 0, nil, errors.New("AS security of the DRB is not activated")
+	}
+	if len(packet) < 1 || packet[0] != constant.UE_DATA_PLANE_PDU {
+		return 0, nil, errors.New("unprotected data plane packet")
+	}
+
+	sdu, err := drb.Unprotect(packet[1:])
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(sdu) < 1 {
+		return 0, nil, errors.New("no pdu session id in data plane packet")
+	}
+	return sdu[0], sdu[1:], nil
+}
+
+func (r *DataPlaneRegistration) Marshal(messageType uint8) ([]byte, error) {
+	if len(r.Identity) > 0xff {
+		return nil, fmt.Errorf("identity too long: %d", len(r.Identity))
+	}
+	if len(r.Token) > 0xff {
+		return nil, fmt.Errorf("token too long: %d", len(r.Token))
+	}
+
+	buffer := make([]byte, 0, 3+len(r.Identity)+len(r.Token))
+	buffer = append(buffer, messageType, uint8(len(r.Identity)))
+	buffer = append(buffer, r.Identity...)
+	buffer = append(buffer, uint8(len(r.Token)))
+	buffer = append(buffer, r.Token...)
+
+	return buffer, nil
+}
+
+func (r *DataPlaneRegistration) Unmarshal(messageType uint8, data []byte) error {
+	if len(data) < 2 {
+		return errors.New("data too short")
+	}
+	if data[0] != messageType {
+		return fmt.Errorf("unexpected message type: %d, expected %d", data[0], messageType)
+	}
+
+	identityLength := int(data[1])
+	data = data[2:]
+	if len(data) < identityLength+1 {
+		return errors.New("data too short")
+	}
+	r.Identity = string(data[:identityLength])
+	data = data[identityLength:]
+
+	tokenLength := int(data[0])
+	data = data[1:]
+	if len(data) != tokenLength {
+		return fmt.Errorf("invalid token length: %d, remaining %d bytes", tokenLength, len(data))
+	}
+	r.Token = append([]byte{}, data...)
+
+	return nil
+}
+
+func NewDataPlaneRegistrationRequest(registration *DataPlaneRegistration, counter uint64) *DataPlaneRegistrationRequest {
+	return &DataPlaneRegistrationRequest{
+		Identity: registration.Identity,
+		Counter:  counter,
+		Mac:      dataPlaneRegistrationMac(registration.Token, registration.Identity, counter),
+	}
+}
+
+// dataPlaneRegistrationMac is the HMAC-SHA-256 of the identity and counter keyed with the token
+func dataPlaneRegistrationMac(token []byte, identity string, counter uint64) []byte {
+	mac := hmac.New(sha256.New, token)
+	mac.Write([]byte(identity))
+	mac.Write(binary.BigEndian.AppendUint64(nil, counter))
+	return mac.Sum(nil)
+}
+
+func (r *DataPlaneRegistrationRequest) Marshal() ([]byte, error) {
+	if len(r.Identity) > 0xff {
+		return nil, fmt.Errorf("identity too long: %d", len(r.Identity))
+	}
+	if len(r.Mac) != sha256.Size {
+		return nil, fmt.Errorf("invalid mac length: %d", len(r.Mac))
+	}
+
+	buffer := make([]byte, 0, 2+len(r.Identity)+8+sha256.Size)
+	buffer = append(buffer, constant.UE_DATA_PLANE_REGISTRATION, uint8(len(r.Identity)))
+	buffer = append(buffer, r.Identity...)
+	buffer = binary.BigEndian.AppendUint64(buffer, r.Counter)
+	buffer = append(buffer, r.Mac...)
+
+	return buffer, nil
+}
+
+func (r *DataPlaneRegistrationRequest) Unmarshal(data []byte) error {
+	if len(data) < 2 {
+		return errors.New("data too short")
+	}
+	if data[0] != constant.UE_DATA_PLANE_REGISTRATION {
+		return fmt.Errorf("unexpected message type: %d, expected %d", data[0], constant.UE_DATA_PLANE_REGISTRATION)
+	}
+
+	identityLength := int(data[1])
+	data = data[2:]
+	if len(data) != identityLength+8+sha256.Size {
+		return fmt.Errorf("invalid length: %d, expected %d", len(data), identityLength+8+sha256.Size)
+	}
+	r.Identity = string(data[:identityLength])
+	r.Counter = binary.BigEndian.Uint64(data[identityLength:])
+	r.Mac = append([]byte{}, data[identityLength+8:]...)
+
+	return nil
+}
+
+// Verify checks the request against the identity and token issued by the RAN, the counter is checked by the RAN
+// against the last accepted one
+func (r *DataPlaneRegistrationRequest) Verify(identity string, token []byte) bool {
+	identityMatch := subtle.ConstantTimeCompare([]byte(r.Identity), []byte(identity)) == 1
+	return identityMatch && hmac.Equal(r.Mac, dataPlaneRegistrationMac(token, identity, r.Counter))
+}
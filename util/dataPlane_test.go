@@ -0,0 +1,136 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/protocol"
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/nas/security"
+	"github.com/stretchr/testify/assert"
+)
+
+var testDataPlaneRegistrationCases = []struct {
+	name         string
+	messageType  uint8
+	registration util.DataPlaneRegistration
+	expected     []byte
+}{
+	{
+		name:        "testDataPlaneToken",
+		messageType: constant.UE_DATA_PLANE_TOKEN,
+		registration: util.DataPlaneRegistration{
+			Identity: "imsi-208930000000001",
+			Token:    []byte{0xff},
+		},
+		expected: append(append([]byte{constant.UE_DATA_PLANE_TOKEN, 20}, "imsi-208930000000001"...), 1, 0xff),
+	},
+}
+
+func TestDataPlaneRegistration(t *testing.T) {
+	for _, testCase := range testDataPlaneRegistrationCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			data, err := testCase.registration.Marshal(testCase.messageType)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, data)
+
+			registration := util.DataPlaneRegistration{}
+			assert.NoError(t, registration.Unmarshal(testCase.messageType, data))
+			assert.Equal(t, testCase.registration, registration)
+			assert.Error(t, registration.Unmarshal(testCase.messageType, data[:len(data)-1]))
+			assert.Error(t, registration.Unmarshal(testCase.messageType+1, data))
+		})
+	}
+}
+
+var testDataPlaneRegistrationRequestCases = []struct {
+	name         string
+	registration util.DataPlaneRegistration
+	counter      uint64
+}{
+	{
+		name: "testDataPlaneRegistrationRequest",
+		registration: util.DataPlaneRegistration{
+			Identity: "imsi-208930000000001",
+			Token:    []byte{0x01, 0x02, 0x03, 0x04},
+		},
+		counter: 1,
+	},
+	{
+		name: "testDataPlaneRegistrationRequestLargeCounter",
+		registration: util.DataPlaneRegistration{
+			Identity: "imsi-208930000000001",
+			Token:    []byte{0xff},
+		},
+		counter: 0x0102030405060708,
+	},
+}
+
+func TestDataPlaneRegistrationRequest(t *testing.T) {
+	for _, testCase := range testDataPlaneRegistrationRequestCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			data, err := util.NewDataPlaneRegistrationRequest(&testCase.registration, testCase.counter).Marshal()
+			assert.NoError(t, err)
+			assert.True(t, util.IsDataPlaneRegistration(data))
+			// the token is never sent over the data plane
+			assert.NotContains(t, string(data), string(testCase.registration.Token))
+
+			request := util.DataPlaneRegistrationRequest{}
+			assert.NoError(t, request.Unmarshal(data))
+			assert.Equal(t, testCase.registration.Identity, request.Identity)
+			assert.Equal(t, testCase.counter, request.Counter)
+			assert.True(t, request.Verify(testCase.registration.Identity, testCase.registration.Token))
+			assert.False(t, request.Verify(testCase.registration.Identity, []byte{0x00}))
+			assert.False(t, request.Verify("imsi-208930000000002", testCase.registration.Token))
+
+			// a request whose counter is changed does not verify
+			request.Counter++
+			assert.False(t, request.Verify(testCase.registration.Identity, testCase.registration.Token))
+
+			assert.Error(t, request.Unmarshal(data[:len(data)-1]))
+			assert.Error(t, request.Unmarshal(append([]byte{constant.UE_DATA_PLANE_TOKEN}, data[1:]...)))
+		})
+	}
+}
+
+func TestIsDataPlaneRegistration(t *testing.T) {
+	assert.True(t, util.IsDataPlaneRegistration([]byte{constant.UE_DATA_PLANE_REGISTRATION, 0, 0}))
+	assert.False(t, util.IsDataPlaneRegistration([]byte{0x45, 0x00}))
+	assert.False(t, util.IsDataPlaneRegistration([]byte{0x60, 0x00}))
+	assert.False(t, util.IsDataPlaneRegistration([]byte{}))
+}
+
+func TestNewDataPlaneToken(t *testing.T) {
+	token, err := util.NewDataPlaneToken()
+	assert.NoError(t, err)
+	assert.Len(t, token, constant.UE_DATA_PLANE_TOKEN_LENGTH)
+
+	another, err := util.NewDataPlaneToken()
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, another)
+}
+
+func TestProtectDataPlanePacket(t *testing.T) {
+	kGnb, err := protocol.DeriveKgnb(make([]byte, 32), 0)
+	assert.NoError(t, err)
+	ueContext, err := protocol.NewAsSecurityContext(kGnb, security.AlgCiphering128NEA2, security.AlgIntegrity128NIA2, security.DirectionUplink)
+	assert.NoError(t, err)
+	ranContext, err := protocol.NewAsSecurityContext(kGnb, security.AlgCiphering128NEA2, security.AlgIntegrity128NIA2, security.DirectionDownlink)
+	assert.NoError(t, err)
+
+	packet := []byte{0x45, 0x00, 0x00, 0x1c, 0x00, 0x01, 0x00, 0x00}
+	protected, err := util.ProtectDataPlanePacket(ueContext.Drb, 5, packet)
+	assert.NoError(t, err)
+	assert.Equal(t, constant.UE_DATA_PLANE_PDU, protected[0])
+	assert.False(t, util.IsDataPlaneRegistration(protected))
+
+	pduSessionId, unprotected, err := util.UnprotectDataPlanePacket(ranContext.Drb, protected)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(5), pduSessionId)
+	assert.Equal(t, packet, unprotected)
+
+	_, _, err = util.UnprotectDataPlanePacket(ranContext.Drb, packet)
+	assert.Error(t, err)
+	_, err = util.ProtectDataPlanePacket(nil, 5, packet)
+	assert.Error(t, err)
+}
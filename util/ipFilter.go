@@ -9,17 +9,23 @@ func IsIpInSpecifiedFlow(rawPacket []byte, specifiedFlow []string) bool {
 		return false
 	}
 
-	version := rawPacket[0] >> 4
-	if version != 4 {
-		return false
-	}
-
-	headerLength := int(rawPacket[0]&0x0F) * 4
-	if len(rawPacket) < headerLength || headerLength < 20 {
+	var destIP net.IP
+	switch rawPacket[0] >> 4 {
+	case 4:
+		headerLength := int(rawPacket[0]&0x0F) * 4
+		if len(rawPacket) < headerLength || headerLength < 20 {
+			return false
+		}
+		destIP = net.IPv4(rawPacket[16], rawPacket[17], rawPacket[18], rawPacket[19])
+	case 6:
+		if len(rawPacket) < 40 {
+			return false
+		}
+		destIP = net.IP(rawPacket[24:40])
+	default:
 		return false
 	}
 
-	destIP := net.IPv4(rawPacket[16], rawPacket[17], rawPacket[18], rawPacket[19])
 	for _, cidr := range specifiedFlow {
 		if cidr == "" {
 			continue
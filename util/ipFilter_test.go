@@ -46,6 +46,30 @@ var testCases = []struct {
 		specifiedFlow:  []string{"1.1.1.1/32"},
 		expected: false,
 	},
+	{
+		name: "ipv6 in qos flow",
+		rawPacket: []byte{
+			0x60, 0x00, 0x00, 0x00, 0x00, 0x08, 0x11, 0x40,
+			0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+			0x20, 0x01, 0x48, 0x60, 0x48, 0x60, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x88, 0x88,
+		},
+		specifiedFlow:  []string{"1.1.1.1/32", "2001:4860::/32"},
+		expected: true,
+	},
+	{
+		name: "ipv6 not in qos flow",
+		rawPacket: []byte{
+			0x60, 0x00, 0x00, 0x00, 0x00, 0x08, 0x11, 0x40,
+			0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+			0x20, 0x01, 0x48, 0x60, 0x48, 0x60, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x88, 0x88,
+		},
+		specifiedFlow:  []string{"2001:db8::/32"},
+		expected: false,
+	},
 }
 
 func TestIsIpInQosFlow(t *testing.T) {
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	ipv4HeaderLength = 20
+
+	ipv4ProtocolIcmp = 1
+	ipv4DefaultTtl   = 64
+
+	icmpTypeEchoRequest = 8
+
+	// the echo request carries the identifier and sequence number after type, code and checksum, then its data
+	icmpEchoHeaderLength = 8
+	icmpEchoDataLength   = 32
+)
+
+// BuildIcmpEchoRequest builds the ICMP echo request from source to destination as in RFC 792,
+// its echo reply carries the same identifier and sequence number
+func BuildIcmpEchoRequest(source, destination net.IP, identifier, sequenceNumber uint16) []byte {
+	packet := make([]byte, ipv4HeaderLength+icmpEchoHeaderLength+icmpEchoDataLength)
+
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	binary.BigEndian.PutUint16(packet[4:6], sequenceNumber)
+	packet[8] = ipv4DefaultTtl
+	packet[9] = ipv4ProtocolIcmp
+	copy(packet[12:16], source.To4())
+	copy(packet[16:20], destination.To4())
+	binary.BigEndian.PutUint16(packet[10:12], internetChecksum(packet[:ipv4HeaderLength]))
+
+	icmp := packet[ipv4HeaderLength:]
+	icmp[0] = icmpTypeEchoRequest
+	binary.BigEndian.PutUint16(icmp[4:6], identifier)
+	binary.BigEndian.PutUint16(icmp[6:8], sequenceNumber)
+	for i := range icmpEchoDataLength {
+		icmp[icmpEchoHeaderLength+i] = byte(i)
+	}
+	binary.BigEndian.PutUint16(icmp[2:4], internetChecksum(icmp))
+
+	return packet
+}
+
+// internetChecksum is the one's complement of the one's complement sum of the 16 bits words of the data as in RFC 1071
+func internetChecksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
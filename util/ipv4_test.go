@@ -0,0 +1,29 @@
+package util_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildIcmpEchoRequest(t *testing.T) {
+	echoRequest := util.BuildIcmpEchoRequest(net.ParseIP("10.60.0.1"), net.ParseIP("10.60.0.254"), 0x1234, 1)
+
+	assert.Equal(t, 60, len(echoRequest))
+	assert.Equal(t, uint8(0x45), echoRequest[0])
+	assert.Equal(t, uint16(60), binary.BigEndian.Uint16(echoRequest[2:4]))
+	assert.Equal(t, uint8(1), echoRequest[9])
+	assert.Equal(t, net.ParseIP("10.60.0.1").To4(), net.IP(echoRequest[12:16]))
+	assert.Equal(t, net.ParseIP("10.60.0.254").To4(), net.IP(echoRequest[16:20]))
+	// 10.60.0.1 to 10.60.0.254 with total length 60, identification 1 and TTL 64
+	assert.Equal(t, uint16(0x654a), binary.BigEndian.Uint16(echoRequest[10:12]))
+
+	assert.Equal(t, uint8(8), echoRequest[20])
+	assert.Equal(t, uint16(0x1234), binary.BigEndian.Uint16(echoRequest[24:26]))
+	assert.Equal(t, uint16(1), binary.BigEndian.Uint16(echoRequest[26:28]))
+	// type 8 with identifier 0x1234, sequence number 1 and data 0x00 to 0x1f
+	assert.Equal(t, uint16(0xf4c9), binary.BigEndian.Uint16(echoRequest[22:24]))
+}
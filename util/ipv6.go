@@ -0,0 +1,105 @@
+package util
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	ipv6HeaderLength = 40
+
+	ipv6NextHeaderIcmpv6 = 58
+
+	icmpv6TypeRouterSolicitation  = 133
+	icmpv6TypeRouterAdvertisement = 134
+
+	// the router advertisement options follow type, code, checksum, hop limit, flags, router lifetime, reachable time and retrans timer
+	routerAdvertisementOptionsOffset = 16
+
+	ndpOptionTypePrefixInformation   = 3
+	ndpOptionPrefixInformationLength = 32
+)
+
+// Ipv6LinkLocalAddress is the fe80::/64 address of the interface identifier given in the PDU address of an IPv6 PDU session
+func Ipv6LinkLocalAddress(interfaceIdentifier [8]byte) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	ip[0], ip[1] = 0xfe, 0x80
+	copy(ip[8:], interfaceIdentifier[:])
+	return ip
+}
+
+// Ipv6GlobalAddress is the address of the interface identifier in the /64 prefix advertised for an IPv6 PDU session
+func Ipv6GlobalAddress(prefix net.IP, interfaceIdentifier [8]byte) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip[:8], prefix.To16()[:8])
+	copy(ip[8:], interfaceIdentifier[:])
+	return ip
+}
+
+// BuildRouterSolicitation builds the ICMPv6 router solicitation from the link local address to all routers as in RFC 4861 4.1
+func BuildRouterSolicitation(source net.IP) []byte {
+	icmpv6 := make([]byte, 8)
+	icmpv6[0] = icmpv6TypeRouterSolicitation
+
+	packet := make([]byte, ipv6HeaderLength+len(icmpv6))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(icmpv6)))
+	packet[6] = ipv6NextHeaderIcmpv6
+	packet[7] = 255
+	copy(packet[8:24], source.To16())
+	copy(packet[24:40], net.ParseIP("ff02::2").To16())
+	copy(packet[ipv6HeaderLength:], icmpv6)
+
+	binary.BigEndian.PutUint16(packet[ipv6HeaderLength+2:ipv6HeaderLength+4], icmpv6Checksum(packet))
+	return packet
+}
+
+// ParseRouterAdvertisementPrefix returns the prefix of the first prefix information option
+// if the packet is an ICMPv6 router advertisement as in RFC 4861 4.2
+func ParseRouterAdvertisementPrefix(packet []byte) (net.IP, int, bool) {
+	if len(packet) < ipv6HeaderLength+routerAdvertisementOptionsOffset || packet[0]>>4 != 6 {
+		return nil, 0, false
+	}
+	if packet[6] != ipv6NextHeaderIcmpv6 || packet[ipv6HeaderLength] != icmpv6TypeRouterAdvertisement {
+		return nil, 0, false
+	}
+
+	options := packet[ipv6HeaderLength+routerAdvertisementOptionsOffset:]
+	for len(options) >= 2 {
+		optionLength := int(options[1]) * 8
+		if optionLength == 0 || optionLength > len(options) {
+			return nil, 0, false
+		}
+		if options[0] == ndpOptionTypePrefixInformation && optionLength == ndpOptionPrefixInformationLength {
+			prefix := make(net.IP, net.IPv6len)
+			copy(prefix, options[16:32])
+			return prefix, int(options[2]), true
+		}
+		options = options[optionLength:]
+	}
+	return nil, 0, false
+}
+
+// icmpv6Checksum computes the checksum of the ICMPv6 message of the IPv6 packet with the pseudo header of RFC 8200 8.1
+func icmpv6Checksum(packet []byte) uint16 {
+	icmpv6 := packet[ipv6HeaderLength:]
+
+	var sum uint32
+	for i := 8; i < ipv6HeaderLength; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(packet[i : i+2]))
+	}
+	sum += uint32(len(icmpv6))
+	sum += ipv6NextHeaderIcmpv6
+
+	for i := 0; i+1 < len(icmpv6); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(icmpv6[i : i+2]))
+	}
+	if len(icmpv6)%2 == 1 {
+		sum += uint32(icmpv6[len(icmpv6)-1]) << 8
+	}
+
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
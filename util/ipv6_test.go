@@ -0,0 +1,100 @@
+package util_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/stretchr/testify/assert"
+)
+
+var testInterfaceIdentifier = [8]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+
+func TestIpv6Address(t *testing.T) {
+	assert.Equal(t, "fe80::1", util.Ipv6LinkLocalAddress(testInterfaceIdentifier).String())
+	assert.Equal(t, "2001:db8:1:2::1", util.Ipv6GlobalAddress(net.ParseIP("2001:db8:1:2::"), testInterfaceIdentifier).String())
+}
+
+func TestBuildRouterSolicitation(t *testing.T) {
+	routerSolicitation := util.BuildRouterSolicitation(util.Ipv6LinkLocalAddress(testInterfaceIdentifier))
+
+	assert.Equal(t, 48, len(routerSolicitation))
+	assert.Equal(t, uint8(0x60), routerSolicitation[0])
+	assert.Equal(t, uint8(58), routerSolicitation[6])
+	assert.Equal(t, net.ParseIP("ff02::2"), net.IP(routerSolicitation[24:40]))
+	assert.Equal(t, uint8(133), routerSolicitation[40])
+	// fe80::1 to ff02::2 with ICMPv6 length 8 and type 133
+	assert.Equal(t, uint16(0x7d36), binary.BigEndian.Uint16(routerSolicitation[42:44]))
+}
+
+func buildTestRouterAdvertisement(options []byte) []byte {
+	packet := make([]byte, 40+16+len(options))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:6], uint16(16+len(options)))
+	packet[6] = 58
+	packet[7] = 255
+	copy(packet[8:24], net.ParseIP("fe80::2"))
+	copy(packet[24:40], net.ParseIP("ff02::1"))
+	packet[40] = 134
+	copy(packet[56:], options)
+	return packet
+}
+
+var testPrefixInformationOption = append([]byte{
+	0x03, 0x04, 0x40, 0xc0,
+	0xff, 0xff, 0xff, 0xff,
+	0xff, 0xff, 0xff, 0xff,
+	0x00, 0x00, 0x00, 0x00,
+}, net.ParseIP("2001:db8:1:2::")...)
+
+var testParseRouterAdvertisementPrefixCases = []struct {
+	name              string
+	packet            []byte
+	expectedPrefix    net.IP
+	expectedPrefixLen int
+	expectedOk        bool
+}{
+	{
+		name:              "testPrefixInformation",
+		packet:            buildTestRouterAdvertisement(testPrefixInformationOption),
+		expectedPrefix:    net.ParseIP("2001:db8:1:2::"),
+		expectedPrefixLen: 64,
+		expectedOk:        true,
+	},
+	{
+		name:              "testPrefixInformationAfterMtu",
+		packet:            buildTestRouterAdvertisement(append([]byte{0x05, 0x01, 0x00, 0x00, 0x00, 0x00, 0x05, 0xdc}, testPrefixInformationOption...)),
+		expectedPrefix:    net.ParseIP("2001:db8:1:2::"),
+		expectedPrefixLen: 64,
+		expectedOk:        true,
+	},
+	{
+		name:       "testNoPrefixInformation",
+		packet:     buildTestRouterAdvertisement(nil),
+		expectedOk: false,
+	},
+	{
+		name:       "testRouterSolicitation",
+		packet:     util.BuildRouterSolicitation(net.ParseIP("fe80::1")),
+		expectedOk: false,
+	},
+	{
+		name:       "testIpv4",
+		packet:     []byte{0x45, 0x00, 0x00, 0x14, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00, 0x7f, 0x00, 0x00, 0x01, 0x01, 0x01, 0x01, 0x01},
+		expectedOk: false,
+	},
+}
+
+func TestParseRouterAdvertisementPrefix(t *testing.T) {
+	for _, testCase := range testParseRouterAdvertisementPrefixCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			prefix, prefixLen, ok := util.ParseRouterAdvertisementPrefix(testCase.packet)
+			assert.Equal(t, testCase.expectedOk, ok)
+			if testCase.expectedOk {
+				assert.Equal(t, testCase.expectedPrefix, prefix)
+				assert.Equal(t, testCase.expectedPrefixLen, prefixLen)
+			}
+		})
+	}
+}
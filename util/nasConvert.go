@@ -1,93 +1,216 @@
 package util
 
-import "strconv"
-
-func encodeMcc(mcc string) []byte {
-	// MCC "208" -> digits: 2, 0, 8
-	d1, _ := strconv.Atoi(string(mcc[0])) // 2
-	d2, _ := strconv.Atoi(string(mcc[1])) // 0
-	d3, _ := strconv.Atoi(string(mcc[2])) // 8
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/free5gc/nas/nasConvert"
+	"github.com/free5gc/openapi/models"
+)
+
+// encodePlmnId packs the MCC and the 2 or 3 digit MNC into 3 octets as TS 24.501 9.11.3.4,
+// the third MNC digit takes the high nibble of the second octet, filled with f for a 2 digit MNC
+func encodePlmnId(mcc, mnc string) []byte {
+	mnc3 := byte(0x0f)
+	if len(mnc) == 3 {
+		mnc3 = mnc[2] - '0'
+	}
 
-	// according to 3GPP
-	// Byte 1: (MCC[1] << 4) | MCC[0] = (0 << 4) | 2 = 0x02
-	// Byte 2: (f << 4) | MCC[2] = (15 << 4) | 8 = 0xf8
-	byte1 := uint8((d2 << 4) | d1)
-	byte2 := uint8((0xf << 4) | d3) // f is padding
+	return []byte{
+		(mcc[1]-'0')<<4 | (mcc[0] - '0'),
+		mnc3<<4 | (mcc[2] - '0'),
+		(mnc[1]-'0')<<4 | (mnc[0] - '0'),
+	}
+}
 
-	return []byte{byte1, byte2}
+// encodeBcd packs the digits two per octet with the first digit in the low nibble, an odd digit count is filled with f
+func encodeBcd(digits string) []byte {
+	return encodeBcdWithLength(digits, (len(digits)+1)/2)
 }
 
-func encodeMnc(mnc string) []byte {
-	// MNC "93" -> digits: 9, 3
-	d1, _ := strconv.Atoi(string(mnc[0])) // 9
-	d2, _ := strconv.Atoi(string(mnc[1])) // 3
+// encodeBcdWithLength packs the digits as encodeBcd into the given number of octets, filling the unused nibbles with f
+func encodeBcdWithLength(digits string, length int) []byte {
+	result := make([]byte, length)
+	for i := range result {
+		result[i] = 0xff
+	}
 
-	// Byte 3: (MNC[1] << 4) | MNC[0] = (3 << 4) | 9 = 0x39
-	byte3 := uint8((d2 << 4) | d1)
+	for i := 0; i < len(digits) && i < 2*length; i++ {
+		digit := digits[i] - '0'
+		if i%2 == 0 {
+			result[i/2] = 0xf0 | digit
+		} else {
+			result[i/2] = result[i/2]&0x0f | digit<<4
+		}
+	}
 
-	return []byte{byte3}
+	return result
 }
 
-func encodeMsin(msin string) []byte {
-	// always take 12 digits
-	if len(msin) < 14 {
-		for i := len(msin); i < 14; i++ {
-			msin = "0" + msin
+// ServingNetworkName is the serving network name of TS 24.501 9.12.1 for the key derivation, the MNC is padded to 3 digits
+func ServingNetworkName(mcc, mnc string) string {
+	return fmt.Sprintf("5G:mnc%s.mcc%s.3gppnetwork.org", strings.Repeat("0", 3-len(mnc))+mnc, mcc)
+}
+
+// TaiListToModels decodes the partial tracking area identity lists of the 5GS tracking area identity list of TS 24.501 9.11.3.9,
+// a list of type 00 shares the PLMN among its TACs, type 01 is consecutive TACs from the first one and type 10 pairs each TAC with its PLMN
+func TaiListToModels(buf []byte) ([]models.Tai, error) {
+	taiList := make([]models.Tai, 0)
+	for offset := 0; offset < len(buf); {
+		typeOfList, numberOfElements := (buf[offset]>>5)&0x03, int(buf[offset]&0x1f)+1
+		offset++
+
+		switch typeOfList {
+		case 0x00:
+			if offset+3+3*numberOfElements > len(buf) {
+				return nil, fmt.Errorf("partial tai list of type %d is too short: %d bytes", typeOfList, len(buf)-offset)
+			}
+			plmnId := plmnIdToModels(buf[offset : offset+3])
+			offset += 3
+			for i := 0; i < numberOfElements; i++ {
+				taiList = append(taiList, models.Tai{PlmnId: &plmnId, Tac: hex.EncodeToString(buf[offset : offset+3])})
+				offset += 3
+			}
+		case 0x01:
+			if offset+6 > len(buf) {
+				return nil, fmt.Errorf("partial tai list of type %d is too short: %d bytes", typeOfList, len(buf)-offset)
+			}
+			plmnId := plmnIdToModels(buf[offset : offset+3])
+			tac := uint32(buf[offset+3])<<16 | uint32(buf[offset+4])<<8 | uint32(buf[offset+5])
+			for i := 0; i < numberOfElements; i++ {
+				taiList = append(taiList, models.Tai{PlmnId: &plmnId, Tac: fmt.Sprintf("%06x", tac+uint32(i))})
+			}
+			offset += 6
+		case 0x02:
+			if offset+6*numberOfElements > len(buf) {
+				return nil, fmt.Errorf("partial tai list of type %d is too short: %d bytes", typeOfList, len(buf)-offset)
+			}
+			for i := 0; i < numberOfElements; i++ {
+				plmnId := plmnIdToModels(buf[offset : offset+3])
+				taiList = append(taiList, models.Tai{PlmnId: &plmnId, Tac: hex.EncodeToString(buf[offset+3 : offset+6])})
+				offset += 6
+			}
+		default:
+			return nil, fmt.Errorf("invalid type %d of partial tai list", typeOfList)
 		}
 	}
 
-	result := make([]byte, len(msin)/2)
+	return taiList, nil
+}
 
-	for i := 0; i < len(msin); i += 2 {
-		var d1, d2 uint8
+// plmnIdToModels decodes the PLMN of 3 octets laid out as encodePlmnId
+func plmnIdToModels(buf []byte) models.PlmnId {
+	plmnId := nasConvert.PlmnIDToString(buf)
+	return models.PlmnId{
+		Mcc: plmnId[:3],
+		Mnc: plmnId[3:],
+	}
+}
 
-		tmpD1, err := strconv.Atoi(string(msin[i]))
-		if err != nil {
-			panic(err)
+// NssaiToModels decodes the S-NSSAIs of an NSSAI as TS 24.501 9.11.3.37, e.g. the allowed NSSAI, the mapped HPLMN S-NSSAI is left out
+func NssaiToModels(buf []byte) ([]models.Snssai, error) {
+	nssai := make([]models.Snssai, 0)
+	for offset := 0; offset < len(buf); {
+		length := int(buf[offset])
+		if offset+1+length > len(buf) {
+			return nil, fmt.Errorf("s-nssai of %d bytes is too short: %d bytes", length, len(buf)-offset-1)
 		}
-		d1 = uint8(tmpD1)
-
-		tmpD2, err := strconv.Atoi(string(msin[i+1]))
-		if err != nil {
-			panic(err)
+		contents := buf[offset+1 : offset+1+length]
+
+		switch length {
+		case 1, 2:
+			nssai = append(nssai, models.Snssai{Sst: int32(contents[0])})
+		case 4, 5, 8:
+			nssai = append(nssai, models.Snssai{Sst: int32(contents[0]), Sd: hex.EncodeToString(contents[1:4])})
+		default:
+			return nil, fmt.Errorf("invalid s-nssai length: %d", length)
 		}
-		d2 = uint8(tmpD2)
-
-		result[i/2] = (d2 << 4) | d1
+		offset += 1 + length
 	}
 
-	return result
+	return nssai, nil
 }
 
-func SupiToBytes(supi string) []byte {
-	var mcc, mnc, msin string
-
-	mcc = supi[0:3]
-	mnc = supi[3:5]
-	msin = supi[5:]
-
-	mccBytes := encodeMcc(mcc)
-	mncBytes := encodeMnc(mnc)
-	msinBytes := encodeMsin(msin)
-
-	buffer := make([]byte, 0)
-
-	// Byte 0: SUPI Type (0x01 = IMSI)
-	buffer = append(buffer, 0x01)
+// RejectedSnssai is an S-NSSAI of a rejected NSSAI with the cause the network rejects it with
+type RejectedSnssai struct {
+	Snssai models.Snssai
+	Cause  uint8
+}
 
-	// Bytes 1-3: MCC + MNC 編碼
-	buffer = append(buffer, mccBytes...)
-	buffer = append(buffer, mncBytes...)
+// RejectedNssaiToModels decodes the rejected S-NSSAIs of a rejected NSSAI as TS 24.501 9.11.3.46,
+// each of them led by the length of its contents in the high nibble and the cause in the low nibble
+func RejectedNssaiToModels(buf []byte) ([]RejectedSnssai, error) {
+	rejectedNssai := make([]RejectedSnssai, 0)
+	for offset := 0; offset < len(buf); {
+		length, cause := int(buf[offset]>>4), buf[offset]&0x0f
+		if offset+1+length > len(buf) {
+			return nil, fmt.Errorf("rejected s-nssai of %d bytes is too short: %d bytes", length, len(buf)-offset-1)
+		}
+		contents := buf[offset+1 : offset+1+length]
+
+		switch length {
+		case 1:
+			rejectedNssai = append(rejectedNssai, RejectedSnssai{Snssai: models.Snssai{Sst: int32(contents[0])}, Cause: cause})
+		case 4:
+			rejectedNssai = append(rejectedNssai, RejectedSnssai{Snssai: models.Snssai{Sst: int32(contents[0]), Sd: hex.EncodeToString(contents[1:4])}, Cause: cause})
+		default:
+			return nil, fmt.Errorf("invalid rejected s-nssai length: %d", length)
+		}
+		offset += 1 + length
+	}
 
-	// Byte 4: Routing Indicator (0xf0)
-	buffer = append(buffer, 0xf0)
+	return rejectedNssai, nil
+}
 
-	// Byte 5: Protection Scheme ID (f) + Home Network PKI (f)
-	// use null protection scheme
-	buffer = append(buffer, 0xff)
+// NssaiToNas encodes the S-NSSAIs as the value of an NSSAI of TS 24.501 9.11.3.37, e.g. the requested NSSAI
+func NssaiToNas(nssai []models.Snssai) []byte {
+	buf := make([]byte, 0)
+	for _, snssai := range nssai {
+		buf = append(buf, nasConvert.SnssaiToNas(snssai)...)
+	}
+	return buf
+}
 
-	// Add MSIN BCD encoded
-	buffer = append(buffer, msinBytes...)
+// GprsTimerUnitDeactivated is the unit of a deactivated GPRS timer 2 or GPRS timer 3
+const GprsTimerUnitDeactivated uint8 = 0x07
+
+// GprsTimer2ToDuration is the value of a GPRS timer 2 of TS 24.008 10.5.7.4, e.g. T3346, a deactivated timer is 0
+// and an undefined unit counts in minutes as the spec says
+func GprsTimer2ToDuration(gprsTimer2Value uint8) time.Duration {
+	value := time.Duration(gprsTimer2Value & 0x1f)
+	switch gprsTimer2Value >> 5 {
+	case 0x00:
+		return value * 2 * time.Second
+	case 0x02:
+		return value * 6 * time.Minute
+	case GprsTimerUnitDeactivated:
+		return 0
+	default:
+		return value * time.Minute
+	}
+}
 
-	return buffer
+// GprsTimer3ToDuration is the value of a GPRS timer 3 of TS 24.008 10.5.7.4a, e.g. T3512, a deactivated timer is 0
+func GprsTimer3ToDuration(unit, timerValue uint8) time.Duration {
+	value := time.Duration(timerValue & 0x1f)
+	switch unit {
+	case 0x00:
+		return value * 10 * time.Minute
+	case 0x01:
+		return value * time.Hour
+	case 0x02:
+		return value * 10 * time.Hour
+	case 0x03:
+		return value * 2 * time.Second
+	case 0x04:
+		return value * 30 * time.Second
+	case 0x05:
+		return value * time.Minute
+	case 0x06:
+		return value * 320 * time.Hour
+	default:
+		return 0
+	}
 }
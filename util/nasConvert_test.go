@@ -1,38 +1,349 @@
 package util_test
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/free5gc/nas/nasConvert"
+	"github.com/free5gc/openapi/models"
 	"github.com/go-playground/assert/v2"
 )
 
 var testSupiCases = []struct {
 	name           string
-	supi           string
+	mcc            string
+	mnc            string
+	msin           string
 	expectedLength int
 	expectedBytes  []byte
 }{
 	{
 		name:           "imsi-208930000007487",
-		supi:           "208930000007487",
+		mcc:            "208",
+		mnc:            "93",
+		msin:           "0000007487",
 		expectedLength: 13,
 		expectedBytes:  []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x47, 0x78},
 	},
 	{
 		name:           "imsi-208930000000001",
-		supi:           "208930000000001",
+		mcc:            "208",
+		mnc:            "93",
+		msin:           "0000000001",
 		expectedLength: 13,
 		expectedBytes:  []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10},
 	},
+	{
+		name:           "imsi-310410000000001",
+		mcc:            "310",
+		mnc:            "410",
+		msin:           "000000001",
+		expectedLength: 13,
+		expectedBytes:  []byte{0x01, 0x13, 0x00, 0x14, 0xf0, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf1},
+	},
 }
 
-func TestSupiToBytes(t *testing.T) {
+func TestSupiToNullSchemeSuci(t *testing.T) {
 	for _, testCase := range testSupiCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			result := util.SupiToBytes(testCase.supi)
+			result, err := util.SupiToSuci(testCase.mcc, testCase.mnc, testCase.msin, &util.SuciProfile{})
+			assert.Equal(t, nil, err)
 			assert.Equal(t, testCase.expectedLength, len(result))
 			assert.Equal(t, testCase.expectedBytes, result)
 		})
 	}
 }
+
+var testServingNetworkNameCases = []struct {
+	name           string
+	mcc            string
+	mnc            string
+	expectedSnName string
+}{
+	{
+		name:           "test2DigitMnc",
+		mcc:            "208",
+		mnc:            "93",
+		expectedSnName: "5G:mnc093.mcc208.3gppnetwork.org",
+	},
+	{
+		name:           "test2DigitMncWithLeadingZero",
+		mcc:            "001",
+		mnc:            "01",
+		expectedSnName: "5G:mnc001.mcc001.3gppnetwork.org",
+	},
+	{
+		name:           "test3DigitMnc",
+		mcc:            "310",
+		mnc:            "410",
+		expectedSnName: "5G:mnc410.mcc310.3gppnetwork.org",
+	},
+}
+
+func TestServingNetworkName(t *testing.T) {
+	for _, testCase := range testServingNetworkNameCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expectedSnName, util.ServingNetworkName(testCase.mcc, testCase.mnc))
+		})
+	}
+}
+
+var testPlmnIdNasNgapCases = []struct {
+	name   string
+	plmnId models.PlmnId
+	msin   string
+}{
+	{
+		name:   "test2DigitMnc",
+		plmnId: models.PlmnId{Mcc: "208", Mnc: "93"},
+		msin:   "0000000001",
+	},
+	{
+		name:   "test3DigitMnc",
+		plmnId: models.PlmnId{Mcc: "310", Mnc: "410"},
+		msin:   "000000001",
+	},
+}
+
+// the PLMN of the SUCI in NAS and the PLMN of the gNB in NGAP are laid out differently for a 3 digit MNC,
+// but the AMF must decode the same MCC and MNC from both
+func TestPlmnIdNasNgap(t *testing.T) {
+	for _, testCase := range testPlmnIdNasNgapCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			suci, err := util.SupiToSuci(testCase.plmnId.Mcc, testCase.plmnId.Mnc, testCase.msin, &util.SuciProfile{})
+			assert.Equal(t, nil, err)
+			nasPlmnId := suci[1:4]
+			assert.Equal(t, nasConvert.PlmnIDToNas(testCase.plmnId), nasPlmnId)
+			assert.Equal(t, testCase.plmnId.Mcc+testCase.plmnId.Mnc, nasConvert.PlmnIDToString(nasPlmnId))
+
+			ngapPlmnId, err := util.PlmnIdToNgap(testCase.plmnId)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, testCase.plmnId, util.PlmnIdToModels(ngapPlmnId))
+		})
+	}
+}
+
+var testTaiListToModelsCases = []struct {
+	name          string
+	buf           []byte
+	expectedError error
+	expected      []models.Tai
+}{
+	{
+		name:     "testTaiListOfSharedPlmn",
+		buf:      []byte{0x01, 0x02, 0xf8, 0x39, 0x00, 0x00, 0x01, 0x00, 0x00, 0x02},
+		expected: []models.Tai{{PlmnId: &models.PlmnId{Mcc: "208", Mnc: "93"}, Tac: "000001"}, {PlmnId: &models.PlmnId{Mcc: "208", Mnc: "93"}, Tac: "000002"}},
+	},
+	{
+		name:     "testTaiListOfConsecutiveTacs",
+		buf:      []byte{0x22, 0x13, 0x00, 0x14, 0x00, 0x00, 0x0a},
+		expected: []models.Tai{{PlmnId: &models.PlmnId{Mcc: "310", Mnc: "410"}, Tac: "00000a"}, {PlmnId: &models.PlmnId{Mcc: "310", Mnc: "410"}, Tac: "00000b"}, {PlmnId: &models.PlmnId{Mcc: "310", Mnc: "410"}, Tac: "00000c"}},
+	},
+	{
+		name:     "testTaiListOfPlmnPerTacAndSharedPlmn",
+		buf:      []byte{0x41, 0x02, 0xf8, 0x39, 0x00, 0x00, 0x01, 0x13, 0x00, 0x14, 0x00, 0x00, 0x02, 0x00, 0x02, 0xf8, 0x39, 0x00, 0x00, 0x03},
+		expected: []models.Tai{{PlmnId: &models.PlmnId{Mcc: "208", Mnc: "93"}, Tac: "000001"}, {PlmnId: &models.PlmnId{Mcc: "310", Mnc: "410"}, Tac: "000002"}, {PlmnId: &models.PlmnId{Mcc: "208", Mnc: "93"}, Tac: "000003"}},
+	},
+	{
+		name:          "testTruncatedTaiList",
+		buf:           []byte{0x01, 0x02, 0xf8, 0x39, 0x00, 0x00, 0x01},
+		expectedError: errors.New("partial tai list of type 0 is too short: 6 bytes"),
+	},
+	{
+		name:          "testInvalidTypeOfTaiList",
+		buf:           []byte{0x60, 0x02, 0xf8, 0x39, 0x00, 0x00, 0x01},
+		expectedError: errors.New("invalid type 3 of partial tai list"),
+	},
+}
+
+func TestTaiListToModels(t *testing.T) {
+	for _, testCase := range testTaiListToModelsCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := util.TaiListToModels(testCase.buf)
+			assert.Equal(t, testCase.expectedError, err)
+			if testCase.expectedError == nil {
+				assert.Equal(t, testCase.expected, result)
+			}
+		})
+	}
+}
+
+var testNssaiToModelsCases = []struct {
+	name          string
+	buf           []byte
+	expectedError error
+	expected      []models.Snssai
+}{
+	{
+		name:     "testSstAndSstWithSd",
+		buf:      []byte{0x01, 0x01, 0x04, 0x01, 0x01, 0x02, 0x03},
+		expected: []models.Snssai{{Sst: 1}, {Sst: 1, Sd: "010203"}},
+	},
+	{
+		name:     "testMappedHplmnSnssai",
+		buf:      []byte{0x08, 0x02, 0x11, 0x22, 0x33, 0x01, 0x01, 0x02, 0x03},
+		expected: []models.Snssai{{Sst: 2, Sd: "112233"}},
+	},
+	{
+		name:          "testTruncatedSnssai",
+		buf:           []byte{0x04, 0x01},
+		expectedError: errors.New("s-nssai of 4 bytes is too short: 1 bytes"),
+	},
+	{
+		name:          "testInvalidSnssaiLength",
+		buf:           []byte{0x03, 0x01, 0x02, 0x03},
+		expectedError: errors.New("invalid s-nssai length: 3"),
+	},
+}
+
+func TestNssaiToModels(t *testing.T) {
+	for _, testCase := range testNssaiToModelsCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := util.NssaiToModels(testCase.buf)
+			assert.Equal(t, testCase.expectedError, err)
+			if testCase.expectedError == nil {
+				assert.Equal(t, testCase.expected, result)
+			}
+		})
+	}
+}
+
+var testRejectedNssaiToModelsCases = []struct {
+	name          string
+	buf           []byte
+	expectedError error
+	expected      []util.RejectedSnssai
+}{
+	{
+		name: "testSstAndSstWithSd",
+		buf:  []byte{0x10, 0x01, 0x41, 0x01, 0x01, 0x02, 0x03},
+		expected: []util.RejectedSnssai{
+			{Snssai: models.Snssai{Sst: 1}, Cause: 0},
+			{Snssai: models.Snssai{Sst: 1, Sd: "010203"}, Cause: 1},
+		},
+	},
+	{
+		name:          "testTruncatedRejectedSnssai",
+		buf:           []byte{0x40, 0x01},
+		expectedError: errors.New("rejected s-nssai of 4 bytes is too short: 1 bytes"),
+	},
+	{
+		name:          "testInvalidRejectedSnssaiLength",
+		buf:           []byte{0x20, 0x01, 0x02},
+		expectedError: errors.New("invalid rejected s-nssai length: 2"),
+	},
+}
+
+func TestRejectedNssaiToModels(t *testing.T) {
+	for _, testCase := range testRejectedNssaiToModelsCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result, err := util.RejectedNssaiToModels(testCase.buf)
+			assert.Equal(t, testCase.expectedError, err)
+			if testCase.expectedError == nil {
+				assert.Equal(t, testCase.expected, result)
+			}
+		})
+	}
+}
+
+var testNssaiToNasCases = []struct {
+	name     string
+	nssai    []models.Snssai
+	expected []byte
+}{
+	{
+		name:     "testSstAndSstWithSd",
+		nssai:    []models.Snssai{{Sst: 1}, {Sst: 1, Sd: "010203"}},
+		expected: []byte{0x01, 0x01, 0x04, 0x01, 0x01, 0x02, 0x03},
+	},
+	{
+		name:     "testNoSnssai",
+		nssai:    nil,
+		expected: []byte{},
+	},
+}
+
+func TestNssaiToNas(t *testing.T) {
+	for _, testCase := range testNssaiToNasCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, util.NssaiToNas(testCase.nssai))
+		})
+	}
+}
+
+var testGprsTimer2ToDurationCases = []struct {
+	name            string
+	gprsTimer2Value uint8
+	expected        time.Duration
+}{
+	{
+		name:            "test2SecondsUnit",
+		gprsTimer2Value: 0x0f,
+		expected:        30 * time.Second,
+	},
+	{
+		name:            "test1MinuteUnit",
+		gprsTimer2Value: 0x22,
+		expected:        2 * time.Minute,
+	},
+	{
+		name:            "testDecihoursUnit",
+		gprsTimer2Value: 0x45,
+		expected:        30 * time.Minute,
+	},
+	{
+		name:            "testDeactivated",
+		gprsTimer2Value: 0xe1,
+		expected:        0,
+	},
+}
+
+func TestGprsTimer2ToDuration(t *testing.T) {
+	for _, testCase := range testGprsTimer2ToDurationCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, util.GprsTimer2ToDuration(testCase.gprsTimer2Value))
+		})
+	}
+}
+
+var testGprsTimer3ToDurationCases = []struct {
+	name       string
+	unit       uint8
+	timerValue uint8
+	expected   time.Duration
+}{
+	{
+		name:       "test10MinutesUnit",
+		unit:       0x00,
+		timerValue: 5,
+		expected:   50 * time.Minute,
+	},
+	{
+		name:       "test1HourUnit",
+		unit:       0x01,
+		timerValue: 1,
+		expected:   time.Hour,
+	},
+	{
+		name:       "test2SecondsUnit",
+		unit:       0x03,
+		timerValue: 10,
+		expected:   20 * time.Second,
+	},
+	{
+		name:       "testDeactivated",
+		unit:       0x07,
+		timerValue: 1,
+		expected:   0,
+	},
+}
+
+func TestGprsTimer3ToDuration(t *testing.T) {
+	for _, testCase := range testGprsTimer3ToDurationCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, util.GprsTimer3ToDuration(testCase.unit, testCase.timerValue))
+		})
+	}
+}
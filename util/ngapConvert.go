@@ -2,8 +2,12 @@ package util
 
 import (
 	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
 	"strings"
 
+	"github.com/free5gc/aper"
 	"github.com/free5gc/ngap/ngapType"
 	"github.com/free5gc/openapi/models"
 )
@@ -20,6 +24,7 @@ func PlmnIdToModels(ngapPlmnId ngapType.PLMNIdentity) (modelsPlmnid models.PlmnI
 	return
 }
 
+// PlmnIdToNgap lays out a 3 digit MNC as the ngapConvert of free5GC, so that the AMF decodes the same MNC
 func PlmnIdToNgap(modelsPlmnid models.PlmnId) (ngapType.PLMNIdentity, error) {
 	var hexString string
 	mcc := strings.Split(modelsPlmnid.Mcc, "")
@@ -87,3 +92,42 @@ func SNssaiToNgap(modelsSnssai models.Snssai) (ngapType.SNSSAI, error) {
 	}
 	return ngapSnssai, nil
 }
+
+// NrCellIdToUint64 parses the 36 bits nr cell identity written in 9 hex digits
+func NrCellIdToUint64(nrCellId string) (uint64, error) {
+	if len(nrCellId) != 9 {
+		return 0, fmt.Errorf("invalid nr cell id: %s, nr cell id should be 9 hex digits", nrCellId)
+	}
+	nrCellIdentity, err := strconv.ParseUint(nrCellId, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid nr cell id: %s, nr cell id should be 9 hex digits", nrCellId)
+	}
+	return nrCellIdentity, nil
+}
+
+// NrCellIdentityToNgap puts the 36 bits nr cell identity in the leading bits of 5 bytes as in TS 38.413 9.3.1.7
+func NrCellIdentityToNgap(nrCellIdentity uint64) ngapType.NRCellIdentity {
+	value := nrCellIdentity << 4
+	return ngapType.NRCellIdentity{
+		Value: aper.BitString{
+			Bytes:     []byte{byte(value >> 32), byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)},
+			BitLength: 36,
+		},
+	}
+}
+
+// TransportLayerAddressToIp returns the IP in the transport layer address (TS 38.414),
+// the IPv4 address is preferred when both are present
+func TransportLayerAddressToIp(transportLayerAddress ngapType.TransportLayerAddress) net.IP {
+	value := transportLayerAddress.Value
+	switch {
+	case value.BitLength == 32 && len(value.Bytes) >= 4:
+		return net.IP(append([]byte{}, value.Bytes[:4]...)).To16()
+	case value.BitLength == 128 && len(value.Bytes) >= 16:
+		return net.IP(append([]byte{}, value.Bytes[:16]...))
+	case value.BitLength == 160 && len(value.Bytes) >= 20:
+		return net.IP(append([]byte{}, value.Bytes[:4]...)).To16()
+	default:
+		return nil
+	}
+}
@@ -1,8 +1,11 @@
 package util_test
 
 import (
+	"net"
 	"testing"
 
+	"github.com/free5gc/aper"
+
 	"github.com/Alonza0314/free-ran-ue/util"
 	"github.com/free5gc/ngap/ngapType"
 	"github.com/free5gc/openapi/models"
@@ -24,6 +27,16 @@ var testPlmnIdCases = []struct {
 			Value: []byte{0x02, 0xF8, 0x39},
 		},
 	},
+	{
+		name: "test3DigitMncPlmnId",
+		modelsPlmnId: models.PlmnId{
+			Mcc: "310",
+			Mnc: "410",
+		},
+		ngapPlmnId: ngapType.PLMNIdentity{
+			Value: []byte{0x13, 0x40, 0x01},
+		},
+	},
 }
 
 func TestPlmnIdToModels(t *testing.T) {
@@ -108,3 +121,94 @@ func TestSnssaiToModels(t *testing.T) {
 		})
 	}
 }
+
+var testTransportLayerAddressToIpCases = []struct {
+	name                  string
+	transportLayerAddress ngapType.TransportLayerAddress
+	ip                    net.IP
+}{
+	{
+		name: "testIpv4TransportLayerAddress",
+		transportLayerAddress: ngapType.TransportLayerAddress{
+			Value: aper.BitString{Bytes: []byte{10, 0, 1, 1}, BitLength: 32},
+		},
+		ip: net.ParseIP("10.0.1.1"),
+	},
+	{
+		name: "testIpv6TransportLayerAddress",
+		transportLayerAddress: ngapType.TransportLayerAddress{
+			Value: aper.BitString{Bytes: net.ParseIP("2001:db8::1"), BitLength: 128},
+		},
+		ip: net.ParseIP("2001:db8::1"),
+	},
+	{
+		name: "testIpv4v6TransportLayerAddress",
+		transportLayerAddress: ngapType.TransportLayerAddress{
+			Value: aper.BitString{Bytes: append([]byte{10, 0, 1, 1}, net.ParseIP("2001:db8::1")...), BitLength: 160},
+		},
+		ip: net.ParseIP("10.0.1.1"),
+	},
+	{
+		name:                  "testEmptyTransportLayerAddress",
+		transportLayerAddress: ngapType.TransportLayerAddress{},
+		ip:                    nil,
+	},
+}
+
+func TestTransportLayerAddressToIp(t *testing.T) {
+	for _, testCase := range testTransportLayerAddressToIpCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ip := util.TransportLayerAddressToIp(testCase.transportLayerAddress)
+			assert.Equal(t, testCase.ip, ip)
+		})
+	}
+}
+
+var testNrCellIdCases = []struct {
+	name                   string
+	nrCellId               string
+	expectedNrCellIdentity uint64
+	expectedNgap           []byte
+	expectedError          bool
+}{
+	{
+		name:                   "testNrCellId",
+		nrCellId:               "000000001",
+		expectedNrCellIdentity: 0x000000001,
+		expectedNgap:           []byte{0x00, 0x00, 0x00, 0x00, 0x10},
+	},
+	{
+		name:                   "testNrCellIdMax",
+		nrCellId:               "fffffffff",
+		expectedNrCellIdentity: 0xfffffffff,
+		expectedNgap:           []byte{0xff, 0xff, 0xff, 0xff, 0xf0},
+	},
+	{
+		name:          "testNrCellIdTooLong",
+		nrCellId:      "0000000001",
+		expectedError: true,
+	},
+	{
+		name:          "testNrCellIdNotHex",
+		nrCellId:      "00000000g",
+		expectedError: true,
+	},
+}
+
+func TestNrCellIdToNgap(t *testing.T) {
+	for _, testCase := range testNrCellIdCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			nrCellIdentity, err := util.NrCellIdToUint64(testCase.nrCellId)
+			if testCase.expectedError {
+				assert.NotEqual(t, nil, err)
+				return
+			}
+			assert.Equal(t, nil, err)
+			assert.Equal(t, testCase.expectedNrCellIdentity, nrCellIdentity)
+
+			ngapNrCellIdentity := util.NrCellIdentityToNgap(nrCellIdentity)
+			assert.Equal(t, testCase.expectedNgap, []byte(ngapNrCellIdentity.Value.Bytes))
+			assert.Equal(t, uint64(36), ngapNrCellIdentity.Value.BitLength)
+		})
+	}
+}
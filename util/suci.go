@@ -0,0 +1,177 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+)
+
+const (
+	suciProtectionSchemeNull     = 0x00
+	suciProtectionSchemeProfileA = 0x01
+	suciProtectionSchemeProfileB = 0x02
+
+	// ECIES parameters of TS 33.501 C.3.4, the KDF output is the encryption key, the initial counter block and the MAC key
+	eciesEncryptionKeyLength = 16
+	eciesIcbLength           = 16
+	eciesMacKeyLength        = 32
+	eciesMacTagLength        = 8
+)
+
+// SuciProfile is how the MSIN is concealed in the SUCI, the home network public key is the raw X25519 key of profile A
+// or the compressed or uncompressed secp256r1 point of profile B
+type SuciProfile struct {
+	RoutingIndicator       string
+	ProtectionScheme       string
+	HomeNetworkPublicKeyId uint8
+	HomeNetworkPublicKey   []byte
+}
+
+// SupiToSuci builds the SUCI of the IMSI as the 5GS mobile identity of TS 24.501 9.11.3.4, the scheme output is the MSIN
+// concealed with a fresh ephemeral key for profile A or B, or the MSIN itself for the null scheme
+func SupiToSuci(mcc, mnc, msin string, suciProfile *SuciProfile) ([]byte, error) {
+	routingIndicator := suciProfile.RoutingIndicator
+	if routingIndicator == "" {
+		routingIndicator = "0"
+	}
+
+	var protectionScheme, homeNetworkPublicKeyId uint8
+	schemeOutput := encodeBcd(msin)
+	switch suciProfile.ProtectionScheme {
+	case "", constant.SUCI_PROTECTION_SCHEME_NULL:
+		protectionScheme = suciProtectionSchemeNull
+	case constant.SUCI_PROTECTION_SCHEME_PROFILE_A, constant.SUCI_PROTECTION_SCHEME_PROFILE_B:
+		curve := ecdh.X25519()
+		protectionScheme = suciProtectionSchemeProfileA
+		if suciProfile.ProtectionScheme == constant.SUCI_PROTECTION_SCHEME_PROFILE_B {
+			curve = ecdh.P256()
+			protectionScheme = suciProtectionSchemeProfileB
+		}
+		homeNetworkPublicKeyId = suciProfile.HomeNetworkPublicKeyId
+
+		ephemeralPrivateKey, err := curve.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("error generate ephemeral key: %v", err)
+		}
+		if schemeOutput, err = EciesConceal(suciProfile.ProtectionScheme, suciProfile.HomeNetworkPublicKey, ephemeralPrivateKey.Bytes(), schemeOutput); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported suci protection scheme: %s", suciProfile.ProtectionScheme)
+	}
+
+	buffer := make([]byte, 0, 8+len(schemeOutput))
+
+	// SUCI of an IMSI
+	buffer = append(buffer, 0x01)
+	buffer = append(buffer, encodePlmnId(mcc, mnc)...)
+	buffer = append(buffer, encodeBcdWithLength(routingIndicator, 2)...)
+	buffer = append(buffer, protectionScheme, homeNetworkPublicKeyId)
+	buffer = append(buffer, schemeOutput...)
+
+	return buffer, nil
+}
+
+// EciesConceal encrypts the plaintext as TS 33.501 C.3.2 with the ephemeral private key, the scheme output is the ephemeral public key,
+// compressed for profile B, followed by the ciphertext and the MAC tag
+func EciesConceal(protectionScheme string, homeNetworkPublicKey, ephemeralPrivateKey, plaintext []byte) ([]byte, error) {
+	var curve ecdh.Curve
+	switch protectionScheme {
+	case constant.SUCI_PROTECTION_SCHEME_PROFILE_A:
+		curve = ecdh.X25519()
+	case constant.SUCI_PROTECTION_SCHEME_PROFILE_B:
+		curve = ecdh.P256()
+		if len(homeNetworkPublicKey) == 33 {
+			uncompressed, err := decompressP256PublicKey(homeNetworkPublicKey)
+			if err != nil {
+				return nil, err
+			}
+			homeNetworkPublicKey = uncompressed
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ecies protection scheme: %s", protectionScheme)
+	}
+
+	publicKey, err := curve.NewPublicKey(homeNetworkPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid home network public key: %v", err)
+	}
+	privateKey, err := curve.NewPrivateKey(ephemeralPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral private key: %v", err)
+	}
+	sharedKey, err := privateKey.ECDH(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error ecdh: %v", err)
+	}
+
+	ephemeralPublicKey := privateKey.PublicKey().Bytes()
+	if protectionScheme == constant.SUCI_PROTECTION_SCHEME_PROFILE_B {
+		ephemeralPublicKey = compressP256PublicKey(ephemeralPublicKey)
+	}
+
+	key := ansiX963Kdf(sharedKey, ephemeralPublicKey, eciesEncryptionKeyLength+eciesIcbLength+eciesMacKeyLength)
+	encryptionKey := key[:eciesEncryptionKeyLength]
+	icb := key[eciesEncryptionKeyLength : eciesEncryptionKeyLength+eciesIcbLength]
+	macKey := key[eciesEncryptionKeyLength+eciesIcbLength:]
+
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("error aes cipher: %v", err)
+	}
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCTR(block, icb).XORKeyStream(ciphertext, plaintext)
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(ciphertext)
+	macTag := mac.Sum(nil)[:eciesMacTagLength]
+
+	schemeOutput := make([]byte, 0, len(ephemeralPublicKey)+len(ciphertext)+len(macTag))
+	schemeOutput = append(schemeOutput, ephemeralPublicKey...)
+	schemeOutput = append(schemeOutput, ciphertext...)
+	return append(schemeOutput, macTag...), nil
+}
+
+// ansiX963Kdf derives the key of the given length from the shared key with the ephemeral public key as the shared info, SEC 1 3.6.1
+func ansiX963Kdf(sharedKey, sharedInfo []byte, length int) []byte {
+	key := make([]byte, 0, length+sha256.Size)
+	counter := make([]byte, 4)
+	for i := uint32(1); len(key) < length; i++ {
+		binary.BigEndian.PutUint32(counter, i)
+
+		hash := sha256.New()
+		hash.Write(sharedKey)
+		hash.Write(counter)
+		hash.Write(sharedInfo)
+		key = hash.Sum(key)
+	}
+	return key[:length]
+}
+
+func compressP256PublicKey(uncompressed []byte) []byte {
+	compressed := make([]byte, 33)
+	compressed[0] = 0x02 | uncompressed[64]&0x01
+	copy(compressed[1:], uncompressed[1:33])
+	return compressed
+}
+
+func decompressP256PublicKey(compressed []byte) ([]byte, error) {
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), compressed)
+	if x == nil {
+		return nil, fmt.Errorf("invalid compressed secp256r1 public key")
+	}
+
+	uncompressed := make([]byte, 65)
+	uncompressed[0] = 0x04
+	x.FillBytes(uncompressed[1:33])
+	y.FillBytes(uncompressed[33:])
+	return uncompressed, nil
+}
@@ -0,0 +1,253 @@
+package util_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/Alonza0314/free-ran-ue/constant"
+	"github.com/Alonza0314/free-ran-ue/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// test data of TS 33.501 C.4.3 and C.4.4, the MSIN 001002086 of an IMSI based SUPI
+const (
+	testSuciPlaintext = "00012080f6"
+
+	testProfileAHomeNetworkPrivateKey = "c53c22208b61860b06c62e5406a7b330c2b577aa5558981510d128247d38bd1d"
+	testProfileAHomeNetworkPublicKey  = "5a8d38864820197c3394b92613b20b91633cbd897119273bf8e4a6f4eec0a650"
+	testProfileAEphemeralPrivateKey   = "c80949f13ebe61af4ebdbd293ea4f942696b9e815d7e8f0096bbf6ed7de62256"
+	testProfileASchemeOutput          = "b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457dcb02352410cddd9e730ef3fa87"
+
+	testProfileBHomeNetworkPrivateKey = "f1ab1074477ebcc7f554ea1c5fc368b1616730155e0041ac447d6301975fecda"
+	testProfileBHomeNetworkPublicKey  = "0272da71976234ce833a6907425867b82e074d44ef907dfb4b3e21c1c2256ebcd1"
+	testProfileBEphemeralPrivateKey   = "99798858a1dc6a2c68637149a4b1dbfd1fdff5addd62a2142f06699ed7602529"
+	testProfileBSchemeOutput          = "039aab8376597021e855679a9778ea0b67396e68c66df32c0f41e9acca2da9b9d146a33fc2716ac7dae96aa30a4d"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("decode hex %s: %v", s, err)
+	}
+	return b
+}
+
+// deconcealSchemeOutput is the de-concealment of the home network in TS 33.501 C.3.3, returns the plaintext if the MAC tag matches
+func deconcealSchemeOutput(t *testing.T, protectionScheme string, homeNetworkPrivateKey, schemeOutput []byte) []byte {
+	t.Helper()
+
+	curve, ephemeralPublicKeyLength := ecdh.X25519(), 32
+	if protectionScheme == constant.SUCI_PROTECTION_SCHEME_PROFILE_B {
+		curve, ephemeralPublicKeyLength = ecdh.P256(), 33
+	}
+	ephemeralPublicKey := schemeOutput[:ephemeralPublicKeyLength]
+	ciphertext := schemeOutput[ephemeralPublicKeyLength : len(schemeOutput)-8]
+	macTag := schemeOutput[len(schemeOutput)-8:]
+
+	rawEphemeralPublicKey := ephemeralPublicKey
+	if protectionScheme == constant.SUCI_PROTECTION_SCHEME_PROFILE_B {
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), ephemeralPublicKey)
+		rawEphemeralPublicKey = make([]byte, 65)
+		rawEphemeralPublicKey[0] = 0x04
+		x.FillBytes(rawEphemeralPublicKey[1:33])
+		y.FillBytes(rawEphemeralPublicKey[33:])
+	}
+
+	privateKey, err := curve.NewPrivateKey(homeNetworkPrivateKey)
+	if err != nil {
+		t.Fatalf("home network private key: %v", err)
+	}
+	publicKey, err := curve.NewPublicKey(rawEphemeralPublicKey)
+	if err != nil {
+		t.Fatalf("ephemeral public key: %v", err)
+	}
+	sharedKey, err := privateKey.ECDH(publicKey)
+	if err != nil {
+		t.Fatalf("ecdh: %v", err)
+	}
+
+	key := make([]byte, 0, 64)
+	for counter := uint32(1); counter <= 2; counter++ {
+		counterBytes := make([]byte, 4)
+		binary.BigEndian.PutUint32(counterBytes, counter)
+		hash := sha256.New()
+		hash.Write(sharedKey)
+		hash.Write(counterBytes)
+		hash.Write(ephemeralPublicKey)
+		key = hash.Sum(key)
+	}
+
+	mac := hmac.New(sha256.New, key[32:64])
+	mac.Write(ciphertext)
+	if !hmac.Equal(mac.Sum(nil)[:8], macTag) {
+		t.Fatalf("mac tag mismatch")
+	}
+
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		t.Fatalf("aes: %v", err)
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCTR(block, key[16:32]).XORKeyStream(plaintext, ciphertext)
+	return plaintext
+}
+
+var testEciesConcealCases = []struct {
+	name                  string
+	protectionScheme      string
+	homeNetworkPublicKey  string
+	homeNetworkPrivateKey string
+	ephemeralPrivateKey   string
+	expectedSchemeOutput  string
+}{
+	{
+		name:                  "testProfileATestData",
+		protectionScheme:      constant.SUCI_PROTECTION_SCHEME_PROFILE_A,
+		homeNetworkPublicKey:  testProfileAHomeNetworkPublicKey,
+		homeNetworkPrivateKey: testProfileAHomeNetworkPrivateKey,
+		ephemeralPrivateKey:   testProfileAEphemeralPrivateKey,
+		expectedSchemeOutput:  testProfileASchemeOutput,
+	},
+	{
+		name:                  "testProfileBTestData",
+		protectionScheme:      constant.SUCI_PROTECTION_SCHEME_PROFILE_B,
+		homeNetworkPublicKey:  testProfileBHomeNetworkPublicKey,
+		homeNetworkPrivateKey: testProfileBHomeNetworkPrivateKey,
+		ephemeralPrivateKey:   testProfileBEphemeralPrivateKey,
+		expectedSchemeOutput:  testProfileBSchemeOutput,
+	},
+	{
+		name:                  "testProfileARoundTrip",
+		protectionScheme:      constant.SUCI_PROTECTION_SCHEME_PROFILE_A,
+		homeNetworkPublicKey:  testProfileAHomeNetworkPublicKey,
+		homeNetworkPrivateKey: testProfileAHomeNetworkPrivateKey,
+		ephemeralPrivateKey:   "0707070707070707070707070707070707070707070707070707070707070707",
+	},
+}
+
+func TestEciesConceal(t *testing.T) {
+	for _, testCase := range testEciesConcealCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			schemeOutput, err := util.EciesConceal(testCase.protectionScheme, mustDecodeHex(t, testCase.homeNetworkPublicKey), mustDecodeHex(t, testCase.ephemeralPrivateKey), mustDecodeHex(t, testSuciPlaintext))
+			assert.Nil(t, err)
+			if testCase.expectedSchemeOutput != "" {
+				assert.Equal(t, testCase.expectedSchemeOutput, hex.EncodeToString(schemeOutput))
+			}
+			assert.Equal(t, testSuciPlaintext, hex.EncodeToString(deconcealSchemeOutput(t, testCase.protectionScheme, mustDecodeHex(t, testCase.homeNetworkPrivateKey), schemeOutput)))
+		})
+	}
+}
+
+var testDeconcealTestDataCases = []struct {
+	name                  string
+	protectionScheme      string
+	homeNetworkPrivateKey string
+	schemeOutput          string
+}{
+	{
+		name:                  "testProfileATestData",
+		protectionScheme:      constant.SUCI_PROTECTION_SCHEME_PROFILE_A,
+		homeNetworkPrivateKey: testProfileAHomeNetworkPrivateKey,
+		schemeOutput:          testProfileASchemeOutput,
+	},
+	{
+		name:                  "testProfileBTestData",
+		protectionScheme:      constant.SUCI_PROTECTION_SCHEME_PROFILE_B,
+		homeNetworkPrivateKey: testProfileBHomeNetworkPrivateKey,
+		schemeOutput:          testProfileBSchemeOutput,
+	},
+}
+
+// the de-concealment checking the concealed SUCIs is itself checked against the test data
+func TestDeconcealTestData(t *testing.T) {
+	for _, testCase := range testDeconcealTestDataCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			plaintext := deconcealSchemeOutput(t, testCase.protectionScheme, mustDecodeHex(t, testCase.homeNetworkPrivateKey), mustDecodeHex(t, testCase.schemeOutput))
+			assert.Equal(t, testSuciPlaintext, hex.EncodeToString(plaintext))
+		})
+	}
+}
+
+var testSupiToSuciCases = []struct {
+	name                  string
+	suciProfile           util.SuciProfile
+	homeNetworkPrivateKey string
+	expectedHeader        []byte
+	expectedLength        int
+	expectedError         bool
+}{
+	{
+		name: "testNullSchemeWithRoutingIndicator",
+		suciProfile: util.SuciProfile{
+			RoutingIndicator: "1234",
+		},
+		expectedHeader: []byte{0x01, 0x02, 0xf8, 0x39, 0x21, 0x43, 0x00, 0x00},
+		expectedLength: 13,
+	},
+	{
+		name: "testProfileA",
+		suciProfile: util.SuciProfile{
+			ProtectionScheme:       constant.SUCI_PROTECTION_SCHEME_PROFILE_A,
+			HomeNetworkPublicKeyId: 1,
+			HomeNetworkPublicKey:   []byte{},
+		},
+		homeNetworkPrivateKey: testProfileAHomeNetworkPrivateKey,
+		expectedHeader:        []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0xff, 0x01, 0x01},
+		expectedLength:        8 + 32 + 5 + 8,
+	},
+	{
+		name: "testProfileB",
+		suciProfile: util.SuciProfile{
+			ProtectionScheme:       constant.SUCI_PROTECTION_SCHEME_PROFILE_B,
+			HomeNetworkPublicKeyId: 2,
+		},
+		homeNetworkPrivateKey: testProfileBHomeNetworkPrivateKey,
+		expectedHeader:        []byte{0x01, 0x02, 0xf8, 0x39, 0xf0, 0xff, 0x02, 0x02},
+		expectedLength:        8 + 33 + 5 + 8,
+	},
+	{
+		name: "testUnsupportedScheme",
+		suciProfile: util.SuciProfile{
+			ProtectionScheme: "profileC",
+		},
+		expectedError: true,
+	},
+}
+
+func TestSupiToSuci(t *testing.T) {
+	for _, testCase := range testSupiToSuciCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			suciProfile := testCase.suciProfile
+			switch suciProfile.ProtectionScheme {
+			case constant.SUCI_PROTECTION_SCHEME_PROFILE_A:
+				suciProfile.HomeNetworkPublicKey = mustDecodeHex(t, testProfileAHomeNetworkPublicKey)
+			case constant.SUCI_PROTECTION_SCHEME_PROFILE_B:
+				suciProfile.HomeNetworkPublicKey = mustDecodeHex(t, testProfileBHomeNetworkPublicKey)
+			}
+
+			suci, err := util.SupiToSuci("208", "93", "0000007487", &suciProfile)
+			if testCase.expectedError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, testCase.expectedLength, len(suci))
+			assert.Equal(t, testCase.expectedHeader, suci[:8])
+
+			msin := []byte{0x00, 0x00, 0x00, 0x47, 0x78}
+			if testCase.homeNetworkPrivateKey == "" {
+				assert.Equal(t, msin, suci[8:])
+				return
+			}
+			assert.Equal(t, msin, deconcealSchemeOutput(t, suciProfile.ProtectionScheme, mustDecodeHex(t, testCase.homeNetworkPrivateKey), suci[8:]))
+		})
+	}
+}
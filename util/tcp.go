@@ -3,15 +3,16 @@ package util
 import (
 	"fmt"
 	"net"
+	"strconv"
 )
 
 func TcpDialWithOptionalLocalAddress(remoteAddress string, remotePort int, localAddress string) (net.Conn, error) {
 	if localAddress == "" {
-		return net.Dial("tcp", fmt.Sprintf("%s:%d", remoteAddress, remotePort))
+		return net.Dial("tcp", net.JoinHostPort(remoteAddress, strconv.Itoa(remotePort)))
 	}
 
 	// port 0 means to use any available port
-	localAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", localAddress, 0))
+	localAddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(localAddress, "0"))
 	if err != nil {
 		return nil, fmt.Errorf("error resolving local address: %v", err)
 	}
@@ -19,5 +20,5 @@ func TcpDialWithOptionalLocalAddress(remoteAddress string, remotePort int, local
 	dialer := &net.Dialer{
 		LocalAddr: localAddr,
 	}
-	return dialer.Dial("tcp", fmt.Sprintf("%s:%d", remoteAddress, remotePort))
+	return dialer.Dial("tcp", net.JoinHostPort(remoteAddress, strconv.Itoa(remotePort)))
 }
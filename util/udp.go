@@ -3,14 +3,15 @@ package util
 import (
 	"fmt"
 	"net"
+	"strconv"
 )
 
 func UdpDialWithOptionalLocalAddress(remoteAddress string, remotePort int, localAddress string) (net.Conn, error) {
 	if localAddress == "" {
-		return net.Dial("udp", fmt.Sprintf("%s:%d", remoteAddress, remotePort))
+		return net.Dial("udp", net.JoinHostPort(remoteAddress, strconv.Itoa(remotePort)))
 	}
 
-	localAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", localAddress, 0))
+	localAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(localAddress, "0"))
 	if err != nil {
 		return nil, fmt.Errorf("error resolving local address: %v", err)
 	}
@@ -18,5 +19,5 @@ func UdpDialWithOptionalLocalAddress(remoteAddress string, remotePort int, local
 	dialer := &net.Dialer{
 		LocalAddr: localAddr,
 	}
-	return dialer.Dial("udp", fmt.Sprintf("%s:%d", remoteAddress, remotePort))
+	return dialer.Dial("udp", net.JoinHostPort(remoteAddress, strconv.Itoa(remotePort)))
 }
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 
+	"github.com/Alonza0314/free-ran-ue/constant"
 	"github.com/Alonza0314/free-ran-ue/model"
 	loggergoUtil "github.com/Alonza0314/logger-go/v2/util"
 	"github.com/free5gc/openapi/models"
@@ -69,25 +72,59 @@ func ValidatePlmnId(plmnId *model.PlmnIdIE) error {
 	if err := ValidateIntStringWithLength(plmnId.Mcc, 3); err != nil {
 		return err
 	}
-	if len(plmnId.Mnc) != 2 {
-		return fmt.Errorf("invalid mnc: %s, mnc should be 2 digits", plmnId.Mnc)
+	if len(plmnId.Mnc) != 2 && len(plmnId.Mnc) != 3 {
+		return fmt.Errorf("invalid mnc: %s, mnc should be 2 or 3 digits", plmnId.Mnc)
 	}
-	if err := ValidateIntStringWithLength(plmnId.Mnc, 2); err != nil {
+	if err := ValidateIntStringWithLength(plmnId.Mnc, len(plmnId.Mnc)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func ValidateMsin(msin string) error {
-	if len(msin) != 10 {
-		return fmt.Errorf("invalid msin: %s, msin should be 10 digits", msin)
+// ValidateMsin validates the msin of an imsi of at most 15 digits with the mcc and the 2 or 3 digit mnc
+func ValidateMsin(msin string, mcc, mnc string) error {
+	if len(msin) == 0 || len(mcc)+len(mnc)+len(msin) > 15 {
+		return fmt.Errorf("invalid msin: %s, msin should be 1 to %d digits", msin, 15-len(mcc)-len(mnc))
 	}
-	if err := ValidateIntStringWithLength(msin, 10); err != nil {
+	if err := ValidateIntStringWithLength(msin, len(msin)); err != nil {
 		return err
 	}
 	return nil
 }
 
+// ValidateSuci validates the suci section, an empty routing indicator and protection scheme take "0" and the null scheme
+func ValidateSuci(suci *model.SuciIE) error {
+	if suci.RoutingIndicator != "" {
+		if len(suci.RoutingIndicator) > 4 {
+			return fmt.Errorf("invalid routing indicator: %s, routing indicator should be 1-4 digits", suci.RoutingIndicator)
+		}
+		if err := ValidateIntStringWithLength(suci.RoutingIndicator, len(suci.RoutingIndicator)); err != nil {
+			return fmt.Errorf("invalid routing indicator, %s", err.Error())
+		}
+	}
+
+	var homeNetworkPublicKeyLengths []int
+	switch suci.ProtectionScheme {
+	case "", constant.SUCI_PROTECTION_SCHEME_NULL:
+		return nil
+	case constant.SUCI_PROTECTION_SCHEME_PROFILE_A:
+		homeNetworkPublicKeyLengths = []int{32}
+	case constant.SUCI_PROTECTION_SCHEME_PROFILE_B:
+		homeNetworkPublicKeyLengths = []int{33, 65}
+	default:
+		return fmt.Errorf("invalid protection scheme: %s", suci.ProtectionScheme)
+	}
+
+	if err := ValidateHexString(suci.HomeNetworkPublicKey); err != nil {
+		return fmt.Errorf("invalid home network public key, %s", err.Error())
+	}
+	homeNetworkPublicKey, _ := hex.DecodeString(suci.HomeNetworkPublicKey)
+	if !slices.Contains(homeNetworkPublicKeyLengths, len(homeNetworkPublicKey)) {
+		return fmt.Errorf("invalid home network public key: %s, %s key should be %v bytes", suci.HomeNetworkPublicKey, suci.ProtectionScheme, homeNetworkPublicKeyLengths)
+	}
+	return nil
+}
+
 func ValidateAccessType(accessType models.AccessType) error {
 	switch accessType {
 	case models.AccessType__3_GPP_ACCESS:
@@ -99,6 +136,18 @@ func ValidateAccessType(accessType models.AccessType) error {
 	}
 }
 
+// ValidateAuthenticationMethod accepts the methods the UE authenticates with, the UE without method uses 5G AKA
+func ValidateAuthenticationMethod(authenticationMethod models.AuthMethod) error {
+	switch authenticationMethod {
+	case "", models.AuthMethod__5_G_AKA, models.AuthMethod_EAP_AKA_PRIME:
+		return nil
+	case models.AuthMethod_EAP_TLS, models.AuthMethod_EAP_TTLS:
+		return fmt.Errorf("unsupported authentication method: %s", authenticationMethod)
+	default:
+		return fmt.Errorf("invalid authentication method: %s", authenticationMethod)
+	}
+}
+
 func ValidateHexString(hexString string) error {
 	if _, err := hex.DecodeString(hexString); err != nil {
 		return fmt.Errorf("invalid hex string: %s", hexString)
@@ -107,6 +156,9 @@ func ValidateHexString(hexString string) error {
 }
 
 func ValidateAuthenticationSubscription(authenticationSubscription *model.AuthenticationSubscriptionIE) error {
+	if err := ValidateAuthenticationMethod(models.AuthMethod(authenticationSubscription.AuthenticationMethod)); err != nil {
+		return err
+	}
 	if err := ValidateHexString(authenticationSubscription.EncPermanentKey); err != nil {
 		return fmt.Errorf("invalid enc permanent key, %s", err.Error())
 	}
@@ -139,15 +191,44 @@ func ValidateXorBooleanFlag(booleanFlags ...bool) error {
 	return nil
 }
 
+func ValidateAnyBooleanFlag(booleanFlags ...bool) error {
+	for _, booleanFlag := range booleanFlags {
+		if booleanFlag {
+			return nil
+		}
+	}
+	return fmt.Errorf("no true boolean flag, at least one true flag is required")
+}
+
 func ValidateIntegrityAlgorithm(integrityAlgorithm *model.IntegrityAlgorithmIE) error {
-	return ValidateXorBooleanFlag(integrityAlgorithm.Nia0, integrityAlgorithm.Nia1, integrityAlgorithm.Nia2, integrityAlgorithm.Nia3)
+	return ValidateAnyBooleanFlag(integrityAlgorithm.Nia0, integrityAlgorithm.Nia1, integrityAlgorithm.Nia2, integrityAlgorithm.Nia3)
 }
 
 func ValidateCipheringAlgorithm(cipheringAlgorithm *model.CipheringAlgorithmIE) error {
-	return ValidateXorBooleanFlag(cipheringAlgorithm.Nea0, cipheringAlgorithm.Nea1, cipheringAlgorithm.Nea2, cipheringAlgorithm.Nea3)
+	return ValidateAnyBooleanFlag(cipheringAlgorithm.Nea0, cipheringAlgorithm.Nea1, cipheringAlgorithm.Nea2, cipheringAlgorithm.Nea3)
 }
 
 func ValidatePduSession(pduSession *model.PduSessionIE) error {
+	if pduSession.Id < constant.PDU_SESSION_ID_MIN || pduSession.Id > constant.PDU_SESSION_ID_MAX {
+		return fmt.Errorf("invalid pdu session id: %d, must be in %d-%d", pduSession.Id, constant.PDU_SESSION_ID_MIN, constant.PDU_SESSION_ID_MAX)
+	}
+	switch pduSession.Type {
+	case "", constant.PDU_SESSION_TYPE_IPV4, constant.PDU_SESSION_TYPE_IPV6, constant.PDU_SESSION_TYPE_IPV4V6, constant.PDU_SESSION_TYPE_ETHERNET:
+		if pduSession.UnstructuredSocket != "" {
+			return fmt.Errorf("invalid pdu session unstructured socket, only for %s pdu session", constant.PDU_SESSION_TYPE_UNSTRUCTURED)
+		}
+	case constant.PDU_SESSION_TYPE_UNSTRUCTURED:
+		if pduSession.UnstructuredSocket != "" {
+			if _, err := net.ResolveUDPAddr("udp", pduSession.UnstructuredSocket); err != nil {
+				return fmt.Errorf("invalid pdu session unstructured socket, %s", err.Error())
+			}
+		}
+	default:
+		return fmt.Errorf("invalid pdu session type: %s, must be %s, %s, %s, %s or %s", pduSession.Type, constant.PDU_SESSION_TYPE_IPV4, constant.PDU_SESSION_TYPE_IPV6, constant.PDU_SESSION_TYPE_IPV4V6, constant.PDU_SESSION_TYPE_ETHERNET, constant.PDU_SESSION_TYPE_UNSTRUCTURED)
+	}
+	if pduSession.Snssai == (model.SnssaiIE{}) {
+		return nil
+	}
 	if err := ValidateIntStringWithLength(pduSession.Snssai.Sst, 1); err != nil {
 		return fmt.Errorf("invalid pdu session sst, %s", err.Error())
 	}
@@ -157,6 +238,50 @@ func ValidatePduSession(pduSession *model.PduSessionIE) error {
 	return nil
 }
 
+// ValidatePduSessions validates the pdu sessions of a UE, the ids, tunnel devices and unstructured sockets must be unique,
+// and every session but the first needs its own tunnel device, except an Unstructured session which has none
+func ValidatePduSessions(pduSessions []model.PduSessionIE, ueTunnelDevice string) error {
+	if len(pduSessions) == 0 {
+		return fmt.Errorf("no pdu session")
+	}
+
+	ids, tunnelDevices, unstructuredSockets := make(map[uint8]struct{}), make(map[string]struct{}), make(map[string]struct{})
+	for i := range pduSessions {
+		pduSession := &pduSessions[i]
+		if err := ValidatePduSession(pduSession); err != nil {
+			return err
+		}
+		if _, exists := ids[pduSession.Id]; exists {
+			return fmt.Errorf("duplicate pdu session id: %d", pduSession.Id)
+		}
+		ids[pduSession.Id] = struct{}{}
+
+		if pduSession.Type == constant.PDU_SESSION_TYPE_UNSTRUCTURED {
+			if pduSession.UnstructuredSocket == "" {
+				continue
+			}
+			if _, exists := unstructuredSockets[pduSession.UnstructuredSocket]; exists {
+				return fmt.Errorf("duplicate unstructured socket: %s", pduSession.UnstructuredSocket)
+			}
+			unstructuredSockets[pduSession.UnstructuredSocket] = struct{}{}
+			continue
+		}
+
+		tunnelDevice := pduSession.UeTunnelDevice
+		if tunnelDevice == "" {
+			if i > 0 {
+				return fmt.Errorf("no ue tunnel device of pdu session %d", pduSession.Id)
+			}
+			tunnelDevice = ueTunnelDevice
+		}
+		if _, exists := tunnelDevices[tunnelDevice]; exists {
+			return fmt.Errorf("duplicate ue tunnel device: %s", tunnelDevice)
+		}
+		tunnelDevices[tunnelDevice] = struct{}{}
+	}
+	return nil
+}
+
 func ValidateNrdc(nrdc *model.NrdcIE) error {
 	if !nrdc.Enable {
 		return nil
@@ -175,6 +300,24 @@ func ValidateNrdc(nrdc *model.NrdcIE) error {
 	return nil
 }
 
+func ValidateNrCellId(nrCellId string) error {
+	nrCellIdentity, err := NrCellIdToUint64(nrCellId)
+	if err != nil {
+		return err
+	}
+	if nrCellIdentity == 0 {
+		return fmt.Errorf("invalid nr cell id: %s, nr cell id 0 is reserved", nrCellId)
+	}
+	return nil
+}
+
+func ValidatePci(pci int) error {
+	if pci < 0 || pci > constant.RRC_PCI_MAX {
+		return fmt.Errorf("invalid pci: %d, range should be 0-%d", pci, constant.RRC_PCI_MAX)
+	}
+	return nil
+}
+
 func ValidateUeIe(ueIe *model.UeIE) error {
 	if err := ValidateIp(ueIe.RanControlPlaneIp); err != nil {
 		return fmt.Errorf("invalid ue ran control plane ip, %s", err.Error())
@@ -196,12 +339,21 @@ func ValidateUeIe(ueIe *model.UeIE) error {
 		return fmt.Errorf("invalid ue ran data plane port, %s", err.Error())
 	}
 
+	if ueIe.NrCellId != "" {
+		if err := ValidateNrCellId(ueIe.NrCellId); err != nil {
+			return fmt.Errorf("invalid ue nr cell id, %s", err.Error())
+		}
+	}
+
 	if err := ValidatePlmnId(&ueIe.PlmnId); err != nil {
 		return fmt.Errorf("invalid ue plmn id, %s", err.Error())
 	}
-	if err := ValidateMsin(ueIe.Msin); err != nil {
+	if err := ValidateMsin(ueIe.Msin, ueIe.PlmnId.Mcc, ueIe.PlmnId.Mnc); err != nil {
 		return fmt.Errorf("invalid ue msin, %s", err.Error())
 	}
+	if err := ValidateSuci(&ueIe.Suci); err != nil {
+		return fmt.Errorf("invalid ue suci, %s", err.Error())
+	}
 
 	if err := ValidateAccessType(ueIe.AccessType); err != nil {
 		return fmt.Errorf("invalid ue access type, %s", err.Error())
@@ -217,13 +369,115 @@ func ValidateUeIe(ueIe *model.UeIE) error {
 		return fmt.Errorf("invalid ue integrity algorithm, %s", err.Error())
 	}
 
-	if err := ValidatePduSession(&ueIe.PduSession); err != nil {
-		return fmt.Errorf("invalid ue pdu session, %s", err.Error())
+	for _, snssai := range ueIe.ConfiguredNssai {
+		if err := ValidateSnssaiIe(&snssai); err != nil {
+			return fmt.Errorf("invalid ue configured nssai, %s", err.Error())
+		}
+	}
+
+	if err := ValidatePduSessions(ueIe.PduSessions, ueIe.UeTunnelDevice); err != nil {
+		return fmt.Errorf("invalid ue pdu sessions, %s", err.Error())
 	}
 
 	if err := ValidateNrdc(&ueIe.Nrdc); err != nil {
 		return fmt.Errorf("invalid ue nrdc, %s", err.Error())
 	}
+
+	if err := ValidateNasRetryIe(&ueIe.NasRetry); err != nil {
+		return fmt.Errorf("invalid ue nas retry, %s", err.Error())
+	}
+
+	if err := ValidateUeApiIe(&ueIe.Api); err != nil {
+		return fmt.Errorf("invalid ue api, %s", err.Error())
+	}
+	return nil
+}
+
+func ValidateUeApiIe(ueApiIe *model.UeApiIE) error {
+	if !ueApiIe.Enable {
+		return nil
+	}
+	return ValidateApiIe(&model.ApiIE{Ip: ueApiIe.Ip, Port: ueApiIe.Port})
+}
+
+func ValidateNasRetryIe(nasRetryIe *model.NasRetryIE) error {
+	if nasRetryIe.RegistrationAttempts < 0 {
+		return fmt.Errorf("invalid registrationAttempts: %d, registrationAttempts must not be negative", nasRetryIe.RegistrationAttempts)
+	}
+	if nasRetryIe.RegistrationInterval < 0 {
+		return fmt.Errorf("invalid registrationInterval: %s, registrationInterval must not be negative", nasRetryIe.RegistrationInterval)
+	}
+	if nasRetryIe.Retransmissions < 0 {
+		return fmt.Errorf("invalid retransmissions: %d, retransmissions must not be negative", nasRetryIe.Retransmissions)
+	}
+	if nasRetryIe.MaxBackoff < 0 {
+		return fmt.Errorf("invalid maxBackoff: %s, maxBackoff must not be negative", nasRetryIe.MaxBackoff)
+	}
+	if nasRetryIe.T3510 < 0 {
+		return fmt.Errorf("invalid t3510: %s, t3510 must not be negative", nasRetryIe.T3510)
+	}
+	if nasRetryIe.T3521 < 0 {
+		return fmt.Errorf("invalid t3521: %s, t3521 must not be negative", nasRetryIe.T3521)
+	}
+	if nasRetryIe.T3580 < 0 {
+		return fmt.Errorf("invalid t3580: %s, t3580 must not be negative", nasRetryIe.T3580)
+	}
+	return nil
+}
+
+// ValidateMultiUeIe validates the multi ue section, the msin of the ue section is the start msin if none is given
+func ValidateMultiUeIe(multiUeIe *model.MultiUeIE, mcc, mnc, msin string) error {
+	if !multiUeIe.Enable {
+		return nil
+	}
+
+	if multiUeIe.StartMsin != "" {
+		if err := ValidateMsin(multiUeIe.StartMsin, mcc, mnc); err != nil {
+			return fmt.Errorf("invalid start msin, %s", err.Error())
+		}
+		msin = multiUeIe.StartMsin
+	}
+	if multiUeIe.Count < 1 {
+		return fmt.Errorf("invalid count: %d, count must be positive", multiUeIe.Count)
+	}
+	startMsin, err := strconv.ParseUint(msin, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid start msin: %s", msin)
+	}
+	if len(strconv.FormatUint(startMsin+uint64(multiUeIe.Count)-1, 10)) > len(msin) {
+		return fmt.Errorf("invalid count: %d, msin exceeds %d digits from %s", multiUeIe.Count, len(msin), msin)
+	}
+
+	switch multiUeIe.KeyDerivation {
+	case "", constant.MULTI_UE_KEY_DERIVATION_SHARED, constant.MULTI_UE_KEY_DERIVATION_INCREMENT:
+	default:
+		return fmt.Errorf("invalid key derivation: %s, must be %s or %s", multiUeIe.KeyDerivation, constant.MULTI_UE_KEY_DERIVATION_SHARED, constant.MULTI_UE_KEY_DERIVATION_INCREMENT)
+	}
+
+	if multiUeIe.AttachRate < 0 {
+		return fmt.Errorf("invalid attach rate: %v, attach rate must not be negative", multiUeIe.AttachRate)
+	}
+	if multiUeIe.Concurrency < 0 {
+		return fmt.Errorf("invalid concurrency: %d, concurrency must not be negative", multiUeIe.Concurrency)
+	}
+
+	switch multiUeIe.DataPlane {
+	case "", constant.MULTI_UE_DATA_PLANE_TUN, constant.MULTI_UE_DATA_PLANE_USERSPACE:
+	default:
+		return fmt.Errorf("invalid data plane: %s, must be %s or %s", multiUeIe.DataPlane, constant.MULTI_UE_DATA_PLANE_TUN, constant.MULTI_UE_DATA_PLANE_USERSPACE)
+	}
+
+	if multiUeIe.Traffic.Target != "" {
+		if multiUeIe.DataPlane != constant.MULTI_UE_DATA_PLANE_USERSPACE {
+			return fmt.Errorf("invalid traffic, traffic is only sent with the %s data plane", constant.MULTI_UE_DATA_PLANE_USERSPACE)
+		}
+		if ip := net.ParseIP(multiUeIe.Traffic.Target); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid traffic target: %s, must be an IPv4 address", multiUeIe.Traffic.Target)
+		}
+	}
+	if multiUeIe.Traffic.Interval < 0 {
+		return fmt.Errorf("invalid traffic interval: %v, interval must not be negative", multiUeIe.Traffic.Interval)
+	}
 	return nil
 }
 
@@ -231,6 +485,9 @@ func ValidateUe(ue *model.UeConfig) error {
 	if err := ValidateUeIe(&ue.Ue); err != nil {
 		return err
 	}
+	if err := ValidateMultiUeIe(&ue.MultiUe, ue.Ue.PlmnId.Mcc, ue.Ue.PlmnId.Mnc, ue.Ue.Msin); err != nil {
+		return fmt.Errorf("invalid ue multi ue, %s", err.Error())
+	}
 	if err := ValidateLoggerIe(&ue.Logger); err != nil {
 		return err
 	}
@@ -259,6 +516,66 @@ func ValidateSnssaiIe(snssai *model.SnssaiIE) error {
 	return nil
 }
 
+func ValidateCellIe(cellIe *model.CellIE) error {
+	if err := ValidateNrCellId(cellIe.NrCellId); err != nil {
+		return err
+	}
+	if err := ValidatePci(cellIe.Pci); err != nil {
+		return err
+	}
+
+	if cellIe.Tac != "" {
+		if err := ValidateHexString(cellIe.Tac); err != nil || len(cellIe.Tac) != 6 {
+			return fmt.Errorf("invalid tac: %s, tac should be 6 hex digits", cellIe.Tac)
+		}
+	}
+	for _, snssai := range cellIe.Snssai {
+		if err := ValidateSnssaiIe(&snssai); err != nil {
+			return fmt.Errorf("invalid snssai: %s", err.Error())
+		}
+	}
+
+	if cellIe.RanControlPlaneIp != "" {
+		if err := ValidateIp(cellIe.RanControlPlaneIp); err != nil {
+			return fmt.Errorf("invalid ranControlPlaneIp: %s", err.Error())
+		}
+		if err := ValidatePort(cellIe.RanControlPlanePort); err != nil {
+			return fmt.Errorf("invalid ranControlPlanePort: %s", err.Error())
+		}
+	}
+	if cellIe.RanDataPlaneIp != "" {
+		if err := ValidateIp(cellIe.RanDataPlaneIp); err != nil {
+			return fmt.Errorf("invalid ranDataPlaneIp: %s", err.Error())
+		}
+		if err := ValidatePort(cellIe.RanDataPlanePort); err != nil {
+			return fmt.Errorf("invalid ranDataPlanePort: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// ValidateCellsIe validates each cell, the nr cell id and the pci of the cells must be unique in the gNB
+func ValidateCellsIe(cellsIe []model.CellIE) error {
+	nrCellIds, pcis := make(map[string]struct{}), make(map[int]struct{})
+	for _, cellIe := range cellsIe {
+		if err := ValidateCellIe(&cellIe); err != nil {
+			return fmt.Errorf("invalid cell %s: %s", cellIe.NrCellId, err.Error())
+		}
+
+		nrCellId := strings.ToLower(cellIe.NrCellId)
+		if _, exists := nrCellIds[nrCellId]; exists {
+			return fmt.Errorf("duplicate nr cell id: %s", cellIe.NrCellId)
+		}
+		nrCellIds[nrCellId] = struct{}{}
+
+		if _, exists := pcis[cellIe.Pci]; exists {
+			return fmt.Errorf("duplicate pci: %d", cellIe.Pci)
+		}
+		pcis[cellIe.Pci] = struct{}{}
+	}
+	return nil
+}
+
 func ValidateApiIe(apiIe *model.ApiIE) error {
 	if err := ValidateIp(apiIe.Ip); err != nil {
 		return fmt.Errorf("invalid ip: %s", err.Error())
@@ -291,6 +608,70 @@ func ValidateXnInterfaceIe(xnIe *model.XnInterfaceIE) error {
 	return nil
 }
 
+func ValidateTeidAllocation(teidAllocation string) error {
+	switch teidAllocation {
+	case "", constant.TEID_ALLOCATION_SEQUENTIAL, constant.TEID_ALLOCATION_RANDOM:
+		return nil
+	default:
+		return fmt.Errorf("invalid teid allocation: %s, must be %s or %s", teidAllocation, constant.TEID_ALLOCATION_SEQUENTIAL, constant.TEID_ALLOCATION_RANDOM)
+	}
+}
+
+func ValidateDlBufferIe(dlBufferIe *model.DlBufferIE) error {
+	if dlBufferIe.Size < 0 {
+		return fmt.Errorf("invalid size: %d, size must not be negative", dlBufferIe.Size)
+	}
+	if dlBufferIe.MaxAge < 0 {
+		return fmt.Errorf("invalid maxAge: %s, maxAge must not be negative", dlBufferIe.MaxAge)
+	}
+	return nil
+}
+
+func ValidateRadioChannelProfileIe(radioChannelProfileIe *model.RadioChannelProfileIE) error {
+	if radioChannelProfileIe.Latency < 0 {
+		return fmt.Errorf("invalid latency: %s, latency must not be negative", radioChannelProfileIe.Latency)
+	}
+	if radioChannelProfileIe.Jitter < 0 {
+		return fmt.Errorf("invalid jitter: %s, jitter must not be negative", radioChannelProfileIe.Jitter)
+	}
+	if radioChannelProfileIe.Loss < 0 || radioChannelProfileIe.Loss > 1 {
+		return fmt.Errorf("invalid loss: %v, loss must be in [0, 1]", radioChannelProfileIe.Loss)
+	}
+	if radioChannelProfileIe.Reorder < 0 || radioChannelProfileIe.Reorder > 1 {
+		return fmt.Errorf("invalid reorder: %v, reorder must be in [0, 1]", radioChannelProfileIe.Reorder)
+	}
+	if radioChannelProfileIe.Bandwidth < 0 {
+		return fmt.Errorf("invalid bandwidth: %d, bandwidth must not be negative", radioChannelProfileIe.Bandwidth)
+	}
+	for _, outage := range radioChannelProfileIe.Outages {
+		if outage.Start < 0 || outage.Duration <= 0 || outage.Period < 0 {
+			return fmt.Errorf("invalid outage: start %s, duration %s, period %s", outage.Start, outage.Duration, outage.Period)
+		}
+		if outage.Period != 0 && outage.Period < outage.Duration {
+			return fmt.Errorf("invalid outage: period %s shorter than duration %s", outage.Period, outage.Duration)
+		}
+	}
+	return nil
+}
+
+func ValidateRadioChannelIe(radioChannelIe *model.RadioChannelIE) error {
+	if err := ValidateRadioChannelProfileIe(&radioChannelIe.Cell); err != nil {
+		return fmt.Errorf("invalid cell: %s", err.Error())
+	}
+	for _, ueRadioChannelIe := range radioChannelIe.Ue {
+		if !strings.HasPrefix(ueRadioChannelIe.Imsi, "imsi-") {
+			return fmt.Errorf("invalid ue imsi: %s, imsi must be in the form of imsi-<mcc><mnc><msin>", ueRadioChannelIe.Imsi)
+		}
+		if err := ValidateIntStringWithLength(strings.TrimPrefix(ueRadioChannelIe.Imsi, "imsi-"), len(ueRadioChannelIe.Imsi)-len("imsi-")); err != nil {
+			return fmt.Errorf("invalid ue imsi: %s", err.Error())
+		}
+		if err := ValidateRadioChannelProfileIe(&ueRadioChannelIe.Profile); err != nil {
+			return fmt.Errorf("invalid ue %s: %s", ueRadioChannelIe.Imsi, err.Error())
+		}
+	}
+	return nil
+}
+
 func ValidateGnbIe(gnbIe *model.GnbIE) error {
 	if err := ValidateIp(gnbIe.AmfN2Ip); err != nil {
 		return fmt.Errorf("invalid gnb amfN2Ip: %s", err.Error())
@@ -346,6 +727,22 @@ func ValidateGnbIe(gnbIe *model.GnbIE) error {
 		return fmt.Errorf("invalid gnb snssai: %s", err.Error())
 	}
 
+	if err := ValidateCellsIe(gnbIe.Cells); err != nil {
+		return fmt.Errorf("invalid gnb cells: %s", err.Error())
+	}
+
+	if err := ValidateTeidAllocation(gnbIe.TeidAllocation); err != nil {
+		return fmt.Errorf("invalid gnb teidAllocation: %s", err.Error())
+	}
+
+	if err := ValidateDlBufferIe(&gnbIe.DlBuffer); err != nil {
+		return fmt.Errorf("invalid gnb dlBuffer: %s", err.Error())
+	}
+
+	if err := ValidateRadioChannelIe(&gnbIe.RadioChannel); err != nil {
+		return fmt.Errorf("invalid gnb radioChannel: %s", err.Error())
+	}
+
 	if err := ValidateApiIe(&gnbIe.Api); err != nil {
 		return fmt.Errorf("invalid gnb api: %s", err.Error())
 	}
@@ -167,10 +167,15 @@ var testValidatePlmnIdCases = []struct {
 		plmnId:        model.PlmnIdIE{Mcc: "208", Mnc: "93"},
 		expectedError: nil,
 	},
+	{
+		name:          "testValid3DigitMncPlmnId",
+		plmnId:        model.PlmnIdIE{Mcc: "310", Mnc: "410"},
+		expectedError: nil,
+	},
 	{
 		name:          "testInvalidPlmnId",
-		plmnId:        model.PlmnIdIE{Mcc: "208", Mnc: "930"},
-		expectedError: fmt.Errorf("invalid mnc: 930, mnc should be 2 digits"),
+		plmnId:        model.PlmnIdIE{Mcc: "208", Mnc: "9301"},
+		expectedError: fmt.Errorf("invalid mnc: 9301, mnc should be 2 or 3 digits"),
 	},
 	{
 		name:          "testInvalidNonIntMcc",
@@ -196,21 +201,57 @@ func TestValidatePlmnId(t *testing.T) {
 var testValidateMsinCases = []struct {
 	name          string
 	msin          string
+	mcc           string
+	mnc           string
 	expectedError error
 }{
 	{
 		name:          "testValidMsin",
 		msin:          "0000000001",
+		mcc:           "208",
+		mnc:           "93",
+		expectedError: nil,
+	},
+	{
+		name:          "testValid3DigitMncMsin",
+		msin:          "000000001",
+		mcc:           "310",
+		mnc:           "410",
+		expectedError: nil,
+	},
+	{
+		name:          "testValidShortMsin",
+		msin:          "00001",
+		mcc:           "208",
+		mnc:           "93",
 		expectedError: nil,
 	},
 	{
 		name:          "testInvalidMsin",
 		msin:          "00000000010",
-		expectedError: fmt.Errorf("invalid msin: 00000000010, msin should be 10 digits"),
+		mcc:           "208",
+		mnc:           "93",
+		expectedError: fmt.Errorf("invalid msin: 00000000010, msin should be 1 to 10 digits"),
+	},
+	{
+		name:          "testInvalid3DigitMncMsin",
+		msin:          "0000000001",
+		mcc:           "310",
+		mnc:           "410",
+		expectedError: fmt.Errorf("invalid msin: 0000000001, msin should be 1 to 9 digits"),
+	},
+	{
+		name:          "testInvalidEmptyMsin",
+		msin:          "",
+		mcc:           "208",
+		mnc:           "93",
+		expectedError: fmt.Errorf("invalid msin: , msin should be 1 to 10 digits"),
 	},
 	{
 		name:          "testInvalidNonIntMsin",
 		msin:          "000000000a",
+		mcc:           "208",
+		mnc:           "93",
 		expectedError: fmt.Errorf("invalid int string: 000000000a"),
 	},
 }
@@ -218,7 +259,7 @@ var testValidateMsinCases = []struct {
 func TestValidateMsin(t *testing.T) {
 	for _, testCase := range testValidateMsinCases {
 		t.Run(testCase.name, func(t *testing.T) {
-			err := util.ValidateMsin(testCase.msin)
+			err := util.ValidateMsin(testCase.msin, testCase.mcc, testCase.mnc)
 			assert.Equal(t, testCase.expectedError, err)
 		})
 	}
@@ -310,6 +351,39 @@ var testValidateAuthenticationSubscriptionCases = []struct {
 		},
 		expectedError: fmt.Errorf("invalid authentication management field, invalid int string: 80000, length should be 4"),
 	},
+	{
+		name: "testValidEapAkaPrimeAuthenticationMethod",
+		authenticationSubscription: model.AuthenticationSubscriptionIE{
+			AuthenticationMethod:          "EAP_AKA_PRIME",
+			EncPermanentKey:               "8baf473f2f8fd09487cccbd7097c6862",
+			EncOpcKey:                     "8e27b6af0e692e750f32667a3b14605d",
+			AuthenticationManagementField: "8000",
+			SequenceNumber:                "000000000023",
+		},
+		expectedError: nil,
+	},
+	{
+		name: "testUnsupportedAuthenticationMethod",
+		authenticationSubscription: model.AuthenticationSubscriptionIE{
+			AuthenticationMethod:          "EAP_TLS",
+			EncPermanentKey:               "8baf473f2f8fd09487cccbd7097c6862",
+			EncOpcKey:                     "8e27b6af0e692e750f32667a3b14605d",
+			AuthenticationManagementField: "8000",
+			SequenceNumber:                "000000000023",
+		},
+		expectedError: fmt.Errorf("unsupported authentication method: EAP_TLS"),
+	},
+	{
+		name: "testInvalidAuthenticationMethod",
+		authenticationSubscription: model.AuthenticationSubscriptionIE{
+			AuthenticationMethod:          "EAP_AKA",
+			EncPermanentKey:               "8baf473f2f8fd09487cccbd7097c6862",
+			EncOpcKey:                     "8e27b6af0e692e750f32667a3b14605d",
+			AuthenticationManagementField: "8000",
+			SequenceNumber:                "000000000023",
+		},
+		expectedError: fmt.Errorf("invalid authentication method: EAP_AKA"),
+	},
 }
 
 func TestValidateAuthenticationSubscription(t *testing.T) {
@@ -352,6 +426,37 @@ func TestValidateXorBooleanFlag(t *testing.T) {
 	}
 }
 
+var testValidateAnyBooleanFlagCases = []struct {
+	name          string
+	booleanFlags  []bool
+	expectedError error
+}{
+	{
+		name:          "testValidAnyBooleanFlag",
+		booleanFlags:  []bool{false, true, false},
+		expectedError: nil,
+	},
+	{
+		name:          "testValidMultipleAnyBooleanFlag",
+		booleanFlags:  []bool{true, true, false},
+		expectedError: nil,
+	},
+	{
+		name:          "testInvalidAnyBooleanFlag",
+		booleanFlags:  []bool{false, false, false},
+		expectedError: fmt.Errorf("no true boolean flag, at least one true flag is required"),
+	},
+}
+
+func TestValidateAnyBooleanFlag(t *testing.T) {
+	for _, testCase := range testValidateAnyBooleanFlagCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := util.ValidateAnyBooleanFlag(testCase.booleanFlags...)
+			assert.Equal(t, testCase.expectedError, err)
+		})
+	}
+}
+
 var testValidateIntegrityAlgorithmCases = []struct {
 	name               string
 	integrityAlgorithm model.IntegrityAlgorithmIE
@@ -368,14 +473,14 @@ var testValidateIntegrityAlgorithmCases = []struct {
 		expectedError: nil,
 	},
 	{
-		name: "testInvalidMultipleTrueIntegrityAlgorithm",
+		name: "testValidMultipleTrueIntegrityAlgorithm",
 		integrityAlgorithm: model.IntegrityAlgorithmIE{
 			Nia0: false,
 			Nia1: false,
 			Nia2: true,
 			Nia3: true,
 		},
-		expectedError: fmt.Errorf("exist multiple true boolean flags"),
+		expectedError: nil,
 	},
 	{
 		name: "testInvalidNoTrueIntegrityAlgorithm",
@@ -385,7 +490,7 @@ var testValidateIntegrityAlgorithmCases = []struct {
 			Nia2: false,
 			Nia3: false,
 		},
-		expectedError: fmt.Errorf("no true boolean flag, one true flag is required"),
+		expectedError: fmt.Errorf("no true boolean flag, at least one true flag is required"),
 	},
 }
 
@@ -414,14 +519,14 @@ var testValidateCipheringAlgorithmCases = []struct {
 		expectedError: nil,
 	},
 	{
-		name: "testInvalidMultipleTrueCipheringAlgorithm",
+		name: "testValidMultipleTrueCipheringAlgorithm",
 		cipheringAlgorithm: model.CipheringAlgorithmIE{
 			Nea0: true,
 			Nea1: false,
 			Nea2: false,
 			Nea3: true,
 		},
-		expectedError: fmt.Errorf("exist multiple true boolean flags"),
+		expectedError: nil,
 	},
 	{
 		name: "testInvalidNoTrueCipheringAlgorithm",
@@ -431,7 +536,7 @@ var testValidateCipheringAlgorithmCases = []struct {
 			Nea2: false,
 			Nea3: false,
 		},
-		expectedError: fmt.Errorf("no true boolean flag, one true flag is required"),
+		expectedError: fmt.Errorf("no true boolean flag, at least one true flag is required"),
 	},
 }
 
@@ -452,6 +557,7 @@ var testValidatePduSessionCases = []struct {
 	{
 		name: "testValidPduSession",
 		pduSession: model.PduSessionIE{
+			Id:  1,
 			Dnn: "internet",
 			Snssai: model.SnssaiIE{
 				Sst: "1",
@@ -463,6 +569,7 @@ var testValidatePduSessionCases = []struct {
 	{
 		name: "testInvalidSstNilPduSession",
 		pduSession: model.PduSessionIE{
+			Id:  1,
 			Dnn: "internet",
 			Snssai: model.SnssaiIE{
 				Sst: "z",
@@ -474,6 +581,7 @@ var testValidatePduSessionCases = []struct {
 	{
 		name: "testInvalidSdNilPduSession",
 		pduSession: model.PduSessionIE{
+			Id:  1,
 			Dnn: "internet",
 			Snssai: model.SnssaiIE{
 				Sst: "1",
@@ -482,6 +590,103 @@ var testValidatePduSessionCases = []struct {
 		},
 		expectedError: fmt.Errorf("invalid pdu session sd, invalid hex string: zzzzzz"),
 	},
+	{
+		name: "testInvalidIdPduSession",
+		pduSession: model.PduSessionIE{
+			Id:  16,
+			Dnn: "internet",
+			Snssai: model.SnssaiIE{
+				Sst: "1",
+				Sd:  "010203",
+			},
+		},
+		expectedError: fmt.Errorf("invalid pdu session id: 16, must be in 1-15"),
+	},
+	{
+		name: "testIpv4v6PduSession",
+		pduSession: model.PduSessionIE{
+			Id:   1,
+			Type: "IPv4v6",
+			Dnn:  "internet",
+			Snssai: model.SnssaiIE{
+				Sst: "1",
+				Sd:  "010203",
+			},
+		},
+		expectedError: nil,
+	},
+	{
+		name: "testEthernetPduSession",
+		pduSession: model.PduSessionIE{
+			Id:   1,
+			Type: "Ethernet",
+			Dnn:  "lan",
+			Snssai: model.SnssaiIE{
+				Sst: "1",
+				Sd:  "010203",
+			},
+		},
+		expectedError: nil,
+	},
+	{
+		name: "testUnstructuredPduSession",
+		pduSession: model.PduSessionIE{
+			Id:   1,
+			Type: "Unstructured",
+			Dnn:  "iot",
+			Snssai: model.SnssaiIE{
+				Sst: "1",
+				Sd:  "010203",
+			},
+			UnstructuredSocket: "127.0.0.1:9000",
+		},
+		expectedError: nil,
+	},
+	{
+		name: "testUnstructuredSocketOfIpv4PduSession",
+		pduSession: model.PduSessionIE{
+			Id:  1,
+			Dnn: "internet",
+			Snssai: model.SnssaiIE{
+				Sst: "1",
+				Sd:  "010203",
+			},
+			UnstructuredSocket: "127.0.0.1:9000",
+		},
+		expectedError: fmt.Errorf("invalid pdu session unstructured socket, only for Unstructured pdu session"),
+	},
+	{
+		name: "testInvalidTypePduSession",
+		pduSession: model.PduSessionIE{
+			Id:   1,
+			Type: "PPP",
+			Dnn:  "internet",
+			Snssai: model.SnssaiIE{
+				Sst: "1",
+				Sd:  "010203",
+			},
+		},
+		expectedError: fmt.Errorf("invalid pdu session type: PPP, must be IPv4, IPv6, IPv4v6, Ethernet or Unstructured"),
+	},
+	{
+		name: "testPduSessionWithoutSnssai",
+		pduSession: model.PduSessionIE{
+			Id:  1,
+			Dnn: "internet",
+		},
+		expectedError: nil,
+	},
+	{
+		name: "testInvalidSstPduSession",
+		pduSession: model.PduSessionIE{
+			Id:  1,
+			Dnn: "internet",
+			Snssai: model.SnssaiIE{
+				Sd: "010203",
+			},
+		},
+		expectedError: fmt.Errorf("invalid pdu session sst, invalid int string: "),
+	},
 }
 
 func TestValidatePduSession(t *testing.T) {
@@ -493,6 +698,104 @@ func TestValidatePduSession(t *testing.T) {
 	}
 }
 
+var (
+	testInternetPduSession = model.PduSessionIE{
+		Id:  1,
+		Dnn: "internet",
+		Snssai: model.SnssaiIE{
+			Sst: "1",
+			Sd:  "010203",
+		},
+	}
+	testImsPduSession = model.PduSessionIE{
+		Id:  2,
+		Dnn: "ims",
+		Snssai: model.SnssaiIE{
+			Sst: "1",
+			Sd:  "010203",
+		},
+		UeTunnelDevice: "imsTun0",
+	}
+	testUnstructuredPduSession = model.PduSessionIE{
+		Id:   3,
+		Type: "Unstructured",
+		Dnn:  "iot",
+		Snssai: model.SnssaiIE{
+			Sst: "1",
+			Sd:  "010203",
+		},
+		UnstructuredSocket: "127.0.0.1:9000",
+	}
+)
+
+var testValidatePduSessionsCases = []struct {
+	name           string
+	pduSessions    []model.PduSessionIE
+	ueTunnelDevice string
+	expectedError  error
+}{
+	{
+		name:           "testValidInternetAndImsPduSessions",
+		pduSessions:    []model.PduSessionIE{testInternetPduSession, testImsPduSession},
+		ueTunnelDevice: "ueTun0",
+		expectedError:  nil,
+	},
+	{
+		name:           "testNoPduSession",
+		pduSessions:    []model.PduSessionIE{},
+		ueTunnelDevice: "ueTun0",
+		expectedError:  fmt.Errorf("no pdu session"),
+	},
+	{
+		name:           "testDuplicatePduSessionId",
+		pduSessions:    []model.PduSessionIE{testInternetPduSession, testInternetPduSession},
+		ueTunnelDevice: "ueTun0",
+		expectedError:  fmt.Errorf("duplicate pdu session id: 1"),
+	},
+	{
+		name:           "testNoUeTunnelDeviceOfSecondPduSession",
+		pduSessions:    []model.PduSessionIE{testImsPduSession, testInternetPduSession},
+		ueTunnelDevice: "ueTun0",
+		expectedError:  fmt.Errorf("no ue tunnel device of pdu session 1"),
+	},
+	{
+		name:           "testUnstructuredPduSessionWithoutUeTunnelDevice",
+		pduSessions:    []model.PduSessionIE{testInternetPduSession, testUnstructuredPduSession},
+		ueTunnelDevice: "ueTun0",
+		expectedError:  nil,
+	},
+	{
+		name: "testDuplicateUnstructuredSocket",
+		pduSessions: []model.PduSessionIE{testUnstructuredPduSession, {
+			Id:   4,
+			Type: "Unstructured",
+			Dnn:  "iot",
+			Snssai: model.SnssaiIE{
+				Sst: "1",
+				Sd:  "010203",
+			},
+			UnstructuredSocket: "127.0.0.1:9000",
+		}},
+		ueTunnelDevice: "ueTun0",
+		expectedError:  fmt.Errorf("duplicate unstructured socket: 127.0.0.1:9000"),
+	},
+	{
+		name:           "testDuplicateUeTunnelDevice",
+		pduSessions:    []model.PduSessionIE{testInternetPduSession, testImsPduSession},
+		ueTunnelDevice: "imsTun0",
+		expectedError:  fmt.Errorf("duplicate ue tunnel device: imsTun0"),
+	},
+}
+
+func TestValidatePduSessions(t *testing.T) {
+	for _, testCase := range testValidatePduSessionsCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := util.ValidatePduSessions(testCase.pduSessions, testCase.ueTunnelDevice)
+			assert.Equal(t, testCase.expectedError, err)
+		})
+	}
+}
+
 var testValidateNrdcCases = []struct {
 	name          string
 	nrdc          model.NrdcIE
@@ -561,6 +864,170 @@ func TestValidateNrdc(t *testing.T) {
 	}
 }
 
+var testValidateUeApiIeCases = []struct {
+	name          string
+	ueApiIe       model.UeApiIE
+	expectedError error
+}{
+	{
+		name: "testValidUeApiIe",
+		ueApiIe: model.UeApiIE{
+			Enable: true,
+			Ip:     "127.0.0.1",
+			Port:   40104,
+		},
+		expectedError: nil,
+	},
+	{
+		name:          "testDisabledUeApiIe",
+		ueApiIe:       model.UeApiIE{},
+		expectedError: nil,
+	},
+	{
+		name: "testInvalidPortUeApiIe",
+		ueApiIe: model.UeApiIE{
+			Enable: true,
+			Ip:     "127.0.0.1",
+			Port:   70000,
+		},
+		expectedError: fmt.Errorf("invalid port: invalid port range: 70000, range should be 1-65535"),
+	},
+}
+
+func TestValidateUeApiIe(t *testing.T) {
+	for _, testCase := range testValidateUeApiIeCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := util.ValidateUeApiIe(&testCase.ueApiIe)
+			assert.Equal(t, testCase.expectedError, err)
+		})
+	}
+}
+
+var testValidateNasRetryIeCases = []struct {
+	name          string
+	nasRetryIe    model.NasRetryIE
+	expectedError error
+}{
+	{
+		name:          "testDefaultNasRetry",
+		nasRetryIe:    model.NasRetryIE{},
+		expectedError: nil,
+	},
+	{
+		name: "testValidNasRetry",
+		nasRetryIe: model.NasRetryIE{
+			RegistrationAttempts: 3,
+			RegistrationInterval: 2 * time.Second,
+			Retransmissions:      1,
+			MaxBackoff:           time.Minute,
+			T3510:                5 * time.Second,
+			T3521:                5 * time.Second,
+			T3580:                5 * time.Second,
+		},
+		expectedError: nil,
+	},
+	{
+		name: "testInvalidRegistrationAttempts",
+		nasRetryIe: model.NasRetryIE{
+			RegistrationAttempts: -1,
+		},
+		expectedError: fmt.Errorf("invalid registrationAttempts: -1, registrationAttempts must not be negative"),
+	},
+	{
+		name: "testInvalidMaxBackoff",
+		nasRetryIe: model.NasRetryIE{
+			MaxBackoff: -1 * time.Second,
+		},
+		expectedError: fmt.Errorf("invalid maxBackoff: -1s, maxBackoff must not be negative"),
+	},
+	{
+		name: "testInvalidT3580",
+		nasRetryIe: model.NasRetryIE{
+			T3580: -1 * time.Second,
+		},
+		expectedError: fmt.Errorf("invalid t3580: -1s, t3580 must not be negative"),
+	},
+}
+
+func TestValidateNasRetryIe(t *testing.T) {
+	for _, testCase := range testValidateNasRetryIeCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := util.ValidateNasRetryIe(&testCase.nasRetryIe)
+			assert.Equal(t, testCase.expectedError, err)
+		})
+	}
+}
+
+var testValidateSuciCases = []struct {
+	name          string
+	suci          model.SuciIE
+	expectedError error
+}{
+	{
+		name:          "testValidNullScheme",
+		suci:          model.SuciIE{},
+		expectedError: nil,
+	},
+	{
+		name: "testValidProfileA",
+		suci: model.SuciIE{
+			RoutingIndicator:       "1234",
+			ProtectionScheme:       "profileA",
+			HomeNetworkPublicKeyId: 1,
+			HomeNetworkPublicKey:   "5a8d38864820197c3394b92613b20b91633cbd897119273bf8e4a6f4eec0a650",
+		},
+		expectedError: nil,
+	},
+	{
+		name: "testValidProfileB",
+		suci: model.SuciIE{
+			ProtectionScheme:       "profileB",
+			HomeNetworkPublicKeyId: 2,
+			HomeNetworkPublicKey:   "0272da71976234ce833a6907425867b82e074d44ef907dfb4b3e21c1c2256ebcd1",
+		},
+		expectedError: nil,
+	},
+	{
+		name: "testInvalidRoutingIndicator",
+		suci: model.SuciIE{
+			RoutingIndicator: "12345",
+		},
+		expectedError: fmt.Errorf("invalid routing indicator: 12345, routing indicator should be 1-4 digits"),
+	},
+	{
+		name: "testInvalidProtectionScheme",
+		suci: model.SuciIE{
+			ProtectionScheme: "profileC",
+		},
+		expectedError: fmt.Errorf("invalid protection scheme: profileC"),
+	},
+	{
+		name: "testInvalidHomeNetworkPublicKeyLength",
+		suci: model.SuciIE{
+			ProtectionScheme:     "profileA",
+			HomeNetworkPublicKey: "5a8d",
+		},
+		expectedError: fmt.Errorf("invalid home network public key: 5a8d, profileA key should be [32] bytes"),
+	},
+	{
+		name: "testInvalidHomeNetworkPublicKeyHex",
+		suci: model.SuciIE{
+			ProtectionScheme:     "profileB",
+			HomeNetworkPublicKey: "xyz",
+		},
+		expectedError: fmt.Errorf("invalid home network public key, invalid hex string: xyz"),
+	},
+}
+
+func TestValidateSuci(t *testing.T) {
+	for _, testCase := range testValidateSuciCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := util.ValidateSuci(&testCase.suci)
+			assert.Equal(t, testCase.expectedError, err)
+		})
+	}
+}
+
 var testValidateUeIeCases = []struct {
 	name          string
 	ueIe          model.UeIE
@@ -597,11 +1064,14 @@ var testValidateUeIeCases = []struct {
 				Nia2: true,
 				Nia3: false,
 			},
-			PduSession: model.PduSessionIE{
-				Dnn: "internet",
-				Snssai: model.SnssaiIE{
-					Sst: "1",
-					Sd:  "010203",
+			PduSessions: []model.PduSessionIE{
+				{
+					Id:  1,
+					Dnn: "internet",
+					Snssai: model.SnssaiIE{
+						Sst: "1",
+						Sd:  "010203",
+					},
 				},
 			},
 			UeTunnelDevice: "ueTun0",
@@ -640,11 +1110,14 @@ var testValidateUeIeCases = []struct {
 				Nia2: true,
 				Nia3: false,
 			},
-			PduSession: model.PduSessionIE{
-				Dnn: "internet",
-				Snssai: model.SnssaiIE{
-					Sst: "1",
-					Sd:  "010203",
+			PduSessions: []model.PduSessionIE{
+				{
+					Id:  1,
+					Dnn: "internet",
+					Snssai: model.SnssaiIE{
+						Sst: "1",
+						Sd:  "010203",
+					},
 				},
 			},
 			Nrdc: model.NrdcIE{
@@ -691,11 +1164,14 @@ var testValidateUeIeCases = []struct {
 				Nia2: true,
 				Nia3: false,
 			},
-			PduSession: model.PduSessionIE{
-				Dnn: "internet",
-				Snssai: model.SnssaiIE{
-					Sst: "1",
-					Sd:  "010203",
+			PduSessions: []model.PduSessionIE{
+				{
+					Id:  1,
+					Dnn: "internet",
+					Snssai: model.SnssaiIE{
+						Sst: "1",
+						Sd:  "010203",
+					},
 				},
 			},
 			Nrdc: model.NrdcIE{
@@ -721,6 +1197,114 @@ func TestValidateUeIe(t *testing.T) {
 	}
 }
 
+var testValidateMultiUeIeCases = []struct {
+	name          string
+	multiUeIe     model.MultiUeIE
+	msin          string
+	expectedError error
+}{
+	{
+		name:          "testDisabledMultiUeIe",
+		multiUeIe:     model.MultiUeIE{},
+		msin:          "0000000001",
+		expectedError: nil,
+	},
+	{
+		name: "testValidMultiUeIe",
+		multiUeIe: model.MultiUeIE{
+			Enable:        true,
+			StartMsin:     "0000000100",
+			Count:         100,
+			KeyDerivation: "increment",
+			AttachRate:    10,
+			Concurrency:   8,
+			DataPlane:     "userspace",
+			Traffic: model.MultiUeTrafficIE{
+				Target:   "10.60.0.254",
+				Interval: time.Second,
+			},
+		},
+		msin:          "0000000001",
+		expectedError: nil,
+	},
+	{
+		name: "testInvalidMultiUeCount",
+		multiUeIe: model.MultiUeIE{
+			Enable: true,
+		},
+		msin:          "0000000001",
+		expectedError: fmt.Errorf("invalid count: 0, count must be positive"),
+	},
+	{
+		name: "testMultiUeMsinOverflow",
+		multiUeIe: model.MultiUeIE{
+			Enable: true,
+			Count:  2,
+		},
+		msin:          "9999999999",
+		expectedError: fmt.Errorf("invalid count: 2, msin exceeds 10 digits from 9999999999"),
+	},
+	{
+		name: "testInvalidMultiUeKeyDerivation",
+		multiUeIe: model.MultiUeIE{
+			Enable:        true,
+			Count:         1,
+			KeyDerivation: "random",
+		},
+		msin:          "0000000001",
+		expectedError: fmt.Errorf("invalid key derivation: random, must be shared or increment"),
+	},
+	{
+		name: "testInvalidMultiUeDataPlane",
+		multiUeIe: model.MultiUeIE{
+			Enable:    true,
+			Count:     1,
+			DataPlane: "kernel",
+		},
+		msin:          "0000000001",
+		expectedError: fmt.Errorf("invalid data plane: kernel, must be tun or userspace"),
+	},
+	{
+		name: "testMultiUeTrafficWithTunDataPlane",
+		multiUeIe: model.MultiUeIE{
+			Enable:    true,
+			Count:     1,
+			DataPlane: "tun",
+			Traffic: model.MultiUeTrafficIE{
+				Target: "10.60.0.254",
+			},
+		},
+		msin:          "0000000001",
+		expectedError: fmt.Errorf("invalid traffic, traffic is only sent with the userspace data plane"),
+	},
+	{
+		name: "testInvalidMultiUeTrafficTarget",
+		multiUeIe: model.MultiUeIE{
+			Enable:    true,
+			Count:     1,
+			DataPlane: "userspace",
+			Traffic: model.MultiUeTrafficIE{
+				Target: "2001:db8::1",
+			},
+		},
+		msin:          "0000000001",
+		expectedError: fmt.Errorf("invalid traffic target: 2001:db8::1, must be an IPv4 address"),
+	},
+}
+
+func TestValidateMultiUeIe(t *testing.T) {
+	for _, tc := range testValidateMultiUeIeCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := util.ValidateMultiUeIe(&tc.multiUeIe, "208", "93", tc.msin)
+			if tc.expectedError != nil {
+				assert.EqualError(t, err, tc.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
 var testValidateTaiIeCases = []struct {
 	name          string
 	tai           model.TaiIE
@@ -754,10 +1338,10 @@ var testValidateTaiIeCases = []struct {
 			Tac: "000001",
 			BroadcastPlmnId: model.PlmnIdIE{
 				Mcc: "208",
-				Mnc: "930",
+				Mnc: "9301",
 			},
 		},
-		expectedError: fmt.Errorf("invalid broadcastPlmnId: invalid mnc: 930, mnc should be 2 digits"),
+		expectedError: fmt.Errorf("invalid broadcastPlmnId: invalid mnc: 9301, mnc should be 2 or 3 digits"),
 	},
 }
 
@@ -862,6 +1446,112 @@ func TestValidateApiIe(t *testing.T) {
 	}
 }
 
+var testValidateCellsIeCases = []struct {
+	name          string
+	cells         []model.CellIE
+	expectedError error
+}{
+	{
+		name: "testValidCellsIe",
+		cells: []model.CellIE{
+			{
+				NrCellId: "000000001",
+				Pci:      1,
+			},
+			{
+				NrCellId: "000000002",
+				Pci:      2,
+				Tac:      "000002",
+				Snssai: []model.SnssaiIE{
+					{Sst: "1", Sd: "010203"},
+					{Sst: "1", Sd: "112233"},
+				},
+				RanControlPlaneIp:   "10.0.2.1",
+				RanControlPlanePort: 31423,
+				RanDataPlaneIp:      "10.0.2.1",
+				RanDataPlanePort:    31424,
+			},
+		},
+		expectedError: nil,
+	},
+	{
+		name:          "testValidNoCells",
+		cells:         nil,
+		expectedError: nil,
+	},
+	{
+		name: "testInvalidNrCellId",
+		cells: []model.CellIE{
+			{NrCellId: "00000001", Pci: 1},
+		},
+		expectedError: fmt.Errorf("invalid cell 00000001: invalid nr cell id: 00000001, nr cell id should be 9 hex digits"),
+	},
+	{
+		name: "testReservedNrCellId",
+		cells: []model.CellIE{
+			{NrCellId: "000000000", Pci: 1},
+		},
+		expectedError: fmt.Errorf("invalid cell 000000000: invalid nr cell id: 000000000, nr cell id 0 is reserved"),
+	},
+	{
+		name: "testInvalidPci",
+		cells: []model.CellIE{
+			{NrCellId: "000000001", Pci: 1008},
+		},
+		expectedError: fmt.Errorf("invalid cell 000000001: invalid pci: 1008, range should be 0-1007"),
+	},
+	{
+		name: "testInvalidTac",
+		cells: []model.CellIE{
+			{NrCellId: "000000001", Pci: 1, Tac: "0001"},
+		},
+		expectedError: fmt.Errorf("invalid cell 000000001: invalid tac: 0001, tac should be 6 hex digits"),
+	},
+	{
+		name: "testInvalidSnssai",
+		cells: []model.CellIE{
+			{NrCellId: "000000001", Pci: 1, Snssai: []model.SnssaiIE{{Sst: "1", Sd: "01020g"}}},
+		},
+		expectedError: fmt.Errorf("invalid cell 000000001: invalid snssai: invalid sd, invalid hex string: 01020g"),
+	},
+	{
+		name: "testInvalidRanControlPlanePort",
+		cells: []model.CellIE{
+			{NrCellId: "000000001", Pci: 1, RanControlPlaneIp: "10.0.2.1"},
+		},
+		expectedError: fmt.Errorf("invalid cell 000000001: invalid ranControlPlanePort: invalid port range: 0, range should be 1-65535"),
+	},
+	{
+		name: "testDuplicateNrCellId",
+		cells: []model.CellIE{
+			{NrCellId: "00000000a", Pci: 1},
+			{NrCellId: "00000000A", Pci: 2},
+		},
+		expectedError: fmt.Errorf("duplicate nr cell id: 00000000A"),
+	},
+	{
+		name: "testDuplicatePci",
+		cells: []model.CellIE{
+			{NrCellId: "000000001", Pci: 1},
+			{NrCellId: "000000002", Pci: 1},
+		},
+		expectedError: fmt.Errorf("duplicate pci: 1"),
+	},
+}
+
+func TestValidateCellsIe(t *testing.T) {
+	for _, tc := range testValidateCellsIeCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := util.ValidateCellsIe(tc.cells)
+			if tc.expectedError != nil {
+				assert.EqualError(t, err, tc.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
 var testValidateXnInterfaceIeCases = []struct {
 	name          string
 	xn            model.XnInterfaceIE
@@ -944,6 +1634,173 @@ func TestValidateXnInterfaceIe(t *testing.T) {
 	}
 }
 
+var testValidateTeidAllocationCases = []struct {
+	name           string
+	teidAllocation string
+	expectedError  error
+}{
+	{
+		name:           "testDefaultTeidAllocation",
+		teidAllocation: "",
+		expectedError:  nil,
+	},
+	{
+		name:           "testSequentialTeidAllocation",
+		teidAllocation: "sequential",
+		expectedError:  nil,
+	},
+	{
+		name:           "testRandomTeidAllocation",
+		teidAllocation: "random",
+		expectedError:  nil,
+	},
+	{
+		name:           "testInvalidTeidAllocation",
+		teidAllocation: "shuffle",
+		expectedError:  fmt.Errorf("invalid teid allocation: shuffle, must be sequential or random"),
+	},
+}
+
+func TestValidateTeidAllocation(t *testing.T) {
+	for _, tc := range testValidateTeidAllocationCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := util.ValidateTeidAllocation(tc.teidAllocation)
+			if tc.expectedError != nil {
+				assert.EqualError(t, err, tc.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+var testValidateDlBufferIeCases = []struct {
+	name          string
+	dlBufferIe    model.DlBufferIE
+	expectedError error
+}{
+	{
+		name:          "testDefaultDlBufferIe",
+		dlBufferIe:    model.DlBufferIE{},
+		expectedError: nil,
+	},
+	{
+		name: "testValidDlBufferIe",
+		dlBufferIe: model.DlBufferIE{
+			Size:   128,
+			MaxAge: 500 * time.Millisecond,
+		},
+		expectedError: nil,
+	},
+	{
+		name: "testInvalidDlBufferSize",
+		dlBufferIe: model.DlBufferIE{
+			Size: -1,
+		},
+		expectedError: fmt.Errorf("invalid size: -1, size must not be negative"),
+	},
+	{
+		name: "testInvalidDlBufferMaxAge",
+		dlBufferIe: model.DlBufferIE{
+			MaxAge: -1 * time.Second,
+		},
+		expectedError: fmt.Errorf("invalid maxAge: -1s, maxAge must not be negative"),
+	},
+}
+
+func TestValidateDlBufferIe(t *testing.T) {
+	for _, tc := range testValidateDlBufferIeCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := util.ValidateDlBufferIe(&tc.dlBufferIe)
+			if tc.expectedError != nil {
+				assert.EqualError(t, err, tc.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+var testValidateRadioChannelIeCases = []struct {
+	name           string
+	radioChannelIe model.RadioChannelIE
+	expectedError  error
+}{
+	{
+		name:           "testDefaultRadioChannelIe",
+		radioChannelIe: model.RadioChannelIE{},
+		expectedError:  nil,
+	},
+	{
+		name: "testValidRadioChannelIe",
+		radioChannelIe: model.RadioChannelIE{
+			Cell: model.RadioChannelProfileIE{
+				Latency:   10 * time.Millisecond,
+				Jitter:    2 * time.Millisecond,
+				Loss:      0.01,
+				Reorder:   0.01,
+				Bandwidth: 100000,
+				Outages: []model.RadioChannelOutageIE{
+					{Start: 10 * time.Second, Duration: time.Second, Period: 30 * time.Second},
+				},
+			},
+			Ue: []model.UeRadioChannelIE{
+				{Imsi: "imsi-208930000000001", Profile: model.RadioChannelProfileIE{Loss: 0.1}},
+			},
+		},
+		expectedError: nil,
+	},
+	{
+		name: "testInvalidRadioChannelCellLoss",
+		radioChannelIe: model.RadioChannelIE{
+			Cell: model.RadioChannelProfileIE{Loss: 1.5},
+		},
+		expectedError: fmt.Errorf("invalid cell: invalid loss: 1.5, loss must be in [0, 1]"),
+	},
+	{
+		name: "testInvalidRadioChannelOutagePeriod",
+		radioChannelIe: model.RadioChannelIE{
+			Cell: model.RadioChannelProfileIE{
+				Outages: []model.RadioChannelOutageIE{
+					{Duration: 2 * time.Second, Period: time.Second},
+				},
+			},
+		},
+		expectedError: fmt.Errorf("invalid cell: invalid outage: period 1s shorter than duration 2s"),
+	},
+	{
+		name: "testInvalidRadioChannelUeImsi",
+		radioChannelIe: model.RadioChannelIE{
+			Ue: []model.UeRadioChannelIE{
+				{Imsi: "208930000000001"},
+			},
+		},
+		expectedError: fmt.Errorf("invalid ue imsi: 208930000000001, imsi must be in the form of imsi-<mcc><mnc><msin>"),
+	},
+	{
+		name: "testInvalidRadioChannelUeLatency",
+		radioChannelIe: model.RadioChannelIE{
+			Ue: []model.UeRadioChannelIE{
+				{Imsi: "imsi-208930000000001", Profile: model.RadioChannelProfileIE{Latency: -1 * time.Millisecond}},
+			},
+		},
+		expectedError: fmt.Errorf("invalid ue imsi-208930000000001: invalid latency: -1ms, latency must not be negative"),
+	},
+}
+
+func TestValidateRadioChannelIe(t *testing.T) {
+	for _, tc := range testValidateRadioChannelIeCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := util.ValidateRadioChannelIe(&tc.radioChannelIe)
+			if tc.expectedError != nil {
+				assert.EqualError(t, err, tc.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
 var testValidateGnbIeCases = []struct {
 	name          string
 	gnbIe         model.GnbIE
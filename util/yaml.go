@@ -1,7 +1,9 @@
 package util
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v2"
 )
@@ -17,6 +19,27 @@ func LoadFromYaml(filePath string, v interface{}) error {
 	return yamlFile.Close()
 }
 
+// SaveToYamlAtomically writes the yaml to a temporary file renamed to the file path,
+// so that a reader never sees a partially written file
+func SaveToYamlAtomically(filePath string, v interface{}) error {
+	yamlFile, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	err = yaml.NewEncoder(yamlFile).Encode(v)
+	if err == nil {
+		err = yamlFile.Sync()
+	}
+	if closeErr := yamlFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Join(err, os.Remove(yamlFile.Name()))
+	}
+	return os.Rename(yamlFile.Name(), filePath)
+}
+
 func SaveToYaml(filePath string, v interface{}) error {
 	yamlFile, err := os.Create(filePath)
 	if err != nil {
@@ -45,6 +45,32 @@ var testYamlCases = []struct {
 			B: 2,
 		},
 	},
+	{
+		name:       "test save yaml atomically",
+		actionType: "saveAtomically",
+		filePath:   "test.yaml",
+		testData: testStruct{
+			A: 3,
+			B: 4,
+		},
+		expectedData: testStruct{
+			A: 3,
+			B: 4,
+		},
+	},
+	{
+		name:       "test load yaml saved atomically",
+		actionType: "load",
+		filePath:   "test.yaml",
+		testData: testStruct{
+			A: 3,
+			B: 4,
+		},
+		expectedData: testStruct{
+			A: 3,
+			B: 4,
+		},
+	},
 }
 
 func TestYaml(t *testing.T) {
@@ -56,6 +82,11 @@ func TestYaml(t *testing.T) {
 				if err != nil {
 					t.Errorf("save yaml failed: %v", err)
 				}
+			case "saveAtomically":
+				err := util.SaveToYamlAtomically(testCase.filePath, testCase.testData)
+				if err != nil {
+					t.Errorf("save yaml atomically failed: %v", err)
+				}
 			case "load":
 				var data testStruct
 				err := util.LoadFromYaml(testCase.filePath, &data)